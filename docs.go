@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/docs"
+)
+
+// runDocs implements "gatekeeper docs": it loads the configuration the
+// server would run with and writes a description of it
+func runDocs(args []string) int {
+	fs := flag.NewFlagSet("docs", flag.ContinueOnError)
+	format := fs.String("format", "markdown", "output format: markdown or html")
+	output := fs.String("o", "", "write to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gatekeeper docs [-format markdown|html] [-o file]")
+		fmt.Fprintln(fs.Output(), "Reads the config from GATEKEEPER_CONFIG (default config.yaml).")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var render func(io.Writer, docs.Document) error
+	switch *format {
+	case "markdown", "md":
+		render = docs.Markdown
+	case "html":
+		render = docs.HTML
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", *output, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := render(w, docs.Describe(cfg)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write documentation: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// parseFlags handles the flags of "gatekeeper" without a subcommand. It
+// exits after --version or a bad flag.
+func parseFlags(args []string) {
+	fs := flag.NewFlagSet("gatekeeper", flag.ExitOnError)
+	configFile := fs.String("config", "", "config file, read as JSON or TOML for .json and .toml (default GATEKEEPER_CONFIG or config.yaml)")
+	address := fs.String("address", "", "listen address, overriding server.address")
+	logLevel := fs.String("log-level", "", "debug, info, warn or error, overriding logLevel")
+	showVersion := fs.Bool("version", false, "print the version and exit")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gatekeeper [--config file] [--address addr] [--log-level level] [--version]")
+		fmt.Fprintln(fs.Output(), "       gatekeeper docs|validate|test [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *showVersion {
+		fmt.Println("gatekeeper", version)
+		os.Exit(0)
+	}
+	if *configFile != "" {
+		// Reloads read the file through GATEKEEPER_CONFIG too
+		os.Setenv("GATEKEEPER_CONFIG", *configFile)
+	}
+	config.SetOverrides(config.Overrides{Address: *address, LogLevel: *logLevel})
+}
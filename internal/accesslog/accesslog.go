@@ -0,0 +1,351 @@
+// Package accesslog writes one line per request, as JSON, Apache combined
+// or W3C extended, to its own output apart from the application log.
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/redact"
+)
+
+// defaultFields are the JSON fields logged when none are configured
+var defaultFields = []string{"time", "method", "path", "status", "duration", "remote_ip", "user_agent", "class", "incident"}
+
+// w3cFields is the #Fields directive of the W3C format
+const w3cFields = "date time c-ip cs-method cs-uri-stem cs-uri-query sc-status sc-bytes time-taken cs(User-Agent) cs(Referer)"
+
+// Entry is one request as the access log sees it. The logging middleware
+// fills it in; Route is set by the route that served the request and
+// Consumer by the authentication that verified it.
+type Entry struct {
+	Time      time.Time
+	Method    string
+	Path      string
+	Query     string
+	Protocol  string
+	Host      string
+	Status    int
+	Bytes     int64
+	Duration  time.Duration
+	RemoteIP  string
+	UserAgent string
+	Referer   string
+	Route     string
+	Consumer  string
+	RequestID string
+	Class     string
+	Incident  string
+	Header    http.Header
+}
+
+type entryKey struct{}
+
+// NewContext carries e to the handlers below, so they can add to it
+func NewContext(ctx context.Context, e *Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, e)
+}
+
+// SetRoute records the route serving the request being logged, if any
+func SetRoute(ctx context.Context, route string) {
+	if e, ok := ctx.Value(entryKey{}).(*Entry); ok {
+		e.Route = route
+	}
+}
+
+// SetConsumer records the principal authentication verified for the
+// request being logged, if any
+func SetConsumer(ctx context.Context, consumer string) {
+	if e, ok := ctx.Value(entryKey{}).(*Entry); ok {
+		e.Consumer = consumer
+	}
+}
+
+// Logger writes entries in one format to one output
+type Logger struct {
+	disabled bool
+	format   string
+	fields   []string
+	headers  []string
+	sample   float64
+	redact   *redact.Redactor
+	routes   map[string]config.RouteAccessLogConfig
+	exports  []func(*Entry)
+
+	mu     sync.Mutex
+	out    io.Writer
+	file   *rotatingFile
+	random *rand.Rand
+}
+
+// New opens the output of cfg. A file output is appended to, created if
+// missing and rotated as cfg.Rotation says. Query strings, referers and
+// headers are masked by redactor, or by the default redaction when nil.
+func New(cfg config.AccessLogConfig, redactor *redact.Redactor) (*Logger, error) {
+	switch cfg.Output {
+	case "", "stdout":
+		return NewWriter(cfg, redactor, os.Stdout), nil
+	case "stderr":
+		return NewWriter(cfg, redactor, os.Stderr), nil
+	}
+	// Every file the W3C format starts gets its directives
+	var header func() []byte
+	if cfg.Format == "w3c" && !cfg.Disabled {
+		header = w3cHeader
+	}
+	f, err := openRotating(cfg.Output, cfg.Rotation, header)
+	if err != nil {
+		return nil, err
+	}
+	l := newLogger(cfg, redactor, f)
+	l.file = f
+	return l, nil
+}
+
+// NewWriter logs to w instead of cfg.Output
+func NewWriter(cfg config.AccessLogConfig, redactor *redact.Redactor, w io.Writer) *Logger {
+	l := newLogger(cfg, redactor, w)
+	if l.format == "w3c" && !l.disabled {
+		w.Write(w3cHeader())
+	}
+	return l
+}
+
+func newLogger(cfg config.AccessLogConfig, redactor *redact.Redactor, w io.Writer) *Logger {
+	if redactor == nil {
+		redactor = redact.New(config.RedactionConfig{})
+	}
+	l := &Logger{
+		disabled: cfg.Disabled,
+		format:   cfg.Format,
+		fields:   cfg.Fields,
+		headers:  cfg.Headers,
+		redact:   redactor,
+		sample:   cfg.SampleRate,
+		routes:   make(map[string]config.RouteAccessLogConfig),
+		out:      w,
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if l.format == "" {
+		l.format = "json"
+	}
+	if len(l.fields) == 0 {
+		l.fields = defaultFields
+	}
+	if l.sample == 0 {
+		l.sample = 1
+	}
+	return l
+}
+
+// w3cHeader holds the directives that start a W3C log
+func w3cHeader() []byte {
+	return []byte(fmt.Sprintf("#Version: 1.0\n#Date: %s\n#Fields: %s\n", time.Now().UTC().Format("2006-01-02 15:04:05"), w3cFields))
+}
+
+// Route overrides the access log for a route. Call it while setting up
+// routes, before requests are served.
+func (l *Logger) Route(route string, cfg config.RouteAccessLogConfig) {
+	l.routes[route] = cfg
+}
+
+// Export hands every entry to fn, whether it is logged or not. Call it
+// while setting up, before requests are served. fn must not block.
+func (l *Logger) Export(fn func(*Entry)) {
+	l.exports = append(l.exports, fn)
+}
+
+// Log writes e unless its route is not logged or sampling skips it.
+// Server errors are never sampled out.
+func (l *Logger) Log(e *Entry) {
+	for _, export := range l.exports {
+		export(e)
+	}
+	if l.disabled {
+		return
+	}
+	rate := l.sample
+	if override, ok := l.routes[e.Route]; ok {
+		if override.Disabled {
+			return
+		}
+		if override.SampleRate > 0 {
+			rate = override.SampleRate
+		}
+	}
+
+	if rate < 1 && e.Status < 500 {
+		l.mu.Lock()
+		skip := l.random.Float64() >= rate
+		l.mu.Unlock()
+		if skip {
+			return
+		}
+	}
+
+	// Credentials never reach the output
+	masked := *e
+	masked.Query = l.redact.Query(e.Query)
+	masked.Referer = l.redact.Text(e.Referer)
+
+	var line []byte
+	switch l.format {
+	case "combined":
+		line = combined(&masked)
+	case "w3c":
+		line = w3c(&masked)
+	default:
+		line = l.json(&masked)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line)
+}
+
+// Reopen reopens a file output, so a file moved away by logrotate or the
+// like is written to again under its name
+func (l *Logger) Reopen() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Reopen()
+}
+
+// Close closes a file output
+func (l *Logger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+func (l *Logger) json(e *Entry) []byte {
+	line := make(map[string]interface{}, len(l.fields))
+	for _, field := range l.fields {
+		var value interface{}
+		switch field {
+		case "time":
+			value = e.Time.Format("2006-01-02T15:04:05.000Z07:00")
+		case "method":
+			value = e.Method
+		case "path":
+			value = e.Path
+		case "query":
+			value = e.Query
+		case "protocol":
+			value = e.Protocol
+		case "host":
+			value = e.Host
+		case "status":
+			value = e.Status
+		case "bytes":
+			value = e.Bytes
+		case "duration":
+			value = e.Duration.String()
+		case "duration_ms":
+			value = float64(e.Duration) / float64(time.Millisecond)
+		case "remote_ip":
+			value = e.RemoteIP
+		case "user_agent":
+			value = e.UserAgent
+		case "referer":
+			value = e.Referer
+		case "route":
+			value = e.Route
+		case "consumer":
+			value = e.Consumer
+		case "request_id":
+			value = e.RequestID
+		case "class":
+			value = e.Class
+		case "incident":
+			value = e.Incident
+		}
+		if value == "" {
+			continue
+		}
+		line[field] = value
+	}
+	if len(l.headers) > 0 {
+		headers := make(map[string]string, len(l.headers))
+		for _, name := range l.headers {
+			if value := e.Header.Get(name); value != "" {
+				headers[name] = l.redact.Header(name, value)
+			}
+		}
+		line["headers"] = headers
+	}
+	// Query strings and URLs keep their & and < as they are
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(line)
+	return b.Bytes()
+}
+
+// combined is the Apache combined log format
+func combined(e *Entry) []byte {
+	request := e.Path
+	if e.Query != "" {
+		request += "?" + e.Query
+	}
+	return []byte(fmt.Sprintf("%s - - [%s] %s %d %s %s %s\n",
+		e.RemoteIP,
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(e.Method+" "+request+" "+e.Protocol),
+		e.Status,
+		dash(bytesField(e.Bytes)),
+		strconv.Quote(dash(e.Referer)),
+		strconv.Quote(dash(e.UserAgent)),
+	))
+}
+
+// w3c is a line of the W3C extended format, in UTC as the format asks
+func w3c(e *Entry) []byte {
+	t := e.Time.UTC()
+	values := []string{
+		t.Format("2006-01-02"),
+		t.Format("15:04:05"),
+		e.RemoteIP,
+		e.Method,
+		e.Path,
+		e.Query,
+		strconv.Itoa(e.Status),
+		strconv.FormatInt(e.Bytes, 10),
+		strconv.FormatFloat(e.Duration.Seconds(), 'f', 3, 64),
+		e.UserAgent,
+		e.Referer,
+	}
+	for i, value := range values {
+		values[i] = dash(strings.ReplaceAll(value, " ", "+"))
+	}
+	return []byte(strings.Join(values, " ") + "\n")
+}
+
+// bytesField leaves out a response without a body, as Apache does
+func bytesField(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// dash stands in for an empty value
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,129 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func testEntry() *Entry {
+	return &Entry{
+		Time:      time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC),
+		Method:    "GET",
+		Path:      "/orders/42",
+		Query:     "expand=items",
+		Protocol:  "HTTP/1.1",
+		Status:    200,
+		Bytes:     512,
+		Duration:  1500 * time.Millisecond,
+		RemoteIP:  "203.0.113.9",
+		UserAgent: "curl/8.0 (x86_64)",
+		Route:     "orders",
+	}
+}
+
+func TestFormats(t *testing.T) {
+	testCases := []struct {
+		name string
+		cfg  config.AccessLogConfig
+		want string
+	}{
+		{"combined", config.AccessLogConfig{Format: "combined"},
+			`203.0.113.9 - - [01/Mar/2024:09:30:00 +0000] "GET /orders/42?expand=items HTTP/1.1" 200 512 "-" "curl/8.0 (x86_64)"` + "\n"},
+		{"w3c", config.AccessLogConfig{Format: "w3c"},
+			"2024-03-01 09:30:00 203.0.113.9 GET /orders/42 expand=items 200 512 1.500 curl/8.0+(x86_64) -\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			l := NewWriter(tc.cfg, nil, &out)
+			out.Reset()
+			l.Log(testEntry())
+			if got := out.String(); got != tc.want {
+				t.Errorf("Expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestW3CHeader(t *testing.T) {
+	var out bytes.Buffer
+	NewWriter(config.AccessLogConfig{Format: "w3c"}, nil, &out)
+	if !strings.HasPrefix(out.String(), "#Version: 1.0\n") || !strings.Contains(out.String(), "#Fields: date time c-ip") {
+		t.Errorf("Expected the W3C directives first, got %q", out.String())
+	}
+}
+
+func TestJSONFields(t *testing.T) {
+	var out bytes.Buffer
+	l := NewWriter(config.AccessLogConfig{Fields: []string{"route", "status", "duration_ms", "referer"}}, nil, &out)
+	l.Log(testEntry())
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if len(line) != 3 || line["route"] != "orders" || line["status"] != float64(200) || line["duration_ms"] != float64(1500) {
+		t.Errorf("Expected only the chosen fields, without the empty referer, got %v", line)
+	}
+}
+
+func TestRoutesAndSampling(t *testing.T) {
+	var out bytes.Buffer
+	l := NewWriter(config.AccessLogConfig{Fields: []string{"route"}}, nil, &out)
+	l.Route("health", config.RouteAccessLogConfig{Disabled: true})
+	l.Route("search", config.RouteAccessLogConfig{SampleRate: 0.1})
+
+	for i := 0; i < 1000; i++ {
+		for _, route := range []string{"orders", "health", "search"} {
+			e := testEntry()
+			e.Route = route
+			l.Log(e)
+		}
+	}
+	failed := testEntry()
+	failed.Route, failed.Status = "search", 503
+	l.Log(failed)
+
+	counts := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		counts[line]++
+	}
+	if n := counts[`{"route":"orders"}`]; n != 1000 {
+		t.Errorf("Expected every orders request, got %d", n)
+	}
+	if n := counts[`{"route":"health"}`]; n != 0 {
+		t.Errorf("Expected no health requests, got %d", n)
+	}
+	// 10% of 1000, plus the server error that is never sampled out
+	if n := counts[`{"route":"search"}`]; n < 50 || n > 160 {
+		t.Errorf("Expected about 100 sampled search requests, got %d", n)
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	var out bytes.Buffer
+	l := NewWriter(config.AccessLogConfig{Fields: []string{"query", "referer"}, Headers: []string{"Authorization", "X-Tenant"}}, nil, &out)
+
+	e := testEntry()
+	e.Query = "expand=items&access_token=abc"
+	e.Referer = "https://app.example.com/cb?code=1&api_key=xyz"
+	e.Header = http.Header{"Authorization": {"Bearer abc"}, "X-Tenant": {"acme"}}
+	l.Log(e)
+
+	want := `{"headers":{"Authorization":"[REDACTED]","X-Tenant":"acme"},` +
+		`"query":"expand=items&access_token=[REDACTED]",` +
+		`"referer":"https://app.example.com/cb?code=1&api_key=[REDACTED]"}` + "\n"
+	if got := out.String(); got != want {
+		t.Errorf("Expected credentials masked, got %s", got)
+	}
+	if e.Query != "expand=items&access_token=abc" {
+		t.Errorf("Expected the entry itself untouched, got %q", e.Query)
+	}
+}
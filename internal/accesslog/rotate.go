@@ -0,0 +1,223 @@
+package accesslog
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+)
+
+// rotatedTime stamps rotated files; it sorts in time order
+const rotatedTime = "2006-01-02T15-04-05.000"
+
+// rotatingFile appends to a file, moving it aside and starting a new one
+// when it grows past maxSize or its interval ends
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+	compress   bool
+	header     func() []byte
+	now        func() time.Time
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	next time.Time
+
+	// Rotated files are compressed and pruned one rotation at a time
+	cleanup sync.Mutex
+	wg      sync.WaitGroup
+}
+
+// openRotating opens path. header, when set, is written at the start of
+// every file opened.
+func openRotating(path string, cfg config.AccessLogRotationConfig, header func() []byte) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:       path,
+		maxSize:    int64(cfg.MaxSizeMB) * 1024 * 1024,
+		maxBackups: cfg.MaxBackups,
+		compress:   cfg.Compress,
+		header:     header,
+		now:        time.Now,
+	}
+	switch cfg.Interval {
+	case "hourly":
+		r.interval = time.Hour
+	case "daily":
+		r.interval = 24 * time.Hour
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the file at path, keeping what it holds
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open access log %s: %w", r.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open access log %s: %w", r.path, err)
+	}
+	r.f = f
+	r.size = info.Size()
+	if r.header != nil {
+		n, _ := f.Write(r.header())
+		r.size += int64(n)
+	}
+	if r.interval > 0 {
+		r.next = r.now().Truncate(r.interval).Add(r.interval)
+	}
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	full := r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize
+	due := r.interval > 0 && !r.now().Before(r.next)
+	if full || due {
+		if err := r.rotate(); err != nil {
+			logger.Error("Failed to rotate access log %s: %v", r.path, err)
+			if r.f == nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside and opens a new one. The moved file is
+// compressed and old ones pruned in the background.
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+	rotated := r.path + "." + r.now().Format(rotatedTime)
+	renameErr := os.Rename(r.path, rotated)
+	if err := r.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.cleanup.Lock()
+		defer r.cleanup.Unlock()
+		// A later rotation may have pruned the file already
+		if r.compress {
+			if err := compress(rotated); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				logger.Error("Failed to compress rotated access log %s: %v", rotated, err)
+			}
+		}
+		r.prune()
+	}()
+	return nil
+}
+
+// Reopen closes and reopens the file, for when something else, such as
+// logrotate, moved it
+func (r *rotatingFile) Reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	var err error
+	if r.f != nil {
+		err = r.f.Close()
+		r.f = nil
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	return err
+}
+
+// prune removes the oldest rotated files beyond maxBackups
+func (r *rotatingFile) prune() {
+	if r.maxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(r.path + ".[0-9]*")
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, match := range matches {
+		// Files still being compressed are left alone
+		if !strings.HasSuffix(match, ".tmp") {
+			backups = append(backups, match)
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > r.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			logger.Warn("Failed to remove old access log %s: %v", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// compress gzips path into path.gz and removes path
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,116 @@
+package accesslog
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := openRotating(path, config.AccessLogRotationConfig{MaxSizeMB: 1, MaxBackups: 2, Compress: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.maxSize = 100 // bytes, to keep the test small
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { now = now.Add(time.Second); return now }
+
+	line := strings.Repeat("x", 39) + "\n"
+	for i := 0; i < 10; i++ {
+		f.Write([]byte(line))
+	}
+	f.Close()
+
+	// 10 lines of 40 bytes, two to a file: four rotations, two kept
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 rotated files, got %v", backups)
+	}
+	for _, backup := range backups {
+		if !strings.HasSuffix(backup, ".gz") {
+			t.Errorf("Expected %s to be compressed", backup)
+			continue
+		}
+		if got := gunzip(t, backup); got != line+line {
+			t.Errorf("Expected two lines in %s, got %q", backup, got)
+		}
+	}
+	if got, _ := os.ReadFile(path); string(got) != line+line {
+		t.Errorf("Expected the last two lines in the current file, got %q", got)
+	}
+}
+
+func TestRotateByInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	now := time.Date(2024, 3, 1, 9, 59, 0, 0, time.UTC)
+	f, err := openRotating(path, config.AccessLogRotationConfig{Interval: "hourly"}, func() []byte { return []byte("#header\n") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.now = func() time.Time { return now }
+	f.next = now.Truncate(time.Hour).Add(time.Hour)
+
+	f.Write([]byte("before\n"))
+	now = now.Add(2 * time.Minute)
+	f.Write([]byte("after\n"))
+	f.Close()
+
+	rotated, err := os.ReadFile(path + ".2024-03-01T10-01-00.000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rotated) != "#header\nbefore\n" {
+		t.Errorf("Expected the first hour in the rotated file, got %q", rotated)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "#header\nafter\n" {
+		t.Errorf("Expected a new file with its header for the next hour, got %q", got)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	l, err := New(config.AccessLogConfig{Fields: []string{"path"}, Output: path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Log(&Entry{Path: "/one"})
+	// logrotate moves the file, then signals
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	l.Log(&Entry{Path: "/two"})
+	if err := l.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	l.Log(&Entry{Path: "/three"})
+
+	moved, _ := os.ReadFile(path + ".1")
+	current, _ := os.ReadFile(path)
+	if string(moved) != "{\"path\":\"/one\"}\n{\"path\":\"/two\"}\n" || string(current) != "{\"path\":\"/three\"}\n" {
+		t.Errorf("Expected writes to follow the reopen, got %q and %q", moved, current)
+	}
+}
+
+func gunzip(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(zr)
+	return string(b)
+}
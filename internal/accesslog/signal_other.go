@@ -0,0 +1,8 @@
+//go:build !unix
+
+package accesslog
+
+import "os"
+
+// ReopenSignal is nil where there is no SIGUSR1
+var ReopenSignal os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package accesslog
+
+import (
+	"os"
+	"syscall"
+)
+
+// ReopenSignal asks a running GateKeeper to reopen its access log file
+var ReopenSignal os.Signal = syscall.SIGUSR1
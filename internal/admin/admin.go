@@ -0,0 +1,61 @@
+// Package admin serves the operator API on its own listener. Features
+// register their endpoints under /admin at startup.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type Server struct {
+	token  string
+	router *mux.Router
+}
+
+// New creates an admin API. When token is set, every request must carry
+// "Authorization: Bearer <token>".
+func New(token string) *Server {
+	return &Server{
+		token:  token,
+		router: mux.NewRouter().PathPrefix("/admin").Subrouter(),
+	}
+}
+
+// HandleFunc registers an endpoint; path is relative to /admin
+func (s *Server) HandleFunc(path string, handler http.HandlerFunc, methods ...string) {
+	route := s.router.HandleFunc(path, handler)
+	if len(methods) > 0 {
+		route.Methods(methods...)
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "Bearer ") ||
+				subtle.ConstantTimeCompare([]byte(auth[7:]), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="gatekeeper-admin"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		s.router.ServeHTTP(w, r)
+	})
+}
+
+// WriteJSON writes v as a JSON response
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ReadJSON decodes a request body of at most 1MB into v
+func ReadJSON(r *http.Request, v interface{}) error {
+	return json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20)).Decode(v)
+}
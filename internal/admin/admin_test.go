@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminRequiresToken(t *testing.T) {
+	s := New("s3cret")
+	s.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}, "GET")
+	handler := s.Handler()
+
+	testCases := []struct {
+		name           string
+		authorization  string
+		path           string
+		expectedStatus int
+	}{
+		{"no token", "", "/admin/ping", http.StatusUnauthorized},
+		{"wrong token", "Bearer nope", "/admin/ping", http.StatusUnauthorized},
+		{"valid token", "Bearer s3cret", "/admin/ping", http.StatusOK},
+		{"unknown endpoint", "Bearer s3cret", "/admin/missing", http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status %v, got %v", tc.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
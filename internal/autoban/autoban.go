@@ -0,0 +1,149 @@
+// Package autoban keeps per-client offence counters and temporary bans.
+// The memory store suits a single gateway; the Redis store shares bans
+// across replicas.
+package autoban
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ban is an active ban on a client IP
+type Ban struct {
+	IP    string    `json:"ip"`
+	Until time.Time `json:"until"`
+}
+
+// Store counts offences and records bans
+type Store interface {
+	// Hit records an offence and returns the count within the current window
+	Hit(ctx context.Context, ip string, window time.Duration) (int64, error)
+	// Ban bans ip for duration and resets its offence count
+	Ban(ctx context.Context, ip string, duration time.Duration) (Ban, error)
+	// Banned returns the ban on ip, if any
+	Banned(ctx context.Context, ip string) (Ban, bool, error)
+	// List returns active bans, soonest to expire first
+	List(ctx context.Context) ([]Ban, error)
+	// Unban lifts a ban and clears the offence count. It reports whether
+	// a ban was lifted.
+	Unban(ctx context.Context, ip string) (bool, error)
+}
+
+// MemoryStore keeps counters and bans in process
+type MemoryStore struct {
+	now func() time.Time
+
+	mu       sync.Mutex
+	counters map[string]*counter
+	bans     map[string]time.Time
+}
+
+type counter struct {
+	count   int64
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		now:      time.Now,
+		counters: make(map[string]*counter),
+		bans:     make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryStore) Hit(_ context.Context, ip string, window time.Duration) (int64, error) {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[ip]
+	if !ok || !now.Before(c.expires) {
+		c = &counter{expires: now.Add(window)}
+		s.counters[ip] = c
+	}
+	c.count++
+	return c.count, nil
+}
+
+func (s *MemoryStore) Ban(_ context.Context, ip string, duration time.Duration) (Ban, error) {
+	until := s.now().Add(duration)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bans[ip] = until
+	delete(s.counters, ip)
+	return Ban{IP: ip, Until: until}, nil
+}
+
+func (s *MemoryStore) Banned(_ context.Context, ip string) (Ban, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.bans[ip]
+	if !ok {
+		return Ban{}, false, nil
+	}
+	if !s.now().Before(until) {
+		delete(s.bans, ip)
+		return Ban{}, false, nil
+	}
+	return Ban{IP: ip, Until: until}, true, nil
+}
+
+func (s *MemoryStore) List(_ context.Context) ([]Ban, error) {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bans := make([]Ban, 0, len(s.bans))
+	for ip, until := range s.bans {
+		if now.Before(until) {
+			bans = append(bans, Ban{IP: ip, Until: until})
+		}
+	}
+	sortBans(bans)
+	return bans, nil
+}
+
+func (s *MemoryStore) Unban(_ context.Context, ip string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.bans[ip]
+	delete(s.bans, ip)
+	delete(s.counters, ip)
+	return ok, nil
+}
+
+// Sweep drops expired counters and bans
+func (s *MemoryStore) Sweep() {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ip, c := range s.counters {
+		if !now.Before(c.expires) {
+			delete(s.counters, ip)
+		}
+	}
+	for ip, until := range s.bans {
+		if !now.Before(until) {
+			delete(s.bans, ip)
+		}
+	}
+}
+
+func sortBans(bans []Ban) {
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].Until.Equal(bans[j].Until) {
+			return bans[i].IP < bans[j].IP
+		}
+		return bans[i].Until.Before(bans[j].Until)
+	})
+}
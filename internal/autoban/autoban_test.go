@@ -0,0 +1,88 @@
+package autoban
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/barisgenc/gatekeeper/internal/kv"
+)
+
+func testStore(t *testing.T, store Store, advance func(time.Duration)) {
+	ctx := context.Background()
+
+	for i := int64(1); i <= 3; i++ {
+		count, err := store.Hit(ctx, "10.0.0.1", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != i {
+			t.Fatalf("expected count %d, got %d", i, count)
+		}
+	}
+
+	advance(time.Minute)
+	if count, _ := store.Hit(ctx, "10.0.0.1", time.Minute); count != 1 {
+		t.Errorf("expected the count to restart after the window, got %d", count)
+	}
+
+	if _, banned, _ := store.Banned(ctx, "10.0.0.1"); banned {
+		t.Fatal("client should not start banned")
+	}
+	if _, err := store.Ban(ctx, "10.0.0.1", 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	store.Ban(ctx, "10.0.0.2", 5*time.Minute)
+
+	ban, banned, err := store.Banned(ctx, "10.0.0.1")
+	if err != nil || !banned || ban.IP != "10.0.0.1" {
+		t.Fatalf("expected 10.0.0.1 to be banned, got %v %v %v", ban, banned, err)
+	}
+
+	bans, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans) != 2 || bans[0].IP != "10.0.0.2" {
+		t.Fatalf("expected two bans, soonest first, got %v", bans)
+	}
+
+	if lifted, _ := store.Unban(ctx, "10.0.0.2"); !lifted {
+		t.Error("expected 10.0.0.2 to be unbanned")
+	}
+	if lifted, _ := store.Unban(ctx, "10.0.0.2"); lifted {
+		t.Error("unbanning twice should report nothing lifted")
+	}
+
+	advance(10 * time.Minute)
+	if _, banned, _ := store.Banned(ctx, "10.0.0.1"); banned {
+		t.Error("ban should expire")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+
+	testStore(t, store, func(d time.Duration) { now = now.Add(d) })
+}
+
+func TestRedisStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	testStore(t, NewRedisStore(client, ""), server.FastForward)
+}
+
+func TestKVStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	testStore(t, NewKVStore(kv.NewRedisStore(client, "")), server.FastForward)
+}
@@ -0,0 +1,71 @@
+package autoban
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/kv"
+)
+
+// KVStore keeps counters and bans in the gateway's shared storage under
+// "autoban:hits:<ip>" and "autoban:ban:<ip>"
+type KVStore struct {
+	store kv.Store
+}
+
+func NewKVStore(store kv.Store) *KVStore {
+	return &KVStore{store: store}
+}
+
+const (
+	kvHits = "autoban:hits:"
+	kvBan  = "autoban:ban:"
+)
+
+func (s *KVStore) Hit(ctx context.Context, ip string, window time.Duration) (int64, error) {
+	// The window runs from the first offence
+	return s.store.Incr(ctx, kvHits+ip, window)
+}
+
+func (s *KVStore) Ban(ctx context.Context, ip string, duration time.Duration) (Ban, error) {
+	until := time.Now().Add(duration)
+	if err := s.store.Set(ctx, kvBan+ip, []byte(strconv.FormatInt(until.UnixMilli(), 10)), duration); err != nil {
+		return Ban{}, err
+	}
+	if _, err := s.store.Delete(ctx, kvHits+ip); err != nil {
+		return Ban{}, err
+	}
+	return Ban{IP: ip, Until: until}, nil
+}
+
+func (s *KVStore) Banned(ctx context.Context, ip string) (Ban, bool, error) {
+	value, ok, err := s.store.Get(ctx, kvBan+ip)
+	if err != nil || !ok {
+		return Ban{}, false, err
+	}
+	return Ban{IP: ip, Until: parseUntil(string(value))}, true, nil
+}
+
+func (s *KVStore) List(ctx context.Context) ([]Ban, error) {
+	entries, err := s.store.Scan(ctx, kvBan)
+	if err != nil {
+		return nil, err
+	}
+	bans := make([]Ban, 0, len(entries))
+	for key, value := range entries {
+		bans = append(bans, Ban{IP: strings.TrimPrefix(key, kvBan), Until: parseUntil(string(value))})
+	}
+	sortBans(bans)
+	return bans, nil
+}
+
+func (s *KVStore) Unban(ctx context.Context, ip string) (bool, error) {
+	lifted, err := s.store.Delete(ctx, kvBan+ip)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.store.Delete(ctx, kvHits+ip)
+	return lifted, err
+}
@@ -0,0 +1,105 @@
+package autoban
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore shares counters and bans between gateway replicas. Keys are
+// "<prefix>hits:<ip>" and "<prefix>ban:<ip>" and expire on their own.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = "gatekeeper:autoban:"
+	}
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+// Close closes the Redis client
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *RedisStore) hitsKey(ip string) string { return s.prefix + "hits:" + ip }
+func (s *RedisStore) banKey(ip string) string  { return s.prefix + "ban:" + ip }
+
+func (s *RedisStore) Hit(ctx context.Context, ip string, window time.Duration) (int64, error) {
+	key := s.hitsKey(ip)
+	count, err := s.client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// The window runs from the first offence
+	if count == 1 {
+		if err := s.client.Expire(ctx, key, window).Err(); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+func (s *RedisStore) Ban(ctx context.Context, ip string, duration time.Duration) (Ban, error) {
+	until := time.Now().Add(duration)
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, s.banKey(ip), strconv.FormatInt(until.UnixMilli(), 10), duration)
+	pipe.Del(ctx, s.hitsKey(ip))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Ban{}, err
+	}
+	return Ban{IP: ip, Until: until}, nil
+}
+
+func (s *RedisStore) Banned(ctx context.Context, ip string) (Ban, bool, error) {
+	value, err := s.client.Get(ctx, s.banKey(ip)).Result()
+	if err == redis.Nil {
+		return Ban{}, false, nil
+	}
+	if err != nil {
+		return Ban{}, false, err
+	}
+	return Ban{IP: ip, Until: parseUntil(value)}, true, nil
+}
+
+func (s *RedisStore) List(ctx context.Context) ([]Ban, error) {
+	var bans []Ban
+	iter := s.client.Scan(ctx, 0, s.prefix+"ban:*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		value, err := s.client.Get(ctx, key).Result()
+		if err == redis.Nil {
+			continue // expired since the scan
+		}
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, Ban{IP: strings.TrimPrefix(key, s.prefix+"ban:"), Until: parseUntil(value)})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sortBans(bans)
+	return bans, nil
+}
+
+func (s *RedisStore) Unban(ctx context.Context, ip string) (bool, error) {
+	pipe := s.client.TxPipeline()
+	del := pipe.Del(ctx, s.banKey(ip))
+	pipe.Del(ctx, s.hitsKey(ip))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return del.Val() > 0, nil
+}
+
+func parseUntil(value string) time.Time {
+	ms, _ := strconv.ParseInt(value, 10, 64)
+	return time.UnixMilli(ms)
+}
@@ -0,0 +1,89 @@
+// Package bulkhead limits how many requests run at once, queueing a bounded
+// number of the rest for a bounded time.
+package bulkhead
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrQueueFull means the limit was reached and the queue was full
+	ErrQueueFull = errors.New("bulkhead queue full")
+	// ErrQueueTimeout means no slot freed up within the queue timeout
+	ErrQueueTimeout = errors.New("bulkhead queue timeout")
+)
+
+type Limiter struct {
+	Name string
+
+	slots      chan struct{}
+	queueDepth int64
+	timeout    time.Duration
+	waiting    atomic.Int64
+}
+
+// New allows maxConcurrent requests at once, with up to queueDepth more
+// waiting up to timeout for a slot
+func New(name string, maxConcurrent, queueDepth int, timeout time.Duration) *Limiter {
+	return &Limiter{
+		Name:       name,
+		slots:      make(chan struct{}, maxConcurrent),
+		queueDepth: int64(queueDepth),
+		timeout:    timeout,
+	}
+}
+
+// Acquire takes a slot, waiting in the queue if none is free. Call the
+// returned release function once the request is done.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	default:
+	}
+
+	if l.waiting.Add(1) > l.queueDepth {
+		l.waiting.Add(-1)
+		return nil, ErrQueueFull
+	}
+	defer l.waiting.Add(-1)
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	case <-timer.C:
+		return nil, ErrQueueTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// TryAcquire takes a slot if one is free, without queueing
+func (l *Limiter) TryAcquire() (func(), bool) {
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, true
+	default:
+		return nil, false
+	}
+}
+
+func (l *Limiter) release() {
+	<-l.slots
+}
+
+// InFlight is the number of slots taken
+func (l *Limiter) InFlight() int {
+	return len(l.slots)
+}
+
+// Waiting is the number of queued requests
+func (l *Limiter) Waiting() int {
+	return int(l.waiting.Load())
+}
@@ -0,0 +1,59 @@
+package bulkhead
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	limiter := New("test", 1, 1, 50*time.Millisecond)
+
+	release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Expected a free slot, got %v", err)
+	}
+
+	// A queued request gets the slot once it is released
+	acquired := make(chan error)
+	go func() {
+		release, err := limiter.Acquire(context.Background())
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+
+	for limiter.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := limiter.Acquire(context.Background()); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull with the queue taken, got %v", err)
+	}
+
+	release()
+	if err := <-acquired; err != nil {
+		t.Errorf("Expected the queued request to get the slot, got %v", err)
+	}
+	if limiter.InFlight() != 0 {
+		t.Errorf("Expected no slots taken, got %d", limiter.InFlight())
+	}
+}
+
+func TestAcquireTimeout(t *testing.T) {
+	limiter := New("test", 1, 5, 20*time.Millisecond)
+
+	release, _ := limiter.Acquire(context.Background())
+	defer release()
+
+	if _, err := limiter.Acquire(context.Background()); !errors.Is(err, ErrQueueTimeout) {
+		t.Errorf("Expected ErrQueueTimeout, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := limiter.Acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled request to give up, got %v", err)
+	}
+}
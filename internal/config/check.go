@@ -0,0 +1,301 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is a mistake in a config file. Line is 0 when the mistake cannot
+// be tied to one.
+type Problem struct {
+	Line    int
+	Field   string
+	Message string
+}
+
+func (p Problem) String() string {
+	s := p.Message
+	if p.Field != "" {
+		s = p.Field + ": " + s
+	}
+	if p.Line > 0 {
+		s = fmt.Sprintf("line %d: %s", p.Line, s)
+	}
+	return s
+}
+
+// Check reads the config file at path and reports the problems in it
+// without starting anything. Unlike Load, which stops at the first
+// mistake, it reports every YAML error, unknown key, negative duration and
+// reference to an undefined backend or middleware, with the line it is
+// on. The error is only for a file that cannot be read.
+func Check(path string) ([]Problem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = toYAML(path, data)
+	if err != nil {
+		return []Problem{{Message: err.Error()}}, nil
+	}
+
+	problems := check(data)
+	if isTOML(path) {
+		// The lines are those of the converted YAML
+		for i := range problems {
+			problems[i].Line = 0
+		}
+	}
+	if len(problems) > 0 {
+		return problems, nil
+	}
+
+	// The rest of what Load checks, which stops at the first mistake
+	if _, err := LoadFile(path); err != nil {
+		return []Problem{{Message: err.Error()}}, nil
+	}
+	return nil, nil
+}
+
+// check reports the problems in a YAML config
+func check(data []byte) []Problem {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return yamlProblems(err)
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+	var cfg Config
+	if err := decode(data, &cfg); err != nil {
+		return yamlProblems(err)
+	}
+
+	c := &checker{root: root.Content[0]}
+	c.durations(c.root, nil)
+	c.backends(&cfg)
+	c.references(&cfg)
+	return c.problems
+}
+
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlProblems turns a syntax error, or the list of mistakes in a
+// decodeError, into problems
+func yamlProblems(err error) []Problem {
+	messages := []string{err.Error()}
+	var decodeErr decodeError
+	if errors.As(err, &decodeErr) {
+		messages = decodeErr
+	}
+
+	problems := make([]Problem, 0, len(messages))
+	for _, message := range messages {
+		p := Problem{Message: message}
+		if m := yamlLine.FindStringSubmatch(message); m != nil {
+			p.Line, _ = strconv.Atoi(m[1])
+			p.Message = m[2]
+		}
+		problems = append(problems, p)
+	}
+	return problems
+}
+
+type checker struct {
+	root     *yaml.Node
+	problems []Problem
+}
+
+// add records a problem with the value at path, a list of mapping keys and
+// sequence indexes
+func (c *checker) add(message string, path ...any) {
+	var field strings.Builder
+	for _, step := range path {
+		switch s := step.(type) {
+		case string:
+			if field.Len() > 0 {
+				field.WriteByte('.')
+			}
+			field.WriteString(s)
+		case int:
+			fmt.Fprintf(&field, "[%d]", s)
+		}
+	}
+	c.problems = append(c.problems, Problem{Line: lineOf(c.root, path), Field: field.String(), Message: message})
+}
+
+// lineOf returns the line of the value at path, or of the closest parent
+// that is in the file
+func lineOf(n *yaml.Node, path []any) int {
+	line := n.Line
+	for _, step := range path {
+		var next *yaml.Node
+		switch s := step.(type) {
+		case string:
+			if n.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(n.Content); i += 2 {
+					if n.Content[i].Value == s {
+						next = n.Content[i+1]
+					}
+				}
+			}
+		case int:
+			if n.Kind == yaml.SequenceNode && s < len(n.Content) {
+				next = n.Content[s]
+			}
+		}
+		if next == nil {
+			return line
+		}
+		n, line = next, next.Line
+	}
+	return line
+}
+
+// isDuration reports whether a key holds a number of seconds or
+// milliseconds
+func isDuration(key string) bool {
+	if strings.HasSuffix(key, "Ms") {
+		return true
+	}
+	key = strings.ToLower(key)
+	for _, suffix := range []string{"timeout", "interval", "ttl", "maxage", "duration", "window", "revalidate"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// durations reports negative durations anywhere below n
+func (c *checker) durations(n *yaml.Node, path []any) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i].Value, n.Content[i+1]
+			at := append(append([]any(nil), path...), key)
+			if value.Kind == yaml.ScalarNode && value.Tag == "!!int" && isDuration(key) {
+				if v, err := strconv.Atoi(value.Value); err == nil && v < 0 {
+					c.add("duration cannot be negative", at...)
+				}
+				continue
+			}
+			c.durations(value, at)
+		}
+	case yaml.SequenceNode:
+		for i, item := range n.Content {
+			c.durations(item, append(append([]any(nil), path...), i))
+		}
+	}
+}
+
+// backends checks backend names, URLs and weights. Weighted balancing
+// never picks a backend with weight 0 while another has a weight, which
+// is usually a forgotten weight rather than a way to disable a backend.
+// The same goes for the servers of a group.
+func (c *checker) backends(cfg *Config) {
+	names := make(map[string]bool, len(cfg.Backends))
+	weighted := false
+	for _, backend := range cfg.Backends {
+		weighted = weighted || backend.TotalWeight() > 0
+	}
+
+	for i, backend := range cfg.Backends {
+		switch {
+		case backend.Name == "":
+			c.add("name is required", "backends", i)
+		case names[backend.Name]:
+			c.add(fmt.Sprintf("backend %s is defined twice", backend.Name), "backends", i, "name")
+		}
+		names[backend.Name] = true
+
+		switch {
+		case len(backend.Servers) == 0:
+			c.url(backend.URL, "backends", i, "url")
+		case backend.URL != "":
+			c.add("url and servers cannot both be set", "backends", i, "url")
+		}
+
+		switch {
+		case backend.Weight < 0:
+			c.add("weight cannot be negative", "backends", i, "weight")
+		case backend.TotalWeight() == 0 && weighted:
+			c.add("weight 0 never receives traffic while other backends have weights", "backends", i, "weight")
+		}
+
+		serversWeighted := false
+		for _, server := range backend.Servers {
+			serversWeighted = serversWeighted || server.Weight > 0
+		}
+		for j, server := range backend.Servers {
+			c.url(server.URL, "backends", i, "servers", j, "url")
+			switch {
+			case server.Weight < 0:
+				c.add("weight cannot be negative", "backends", i, "servers", j, "weight")
+			case server.Weight == 0 && serversWeighted:
+				c.add("weight 0 never receives traffic while other servers have weights", "backends", i, "servers", j, "weight")
+			}
+		}
+		for _, instance := range backend.Instances() {
+			if names[instance.Name] && instance.Name != backend.Name {
+				c.add(fmt.Sprintf("backend %s is defined twice", instance.Name), "backends", i, "servers")
+			}
+			names[instance.Name] = true
+		}
+	}
+}
+
+// url checks a backend URL at path
+func (c *checker) url(raw string, path ...any) {
+	if u, err := url.Parse(raw); err != nil {
+		c.add(fmt.Sprintf("url %q does not parse", raw), path...)
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.add(fmt.Sprintf("url %q must be http:// or https:// with a host", raw), path...)
+	}
+}
+
+// references checks that bulkhead groups and routes name backends and
+// middlewares that are defined
+func (c *checker) references(cfg *Config) {
+	backends := make(map[string]bool, len(cfg.Backends))
+	for _, backend := range cfg.Backends {
+		backends[backend.Name] = true
+	}
+	// Without backends in the file Load adds a default one
+	if len(cfg.Backends) == 0 {
+		backends["default"] = true
+	}
+
+	for i, group := range cfg.Bulkhead.Groups {
+		for j, backend := range group.Backends {
+			if !backends[backend] {
+				c.add(fmt.Sprintf("unknown backend %q", backend), "bulkhead", "groups", i, "backends", j)
+			}
+		}
+	}
+
+	for i, route := range cfg.Routes {
+		for j, m := range route.Middlewares {
+			if _, ok := cfg.Middlewares[m]; !ok {
+				c.add(fmt.Sprintf("middleware %s is not defined", m), "routes", i, "middlewares", j)
+			}
+		}
+		if route.Aggregate != nil {
+			for j, part := range route.Aggregate.Parts {
+				if !backends[part.Backend] {
+					c.add(fmt.Sprintf("unknown backend %q", part.Backend), "routes", i, "aggregate", "parts", j, "backend")
+				}
+			}
+		}
+		if route.GRPCTranscode != nil && !backends[route.GRPCTranscode.Backend] {
+			c.add(fmt.Sprintf("unknown backend %q", route.GRPCTranscode.Backend), "routes", i, "grpcTranscode", "backend")
+		}
+	}
+}
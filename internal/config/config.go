@@ -1,39 +1,2753 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/barisgenc/gatekeeper/internal/expr"
+	"github.com/barisgenc/gatekeeper/internal/openapi"
+	"github.com/barisgenc/gatekeeper/internal/transcode"
 )
 
-type Config struct {
-	Server    ServerConfig   `yaml:"server"`
-	Backends  []Backend      `yaml:"backends"`
-	RateLimit RateLimitConfig `yaml:"rateLimit"`
-	LogLevel  string         `yaml:"logLevel"`
+type Config struct {
+	Server         ServerConfig         `yaml:"server"`
+	Backends       []Backend            `yaml:"backends"`
+	LoadBalancing  LoadBalancingConfig  `yaml:"loadBalancing"`
+	HealthCheck    HealthCheckConfig    `yaml:"healthCheck"`
+	Routes         []RouteConfig        `yaml:"routes"`
+	Middlewares    MiddlewareConfigs    `yaml:"middlewares"`
+	Connect        ConnectConfig        `yaml:"connect"`
+	PathNormalize  PathNormalizeConfig  `yaml:"pathNormalization"`
+	Synthetics     SyntheticsConfig     `yaml:"synthetics"`
+	Notifications  NotificationsConfig  `yaml:"notifications"`
+	Bulkhead       BulkheadConfig       `yaml:"bulkhead"`
+	Incident       IncidentConfig       `yaml:"incident"`
+	Hedging        HedgingConfig        `yaml:"hedging"`
+	RequestAge     RequestAgeConfig     `yaml:"requestAge"`
+	CORS           CORSConfig           `yaml:"cors"`
+	Lint           LintConfig           `yaml:"lint"`
+	Idempotency    IdempotencyConfig    `yaml:"idempotency"`
+	OpenAPI        OpenAPIConfig        `yaml:"openapi"`
+	Storage        StorageConfig        `yaml:"storage"`
+	Tests          []ContractTest       `yaml:"tests"`
+	EdgeHealth     EdgeHealthConfig     `yaml:"edgeHealth"`
+	DebugEcho      DebugEchoConfig      `yaml:"debugEcho"`
+	Drain          DrainConfig          `yaml:"drain"`
+	Protocols      ProtocolConfig       `yaml:"protocolDetection"`
+	RateLimit      RateLimitConfig      `yaml:"rateLimit"`
+	AutoBan        AutoBanConfig        `yaml:"autoBan"`
+	Tarpit         TarpitConfig         `yaml:"tarpit"`
+	Classification ClassificationConfig `yaml:"classification"`
+	Journal        JournalConfig        `yaml:"journal"`
+	OIDC           OIDCConfig           `yaml:"oidc"`
+	Sessions       SessionsConfig       `yaml:"sessions"`
+	SAML           SAMLConfig           `yaml:"saml"`
+	SPNEGO         SPNEGOConfig         `yaml:"spnego"`
+	LDAP           LDAPConfig           `yaml:"ldap"`
+	Introspection  IntrospectionConfig  `yaml:"introspection"`
+	ExtAuthz       ExtAuthzConfig       `yaml:"extAuthz"`
+	ExtProc        ExtProcConfig        `yaml:"extProc"`
+	HMAC           HMACConfig           `yaml:"hmac"`
+	UpstreamSign   UpstreamSignConfig   `yaml:"upstreamSigning"`
+	TokenRelay     TokenRelayConfig     `yaml:"tokenRelay"`
+	UpstreamTLS    UpstreamTLSConfig    `yaml:"upstreamTLS"`
+	Admin          AdminConfig          `yaml:"admin"`
+	Tracing        TracingConfig        `yaml:"tracing"`
+	Metrics        MetricsConfig        `yaml:"metrics"`
+	SLOs           []SLOConfig          `yaml:"slos"`
+	Banner         BannerConfig         `yaml:"banner"`
+	Maintenance    MaintenanceConfig    `yaml:"maintenance"`
+	BotDetection   BotDetectionConfig   `yaml:"botDetection"`
+	Versioning     VersioningConfig     `yaml:"versioning"`
+	TCPProxies     []TCPProxyConfig     `yaml:"tcpProxies"`
+	Secrets        SecretsConfig        `yaml:"secrets"`
+	Source         ConfigSourceConfig   `yaml:"configSource"`
+	LogLevel       string               `yaml:"logLevel"`
+	AccessLog      AccessLogConfig      `yaml:"accessLog"`
+	Redaction      RedactionConfig      `yaml:"redaction"`
+	Events         EventsConfig         `yaml:"events"`
+	Usage          UsageConfig          `yaml:"usage"`
+	Quota          QuotaConfig          `yaml:"quota"`
+	RateLimitSvc   RateLimitSvcConfig   `yaml:"rateLimitService"`
+}
+
+type ServerConfig struct {
+	Address      string             `yaml:"address"`
+	ReadTimeout  int                `yaml:"readTimeout"`
+	WriteTimeout int                `yaml:"writeTimeout"`
+	IdleTimeout  int                `yaml:"idleTimeout"`
+	TLS          TLSConfig          `yaml:"tls"`
+	HTTPRedirect HTTPRedirectConfig `yaml:"httpRedirect"`
+	HSTS         HSTSConfig         `yaml:"hsts"`
+	ConnLimit    ConnLimitConfig    `yaml:"connLimit"`
+	HTTP2        HTTP2Config        `yaml:"http2"`
+	HTTP3        HTTP3Config        `yaml:"http3"`
+	Listeners    []ListenerConfig   `yaml:"listeners"`
+}
+
+// ListenerConfig is an additional client-facing listener serving the same
+// routes as the main one, e.g. plain HTTP on an internal port next to
+// HTTPS. Listeners can be added, removed or given new TLS settings on
+// reload; timeouts and the connection limit only apply to newly bound
+// listeners.
+type ListenerConfig struct {
+	Address string      `yaml:"address"`
+	TLS     TLSConfig   `yaml:"tls"`
+	HTTP2   HTTP2Config `yaml:"http2"`
+}
+
+// HTTP2Config controls HTTP/2 on a listener. TLS listeners offer h2 through
+// ALPN unless Disabled is set. H2C also accepts cleartext HTTP/2, both with
+// prior knowledge and through "Upgrade: h2c", which plain listeners need
+// for clients such as gRPC. MaxConcurrentStreams defaults to 250 per
+// connection. Like timeouts, H2C and MaxConcurrentStreams only apply to
+// newly bound listeners on reload.
+type HTTP2Config struct {
+	Disabled             bool   `yaml:"disabled"`
+	H2C                  bool   `yaml:"h2c"`
+	MaxConcurrentStreams uint32 `yaml:"maxConcurrentStreams"`
+}
+
+// HTTP3Config adds an experimental HTTP/3 listener on UDP, serving the same
+// routes with the certificate of the TLS listener at server.address.
+// Address defaults to server.address. Responses over TCP advertise it with
+// Alt-Svc for AltSvcMaxAge seconds (default 86400), so clients switch to
+// QUIC on their next connection.
+type HTTP3Config struct {
+	Enabled      bool   `yaml:"enabled"`
+	Address      string `yaml:"address"`
+	AltSvcMaxAge int    `yaml:"altSvcMaxAge"`
+}
+
+func (h HTTP2Config) validate() error {
+	if h.Disabled && h.H2C {
+		return errors.New("http2 h2c cannot be enabled while HTTP/2 is disabled")
+	}
+	return nil
+}
+
+// ConnLimitConfig caps how many new connections each client IP may open per
+// second, checked at accept time before any TLS handshake. Zero disables it;
+// Burst defaults to the per-second rate.
+type ConnLimitConfig struct {
+	PerIPPerSecond float64 `yaml:"perIPPerSecond"`
+	Burst          int     `yaml:"burst"`
+}
+
+// HTTPRedirectConfig runs a second, plain HTTP listener that only redirects
+// to HTTPS and serves ACME HTTP-01 challenge files from ACMEChallengeDir.
+// HTTPSPort is added to redirect targets when it is not 443.
+type HTTPRedirectConfig struct {
+	Enabled          bool   `yaml:"enabled"`
+	Address          string `yaml:"address"`
+	HTTPSPort        int    `yaml:"httpsPort"`
+	ACMEChallengeDir string `yaml:"acmeChallengeDir"`
+}
+
+// HSTSConfig sets Strict-Transport-Security on HTTPS responses. MaxAge is in
+// seconds; Preload requires a max age of at least a year and subdomains.
+type HSTSConfig struct {
+	MaxAge            int  `yaml:"maxAge"`
+	IncludeSubdomains bool `yaml:"includeSubdomains"`
+	Preload           bool `yaml:"preload"`
+}
+
+// TLSConfig enables HTTPS on the listener and, with a client CA, mutual TLS.
+// ClientAuth is one of none, request, require, verify_if_given or
+// require_and_verify.
+type TLSConfig struct {
+	CertFile          string `yaml:"certFile"`
+	KeyFile           string `yaml:"keyFile"`
+	ClientCAFile      string `yaml:"clientCAFile"`
+	ClientAuth        string `yaml:"clientAuth"`
+	CRLFile           string `yaml:"crlFile"`
+	ForwardClientCert bool   `yaml:"forwardClientCert"`
+}
+
+// Enabled reports whether the listener should serve TLS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// allowRouteClientCerts makes the handshake verify client certificates that
+// are offered without requiring one, leaving enforcement to the route
+func (t *TLSConfig) allowRouteClientCerts() error {
+	if !t.Enabled() || t.ClientCAFile == "" {
+		return errors.New("clientCert requires server.tls with a clientCAFile")
+	}
+	switch t.ClientAuth {
+	case "":
+		t.ClientAuth = "verify_if_given"
+	case "verify_if_given", "require_and_verify":
+	default:
+		return fmt.Errorf("clientCert requires clientAuth verify_if_given or require_and_verify, not %q", t.ClientAuth)
+	}
+	return nil
+}
+
+// Backend is an upstream service. Protocol is "http1", "http2" ("grpc" is
+// the same) or "auto" (default). For http:// URLs, "http2" means h2c with
+// prior knowledge and "auto" probes the backend once and caches the answer
+// (see ProtocolConfig). For https:// URLs, TLS negotiates the protocol
+// unless it is "http1", which never offers h2.
+//
+// A backend with Servers instead of a URL is a group of instances sharing
+// its other settings. Traffic is split between backends by their Weight,
+// which for a group defaults to the sum of its servers' weights, and then
+// between a group's healthy servers.
+//
+// MaxConnections caps the requests in flight to each server (0, the
+// default, is no cap). At the cap, requests wait for a free connection in
+// a queue of up to QueueDepth (default MaxConnections) for up to
+// QueueTimeoutMs (default 100). With Overflow "spill" they go to another
+// healthy backend with room first, and only wait when there is none;
+// Overflow is "queue" by default.
+//
+// Backup backends only get traffic while the others are down or failing;
+// see FailoverConfig.
+//
+// Algorithm picks between a group's servers in place of the route's or the
+// global one, with the same choices as LoadBalancingConfig.
+type Backend struct {
+	Name           string              `yaml:"name"`
+	URL            string              `yaml:"url"`
+	Weight         int                 `yaml:"weight"`
+	Health         string              `yaml:"health"`
+	Proxy          *BackendProxyConfig `yaml:"proxy"`
+	Protocol       string              `yaml:"protocol"`
+	Servers        []BackendServer     `yaml:"servers"`
+	MaxConnections int                 `yaml:"maxConnections"`
+	QueueDepth     int                 `yaml:"queueDepth"`
+	QueueTimeoutMs int                 `yaml:"queueTimeoutMs"`
+	Overflow       string              `yaml:"overflow"`
+	Backup         bool                `yaml:"backup"`
+	Algorithm      string              `yaml:"algorithm"`
+
+	// Group is the name of the backend an instance belongs to; see
+	// Instances
+	Group string `yaml:"-"`
+}
+
+// BackendServer is one instance of a backend. Its Name, by default its
+// position starting at 1, is appended to the backend's to name the
+// instance in health checks and metrics, e.g. "api-2". Weight splits the
+// backend's traffic between its servers.
+type BackendServer struct {
+	Name   string `yaml:"name"`
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"`
+}
+
+// Instances returns one Backend per server of b, named after b and the
+// server, or b itself when it has no servers. Each has Group set to b's
+// name.
+func (b Backend) Instances() []Backend {
+	if len(b.Servers) == 0 {
+		b.Group = b.Name
+		return []Backend{b}
+	}
+	instances := make([]Backend, len(b.Servers))
+	for i, server := range b.Servers {
+		instance := b
+		instance.Servers = nil
+		instance.Group = b.Name
+		instance.Name = b.Name + "-" + server.Name
+		if server.Name == "" {
+			instance.Name = b.Name + "-" + strconv.Itoa(i+1)
+		}
+		instance.URL = server.URL
+		instance.Weight = server.Weight
+		instances[i] = instance
+	}
+	return instances
+}
+
+// TotalWeight is the share of traffic b gets: its weight, or for a group
+// without one the sum of its servers' weights
+func (b Backend) TotalWeight() int {
+	if b.Weight != 0 || len(b.Servers) == 0 {
+		return b.Weight
+	}
+	total := 0
+	for _, server := range b.Servers {
+		total += server.Weight
+	}
+	return total
+}
+
+// Instances returns the instances of every backend, in order
+func Instances(backends []Backend) []Backend {
+	var instances []Backend
+	for _, backend := range backends {
+		instances = append(instances, backend.Instances()...)
+	}
+	return instances
+}
+
+// ProtocolConfig tunes how backends with protocol "auto" are
+// probed: each probe waits up to Timeout seconds (default 2), and answers
+// are re-checked after Revalidate seconds (default 600).
+type ProtocolConfig struct {
+	Revalidate int `yaml:"revalidate"`
+	Timeout    int `yaml:"timeout"`
+}
+
+// BackendProxyConfig sends a backend's traffic, health checks included,
+// through a forward proxy. URL is http:// or https:// for an HTTP proxy
+// (CONNECT for HTTPS backends) or socks5:// for SOCKS5. Username and
+// Password override credentials in the URL.
+type BackendProxyConfig struct {
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// RateLimitConfig is a request rate limit. Mode is "tokenBucket"
+// (default), which lets a client spend BurstSize requests at once, or
+// "spikeArrest", which spreads RequestsPerMinute evenly over the minute:
+// one request every 60/RequestsPerMinute seconds, BurstSize unused.
+//
+// Plans are named per-client limits, such as "free" and "pro", that
+// replace the Authenticated tier for the consumers on them. A consumer's
+// plan is the one its HMAC key names (see HMACConsumer), else the one
+// Consumers maps its identity, such as "oidc:alice", to.
+type RateLimitConfig struct {
+	RequestsPerMinute int                      `yaml:"requestsPerMinute"`
+	BurstSize         int                      `yaml:"burstSize"`
+	Mode              string                   `yaml:"mode"`
+	Anonymous         *RateLimitTier           `yaml:"anonymous"`
+	Authenticated     *RateLimitTier           `yaml:"authenticated"`
+	Plans             map[string]RateLimitTier `yaml:"plans"`
+	Consumers         map[string]string        `yaml:"consumers"`
+	UseForwardedFor   bool                     `yaml:"useForwardedFor"`
+	Store             string                   `yaml:"store"`
+}
+
+// SpikeArrest reports whether requests are spread evenly over the minute
+// rather than allowed in bursts
+func (r RateLimitConfig) SpikeArrest() bool {
+	return r.Mode == "spikeArrest"
+}
+
+func (r RateLimitConfig) validatePlans(hmac HMACConfig) error {
+	for name, plan := range r.Plans {
+		if name == "" || name == "anonymous" || name == "authenticated" {
+			return fmt.Errorf("plan name %q is reserved", name)
+		}
+		if plan.RequestsPerMinute <= 0 || plan.BurstSize < 0 {
+			return fmt.Errorf("plan %s needs a positive requestsPerMinute", name)
+		}
+	}
+	for consumer, plan := range r.Consumers {
+		if _, ok := r.Plans[plan]; !ok {
+			return fmt.Errorf("consumer %s: plan %q is not defined", consumer, plan)
+		}
+	}
+	for _, consumer := range hmac.Consumers {
+		if _, ok := r.Plans[consumer.Plan]; consumer.Plan != "" && !ok {
+			return fmt.Errorf("hmac consumer %s: plan %q is not defined", consumer.ID, consumer.Plan)
+		}
+	}
+	return nil
+}
+
+func (r RateLimitConfig) validate() error {
+	switch r.Mode {
+	case "", "tokenBucket", "spikeArrest":
+	default:
+		return fmt.Errorf("rateLimit mode %q must be tokenBucket or spikeArrest", r.Mode)
+	}
+	switch r.Store {
+	case "", "memory", "shared":
+	default:
+		return fmt.Errorf("rateLimit store %q must be memory or shared", r.Store)
+	}
+	return nil
+}
+
+// RateLimitTier is a per-client limit applied after authentication.
+// Anonymous clients are limited per IP (the last X-Forwarded-For hop with
+// UseForwardedFor), authenticated ones per verified identity, so users
+// behind a shared NAT are not throttled along with scrapers on it. With
+// RateLimitConfig.Store "shared" tiers count requests per clock minute in
+// the shared storage so replicas enforce one limit, and BurstSize is unused.
+type RateLimitTier struct {
+	RequestsPerMinute int `yaml:"requestsPerMinute"`
+	BurstSize         int `yaml:"burstSize"`
+}
+
+// AutoBanConfig bans client IPs that collect Threshold responses with one
+// of Statuses (default 401, 403, 429) within Window seconds, for
+// BanDuration seconds. Clients are identified by the connection's address
+// unless UseForwardedFor trusts the last X-Forwarded-For hop. Store is
+// "memory" (default), "redis" or "shared".
+//
+// Honeypots are decoy paths no real client asks for, such as
+// "/wp-login.php" or "/.env"; a path ending in "/" also covers everything
+// under it. A client requesting one is banned straight away, answered 404
+// and reported with a honeypot_hit notification.
+type AutoBanConfig struct {
+	Enabled         bool        `yaml:"enabled"`
+	Statuses        []int       `yaml:"statuses"`
+	Threshold       int         `yaml:"threshold"`
+	Window          int         `yaml:"window"`
+	BanDuration     int         `yaml:"banDuration"`
+	UseForwardedFor bool        `yaml:"useForwardedFor"`
+	Allowlist       []string    `yaml:"allowlist"`
+	Honeypots       []string    `yaml:"honeypots"`
+	Store           string      `yaml:"store"`
+	Redis           RedisConfig `yaml:"redis"`
+}
+
+func (a AutoBanConfig) validate() error {
+	for _, entry := range a.Allowlist {
+		if net.ParseIP(entry) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(entry); err != nil {
+			return fmt.Errorf("allowlist entry %q is not an address or CIDR", entry)
+		}
+	}
+	for _, path := range a.Honeypots {
+		if !strings.HasPrefix(path, "/") || path == "/" {
+			return fmt.Errorf("honeypot %q must be a path other than /", path)
+		}
+	}
+	if a.Threshold < 0 || a.Window < 0 || a.BanDuration < 0 {
+		return errors.New("threshold, window and banDuration cannot be negative")
+	}
+	return validateStore(a.Store, a.Redis)
+}
+
+// validateStore checks a store setting of "memory", "redis" or "shared"
+// and the redis settings it needs
+func validateStore(store string, redis RedisConfig) error {
+	switch store {
+	case "", "memory", "shared":
+	case "redis":
+		if redis.Address == "" {
+			return errors.New("redis store requires an address")
+		}
+	default:
+		return fmt.Errorf("store %q must be memory, redis or shared", store)
+	}
+	return nil
+}
+
+// TarpitConfig holds the answers to rate-limited and banned clients for
+// Delay seconds (default 5) before sending the 429 or 403, so abusive
+// clients pay for every rejected request. At most MaxConcurrent (default
+// 100) requests are held at once; beyond that clients are answered
+// straight away, so a flood cannot tie up the gateway.
+type TarpitConfig struct {
+	Enabled       bool `yaml:"enabled"`
+	Delay         int  `yaml:"delay"`
+	MaxConcurrent int  `yaml:"maxConcurrent"`
+}
+
+// StorageConfig is the key-value store used by every feature whose store
+// is "shared", so one choice covers bans, idempotency keys and per-client
+// rate limits. Type is "memory" (default), "redis" or "bolt", a local file
+// that survives restarts but cannot be shared between replicas. The store
+// is opened once at startup.
+type StorageConfig struct {
+	Type  string      `yaml:"type"`
+	Redis RedisConfig `yaml:"redis"`
+	Bolt  BoltConfig  `yaml:"bolt"`
+}
+
+type BoltConfig struct {
+	Path string `yaml:"path"`
+}
+
+type RedisConfig struct {
+	Address   string `yaml:"address"`
+	Password  string `yaml:"password"`
+	DB        int    `yaml:"db"`
+	KeyPrefix string `yaml:"keyPrefix"`
+}
+
+// ClassificationConfig tags requests with a business category so logs,
+// metrics and backends can talk about "checkout" instead of raw paths.
+type ClassificationConfig struct {
+	Enabled bool                 `yaml:"enabled"`
+	Header  string               `yaml:"header"`
+	Default string               `yaml:"default"`
+	Rules   []ClassificationRule `yaml:"rules"`
+}
+
+// ClassificationRule assigns Category to requests matching every non-empty
+// condition. Rules are evaluated in order and the first match wins.
+type ClassificationRule struct {
+	Category   string            `yaml:"category"`
+	PathPrefix string            `yaml:"pathPrefix"`
+	Methods    []string          `yaml:"methods"`
+	Headers    map[string]string `yaml:"headers"`
+	Priority   int               `yaml:"priority"`
+}
+
+// JournalConfig controls the write-ahead request journal used to find
+// requests that were in flight when the process died.
+type JournalConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Path      string `yaml:"path"`
+	Sync      bool   `yaml:"sync"`
+	MaxSizeMB int    `yaml:"maxSizeMB"`
+}
+
+// OIDCConfig enables OpenID Connect login for browser traffic. Sessions are
+// kept in an encrypted cookie; SessionTTL is in seconds.
+type OIDCConfig struct {
+	Enabled            bool     `yaml:"enabled"`
+	IssuerURL          string   `yaml:"issuerURL"`
+	ClientID           string   `yaml:"clientID"`
+	ClientSecret       string   `yaml:"clientSecret"`
+	RedirectURL        string   `yaml:"redirectURL"`
+	Scopes             []string `yaml:"scopes"`
+	CookieName         string   `yaml:"cookieName"`
+	CookieSecret       string   `yaml:"cookieSecret"`
+	SessionTTL         int      `yaml:"sessionTTL"`
+	IdentityHeader     string   `yaml:"identityHeader"`
+	ForwardAccessToken bool     `yaml:"forwardAccessToken"`
+	SkipPaths          []string `yaml:"skipPaths"`
+}
+
+// validate checks o; with sessions the cookie settings are not o's
+func (o OIDCConfig) validate(sessions bool) error {
+	if u, err := url.Parse(o.IssuerURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("issuerURL %q must be an http(s) URL", o.IssuerURL)
+	}
+	if o.ClientID == "" {
+		return errors.New("clientID is required")
+	}
+	if u, err := url.Parse(o.RedirectURL); err != nil || u.Path == "" {
+		return fmt.Errorf("redirectURL %q must be a URL with a callback path", o.RedirectURL)
+	}
+	if !sessions && len(o.CookieSecret) < 16 {
+		return errors.New("cookieSecret must be at least 16 characters")
+	}
+	if o.SessionTTL < 0 {
+		return errors.New("sessionTTL cannot be negative")
+	}
+	return nil
+}
+
+// SessionsConfig keeps browser login sessions, such as OIDC's, in place of
+// the oidc cookie settings. Store "cookie" (default) seals the whole
+// session into the cookie; "shared" keeps it in the gateway's storage (see
+// StorageConfig), with only its ID in the cookie, so replicas share
+// sessions and the admin API can list them. Either kind can be revoked
+// through the admin API. CookieName defaults to "gatekeeper_session" and
+// TTL, in seconds, to 8 hours. SameSite is "lax" (default), "strict" or
+// "none", which needs Secure; Secure defaults to true. The short-lived
+// login state cookie is always "lax", or the IdP's redirect would not
+// bring it back.
+type SessionsConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Store      string `yaml:"store"`
+	CookieName string `yaml:"cookieName"`
+	Secret     string `yaml:"secret"`
+	TTL        int    `yaml:"ttl"`
+	SameSite   string `yaml:"sameSite"`
+	Secure     *bool  `yaml:"secure"`
+	Domain     string `yaml:"domain"`
+}
+
+func (s SessionsConfig) validate() error {
+	switch s.Store {
+	case "", "cookie", "shared":
+	default:
+		return fmt.Errorf("store %q must be cookie or shared", s.Store)
+	}
+	if len(s.Secret) < 16 {
+		return errors.New("secret must be at least 16 characters")
+	}
+	if s.TTL < 0 {
+		return errors.New("ttl cannot be negative")
+	}
+	switch s.SameSite {
+	case "", "lax", "strict":
+	case "none":
+		if s.Secure != nil && !*s.Secure {
+			return errors.New("sameSite none needs secure cookies")
+		}
+	default:
+		return fmt.Errorf("sameSite %q must be lax, strict or none", s.SameSite)
+	}
+	return nil
+}
+
+// SAMLConfig validates SAML 2.0 bearer assertions sent by clients, for
+// identity providers that cannot issue OIDC tokens. IdP metadata is read from
+// MetadataFile or fetched once from MetadataURL at startup.
+type SAMLConfig struct {
+	Enabled          bool              `yaml:"enabled"`
+	MetadataFile     string            `yaml:"metadataFile"`
+	MetadataURL      string            `yaml:"metadataURL"`
+	Audience         string            `yaml:"audience"`
+	Header           string            `yaml:"header"`
+	IdentityHeader   string            `yaml:"identityHeader"`
+	AttributeHeaders map[string]string `yaml:"attributeHeaders"`
+	SkipPaths        []string          `yaml:"skipPaths"`
+}
+
+func (s SAMLConfig) validate() error {
+	if (s.MetadataFile == "") == (s.MetadataURL == "") {
+		return errors.New("exactly one of metadataFile and metadataURL is required")
+	}
+	if s.MetadataURL != "" {
+		if u, err := url.Parse(s.MetadataURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("metadataURL %q must be an http(s) URL", s.MetadataURL)
+		}
+	}
+	if s.Audience == "" {
+		return errors.New("audience (the SP entity ID) is required")
+	}
+	return nil
+}
+
+// SPNEGOConfig enables Kerberos "Negotiate" authentication for clients on a
+// Windows domain or with a kinit ticket. Service tickets are decrypted with
+// the keys in KeytabFile.
+type SPNEGOConfig struct {
+	Enabled          bool     `yaml:"enabled"`
+	KeytabFile       string   `yaml:"keytabFile"`
+	ServicePrincipal string   `yaml:"servicePrincipal"`
+	IdentityHeader   string   `yaml:"identityHeader"`
+	StripRealm       bool     `yaml:"stripRealm"`
+	GroupsHeader     string   `yaml:"groupsHeader"`
+	SkipPaths        []string `yaml:"skipPaths"`
+}
+
+func (s SPNEGOConfig) validate() error {
+	if s.KeytabFile == "" {
+		return errors.New("keytabFile is required")
+	}
+	return nil
+}
+
+// LDAPConfig validates Basic credentials against an LDAP directory such as
+// Active Directory. Users are looked up under BaseDN with UserFilter, in
+// which %s stands for the escaped username (default "(uid=%s)"; for AD use
+// "(sAMAccountName=%s)"), by the service account BindDN or anonymously,
+// then bound as to check the password. URL is ldap:// or ldaps://;
+// StartTLS upgrades an ldap:// connection.
+//
+// The user's groups are read from GroupAttribute (default memberOf) and
+// mapped to roles by Roles, keyed by group DN or common name. Backends get
+// the username as the directory stores it, read from UsernameAttribute
+// (default the attribute UserFilter matches, else the entry's DN), in
+// IdentityHeader (default X-Auth-Subject) and the roles in RolesHeader
+// (default X-Auth-Roles). At most PoolSize (default 4) connections are
+// open. Timeout and CacheTTL are in seconds (default 5 and 60).
+type LDAPConfig struct {
+	Enabled           bool              `yaml:"enabled"`
+	URL               string            `yaml:"url"`
+	StartTLS          bool              `yaml:"startTLS"`
+	BindDN            string            `yaml:"bindDN"`
+	BindPassword      string            `yaml:"bindPassword"`
+	BaseDN            string            `yaml:"baseDN"`
+	UserFilter        string            `yaml:"userFilter"`
+	UsernameAttribute string            `yaml:"usernameAttribute"`
+	GroupAttribute    string            `yaml:"groupAttribute"`
+	Roles             map[string]string `yaml:"roles"`
+	Realm             string            `yaml:"realm"`
+	IdentityHeader    string            `yaml:"identityHeader"`
+	RolesHeader       string            `yaml:"rolesHeader"`
+	PoolSize          int               `yaml:"poolSize"`
+	Timeout           int               `yaml:"timeout"`
+	CacheTTL          int               `yaml:"cacheTTL"`
+	SkipPaths         []string          `yaml:"skipPaths"`
+}
+
+func (l LDAPConfig) validate() error {
+	u, err := url.Parse(l.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return fmt.Errorf("url %q must be an ldap:// or ldaps:// URL", l.URL)
+	}
+	if l.StartTLS && u.Scheme == "ldaps" {
+		return errors.New("startTLS only applies to ldap:// URLs")
+	}
+	if l.BaseDN == "" {
+		return errors.New("baseDN is required")
+	}
+	if l.UserFilter != "" && (!strings.Contains(l.UserFilter, "%s") || !strings.HasPrefix(l.UserFilter, "(")) {
+		return fmt.Errorf("userFilter %q must be a filter containing %%s", l.UserFilter)
+	}
+	if l.PoolSize < 0 || l.Timeout < 0 || l.CacheTTL < 0 {
+		return errors.New("poolSize, timeout and cacheTTL cannot be negative")
+	}
+	return nil
+}
+
+// IntrospectionConfig validates opaque bearer tokens against an RFC 7662
+// introspection endpoint. CacheTTL and Timeout are in seconds.
+type IntrospectionConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	Endpoint       string   `yaml:"endpoint"`
+	ClientID       string   `yaml:"clientID"`
+	ClientSecret   string   `yaml:"clientSecret"`
+	CacheTTL       int      `yaml:"cacheTTL"`
+	Timeout        int      `yaml:"timeout"`
+	RequiredScopes []string `yaml:"requiredScopes"`
+	ActiveHeader   string   `yaml:"activeHeader"`
+	ScopeHeader    string   `yaml:"scopeHeader"`
+	SubjectHeader  string   `yaml:"subjectHeader"`
+	SkipPaths      []string `yaml:"skipPaths"`
+}
+
+func (i IntrospectionConfig) validate() error {
+	if u, err := url.Parse(i.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("endpoint %q must be an http(s) URL", i.Endpoint)
+	}
+	if i.CacheTTL < 0 || i.Timeout < 0 {
+		return errors.New("cacheTTL and timeout cannot be negative")
+	}
+	return nil
+}
+
+// ExtAuthzConfig delegates the allow/deny decision to an external service.
+// URL is http(s):// for the HTTP protocol or grpc(s):// for Envoy's
+// Authorization gRPC API. With FailOpen, requests are allowed when the
+// service cannot be reached.
+type ExtAuthzConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	URL             string   `yaml:"url"`
+	TimeoutMs       int      `yaml:"timeoutMs"`
+	FailOpen        bool     `yaml:"failOpen"`
+	AllowedHeaders  []string `yaml:"allowedHeaders"`
+	UpstreamHeaders []string `yaml:"upstreamHeaders"`
+	SkipPaths       []string `yaml:"skipPaths"`
+}
+
+func (e ExtAuthzConfig) validate() error {
+	u, err := url.Parse(e.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("url %q must be http(s):// or grpc(s):// with a host", e.URL)
+	}
+	switch u.Scheme {
+	case "http", "https", "grpc", "grpcs":
+	default:
+		return fmt.Errorf("url %q must be http(s):// or grpc(s):// with a host", e.URL)
+	}
+	if e.TimeoutMs < 0 {
+		return errors.New("timeoutMs cannot be negative")
+	}
+	return nil
+}
+
+// ExtProcConfig streams each request through an external processor
+// speaking Envoy's envoy.service.ext_proc.v3.ExternalProcessor gRPC API,
+// which can change headers and bodies or answer the request itself. URL is
+// grpc:// or grpcs://. Request and response headers are always sent; with
+// RequestBody or ResponseBody that body is buffered, up to MaxBodyBytes
+// (default 1MB), and sent whole. TimeoutMs (default 200) bounds each of
+// the processor's answers. When the processor fails the request gets a
+// 500, unless FailOpen lets it through unprocessed. The global block
+// processes every request; routes opt in with an "extProc" middleware.
+type ExtProcConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	URL          string   `yaml:"url"`
+	TimeoutMs    int      `yaml:"timeoutMs"`
+	FailOpen     bool     `yaml:"failOpen"`
+	RequestBody  bool     `yaml:"requestBody"`
+	ResponseBody bool     `yaml:"responseBody"`
+	MaxBodyBytes int64    `yaml:"maxBodyBytes"`
+	SkipPaths    []string `yaml:"skipPaths"`
+}
+
+func (e ExtProcConfig) validate() error {
+	if !e.Enabled {
+		return nil
+	}
+	if !strings.HasPrefix(e.URL, "grpc://") && !strings.HasPrefix(e.URL, "grpcs://") {
+		return fmt.Errorf("url %q must be grpc:// or grpcs://", e.URL)
+	}
+	if e.TimeoutMs < 0 {
+		return errors.New("timeoutMs cannot be negative")
+	}
+	if e.MaxBodyBytes < 0 {
+		return errors.New("maxBodyBytes cannot be negative")
+	}
+	return nil
+}
+
+// HMACConfig validates HMAC request signatures. Scheme is "simple" (one
+// signature header over method, URI, timestamp and body hash) or
+// "authorization" (SigV4-like Authorization header over signed headers).
+// MaxSkew is in seconds.
+type HMACConfig struct {
+	Enabled         bool           `yaml:"enabled"`
+	Scheme          string         `yaml:"scheme"`
+	Algorithm       string         `yaml:"algorithm"`
+	SignatureHeader string         `yaml:"signatureHeader"`
+	KeyIDHeader     string         `yaml:"keyIDHeader"`
+	TimestampHeader string         `yaml:"timestampHeader"`
+	RequiredHeaders []string       `yaml:"requiredHeaders"`
+	MaxSkew         int            `yaml:"maxSkew"`
+	MaxBodyBytes    int64          `yaml:"maxBodyBytes"`
+	ConsumerHeader  string         `yaml:"consumerHeader"`
+	Consumers       []HMACConsumer `yaml:"consumers"`
+	SkipPaths       []string       `yaml:"skipPaths"`
+}
+
+// HMACConsumer is a signing key. Plan names its rate limit plan (see
+// RateLimitConfig).
+type HMACConsumer struct {
+	ID     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+	Plan   string `yaml:"plan"`
+}
+
+func (h HMACConfig) validate() error {
+	switch h.Scheme {
+	case "simple", "authorization":
+	default:
+		return fmt.Errorf("scheme %q must be simple or authorization", h.Scheme)
+	}
+	switch h.Algorithm {
+	case "", "sha256", "sha512":
+	default:
+		return fmt.Errorf("algorithm %q must be sha256 or sha512", h.Algorithm)
+	}
+	if len(h.Consumers) == 0 {
+		return errors.New("at least one consumer is required")
+	}
+	for _, consumer := range h.Consumers {
+		if consumer.ID == "" || len(consumer.Secret) < 16 {
+			return fmt.Errorf("consumer %q needs an id and a secret of at least 16 characters", consumer.ID)
+		}
+	}
+	if h.MaxSkew < 0 || h.MaxBodyBytes < 0 {
+		return errors.New("maxSkew and maxBodyBytes cannot be negative")
+	}
+	return nil
+}
+
+// UpstreamSignConfig signs every proxied request so backends can reject
+// traffic that bypassed the gateway. Type "hmac" adds the simple-scheme
+// signature (see HMACConfig) with X-Gateway-Timestamp and X-Gateway-Key-ID;
+// type "jwt" adds a short-lived assertion signed with Secret (HS256, HS512)
+// or the PEM private key in KeyFile (RS256, ES256), which may also hold the
+// PEM itself. TTL is in seconds.
+type UpstreamSignConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Type         string `yaml:"type"`
+	Algorithm    string `yaml:"algorithm"`
+	Secret       string `yaml:"secret"`
+	KeyFile      string `yaml:"keyFile"`
+	KeyID        string `yaml:"keyID"`
+	Header       string `yaml:"header"`
+	Issuer       string `yaml:"issuer"`
+	TTL          int    `yaml:"ttl"`
+	MaxBodyBytes int64  `yaml:"maxBodyBytes"`
+}
+
+func (u UpstreamSignConfig) validate() error {
+	switch u.Type {
+	case "", "hmac":
+		switch u.Algorithm {
+		case "", "sha256", "sha512":
+		default:
+			return fmt.Errorf("algorithm %q must be sha256 or sha512", u.Algorithm)
+		}
+		if len(u.Secret) < 16 {
+			return errors.New("secret must be at least 16 characters")
+		}
+	case "jwt":
+		if err := validateSigningKey(u.Algorithm, u.Secret, u.KeyFile); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("type %q must be hmac or jwt", u.Type)
+	}
+	if u.TTL < 0 || u.MaxBodyBytes < 0 {
+		return errors.New("ttl and maxBodyBytes cannot be negative")
+	}
+	return nil
+}
+
+// validateSigningKey checks the settings JWTs are signed with: Secret for
+// HS256 (the default) and HS512, else a KeyFile
+func validateSigningKey(algorithm, secret, keyFile string) error {
+	switch algorithm {
+	case "", "HS256", "HS512":
+		if len(secret) < 16 {
+			return errors.New("secret must be at least 16 characters")
+		}
+	case "RS256", "ES256":
+		if keyFile == "" {
+			return fmt.Errorf("algorithm %s requires a keyFile", algorithm)
+		}
+	default:
+		return fmt.Errorf("algorithm %q must be HS256, HS512, RS256 or ES256", algorithm)
+	}
+	return nil
+}
+
+// TokenRelayConfig decides which credential backends receive in place of
+// the client's Authorization header. Mode "passThrough" forwards it as is,
+// "strip" removes it, "mint" replaces it with a short-lived JWT for the
+// identity the auth middlewares verified, "clientCredentials" with the
+// gateway's own token from TokenURL, and "exchange" with a token TokenURL
+// issues for the client's bearer token (RFC 8693 token exchange).
+//
+// Minted tokens are signed like upstreamSigning's jwt type: with Secret
+// (HS256, HS512) or the PEM private key in KeyFile (RS256, ES256). TTL is
+// in seconds (default 60) and Issuer defaults to "gatekeeper". Tokens are
+// meant for the backend's name, or its entry in Audiences, and sent in
+// Header (default Authorization, as a bearer token). Fetched tokens are
+// cached until shortly before they expire; Timeout is in seconds
+// (default 5).
+type TokenRelayConfig struct {
+	Enabled      bool              `yaml:"enabled"`
+	Mode         string            `yaml:"mode"`
+	Header       string            `yaml:"header"`
+	Algorithm    string            `yaml:"algorithm"`
+	Secret       string            `yaml:"secret"`
+	KeyFile      string            `yaml:"keyFile"`
+	KeyID        string            `yaml:"keyID"`
+	Issuer       string            `yaml:"issuer"`
+	TTL          int               `yaml:"ttl"`
+	TokenURL     string            `yaml:"tokenURL"`
+	ClientID     string            `yaml:"clientID"`
+	ClientSecret string            `yaml:"clientSecret"`
+	Scopes       []string          `yaml:"scopes"`
+	Audiences    map[string]string `yaml:"audiences"`
+	Timeout      int               `yaml:"timeout"`
+}
+
+func (t TokenRelayConfig) validate(backends []Backend) error {
+	switch t.Mode {
+	case "passThrough", "strip":
+	case "mint":
+		if err := validateSigningKey(t.Algorithm, t.Secret, t.KeyFile); err != nil {
+			return err
+		}
+	case "clientCredentials", "exchange":
+		if t.TokenURL == "" {
+			return fmt.Errorf("mode %s needs a tokenURL", t.Mode)
+		}
+		if u, err := url.Parse(t.TokenURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("tokenURL %q must be an http(s) URL", t.TokenURL)
+		}
+	default:
+		return fmt.Errorf("mode %q must be passThrough, strip, mint, clientCredentials or exchange", t.Mode)
+	}
+	if t.TTL < 0 || t.Timeout < 0 {
+		return errors.New("ttl and timeout cannot be negative")
+	}
+	if t.Header != "" && strings.ContainsAny(t.Header, " :\r\n") {
+		return fmt.Errorf("header %q is not a header name", t.Header)
+	}
+	for backend := range t.Audiences {
+		if !slices.ContainsFunc(backends, func(b Backend) bool { return b.Name == backend }) {
+			return fmt.Errorf("audiences: unknown backend %s", backend)
+		}
+	}
+	return nil
+}
+
+// AdminConfig enables the operator API on a separate listener. Keep it on a
+// private address; when Token is set it is required as a bearer token.
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+	Token   string `yaml:"token"`
+}
+
+// TracingConfig exports OpenTelemetry traces over OTLP/HTTP. Endpoint is
+// host:port of the collector (usually port 4318).
+type TracingConfig struct {
+	Enabled     bool    `yaml:"enabled"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	ServiceName string  `yaml:"serviceName"`
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// AccessLogConfig shapes the access log: one line per request, written
+// apart from the application log. Format is "json" (default), "combined"
+// (Apache combined) or "w3c" (W3C extended). Fields picks the JSON fields
+// from AccessLogFields, by default time, method, path, status, duration,
+// remote_ip, user_agent, class and incident. Output is "stdout" (default),
+// "stderr" or a file to append to, rotated as Rotation says. SampleRate
+// (default 1) logs that share of requests; 5xx responses are always
+// logged. Headers adds those request headers to JSON lines, masked as
+// Redaction says.
+type AccessLogConfig struct {
+	Disabled   bool                    `yaml:"disabled"`
+	Format     string                  `yaml:"format"`
+	Fields     []string                `yaml:"fields"`
+	Headers    []string                `yaml:"headers"`
+	Output     string                  `yaml:"output"`
+	SampleRate float64                 `yaml:"sampleRate"`
+	Rotation   AccessLogRotationConfig `yaml:"rotation"`
+}
+
+// AccessLogRotationConfig rotates a file output once it would grow past
+// MaxSizeMB, or when Interval ("hourly" or "daily", in UTC) ends. The
+// rotated file is renamed with the time it was rotated, and gzipped when
+// Compress is set. MaxBackups keeps that many rotated files (default all).
+type AccessLogRotationConfig struct {
+	MaxSizeMB  int    `yaml:"maxSizeMB"`
+	Interval   string `yaml:"interval"`
+	MaxBackups int    `yaml:"maxBackups"`
+	Compress   bool   `yaml:"compress"`
+}
+
+// RedactionConfig masks credentials before they reach the access log or
+// the application log. Headers are masked wherever they show (default
+// Authorization, Proxy-Authorization, Cookie, Set-Cookie and X-API-Key).
+// QueryParams are case-insensitive glob patterns of query parameters
+// whose values are masked (default *token, *secret, *password, api_key,
+// apikey, key, signature, sig, x-amz-signature and x-amz-credential). An
+// empty list masks none. Mask replaces each value (default "[REDACTED]").
+type RedactionConfig struct {
+	Headers     []string `yaml:"headers"`
+	QueryParams []string `yaml:"queryParams"`
+	Mask        string   `yaml:"mask"`
+}
+
+func (r RedactionConfig) validate() error {
+	for _, header := range r.Headers {
+		if header == "" || strings.ContainsAny(header, " :") {
+			return fmt.Errorf("invalid header name %q", header)
+		}
+	}
+	for _, param := range r.QueryParams {
+		if _, err := path.Match(param, ""); err != nil || param == "" {
+			return fmt.Errorf("invalid query parameter pattern %q", param)
+		}
+	}
+	return nil
+}
+
+// EventsConfig publishes one event per request, as JSON, to Kafka or
+// NATS for analytics. Brokers are the addresses to connect to (default
+// localhost:9092 for Kafka, localhost:4222 for NATS) and Topic the Kafka
+// topic or NATS subject (default "gatekeeper.requests"). Events are
+// published in batches of up to BatchSize (default 100), at least every
+// FlushIntervalMs (default 1000). When QueueSize events (default 10000)
+// are waiting, further ones are dropped rather than slowing requests.
+type EventsConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	Broker          string   `yaml:"broker"`
+	Brokers         []string `yaml:"brokers"`
+	Topic           string   `yaml:"topic"`
+	BatchSize       int      `yaml:"batchSize"`
+	FlushIntervalMs int      `yaml:"flushIntervalMs"`
+	QueueSize       int      `yaml:"queueSize"`
+}
+
+func (e EventsConfig) validate() error {
+	if !e.Enabled {
+		return nil
+	}
+	switch e.Broker {
+	case "kafka", "nats":
+	default:
+		return fmt.Errorf("broker must be kafka or nats, got %q", e.Broker)
+	}
+	for _, broker := range e.Brokers {
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			return fmt.Errorf("broker %q must be host:port", broker)
+		}
+	}
+	if strings.ContainsAny(e.Topic, " \t\r\n") {
+		return fmt.Errorf("topic %q must not contain whitespace", e.Topic)
+	}
+	if e.BatchSize < 0 || e.FlushIntervalMs < 0 || e.QueueSize < 0 {
+		return errors.New("batchSize, flushIntervalMs and queueSize cannot be negative")
+	}
+	return nil
+}
+
+// UsageConfig meters requests, errors and response bytes per consumer,
+// the identity authentication verified or "anonymous", in hourly buckets
+// kept for RetentionDays (default 31). Counts are added to the store
+// every FlushInterval seconds (default 10). Store "shared" keeps them in
+// the gateway's storage, so replicas add up and, with Redis or Bolt,
+// counts survive restarts; "memory" (default) keeps them in process.
+type UsageConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Store         string `yaml:"store"`
+	RetentionDays int    `yaml:"retentionDays"`
+	FlushInterval int    `yaml:"flushInterval"`
+}
+
+func (u UsageConfig) validate() error {
+	switch u.Store {
+	case "", "memory", "shared":
+	default:
+		return fmt.Errorf("store %q must be memory or shared", u.Store)
+	}
+	if u.RetentionDays < 0 || u.RetentionDays > 400 {
+		return fmt.Errorf("retentionDays must be between 1 and 400, got %d", u.RetentionDays)
+	}
+	if u.FlushInterval < 0 {
+		return errors.New("flushInterval cannot be negative")
+	}
+	return nil
+}
+
+// QuotaConfig caps the requests each authenticated consumer may make per
+// UTC day and calendar month. Daily and Monthly apply to every consumer and
+// Consumers replaces both for a consumer, by identity such as "hmac:shop";
+// zero means no cap. Anonymous requests are left to the rate limit tiers.
+// Store "shared" counts in the gateway's storage, so replicas share one
+// count and, with Redis or Bolt, counts survive restarts; "memory"
+// (default) counts in process.
+type QuotaConfig struct {
+	Enabled   bool                   `yaml:"enabled"`
+	Store     string                 `yaml:"store"`
+	Daily     int64                  `yaml:"daily"`
+	Monthly   int64                  `yaml:"monthly"`
+	Consumers map[string]QuotaLimits `yaml:"consumers"`
+}
+
+// QuotaLimits are a consumer's own quotas
+type QuotaLimits struct {
+	Daily   int64 `yaml:"daily"`
+	Monthly int64 `yaml:"monthly"`
+}
+
+func (q QuotaConfig) validate() error {
+	switch q.Store {
+	case "", "memory", "shared":
+	default:
+		return fmt.Errorf("store %q must be memory or shared", q.Store)
+	}
+	if q.Daily < 0 || q.Monthly < 0 {
+		return errors.New("daily and monthly cannot be negative")
+	}
+	for consumer, limits := range q.Consumers {
+		if consumer == "" {
+			return errors.New("consumers need an identity")
+		}
+		if limits.Daily < 0 || limits.Monthly < 0 {
+			return fmt.Errorf("consumer %s: daily and monthly cannot be negative", consumer)
+		}
+	}
+	return nil
+}
+
+// RateLimitSvcConfig delegates rate limit decisions to an external service
+// speaking Envoy's envoy.service.ratelimit.v3.RateLimitService gRPC API,
+// such as envoyproxy/ratelimit, so limits are shared with Envoy. URL is
+// grpc:// or grpcs://. Each request is checked with Descriptors in Domain;
+// without descriptors it is checked with the client's IP as
+// remote_address, the last X-Forwarded-For hop with UseForwardedFor.
+// TimeoutMs defaults to 100. When the service cannot be reached requests
+// are allowed, unless FailClosed rejects them.
+type RateLimitSvcConfig struct {
+	Enabled         bool                  `yaml:"enabled"`
+	URL             string                `yaml:"url"`
+	Domain          string                `yaml:"domain"`
+	Descriptors     []RateLimitDescriptor `yaml:"descriptors"`
+	UseForwardedFor bool                  `yaml:"useForwardedFor"`
+	TimeoutMs       int                   `yaml:"timeoutMs"`
+	FailClosed      bool                  `yaml:"failClosed"`
+}
+
+// RateLimitDescriptor is one descriptor sent to the rate limit service,
+// its entries in order. A descriptor with an entry whose value is missing
+// from the request, such as an absent header, is left out.
+type RateLimitDescriptor struct {
+	Entries []RateLimitDescriptorEntry `yaml:"entries"`
+}
+
+// RateLimitDescriptorEntry is a descriptor key with either a fixed Value or
+// one taken From the request: "remoteAddress", "consumer" (the identity
+// authentication verified), "method", "path" or "header:<name>".
+type RateLimitDescriptorEntry struct {
+	Key   string `yaml:"key"`
+	Value string `yaml:"value"`
+	From  string `yaml:"from"`
+}
+
+func (r RateLimitSvcConfig) validate() error {
+	if !r.Enabled {
+		return nil
+	}
+	if !strings.HasPrefix(r.URL, "grpc://") && !strings.HasPrefix(r.URL, "grpcs://") {
+		return fmt.Errorf("url %q must be grpc:// or grpcs://", r.URL)
+	}
+	if r.Domain == "" {
+		return errors.New("domain is required")
+	}
+	if r.TimeoutMs < 0 {
+		return errors.New("timeoutMs cannot be negative")
+	}
+	for i, d := range r.Descriptors {
+		if len(d.Entries) == 0 {
+			return fmt.Errorf("descriptor %d has no entries", i)
+		}
+		for _, e := range d.Entries {
+			if e.Key == "" {
+				return fmt.Errorf("descriptor %d: entries need a key", i)
+			}
+			if (e.Value == "") == (e.From == "") {
+				return fmt.Errorf("descriptor %d: entry %s needs either a value or from", i, e.Key)
+			}
+			switch {
+			case e.From == "", e.From == "remoteAddress", e.From == "consumer", e.From == "method", e.From == "path":
+			case strings.HasPrefix(e.From, "header:") && len(e.From) > len("header:"):
+			default:
+				return fmt.Errorf("descriptor %d: entry %s: from %q must be remoteAddress, consumer, method, path or header:<name>", i, e.Key, e.From)
+			}
+		}
+	}
+	return nil
+}
+
+// RouteAccessLogConfig turns the access log off for a route, or samples
+// it at its own rate
+type RouteAccessLogConfig struct {
+	Disabled   bool    `yaml:"disabled"`
+	SampleRate float64 `yaml:"sampleRate"`
+}
+
+// AccessLogFields are the fields a JSON access log can hold
+var AccessLogFields = []string{
+	"time", "method", "path", "query", "protocol", "host", "status", "bytes",
+	"duration", "duration_ms", "remote_ip", "user_agent", "referer", "route",
+	"request_id", "class", "incident", "consumer",
+}
+
+func (a AccessLogConfig) validate() error {
+	switch a.Format {
+	case "", "json":
+	case "combined", "w3c":
+		if len(a.Fields) > 0 || len(a.Headers) > 0 {
+			return fmt.Errorf("fields and headers only apply to the json format")
+		}
+	default:
+		return fmt.Errorf("format must be json, combined or w3c, got %q", a.Format)
+	}
+	for _, field := range a.Fields {
+		if !slices.Contains(AccessLogFields, field) {
+			return fmt.Errorf("unknown field %q", field)
+		}
+	}
+	if a.SampleRate < 0 || a.SampleRate > 1 {
+		return fmt.Errorf("sampleRate %v must be between 0 and 1", a.SampleRate)
+	}
+
+	r := a.Rotation
+	if r != (AccessLogRotationConfig{}) {
+		switch a.Output {
+		case "", "stdout", "stderr":
+			return fmt.Errorf("rotation needs a file output")
+		}
+	}
+	switch r.Interval {
+	case "", "hourly", "daily":
+	default:
+		return fmt.Errorf("rotation interval must be hourly or daily, got %q", r.Interval)
+	}
+	if r.MaxSizeMB < 0 || r.MaxBackups < 0 {
+		return fmt.Errorf("rotation maxSizeMB and maxBackups must not be negative")
+	}
+	return nil
+}
+
+// MetricsConfig sets the buckets of the Prometheus histograms, to line up
+// with SLO thresholds. DurationBuckets, in seconds, apply to request,
+// route and upstream durations (default Prometheus' 5ms to 10s);
+// SizeBuckets, in bytes, to request and response sizes (default 100 bytes
+// to 100 MB by factors of 10). Buckets must be increasing.
+//
+// Exporter "statsd" also sends every metric to a StatsD or DogStatsD
+// agent; Prometheus' /metrics keeps working either way.
+type MetricsConfig struct {
+	DurationBuckets []float64    `yaml:"durationBuckets"`
+	SizeBuckets     []float64    `yaml:"sizeBuckets"`
+	Exporter        string       `yaml:"exporter"`
+	StatsD          StatsDConfig `yaml:"statsd"`
+}
+
+// StatsDConfig points the StatsD exporter at an agent. Address defaults
+// to 127.0.0.1:8125 and Prefix to "gatekeeper.". Flavor "dogstatsd"
+// (default) sends tags; plain "statsd" folds tag values into the metric
+// name. SampleRate (default 1) samples counters and timings, and
+// SampleRates overrides it by metric name, e.g. "requests". Tags, as
+// "key:value", are added to every metric.
+type StatsDConfig struct {
+	Address     string             `yaml:"address"`
+	Prefix      *string            `yaml:"prefix"`
+	Flavor      string             `yaml:"flavor"`
+	SampleRate  float64            `yaml:"sampleRate"`
+	SampleRates map[string]float64 `yaml:"sampleRates"`
+	Tags        []string           `yaml:"tags"`
+}
+
+func (m MetricsConfig) validate() error {
+	switch m.Exporter {
+	case "", "prometheus", "statsd":
+	default:
+		return fmt.Errorf("exporter must be prometheus or statsd, got %q", m.Exporter)
+	}
+	switch m.StatsD.Flavor {
+	case "", "dogstatsd", "statsd":
+	default:
+		return fmt.Errorf("statsd flavor must be dogstatsd or statsd, got %q", m.StatsD.Flavor)
+	}
+	if m.StatsD.SampleRate < 0 || m.StatsD.SampleRate > 1 {
+		return fmt.Errorf("statsd sampleRate %v must be between 0 and 1", m.StatsD.SampleRate)
+	}
+	for name, rate := range m.StatsD.SampleRates {
+		if rate <= 0 || rate > 1 {
+			return fmt.Errorf("statsd sampleRates %s: %v must be above 0 and at most 1", name, rate)
+		}
+	}
+	for _, tag := range m.StatsD.Tags {
+		if key, _, ok := strings.Cut(tag, ":"); !ok || key == "" {
+			return fmt.Errorf("statsd tag %q must be key:value", tag)
+		}
+	}
+	for name, buckets := range map[string][]float64{"durationBuckets": m.DurationBuckets, "sizeBuckets": m.SizeBuckets} {
+		for i, bound := range buckets {
+			if bound <= 0 {
+				return fmt.Errorf("%s must be positive, got %v", name, bound)
+			}
+			if i > 0 && bound <= buckets[i-1] {
+				return fmt.Errorf("%s must be increasing, got %v after %v", name, bound, buckets[i-1])
+			}
+		}
+	}
+	return nil
+}
+
+// SLOConfig declares a service level objective for a route: Objective
+// percent of its requests must be good over PeriodDays (default 30). A
+// request is bad when it fails with a 5xx or, when LatencyMs is set, takes
+// longer. Route is the route's name, or its path when it has none;
+// "default" covers requests no route matched.
+type SLOConfig struct {
+	Name       string  `yaml:"name"`
+	Route      string  `yaml:"route"`
+	Objective  float64 `yaml:"objective"`
+	LatencyMs  int     `yaml:"latencyMs"`
+	PeriodDays int     `yaml:"periodDays"`
+}
+
+func (s SLOConfig) validate() error {
+	if s.Route == "" {
+		return fmt.Errorf("route is required")
+	}
+	if s.Objective <= 0 || s.Objective >= 100 {
+		return fmt.Errorf("objective %v must be a percentage above 0 and below 100", s.Objective)
+	}
+	if s.LatencyMs < 0 {
+		return fmt.Errorf("latencyMs must not be negative")
+	}
+	if s.PeriodDays < 0 || s.PeriodDays > 90 {
+		return fmt.Errorf("periodDays %d must be between 1 and 90", s.PeriodDays)
+	}
+	return nil
+}
+
+// ConfigSourceConfig loads config from Key in Consul or etcd on top of
+// this file, and reloads whenever the key changes, so a fleet of gateways
+// picks up changes without a redeploy. Type is "consul" or "etcd".
+// Address is the HTTP API of a Consul agent or of etcd's gRPC gateway.
+// Token is a Consul ACL token or an etcd auth token.
+type ConfigSourceConfig struct {
+	Type    string `yaml:"type"`
+	Address string `yaml:"address"`
+	Key     string `yaml:"key"`
+	Token   string `yaml:"token"`
+}
+
+// SecretsConfig names where "vault://path#key" values in the rest of the
+// config are fetched from
+type SecretsConfig struct {
+	Vault VaultConfig `yaml:"vault"`
+}
+
+// VaultConfig reads secrets from the KV version 2 engine at Mount (default
+// "secret") of the Vault server at Address. Token is best given as
+// ${VAULT_TOKEN} or a file:// reference. Secrets are read again every
+// RefreshInterval seconds (default 300), and a changed value reloads the
+// config like SIGHUP. Timeout is in seconds (default 5).
+type VaultConfig struct {
+	Address         string `yaml:"address"`
+	Token           string `yaml:"token"`
+	Namespace       string `yaml:"namespace"`
+	Mount           string `yaml:"mount"`
+	CAFile          string `yaml:"caFile"`
+	Timeout         int    `yaml:"timeout"`
+	RefreshInterval int    `yaml:"refreshInterval"`
+}
+
+// BannerConfig enables injecting an incident banner into proxied HTML pages.
+// Active sets the initial state; the admin API toggles it at runtime.
+type BannerConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Active  bool   `yaml:"active"`
+	HTML    string `yaml:"html"`
+}
+
+// MaintenanceConfig answers requests with a 503 while maintenance is
+// active, on every route or only the named Routes, so backends can be
+// worked on without a restart. Clients in AllowIPs (addresses or CIDRs)
+// still get through; they are identified by the connection unless
+// UseForwardedFor trusts the last X-Forwarded-For hop. Browsers get HTML
+// (a page showing Message by default) and other clients
+// {"error": "maintenance", "message": Message}. RetryAfter is in seconds. Active,
+// Routes and Message set the initial state; the admin API changes them at
+// runtime.
+type MaintenanceConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	Active          bool     `yaml:"active"`
+	Routes          []string `yaml:"routes"`
+	AllowIPs        []string `yaml:"allowIPs"`
+	UseForwardedFor bool     `yaml:"useForwardedFor"`
+	Message         string   `yaml:"message"`
+	HTML            string   `yaml:"html"`
+	RetryAfter      int      `yaml:"retryAfter"`
+}
+
+func (m MaintenanceConfig) validate(routes []RouteConfig) error {
+	for _, entry := range m.AllowIPs {
+		if net.ParseIP(entry) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(entry); err != nil {
+			return fmt.Errorf("allowIPs entry %q is not an address or CIDR", entry)
+		}
+	}
+	if err := KnownRoutes(routes, m.Routes); err != nil {
+		return err
+	}
+	if m.RetryAfter < 0 {
+		return errors.New("retryAfter cannot be negative")
+	}
+	return nil
+}
+
+// BotDetectionConfig scores requests from 0 to 100 by how much they look
+// automated: a missing or automation User-Agent (curl, headless browsers,
+// crawlers and BlockAgents), missing Accept, Accept-Encoding or, for
+// browsers, Accept-Language headers. Requests scoring at least Threshold
+// (default 60) on Routes (every route when empty) are handled by Action:
+// "block" (default) answers 403, "tarpit" holds them for TarpitDelay
+// seconds (default 10) before proxying them. User-Agents containing one of
+// AllowAgents are never scored. ScoreHeader, if set, forwards the score to
+// backends. Clients are identified by the connection unless
+// UseForwardedFor trusts the last X-Forwarded-For hop.
+type BotDetectionConfig struct {
+	Enabled         bool               `yaml:"enabled"`
+	Threshold       int                `yaml:"threshold"`
+	Action          string             `yaml:"action"`
+	TarpitDelay     int                `yaml:"tarpitDelay"`
+	Routes          []string           `yaml:"routes"`
+	AllowAgents     []string           `yaml:"allowAgents"`
+	BlockAgents     []string           `yaml:"blockAgents"`
+	ScoreHeader     string             `yaml:"scoreHeader"`
+	UseForwardedFor bool               `yaml:"useForwardedFor"`
+	Challenge       BotChallengeConfig `yaml:"challenge"`
+	SkipPaths       []string           `yaml:"skipPaths"`
+}
+
+// BotChallengeConfig lets clients prove they are human with a Turnstile or
+// hCaptcha token sent in TokenHeader (default "X-Challenge-Token"). Tokens
+// are checked with the provider's siteverify endpoint, or VerifyURL, using
+// Secret; a client that passes is not scored again for PassTTL seconds
+// (default 1800). Timeout is in seconds (default 5).
+type BotChallengeConfig struct {
+	Provider    string `yaml:"provider"`
+	Secret      string `yaml:"secret"`
+	VerifyURL   string `yaml:"verifyURL"`
+	TokenHeader string `yaml:"tokenHeader"`
+	PassTTL     int    `yaml:"passTTL"`
+	Timeout     int    `yaml:"timeout"`
+}
+
+func (b BotDetectionConfig) validate(routes []RouteConfig) error {
+	if b.Threshold < 0 || b.Threshold > 100 {
+		return errors.New("threshold must be between 0 and 100")
+	}
+	switch b.Action {
+	case "", "block", "tarpit":
+	default:
+		return fmt.Errorf("action %q must be block or tarpit", b.Action)
+	}
+	if b.TarpitDelay < 0 {
+		return errors.New("tarpitDelay cannot be negative")
+	}
+	if err := KnownRoutes(routes, b.Routes); err != nil {
+		return err
+	}
+
+	c := b.Challenge
+	switch c.Provider {
+	case "":
+		return nil
+	case "turnstile", "hcaptcha":
+	default:
+		return fmt.Errorf("challenge provider %q must be turnstile or hcaptcha", c.Provider)
+	}
+	if c.Secret == "" {
+		return errors.New("challenge requires a secret")
+	}
+	if c.VerifyURL != "" {
+		if u, err := url.Parse(c.VerifyURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("challenge verifyURL %q must be an http(s) URL", c.VerifyURL)
+		}
+	}
+	if c.PassTTL < 0 || c.Timeout < 0 {
+		return errors.New("challenge passTTL and timeout cannot be negative")
+	}
+	return nil
+}
+
+// KnownRoutes checks that every name in names is one of routes
+func KnownRoutes(routes []RouteConfig, names []string) error {
+	for _, name := range names {
+		if !slices.ContainsFunc(routes, func(route RouteConfig) bool { return route.Name == name }) {
+			return fmt.Errorf("route %s is not defined", name)
+		}
+	}
+	return nil
+}
+
+// VersioningConfig routes requests by API version. A request's version is
+// its first path segment when that names one of Versions (as in
+// /v2/users), else its Header (default Accept-Version), else Default;
+// requests with none are routed as usual. A version's requests only go to
+// its Backends, and with StripPrefix the version segment is removed from
+// the path before routing. Backends always learn the version from Header.
+// Versions that are not listed get a 400.
+type VersioningConfig struct {
+	Enabled     bool         `yaml:"enabled"`
+	Header      string       `yaml:"header"`
+	Default     string       `yaml:"default"`
+	StripPrefix bool         `yaml:"stripPrefix"`
+	Versions    []APIVersion `yaml:"versions"`
+}
+
+// APIVersion is one version of the API and the backends that serve it
+type APIVersion struct {
+	Name        string             `yaml:"name"`
+	Backends    []string           `yaml:"backends"`
+	Deprecation *DeprecationConfig `yaml:"deprecation"`
+}
+
+func (v VersioningConfig) validate(backends []Backend) error {
+	known := make(map[string]bool, len(backends))
+	for _, backend := range backends {
+		known[backend.Name] = true
+	}
+	if len(v.Versions) == 0 {
+		return errors.New("versioning requires versions")
+	}
+	names := make(map[string]bool, len(v.Versions))
+	for _, version := range v.Versions {
+		if version.Name == "" || strings.ContainsAny(version.Name, "/ ") {
+			return fmt.Errorf("version name %q must be one path segment", version.Name)
+		}
+		if names[version.Name] {
+			return fmt.Errorf("version %s is listed twice", version.Name)
+		}
+		names[version.Name] = true
+		if len(version.Backends) == 0 {
+			return fmt.Errorf("version %s requires backends", version.Name)
+		}
+		for _, backend := range version.Backends {
+			if !known[backend] {
+				return fmt.Errorf("version %s: unknown backend %q", version.Name, backend)
+			}
+		}
+		if version.Deprecation != nil {
+			if err := version.Deprecation.validate(); err != nil {
+				return fmt.Errorf("version %s: %w", version.Name, err)
+			}
+		}
+	}
+	if v.Default != "" && !names[v.Default] {
+		return fmt.Errorf("default version %s is not one of the versions", v.Default)
+	}
+	return nil
+}
+
+// DeprecationConfig marks a route or API version as deprecated. Its
+// responses carry a Deprecation header (RFC 9745) dated Since, a Sunset
+// header (RFC 8594) dated Sunset and a Link to the migration guide at Link.
+// Dates are "2006-01-02" or RFC 3339; without Since, Deprecation is "true".
+type DeprecationConfig struct {
+	Since  string `yaml:"since"`
+	Sunset string `yaml:"sunset"`
+	Link   string `yaml:"link"`
+}
+
+// Dates returns Since and Sunset, zero when not set
+func (d DeprecationConfig) Dates() (since, sunset time.Time, err error) {
+	if since, err = parseDate(d.Since); err != nil {
+		return since, sunset, fmt.Errorf("deprecation since: %w", err)
+	}
+	if sunset, err = parseDate(d.Sunset); err != nil {
+		return since, sunset, fmt.Errorf("deprecation sunset: %w", err)
+	}
+	return since, sunset, nil
+}
+
+func (d DeprecationConfig) validate() error {
+	since, sunset, err := d.Dates()
+	if err != nil {
+		return err
+	}
+	if !since.IsZero() && !sunset.IsZero() && sunset.Before(since) {
+		return errors.New("deprecation sunset cannot be before since")
+	}
+	if d.Link != "" {
+		if u, err := url.Parse(d.Link); err != nil || !u.IsAbs() {
+			return fmt.Errorf("deprecation link %q must be an absolute URL", d.Link)
+		}
+	}
+	return nil
+}
+
+// parseDate reads a "2006-01-02" date or an RFC 3339 time
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date like 2006-01-02 or an RFC 3339 time", value)
+	}
+	return t, nil
+}
+
+// ParseURL returns the proxy URL with the configured credentials applied
+func (p *BackendProxyConfig) ParseURL() (*url.URL, error) {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("proxy url %q must use http, https or socks5", p.URL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy url %q has no host", p.URL)
+	}
+	if p.Username != "" {
+		u.User = url.UserPassword(p.Username, p.Password)
+	}
+	return u, nil
+}
+
+// UpstreamTLSConfig tunes TLS to HTTPS backends. Sessions are cached so
+// new connections can resume instead of doing a full handshake;
+// SessionCacheSize defaults to 256 sessions.
+type UpstreamTLSConfig struct {
+	SessionCacheSize         int  `yaml:"sessionCacheSize"`
+	DisableSessionResumption bool `yaml:"disableSessionResumption"`
+}
+
+// IncidentConfig opens an incident when at least Threshold (default 0.25)
+// of the requests in the last Window seconds failed with a 5xx, given at
+// least MinRequests. It stays open for at least MinDuration seconds and
+// until the ratio falls below half the threshold. While open, responses
+// carry X-Incident-ID, negative caches serve entries up to StaleTTL seconds
+// past expiry and requests classified below ShedBelowPriority get a 503.
+type IncidentConfig struct {
+	Enabled           bool    `yaml:"enabled"`
+	Threshold         float64 `yaml:"threshold"`
+	Window            int     `yaml:"window"`
+	MinRequests       int     `yaml:"minRequests"`
+	MinDuration       int     `yaml:"minDuration"`
+	StaleTTL          int     `yaml:"staleTTL"`
+	ShedBelowPriority int     `yaml:"shedBelowPriority"`
+}
+
+// DrainConfig controls graceful shutdown. Draining starts on SIGTERM or
+// through the admin API: /health then reports "draining" with a 503 so load
+// balancers stop sending traffic, and new requests get a 503 with
+// "Connection: close". Shutdown waits up to Timeout seconds (default 30)
+// for requests in flight before closing the listeners.
+type DrainConfig struct {
+	Timeout int `yaml:"timeout"`
+}
+
+// EdgeHealthConfig serves a health endpoint for global traffic managers
+// (GSLB, DNS failover) at Path (default /edge-health). It reports the
+// healthy share of backend capacity, by weight, and a weight from 0 to 100
+// for weighted DNS. Below DegradedBelow (default 0.75) the region is
+// "degraded" and answers DegradedStatus (default 200); below FailBelow
+// (default 0.5) it is "fail" and answers 503, so traffic moves away before
+// the last backend is gone. An open incident degrades the region and halves
+// its weight, or fails it with FailDuringIncident.
+type EdgeHealthConfig struct {
+	Enabled            bool    `yaml:"enabled"`
+	Path               string  `yaml:"path"`
+	Region             string  `yaml:"region"`
+	DegradedBelow      float64 `yaml:"degradedBelow"`
+	FailBelow          float64 `yaml:"failBelow"`
+	DegradedStatus     int     `yaml:"degradedStatus"`
+	FailDuringIncident bool    `yaml:"failDuringIncident"`
+}
+
+// DebugEchoConfig serves a debugging endpoint at Path (default
+// /debug/echo). A request for Path/rest goes through the middlewares and
+// routes as a request for /rest would, and is answered with what the
+// gateway would have proxied, and where, instead of being proxied. The
+// client IP shown is the one the rate limits act on: the last
+// X-Forwarded-For hop with rateLimit.useForwardedFor. Headers and query
+// parameters the redaction settings cover are masked.
+type DebugEchoConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+// OpenAPIConfig adds a route for every operation in an OpenAPI 3 spec
+// (YAML or JSON), after the routes listed in the config. BasePath is put in
+// front of the spec's paths and defaults to the path of its first server
+// URL. With Validate, parameters and JSON bodies are checked against the
+// spec and invalid requests get a 400 listing the problems; bodies over
+// MaxBodyBytes (default 1MB) get a 413. Generated routes run the named
+// Middlewares.
+type OpenAPIConfig struct {
+	Spec         string   `yaml:"spec"`
+	BasePath     *string  `yaml:"basePath"`
+	Validate     bool     `yaml:"validate"`
+	MaxBodyBytes int64    `yaml:"maxBodyBytes"`
+	Middlewares  []string `yaml:"middlewares"`
+}
+
+// IdempotencyConfig replays the stored response when a request repeats an
+// Idempotency-Key (Header) already used by the same client, instead of
+// proxying it again. Keys are kept for TTL seconds (default 86400); a
+// request still in flight holds its key for at most LockTimeout seconds
+// (default 60). Methods defaults to POST. Request and response bodies over
+// MaxBodyBytes (default 1MB) are refused and not stored respectively. Store
+// is "memory" (default), "redis" or "shared".
+type IdempotencyConfig struct {
+	Enabled      bool        `yaml:"enabled"`
+	Header       string      `yaml:"header"`
+	Methods      []string    `yaml:"methods"`
+	TTL          int         `yaml:"ttl"`
+	LockTimeout  int         `yaml:"lockTimeout"`
+	MaxBodyBytes int64       `yaml:"maxBodyBytes"`
+	Store        string      `yaml:"store"`
+	Redis        RedisConfig `yaml:"redis"`
+	SkipPaths    []string    `yaml:"skipPaths"`
+}
+
+func (i IdempotencyConfig) validate() error {
+	if i.TTL < 0 || i.LockTimeout < 0 || i.MaxBodyBytes < 0 {
+		return errors.New("ttl, lockTimeout and maxBodyBytes cannot be negative")
+	}
+	return validateStore(i.Store, i.Redis)
+}
+
+// LintConfig checks the config against the organization policy in
+// PolicyFile at startup and on reload, refusing configs that break a rule.
+// Environment selects rules limited to environments, e.g. "prod".
+type LintConfig struct {
+	PolicyFile  string `yaml:"policyFile"`
+	Environment string `yaml:"environment"`
+}
+
+// CORSConfig answers cross-origin requests from browsers. AllowedOrigins
+// entries are exact origins, "*", or subdomain wildcards such as
+// "https://*.example.com". AllowedHeaders empty or "*" allows whatever
+// headers a preflight asks for. ExposedHeaders are response headers scripts
+// may read. MaxAge is how long, in seconds, browsers may cache a preflight
+// answer. A route's cors block replaces the global one
+// for requests matching the route; Enabled only applies to the global one.
+type CORSConfig struct {
+	Enabled          bool     `yaml:"enabled"`
+	AllowedOrigins   []string `yaml:"allowedOrigins"`
+	AllowedMethods   []string `yaml:"allowedMethods"`
+	AllowedHeaders   []string `yaml:"allowedHeaders"`
+	ExposedHeaders   []string `yaml:"exposedHeaders"`
+	AllowCredentials bool     `yaml:"allowCredentials"`
+	MaxAge           int      `yaml:"maxAge"`
+}
+
+func (c *CORSConfig) validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return errors.New("cors allowCredentials cannot be combined with origin *")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			return fmt.Errorf("cors origin %q must be scheme://host[:port]", origin)
+		}
+		if strings.Contains(strings.TrimPrefix(u.Host, "*."), "*") {
+			return fmt.Errorf("cors origin %q may only use * as the leftmost label", origin)
+		}
+	}
+	return nil
+}
+
+// RequestAgeConfig stamps each request with its arrival time and forwards
+// it to backends in Header (default X-Request-Start, as "t=<microseconds>").
+// With MaxQueueTimeMs set, requests that spent longer than that inside the
+// gateway before reaching a backend are rejected with 503 rather than
+// proxied, since the client has most likely given up on them.
+type RequestAgeConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	Header         string `yaml:"header"`
+	MaxQueueTimeMs int    `yaml:"maxQueueTimeMs"`
+}
+
+// LoadBalancingConfig tunes how requests are spread over backends.
+// Algorithm is "round_robin" (default), "weighted_round_robin", "random",
+// "least_connections", "p2c", "least_response_time" or "hash"; p2c and
+// least_response_time prefer backends with a lower moving average of
+// response times, and hash sends requests with the same HashKey to the
+// same backend (see BalancerConfig). SlowStart is the number of seconds
+// over which a backend that becomes healthy again ramps from a tenth of
+// its share of traffic to all of it (0, the default, is off).
+type LoadBalancingConfig struct {
+	Algorithm string         `yaml:"algorithm"`
+	HashKey   string         `yaml:"hashKey"`
+	SlowStart int            `yaml:"slowStart"`
+	Failover  FailoverConfig `yaml:"failover"`
+}
+
+// HealthCheckConfig schedules the active health checks of backends: one
+// round at startup, then one every Interval seconds (default 30), each
+// moved by up to Jitter of the interval either way (0 to 1, default 0.1)
+// so that gateways started together do not check in step. A check fails
+// after Timeout seconds (default 5).
+type HealthCheckConfig struct {
+	Interval int     `yaml:"interval"`
+	Jitter   float64 `yaml:"jitter"`
+	Timeout  int     `yaml:"timeout"`
+}
+
+// BalancerConfig overrides the global Algorithm and HashKey for a route.
+// HashKey is what the hash algorithm hashes: "ip" (default, the client
+// address), "path", or "header:NAME", "cookie:NAME" or "query:NAME" for
+// that value of the request. Requests without it are balanced round robin.
+type BalancerConfig struct {
+	Algorithm string `yaml:"algorithm"`
+	HashKey   string `yaml:"hashKey"`
+}
+
+func (b BalancerConfig) validate() error {
+	switch b.Algorithm {
+	case "", "round_robin", "weighted_round_robin", "random", "least_connections", "p2c", "least_response_time", "hash":
+	default:
+		return fmt.Errorf("algorithm %q must be round_robin, weighted_round_robin, random, least_connections, p2c, least_response_time or hash", b.Algorithm)
+	}
+	kind, name, _ := strings.Cut(b.HashKey, ":")
+	switch kind {
+	case "", "ip", "path":
+		if name != "" {
+			return fmt.Errorf("hashKey %q takes no name", b.HashKey)
+		}
+	case "header", "cookie", "query":
+		if name == "" {
+			return fmt.Errorf("hashKey %q needs a name, as in %s:NAME", b.HashKey, kind)
+		}
+	default:
+		return fmt.Errorf("hashKey %q must be ip, path, header:NAME, cookie:NAME or query:NAME", b.HashKey)
+	}
+	return nil
+}
+
+// FailoverConfig moves traffic to backup backends when no primary backend
+// is healthy or, with ErrorRate set (0 to 1), when more than that share of
+// the primaries' responses over the last Window seconds (default 30) were
+// 5xx. Traffic moves back once the primaries have been fine for HoldTime
+// seconds (default 30).
+type FailoverConfig struct {
+	ErrorRate float64 `yaml:"errorRate"`
+	Window    int     `yaml:"window"`
+	HoldTime  int     `yaml:"holdTime"`
+}
+
+// HedgingConfig sends a second copy of a GET or HEAD request to another
+// healthy backend when the first has not answered within DelayMs (default
+// 100). The first response wins and the other request is cancelled.
+type HedgingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	DelayMs int  `yaml:"delayMs"`
+}
+
+// BulkheadConfig caps in-flight requests, both across the gateway
+// (MaxConcurrent, 0 for no cap) and per group of backends, so one slow
+// backend cannot tie up every connection. Requests over a cap wait in a
+// queue of QueueDepth for up to QueueTimeoutMs before getting a 503.
+type BulkheadConfig struct {
+	Enabled        bool                  `yaml:"enabled"`
+	MaxConcurrent  int                   `yaml:"maxConcurrent"`
+	QueueDepth     int                   `yaml:"queueDepth"`
+	QueueTimeoutMs int                   `yaml:"queueTimeoutMs"`
+	Groups         []BulkheadGroupConfig `yaml:"groups"`
+}
+
+// BulkheadGroupConfig caps requests to the named backends together. Zero
+// queue settings inherit the global ones.
+type BulkheadGroupConfig struct {
+	Name           string   `yaml:"name"`
+	Backends       []string `yaml:"backends"`
+	MaxConcurrent  int      `yaml:"maxConcurrent"`
+	QueueDepth     int      `yaml:"queueDepth"`
+	QueueTimeoutMs int      `yaml:"queueTimeoutMs"`
+}
+
+// SyntheticsConfig sends probe requests through the gateway's own handler
+// every Interval seconds, so a broken middleware or route shows up even
+// when backends are healthy.
+type SyntheticsConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval int           `yaml:"interval"`
+	Probes   []ProbeConfig `yaml:"probes"`
+}
+
+// NotificationsConfig sends alerts to Webhooks when a backend goes down or
+// comes back, when no healthy backend is left or one is again, when
+// traffic fails over to backup backends or back, and when a client hits an
+// auto-ban honeypot. An alert identical to
+// one sent in the last DedupWindow seconds (default 300) is dropped, and
+// each webhook gets at most MaxPerMinute alerts a minute (default 20).
+type NotificationsConfig struct {
+	Webhooks     []WebhookConfig `yaml:"webhooks"`
+	DedupWindow  int             `yaml:"dedupWindow"`
+	MaxPerMinute int             `yaml:"maxPerMinute"`
+}
+
+// WebhookConfig is one alert destination. Type is "generic" (default),
+// which posts the alert as JSON to URL with Headers, "slack", which posts
+// a message to a Slack incoming webhook URL, or "pagerduty", which
+// triggers and resolves incidents through the Events API v2 with
+// RoutingKey (URL defaults to PagerDuty's). Events limits the alerts sent
+// to those listed; all are sent by default.
+type WebhookConfig struct {
+	Name       string            `yaml:"name"`
+	Type       string            `yaml:"type"`
+	URL        string            `yaml:"url"`
+	RoutingKey string            `yaml:"routingKey"`
+	Headers    map[string]string `yaml:"headers"`
+	Events     []string          `yaml:"events"`
+}
+
+// NotificationEvents are the alerts NotificationsConfig can send, each
+// raising or clearing a problem
+var NotificationEvents = []string{
+	"backend_down", "backend_up",
+	"no_healthy_backends", "backends_available",
+	"failover", "failback",
+	"honeypot_hit",
+}
+
+// ProbeConfig is one synthetic request and what a passing response looks
+// like. ExpectStatus defaults to 200; ExpectBody must appear in the body
+// when set. Timeout is in seconds.
+type ProbeConfig struct {
+	Name         string            `yaml:"name"`
+	Method       string            `yaml:"method"`
+	Host         string            `yaml:"host"`
+	Path         string            `yaml:"path"`
+	Headers      map[string]string `yaml:"headers"`
+	Body         string            `yaml:"body"`
+	ExpectStatus int               `yaml:"expectStatus"`
+	ExpectBody   string            `yaml:"expectBody"`
+	Timeout      int               `yaml:"timeout"`
+}
+
+// ContractTest is a request "gatekeeper test" sends through the gateway
+// with every backend replaced by a stub, and the outcome it must have.
+// Method defaults to GET and Host to localhost. RemoteAddr sets the
+// client's address for IP-based policies.
+type ContractTest struct {
+	Name       string            `yaml:"name"`
+	Method     string            `yaml:"method"`
+	Host       string            `yaml:"host"`
+	Path       string            `yaml:"path"`
+	Headers    map[string]string `yaml:"headers"`
+	Body       string            `yaml:"body"`
+	RemoteAddr string            `yaml:"remoteAddr"`
+	Upstream   ContractUpstream  `yaml:"upstream"`
+	Expect     ContractExpect    `yaml:"expect"`
+}
+
+// ContractUpstream is the response the stubbed backends give. Status
+// defaults to 200.
+type ContractUpstream struct {
+	Status  int               `yaml:"status"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+}
+
+// ContractExpect is what a passing test looks like; unset fields are not
+// checked. Backend names the backend the request must reach, or "none" if
+// the gateway must answer it itself. UpstreamPath and UpstreamHeaders are
+// what that backend receives. Body must appear in the response body.
+type ContractExpect struct {
+	Status          int               `yaml:"status"`
+	Backend         string            `yaml:"backend"`
+	UpstreamPath    string            `yaml:"upstreamPath"`
+	UpstreamHeaders map[string]string `yaml:"upstreamHeaders"`
+	Headers         map[string]string `yaml:"headers"`
+	Body            string            `yaml:"body"`
+}
+
+// PathNormalizeConfig cleans request paths before routing: percent-encoded
+// unreserved characters are decoded, "//" collapsed and "." and ".."
+// resolved. Requests that climb above the root, or whose ".." leaves a
+// route's pathPrefix, are rejected. It is on unless explicitly disabled.
+// RejectEncodedSlash, also on by default, refuses %2F and %5C, which
+// backends disagree on. With it off they are forwarded encoded, and still
+// count as separators for the checks above.
+type PathNormalizeConfig struct {
+	Enabled            bool `yaml:"enabled"`
+	RejectEncodedSlash bool `yaml:"rejectEncodedSlash"`
+}
+
+// ConnectConfig decides what happens to CONNECT requests. Disabled, they
+// are refused with 405. Enabled, the gateway tunnels to targets matching
+// AllowedTargets ("host:port", "*.example.com:443", "db.internal:*") and,
+// when Users are listed, requires Proxy-Authorization Basic credentials.
+// Timeouts are in seconds.
+type ConnectConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	AllowedTargets []string      `yaml:"allowedTargets"`
+	Users          []ConnectUser `yaml:"users"`
+	DialTimeout    int           `yaml:"dialTimeout"`
+	IdleTimeout    int           `yaml:"idleTimeout"`
+}
+
+type ConnectUser struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// TCPProxyConfig forwards raw TCP connections on Address, for protocols
+// such as PostgreSQL or MQTT that cannot go through the HTTP routes. Each
+// connection goes to one of Backends, whose URLs are tcp://host:port,
+// picked with Algorithm like the HTTP load balancer does (default
+// round_robin). Dialing a backend gives up after ConnectTimeout seconds
+// (default 5), and connections idle in both directions for IdleTimeout
+// seconds (default 3600) are closed. TCP proxies are set up at startup and
+// are not changed by reloads.
+//
+// With SNI routes, the proxy reads the TLS ClientHello, within
+// ConnectTimeout, and only balances over the backends of the first route
+// matching its server name. TLS is still not terminated. Connections that
+// match no route, or do not start with a ClientHello, are closed.
+type TCPProxyConfig struct {
+	Name           string               `yaml:"name"`
+	Address        string               `yaml:"address"`
+	Backends       []Backend            `yaml:"backends"`
+	Algorithm      string               `yaml:"algorithm"`
+	ConnectTimeout int                  `yaml:"connectTimeout"`
+	IdleTimeout    int                  `yaml:"idleTimeout"`
+	HealthCheck    TCPHealthCheckConfig `yaml:"healthCheck"`
+	SNI            []SNIRoute           `yaml:"sni"`
+}
+
+// SNIRoute sends TLS connections for Hosts to Backends, which name entries
+// of the proxy's backends. A "*." prefix matches any subdomain, and "*"
+// matches any server name, including none.
+type SNIRoute struct {
+	Hosts    []string `yaml:"hosts"`
+	Backends []string `yaml:"backends"`
+}
+
+// TCPHealthCheckConfig checks TCP proxy backends by opening a connection
+// every Interval seconds (default 10). A backend that does not accept
+// within Timeout seconds (default 2) is taken out of rotation until it
+// does again.
+type TCPHealthCheckConfig struct {
+	Interval int `yaml:"interval"`
+	Timeout  int `yaml:"timeout"`
+}
+
+func (p TCPProxyConfig) validate() error {
+	if _, _, err := net.SplitHostPort(p.Address); err != nil {
+		return fmt.Errorf("address %q must be host:port", p.Address)
+	}
+	if len(p.Backends) == 0 {
+		return errors.New("at least one backend is required")
+	}
+	names := make(map[string]bool, len(p.Backends))
+	for _, backend := range p.Backends {
+		if backend.Name == "" {
+			return errors.New("every backend needs a name")
+		}
+		if names[backend.Name] {
+			return fmt.Errorf("backend %s is listed twice", backend.Name)
+		}
+		names[backend.Name] = true
+		if len(backend.Servers) > 0 {
+			return fmt.Errorf("backend %s: servers are not supported for TCP backends", backend.Name)
+		}
+		u, err := url.Parse(backend.URL)
+		if err != nil || u.Scheme != "tcp" || u.Hostname() == "" || u.Port() == "" {
+			return fmt.Errorf("backend %s: url %q must be tcp://host:port", backend.Name, backend.URL)
+		}
+	}
+	switch p.Algorithm {
+	case "", "round_robin", "weighted_round_robin", "random", "least_connections":
+	default:
+		return fmt.Errorf("algorithm %q must be round_robin, weighted_round_robin, random or least_connections", p.Algorithm)
+	}
+	if p.ConnectTimeout < 0 || p.IdleTimeout < 0 || p.HealthCheck.Interval < 0 || p.HealthCheck.Timeout < 0 {
+		return errors.New("timeouts and intervals cannot be negative")
+	}
+	for i, route := range p.SNI {
+		if len(route.Hosts) == 0 || len(route.Backends) == 0 {
+			return fmt.Errorf("sni route %d needs hosts and backends", i)
+		}
+		for _, host := range route.Hosts {
+			if host == "" || (host != "*" && strings.Contains(strings.TrimPrefix(host, "*."), "*")) {
+				return fmt.Errorf("sni route %d: host %q must be a name, *.domain or *", i, host)
+			}
+		}
+		for _, backend := range route.Backends {
+			if !names[backend] {
+				return fmt.Errorf("sni route %d: backend %s is not one of the proxy's backends", i, backend)
+			}
+		}
+	}
+	return nil
+}
+
+// RouteConfig matches requests by host, method and path. Path is a gorilla
+// mux template ("/users/{id}") matched exactly; PathPrefix matches a subtree;
+// Glob uses "*" for one segment and "**" for any number ("/files/**").
+// Headers and Query must match too: each header or query parameter needs
+// the given value, or with "*" to be present with any value. Routes are
+// tried by descending Priority, then most specific first (see
+// OrderedRoutes), then in the order they are listed, before the default proxy.
+// RateLimitCost is how many tokens a request to the route takes from the
+// rate limits (default 1), so expensive endpoints use up more of them.
+// When, Allow and SetHeaders are CEL expressions over the request (see
+// package expr): When is a further match condition, a request for which
+// Allow is false gets a 403, and SetHeaders sets each header on the
+// upstream request to its expression's value, removing it when "".
+type RouteConfig struct {
+	Name              string                   `yaml:"name"`
+	Host              string                   `yaml:"host"`
+	Path              string                   `yaml:"path"`
+	PathPrefix        string                   `yaml:"pathPrefix"`
+	Glob              string                   `yaml:"glob"`
+	Priority          int                      `yaml:"priority"`
+	Methods           []string                 `yaml:"methods"`
+	Headers           map[string]string        `yaml:"headers"`
+	Query             map[string]string        `yaml:"query"`
+	Redirect          *RedirectConfig          `yaml:"redirect"`
+	ClientCert        *RouteClientCertConfig   `yaml:"clientCert"`
+	NegativeCache     *NegativeCacheConfig     `yaml:"negativeCache"`
+	Coalesce          *CoalesceConfig          `yaml:"coalesce"`
+	BodySchema        *BodySchemaConfig        `yaml:"bodySchema"`
+	ResponseTransform *ResponseTransformConfig `yaml:"responseTransform"`
+	Aggregate         *AggregateConfig         `yaml:"aggregate"`
+	GRPCTranscode     *GRPCTranscodeConfig     `yaml:"grpcTranscode"`
+	Static            *StaticConfig            `yaml:"static"`
+	HeaderLimits      *HeaderLimitsConfig      `yaml:"headerLimits"`
+	LargeBodies       *LargeBodiesConfig       `yaml:"largeBodies"`
+	Middlewares       []string                 `yaml:"middlewares"`
+	CORS              *CORSConfig              `yaml:"cors"`
+	LoadBalancing     *BalancerConfig          `yaml:"loadBalancing"`
+	AccessLog         *RouteAccessLogConfig    `yaml:"accessLog"`
+	RateLimitCost     int                      `yaml:"rateLimitCost"`
+	When              string                   `yaml:"when"`
+	Allow             string                   `yaml:"allow"`
+	SetHeaders        map[string]string        `yaml:"setHeaders"`
+	Deprecation       *DeprecationConfig       `yaml:"deprecation"`
+
+	// Operation is set on routes generated from the OpenAPI spec
+	Operation *openapi.Operation `yaml:"-"`
+}
+
+func (r RouteConfig) validateExpressions() error {
+	if r.When != "" {
+		if _, err := expr.Compile(r.When); err != nil {
+			return fmt.Errorf("when: %w", err)
+		}
+	}
+	if r.Allow != "" {
+		if _, err := expr.Compile(r.Allow); err != nil {
+			return fmt.Errorf("allow: %w", err)
+		}
+	}
+	for header, value := range r.SetHeaders {
+		if header == "" || strings.ContainsAny(header, " :\r\n") {
+			return fmt.Errorf("setHeaders: %q is not a header name", header)
+		}
+		if _, err := expr.CompileString(value); err != nil {
+			return fmt.Errorf("setHeaders %s: %w", header, err)
+		}
+	}
+	return nil
+}
+
+// MiddlewareConfigs are named middleware instances that routes list, in
+// order, in their Middlewares pipeline. Route pipelines run after the
+// global middlewares and are rebuilt when the config is reloaded.
+type MiddlewareConfigs map[string]MiddlewareConfig
+
+// MiddlewareConfig is one middleware instance. Type picks the middleware
+// and the block of the same name holds its settings; logging and metrics
+// take none. An instance is shared by every route that lists it, so a
+// rateLimit instance on two routes is one budget.
+type MiddlewareConfig struct {
+	Type          string               `yaml:"type"`
+	RateLimit     *RateLimitConfig     `yaml:"rateLimit"`
+	OIDC          *OIDCConfig          `yaml:"oidc"`
+	SAML          *SAMLConfig          `yaml:"saml"`
+	SPNEGO        *SPNEGOConfig        `yaml:"spnego"`
+	LDAP          *LDAPConfig          `yaml:"ldap"`
+	Introspection *IntrospectionConfig `yaml:"introspection"`
+	HMAC          *HMACConfig          `yaml:"hmac"`
+	ExtAuthz      *ExtAuthzConfig      `yaml:"extAuthz"`
+	Bulkhead      *BulkheadConfig      `yaml:"bulkhead"`
+	Idempotency   *IdempotencyConfig   `yaml:"idempotency"`
+	RateLimitSvc  *RateLimitSvcConfig  `yaml:"rateLimitService"`
+	ExtProc       *ExtProcConfig       `yaml:"extProc"`
+}
+
+// Validate checks that Type is known and its settings block is present
+func (m MiddlewareConfig) Validate() error {
+	var configured bool
+	switch m.Type {
+	case "logging", "metrics":
+		return nil
+	case "rateLimit":
+		if m.RateLimit != nil {
+			if err := m.RateLimit.validate(); err != nil {
+				return err
+			}
+		}
+		configured = m.RateLimit != nil
+	case "oidc":
+		if m.OIDC != nil {
+			if err := m.OIDC.validate(false); err != nil {
+				return err
+			}
+		}
+		configured = m.OIDC != nil
+	case "saml":
+		if m.SAML != nil {
+			if err := m.SAML.validate(); err != nil {
+				return err
+			}
+		}
+		configured = m.SAML != nil
+	case "spnego":
+		if m.SPNEGO != nil {
+			if err := m.SPNEGO.validate(); err != nil {
+				return err
+			}
+		}
+		configured = m.SPNEGO != nil
+	case "ldap":
+		if m.LDAP != nil {
+			if err := m.LDAP.validate(); err != nil {
+				return err
+			}
+		}
+		configured = m.LDAP != nil
+	case "introspection":
+		if m.Introspection != nil {
+			if err := m.Introspection.validate(); err != nil {
+				return err
+			}
+		}
+		configured = m.Introspection != nil
+	case "hmac":
+		if m.HMAC != nil {
+			if err := m.HMAC.validate(); err != nil {
+				return err
+			}
+		}
+		configured = m.HMAC != nil
+	case "extAuthz":
+		if m.ExtAuthz != nil {
+			if err := m.ExtAuthz.validate(); err != nil {
+				return err
+			}
+		}
+		configured = m.ExtAuthz != nil
+	case "bulkhead":
+		if m.Bulkhead != nil && m.Bulkhead.MaxConcurrent <= 0 {
+			return errors.New("bulkhead maxConcurrent must be positive")
+		}
+		configured = m.Bulkhead != nil
+	case "idempotency":
+		if m.Idempotency != nil {
+			if err := m.Idempotency.validate(); err != nil {
+				return err
+			}
+		}
+		configured = m.Idempotency != nil
+	case "rateLimitService":
+		if m.RateLimitSvc != nil {
+			svc := *m.RateLimitSvc
+			svc.Enabled = true
+			if err := svc.validate(); err != nil {
+				return err
+			}
+		}
+		configured = m.RateLimitSvc != nil
+	case "extProc":
+		if m.ExtProc != nil {
+			proc := *m.ExtProc
+			proc.Enabled = true
+			if err := proc.validate(); err != nil {
+				return err
+			}
+		}
+		configured = m.ExtProc != nil
+	case "":
+		return errors.New("type is required")
+	default:
+		return fmt.Errorf("unknown type %q", m.Type)
+	}
+	if !configured {
+		return fmt.Errorf("%s middleware requires a %s block", m.Type, m.Type)
+	}
+	return nil
+}
+
+// CoalesceConfig collapses concurrent identical anonymous GET and HEAD
+// requests on a route into one backend call whose response goes to every
+// waiting client. Requests differing in a VaryHeaders header are kept
+// apart; Accept-Encoding always is. Responses over MaxBodyBytes (default
+// 1MB) are not shared and waiters make their own call.
+type CoalesceConfig struct {
+	VaryHeaders  []string `yaml:"varyHeaders"`
+	MaxBodyBytes int      `yaml:"maxBodyBytes"`
+}
+
+// BodySchemaConfig checks request bodies on a route against a JSON Schema
+// file. Mode "block" (default) rejects invalid bodies with a 400 listing
+// the problems; "log" only logs them and lets the request through. Bodies
+// over MaxBodyBytes (default 1MB) get a 413 in either mode. Requests
+// without a body are not checked.
+type BodySchemaConfig struct {
+	File         string `yaml:"file"`
+	Mode         string `yaml:"mode"`
+	MaxBodyBytes int64  `yaml:"maxBodyBytes"`
+}
+
+func (b *BodySchemaConfig) validate() error {
+	switch b.Mode {
+	case "", "block", "log":
+	default:
+		return fmt.Errorf("bodySchema mode %q must be block or log", b.Mode)
+	}
+	if b.File == "" {
+		return errors.New("bodySchema requires a file")
+	}
+	_, err := openapi.LoadSchema(b.File)
+	return err
+}
+
+// AggregateConfig answers a route itself by sending a GET for every part to
+// its backend in parallel and merging the JSON responses into one object,
+// each under the part's Key. A part's Path is a template like a redirect's
+// To and defaults to the request path; the client's query and headers are
+// passed on. OnFailure "partial" (default) answers with null for parts that
+// failed and an "_errors" object saying why; "fail" answers 502 instead.
+// Timeout (seconds, default 10) bounds the whole fan-out and MaxBodyBytes
+// (default 1MB) each part's response.
+type AggregateConfig struct {
+	Parts        []AggregatePart `yaml:"parts"`
+	OnFailure    string          `yaml:"onFailure"`
+	Timeout      int             `yaml:"timeout"`
+	MaxBodyBytes int64           `yaml:"maxBodyBytes"`
+}
+
+type AggregatePart struct {
+	Key     string `yaml:"key"`
+	Backend string `yaml:"backend"`
+	Path    string `yaml:"path"`
+}
+
+func (a *AggregateConfig) validate(backends []Backend) error {
+	switch a.OnFailure {
+	case "", "partial", "fail":
+	default:
+		return fmt.Errorf("aggregate onFailure %q must be partial or fail", a.OnFailure)
+	}
+	if len(a.Parts) == 0 {
+		return errors.New("aggregate requires parts")
+	}
+
+	known := make(map[string]bool, len(backends))
+	for _, backend := range backends {
+		known[backend.Name] = true
+	}
+	keys := make(map[string]bool, len(a.Parts))
+	for _, part := range a.Parts {
+		switch {
+		case part.Key == "" || part.Key == "_errors":
+			return fmt.Errorf("aggregate part key %q is not allowed", part.Key)
+		case keys[part.Key]:
+			return fmt.Errorf("aggregate part %s is defined twice", part.Key)
+		case !known[part.Backend]:
+			return fmt.Errorf("aggregate part %s: unknown backend %q", part.Key, part.Backend)
+		}
+		keys[part.Key] = true
+	}
+	return nil
+}
+
+// GRPCTranscodeConfig answers a route by calling a unary gRPC method on
+// Backend, described by DescriptorSet (a binary FileDescriptorSet from
+// protoc --include_imports --descriptor_set_out). Each binding maps an HTTP
+// method and path template to a method ("package.Service/Method"): {field}
+// segments and query parameters set request fields and the JSON body fills
+// the rest. Without bindings, every unary method is served at POST
+// .../package.Service/Method. The response message comes back as JSON and
+// gRPC errors as the matching HTTP status. Timeout is in seconds (default
+// 10) and MaxBodyBytes (default 1MB) bounds request bodies.
+type GRPCTranscodeConfig struct {
+	Backend       string        `yaml:"backend"`
+	DescriptorSet string        `yaml:"descriptorSet"`
+	Bindings      []GRPCBinding `yaml:"bindings"`
+	Timeout       int           `yaml:"timeout"`
+	MaxBodyBytes  int64         `yaml:"maxBodyBytes"`
+}
+
+// StaticConfig answers a route with files from Dir instead of proxying. The
+// path below PathPrefix (the whole path for other routes) names the file,
+// and directories serve their Index (default index.html). With SPA, paths
+// that match no file and have no extension get the root index, so
+// client-side routes survive a reload. Files are sent with Cache-Control
+// max-age MaxAge in seconds (default 0, revalidate every time), while
+// index pages always revalidate so new deploys show up. Dotfiles are never
+// served and directory listings only with Listing.
+type StaticConfig struct {
+	Dir     string `yaml:"dir"`
+	Index   string `yaml:"index"`
+	SPA     bool   `yaml:"spa"`
+	MaxAge  int    `yaml:"maxAge"`
+	Listing bool   `yaml:"listing"`
+}
+
+func (s *StaticConfig) validate() error {
+	if s.Dir == "" {
+		return errors.New("static requires a dir")
+	}
+	if info, err := os.Stat(s.Dir); err != nil {
+		return fmt.Errorf("static: %w", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("static: %s is not a directory", s.Dir)
+	}
+	if strings.ContainsAny(s.Index, `/\`) {
+		return fmt.Errorf("static index %q must be a file name", s.Index)
+	}
+	if s.MaxAge < 0 {
+		return errors.New("static maxAge cannot be negative")
+	}
+	return nil
+}
+
+// GRPCBinding maps requests to a gRPC method. Method is the HTTP method
+// (default POST).
+type GRPCBinding struct {
+	Method string `yaml:"method"`
+	Path   string `yaml:"path"`
+	RPC    string `yaml:"rpc"`
+}
+
+func (g *GRPCTranscodeConfig) validate(backends []Backend) error {
+	known := false
+	for _, backend := range backends {
+		known = known || backend.Name == g.Backend
+	}
+	if !known {
+		return fmt.Errorf("grpcTranscode: unknown backend %q", g.Backend)
+	}
+	if g.DescriptorSet == "" {
+		return errors.New("grpcTranscode requires a descriptorSet")
+	}
+	set, err := transcode.Load(g.DescriptorSet)
+	if err != nil {
+		return fmt.Errorf("grpcTranscode: %w", err)
+	}
+	for _, binding := range g.Bindings {
+		switch binding.Method {
+		case "", "GET", "POST", "PUT", "PATCH", "DELETE":
+		default:
+			return fmt.Errorf("grpcTranscode binding %s: method %q is not supported", binding.Path, binding.Method)
+		}
+		if _, err := transcode.ParseTemplate(binding.Path); err != nil {
+			return fmt.Errorf("grpcTranscode binding: %w", err)
+		}
+		if _, err := set.Method(binding.RPC); err != nil {
+			return fmt.Errorf("grpcTranscode binding %s: %w", binding.Path, err)
+		}
+	}
+	return nil
+}
+
+// ResponseTransformConfig rewrites a route's JSON response bodies before
+// they reach the client. Fields in Drop are removed and fields in Mask get
+// MaskWith (default "***") as their value. A plain name matches the key at
+// any depth; a dotted path such as "user.ssn" matches from the top, passing
+// through arrays. Rename gives fields a new key and Inject adds top-level
+// keys to object bodies. JSON responses over MaxBodyBytes (default 1MB) or
+// that do not parse cannot be rewritten, so they are replaced by a 502
+// rather than leak what they hold. Other content types pass through.
+type ResponseTransformConfig struct {
+	Drop         []string               `yaml:"drop"`
+	Mask         []string               `yaml:"mask"`
+	MaskWith     string                 `yaml:"maskWith"`
+	Rename       map[string]string      `yaml:"rename"`
+	Inject       map[string]interface{} `yaml:"inject"`
+	MaxBodyBytes int64                  `yaml:"maxBodyBytes"`
+}
+
+func (t *ResponseTransformConfig) validate() error {
+	fields := append(append([]string(nil), t.Drop...), t.Mask...)
+	for from, to := range t.Rename {
+		if to == "" || strings.Contains(to, ".") {
+			return fmt.Errorf("responseTransform rename of %q must be a plain key", from)
+		}
+		fields = append(fields, from)
+	}
+	for _, f := range fields {
+		if f == "" || strings.HasPrefix(f, ".") || strings.HasSuffix(f, ".") || strings.Contains(f, "..") {
+			return fmt.Errorf("responseTransform field %q is not a key or dotted path", f)
+		}
+	}
+	return nil
+}
+
+// HeaderLimitsConfig caps the total size of the headers a route forwards:
+// MaxRequestBytes those sent to the backend and MaxResponseBytes those sent
+// back to the client (0 means no cap). A header's size is its name and
+// value plus four bytes, per value. Over the cap, the largest headers are
+// dropped until the rest fit, instead of failing the request. Critical
+// headers are never dropped, on top of built-in ones such as
+// Authorization, Cookie, Set-Cookie, Location and Content-*. When they
+// alone are over the cap, the request gets a 431 and a response becomes a
+// 502.
+type HeaderLimitsConfig struct {
+	MaxRequestBytes  int      `yaml:"maxRequestBytes"`
+	MaxResponseBytes int      `yaml:"maxResponseBytes"`
+	Critical         []string `yaml:"critical"`
+}
+
+func (h *HeaderLimitsConfig) validate() error {
+	if h.MaxRequestBytes < 0 || h.MaxResponseBytes < 0 {
+		return errors.New("headerLimits sizes cannot be negative")
+	}
+	if h.MaxRequestBytes == 0 && h.MaxResponseBytes == 0 {
+		return errors.New("headerLimits requires maxRequestBytes or maxResponseBytes")
+	}
+	for _, name := range h.Critical {
+		if name == "" || strings.ContainsAny(name, " :\t") {
+			return fmt.Errorf("headerLimits critical header %q is not a header name", name)
+		}
+	}
+	return nil
+}
+
+// LargeBodiesConfig is an experimental copy path for routes that serve
+// large downloads, such as media. Response bodies are copied from the
+// backend to the client with pooled buffers of BufferSize bytes (default
+// 256KB, between 4KB and 16MB), so each gigabyte takes far fewer read and
+// write calls than with the default 32KB buffers.
+type LargeBodiesConfig struct {
+	BufferSize int `yaml:"bufferSize"`
+}
+
+func (l *LargeBodiesConfig) validate() error {
+	if l.BufferSize != 0 && (l.BufferSize < 4<<10 || l.BufferSize > 16<<20) {
+		return fmt.Errorf("largeBodies bufferSize %d must be between 4KB and 16MB", l.BufferSize)
+	}
+	return nil
+}
+
+// NegativeCacheConfig briefly caches error responses from a route's backend
+// so a storm of requests for the same missing key costs one backend call
+// per TTL. Only anonymous GET and HEAD requests are cached, and responses
+// marked no-store or private never are. Statuses defaults to 404; TTL is in
+// seconds.
+type NegativeCacheConfig struct {
+	Statuses     []int `yaml:"statuses"`
+	TTL          int   `yaml:"ttl"`
+	MaxEntries   int   `yaml:"maxEntries"`
+	MaxBodyBytes int   `yaml:"maxBodyBytes"`
+}
+
+// RouteClientCertConfig requires a verified client certificate on a route.
+// When OUs or SANs are listed the certificate must carry at least one of
+// each; SANs match DNS names, email addresses, IPs and URIs exactly. The
+// listener then asks for certificates without demanding them (clientAuth
+// defaults to verify_if_given), so other routes stay open.
+type RouteClientCertConfig struct {
+	OUs  []string `yaml:"ous"`
+	SANs []string `yaml:"sans"`
+}
+
+// RedirectConfig answers matching requests with a redirect instead of
+// proxying them. To is a template that may use {scheme}, {host}, {path},
+// {rest} (the path below PathPrefix), {query} and any Path variables.
+// Without To, the request URL is redirected with Scheme, Host (a template
+// of its own, such as "www.{host}") and TrailingSlash ("add" or "remove")
+// applied; requests already in that form are proxied.
+type RedirectConfig struct {
+	To            string `yaml:"to"`
+	Status        int    `yaml:"status"`
+	Scheme        string `yaml:"scheme"`
+	Host          string `yaml:"host"`
+	TrailingSlash string `yaml:"trailingSlash"`
+	DropQuery     bool   `yaml:"dropQuery"`
+}
+
+// Path is the config file Load reads: GATEKEEPER_CONFIG, or config.yaml.
+// Files ending in .json are read as JSON and .toml as TOML.
+func Path() string {
+	return getEnv("GATEKEEPER_CONFIG", "config.yaml")
+}
+
+// Overrides are settings given on the command line. They win over the
+// config file, whose settings win over environment variables.
+type Overrides struct {
+	Address  string
+	LogLevel string
+}
+
+var overrides Overrides
+
+// SetOverrides makes Load apply o to every config it loads, reloads
+// included
+func SetOverrides(o Overrides) {
+	overrides = o
+}
+
+// Load reads the config file at Path over the defaults and environment
+// variables. A missing config.yaml is fine when GATEKEEPER_CONFIG is not
+// set; a file that was named but cannot be read is an error.
+func Load() (*Config, error) {
+	return LoadWithRemote(nil)
+}
+
+// LoadWithRemote is Load with remote, the YAML or JSON document held by the
+// configSource, decoded over the config file. Its keys replace the file's:
+// lists such as routes are replaced whole, and maps such as middlewares
+// are merged.
+func LoadWithRemote(remote []byte) (*Config, error) {
+	path := Path()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && os.Getenv("GATEKEEPER_CONFIG") == "" {
+		return parse(path, nil, remote)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	return parse(path, data, remote)
+}
+
+// LoadFile is Load with the config file at path, which has to exist
+func LoadFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	return parse(path, data, nil)
 }
 
-type ServerConfig struct {
-	Address      string `yaml:"address"`
-	ReadTimeout  int    `yaml:"readTimeout"`
-	WriteTimeout int    `yaml:"writeTimeout"`
-	IdleTimeout  int    `yaml:"idleTimeout"`
+// Parse is Load with data, a YAML or JSON document, in place of the config
+// file. Environment variables still fill in what it leaves out.
+func Parse(data []byte) (*Config, error) {
+	return parse("inline config", data, nil)
 }
 
-type Backend struct {
-	Name   string `yaml:"name"`
-	URL    string `yaml:"url"`
-	Weight int    `yaml:"weight"`
-	Health string `yaml:"health"`
+// Validate checks a config built in code the way Load checks the ones it
+// reads
+func (c *Config) Validate() error {
+	if err := c.validateFields(); err != nil {
+		return err
+	}
+	return c.validate()
 }
 
-type RateLimitConfig struct {
-	RequestsPerMinute int `yaml:"requestsPerMinute"`
-	BurstSize         int `yaml:"burstSize"`
+// decodeError lists every mistake found while decoding a config, one
+// "line N: ..." entry each
+type decodeError []string
+
+func (e decodeError) Error() string {
+	return "config errors:\n  " + strings.Join(e, "\n  ")
 }
 
-func Load() (*Config, error) {
+// decode decodes a YAML config into cfg after resolving placeholders (see
+// resolve). Keys that match no field are errors, so a typo such as
+// "ratelimit:" is not silently ignored; they are looked for in the file as
+// written, where "${PORT}" would not fit a number yet.
+func decode(data []byte, cfg *Config) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+
+	var errs decodeError
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var typeErr *yaml.TypeError
+	if err := dec.Decode(&Config{}); errors.As(err, &typeErr) {
+		for _, e := range typeErr.Errors {
+			if strings.Contains(e, " not found in type ") {
+				errs = append(errs, e)
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if unresolved := resolve(&root); len(unresolved) > 0 {
+		errs = append(errs, unresolved...)
+	} else if err := root.Decode(cfg); errors.As(err, &typeErr) {
+		errs = append(errs, typeErr.Errors...)
+	} else if err != nil {
+		return err
+	}
+
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errorLine(errs[i]) < errorLine(errs[j]) })
+		return errs
+	}
+	return nil
+}
+
+// errorLine is the line a "line N: ..." message is about
+func errorLine(message string) int {
+	if m := yamlLine.FindStringSubmatch(message); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return line
+	}
+	return 0
+}
+
+func parse(path string, data, remote []byte) (*Config, error) {
 	cfg := &Config{
 		Server: ServerConfig{
 			Address:      getEnv("GATEKEEPER_ADDRESS", ":8080"),
@@ -45,15 +2759,37 @@ func Load() (*Config, error) {
 			RequestsPerMinute: getEnvInt("GATEKEEPER_RATE_LIMIT", 100),
 			BurstSize:         getEnvInt("GATEKEEPER_BURST_SIZE", 10),
 		},
+		Admin: AdminConfig{
+			Address: "127.0.0.1:9901",
+		},
+		PathNormalize: PathNormalizeConfig{
+			Enabled:            true,
+			RejectEncodedSlash: true,
+		},
+		Journal: JournalConfig{
+			Path:      "gatekeeper.journal",
+			Sync:      true,
+			MaxSizeMB: 64,
+		},
 		LogLevel: getEnv("GATEKEEPER_LOG_LEVEL", "info"),
 	}
 
-	// Try to load from config file
-	configFile := getEnv("GATEKEEPER_CONFIG", "config.yaml")
-	if data, err := os.ReadFile(configFile); err == nil {
-		if err := yaml.Unmarshal(data, cfg); err != nil {
-			return nil, err
-		}
+	data, err := toYAML(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := decode(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := decode(remote, cfg); err != nil {
+		return nil, fmt.Errorf("config source: %w", err)
+	}
+
+	if overrides.Address != "" {
+		cfg.Server.Address = overrides.Address
+	}
+	if overrides.LogLevel != "" {
+		cfg.LogLevel = overrides.LogLevel
 	}
 
 	// Set default backends if none configured
@@ -68,9 +2804,612 @@ func Load() (*Config, error) {
 		}
 	}
 
+	if err := cfg.validateFields(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.AddOpenAPIRoutes(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
 	return cfg, nil
 }
 
+// validateFields checks the required settings and value ranges of a
+// loaded config; validate checks how settings fit together
+func (c *Config) validateFields() error {
+	if c.Server.Address == "" {
+		return errors.New("server address is required")
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 {
+		return errors.New("server timeouts cannot be negative")
+	}
+	if c.RateLimit.RequestsPerMinute < 0 || c.RateLimit.BurstSize < 0 {
+		return errors.New("rateLimit requestsPerMinute and burstSize cannot be negative")
+	}
+	if err := c.RateLimit.validate(); err != nil {
+		return err
+	}
+
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		return fmt.Errorf("logLevel %q must be debug, info, warn or error", c.LogLevel)
+	}
+
+	for i, backend := range c.Backends {
+		if backend.Name == "" {
+			return fmt.Errorf("backend %d: name is required", i+1)
+		}
+		if backend.Weight < 0 {
+			return fmt.Errorf("backend %s: weight cannot be negative", backend.Name)
+		}
+		if len(backend.Servers) > 0 {
+			if backend.URL != "" {
+				return fmt.Errorf("backend %s: has both url and servers", backend.Name)
+			}
+			continue
+		}
+		if backend.URL == "" {
+			return fmt.Errorf("backend %s: url is required", backend.Name)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, instance := range Instances(c.Backends) {
+		if names[instance.Name] {
+			return fmt.Errorf("backend %s is defined twice", instance.Name)
+		}
+		names[instance.Name] = true
+		if u, err := url.Parse(instance.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("backend %s: url %q must be http:// or https:// with a host", instance.Name, instance.URL)
+		}
+		if instance.Weight < 0 {
+			return fmt.Errorf("backend %s: weight cannot be negative", instance.Name)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	if hsts := c.Server.HSTS; hsts.Preload && (hsts.MaxAge < 31536000 || !hsts.IncludeSubdomains) {
+		return errors.New("hsts preload requires maxAge of at least 31536000 and includeSubdomains")
+	}
+
+	if c.OpenAPI.Validate && c.OpenAPI.Spec == "" {
+		return errors.New("openapi validate requires a spec")
+	}
+
+	if err := (BalancerConfig{Algorithm: c.LoadBalancing.Algorithm, HashKey: c.LoadBalancing.HashKey}).validate(); err != nil {
+		return fmt.Errorf("loadBalancing %w", err)
+	}
+	if c.LoadBalancing.SlowStart < 0 {
+		return errors.New("loadBalancing slowStart cannot be negative")
+	}
+	failover := c.LoadBalancing.Failover
+	if failover.ErrorRate < 0 || failover.ErrorRate > 1 {
+		return fmt.Errorf("loadBalancing failover errorRate %v must be between 0 and 1", failover.ErrorRate)
+	}
+	if failover.Window < 0 || failover.HoldTime < 0 {
+		return errors.New("loadBalancing failover window and holdTime cannot be negative")
+	}
+
+	if hc := c.HealthCheck; hc.Interval < 0 || hc.Timeout < 0 {
+		return errors.New("healthCheck interval and timeout cannot be negative")
+	} else if hc.Jitter < 0 || hc.Jitter > 1 {
+		return fmt.Errorf("healthCheck jitter %v must be between 0 and 1", hc.Jitter)
+	}
+
+	if err := c.AccessLog.validate(); err != nil {
+		return fmt.Errorf("accessLog: %w", err)
+	}
+
+	if err := c.Redaction.validate(); err != nil {
+		return fmt.Errorf("redaction: %w", err)
+	}
+
+	if err := c.Events.validate(); err != nil {
+		return fmt.Errorf("events: %w", err)
+	}
+
+	if c.Sessions.Enabled {
+		if err := c.Sessions.validate(); err != nil {
+			return fmt.Errorf("sessions: %w", err)
+		}
+	}
+
+	if err := c.Usage.validate(); err != nil {
+		return fmt.Errorf("usage: %w", err)
+	}
+
+	if err := c.Quota.validate(); err != nil {
+		return fmt.Errorf("quota: %w", err)
+	}
+
+	if err := c.RateLimitSvc.validate(); err != nil {
+		return fmt.Errorf("rateLimitService: %w", err)
+	}
+
+	if err := c.ExtProc.validate(); err != nil {
+		return fmt.Errorf("extProc: %w", err)
+	}
+
+	if err := c.Metrics.validate(); err != nil {
+		return fmt.Errorf("metrics: %w", err)
+	}
+
+	slos := make(map[string]bool)
+	for i, slo := range c.SLOs {
+		if slo.Name == "" {
+			return fmt.Errorf("slo %d: name is required", i)
+		}
+		if slos[slo.Name] {
+			return fmt.Errorf("slo %s is declared twice", slo.Name)
+		}
+		slos[slo.Name] = true
+		if err := slo.validate(); err != nil {
+			return fmt.Errorf("slo %s: %w", slo.Name, err)
+		}
+	}
+
+	if err := c.Notifications.validate(); err != nil {
+		return fmt.Errorf("notifications: %w", err)
+	}
+
+	switch src := c.Source; src.Type {
+	case "":
+	case "consul", "etcd":
+		if u, err := url.Parse(src.Address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("configSource address %q must be http:// or https:// with a host", src.Address)
+		}
+		if src.Key == "" {
+			return errors.New("configSource requires a key")
+		}
+	default:
+		return fmt.Errorf("configSource type %q must be consul or etcd", src.Type)
+	}
+
+	if v := c.Secrets.Vault; v.Address != "" {
+		if u, err := url.Parse(v.Address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("secrets vault address %q must be http:// or https:// with a host", v.Address)
+		}
+		if v.Token == "" {
+			return errors.New("secrets vault requires a token")
+		}
+		if v.Timeout < 0 || v.RefreshInterval < 0 {
+			return errors.New("secrets vault timeout and refreshInterval cannot be negative")
+		}
+	}
+
+	if c.Drain.Timeout < 0 {
+		return errors.New("drain timeout cannot be negative")
+	}
+
+	if e := c.EdgeHealth; e.Enabled {
+		if e.DegradedBelow < 0 || e.DegradedBelow > 1 || e.FailBelow < 0 || e.FailBelow > 1 {
+			return errors.New("edgeHealth thresholds must be between 0 and 1")
+		}
+		if e.DegradedBelow != 0 && e.FailBelow > e.DegradedBelow {
+			return errors.New("edgeHealth failBelow must not be above degradedBelow")
+		}
+		if e.Path != "" && !strings.HasPrefix(e.Path, "/") {
+			return errors.New("edgeHealth path must start with /")
+		}
+	}
+
+	if c.LDAP.Enabled {
+		if err := c.LDAP.validate(); err != nil {
+			return fmt.Errorf("ldap: %w", err)
+		}
+	}
+
+	if c.OIDC.Enabled {
+		if err := c.OIDC.validate(c.Sessions.Enabled); err != nil {
+			return fmt.Errorf("oidc: %w", err)
+		}
+	}
+
+	if c.SAML.Enabled {
+		if err := c.SAML.validate(); err != nil {
+			return fmt.Errorf("saml: %w", err)
+		}
+	}
+
+	if c.SPNEGO.Enabled {
+		if err := c.SPNEGO.validate(); err != nil {
+			return fmt.Errorf("spnego: %w", err)
+		}
+	}
+
+	if c.Introspection.Enabled {
+		if err := c.Introspection.validate(); err != nil {
+			return fmt.Errorf("introspection: %w", err)
+		}
+	}
+
+	if c.ExtAuthz.Enabled {
+		if err := c.ExtAuthz.validate(); err != nil {
+			return fmt.Errorf("extAuthz: %w", err)
+		}
+	}
+
+	if c.HMAC.Enabled {
+		if err := c.HMAC.validate(); err != nil {
+			return fmt.Errorf("hmac: %w", err)
+		}
+	}
+
+	if c.UpstreamSign.Enabled {
+		if err := c.UpstreamSign.validate(); err != nil {
+			return fmt.Errorf("upstreamSigning: %w", err)
+		}
+	}
+
+	if c.Idempotency.Enabled {
+		if err := c.Idempotency.validate(); err != nil {
+			return fmt.Errorf("idempotency: %w", err)
+		}
+	}
+
+	if c.TokenRelay.Enabled {
+		if err := c.TokenRelay.validate(c.Backends); err != nil {
+			return fmt.Errorf("tokenRelay: %w", err)
+		}
+	}
+
+	if err := c.RateLimit.validatePlans(c.HMAC); err != nil {
+		return fmt.Errorf("rateLimit: %w", err)
+	}
+
+	if c.AutoBan.Enabled {
+		if err := c.AutoBan.validate(); err != nil {
+			return fmt.Errorf("autoBan: %w", err)
+		}
+	}
+
+	if t := c.Tarpit; t.Enabled && (t.Delay < 0 || t.MaxConcurrent < 0) {
+		return errors.New("tarpit delay and maxConcurrent cannot be negative")
+	}
+
+	if c.Versioning.Enabled {
+		if err := c.Versioning.validate(c.Backends); err != nil {
+			return fmt.Errorf("versioning: %w", err)
+		}
+	}
+
+	if c.Maintenance.Enabled {
+		if err := c.Maintenance.validate(c.Routes); err != nil {
+			return fmt.Errorf("maintenance: %w", err)
+		}
+	}
+
+	if c.BotDetection.Enabled {
+		if err := c.BotDetection.validate(c.Routes); err != nil {
+			return fmt.Errorf("botDetection: %w", err)
+		}
+	}
+
+	if e := c.DebugEcho; e.Enabled && e.Path != "" && (!strings.HasPrefix(e.Path, "/") || e.Path == "/" || strings.HasSuffix(e.Path, "/")) {
+		return fmt.Errorf("debugEcho path %q must start with / and not end with one", e.Path)
+	}
+
+	switch c.Storage.Type {
+	case "", "memory":
+	case "redis":
+		if c.Storage.Redis.Address == "" {
+			return errors.New("redis storage requires an address")
+		}
+	case "bolt":
+		if c.Storage.Bolt.Path == "" {
+			return errors.New("bolt storage requires a path")
+		}
+	default:
+		return fmt.Errorf("storage type %q must be memory, redis or bolt", c.Storage.Type)
+	}
+
+	if err := c.Server.HTTP2.validate(); err != nil {
+		return err
+	}
+	if c.Server.HTTP3.Enabled && !c.Server.TLS.Enabled() {
+		return errors.New("http3 requires TLS on server.address")
+	}
+	if c.Server.HTTP3.AltSvcMaxAge < 0 {
+		return errors.New("http3 altSvcMaxAge cannot be negative")
+	}
+	addresses := map[string]bool{c.Server.Address: true}
+	for _, l := range c.Server.Listeners {
+		if addresses[l.Address] {
+			return fmt.Errorf("listener address %q is used twice", l.Address)
+		}
+		addresses[l.Address] = true
+		if err := l.HTTP2.validate(); err != nil {
+			return fmt.Errorf("listener %s: %w", l.Address, err)
+		}
+	}
+
+	proxies := make(map[string]bool, len(c.TCPProxies))
+	for _, p := range c.TCPProxies {
+		if p.Name == "" {
+			return errors.New("every tcpProxies entry needs a name")
+		}
+		if proxies[p.Name] {
+			return fmt.Errorf("tcp proxy %s is defined twice", p.Name)
+		}
+		proxies[p.Name] = true
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("tcp proxy %s: %w", p.Name, err)
+		}
+		if addresses[p.Address] {
+			return fmt.Errorf("tcp proxy %s: address %q is already used by a listener", p.Name, p.Address)
+		}
+		addresses[p.Address] = true
+	}
+
+	for _, target := range c.Connect.AllowedTargets {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return fmt.Errorf("connect allowedTargets entry %q must be host:port", target)
+		}
+	}
+
+	for _, backend := range c.Backends {
+		switch backend.Protocol {
+		case "", "auto", "http1", "http2", "grpc":
+		default:
+			return fmt.Errorf("backend %s: protocol %q must be auto, http1, http2 or grpc", backend.Name, backend.Protocol)
+		}
+		if backend.MaxConnections < 0 || backend.QueueDepth < 0 || backend.QueueTimeoutMs < 0 {
+			return fmt.Errorf("backend %s: maxConnections, queueDepth and queueTimeoutMs cannot be negative", backend.Name)
+		}
+		switch backend.Overflow {
+		case "", "queue", "spill":
+		default:
+			return fmt.Errorf("backend %s: overflow %q must be queue or spill", backend.Name, backend.Overflow)
+		}
+		if err := (BalancerConfig{Algorithm: backend.Algorithm}).validate(); err != nil {
+			return fmt.Errorf("backend %s: %w", backend.Name, err)
+		}
+		if backend.Proxy == nil {
+			continue
+		}
+		if _, err := backend.Proxy.ParseURL(); err != nil {
+			return fmt.Errorf("backend %s: %w", backend.Name, err)
+		}
+	}
+
+	grouped := make(map[string]string)
+	for _, group := range c.Bulkhead.Groups {
+		if group.MaxConcurrent <= 0 {
+			return fmt.Errorf("bulkhead group %s: maxConcurrent must be positive", group.Name)
+		}
+		for _, backend := range group.Backends {
+			if other, ok := grouped[backend]; ok {
+				return fmt.Errorf("backend %s is in bulkhead groups %s and %s", backend, other, group.Name)
+			}
+			grouped[backend] = group.Name
+		}
+	}
+
+	probes := make(map[string]bool)
+	for _, probe := range c.Synthetics.Probes {
+		if probe.Path != "" && !strings.HasPrefix(probe.Path, "/") {
+			return fmt.Errorf("synthetic probe %s: path must start with /", probe.Name)
+		}
+		if probe.Name != "" && probes[probe.Name] {
+			return fmt.Errorf("synthetic probe %s is defined twice", probe.Name)
+		}
+		probes[probe.Name] = true
+	}
+
+	tests := make(map[string]bool)
+	for i, test := range c.Tests {
+		if test.Name == "" {
+			return fmt.Errorf("test %d: name is required", i+1)
+		}
+		if tests[test.Name] {
+			return fmt.Errorf("test %s is defined twice", test.Name)
+		}
+		tests[test.Name] = true
+		if !strings.HasPrefix(test.Path, "/") {
+			return fmt.Errorf("test %s: path must start with /", test.Name)
+		}
+	}
+
+	if c.CORS.Enabled {
+		if err := c.CORS.validate(); err != nil {
+			return err
+		}
+	}
+
+	for name, m := range c.Middlewares {
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("middleware %s: %w", name, err)
+		}
+	}
+
+	for i, route := range c.Routes {
+		name := route.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		for _, m := range route.Middlewares {
+			if _, ok := c.Middlewares[m]; !ok {
+				return fmt.Errorf("route %s: middleware %s is not defined", name, m)
+			}
+		}
+		if countSet(route.Path, route.PathPrefix, route.Glob) > 1 {
+			return fmt.Errorf("route %s: path, pathPrefix and glob are mutually exclusive", name)
+		}
+		if _, err := route.Pattern(); err != nil {
+			return fmt.Errorf("route %s: %w", name, err)
+		}
+		for header := range route.Headers {
+			if header == "" || strings.ContainsAny(header, " :\r\n") {
+				return fmt.Errorf("route %s: headers: %q is not a header name", name, header)
+			}
+		}
+		for param := range route.Query {
+			if param == "" {
+				return fmt.Errorf("route %s: query parameter names cannot be empty", name)
+			}
+		}
+		if route.Redirect != nil {
+			if err := route.Redirect.validate(); err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+		if route.CORS != nil {
+			if err := route.CORS.validate(); err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+		if route.LoadBalancing != nil {
+			if err := route.LoadBalancing.validate(); err != nil {
+				return fmt.Errorf("route %s: loadBalancing %w", name, err)
+			}
+		}
+		if route.Deprecation != nil {
+			if err := route.Deprecation.validate(); err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+		if route.RateLimitCost < 0 {
+			return fmt.Errorf("route %s: rateLimitCost cannot be negative", name)
+		}
+		if err := route.validateExpressions(); err != nil {
+			return fmt.Errorf("route %s: %w", name, err)
+		}
+		if route.AccessLog != nil && (route.AccessLog.SampleRate < 0 || route.AccessLog.SampleRate > 1) {
+			return fmt.Errorf("route %s: accessLog sampleRate %v must be between 0 and 1", name, route.AccessLog.SampleRate)
+		}
+		if route.BodySchema != nil {
+			if err := route.BodySchema.validate(); err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+		if route.ResponseTransform != nil {
+			if err := route.ResponseTransform.validate(); err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+		if route.HeaderLimits != nil {
+			if err := route.HeaderLimits.validate(); err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+		if route.LargeBodies != nil {
+			if err := route.LargeBodies.validate(); err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+		if route.Aggregate != nil {
+			if err := route.Aggregate.validate(c.Backends); err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+		if route.GRPCTranscode != nil {
+			if route.Aggregate != nil {
+				return fmt.Errorf("route %s: aggregate and grpcTranscode cannot be combined", name)
+			}
+			if err := route.GRPCTranscode.validate(c.Backends); err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+		if route.Static != nil {
+			if route.Aggregate != nil || route.GRPCTranscode != nil {
+				return fmt.Errorf("route %s: static cannot be combined with aggregate or grpcTranscode", name)
+			}
+			if err := route.Static.validate(); err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+		if route.ClientCert != nil {
+			if err := c.Server.TLS.allowRouteClientCerts(); err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+		if route.NegativeCache != nil {
+			for _, status := range route.NegativeCache.Statuses {
+				if status < 400 || status > 599 {
+					return fmt.Errorf("route %s: negativeCache status %d is not an error status", name, status)
+				}
+			}
+		}
+	}
+	return checkRouteConflicts(c.Routes)
+}
+
+func (n NotificationsConfig) validate() error {
+	if n.DedupWindow < 0 || n.MaxPerMinute < 0 {
+		return errors.New("dedupWindow and maxPerMinute cannot be negative")
+	}
+	names := make(map[string]bool)
+	for i, hook := range n.Webhooks {
+		if hook.Name == "" {
+			return fmt.Errorf("webhook %d: name is required", i+1)
+		}
+		if names[hook.Name] {
+			return fmt.Errorf("webhook %s is defined twice", hook.Name)
+		}
+		names[hook.Name] = true
+
+		switch hook.Type {
+		case "", "generic", "slack":
+			if hook.URL == "" {
+				return fmt.Errorf("webhook %s: url is required", hook.Name)
+			}
+		case "pagerduty":
+			if hook.RoutingKey == "" {
+				return fmt.Errorf("webhook %s: pagerduty requires a routingKey", hook.Name)
+			}
+		default:
+			return fmt.Errorf("webhook %s: type %q must be generic, slack or pagerduty", hook.Name, hook.Type)
+		}
+		if hook.URL != "" {
+			if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("webhook %s: url %q must be http:// or https:// with a host", hook.Name, hook.URL)
+			}
+		}
+		for _, event := range hook.Events {
+			if !slices.Contains(NotificationEvents, event) {
+				return fmt.Errorf("webhook %s: unknown event %q", hook.Name, event)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *RedirectConfig) validate() error {
+	switch r.Status {
+	case 0, 301, 302, 307, 308:
+	default:
+		return fmt.Errorf("redirect status %d must be 301, 302, 307 or 308", r.Status)
+	}
+	switch r.Scheme {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("redirect scheme %q must be http or https", r.Scheme)
+	}
+	switch r.TrailingSlash {
+	case "", "add", "remove":
+	default:
+		return fmt.Errorf("redirect trailingSlash %q must be add or remove", r.TrailingSlash)
+	}
+	if strings.ContainsAny(r.Host, "/?#") {
+		return fmt.Errorf("redirect host %q must be a host, not a URL", r.Host)
+	}
+	if r.To != "" && (r.Scheme != "" || r.Host != "" || r.TrailingSlash != "") {
+		return errors.New("redirect to already sets the whole target; drop scheme, host and trailingSlash")
+	}
+	if r.To == "" && r.Scheme == "" && r.Host == "" && r.TrailingSlash == "" {
+		return errors.New("redirect needs a target, scheme, host or trailingSlash policy")
+	}
+	return nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// isTOML reports whether the config file at path is TOML rather than YAML
+// or JSON
+func isTOML(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".toml")
+}
+
+// toYAML converts a config file to YAML by its extension, so every format
+// is decoded by the same strict decoder against the yaml tags. JSON is
+// valid YAML already; TOML is converted, which loses its line numbers.
+func toYAML(path string, data []byte) ([]byte, error) {
+	if !isTOML(path) {
+		return data, nil
+	}
+	var doc map[string]any
+	if _, err := toml.Decode(string(data), &doc); err != nil {
+		return nil, err
+	}
+	if len(doc) == 0 {
+		return nil, nil
+	}
+	return yaml.Marshal(doc)
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// placeholder matches ${VAR} and ${VAR:-default}, and $${...}, which stands
+// for the literal text
+var placeholder = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// resolve replaces, in every value below n, ${VAR} with the environment
+// variable VAR and a value that is a file:// URL with the contents of the
+// file, without its trailing newline, so that secrets mounted by Docker or
+// Kubernetes need not be written into the config. It returns a "line N:"
+// message for each unset variable or unreadable file.
+func resolve(n *yaml.Node) []string {
+	var errs []string
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range n.Content {
+			errs = append(errs, resolve(child)...)
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			errs = append(errs, resolve(n.Content[i])...)
+		}
+	case yaml.ScalarNode:
+		if err := resolveScalar(n); err != nil {
+			errs = append(errs, fmt.Sprintf("line %d: %v", n.Line, err))
+		}
+	}
+	return errs
+}
+
+func resolveScalar(n *yaml.Node) error {
+	value := n.Value
+	if strings.Contains(value, "${") {
+		var missing []string
+		value = placeholder.ReplaceAllStringFunc(value, func(match string) string {
+			if strings.HasPrefix(match, "$$") {
+				return match[1:]
+			}
+			m := placeholder.FindStringSubmatch(match)
+			if v, ok := os.LookupEnv(m[1]); ok {
+				return v
+			}
+			if strings.Contains(match, ":-") {
+				return m[2]
+			}
+			missing = append(missing, m[1])
+			return match
+		})
+		if len(missing) > 0 {
+			return fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+		}
+	}
+
+	if strings.HasPrefix(value, "file://") {
+		u, err := url.Parse(value)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(u.Path)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimRight(string(data), "\r\n")
+	}
+
+	if value == n.Value {
+		return nil
+	}
+	n.Value = value
+	// A plain value is typed by what it resolved to, so "${PORT}" can fill
+	// a number; quoted and tagged values stay strings
+	if n.Style&(yaml.TaggedStyle|yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+		n.Tag = ""
+	}
+	return nil
+}
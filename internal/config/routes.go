@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/openapi"
+	"github.com/barisgenc/gatekeeper/internal/routematch"
+)
+
+// Pattern describes the paths the route matches. A route without a path
+// matches everything.
+func (r RouteConfig) Pattern() (routematch.Pattern, error) {
+	switch {
+	case r.Glob != "":
+		return routematch.Glob(r.Glob)
+	case r.Path != "":
+		return routematch.Template(r.Path), nil
+	default:
+		return routematch.Prefix(r.PathPrefix), nil
+	}
+}
+
+// OrderedRoutes returns the routes in matching order: higher Priority
+// first, then the more specific pattern (fixed-length before "**", then
+// more literal segments, then more segments), then more header and query
+// conditions, then listing order.
+func (c *Config) OrderedRoutes() []RouteConfig {
+	routes := append([]RouteConfig(nil), c.Routes...)
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Priority != routes[j].Priority {
+			return routes[i].Priority > routes[j].Priority
+		}
+		a, _ := routes[i].Pattern()
+		b, _ := routes[j].Pattern()
+		if order := a.Compare(b); order != 0 {
+			return order < 0
+		}
+		return routes[i].conditions() > routes[j].conditions()
+	})
+	return routes
+}
+
+// conditions counts the route's header and query matchers
+func (r RouteConfig) conditions() int {
+	return len(r.Headers) + len(r.Query)
+}
+
+// AddOpenAPIRoutes appends a route for each operation in the OpenAPI spec,
+// if one is configured. Load calls it before validating the config.
+func (c *Config) AddOpenAPIRoutes() error {
+	if c.OpenAPI.Spec == "" {
+		return nil
+	}
+	spec, err := openapi.Load(c.OpenAPI.Spec)
+	if err != nil {
+		return err
+	}
+
+	basePath := spec.BasePath
+	if c.OpenAPI.BasePath != nil {
+		basePath = strings.TrimSuffix(*c.OpenAPI.BasePath, "/")
+	}
+	for _, op := range spec.Operations {
+		name := op.ID
+		if name == "" {
+			name = op.Method + " " + basePath + op.Path
+		}
+		c.Routes = append(c.Routes, RouteConfig{
+			Name:        name,
+			Path:        basePath + op.Path,
+			Methods:     []string{op.Method},
+			Middlewares: c.OpenAPI.Middlewares,
+			Operation:   op,
+		})
+	}
+	return nil
+}
+
+// checkRouteConflicts rejects pairs of routes that rank the same but can
+// match the same request, since only listing order would decide between
+// them. Give one a higher priority to make the choice explicit.
+func checkRouteConflicts(routes []RouteConfig) error {
+	patterns := make([]routematch.Pattern, len(routes))
+	for i, route := range routes {
+		patterns[i], _ = route.Pattern()
+	}
+
+	for i := range routes {
+		for j := i + 1; j < len(routes); j++ {
+			a, b := routes[i], routes[j]
+			if a.Priority != b.Priority || patterns[i].Compare(patterns[j]) != 0 {
+				continue
+			}
+			// A conditional route leaves the requests it does not take to
+			// the ones after it, as does one with more header and query
+			// conditions to those with fewer
+			if a.When != "" || a.conditions() != b.conditions() {
+				continue
+			}
+			if !hostsOverlap(a.Host, b.Host) || !methodsOverlap(a.Methods, b.Methods) {
+				continue
+			}
+			if !valuesOverlap(a.Headers, b.Headers, http.CanonicalHeaderKey) || !valuesOverlap(a.Query, b.Query, nil) {
+				continue
+			}
+			if patterns[i].Overlaps(patterns[j]) {
+				return fmt.Errorf("routes %s (%s) and %s (%s) match the same requests with equal priority and specificity; set priority on one of them",
+					routeName(a, i), patterns[i], routeName(b, j), patterns[j])
+			}
+		}
+	}
+	return nil
+}
+
+func routeName(route RouteConfig, i int) string {
+	if route.Name != "" {
+		return route.Name
+	}
+	return fmt.Sprintf("#%d", i)
+}
+
+// hostsOverlap treats an empty host, or one with mux variables, as matching
+// any host
+func hostsOverlap(a, b string) bool {
+	if a == "" || b == "" || strings.Contains(a, "{") || strings.Contains(b, "{") {
+		return true
+	}
+	return strings.EqualFold(a, b)
+}
+
+func methodsOverlap(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for _, x := range a {
+		for _, y := range b {
+			if strings.EqualFold(x, y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// valuesOverlap reports whether a request can satisfy the header or query
+// conditions of both routes, which it cannot when they want different
+// values of the same key. canonical normalises keys when set.
+func valuesOverlap(a, b map[string]string, canonical func(string) string) bool {
+	values := make(map[string]string, len(a))
+	for key, value := range a {
+		if canonical != nil {
+			key = canonical(key)
+		}
+		values[key] = value
+	}
+	for key, value := range b {
+		if canonical != nil {
+			key = canonical(key)
+		}
+		other, ok := values[key]
+		if ok && other != "*" && value != "*" && other != value {
+			return false
+		}
+	}
+	return true
+}
+
+func countSet(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ReadSource returns the configSource block of the config file at Path. The
+// rest of the file is not checked, since it may only be complete together
+// with the document the source holds.
+func ReadSource() (ConfigSourceConfig, error) {
+	var src ConfigSourceConfig
+	path := Path()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && os.Getenv("GATEKEEPER_CONFIG") == "" {
+		return src, nil
+	}
+	if err != nil {
+		return src, fmt.Errorf("reading config file: %w", err)
+	}
+	if data, err = toYAML(path, data); err != nil {
+		return src, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return src, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return src, nil
+	}
+	doc := root.Content[0]
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value != "configSource" {
+			continue
+		}
+		node := doc.Content[i+1]
+		if errs := resolve(node); len(errs) > 0 {
+			return src, fmt.Errorf("%s: %w", path, decodeError(errs))
+		}
+		if err := node.Decode(&src); err != nil {
+			return src, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return src, nil
+}
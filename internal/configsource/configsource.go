@@ -0,0 +1,113 @@
+// Package configsource reads gateway config kept under a key in Consul or
+// etcd and watches the key, so a change reaches every gateway as a reload
+// without a redeploy.
+package configsource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+)
+
+const (
+	// waitTimeout bounds one long poll for a change
+	waitTimeout = 10 * time.Minute
+
+	// retryInterval is the pause after a failed watch
+	retryInterval = 5 * time.Second
+)
+
+// Source is a key holding a config document
+type Source interface {
+	// Get returns the document and its version
+	Get(ctx context.Context) ([]byte, uint64, error)
+
+	// Wait blocks until the document has a version other than version, or
+	// the store gives up waiting, and returns it like Get
+	Wait(ctx context.Context, version uint64) ([]byte, uint64, error)
+}
+
+// New creates the Source cfg describes
+func New(cfg config.ConfigSourceConfig) (Source, error) {
+	client := &http.Client{}
+	address := strings.TrimRight(cfg.Address, "/")
+	switch cfg.Type {
+	case "consul":
+		return &consul{address: address, key: strings.Trim(cfg.Key, "/"), token: cfg.Token, client: client}, nil
+	case "etcd":
+		return &etcd{address: address, key: cfg.Key, token: cfg.Token, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown config source type %q", cfg.Type)
+	}
+}
+
+// state is the source the config was last fetched from and the version it
+// had, which Watch waits to change
+type state struct {
+	source  Source
+	version uint64
+}
+
+var (
+	mu   sync.Mutex
+	last state
+)
+
+// Fetch returns the document in the source cfg describes and remembers its
+// version for Watch
+func Fetch(ctx context.Context, cfg config.ConfigSourceConfig) ([]byte, error) {
+	source, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	doc, version, err := source.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("config source %s %s: %w", cfg.Type, cfg.Key, err)
+	}
+
+	mu.Lock()
+	last = state{source: source, version: version}
+	mu.Unlock()
+	return doc, nil
+}
+
+// Watch waits for the document Fetch last returned to change, and then
+// calls reload. A change the reload rejects is not retried; the next one
+// is waited for. It is meant to run for the life of the process.
+func Watch(reload func()) {
+	for {
+		mu.Lock()
+		s := last
+		mu.Unlock()
+		if s.source == nil {
+			time.Sleep(retryInterval)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+		_, version, err := s.source.Wait(ctx, s.version)
+		cancel()
+		if err != nil {
+			logger.Warn("Watching config source failed: %v", err)
+			time.Sleep(retryInterval)
+			continue
+		}
+		if version == s.version {
+			continue
+		}
+
+		mu.Lock()
+		if last == s {
+			last.version = version
+		}
+		mu.Unlock()
+		logger.Info("Config source changed, reloading")
+		reload()
+	}
+}
@@ -0,0 +1,163 @@
+package configsource
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// store is a key with a version that fake servers wait on
+type store struct {
+	mu      sync.Mutex
+	value   string
+	version uint64
+	changed chan struct{}
+}
+
+func newStore(value string) *store {
+	return &store{value: value, version: 1, changed: make(chan struct{})}
+}
+
+func (s *store) set(value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.value = value
+	s.version++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *store) get() (string, uint64, chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.value, s.version, s.changed
+}
+
+func fakeConsul(t *testing.T, s *store) config.ConfigSourceConfig {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/kv/gatekeeper/config" || r.Header.Get("X-Consul-Token") != "acl" {
+			http.NotFound(w, r)
+			return
+		}
+		value, version, changed := s.get()
+		if index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); index == version {
+			select {
+			case <-changed:
+			case <-time.After(2 * time.Second):
+			case <-r.Context().Done():
+				return
+			}
+			value, version, _ = s.get()
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(version, 10))
+		w.Write([]byte(value))
+	}))
+	t.Cleanup(srv.Close)
+	return config.ConfigSourceConfig{Type: "consul", Address: srv.URL, Key: "/gatekeeper/config", Token: "acl"}
+}
+
+func fakeEtcd(t *testing.T, s *store) config.ConfigSourceConfig {
+	kv := func(value string, version uint64) map[string]interface{} {
+		return map[string]interface{}{"key": []byte("gatekeeper/config"), "value": []byte(value), "mod_revision": strconv.FormatUint(version, 10)}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value, version, changed := s.get()
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			json.NewEncoder(w).Encode(map[string]interface{}{"kvs": []interface{}{kv(value, version)}})
+		case "/v3/watch":
+			var req struct {
+				CreateRequest struct {
+					StartRevision string `json:"start_revision"`
+				} `json:"create_request"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			enc := json.NewEncoder(w)
+			enc.Encode(map[string]interface{}{"result": map[string]interface{}{"created": true}})
+			w.(http.Flusher).Flush()
+			if start, _ := strconv.ParseUint(req.CreateRequest.StartRevision, 10, 64); start > version {
+				select {
+				case <-changed:
+				case <-r.Context().Done():
+					return
+				}
+				value, version, _ = s.get()
+			}
+			enc.Encode(map[string]interface{}{"result": map[string]interface{}{
+				"events": []interface{}{map[string]interface{}{"kv": kv(value, version)}},
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return config.ConfigSourceConfig{Type: "etcd", Address: srv.URL, Key: "gatekeeper/config"}
+}
+
+func TestSources(t *testing.T) {
+	for name, start := range map[string]func(*testing.T, *store) config.ConfigSourceConfig{
+		"consul": fakeConsul,
+		"etcd":   fakeEtcd,
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newStore("routes: []")
+			source, err := New(start(t, s))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+
+			doc, version, err := source.Get(ctx)
+			if err != nil || string(doc) != "routes: []" {
+				t.Fatalf("Get = %q, %v, want routes: []", doc, err)
+			}
+
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				s.set("backends: []")
+			}()
+			doc, newVersion, err := source.Wait(ctx, version)
+			if err != nil || string(doc) != "backends: []" || newVersion == version {
+				t.Errorf("Wait = %q, %d, %v, want the new document", doc, newVersion, err)
+			}
+		})
+	}
+}
+
+func TestWatchReloadsOnChange(t *testing.T) {
+	s := newStore("logLevel: info")
+	cfg := fakeConsul(t, s)
+
+	doc, err := Fetch(context.Background(), cfg)
+	if err != nil || string(doc) != "logLevel: info" {
+		t.Fatalf("Fetch = %q, %v", doc, err)
+	}
+
+	reloads := make(chan struct{}, 1)
+	go Watch(func() { reloads <- struct{}{} })
+
+	s.set("logLevel: debug")
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after the key changed")
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	cfg := fakeConsul(t, newStore(""))
+	cfg.Key = "other"
+	if _, err := Fetch(context.Background(), cfg); err == nil {
+		t.Error("Fetch of a missing key succeeded")
+	}
+	if _, err := New(config.ConfigSourceConfig{Type: "zookeeper"}); err == nil {
+		t.Error("New accepted an unknown type")
+	}
+}
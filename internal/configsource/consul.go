@@ -0,0 +1,68 @@
+package configsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// maxDocument bounds the size of a config document
+const maxDocument = 4 << 20
+
+// consul reads a key from Consul's KV store. Waiting uses blocking queries
+// on the key's modify index.
+type consul struct {
+	address string
+	key     string
+	token   string
+	client  *http.Client
+}
+
+func (c *consul) Get(ctx context.Context) ([]byte, uint64, error) {
+	return c.read(ctx, nil)
+}
+
+func (c *consul) Wait(ctx context.Context, version uint64) ([]byte, uint64, error) {
+	return c.read(ctx, url.Values{"index": {strconv.FormatUint(version, 10)}, "wait": {"5m"}})
+}
+
+func (c *consul) read(ctx context.Context, query url.Values) ([]byte, uint64, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("raw", "")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.address+"/v1/kv/"+c.key+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, 0, errors.New("key not found")
+	default:
+		return nil, 0, fmt.Errorf("consul answered %d", resp.StatusCode)
+	}
+
+	index, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, errors.New("consul answered without X-Consul-Index")
+	}
+	doc, err := io.ReadAll(io.LimitReader(resp.Body, maxDocument))
+	if err != nil {
+		return nil, 0, err
+	}
+	return doc, index, nil
+}
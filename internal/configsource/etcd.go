@@ -0,0 +1,123 @@
+package configsource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// etcd reads a key through etcd's v3 JSON gateway. Waiting uses a watch
+// from the revision after the key's last modification.
+type etcd struct {
+	address string
+	key     string
+	token   string
+	client  *http.Client
+}
+
+// etcdKV is a key-value pair as the gateway encodes it: bytes in base64
+// and 64-bit numbers as strings
+type etcdKV struct {
+	Value       []byte `json:"value"`
+	ModRevision string `json:"mod_revision"`
+}
+
+func (kv etcdKV) version() (uint64, error) {
+	return strconv.ParseUint(kv.ModRevision, 10, 64)
+}
+
+func (e *etcd) Get(ctx context.Context) ([]byte, uint64, error) {
+	resp, err := e.post(ctx, "/v3/kv/range", map[string]interface{}{"key": []byte(e.key)})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		KVs []etcdKV `json:"kvs"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDocument*2)).Decode(&result); err != nil {
+		return nil, 0, err
+	}
+	if len(result.KVs) == 0 {
+		return nil, 0, errors.New("key not found")
+	}
+	version, err := result.KVs[0].version()
+	return result.KVs[0].Value, version, err
+}
+
+func (e *etcd) Wait(ctx context.Context, version uint64) ([]byte, uint64, error) {
+	resp, err := e.post(ctx, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            []byte(e.key),
+			"start_revision": strconv.FormatUint(version+1, 10),
+		},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	// The watch streams one message per batch of events, starting with an
+	// empty one confirming the watch
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Canceled bool `json:"canceled"`
+				Events   []struct {
+					Type string `json:"type"`
+					KV   etcdKV `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if ctx.Err() != nil {
+				// Waited long enough without a change
+				return nil, version, nil
+			}
+			return nil, 0, err
+		}
+		if msg.Result.Canceled {
+			return nil, 0, errors.New("etcd canceled the watch")
+		}
+		if n := len(msg.Result.Events); n > 0 {
+			event := msg.Result.Events[n-1]
+			if event.Type == "DELETE" {
+				return nil, 0, errors.New("key was deleted")
+			}
+			latest, err := event.KV.version()
+			return event.KV.Value, latest, err
+		}
+	}
+}
+
+func (e *etcd) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.address+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", e.token)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("etcd answered %d", resp.StatusCode)
+	}
+	return resp, nil
+}
@@ -0,0 +1,121 @@
+// Package connlimit limits how fast each client IP may open new
+// connections. It wraps the listener, so excess connections are closed right
+// after accept, before any TLS handshake or HTTP parsing is spent on them.
+package connlimit
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// sweepInterval is how often idle per-IP limiters are dropped
+const sweepInterval = time.Minute
+
+// Listener accepts connections from the wrapped listener and drops those
+// from clients that exceed their connection rate.
+type Listener struct {
+	net.Listener
+
+	limit rate.Limit
+	burst int
+	idle  time.Duration
+	now   func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewListener allows each client IP perSecond new connections, with bursts
+// of up to burst. A burst below one defaults to the per-second rate.
+func NewListener(inner net.Listener, perSecond float64, burst int) *Listener {
+	if burst < 1 {
+		burst = int(perSecond)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+
+	return &Listener{
+		Listener: inner,
+		limit:    rate.Limit(perSecond),
+		burst:    burst,
+		// Once a bucket has had time to refill it is no different from a
+		// new one, so it can be forgotten
+		idle:      time.Duration(float64(burst)/perSecond*float64(time.Second)) + time.Second,
+		now:       time.Now,
+		clients:   make(map[string]*client),
+		lastSweep: time.Now(),
+	}
+}
+
+// Accept returns the next connection within its client's rate. Rejected
+// connections are reset rather than closed gracefully so they do not linger
+// in TIME_WAIT.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := hostIP(conn.RemoteAddr())
+		if l.allow(ip) {
+			return conn, nil
+		}
+
+		metrics.RecordConnectionRejected()
+		logger.Debug("Connection from %s rejected: connection rate exceeded", ip)
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetLinger(0)
+		}
+		conn.Close()
+	}
+}
+
+func (l *Listener) allow(ip string) bool {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		for key, c := range l.clients {
+			if now.Sub(c.lastSeen) > l.idle {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	c, ok := l.clients[ip]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = c
+	}
+	c.lastSeen = now
+
+	return c.limiter.AllowN(now, 1)
+}
+
+func hostIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,99 @@
+package connlimit
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAllowPerIP(t *testing.T) {
+	l := NewListener(nil, 1, 2)
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+
+	if !l.allow("10.0.0.1") || !l.allow("10.0.0.1") {
+		t.Fatal("connections within burst should be allowed")
+	}
+	if l.allow("10.0.0.1") {
+		t.Error("third connection in the same second should be rejected")
+	}
+	if !l.allow("10.0.0.2") {
+		t.Error("other clients should have their own budget")
+	}
+
+	now = now.Add(time.Second)
+	if !l.allow("10.0.0.1") {
+		t.Error("budget should refill over time")
+	}
+}
+
+func TestIdleClientsSwept(t *testing.T) {
+	l := NewListener(nil, 10, 10)
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+	l.lastSweep = now
+
+	l.allow("10.0.0.1")
+	now = now.Add(sweepInterval)
+	l.allow("10.0.0.2")
+
+	if _, ok := l.clients["10.0.0.1"]; ok {
+		t.Error("idle client should have been swept")
+	}
+	if _, ok := l.clients["10.0.0.2"]; !ok {
+		t.Error("active client should be kept")
+	}
+}
+
+func TestAcceptDropsExcessConnections(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(inner, 0.001, 1)
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	first, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(2 * time.Second):
+		t.Fatal("first connection was not accepted")
+	}
+
+	second, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = second.Read(make([]byte, 1))
+	if err == nil {
+		t.Error("second connection should have been closed")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Error("second connection was left open")
+	}
+
+	select {
+	case <-accepted:
+		t.Error("second connection should not reach the server")
+	default:
+	}
+}
@@ -0,0 +1,206 @@
+// Package contract runs the config's declarative tests: requests sent
+// through the gateway's full handler chain with every backend replaced by
+// an in-memory stub, checked against the routing, status and policy
+// outcome they must have. Platform teams keep them next to the routes so a
+// routing change that breaks a contract fails before it is deployed.
+package contract
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/gateway"
+)
+
+// NoBackend is the Expect.Backend of a request the gateway must answer
+// without proxying it
+const NoBackend = "none"
+
+// Result is the outcome of one test
+type Result struct {
+	Test     string
+	Passed   bool
+	Failures []string
+}
+
+// upstreamCall is what the stubbed backends saw of a request
+type upstreamCall struct {
+	backend string
+	path    string
+	header  http.Header
+}
+
+// Run sends every test in cfg through one gateway, in order, so a test can
+// rely on state left by earlier ones, such as a rate limit that is nearly
+// used up. State stores are replaced by in-memory ones so running the
+// tests cannot ban clients or use up limits of gateways in service.
+func Run(cfg *config.Config) []Result {
+	var (
+		mu       sync.Mutex
+		upstream config.ContractUpstream
+		calls    []upstreamCall
+	)
+	stub := func(backend string) http.RoundTripper {
+		return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if r.Body != nil {
+				io.Copy(io.Discard, r.Body)
+				r.Body.Close()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, upstreamCall{backend: backend, path: r.URL.Path, header: r.Header.Clone()})
+			return upstreamResponse(r, upstream), nil
+		})
+	}
+
+	handler := gateway.NewWithUpstream(Isolate(cfg), stub).Handler()
+
+	results := make([]Result, 0, len(cfg.Tests))
+	for _, test := range cfg.Tests {
+		mu.Lock()
+		upstream, calls = test.Upstream, nil
+		mu.Unlock()
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, newRequest(test))
+
+		mu.Lock()
+		failures := check(test.Expect, rr.Result(), rr.Body.String(), calls)
+		mu.Unlock()
+		results = append(results, Result{Test: test.Name, Passed: len(failures) == 0, Failures: failures})
+	}
+	return results
+}
+
+// Isolate copies cfg with every shared state store swapped for memory, so
+// a gateway run in tests cannot touch the state of ones in service
+func Isolate(cfg *config.Config) *config.Config {
+	isolated := *cfg
+	isolated.Storage = config.StorageConfig{}
+	if isolated.AutoBan.Store == "redis" {
+		isolated.AutoBan.Store = "memory"
+	}
+	if isolated.Idempotency.Store == "redis" {
+		isolated.Idempotency.Store = "memory"
+	}
+
+	isolated.Middlewares = make(config.MiddlewareConfigs, len(cfg.Middlewares))
+	for name, def := range cfg.Middlewares {
+		if def.Idempotency != nil && def.Idempotency.Store == "redis" {
+			idempotency := *def.Idempotency
+			idempotency.Store = "memory"
+			def.Idempotency = &idempotency
+		}
+		isolated.Middlewares[name] = def
+	}
+	return &isolated
+}
+
+func newRequest(test config.ContractTest) *http.Request {
+	method := test.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	host := test.Host
+	if host == "" {
+		host = "localhost"
+	}
+
+	req := httptest.NewRequest(method, "http://"+host+test.Path, strings.NewReader(test.Body))
+	for name, value := range test.Headers {
+		req.Header.Set(name, value)
+	}
+	if test.RemoteAddr != "" {
+		req.RemoteAddr = test.RemoteAddr
+	}
+	return req
+}
+
+func upstreamResponse(r *http.Request, upstream config.ContractUpstream) *http.Response {
+	status := upstream.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := make(http.Header)
+	for name, value := range upstream.Headers {
+		header.Set(name, value)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(upstream.Body))),
+		ContentLength: int64(len(upstream.Body)),
+		Request:       r,
+	}
+}
+
+// check compares a test's outcome with what it expects
+func check(expect config.ContractExpect, resp *http.Response, body string, calls []upstreamCall) []string {
+	var failures []string
+	if expect.Status != 0 && resp.StatusCode != expect.Status {
+		failures = append(failures, fmt.Sprintf("status: expected %d, got %d", expect.Status, resp.StatusCode))
+	}
+	for _, name := range sortedKeys(expect.Headers) {
+		if got := resp.Header.Get(name); got != expect.Headers[name] {
+			failures = append(failures, fmt.Sprintf("header %s: expected %q, got %q", name, expect.Headers[name], got))
+		}
+	}
+	if expect.Body != "" && !strings.Contains(body, expect.Body) {
+		failures = append(failures, fmt.Sprintf("body: expected to contain %q", expect.Body))
+	}
+
+	switch {
+	case expect.Backend == NoBackend:
+		if len(calls) > 0 {
+			failures = append(failures, fmt.Sprintf("backend: expected none, got %s", calls[0].backend))
+		}
+		return failures
+	case len(calls) == 0:
+		if expect.Backend != "" || expect.UpstreamPath != "" || len(expect.UpstreamHeaders) > 0 {
+			failures = append(failures, "backend: request did not reach a backend")
+		}
+		return failures
+	}
+
+	// Retries and hedges can reach several backends; the last one answered
+	call := calls[len(calls)-1]
+	if expect.Backend != "" && call.backend != expect.Backend {
+		failures = append(failures, fmt.Sprintf("backend: expected %s, got %s", expect.Backend, call.backend))
+	}
+	if expect.UpstreamPath != "" && call.path != expect.UpstreamPath {
+		failures = append(failures, fmt.Sprintf("upstream path: expected %s, got %s", expect.UpstreamPath, call.path))
+	}
+	for _, name := range sortedKeys(expect.UpstreamHeaders) {
+		if got := call.header.Get(name); got != expect.UpstreamHeaders[name] {
+			failures = append(failures, fmt.Sprintf("upstream header %s: expected %q, got %q", name, expect.UpstreamHeaders[name], got))
+		}
+	}
+	return failures
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,68 @@
+package contract
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestRun(t *testing.T) {
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "api", URL: "http://api.internal:8080/v1", Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Routes: []config.RouteConfig{
+			{Name: "old", Path: "/u/{id}", Redirect: &config.RedirectConfig{To: "/users/{id}", Status: 301}},
+			{Name: "search", PathPrefix: "/search", Middlewares: []string{"strict"}},
+			{Name: "users", PathPrefix: "/users"},
+		},
+		Middlewares: config.MiddlewareConfigs{
+			"strict": {Type: "rateLimit", RateLimit: &config.RateLimitConfig{RequestsPerMinute: 1, BurstSize: 1}},
+		},
+		Tests: []config.ContractTest{
+			{
+				Name:     "users reach the api",
+				Path:     "/users/42",
+				Headers:  map[string]string{"X-Request-ID": "abc"},
+				Upstream: config.ContractUpstream{Body: `{"id":42}`, Headers: map[string]string{"Content-Type": "application/json"}},
+				Expect: config.ContractExpect{
+					Status:          200,
+					Backend:         "api",
+					UpstreamPath:    "/v1/users/42",
+					UpstreamHeaders: map[string]string{"X-Request-ID": "abc"},
+					Headers:         map[string]string{"Content-Type": "application/json"},
+					Body:            `"id":42`,
+				},
+			},
+			{
+				Name:   "old user links redirect",
+				Path:   "/u/42",
+				Expect: config.ContractExpect{Status: 301, Backend: NoBackend, Headers: map[string]string{"Location": "/users/42"}},
+			},
+			{Name: "first search passes", Path: "/search", Expect: config.ContractExpect{Status: 200}},
+			{Name: "second search is limited", Path: "/search", Expect: config.ContractExpect{Status: 429, Backend: NoBackend}},
+			{
+				Name:     "wrong expectations",
+				Path:     "/users/7",
+				Upstream: config.ContractUpstream{Status: 503},
+				Expect:   config.ContractExpect{Status: 200, UpstreamPath: "/users/7", Backend: NoBackend},
+			},
+		},
+	}
+
+	results := Run(cfg)
+	if len(results) != len(cfg.Tests) {
+		t.Fatalf("Expected %d results, got %d", len(cfg.Tests), len(results))
+	}
+	for _, result := range results[:4] {
+		if !result.Passed {
+			t.Errorf("Expected %q to pass, got %q", result.Test, result.Failures)
+		}
+	}
+
+	failed := results[4]
+	expected := []string{"status: expected 200, got 503", "backend: expected none, got api"}
+	if failed.Passed || strings.Join(failed.Failures, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected failures %q, got %q", expected, failed.Failures)
+	}
+}
@@ -0,0 +1,572 @@
+// Package docs describes a gateway configuration for humans: its routes,
+// authentication, rate limits and policies. The description is derived from
+// the same config the gateway runs with, so it cannot drift from it.
+package docs
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// Document is a format-independent description of a configuration
+type Document struct {
+	Title    string
+	Sections []Section
+}
+
+// Section is one table of the document. Sections with no rows carry only
+// their Empty note.
+type Section struct {
+	Title   string
+	Intro   string
+	Empty   string
+	Columns []string
+	Rows    [][]string
+}
+
+// Describe builds the document for cfg. Secrets such as client secrets,
+// keys and proxy credentials are never included.
+func Describe(cfg *config.Config) Document {
+	return Document{
+		Title: "GateKeeper configuration",
+		Sections: []Section{
+			describeRoutes(cfg),
+			describeBackends(cfg),
+			describeAuth(cfg),
+			describeRateLimits(cfg),
+			describePolicies(cfg),
+		},
+	}
+}
+
+func describeRoutes(cfg *config.Config) Section {
+	s := Section{
+		Title:   "Routes",
+		Intro:   "Routes in the order they are tried. Requests matching none are proxied to the backends with the global middlewares only.",
+		Empty:   "No routes are configured; every request is proxied.",
+		Columns: []string{"Route", "Match", "Action", "Middlewares", "Behavior"},
+	}
+
+	for _, route := range cfg.OrderedRoutes() {
+		pattern, err := route.Pattern()
+		if err != nil {
+			continue
+		}
+
+		// Exact paths keep their template variables
+		match := route.Path
+		if match == "" {
+			match = pattern.String()
+		}
+		if route.Host != "" {
+			match = route.Host + match
+		}
+		if len(route.Methods) > 0 {
+			match = strings.Join(route.Methods, ", ") + " " + match
+		}
+		if conditions := describeConditions(route); conditions != "" {
+			match += " [" + conditions + "]"
+		}
+		if route.Priority != 0 {
+			match += fmt.Sprintf(" (priority %d)", route.Priority)
+		}
+
+		action := "proxy"
+		if r := route.Redirect; r != nil {
+			action = describeRedirect(r)
+		}
+		if a := route.Aggregate; a != nil {
+			parts := make([]string, 0, len(a.Parts))
+			for _, part := range a.Parts {
+				parts = append(parts, part.Key+" from "+part.Backend)
+			}
+			action = "aggregate " + strings.Join(parts, ", ")
+		}
+		if g := route.GRPCTranscode; g != nil {
+			action = "gRPC transcode to " + g.Backend
+			if len(g.Bindings) > 0 {
+				rpcs := make([]string, 0, len(g.Bindings))
+				for _, b := range g.Bindings {
+					rpcs = append(rpcs, b.RPC)
+				}
+				action += " (" + strings.Join(rpcs, ", ") + ")"
+			}
+		}
+
+		if s := route.Static; s != nil {
+			action = "static " + s.Dir
+			if s.SPA {
+				action += " (spa)"
+			}
+		}
+
+		middlewares := make([]string, 0, len(route.Middlewares))
+		for _, name := range route.Middlewares {
+			middlewares = append(middlewares, fmt.Sprintf("%s (%s)", name, cfg.Middlewares[name].Type))
+		}
+
+		var behavior []string
+		if cc := route.ClientCert; cc != nil {
+			req := "client certificate"
+			if len(cc.OUs) > 0 {
+				req += " with OU " + strings.Join(cc.OUs, " or ")
+			}
+			if len(cc.SANs) > 0 {
+				req += " with SAN " + strings.Join(cc.SANs, " or ")
+			}
+			behavior = append(behavior, "requires "+req)
+		}
+		if nc := route.NegativeCache; nc != nil {
+			statuses := "404"
+			if len(nc.Statuses) > 0 {
+				statuses = joinInts(nc.Statuses)
+			}
+			behavior = append(behavior, fmt.Sprintf("caches %s for %s", statuses, seconds(nc.TTL)))
+		}
+		if c := route.CORS; c != nil {
+			behavior = append(behavior, "CORS for "+orDash(strings.Join(c.AllowedOrigins, ", ")))
+		}
+		if route.Coalesce != nil {
+			behavior = append(behavior, "coalesces identical GET and HEAD requests")
+		}
+		if h := route.HeaderLimits; h != nil {
+			var caps []string
+			if h.MaxRequestBytes > 0 {
+				caps = append(caps, fmt.Sprintf("request headers at %d bytes", h.MaxRequestBytes))
+			}
+			if h.MaxResponseBytes > 0 {
+				caps = append(caps, fmt.Sprintf("response headers at %d bytes", h.MaxResponseBytes))
+			}
+			behavior = append(behavior, "caps "+strings.Join(caps, " and "))
+		}
+		if route.LargeBodies != nil {
+			behavior = append(behavior, "copies response bodies with large buffers")
+		}
+		if t := route.ResponseTransform; t != nil {
+			if hidden := append(append([]string(nil), t.Drop...), t.Mask...); len(hidden) > 0 {
+				behavior = append(behavior, "hides "+strings.Join(hidden, ", ")+" in JSON responses")
+			}
+		}
+
+		s.Rows = append(s.Rows, []string{
+			orDash(route.Name), match, action, orDash(strings.Join(middlewares, ", ")), orDash(strings.Join(behavior, "; ")),
+		})
+	}
+	return s
+}
+
+// describeConditions lists a route's header and query matchers in a
+// stable order
+func describeConditions(route config.RouteConfig) string {
+	var conditions []string
+	for _, name := range sortedKeys(route.Headers) {
+		conditions = append(conditions, name+": "+route.Headers[name])
+	}
+	for _, name := range sortedKeys(route.Query) {
+		conditions = append(conditions, "?"+name+"="+route.Query[name])
+	}
+	return strings.Join(conditions, ", ")
+}
+
+func describeRedirect(r *config.RedirectConfig) string {
+	status := r.Status
+	if status == 0 {
+		status = 302
+	}
+	if r.To != "" {
+		return fmt.Sprintf("redirect %d to %s", status, r.To)
+	}
+	var changes []string
+	if r.Scheme != "" {
+		changes = append(changes, r.Scheme)
+	}
+	if r.Host != "" {
+		changes = append(changes, "host "+r.Host)
+	}
+	if r.TrailingSlash != "" {
+		changes = append(changes, r.TrailingSlash+" trailing slash")
+	}
+	if len(changes) == 0 {
+		return fmt.Sprintf("redirect %d", status)
+	}
+	return fmt.Sprintf("redirect %d to %s", status, strings.Join(changes, ", "))
+}
+
+func describeBackends(cfg *config.Config) Section {
+	s := Section{
+		Title:   "Backends",
+		Columns: []string{"Backend", "URL", "Weight", "Health check", "Egress proxy"},
+	}
+	for _, backend := range cfg.Backends {
+		proxy := "-"
+		if backend.Proxy != nil {
+			if u, err := url.Parse(backend.Proxy.URL); err == nil {
+				proxy = u.Scheme + "://" + u.Host
+			}
+		}
+		if len(backend.Servers) == 0 {
+			s.Rows = append(s.Rows, []string{
+				backend.Name, backend.URL, fmt.Sprint(backend.Weight), orDash(backend.Health), proxy,
+			})
+			continue
+		}
+		s.Rows = append(s.Rows, []string{
+			backend.Name, fmt.Sprintf("%d servers", len(backend.Servers)), fmt.Sprint(backend.TotalWeight()), orDash(backend.Health), proxy,
+		})
+		for _, instance := range backend.Instances() {
+			s.Rows = append(s.Rows, []string{
+				instance.Name, instance.URL, fmt.Sprint(instance.Weight), orDash(instance.Health), proxy,
+			})
+		}
+	}
+	return s
+}
+
+func describeAuth(cfg *config.Config) Section {
+	s := Section{
+		Title:   "Authentication",
+		Intro:   "Mechanisms applied to every request except /health, /metrics and the listed skipped paths.",
+		Empty:   "No global authentication is configured.",
+		Columns: []string{"Mechanism", "Settings", "Skipped paths"},
+	}
+	add := func(mechanism, settings string, skip []string) {
+		s.Rows = append(s.Rows, []string{mechanism, settings, orDash(strings.Join(skip, ", "))})
+	}
+
+	if c := cfg.OIDC; c.Enabled {
+		add("OpenID Connect", "issuer "+c.IssuerURL+", client "+c.ClientID, c.SkipPaths)
+	}
+	if c := cfg.Sessions; c.Enabled {
+		store := c.Store
+		if store == "" {
+			store = "cookie"
+		}
+		add("Sessions", "store "+store+", SameSite "+orDash(c.SameSite), nil)
+	}
+	if c := cfg.SAML; c.Enabled {
+		add("SAML bearer assertion", "audience "+orDash(c.Audience), c.SkipPaths)
+	}
+	if c := cfg.SPNEGO; c.Enabled {
+		add("Kerberos (SPNEGO)", "service principal "+orDash(c.ServicePrincipal), c.SkipPaths)
+	}
+	if c := cfg.LDAP; c.Enabled {
+		add("LDAP (Basic)", "directory "+c.URL+", base "+c.BaseDN, c.SkipPaths)
+	}
+	if c := cfg.Introspection; c.Enabled {
+		settings := "endpoint " + c.Endpoint
+		if len(c.RequiredScopes) > 0 {
+			settings += ", scopes " + strings.Join(c.RequiredScopes, " ")
+		}
+		add("Token introspection", settings, c.SkipPaths)
+	}
+	if c := cfg.HMAC; c.Enabled {
+		consumers := make([]string, 0, len(c.Consumers))
+		for _, consumer := range c.Consumers {
+			consumers = append(consumers, consumer.ID)
+		}
+		add("HMAC signature", "scheme "+orDefault(c.Scheme)+", consumers "+orDash(strings.Join(consumers, ", ")), c.SkipPaths)
+	}
+	if c := cfg.ExtAuthz; c.Enabled {
+		settings := "service " + c.URL
+		if c.FailOpen {
+			settings += ", fails open"
+		}
+		add("External authorization", settings, c.SkipPaths)
+	}
+	if tls := cfg.Server.TLS; tls.ClientCAFile != "" {
+		add("Client certificate", "client auth "+orDefault(tls.ClientAuth), nil)
+	}
+	return s
+}
+
+func describeRateLimits(cfg *config.Config) Section {
+	s := Section{
+		Title:   "Rate limits",
+		Columns: []string{"Scope", "Requests per minute", "Burst", "Keyed by"},
+	}
+	rl := cfg.RateLimit
+	s.Rows = append(s.Rows, []string{"global", fmt.Sprint(rl.RequestsPerMinute), burst(rl, rl.BurstSize), "gateway"})
+
+	if t := rl.Anonymous; t != nil {
+		s.Rows = append(s.Rows, []string{"anonymous clients", fmt.Sprint(t.RequestsPerMinute), burst(rl, t.BurstSize), clientKey(rl)})
+	}
+	if t := rl.Authenticated; t != nil {
+		s.Rows = append(s.Rows, []string{"authenticated clients", fmt.Sprint(t.RequestsPerMinute), burst(rl, t.BurstSize), "identity"})
+	}
+	for _, name := range sortedKeys(rl.Plans) {
+		t := rl.Plans[name]
+		s.Rows = append(s.Rows, []string{"plan " + name, fmt.Sprint(t.RequestsPerMinute), burst(rl, t.BurstSize), "identity"})
+	}
+
+	for _, name := range sortedMiddlewares(cfg, "rateLimit") {
+		limit := cfg.Middlewares[name].RateLimit
+		scope := "middleware " + name + " on " + strings.Join(routesUsing(cfg, name), ", ")
+		if limit.RequestsPerMinute > 0 {
+			s.Rows = append(s.Rows, []string{scope, fmt.Sprint(limit.RequestsPerMinute), burst(*limit, limit.BurstSize), "instance"})
+		}
+		if t := limit.Anonymous; t != nil {
+			s.Rows = append(s.Rows, []string{scope + " (anonymous)", fmt.Sprint(t.RequestsPerMinute), burst(*limit, t.BurstSize), clientKey(*limit)})
+		}
+		if t := limit.Authenticated; t != nil {
+			s.Rows = append(s.Rows, []string{scope + " (authenticated)", fmt.Sprint(t.RequestsPerMinute), burst(*limit, t.BurstSize), "identity"})
+		}
+	}
+
+	if c := cfg.RateLimitSvc; c.Enabled {
+		s.Rows = append(s.Rows, []string{"service " + c.URL + " (domain " + c.Domain + ")", "set by service", "-", descriptorKeys(c)})
+	}
+	for _, name := range sortedMiddlewares(cfg, "rateLimitService") {
+		c := cfg.Middlewares[name].RateLimitSvc
+		scope := "middleware " + name + " on " + strings.Join(routesUsing(cfg, name), ", ")
+		s.Rows = append(s.Rows, []string{scope + " (domain " + c.Domain + ")", "set by service", "-", descriptorKeys(*c)})
+	}
+
+	if c := cfg.Server.ConnLimit; c.PerIPPerSecond > 0 {
+		s.Rows = append(s.Rows, []string{"new connections", fmt.Sprintf("%g", c.PerIPPerSecond*60), orDefault(c.Burst), "connecting IP"})
+	}
+	return s
+}
+
+func describePolicies(cfg *config.Config) Section {
+	s := Section{
+		Title:   "Policies",
+		Empty:   "No additional policies are enabled.",
+		Columns: []string{"Policy", "Settings"},
+	}
+	add := func(policy string, settings ...string) {
+		s.Rows = append(s.Rows, []string{policy, strings.Join(settings, ", ")})
+	}
+
+	if c := cfg.CORS; c.Enabled {
+		add("CORS", "origins "+orDash(strings.Join(c.AllowedOrigins, ", ")), fmt.Sprintf("credentials %v", c.AllowCredentials))
+	}
+	if c := cfg.AutoBan; c.Enabled {
+		settings := []string{"threshold " + orDefault(c.Threshold), "window " + seconds(c.Window), "ban " + seconds(c.BanDuration)}
+		if len(c.Honeypots) > 0 {
+			settings = append(settings, "honeypots "+strings.Join(c.Honeypots, " "))
+		}
+		add("Automatic bans", settings...)
+	}
+	if c := cfg.Tarpit; c.Enabled {
+		add("Tarpit", "delay "+seconds(c.Delay), "maxConcurrent "+orDefault(c.MaxConcurrent))
+	}
+	if c := cfg.Versioning; c.Enabled {
+		var settings []string
+		for _, version := range c.Versions {
+			setting := version.Name + " on " + strings.Join(version.Backends, ", ")
+			if d := version.Deprecation; d != nil {
+				setting += " (deprecated"
+				if d.Sunset != "" {
+					setting += ", sunset " + d.Sunset
+				}
+				setting += ")"
+			}
+			settings = append(settings, setting)
+		}
+		add("API versions", append(settings, "default "+orDash(c.Default))...)
+	}
+	if c := cfg.Maintenance; c.Enabled {
+		routes := "every route"
+		if len(c.Routes) > 0 {
+			routes = "routes " + strings.Join(c.Routes, ", ")
+		}
+		add("Maintenance mode", fmt.Sprintf("active %v at startup", c.Active), routes, fmt.Sprintf("%d allowed IP ranges", len(c.AllowIPs)))
+	}
+	if c := cfg.BotDetection; c.Enabled {
+		routes := "every route"
+		if len(c.Routes) > 0 {
+			routes = "routes " + strings.Join(c.Routes, ", ")
+		}
+		settings := []string{"threshold " + orDefault(c.Threshold), "action " + orDefault(c.Action), routes}
+		if c.Challenge.Provider != "" {
+			settings = append(settings, "challenge "+c.Challenge.Provider)
+		}
+		add("Bot detection", settings...)
+	}
+	if c := cfg.Bulkhead; c.Enabled {
+		settings := []string{fmt.Sprintf("%d concurrent", c.MaxConcurrent), fmt.Sprintf("queue %d", c.QueueDepth)}
+		for _, group := range c.Groups {
+			settings = append(settings, fmt.Sprintf("group %s (%s): %d concurrent", group.Name, strings.Join(group.Backends, ", "), group.MaxConcurrent))
+		}
+		add("Bulkhead", settings...)
+	}
+	if c := cfg.Quota; c.Enabled {
+		capped := func(n int64) string {
+			if n == 0 {
+				return "unlimited"
+			}
+			return fmt.Sprint(n)
+		}
+		add("Quotas", "daily "+capped(c.Daily), "monthly "+capped(c.Monthly), fmt.Sprintf("%d consumers with their own", len(c.Consumers)))
+	}
+	if c := cfg.Idempotency; c.Enabled {
+		add("Idempotency keys", "header "+orDefault(c.Header), "methods "+orDefault(strings.Join(c.Methods, " ")), "ttl "+seconds(c.TTL))
+	}
+	if c := cfg.RequestAge; c.Enabled {
+		maxWait := "not enforced"
+		if c.MaxQueueTimeMs > 0 {
+			maxWait = fmt.Sprintf("%dms", c.MaxQueueTimeMs)
+		}
+		add("Request age", "header "+orDefault(c.Header), "max queue time "+maxWait)
+	}
+	if c := cfg.Hedging; c.Enabled {
+		add("Hedging", "GET and HEAD after "+orDefault(c.DelayMs)+"ms")
+	}
+	if c := cfg.Incident; c.Enabled {
+		add("Incident mode", "5xx ratio "+orDefault(c.Threshold), "window "+seconds(c.Window), "sheds below priority "+fmt.Sprint(c.ShedBelowPriority))
+	}
+	if c := cfg.Server.HSTS; c.MaxAge > 0 {
+		add("HSTS", "max age "+seconds(c.MaxAge), fmt.Sprintf("subdomains %v", c.IncludeSubdomains), fmt.Sprintf("preload %v", c.Preload))
+	}
+	if c := cfg.UpstreamSign; c.Enabled {
+		add("Upstream request signing", "type "+c.Type, "algorithm "+orDefault(c.Algorithm))
+	}
+	if c := cfg.TokenRelay; c.Enabled {
+		settings := []string{"mode " + c.Mode}
+		switch c.Mode {
+		case "mint":
+			settings = append(settings, "algorithm "+orDefault(c.Algorithm), "ttl "+seconds(c.TTL))
+		case "clientCredentials", "exchange":
+			settings = append(settings, "token endpoint "+c.TokenURL)
+		}
+		add("Token relay", settings...)
+	}
+	if c := cfg.ExtProc; c.Enabled {
+		add("External processing", extProcSettings(c)...)
+	}
+	for _, name := range sortedMiddlewares(cfg, "extProc") {
+		c := cfg.Middlewares[name].ExtProc
+		add("External processing on "+strings.Join(routesUsing(cfg, name), ", "), extProcSettings(*c)...)
+	}
+	if c := cfg.PathNormalize; c.Enabled {
+		add("Path normalization", fmt.Sprintf("rejects encoded slashes %v", c.RejectEncodedSlash))
+	}
+	return s
+}
+
+// clientKey says how a tiered limit tells anonymous clients apart
+func clientKey(cfg config.RateLimitConfig) string {
+	if cfg.UseForwardedFor {
+		return "last X-Forwarded-For hop"
+	}
+	return "connecting IP"
+}
+
+// burst is a limit's burst size, or that it has none in spike arrest mode
+func burst(cfg config.RateLimitConfig, size int) string {
+	if cfg.SpikeArrest() {
+		return "none (spike arrest)"
+	}
+	return fmt.Sprint(size)
+}
+
+// descriptorKeys lists the keys of each descriptor sent to a rate limit
+// service
+func descriptorKeys(c config.RateLimitSvcConfig) string {
+	if len(c.Descriptors) == 0 {
+		return "remote_address"
+	}
+	descriptors := make([]string, 0, len(c.Descriptors))
+	for _, d := range c.Descriptors {
+		keys := make([]string, 0, len(d.Entries))
+		for _, e := range d.Entries {
+			keys = append(keys, e.Key)
+		}
+		descriptors = append(descriptors, strings.Join(keys, "."))
+	}
+	return strings.Join(descriptors, ", ")
+}
+
+// extProcSettings describes what an external processor is sent
+func extProcSettings(c config.ExtProcConfig) []string {
+	sent := "headers"
+	switch {
+	case c.RequestBody && c.ResponseBody:
+		sent = "headers and bodies"
+	case c.RequestBody:
+		sent = "headers and request bodies"
+	case c.ResponseBody:
+		sent = "headers and response bodies"
+	}
+	settings := []string{"service " + c.URL, "sends " + sent}
+	if c.FailOpen {
+		settings = append(settings, "fails open")
+	}
+	return settings
+}
+
+// sortedMiddlewares lists the names of middleware instances of one type
+func sortedMiddlewares(cfg *config.Config, typ string) []string {
+	var names []string
+	for name, m := range cfg.Middlewares {
+		if m.Type == typ {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func routesUsing(cfg *config.Config, middleware string) []string {
+	routes := []string{}
+	for i, route := range cfg.Routes {
+		for _, name := range route.Middlewares {
+			if name == middleware {
+				label := route.Name
+				if label == "" {
+					label = fmt.Sprintf("#%d", i)
+				}
+				routes = append(routes, label)
+				break
+			}
+		}
+	}
+	if len(routes) == 0 {
+		return []string{"no routes"}
+	}
+	return routes
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// seconds formats a duration setting, where zero means the default
+func seconds(n int) string {
+	if n == 0 {
+		return "default"
+	}
+	return fmt.Sprintf("%ds", n)
+}
+
+func orDefault[T comparable](v T) string {
+	var zero T
+	if v == zero {
+		return "default"
+	}
+	return fmt.Sprint(v)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
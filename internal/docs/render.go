@@ -0,0 +1,75 @@
+package docs
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// Markdown writes the document as GitHub-flavored Markdown
+func Markdown(w io.Writer, doc Document) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", doc.Title)
+
+	for _, section := range doc.Sections {
+		fmt.Fprintf(&b, "\n## %s\n\n", section.Title)
+		if section.Intro != "" {
+			fmt.Fprintf(&b, "%s\n\n", section.Intro)
+		}
+		if len(section.Rows) == 0 {
+			fmt.Fprintf(&b, "%s\n", section.Empty)
+			continue
+		}
+
+		b.WriteString("| " + strings.Join(escapeCells(section.Columns), " | ") + " |\n")
+		b.WriteString("|" + strings.Repeat(" --- |", len(section.Columns)) + "\n")
+		for _, row := range section.Rows {
+			b.WriteString("| " + strings.Join(escapeCells(row), " | ") + " |\n")
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escapeCells keeps cell text from breaking out of its table column
+func escapeCells(cells []string) []string {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		cell = strings.ReplaceAll(cell, "|", `\|`)
+		escaped[i] = strings.ReplaceAll(cell, "\n", " ")
+	}
+	return escaped
+}
+
+var htmlTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Sections}}
+<h2>{{.Title}}</h2>
+{{if .Intro}}<p>{{.Intro}}</p>{{end}}
+{{if .Rows}}<table>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>{{else}}<p>{{.Empty}}</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// HTML writes the document as a standalone HTML page
+func HTML(w io.Writer, doc Document) error {
+	return htmlTemplate.Execute(w, doc)
+}
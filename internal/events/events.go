@@ -0,0 +1,196 @@
+// Package events publishes one structured event per request to Kafka or
+// NATS, for analytics downstream without scraping the access log. Events
+// are queued and published in batches off the request path; when the
+// broker falls behind the queue fills and new events are dropped.
+package events
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/accesslog"
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// DefaultTopic is the Kafka topic or NATS subject events go to
+const DefaultTopic = "gatekeeper.requests"
+
+// publishAttempts is how often a batch is tried before it is dropped
+const publishAttempts = 3
+
+// retryBackoff is the wait before the first retry, doubled for each next
+var retryBackoff = 200 * time.Millisecond
+
+// Event is what is published for a request
+type Event struct {
+	Time      time.Time `json:"time"`
+	Route     string    `json:"route,omitempty"`
+	Consumer  string    `json:"consumer,omitempty"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	LatencyMs float64   `json:"latencyMs"`
+	Bytes     int64     `json:"bytes"`
+	RequestID string    `json:"requestId,omitempty"`
+}
+
+// Publisher sends a batch of messages to a broker, all or none
+type Publisher interface {
+	Publish(messages [][]byte) error
+	Close() error
+}
+
+// Exporter queues events and publishes them in batches
+type Exporter struct {
+	publisher Publisher
+	batchSize int
+	interval  time.Duration
+	queue     chan Event
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// New publishes to the broker of cfg. Connecting waits for the first
+// batch, so a broker that is down does not keep the gateway from starting.
+func New(cfg config.EventsConfig) *Exporter {
+	topic := cfg.Topic
+	if topic == "" {
+		topic = DefaultTopic
+	}
+	var publisher Publisher
+	switch cfg.Broker {
+	case "nats":
+		publisher = NewNATS(cfg.Brokers, topic)
+	default:
+		publisher = NewKafka(cfg.Brokers, topic)
+	}
+	return NewExporter(cfg, publisher)
+}
+
+// NewExporter publishes through publisher, batching and queueing as cfg
+// says
+func NewExporter(cfg config.EventsConfig, publisher Publisher) *Exporter {
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushIntervalMs == 0 {
+		cfg.FlushIntervalMs = 1000
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = 10000
+	}
+	e := &Exporter{
+		publisher: publisher,
+		batchSize: cfg.BatchSize,
+		interval:  time.Duration(cfg.FlushIntervalMs) * time.Millisecond,
+		queue:     make(chan Event, cfg.QueueSize),
+		done:      make(chan struct{}),
+	}
+	e.wg.Add(1)
+	go e.run()
+	return e
+}
+
+// Export queues an event for the request of entry. It never blocks: with
+// the queue full, the event is dropped.
+func (e *Exporter) Export(entry *accesslog.Entry) {
+	event := Event{
+		Time:      entry.Time,
+		Route:     entry.Route,
+		Consumer:  entry.Consumer,
+		Method:    entry.Method,
+		Path:      entry.Path,
+		Status:    entry.Status,
+		LatencyMs: float64(entry.Duration) / float64(time.Millisecond),
+		Bytes:     entry.Bytes,
+		RequestID: entry.RequestID,
+	}
+	select {
+	case e.queue <- event:
+	default:
+		metrics.RecordEventsDropped("queue_full", 1)
+	}
+}
+
+// Close publishes what is queued and disconnects
+func (e *Exporter) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.done)
+		e.wg.Wait()
+	})
+	return e.publisher.Close()
+}
+
+func (e *Exporter) run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, e.batchSize)
+	for {
+		select {
+		case event := <-e.queue:
+			batch = append(batch, event)
+			if len(batch) >= e.batchSize {
+				e.publish(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				e.publish(batch)
+				batch = batch[:0]
+			}
+		case <-e.done:
+			// Drain what was queued before closing
+			for {
+				select {
+				case event := <-e.queue:
+					batch = append(batch, event)
+					if len(batch) >= e.batchSize {
+						e.publish(batch)
+						batch = batch[:0]
+					}
+				default:
+					if len(batch) > 0 {
+						e.publish(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// publish sends batch, retrying with backoff. Requests keep being queued
+// meanwhile, and dropped once the queue is full.
+func (e *Exporter) publish(batch []Event) {
+	messages := make([][]byte, len(batch))
+	for i, event := range batch {
+		messages[i], _ = json.Marshal(event)
+	}
+
+	backoff := retryBackoff
+	var err error
+	for attempt := 1; attempt <= publishAttempts; attempt++ {
+		if err = e.publisher.Publish(messages); err == nil {
+			metrics.RecordEventsPublished(len(messages))
+			return
+		}
+		if attempt == publishAttempts {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-e.done:
+			// Shutting down: one last try, no more waiting
+			attempt = publishAttempts - 1
+		}
+		backoff *= 2
+	}
+	logger.Warn("Failed to publish %d request events: %v", len(messages), err)
+	metrics.RecordEventsDropped("publish_failed", len(messages))
+}
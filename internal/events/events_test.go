@@ -0,0 +1,343 @@
+package events
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/accesslog"
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// recordingPublisher keeps the batches it is given
+type recordingPublisher struct {
+	mu      sync.Mutex
+	batches [][][]byte
+	fail    int
+	block   chan struct{}
+}
+
+func (p *recordingPublisher) Publish(messages [][]byte) error {
+	if p.block != nil {
+		<-p.block
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail > 0 {
+		p.fail--
+		return errors.New("broker down")
+	}
+	p.batches = append(p.batches, messages)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error { return nil }
+
+func (p *recordingPublisher) sizes() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var sizes []int
+	for _, batch := range p.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+func TestExporterBatches(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+	publisher := &recordingPublisher{fail: 1}
+	e := NewExporter(config.EventsConfig{BatchSize: 3, FlushIntervalMs: 20}, publisher)
+
+	for i := 0; i < 4; i++ {
+		e.Export(&accesslog.Entry{
+			Time:     time.Now(),
+			Route:    "orders",
+			Consumer: "hmac:shop",
+			Method:   "GET",
+			Path:     "/api/orders",
+			Status:   200,
+			Bytes:    42,
+			Duration: 1500 * time.Microsecond,
+		})
+	}
+
+	// A full batch goes at once, despite a failed first try; the rest
+	// when the interval ends
+	deadline := time.Now().Add(2 * time.Second)
+	for len(publisher.sizes()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sizes := publisher.sizes(); fmt.Sprint(sizes) != "[3 1]" {
+		t.Fatalf("Expected batches of [3 1], got %v", sizes)
+	}
+
+	var event Event
+	if err := json.Unmarshal(publisher.batches[0][0], &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Route != "orders" || event.Consumer != "hmac:shop" || event.Status != 200 || event.Bytes != 42 || event.LatencyMs != 1.5 {
+		t.Errorf("Expected the request in the event, got %+v", event)
+	}
+	e.Close()
+}
+
+func TestExporterDropsWhenFull(t *testing.T) {
+	publisher := &recordingPublisher{block: make(chan struct{})}
+	e := NewExporter(config.EventsConfig{BatchSize: 1, QueueSize: 2}, publisher)
+
+	// One event is being published, two wait and the rest are dropped
+	for i := 0; i < 10; i++ {
+		e.Export(&accesslog.Entry{Status: 200})
+		time.Sleep(time.Millisecond)
+	}
+	close(publisher.block)
+	e.Close()
+
+	if published := len(publisher.sizes()); published != 3 {
+		t.Errorf("Expected 3 events published, got %d", published)
+	}
+}
+
+func TestNATSPublish(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "PING":
+				conn.Write([]byte("PONG\r\n"))
+			case "PUB":
+				n, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, n+2)
+				io.ReadFull(r, payload)
+				received <- fields[1] + " " + string(payload[:n])
+			}
+		}
+	}()
+
+	n := NewNATS([]string{ln.Addr().String()}, "gatekeeper.requests")
+	defer n.Close()
+	if err := n.Publish([][]byte{[]byte(`{"status":200}`), []byte(`{"status":502}`)}); err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	for _, want := range []string{`gatekeeper.requests {"status":200}`, `gatekeeper.requests {"status":502}`} {
+		if got := <-received; got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestNATSError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {}\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "CONNECT") {
+				conn.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+			}
+		}
+	}()
+
+	n := NewNATS([]string{ln.Addr().String()}, "events")
+	defer n.Close()
+	err = n.Publish([][]byte{[]byte("{}")})
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("Expected the server's error, got %v", err)
+	}
+}
+
+// fakeKafka answers Metadata with itself as the leader of one partition and
+// records what is produced to it
+func fakeKafka(t *testing.T, topic string) (string, <-chan []string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	host, portStr, _ := net.SplitHostPort(ln.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	produced := make(chan []string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					var size [4]byte
+					if _, err := io.ReadFull(conn, size[:]); err != nil {
+						return
+					}
+					req := make([]byte, binary.BigEndian.Uint32(size[:]))
+					if _, err := io.ReadFull(conn, req); err != nil {
+						return
+					}
+					d := decoder{buf: req}
+					key, _, correlation := d.int16(), d.int16(), d.int32()
+					d.string() // client ID
+
+					var resp encoder
+					resp.int32(correlation)
+					switch key {
+					case apiMetadata:
+						resp.int32(0) // throttle
+						resp.int32(1)
+						resp.int32(7)
+						resp.string(host)
+						resp.int32(int32(port))
+						resp.nullString()
+						resp.nullString() // cluster
+						resp.int32(7)     // controller
+						resp.int32(1)
+						resp.int16(0)
+						resp.string(topic)
+						resp.bool(false)
+						resp.int32(1)
+						resp.int16(0)
+						resp.int32(0)
+						resp.int32(7)
+						resp.int32(1)
+						resp.int32(7)
+						resp.int32(1)
+						resp.int32(7)
+					case apiProduce:
+						d.string() // transactional ID
+						d.int16()  // acks
+						d.int32()  // timeout
+						d.int32()
+						d.string()
+						d.int32()
+						d.int32()
+						batch := d.take(int(d.int32()))
+						values, err := readBatch(batch)
+						if err != nil {
+							t.Error(err)
+						}
+						produced <- values
+						resp.int32(1)
+						resp.string(topic)
+						resp.int32(1)
+						resp.int32(0)
+						resp.int16(0)
+						resp.int64(0)
+						resp.int64(-1)
+						resp.int32(0) // throttle
+					}
+					var framed encoder
+					framed.bytes(resp.buf)
+					conn.Write(framed.buf)
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), produced
+}
+
+// readBatch checks a record batch and returns its values
+func readBatch(batch []byte) ([]string, error) {
+	if len(batch) < 61 || batch[16] != 2 {
+		return nil, errors.New("not a version 2 record batch")
+	}
+	if crc := binary.BigEndian.Uint32(batch[17:21]); crc != crc32.Checksum(batch[21:], castagnoli) {
+		return nil, errors.New("record batch CRC does not match")
+	}
+	count := int(binary.BigEndian.Uint32(batch[57:61]))
+	records := batch[61:]
+	var values []string
+	for i := 0; i < count; i++ {
+		length, n := binary.Varint(records)
+		record := records[n : n+int(length)]
+		records = records[n+int(length):]
+
+		record = record[1:] // attributes
+		for field := 0; field < 3; field++ {
+			// timestamp and offset deltas, key length
+			_, n := binary.Varint(record)
+			record = record[n:]
+		}
+		valueLen, n := binary.Varint(record)
+		values = append(values, string(record[n:n+int(valueLen)]))
+	}
+	return values, nil
+}
+
+func TestKafkaPublish(t *testing.T) {
+	address, produced := fakeKafka(t, "requests")
+	k := NewKafka([]string{address}, "requests")
+	defer k.Close()
+
+	for _, batch := range [][][]byte{
+		{[]byte(`{"status":200}`), []byte(`{"status":404}`)},
+		{[]byte(`{"status":503}`)},
+	} {
+		if err := k.Publish(batch); err != nil {
+			t.Fatalf("Expected publish to succeed, got %v", err)
+		}
+	}
+	for _, want := range []string{`[{"status":200} {"status":404}]`, `[{"status":503}]`} {
+		select {
+		case values := <-produced:
+			if got := fmt.Sprint(values); got != want {
+				t.Errorf("Expected %s, got %s", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected a produce request")
+		}
+	}
+}
+
+func TestKafkaUnreachable(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := ln.Addr().String()
+	ln.Close()
+
+	k := NewKafka([]string{address}, "requests")
+	if err := k.Publish([][]byte{[]byte("{}")}); err == nil {
+		t.Error("Expected an error without a broker")
+	}
+}
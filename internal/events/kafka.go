@@ -0,0 +1,405 @@
+package events
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kafka API keys and the versions spoken: Produce v3 is the first with
+// record batches and Metadata v4 the first Kafka 4 still accepts
+const (
+	apiProduce      = 0
+	apiMetadata     = 3
+	produceVersion  = 3
+	metadataVersion = 4
+)
+
+// kafkaClientID names the gateway to brokers
+const kafkaClientID = "gatekeeper"
+
+// castagnoli is the CRC record batches are checked with
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Kafka produces to a topic, speaking the Kafka protocol directly. Each
+// batch goes to the next partition in turn, and is acknowledged by the
+// partition leader.
+type Kafka struct {
+	brokers []string
+	topic   string
+
+	mu          sync.Mutex
+	conns       map[int32]net.Conn
+	leaders     map[int32]string // partition leader addresses by node ID
+	partitions  []kafkaPartition
+	next        int
+	correlation int32
+}
+
+type kafkaPartition struct {
+	id     int32
+	leader int32
+}
+
+// NewKafka produces to topic through the cluster of brokers,
+// localhost:9092 when none are given
+func NewKafka(brokers []string, topic string) *Kafka {
+	if len(brokers) == 0 {
+		brokers = []string{"localhost:9092"}
+	}
+	return &Kafka{brokers: brokers, topic: topic, conns: make(map[int32]net.Conn)}
+}
+
+// Publish produces messages as one record batch
+func (k *Kafka) Publish(messages [][]byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.partitions) == 0 {
+		if err := k.refreshMetadata(); err != nil {
+			return err
+		}
+	}
+	partition := k.partitions[k.next%len(k.partitions)]
+	k.next++
+
+	if err := k.produce(partition, messages); err != nil {
+		// Leaders may have moved; look them up again next time
+		k.disconnect()
+		return err
+	}
+	return nil
+}
+
+// refreshMetadata asks the brokers in turn where the topic's partitions
+// are led
+func (k *Kafka) refreshMetadata() error {
+	var err error
+	for _, broker := range k.brokers {
+		var conn net.Conn
+		conn, err = net.DialTimeout("tcp", broker, brokerTimeout)
+		if err != nil {
+			continue
+		}
+		err = k.metadata(conn)
+		conn.Close()
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to get Kafka metadata for %s: %w", k.topic, err)
+}
+
+func (k *Kafka) metadata(conn net.Conn) error {
+	var req encoder
+	req.int32(1) // topics
+	req.string(k.topic)
+	req.bool(true) // allow auto topic creation
+	resp, err := k.roundTrip(conn, apiMetadata, metadataVersion, req.buf)
+	if err != nil {
+		return err
+	}
+
+	d := decoder{buf: resp}
+	d.int32() // throttle time
+	leaders := make(map[int32]string)
+	for i := d.int32(); i > 0 && d.err == nil; i-- {
+		node := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		leaders[node] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.string() // cluster ID
+	d.int32()  // controller ID
+
+	var partitions []kafkaPartition
+	for i := d.int32(); i > 0 && d.err == nil; i-- {
+		if code := d.int16(); code != 0 {
+			return kafkaError(code)
+		}
+		name := d.string()
+		d.bool() // internal
+		for j := d.int32(); j > 0 && d.err == nil; j-- {
+			d.int16() // error code; an unavailable leader fails the produce
+			p := kafkaPartition{id: d.int32(), leader: d.int32()}
+			d.int32s() // replicas
+			d.int32s() // in-sync replicas
+			if name == k.topic && p.leader >= 0 {
+				partitions = append(partitions, p)
+			}
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(partitions) == 0 {
+		return errors.New("topic has no partitions with a leader")
+	}
+	k.leaders = leaders
+	k.partitions = partitions
+	return nil
+}
+
+// produce sends messages to partition and waits for its leader to
+// acknowledge them
+func (k *Kafka) produce(partition kafkaPartition, messages [][]byte) error {
+	conn, err := k.conn(partition.leader)
+	if err != nil {
+		return err
+	}
+
+	batch := recordBatch(messages, time.Now())
+	var req encoder
+	req.nullString() // transactional ID
+	req.int16(1)     // acks: the leader
+	req.int32(int32(brokerTimeout / time.Millisecond))
+	req.int32(1) // topics
+	req.string(k.topic)
+	req.int32(1) // partitions
+	req.int32(partition.id)
+	req.bytes(batch)
+	resp, err := k.roundTrip(conn, apiProduce, produceVersion, req.buf)
+	if err != nil {
+		return err
+	}
+
+	d := decoder{buf: resp}
+	for i := d.int32(); i > 0 && d.err == nil; i-- {
+		d.string() // topic
+		for j := d.int32(); j > 0 && d.err == nil; j-- {
+			d.int32() // partition
+			if code := d.int16(); code != 0 {
+				return kafkaError(code)
+			}
+			d.int64() // base offset
+			d.int64() // log append time
+		}
+	}
+	return d.err
+}
+
+// conn returns a connection to the broker with the node ID, dialing it
+// when there is none
+func (k *Kafka) conn(node int32) (net.Conn, error) {
+	if conn, ok := k.conns[node]; ok {
+		return conn, nil
+	}
+	address, ok := k.leaders[node]
+	if !ok {
+		return nil, fmt.Errorf("unknown Kafka broker %d", node)
+	}
+	conn, err := net.DialTimeout("tcp", address, brokerTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kafka broker %s: %w", address, err)
+	}
+	k.conns[node] = conn
+	return conn, nil
+}
+
+// roundTrip sends a request and reads its response body
+func (k *Kafka) roundTrip(conn net.Conn, key, version int16, body []byte) ([]byte, error) {
+	k.correlation++
+	var req encoder
+	req.int32(0) // size, set below
+	req.int16(key)
+	req.int16(version)
+	req.int32(k.correlation)
+	req.string(kafkaClientID)
+	req.buf = append(req.buf, body...)
+	binary.BigEndian.PutUint32(req.buf, uint32(len(req.buf)-4))
+
+	conn.SetDeadline(time.Now().Add(brokerTimeout))
+	if _, err := conn.Write(req.buf); err != nil {
+		return nil, err
+	}
+	var header [8]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size < 4 || size > 64<<20 {
+		return nil, fmt.Errorf("kafka: invalid response size %d", size)
+	}
+	if correlation := int32(binary.BigEndian.Uint32(header[4:])); correlation != k.correlation {
+		return nil, fmt.Errorf("kafka: response %d does not match request %d", correlation, k.correlation)
+	}
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// disconnect closes every connection and forgets the partition leaders
+func (k *Kafka) disconnect() {
+	for node, conn := range k.conns {
+		conn.Close()
+		delete(k.conns, node)
+	}
+	k.partitions = nil
+}
+
+// Close disconnects from the brokers
+func (k *Kafka) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.disconnect()
+	return nil
+}
+
+// recordBatch encodes messages as a Kafka record batch, version 2,
+// without keys or compression
+func recordBatch(messages [][]byte, now time.Time) []byte {
+	timestamp := now.UnixMilli()
+
+	var records encoder
+	for i, message := range messages {
+		var record encoder
+		record.int8(0)   // attributes
+		record.varint(0) // timestamp delta
+		record.varint(int64(i))
+		record.varint(-1) // no key
+		record.varint(int64(len(message)))
+		record.buf = append(record.buf, message...)
+		record.varint(0) // headers
+		records.varint(int64(len(record.buf)))
+		records.buf = append(records.buf, record.buf...)
+	}
+
+	// Everything after the CRC is checked by it
+	var checked encoder
+	checked.int16(0) // attributes
+	checked.int32(int32(len(messages) - 1))
+	checked.int64(timestamp)
+	checked.int64(timestamp)
+	checked.int64(-1) // producer ID
+	checked.int16(-1) // producer epoch
+	checked.int32(-1) // base sequence
+	checked.int32(int32(len(messages)))
+	checked.buf = append(checked.buf, records.buf...)
+
+	var batch encoder
+	batch.int64(0) // base offset
+	batch.int32(int32(4 + 1 + 4 + len(checked.buf)))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(checked.buf, castagnoli)))
+	batch.buf = append(batch.buf, checked.buf...)
+	return batch.buf
+}
+
+// kafkaError names the error codes a producer is likely to meet
+func kafkaError(code int16) error {
+	names := map[int16]string{
+		3:  "unknown topic or partition",
+		5:  "leader not available",
+		6:  "not leader for partition",
+		7:  "request timed out",
+		10: "message too large",
+		29: "topic authorization failed",
+	}
+	if name, ok := names[code]; ok {
+		return fmt.Errorf("kafka: %s", name)
+	}
+	return fmt.Errorf("kafka: error code %d", code)
+}
+
+// encoder appends values in the Kafka protocol's big-endian encoding
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int8(v int8)   { e.buf = append(e.buf, byte(v)) }
+func (e *encoder) int16(v int16) { e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v)) }
+func (e *encoder) int32(v int32) { e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v)) }
+func (e *encoder) int64(v int64) { e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v)) }
+func (e *encoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.int8(1)
+	} else {
+		e.int8(0)
+	}
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) nullString() { e.int16(-1) }
+
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// decoder reads values in the Kafka protocol's encoding, remembering the
+// first error so a response is checked once at the end
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf) {
+		d.err = errors.New("kafka: truncated response")
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) bool() bool {
+	b := d.take(1)
+	return b != nil && b[0] != 0
+}
+
+// string reads a string; a null one reads as empty
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+func (d *decoder) int32s() {
+	for i := d.int32(); i > 0 && d.err == nil; i-- {
+		d.int32()
+	}
+}
@@ -0,0 +1,139 @@
+package events
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// brokerTimeout bounds connecting to a broker and each exchange with it
+const brokerTimeout = 5 * time.Second
+
+// NATS publishes to a subject on a NATS server, speaking the client
+// protocol directly
+type NATS struct {
+	servers []string
+	subject string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewNATS publishes to subject on the first of servers that answers,
+// localhost:4222 when none are given
+func NewNATS(servers []string, subject string) *NATS {
+	if len(servers) == 0 {
+		servers = []string{"localhost:4222"}
+	}
+	return &NATS{servers: servers, subject: subject}
+}
+
+// Publish sends every message, then waits for the server to answer a PING
+// so messages it rejected show up as an error
+func (n *NATS) Publish(messages [][]byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		if err := n.connect(); err != nil {
+			return err
+		}
+	}
+	if err := n.publish(messages); err != nil {
+		n.disconnect()
+		return err
+	}
+	return nil
+}
+
+func (n *NATS) publish(messages [][]byte) error {
+	n.conn.SetDeadline(time.Now().Add(brokerTimeout))
+	w := bufio.NewWriter(n.conn)
+	for _, message := range messages {
+		fmt.Fprintf(w, "PUB %s %d\r\n", n.subject, len(message))
+		w.Write(message)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("PING\r\n")
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return n.awaitPong()
+}
+
+// connect dials the servers in turn and completes the handshake
+func (n *NATS) connect() error {
+	var err error
+	for _, server := range n.servers {
+		var conn net.Conn
+		conn, err = net.DialTimeout("tcp", server, brokerTimeout)
+		if err != nil {
+			continue
+		}
+		n.conn = conn
+		n.r = bufio.NewReader(conn)
+		if err = n.handshake(); err == nil {
+			return nil
+		}
+		n.disconnect()
+	}
+	return fmt.Errorf("failed to connect to NATS: %w", err)
+}
+
+// handshake reads the server's INFO and introduces the gateway
+func (n *NATS) handshake() error {
+	n.conn.SetDeadline(time.Now().Add(brokerTimeout))
+	line, err := n.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+	if _, err := n.conn.Write([]byte(`CONNECT {"verbose":false,"pedantic":false,"name":"gatekeeper","lang":"go","version":"1.0.0"}` + "\r\nPING\r\n")); err != nil {
+		return err
+	}
+	return n.awaitPong()
+}
+
+// awaitPong reads until the PONG that answers our PING, answering the
+// server's own PINGs on the way
+func (n *NATS) awaitPong() error {
+	for {
+		line, err := n.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := n.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("nats: " + strings.Trim(strings.TrimPrefix(line, "-ERR"), " '"))
+		}
+	}
+}
+
+func (n *NATS) disconnect() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+		n.r = nil
+	}
+}
+
+// Close disconnects from the server
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.disconnect()
+	return nil
+}
@@ -0,0 +1,130 @@
+// Package expr evaluates CEL expressions over requests, for conditions and
+// values written inline in the config. An expression sees the request as
+// `request`, a map of:
+//
+//	method, path, host, scheme  strings
+//	query                       first value of each query parameter
+//	header                      headers by canonical name, values joined by ","
+//	remoteAddr                  the client's IP
+//	consumer                    the identity authentication verified, or ""
+//
+// e.g. request.header["X-Tenant"] == "acme" && request.path.startsWith("/v2").
+// A missing header or parameter is an error rather than "", so optional
+// ones are tested first: "X-Tenant" in request.header.
+package expr
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+)
+
+// Input is what an expression is evaluated against
+type Input struct {
+	Request  *http.Request
+	ClientIP string
+	Consumer string
+}
+
+// Program is a compiled expression
+type Program struct {
+	source  string
+	program cel.Program
+}
+
+var newEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)))
+})
+
+// Compile compiles a condition, which must evaluate to a bool
+func Compile(source string) (*Program, error) {
+	return compile(source, cel.BoolType)
+}
+
+// CompileString compiles an expression that evaluates to a string
+func CompileString(source string) (*Program, error) {
+	return compile(source, cel.StringType)
+}
+
+func compile(source string, want *cel.Type) (*Program, error) {
+	env, err := newEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(source)
+	if issues.Err() != nil {
+		return nil, fmt.Errorf("expression %q: %w", source, issues.Err())
+	}
+	// Values taken from the request map are only known when evaluated
+	if out := ast.OutputType(); !out.IsExactType(want) && !out.IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("expression %q is a %s, not a %s", source, out, want)
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", source, err)
+	}
+	return &Program{source: source, program: program}, nil
+}
+
+func (p *Program) String() string {
+	return p.source
+}
+
+// Bool evaluates a condition
+func (p *Program) Bool(in Input) (bool, error) {
+	out, _, err := p.program.Eval(activation(in))
+	if err != nil {
+		return false, err
+	}
+	b, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q returned %v, not a bool", p.source, out.Value())
+	}
+	return b, nil
+}
+
+// Value evaluates an expression that returns a string
+func (p *Program) Value(in Input) (string, error) {
+	out, _, err := p.program.Eval(activation(in))
+	if err != nil {
+		return "", err
+	}
+	s, ok := out.Value().(string)
+	if !ok {
+		return "", fmt.Errorf("expression %q returned %v, not a string", p.source, out.Value())
+	}
+	return s, nil
+}
+
+func activation(in Input) map[string]interface{} {
+	r := in.Request
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	query := make(map[string]string)
+	for name, values := range r.URL.Query() {
+		query[name] = values[0]
+	}
+	header := make(map[string]string, len(r.Header))
+	for name, values := range r.Header {
+		header[http.CanonicalHeaderKey(name)] = strings.Join(values, ",")
+	}
+
+	return map[string]interface{}{
+		"request": map[string]interface{}{
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"host":       r.Host,
+			"scheme":     scheme,
+			"query":      query,
+			"header":     header,
+			"remoteAddr": in.ClientIP,
+			"consumer":   in.Consumer,
+		},
+	}
+}
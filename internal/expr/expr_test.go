@@ -0,0 +1,57 @@
+package expr
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBool(t *testing.T) {
+	req := httptest.NewRequest("POST", "https://api.example.com/v2/orders?limit=10", nil)
+	req.Header.Set("X-Tenant", "acme")
+	in := Input{Request: req, ClientIP: "10.0.0.1", Consumer: "hmac:shop"}
+
+	testCases := []struct {
+		source string
+		want   bool
+	}{
+		{`request.header["X-Tenant"] == "acme" && request.path.startsWith("/v2")`, true},
+		{`request.method == "POST" && request.scheme == "https" && request.host == "api.example.com"`, true},
+		{`request.query["limit"] == "10" && !("offset" in request.query)`, true},
+		{`request.consumer.startsWith("hmac:") && request.remoteAddr == "10.0.0.1"`, true},
+		{`"X-Plan" in request.header`, false},
+	}
+	for _, tc := range testCases {
+		p, err := Compile(tc.source)
+		if err != nil {
+			t.Fatalf("Compile(%s): %v", tc.source, err)
+		}
+		if got, err := p.Bool(in); err != nil || got != tc.want {
+			t.Errorf("%s: expected %v, got %v (%v)", tc.source, tc.want, got, err)
+		}
+	}
+
+	p, _ := Compile(`request.header["X-Plan"] == "gold"`)
+	if _, err := p.Bool(in); err == nil {
+		t.Error("Expected a missing header to be an error")
+	}
+}
+
+func TestCompileChecksTypes(t *testing.T) {
+	if _, err := Compile(`"acme"`); err == nil {
+		t.Error("Expected a string to be rejected as a condition")
+	}
+	if _, err := CompileString(`1 + 1`); err == nil {
+		t.Error("Expected an int to be rejected as a value")
+	}
+	if _, err := Compile(`request.path.startsWith(`); err == nil {
+		t.Error("Expected a syntax error")
+	}
+
+	p, err := CompileString(`request.method + " " + request.path`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := p.Value(Input{Request: httptest.NewRequest("GET", "/x", nil)}); err != nil || v != "GET /x" {
+		t.Errorf("Expected %q, got %q (%v)", "GET /x", v, err)
+	}
+}
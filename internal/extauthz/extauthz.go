@@ -0,0 +1,69 @@
+// Package extauthz asks an external service whether a request may proceed.
+// The gRPC checker speaks Envoy's envoy.service.auth.v3.Authorization API so
+// existing policy engines (OPA, Authorino, ...) can be reused; the HTTP
+// checker follows Envoy's HTTP ext_authz conventions.
+package extauthz
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Request is the metadata sent to the authorization service
+type Request struct {
+	Method   string
+	Path     string
+	Host     string
+	Scheme   string
+	Protocol string
+	Headers  map[string]string
+	ClientIP string
+}
+
+// Decision is the authorization service's answer. On allow, Headers are
+// added to the upstream request; on deny, Status, Headers and Body are sent
+// to the client.
+type Decision struct {
+	Allowed bool
+	Status  int
+	Headers http.Header
+	Body    string
+}
+
+// Checker asks an authorization service for a decision
+type Checker interface {
+	Check(ctx context.Context, req *Request) (*Decision, error)
+}
+
+// FromHTTP builds the check request for an incoming request. Only headers
+// listed in allowed are included, or all of them when allowed is empty.
+func FromHTTP(r *http.Request, clientIP string, allowed []string) *Request {
+	headers := make(map[string]string)
+	if len(allowed) == 0 {
+		for name, values := range r.Header {
+			headers[http.CanonicalHeaderKey(name)] = strings.Join(values, ",")
+		}
+	} else {
+		for _, name := range allowed {
+			if values := r.Header.Values(name); len(values) > 0 {
+				headers[http.CanonicalHeaderKey(name)] = strings.Join(values, ",")
+			}
+		}
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return &Request{
+		Method:   r.Method,
+		Path:     r.URL.RequestURI(),
+		Host:     r.Host,
+		Scheme:   scheme,
+		Protocol: r.Proto,
+		Headers:  headers,
+		ClientIP: clientIP,
+	}
+}
@@ -0,0 +1,148 @@
+package extauthz
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestHTTPChecker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer ok" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("denied by policy"))
+			return
+		}
+		if r.URL.Path != "/orders/1" || r.Method != "DELETE" {
+			t.Errorf("Expected original method and path, got %s %s", r.Method, r.URL.Path)
+		}
+		w.Header().Set("X-User-ID", "42")
+		w.Header().Set("X-Internal", "not forwarded")
+	}))
+	defer server.Close()
+
+	checker := NewHTTPChecker(server.URL, []string{"X-User-ID"}, &http.Client{Timeout: time.Second})
+
+	req := httptest.NewRequest("DELETE", "/orders/1", nil)
+	req.Header.Set("Authorization", "Bearer ok")
+	decision, err := checker.Check(context.Background(), FromHTTP(req, "10.0.0.1:5000", nil))
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !decision.Allowed || decision.Headers.Get("X-User-ID") != "42" || decision.Headers.Get("X-Internal") != "" {
+		t.Errorf("Unexpected allow decision: %+v", decision)
+	}
+
+	req.Header.Set("Authorization", "Bearer nope")
+	decision, err = checker.Check(context.Background(), FromHTTP(req, "10.0.0.1:5000", nil))
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if decision.Allowed || decision.Status != http.StatusUnauthorized || decision.Body != "denied by policy" {
+		t.Errorf("Unexpected deny decision: %+v", decision)
+	}
+}
+
+func TestFromHTTPFiltersHeaders(t *testing.T) {
+	req := httptest.NewRequest("GET", "/a?b=c", nil)
+	req.Header.Set("Authorization", "Bearer x")
+	req.Header.Set("Cookie", "secret")
+
+	check := FromHTTP(req, "10.0.0.1:5000", []string{"authorization"})
+	if len(check.Headers) != 1 || check.Headers["Authorization"] != "Bearer x" {
+		t.Errorf("Expected only the allowed header, got %v", check.Headers)
+	}
+	if check.Path != "/a?b=c" {
+		t.Errorf("Expected path with query, got %v", check.Path)
+	}
+}
+
+// authorizer allows requests with "Bearer ok" and limits the rest
+type authorizer struct {
+	authv3.UnimplementedAuthorizationServer
+	t *testing.T
+}
+
+func (a *authorizer) Check(_ context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	if httpReq.GetMethod() != "GET" || httpReq.GetPath() != "/orders" {
+		a.t.Errorf("Expected GET /orders, got %s %s", httpReq.GetMethod(), httpReq.GetPath())
+	}
+	if socket := req.GetAttributes().GetSource().GetAddress().GetSocketAddress(); socket.GetAddress() != "10.0.0.1" || socket.GetPortValue() != 5000 {
+		a.t.Errorf("Expected source 10.0.0.1:5000, got %v", socket)
+	}
+
+	if httpReq.GetHeaders()["authorization"] == "Bearer ok" {
+		return &authv3.CheckResponse{
+			Status: &status.Status{},
+			HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{
+				Headers: []*corev3.HeaderValueOption{{Header: &corev3.HeaderValue{Key: "x-user-id", Value: "42"}}},
+			}},
+		}, nil
+	}
+	return &authv3.CheckResponse{
+		Status: &status.Status{Code: 7},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+			Status:  &typev3.HttpStatus{Code: typev3.StatusCode_TooManyRequests},
+			Headers: []*corev3.HeaderValueOption{{Header: &corev3.HeaderValue{Key: "x-reason", Value: "blocked"}}},
+			Body:    "slow down",
+		}},
+	}, nil
+}
+
+func TestGRPCChecker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	authv3.RegisterAuthorizationServer(server, &authorizer{t: t})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	checker := NewGRPCChecker(conn, time.Second)
+
+	check := &Request{Method: "GET", Path: "/orders", Headers: map[string]string{"Authorization": "Bearer ok"}, ClientIP: "10.0.0.1:5000"}
+	decision, err := checker.Check(context.Background(), check)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !decision.Allowed || decision.Status != http.StatusOK || decision.Headers.Get("X-User-ID") != "42" {
+		t.Errorf("Unexpected allow decision: %+v", decision)
+	}
+
+	check.Headers["Authorization"] = "Bearer nope"
+	decision, err = checker.Check(context.Background(), check)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if decision.Allowed || decision.Status != 429 || decision.Body != "slow down" || decision.Headers.Get("X-Reason") != "blocked" {
+		t.Errorf("Unexpected deny decision: %+v", decision)
+	}
+}
+
+func TestDecisionWithoutStatus(t *testing.T) {
+	if d := decision(&authv3.CheckResponse{}); !d.Allowed || d.Status != http.StatusOK {
+		t.Errorf("Expected an empty response to mean OK, got %+v", d)
+	}
+	denied := &authv3.CheckResponse{Status: &status.Status{Code: 7}}
+	if d := decision(denied); d.Allowed || d.Status != http.StatusForbidden {
+		t.Errorf("Expected a denial without a status to be 403, got %+v", d)
+	}
+}
@@ -0,0 +1,105 @@
+package extauthz
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc"
+)
+
+// GRPCChecker calls envoy.service.auth.v3.Authorization/Check
+type GRPCChecker struct {
+	client  authv3.AuthorizationClient
+	timeout time.Duration
+}
+
+// NewGRPCChecker checks requests over conn, giving the service timeout to
+// answer each
+func NewGRPCChecker(conn grpc.ClientConnInterface, timeout time.Duration) *GRPCChecker {
+	return &GRPCChecker{client: authv3.NewAuthorizationClient(conn), timeout: timeout}
+}
+
+func (c *GRPCChecker) Check(ctx context.Context, req *Request) (*Decision, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	resp, err := c.client.Check(ctx, checkRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	return decision(resp), nil
+}
+
+// checkRequest carries the source peer address and the HTTP request
+// attributes
+func checkRequest(req *Request) *authv3.CheckRequest {
+	host, port := req.ClientIP, ""
+	if h, p, err := net.SplitHostPort(req.ClientIP); err == nil {
+		host, port = h, p
+	}
+	socket := &corev3.SocketAddress{Address: host}
+	if n, err := strconv.ParseUint(port, 10, 32); err == nil {
+		socket.PortSpecifier = &corev3.SocketAddress_PortValue{PortValue: uint32(n)}
+	}
+
+	// Envoy presents header names in lower case
+	headers := make(map[string]string, len(req.Headers))
+	for name, value := range req.Headers {
+		headers[strings.ToLower(name)] = value
+	}
+
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source: &authv3.AttributeContext_Peer{
+				Address: &corev3.Address{Address: &corev3.Address_SocketAddress{SocketAddress: socket}},
+			},
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Method:   req.Method,
+					Headers:  headers,
+					Path:     req.Path,
+					Host:     req.Host,
+					Scheme:   req.Scheme,
+					Protocol: req.Protocol,
+				},
+			},
+		},
+	}
+}
+
+// decision reads the status and the denied or OK response. An omitted
+// status is the zero value, OK.
+func decision(resp *authv3.CheckResponse) *Decision {
+	d := &Decision{Headers: http.Header{}, Allowed: resp.GetStatus().GetCode() == 0}
+	if denied := resp.GetDeniedResponse(); denied != nil {
+		d.Status = int(denied.GetStatus().GetCode())
+		addHeaders(d.Headers, denied.GetHeaders())
+		d.Body = denied.GetBody()
+	}
+	if ok := resp.GetOkResponse(); ok != nil {
+		addHeaders(d.Headers, ok.GetHeaders())
+	}
+
+	if d.Allowed {
+		d.Status = http.StatusOK
+	} else if d.Status == 0 {
+		d.Status = http.StatusForbidden
+	}
+	return d
+}
+
+func addHeaders(headers http.Header, options []*corev3.HeaderValueOption) {
+	for _, option := range options {
+		if key := option.GetHeader().GetKey(); key != "" {
+			headers.Add(key, option.GetHeader().GetValue())
+		}
+	}
+}
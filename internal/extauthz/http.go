@@ -0,0 +1,78 @@
+package extauthz
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// hopHeaders must not be copied to the authorization request
+var hopHeaders = []string{"Connection", "Content-Length", "Transfer-Encoding", "Upgrade", "Te", "Trailer", "Keep-Alive"}
+
+// HTTPChecker replays the request line and headers (without the body) to
+// an authorization server. Any 2xx allows the request; anything else is
+// relayed to the client as the denial.
+type HTTPChecker struct {
+	baseURL         string
+	upstreamHeaders []string
+	client          *http.Client
+}
+
+// NewHTTPChecker creates a checker for the server at baseURL. On allow, the
+// listed upstreamHeaders are copied from the authorization response to the
+// upstream request.
+func NewHTTPChecker(baseURL string, upstreamHeaders []string, client *http.Client) *HTTPChecker {
+	return &HTTPChecker{
+		baseURL:         strings.TrimSuffix(baseURL, "/"),
+		upstreamHeaders: upstreamHeaders,
+		client:          client,
+	}
+}
+
+func (c *HTTPChecker) Check(ctx context.Context, req *Request) (*Decision, error) {
+	checkReq, err := http.NewRequestWithContext(ctx, req.Method, c.baseURL+req.Path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range req.Headers {
+		checkReq.Header.Set(name, value)
+	}
+	for _, name := range hopHeaders {
+		checkReq.Header.Del(name)
+	}
+	checkReq.Host = req.Host
+	checkReq.Header.Set("X-Forwarded-For", req.ClientIP)
+	checkReq.Header.Set("X-Forwarded-Proto", req.Scheme)
+
+	resp, err := c.client.Do(checkReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		decision := &Decision{Allowed: true, Status: resp.StatusCode, Headers: http.Header{}}
+		for _, name := range c.upstreamHeaders {
+			if values := resp.Header.Values(name); len(values) > 0 {
+				decision.Headers[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+		return decision, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+
+	headers := resp.Header.Clone()
+	headers.Del("Content-Length")
+	headers.Del("Date")
+	return &Decision{
+		Allowed: false,
+		Status:  resp.StatusCode,
+		Headers: headers,
+		Body:    string(body),
+	}, nil
+}
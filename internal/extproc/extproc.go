@@ -0,0 +1,286 @@
+// Package extproc lets an external service see and change requests and
+// responses as they pass. It speaks Envoy's
+// envoy.service.ext_proc.v3.ExternalProcessor API, one stream per request,
+// so existing ext_proc services can be reused.
+package extproc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"google.golang.org/grpc"
+)
+
+// Phase is the part of the exchange a message is about. Its value is the
+// ProcessingRequest field that carries it.
+type Phase int
+
+const (
+	RequestHeaders  Phase = 2
+	ResponseHeaders Phase = 3
+	RequestBody     Phase = 4
+	ResponseBody    Phase = 5
+)
+
+func (p Phase) String() string {
+	switch p {
+	case RequestHeaders:
+		return "request headers"
+	case ResponseHeaders:
+		return "response headers"
+	case RequestBody:
+		return "request body"
+	case ResponseBody:
+		return "response body"
+	}
+	return fmt.Sprintf("phase %d", int(p))
+}
+
+// Header is a header, or a pseudo-header such as ":path" or ":status"
+type Header struct {
+	Key   string
+	Value string
+}
+
+// SetHeader is a header the service sets. With Append the value is added
+// to existing ones, with IfAbsent it is only added when there are none.
+type SetHeader struct {
+	Header
+	Append   bool
+	IfAbsent bool
+}
+
+// Mutation is how the service changes headers, and a body when one was
+// sent: Body replaces it when ReplaceBody is set.
+type Mutation struct {
+	Set         []SetHeader
+	Remove      []string
+	ReplaceBody bool
+	Body        []byte
+}
+
+// Apply changes headers. Pseudo-headers are left to the caller.
+func (m *Mutation) Apply(headers http.Header) {
+	for _, name := range m.Remove {
+		if name != "" && name[0] != ':' {
+			headers.Del(name)
+		}
+	}
+	for _, h := range m.Set {
+		if h.Key == "" || h.Key[0] == ':' {
+			continue
+		}
+		switch {
+		case h.IfAbsent:
+			if headers.Get(h.Key) == "" {
+				headers.Set(h.Key, h.Value)
+			}
+		case h.Append:
+			headers.Add(h.Key, h.Value)
+		default:
+			headers.Set(h.Key, h.Value)
+		}
+	}
+}
+
+// Pseudo returns the value the mutation sets for a pseudo-header
+func (m *Mutation) Pseudo(name string) (string, bool) {
+	for _, h := range m.Set {
+		if h.Key == name {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+// Immediate is a response the service sends instead of going on
+type Immediate struct {
+	Status  int
+	Headers Mutation
+	Body    []byte
+}
+
+// Response is the service's answer to one message: a mutation, or an
+// immediate response that ends the exchange
+type Response struct {
+	Phase     Phase
+	Mutation  Mutation
+	Immediate *Immediate
+}
+
+// Client opens exchanges with an ext_proc service. The service has timeout
+// to answer each message.
+type Client struct {
+	client  extprocv3.ExternalProcessorClient
+	timeout time.Duration
+}
+
+func New(conn grpc.ClientConnInterface, timeout time.Duration) *Client {
+	return &Client{client: extprocv3.NewExternalProcessorClient(conn), timeout: timeout}
+}
+
+// Exchange is the stream for one request. Each message sent is answered
+// before the next is sent.
+type Exchange struct {
+	stream  extprocv3.ExternalProcessor_ProcessClient
+	cancel  context.CancelFunc
+	timeout time.Duration
+}
+
+// Open starts the exchange for a request. Close it when the request is done.
+func (c *Client) Open(ctx context.Context) (*Exchange, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.client.Process(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Exchange{stream: stream, cancel: cancel, timeout: c.timeout}, nil
+}
+
+// Headers sends the request or response headers, pseudo-headers included,
+// and returns the service's answer. endOfStream tells it no body follows.
+func (e *Exchange) Headers(phase Phase, headers []Header, endOfStream bool) (*Response, error) {
+	headerMap := &corev3.HeaderMap{}
+	for _, h := range headers {
+		// Raw values, as Envoy sends them
+		headerMap.Headers = append(headerMap.Headers, &corev3.HeaderValue{Key: h.Key, RawValue: []byte(h.Value)})
+	}
+	msg := &extprocv3.HttpHeaders{Headers: headerMap, EndOfStream: endOfStream}
+
+	req := &extprocv3.ProcessingRequest{}
+	switch phase {
+	case RequestHeaders:
+		req.Request = &extprocv3.ProcessingRequest_RequestHeaders{RequestHeaders: msg}
+	case ResponseHeaders:
+		req.Request = &extprocv3.ProcessingRequest_ResponseHeaders{ResponseHeaders: msg}
+	default:
+		return nil, fmt.Errorf("extproc: %s is not a headers phase", phase)
+	}
+	return e.send(phase, req)
+}
+
+// Body sends a whole request or response body and returns the service's
+// answer
+func (e *Exchange) Body(phase Phase, body []byte) (*Response, error) {
+	msg := &extprocv3.HttpBody{Body: body, EndOfStream: true}
+
+	req := &extprocv3.ProcessingRequest{}
+	switch phase {
+	case RequestBody:
+		req.Request = &extprocv3.ProcessingRequest_RequestBody{RequestBody: msg}
+	case ResponseBody:
+		req.Request = &extprocv3.ProcessingRequest_ResponseBody{ResponseBody: msg}
+	default:
+		return nil, fmt.Errorf("extproc: %s is not a body phase", phase)
+	}
+	return e.send(phase, req)
+}
+
+func (e *Exchange) send(phase Phase, req *extprocv3.ProcessingRequest) (resp *Response, err error) {
+	if e.timeout > 0 {
+		timer := time.AfterFunc(e.timeout, e.cancel)
+		defer func() {
+			if !timer.Stop() {
+				resp, err = nil, fmt.Errorf("extproc: no answer to the %s within %s", phase, e.timeout)
+			}
+		}()
+	}
+
+	if err := e.stream.Send(req); err != nil {
+		return nil, err
+	}
+	msg, err := e.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	resp, err = response(msg)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Immediate == nil && resp.Phase != phase {
+		return nil, fmt.Errorf("extproc: expected an answer to the %s, got one to the %s", phase, resp.Phase)
+	}
+	return resp, nil
+}
+
+// Close ends the exchange
+func (e *Exchange) Close() {
+	e.stream.CloseSend()
+	e.cancel()
+}
+
+// response reads the answer to a headers or body message, or an immediate
+// response
+func response(msg *extprocv3.ProcessingResponse) (*Response, error) {
+	resp := &Response{}
+	var common *extprocv3.CommonResponse
+	switch r := msg.GetResponse().(type) {
+	case *extprocv3.ProcessingResponse_RequestHeaders:
+		resp.Phase, common = RequestHeaders, r.RequestHeaders.GetResponse()
+	case *extprocv3.ProcessingResponse_ResponseHeaders:
+		resp.Phase, common = ResponseHeaders, r.ResponseHeaders.GetResponse()
+	case *extprocv3.ProcessingResponse_RequestBody:
+		resp.Phase, common = RequestBody, r.RequestBody.GetResponse()
+	case *extprocv3.ProcessingResponse_ResponseBody:
+		resp.Phase, common = ResponseBody, r.ResponseBody.GetResponse()
+	case *extprocv3.ProcessingResponse_ImmediateResponse:
+		resp.Immediate = immediate(r.ImmediateResponse)
+		return resp, nil
+	default:
+		return nil, errors.New("extproc: response answers nothing")
+	}
+
+	resp.Mutation = headerMutation(common.GetHeaderMutation())
+	switch body := common.GetBodyMutation().GetMutation().(type) {
+	case *extprocv3.BodyMutation_Body:
+		resp.Mutation.ReplaceBody, resp.Mutation.Body = true, body.Body
+	case *extprocv3.BodyMutation_ClearBody:
+		if body.ClearBody {
+			resp.Mutation.ReplaceBody, resp.Mutation.Body = true, nil
+		}
+	}
+	return resp, nil
+}
+
+// headerMutation reads the headers to set and remove. A header without
+// append or append_action replaces the existing one, as ext_proc does.
+func headerMutation(m *extprocv3.HeaderMutation) Mutation {
+	var mutation Mutation
+	for _, option := range m.GetSetHeaders() {
+		h := SetHeader{
+			Header:   header(option.GetHeader()),
+			Append:   option.GetAppend().GetValue(),
+			IfAbsent: option.GetAppendAction() == corev3.HeaderValueOption_ADD_IF_ABSENT,
+		}
+		mutation.Set = append(mutation.Set, h)
+	}
+	mutation.Remove = m.GetRemoveHeaders()
+	return mutation
+}
+
+// header reads a header's value, or its raw value as Envoy sends them
+func header(h *corev3.HeaderValue) Header {
+	value := h.GetValue()
+	if raw := h.GetRawValue(); len(raw) > 0 {
+		value = string(raw)
+	}
+	return Header{Key: h.GetKey(), Value: value}
+}
+
+func immediate(r *extprocv3.ImmediateResponse) *Immediate {
+	immediate := &Immediate{
+		Status:  int(r.GetStatus().GetCode()),
+		Headers: headerMutation(r.GetHeaders()),
+		Body:    r.GetBody(),
+	}
+	if immediate.Status < 200 || immediate.Status > 599 {
+		immediate.Status = http.StatusOK
+	}
+	return immediate
+}
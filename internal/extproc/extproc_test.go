@@ -0,0 +1,91 @@
+package extproc
+
+import (
+	"net/http"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+)
+
+func setHeader(key, value string, action corev3.HeaderValueOption_HeaderAppendAction) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{Header: &corev3.HeaderValue{Key: key, Value: value}, AppendAction: action}
+}
+
+func TestResponse(t *testing.T) {
+	resp, err := response(&extprocv3.ProcessingResponse{
+		Response: &extprocv3.ProcessingResponse_RequestBody{RequestBody: &extprocv3.BodyResponse{
+			Response: &extprocv3.CommonResponse{
+				HeaderMutation: &extprocv3.HeaderMutation{
+					SetHeaders: []*corev3.HeaderValueOption{
+						setHeader("x-tenant", "acme", corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD),
+						setHeader("x-trace", "set", corev3.HeaderValueOption_ADD_IF_ABSENT),
+						setHeader(":path", "/v2/orders", corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD),
+					},
+					RemoveHeaders: []string{"x-secret"},
+				},
+				BodyMutation: &extprocv3.BodyMutation{Mutation: &extprocv3.BodyMutation_Body{Body: []byte("replaced")}},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Expected a response, got %v", err)
+	}
+	if resp.Phase != RequestBody || resp.Immediate != nil {
+		t.Fatalf("Expected an answer to the request body, got %s", resp.Phase)
+	}
+	if !resp.Mutation.ReplaceBody || string(resp.Mutation.Body) != "replaced" {
+		t.Errorf("Expected the body to be replaced, got %q", resp.Mutation.Body)
+	}
+	if path, ok := resp.Mutation.Pseudo(":path"); !ok || path != "/v2/orders" {
+		t.Errorf("Expected :path /v2/orders, got %q", path)
+	}
+
+	headers := http.Header{"X-Secret": {"s3cret"}, "X-Trace": {"abc"}}
+	resp.Mutation.Apply(headers)
+	if headers.Get("X-Tenant") != "acme" {
+		t.Errorf("Expected X-Tenant to be set, got %q", headers.Get("X-Tenant"))
+	}
+	if headers.Get("X-Trace") != "abc" {
+		t.Errorf("Expected X-Trace to be left alone, got %q", headers.Get("X-Trace"))
+	}
+	if headers.Get("X-Secret") != "" {
+		t.Error("Expected X-Secret to be removed")
+	}
+	if _, ok := headers[":path"]; ok {
+		t.Error("Expected pseudo-headers to be left to the caller")
+	}
+}
+
+func TestImmediateResponse(t *testing.T) {
+	resp, err := response(&extprocv3.ProcessingResponse{
+		Response: &extprocv3.ProcessingResponse_ImmediateResponse{ImmediateResponse: &extprocv3.ImmediateResponse{
+			Status: &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden},
+			Headers: &extprocv3.HeaderMutation{
+				SetHeaders: []*corev3.HeaderValueOption{setHeader("content-type", "text/plain", corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD)},
+			},
+			Body: []byte("blocked"),
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Expected a response, got %v", err)
+	}
+	if resp.Immediate == nil {
+		t.Fatal("Expected an immediate response")
+	}
+	if resp.Immediate.Status != 403 || string(resp.Immediate.Body) != "blocked" {
+		t.Errorf("Expected 403 blocked, got %d %q", resp.Immediate.Status, resp.Immediate.Body)
+	}
+	headers := http.Header{}
+	resp.Immediate.Headers.Apply(headers)
+	if headers.Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected Content-Type text/plain, got %q", headers.Get("Content-Type"))
+	}
+}
+
+func TestEmptyResponse(t *testing.T) {
+	if _, err := response(&extprocv3.ProcessingResponse{}); err == nil {
+		t.Error("Expected an error for a response that answers nothing")
+	}
+}
@@ -0,0 +1,330 @@
+package gateway
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/barisgenc/gatekeeper/internal/admin"
+	"github.com/barisgenc/gatekeeper/internal/autoban"
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/middleware"
+)
+
+// registerBannerAdmin exposes the outage banner:
+//
+//	GET    /admin/banner  current state
+//	PUT    /admin/banner  {"active": true, "html": "..."}
+//	DELETE /admin/banner  clear the banner
+func (gw *Gateway) registerBannerAdmin(banner *middleware.BannerMiddleware) {
+	if gw.admin == nil {
+		return
+	}
+
+	gw.admin.HandleFunc("/banner", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, banner.State())
+	}, "GET")
+
+	gw.admin.HandleFunc("/banner", func(w http.ResponseWriter, r *http.Request) {
+		var req middleware.BannerState
+		if err := admin.ReadJSON(r, &req); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		banner.Set(req.Active, req.HTML)
+		logger.Info("Outage banner set active=%v via admin API", req.Active)
+		admin.WriteJSON(w, http.StatusOK, banner.State())
+	}, "PUT")
+
+	gw.admin.HandleFunc("/banner", func(w http.ResponseWriter, r *http.Request) {
+		banner.Set(false, "")
+		logger.Info("Outage banner cleared via admin API")
+		admin.WriteJSON(w, http.StatusOK, banner.State())
+	}, "DELETE")
+}
+
+// registerMaintenanceAdmin exposes maintenance mode:
+//
+//	GET    /admin/maintenance  current state
+//	PUT    /admin/maintenance  {"active": true, "routes": ["checkout"], "message": "..."}
+//	DELETE /admin/maintenance  end maintenance
+func (gw *Gateway) registerMaintenanceAdmin(maintenance *middleware.MaintenanceMiddleware) {
+	if gw.admin == nil {
+		return
+	}
+
+	gw.admin.HandleFunc("/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, maintenance.State())
+	}, "GET")
+
+	gw.admin.HandleFunc("/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		var req middleware.MaintenanceState
+		if err := admin.ReadJSON(r, &req); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if err := config.KnownRoutes(gw.config.Routes, req.Routes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		maintenance.Set(req)
+		logger.Info("Maintenance mode set active=%v routes=%v via admin API", req.Active, req.Routes)
+		admin.WriteJSON(w, http.StatusOK, maintenance.State())
+	}, "PUT")
+
+	gw.admin.HandleFunc("/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		maintenance.Set(middleware.MaintenanceState{})
+		logger.Info("Maintenance mode ended via admin API")
+		admin.WriteJSON(w, http.StatusOK, maintenance.State())
+	}, "DELETE")
+}
+
+// registerAutoBanAdmin exposes automatic bans:
+//
+//	GET    /admin/bans       active bans
+//	DELETE /admin/bans/{ip}  lift a ban
+func (gw *Gateway) registerAutoBanAdmin(bans *middleware.AutoBanMiddleware) {
+	if gw.admin == nil {
+		return
+	}
+
+	gw.admin.HandleFunc("/bans", func(w http.ResponseWriter, r *http.Request) {
+		list, err := bans.Bans(r.Context())
+		if err != nil {
+			logger.Error("Listing bans failed: %v", err)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if list == nil {
+			list = []autoban.Ban{}
+		}
+		admin.WriteJSON(w, http.StatusOK, list)
+	}, "GET")
+
+	gw.admin.HandleFunc("/bans/{ip}", func(w http.ResponseWriter, r *http.Request) {
+		ip := mux.Vars(r)["ip"]
+		lifted, err := bans.Unban(r.Context(), ip)
+		if err != nil {
+			logger.Error("Unbanning %s failed: %v", ip, err)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !lifted {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		logger.Info("Ban on %s lifted via admin API", ip)
+		w.WriteHeader(http.StatusNoContent)
+	}, "DELETE")
+}
+
+// registerStatsAdmin exposes backend health and traffic:
+//
+//	GET /admin/stats                    load balancer state and bytes per backend and route
+//	GET /admin/backends/{name}/history  recent health transitions of a backend
+func (gw *Gateway) registerStatsAdmin() {
+	if gw.admin == nil {
+		return
+	}
+
+	gw.admin.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"loadBalancer": gw.loadBalancer.GetStats(),
+			"backends":     gw.backendBytes.Snapshot(),
+			"routes":       gw.routeBytes.Snapshot(),
+		})
+	}, "GET")
+
+	gw.admin.HandleFunc("/backends/{name}/history", func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		history, ok := gw.loadBalancer.HealthHistory(name)
+		if !ok {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"backend":     name,
+			"transitions": history,
+		})
+	}, "GET")
+}
+
+// registerSLOAdmin exposes the SLOs:
+//
+//	GET /admin/slo  compliance, error budget left and burn rates of each SLO
+func (gw *Gateway) registerSLOAdmin() {
+	if gw.admin == nil {
+		return
+	}
+
+	gw.admin.HandleFunc("/slo", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, gw.slos.Statuses())
+	}, "GET")
+}
+
+// registerUsageAdmin exposes usage per consumer, over the duration in the
+// since parameter (default 24h):
+//
+//	GET /admin/usage             totals of every consumer, busiest first
+//	GET /admin/usage/{consumer}  hourly usage of a consumer, or daily with granularity=day
+func (gw *Gateway) registerUsageAdmin() {
+	if gw.admin == nil {
+		return
+	}
+
+	since := func(r *http.Request) (time.Time, bool) {
+		d := 24 * time.Hour
+		if value := r.URL.Query().Get("since"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				return time.Time{}, false
+			}
+			d = parsed
+		}
+		return time.Now().Add(-d), true
+	}
+
+	gw.admin.HandleFunc("/usage", func(w http.ResponseWriter, r *http.Request) {
+		from, ok := since(r)
+		if !ok {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		consumers, err := gw.usage.Consumers(r.Context(), from)
+		if err != nil {
+			logger.Error("Reading usage failed: %v", err)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, consumers)
+	}, "GET")
+
+	gw.admin.HandleFunc("/usage/{consumer}", func(w http.ResponseWriter, r *http.Request) {
+		from, ok := since(r)
+		granularity := r.URL.Query().Get("granularity")
+		if !ok || (granularity != "" && granularity != "hour" && granularity != "day") {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		consumer := mux.Vars(r)["consumer"]
+		buckets, err := gw.usage.History(r.Context(), consumer, from, granularity == "day")
+		if err != nil {
+			logger.Error("Reading usage of %s failed: %v", consumer, err)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"consumer": consumer,
+			"buckets":  buckets,
+		})
+	}, "GET")
+}
+
+// registerQuotaAdmin exposes consumer quotas for the current day and month:
+//
+//	GET    /admin/quotas             use of every consumer with requests or quotas of its own
+//	GET    /admin/quotas/{consumer}  use of one consumer
+//	DELETE /admin/quotas/{consumer}  start the consumer's day and month over
+func (gw *Gateway) registerQuotaAdmin(quotas *middleware.QuotaMiddleware) {
+	if gw.admin == nil {
+		return
+	}
+
+	gw.admin.HandleFunc("/quotas", func(w http.ResponseWriter, r *http.Request) {
+		statuses, err := quotas.Statuses(r.Context())
+		if err != nil {
+			logger.Error("Listing quotas failed: %v", err)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, statuses)
+	}, "GET")
+
+	gw.admin.HandleFunc("/quotas/{consumer}", func(w http.ResponseWriter, r *http.Request) {
+		consumer := mux.Vars(r)["consumer"]
+		status, err := quotas.Status(r.Context(), consumer)
+		if err != nil {
+			logger.Error("Reading the quotas of %s failed: %v", consumer, err)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, status)
+	}, "GET")
+
+	gw.admin.HandleFunc("/quotas/{consumer}", func(w http.ResponseWriter, r *http.Request) {
+		consumer := mux.Vars(r)["consumer"]
+		reset, err := quotas.Reset(r.Context(), consumer)
+		if err != nil {
+			logger.Error("Resetting the quotas of %s failed: %v", consumer, err)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !reset {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		logger.Info("Quotas of %s reset via admin API", consumer)
+		w.WriteHeader(http.StatusNoContent)
+	}, "DELETE")
+}
+
+// registerSessionsAdmin exposes login sessions:
+//
+//	GET    /admin/sessions                 live sessions, when kept server side
+//	DELETE /admin/sessions/{id}            revoke one session
+//	DELETE /admin/sessions?subject={who}   revoke every session of a subject
+func (gw *Gateway) registerSessionsAdmin() {
+	if gw.admin == nil {
+		return
+	}
+	sessions, err := middleware.NewSessions(gw.config.Sessions, gw.storage)
+	if err != nil {
+		logger.Error("Session admin API unavailable: %v", err)
+		return
+	}
+
+	if sessions.ServerSide() {
+		gw.admin.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+			list, err := sessions.List(r.Context())
+			if err != nil {
+				logger.Error("Listing sessions failed: %v", err)
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			admin.WriteJSON(w, http.StatusOK, list)
+		}, "GET")
+	}
+
+	gw.admin.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		subject := r.URL.Query().Get("subject")
+		if subject == "" {
+			http.Error(w, "subject is required", http.StatusBadRequest)
+			return
+		}
+		if err := sessions.RevokeSubject(r.Context(), subject); err != nil {
+			logger.Error("Revoking the sessions of %s failed: %v", subject, err)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		logger.Info("Sessions of %s revoked via admin API", subject)
+		w.WriteHeader(http.StatusNoContent)
+	}, "DELETE")
+
+	gw.admin.HandleFunc("/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		revoked, err := sessions.Revoke(r.Context(), id)
+		if err != nil {
+			logger.Error("Revoking session %s failed: %v", id, err)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !revoked {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		logger.Info("Session %s revoked via admin API", id)
+		w.WriteHeader(http.StatusNoContent)
+	}, "DELETE")
+}
@@ -0,0 +1,372 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/loadbalancer"
+	"github.com/barisgenc/gatekeeper/internal/middleware"
+	"github.com/barisgenc/gatekeeper/internal/session"
+	"github.com/barisgenc/gatekeeper/internal/traffic"
+)
+
+func TestBannerAdminAPI(t *testing.T) {
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "test", URL: "http://localhost:3000", Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 60, BurstSize: 10},
+		Admin:     config.AdminConfig{Enabled: true, Token: "t"},
+		Banner:    config.BannerConfig{Enabled: true},
+	}
+	gw := New(cfg)
+	handler := gw.AdminHandler()
+	if handler == nil {
+		t.Fatal("Expected admin handler when admin is enabled")
+	}
+
+	req := httptest.NewRequest("PUT", "/admin/banner", strings.NewReader(`{"active":true,"html":"<b>down</b>"}`))
+	req.Header.Set("Authorization", "Bearer t")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"active":true`) {
+		t.Fatalf("Expected banner to be activated, got %v %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("DELETE", "/admin/banner", nil)
+	req.Header.Set("Authorization", "Bearer t")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), `"active":false`) {
+		t.Errorf("Expected banner to be cleared, got %s", rr.Body.String())
+	}
+}
+
+func TestMaintenanceAdminAPI(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Backends:    []config.Backend{{Name: "test", URL: backend.URL, Weight: 100}},
+		RateLimit:   config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Admin:       config.AdminConfig{Enabled: true},
+		Maintenance: config.MaintenanceConfig{Enabled: true},
+		Routes: []config.RouteConfig{
+			{Name: "checkout", PathPrefix: "/checkout"},
+			{Name: "products", PathPrefix: "/products"},
+		},
+	}
+	gw := New(cfg)
+	handler, admin := gw.Handler(), gw.AdminHandler()
+	status := func(path string) int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr.Code
+	}
+
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("PUT", "/admin/maintenance", strings.NewReader(`{"active":true,"routes":["missing"]}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected unknown routes to be rejected, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("PUT", "/admin/maintenance", strings.NewReader(`{"active":true,"routes":["checkout"]}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected maintenance to start, got %d %s", rr.Code, rr.Body.String())
+	}
+	if status("/checkout/cart") != http.StatusServiceUnavailable || status("/products/1") != http.StatusOK {
+		t.Errorf("Expected only checkout in maintenance, got %d and %d", status("/checkout/cart"), status("/products/1"))
+	}
+
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("DELETE", "/admin/maintenance", nil))
+	if !strings.Contains(rr.Body.String(), `"active":false`) || status("/checkout/cart") != http.StatusOK {
+		t.Errorf("Expected maintenance to end, got %s", rr.Body.String())
+	}
+}
+
+func TestAutoBanAdminAPI(t *testing.T) {
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "test", URL: "http://localhost:3000", Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 60, BurstSize: 1},
+		Admin:     config.AdminConfig{Enabled: true},
+		AutoBan:   config.AutoBanConfig{Enabled: true, Threshold: 2},
+	}
+	gw := New(cfg)
+	handler := gw.Handler()
+
+	// Rate limited responses count as offences
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest("GET", "/health-not", nil)
+		req.RemoteAddr = "203.0.113.5:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rr := httptest.NewRecorder()
+	gw.AdminHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/admin/bans", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"ip":"203.0.113.5"`) {
+		t.Fatalf("Expected the client to be listed as banned, got %v %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	gw.AdminHandler().ServeHTTP(rr, httptest.NewRequest("DELETE", "/admin/bans/203.0.113.5", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 when lifting a ban, got %v", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	gw.AdminHandler().ServeHTTP(rr, httptest.NewRequest("DELETE", "/admin/bans/203.0.113.5", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an IP that is not banned, got %v", rr.Code)
+	}
+}
+
+func TestStatsAdminAPI(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("hello world"))
+	}))
+	defer backend.Close()
+
+	gw := New(&config.Config{
+		Backends:  []config.Backend{{Name: "api", URL: backend.URL, Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 60, BurstSize: 10},
+		Admin:     config.AdminConfig{Enabled: true},
+		Routes:    []config.RouteConfig{{Name: "upload", PathPrefix: "/upload"}},
+	})
+
+	rr := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(rr, httptest.NewRequest("POST", "/upload", strings.NewReader("12345")))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected upload to be proxied, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	gw.AdminHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/admin/stats", nil))
+
+	var stats struct {
+		Backends []traffic.Usage `json:"backends"`
+		Routes   []traffic.Usage `json:"routes"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	for _, usage := range [][]traffic.Usage{stats.Backends, stats.Routes} {
+		if len(usage) != 1 || usage[0].BytesIn != 5 || usage[0].BytesOut != 11 {
+			t.Errorf("Expected 5 bytes in and 11 out, got %+v", usage)
+		}
+	}
+	if stats.Routes[0].Name != "upload" {
+		t.Errorf("Expected usage under the route name, got %s", stats.Routes[0].Name)
+	}
+}
+
+func TestBackendHistoryAdminAPI(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	gw := New(&config.Config{
+		Backends:  []config.Backend{{Name: "api", URL: backend.URL, Weight: 100, Health: "/health"}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 60, BurstSize: 10},
+		Admin:     config.AdminConfig{Enabled: true},
+	})
+	gw.checkBackendHealth(gw.config.Backends[0])
+
+	rr := httptest.NewRecorder()
+	gw.AdminHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/admin/backends/api/history", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+
+	var resp struct {
+		Transitions []loadbalancer.HealthTransition `json:"transitions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Transitions) != 2 {
+		t.Fatalf("Expected startup and one transition, got %+v", resp.Transitions)
+	}
+	last := resp.Transitions[1]
+	if last.Healthy || last.Reason != "status 503 Service Unavailable" || last.Source != "health_check" {
+		t.Errorf("Unexpected transition %+v", last)
+	}
+
+	rr = httptest.NewRecorder()
+	gw.AdminHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/admin/backends/missing/history", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown backend, got %d", rr.Code)
+	}
+}
+
+func TestSLOAdminAPI(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/orders/broken" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer backend.Close()
+
+	gw := New(&config.Config{
+		Backends:  []config.Backend{{Name: "api", URL: backend.URL, Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100},
+		Admin:     config.AdminConfig{Enabled: true},
+		Routes:    []config.RouteConfig{{Name: "orders", PathPrefix: "/orders"}},
+		SLOs:      []config.SLOConfig{{Name: "orders-available", Route: "orders", Objective: 90}},
+	})
+
+	handler := gw.Handler()
+	for _, path := range []string{"/orders/1", "/orders/2", "/orders/3", "/orders/broken", "/other"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	rr := httptest.NewRecorder()
+	gw.AdminHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/admin/slo", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+
+	var statuses []struct {
+		Name        string             `json:"name"`
+		Requests    int64              `json:"requests"`
+		BadRequests int64              `json:"badRequests"`
+		BurnRates   map[string]float64 `json:"burnRates"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &statuses); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Requests != 4 || statuses[0].BadRequests != 1 {
+		t.Fatalf("Expected 1 bad of the route's 4 requests, got %+v", statuses)
+	}
+	if rate := statuses[0].BurnRates["5m"]; rate < 2.49 || rate > 2.51 {
+		t.Errorf("Expected a 5m burn rate of 2.5, got %v", rate)
+	}
+}
+
+func TestUsageAdminAPI(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/orders/broken" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	gw := New(&config.Config{
+		Backends:  []config.Backend{{Name: "api", URL: backend.URL, Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100},
+		Admin:     config.AdminConfig{Enabled: true},
+		Routes:    []config.RouteConfig{{Name: "orders", PathPrefix: "/orders"}},
+		Usage:     config.UsageConfig{Enabled: true},
+	})
+	defer gw.usage.Close()
+
+	handler := gw.Handler()
+	for _, path := range []string{"/orders/1", "/orders/2", "/orders/broken"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	rr := httptest.NewRecorder()
+	gw.AdminHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/admin/usage", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+	var consumers []struct {
+		Consumer     string `json:"consumer"`
+		Requests     int64  `json:"requests"`
+		ServerErrors int64  `json:"serverErrors"`
+		Bytes        int64  `json:"bytes"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &consumers); err != nil {
+		t.Fatal(err)
+	}
+	if len(consumers) != 1 || consumers[0].Consumer != "anonymous" || consumers[0].Requests != 3 || consumers[0].ServerErrors != 1 || consumers[0].Bytes != 4 {
+		t.Fatalf("Expected 3 anonymous requests, 1 failed, 4 bytes, got %+v", consumers)
+	}
+
+	rr = httptest.NewRecorder()
+	gw.AdminHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/admin/usage/anonymous?granularity=day&since=720h", nil))
+	var history struct {
+		Buckets []struct {
+			Requests int64 `json:"requests"`
+		} `json:"buckets"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Buckets) != 1 || history.Buckets[0].Requests != 3 {
+		t.Errorf("Expected one day with 3 requests, got %+v", history.Buckets)
+	}
+
+	rr = httptest.NewRecorder()
+	gw.AdminHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/admin/usage?since=yesterday", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad since, got %d", rr.Code)
+	}
+}
+
+func TestSessionsAdminAPI(t *testing.T) {
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "test", URL: "http://localhost:3000", Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 60, BurstSize: 10},
+		Admin:     config.AdminConfig{Enabled: true},
+		Sessions:  config.SessionsConfig{Enabled: true, Store: "shared", Secret: "0123456789abcdef-secret"},
+	}
+	gw := New(cfg)
+	admin := gw.AdminHandler()
+
+	// Sessions started by another manager over the same storage, as OIDC's
+	sessions, err := middleware.NewSessions(cfg.Sessions, gw.storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, subject := range []string{"oidc:alice", "oidc:bob"} {
+		if err := sessions.Start(context.Background(), httptest.NewRecorder(), subject, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := func() []session.Session {
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/sessions", nil))
+		var got []session.Session
+		json.NewDecoder(rr.Body).Decode(&got)
+		return got
+	}
+
+	live := list()
+	if len(live) != 2 {
+		t.Fatalf("Expected two sessions, got %+v", live)
+	}
+
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("DELETE", "/admin/sessions/"+live[0].ID, nil))
+	if rr.Code != http.StatusNoContent || len(list()) != 1 {
+		t.Errorf("Expected the session to be revoked, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("DELETE", "/admin/sessions/"+live[0].ID, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a revoked session, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("DELETE", "/admin/sessions", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a subject to be required, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("DELETE", "/admin/sessions?subject=oidc:bob", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected bob's sessions to be revoked, got %d", rr.Code)
+	}
+}
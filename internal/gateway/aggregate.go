@@ -0,0 +1,157 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// aggregateErrorsKey holds what went wrong with failed parts in a partial
+// response
+const aggregateErrorsKey = "_errors"
+
+// hopHeaders are not forwarded to aggregate parts
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+	"Content-Length", "Accept-Encoding",
+}
+
+// aggregateHandler answers the route by requesting every part in parallel
+// and merging their JSON responses into one object
+func (gw *Gateway) aggregateHandler(label string, route config.RouteConfig) http.Handler {
+	cfg := *route.Aggregate
+	if cfg.OnFailure == "" {
+		cfg.OnFailure = "partial"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(cfg.Timeout)*time.Second)
+		defer cancel()
+
+		vars := map[string]string{
+			"path":  strings.TrimPrefix(r.URL.Path, "/"),
+			"rest":  strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, route.PathPrefix), "/"),
+			"query": r.URL.RawQuery,
+		}
+		for name, value := range mux.Vars(r) {
+			vars[name] = value
+		}
+
+		results := make([]json.RawMessage, len(cfg.Parts))
+		failures := make([]error, len(cfg.Parts))
+		var wg sync.WaitGroup
+		for i, part := range cfg.Parts {
+			wg.Add(1)
+			go func(i int, part config.AggregatePart) {
+				defer wg.Done()
+				results[i], failures[i] = gw.fetchPart(ctx, r, gw.instance(part.Backend), part, vars, cfg.MaxBodyBytes)
+			}(i, part)
+		}
+		wg.Wait()
+
+		merged := make(map[string]json.RawMessage, len(cfg.Parts)+1)
+		errs := make(map[string]string)
+		for i, part := range cfg.Parts {
+			if failures[i] != nil {
+				metrics.RecordAggregatePartFailure(label, part.Key)
+				logger.Warn("Aggregate route %s: part %s from %s failed: %v", label, part.Key, part.Backend, failures[i])
+				errs[part.Key] = failures[i].Error()
+				merged[part.Key] = json.RawMessage("null")
+				continue
+			}
+			merged[part.Key] = results[i]
+		}
+
+		if len(errs) > 0 {
+			if cfg.OnFailure == "fail" {
+				writeJSON(w, http.StatusBadGateway, map[string]interface{}{"error": "aggregation failed", "details": errs})
+				return
+			}
+			encoded, _ := json.Marshal(errs)
+			merged[aggregateErrorsKey] = encoded
+		}
+		writeJSON(w, http.StatusOK, merged)
+	})
+}
+
+// fetchPart requests one part and returns its JSON body
+func (gw *Gateway) fetchPart(ctx context.Context, r *http.Request, backend config.Backend, part config.AggregatePart, vars map[string]string, maxBody int64) (json.RawMessage, error) {
+	target := r.URL.Path
+	if part.Path != "" {
+		target = expandTemplate(part.Path, vars)
+	}
+	if r.URL.RawQuery != "" && !strings.Contains(target, "?") {
+		target += "?" + r.URL.RawQuery
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(backend.URL, "/")+"/"+strings.TrimPrefix(target, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = r.Header.Clone()
+	for _, name := range hopHeaders {
+		req.Header.Del(name)
+	}
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+			ip = prior + ", " + ip
+		}
+		req.Header.Set("X-Forwarded-For", ip)
+	}
+
+	resp, err := gw.roundTripper(backend.Name).RoundTrip(withUpstreamTrace(req, backend.Name))
+	if err != nil {
+		metrics.RecordBackendRequest(backend.Name, "502")
+		return nil, err
+	}
+	defer resp.Body.Close()
+	metrics.RecordBackendRequest(backend.Name, strconv.Itoa(resp.StatusCode))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxBody {
+		return nil, fmt.Errorf("response is larger than %d bytes", maxBody)
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("response is not JSON")
+	}
+	return body, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestAggregateRoutes(t *testing.T) {
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t" {
+			t.Error("Client headers were not passed on")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"` + r.URL.Path + `","query":"` + r.URL.RawQuery + `"}`))
+	}))
+	defer users.Close()
+	orders := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/orders/down" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`[1,2]`))
+	}))
+	defer orders.Close()
+
+	parts := func(ordersPath string) []config.AggregatePart {
+		return []config.AggregatePart{
+			{Key: "user", Backend: "users", Path: "/users/{id}"},
+			{Key: "orders", Backend: "orders", Path: ordersPath},
+		}
+	}
+	cfg := &config.Config{
+		Backends: []config.Backend{
+			{Name: "users", URL: users.URL, Weight: 100},
+			{Name: "orders", URL: orders.URL},
+		},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Routes: []config.RouteConfig{
+			{Name: "profile", Path: "/profile/{id}", Aggregate: &config.AggregateConfig{Parts: parts("/orders?user={id}")}},
+			{Name: "partial", Path: "/partial/{id}", Aggregate: &config.AggregateConfig{Parts: parts("/orders/down")}},
+			{Name: "strict", Path: "/strict/{id}", Aggregate: &config.AggregateConfig{Parts: parts("/orders/down"), OnFailure: "fail"}},
+		},
+	}
+	handler := New(cfg).Handler()
+
+	serve := func(method, url string) (*httptest.ResponseRecorder, map[string]json.RawMessage) {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer t")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		var body map[string]json.RawMessage
+		json.Unmarshal(rr.Body.Bytes(), &body)
+		return rr, body
+	}
+
+	rr, body := serve("GET", "/profile/7?fields=name")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+	if string(body["user"]) != `{"id":"/users/7","query":"fields=name"}` || string(body["orders"]) != `[1,2]` {
+		t.Errorf("Unexpected merged body %s", rr.Body)
+	}
+	if _, ok := body["_errors"]; ok {
+		t.Error("Expected no errors")
+	}
+
+	rr, body = serve("GET", "/partial/7")
+	if rr.Code != http.StatusOK || string(body["orders"]) != "null" || string(body["_errors"]) != `{"orders":"status 503"}` {
+		t.Errorf("Expected a partial response, got %d %s", rr.Code, rr.Body)
+	}
+
+	if rr, _ = serve("GET", "/strict/7"); rr.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 when a part fails, got %d", rr.Code)
+	}
+	if rr, _ = serve("POST", "/profile/7"); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", rr.Code)
+	}
+}
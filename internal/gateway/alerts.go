@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/notify"
+)
+
+// alerts turns backend health changes into notifications. It remembers
+// whether any backend was healthy and which tier served, so that only
+// changes are announced.
+type alerts struct {
+	notifier *notify.Notifier
+
+	mu          sync.Mutex
+	noneHealthy bool
+	tierKnown   bool
+	backup      bool
+}
+
+// setupAlerts sends health changes to the configured webhooks
+func (gw *Gateway) setupAlerts(cfg config.NotificationsConfig) {
+	gw.alerts = &alerts{notifier: notify.New(cfg)}
+	gw.loadBalancer.OnFailover(gw.tierChanged)
+	if len(cfg.Webhooks) > 0 {
+		gw.OnBackendChange(gw.alertBackendChange)
+	}
+}
+
+// alertBackendChange announces a backend going down or coming back, and
+// the gateway running out of healthy backends or having one again
+func (gw *Gateway) alertBackendChange(change BackendChange) {
+	kind, message := "backend_up", fmt.Sprintf("Backend %s is healthy again", change.Backend)
+	if !change.Healthy {
+		kind, message = "backend_down", fmt.Sprintf("Backend %s is down: %s", change.Backend, change.Reason)
+	}
+	gw.alerts.notifier.Notify(notify.Event{Kind: kind, Backend: change.Backend, Message: message})
+
+	noneHealthy := len(gw.loadBalancer.GetHealthyBackends()) == 0
+	gw.alerts.mu.Lock()
+	changed := noneHealthy != gw.alerts.noneHealthy
+	gw.alerts.noneHealthy = noneHealthy
+	gw.alerts.mu.Unlock()
+	switch {
+	case changed && noneHealthy:
+		gw.alerts.notifier.Notify(notify.Event{Kind: "no_healthy_backends", Message: "No healthy backends are left"})
+	case changed:
+		gw.alerts.notifier.Notify(notify.Event{Kind: "backends_available", Message: fmt.Sprintf("Backend %s is healthy, traffic can be served again", change.Backend)})
+	}
+}
+
+// tierChanged records and announces traffic moving to the backup backends
+// or back. The load balancer reports the starting tier, which is not
+// announced.
+func (gw *Gateway) tierChanged(backup bool) {
+	metrics.SetBackupActive(backup)
+
+	gw.alerts.mu.Lock()
+	changed := gw.alerts.tierKnown && backup != gw.alerts.backup
+	gw.alerts.tierKnown = true
+	gw.alerts.backup = backup
+	gw.alerts.mu.Unlock()
+	switch {
+	case changed && backup:
+		gw.alerts.notifier.Notify(notify.Event{Kind: "failover", Message: "Traffic failed over to the backup backends"})
+	case changed:
+		gw.alerts.notifier.Notify(notify.Event{Kind: "failback", Message: "Traffic moved back to the primary backends"})
+	}
+}
+
+// alertHoneypot announces a client banned for requesting a honeypot
+func (gw *Gateway) alertHoneypot(ip, honeypot string) {
+	gw.alerts.notifier.Notify(notify.Event{
+		Kind:    "honeypot_hit",
+		Client:  ip,
+		Message: fmt.Sprintf("Client %s requested honeypot %s and was banned", ip, honeypot),
+	})
+}
@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/loadbalancer"
+)
+
+func TestHealthAlerts(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Event string `json:"event"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		events = append(events, body.Event)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	cfg := &config.Config{
+		Backends: []config.Backend{
+			{Name: "api", URL: "http://api.internal"},
+			{Name: "dr", URL: "http://dr.internal", Backup: true},
+		},
+		Notifications: config.NotificationsConfig{Webhooks: []config.WebhookConfig{{Name: "ops", URL: hook.URL}}},
+		RateLimit:     config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+	}
+	gw := New(cfg)
+	expect := func(want ...string) {
+		t.Helper()
+		gw.alerts.notifier.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		counts := make(map[string]int)
+		for _, event := range events {
+			counts[event]++
+		}
+		for _, event := range want {
+			if counts[event] == 0 {
+				t.Errorf("Expected a %s alert, got %v", event, events)
+			}
+			counts[event]--
+		}
+		if len(events) != len(want) {
+			t.Errorf("Expected alerts %v, got %v", want, events)
+		}
+		events = nil
+	}
+
+	// Losing the primary fails over to the backup
+	gw.reportHealth("api", loadbalancer.HealthReport{Healthy: false, Reason: "status 503"})
+	gw.loadBalancer.NextBackend()
+	expect("backend_down", "failover")
+
+	gw.reportHealth("dr", loadbalancer.HealthReport{Healthy: false, Reason: "timeout"})
+	expect("backend_down", "no_healthy_backends")
+
+	gw.reportHealth("dr", loadbalancer.HealthReport{Healthy: true})
+	expect("backend_up", "backends_available")
+}
+
+func TestHoneypotAlerts(t *testing.T) {
+	alerts := make(chan map[string]string, 4)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		alerts <- body
+	}))
+	defer hook.Close()
+
+	cfg := &config.Config{
+		Backends:      []config.Backend{{Name: "api", URL: "http://api.internal"}},
+		Notifications: config.NotificationsConfig{Webhooks: []config.WebhookConfig{{Name: "security", URL: hook.URL, Events: []string{"honeypot_hit"}}}},
+		RateLimit:     config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		AutoBan:       config.AutoBanConfig{Enabled: true, Honeypots: []string{"/wp-login.php"}},
+	}
+	gw := New(cfg)
+
+	req := httptest.NewRequest("GET", "/wp-login.php", nil)
+	req.RemoteAddr = "203.0.113.9:4000"
+	rr := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(rr, req)
+	gw.alerts.notifier.Wait()
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected the honeypot to answer 404, got %d", rr.Code)
+	}
+	select {
+	case alert := <-alerts:
+		if alert["event"] != "honeypot_hit" || alert["client"] != "203.0.113.9" {
+			t.Errorf("Expected a honeypot_hit alert about 203.0.113.9, got %v", alert)
+		}
+	default:
+		t.Error("Expected a honeypot_hit alert")
+	}
+}
@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/loadbalancer"
+)
+
+// balancerHandler proxies a route's requests with its own loadBalancing
+// settings
+func (gw *Gateway) balancerHandler(route config.RouteConfig) http.Handler {
+	balancer := *route.LoadBalancing
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gw.proxy(w, r, &balancer)
+	})
+}
+
+// choice returns how to balance r: by the route's algorithm and hashKey
+// when it sets them, else by the global ones
+func (gw *Gateway) choice(r *http.Request, route *config.BalancerConfig) loadbalancer.Choice {
+	spec := gw.config.LoadBalancing.HashKey
+	var algorithm string
+	if route != nil {
+		algorithm = route.Algorithm
+		if route.HashKey != "" {
+			spec = route.HashKey
+		}
+	}
+	return loadbalancer.Choice{Algorithm: algorithm, Key: hashKey(spec, r)}
+}
+
+// hashKey returns the part of r that spec names, or "" when r has none
+func hashKey(spec string, r *http.Request) string {
+	kind, name, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "header":
+		return r.Header.Get(name)
+	case "cookie":
+		if cookie, err := r.Cookie(name); err == nil {
+			return cookie.Value
+		}
+		return ""
+	case "query":
+		return r.URL.Query().Get(name)
+	case "path":
+		return r.URL.Path
+	default:
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+}
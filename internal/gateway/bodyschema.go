@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/openapi"
+)
+
+// bodySchemaHandler checks request bodies against the route's JSON Schema,
+// rejecting or only logging the ones that do not match
+func bodySchemaHandler(label string, route config.RouteConfig, next http.Handler) http.Handler {
+	cfg := *route.BodySchema
+	if cfg.Mode == "" {
+		cfg.Mode = "block"
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+
+	schema, err := openapi.LoadSchema(cfg.File)
+	if err != nil {
+		logger.Error("Route %s body schema misconfigured, rejecting all requests: %v", label, err)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cfg.MaxBodyBytes))
+		if err != nil {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if len(body) > 0 {
+			if problems := schema.ValidateJSON(body); len(problems) > 0 {
+				metrics.RecordBodySchemaFailure(label, cfg.Mode)
+				if cfg.Mode == "block" {
+					writeInvalidRequest(w, "request body does not match the schema", problems)
+					return
+				}
+				logger.Warn("Request body for %s %s does not match the schema of route %s: %s",
+					r.Method, r.URL.Path, label, strings.Join(problems, "; "))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
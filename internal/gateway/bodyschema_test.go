@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestBodySchemaRoutes(t *testing.T) {
+	backendCalls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendCalls++
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	schemaFile := filepath.Join(t.TempDir(), "user.json")
+	schema := `{"type": "object", "required": ["email"], "properties": {"email": {"type": "string", "pattern": "@"}}}`
+	if err := os.WriteFile(schemaFile, []byte(schema), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "test", URL: backend.URL, Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Routes: []config.RouteConfig{
+			{Name: "users", PathPrefix: "/users", BodySchema: &config.BodySchemaConfig{File: schemaFile}},
+			{Name: "legacy", PathPrefix: "/legacy", BodySchema: &config.BodySchemaConfig{File: schemaFile, Mode: "log"}},
+			{Name: "small", PathPrefix: "/small", BodySchema: &config.BodySchemaConfig{File: schemaFile, MaxBodyBytes: 8}},
+		},
+	}
+	handler := New(cfg).Handler()
+
+	testCases := []struct {
+		name           string
+		method         string
+		url            string
+		body           string
+		expectedStatus int
+		reachesBackend bool
+	}{
+		{"valid", "POST", "/users", `{"email":"a@example.com"}`, http.StatusOK, true},
+		{"invalid blocked", "POST", "/users", `{"email":"nobody"}`, http.StatusBadRequest, false},
+		{"malformed blocked", "POST", "/users", `{"email":`, http.StatusBadRequest, false},
+		{"no body", "GET", "/users", "", http.StatusOK, true},
+		{"invalid logged", "POST", "/legacy", `{}`, http.StatusOK, true},
+		{"too large", "POST", "/small", `{"email":"a@example.com"}`, http.StatusRequestEntityTooLarge, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			backendCalls = 0
+			req := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			if tc.body == "" {
+				req = httptest.NewRequest(tc.method, tc.url, nil)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status %v, got %v: %s", tc.expectedStatus, rr.Code, rr.Body)
+			}
+			if (backendCalls > 0) != tc.reachesBackend {
+				t.Errorf("Expected backend reached: %v, got %d calls", tc.reachesBackend, backendCalls)
+			}
+		})
+	}
+}
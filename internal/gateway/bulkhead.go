@@ -0,0 +1,34 @@
+package gateway
+
+import (
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/bulkhead"
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/middleware"
+)
+
+// newBackendBulkheads builds one limiter per group and maps each backend
+// in the group to it, so the group's backends share its slots
+func newBackendBulkheads(cfg config.BulkheadConfig) map[string]*bulkhead.Limiter {
+	cfg = middleware.BulkheadDefaults(cfg)
+
+	limiters := make(map[string]*bulkhead.Limiter)
+	for _, group := range cfg.Groups {
+		queueDepth := group.QueueDepth
+		if queueDepth == 0 {
+			queueDepth = cfg.QueueDepth
+		}
+		timeout := group.QueueTimeoutMs
+		if timeout == 0 {
+			timeout = cfg.QueueTimeoutMs
+		}
+
+		limiter := bulkhead.New(group.Name, group.MaxConcurrent, queueDepth,
+			time.Duration(timeout)*time.Millisecond)
+		for _, backend := range group.Backends {
+			limiters[backend] = limiter
+		}
+	}
+	return limiters
+}
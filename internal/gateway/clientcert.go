@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"crypto/x509"
+	"net/http"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// clientCertHandler only passes requests that presented a verified client
+// certificate matching the route's OU and SAN requirements. The handshake
+// has already checked the chain and CRL; this decides whether the route
+// accepts that identity.
+func clientCertHandler(route config.RouteConfig, next http.Handler) http.Handler {
+	cfg := *route.ClientCert
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			tracing.RecordDecision(r.Context(), "client_cert", tracing.Denied, "no verified client certificate")
+			http.Error(w, "Client Certificate Required", http.StatusForbidden)
+			return
+		}
+
+		cert := r.TLS.VerifiedChains[0][0]
+		if len(cfg.OUs) > 0 && !anyMatch(cfg.OUs, cert.Subject.OrganizationalUnit) {
+			tracing.RecordDecision(r.Context(), "client_cert", tracing.Denied, "organizational unit not allowed")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if len(cfg.SANs) > 0 && !anyMatch(cfg.SANs, subjectAltNames(cert)) {
+			tracing.RecordDecision(r.Context(), "client_cert", tracing.Denied, "subject alternative name not allowed")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		tracing.RecordDecision(r.Context(), "client_cert", tracing.Allowed, "client certificate accepted")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func subjectAltNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+func anyMatch(allowed, values []string) bool {
+	for _, value := range values {
+		for _, a := range allowed {
+			if value == a {
+				return true
+			}
+		}
+	}
+	return false
+}
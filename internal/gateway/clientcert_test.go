@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestRouteClientCertRequirements(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	gw := New(&config.Config{
+		Backends:  []config.Backend{{Name: "api", URL: backend.URL, Weight: 1, Health: "/health"}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100},
+		Routes: []config.RouteConfig{
+			{Name: "ops", PathPrefix: "/ops", ClientCert: &config.RouteClientCertConfig{
+				OUs:  []string{"platform"},
+				SANs: []string{"spiffe://corp/deployer"},
+			}},
+			{Name: "any-cert", PathPrefix: "/internal", ClientCert: &config.RouteClientCertConfig{}},
+		},
+	})
+
+	deployer := &x509.Certificate{Subject: pkix.Name{CommonName: "deployer", OrganizationalUnit: []string{"platform"}}}
+	spiffeID, _ := url.Parse("spiffe://corp/deployer")
+	deployer.URIs = []*url.URL{spiffeID}
+	stranger := &x509.Certificate{Subject: pkix.Name{CommonName: "stranger", OrganizationalUnit: []string{"sales"}}}
+
+	testCases := []struct {
+		name   string
+		path   string
+		cert   *x509.Certificate
+		status int
+	}{
+		{"open route without cert", "/public", nil, http.StatusOK},
+		{"protected route without cert", "/ops/deploy", nil, http.StatusForbidden},
+		{"matching cert", "/ops/deploy", deployer, http.StatusOK},
+		{"wrong OU", "/ops/deploy", stranger, http.StatusForbidden},
+		{"any verified cert", "/internal/x", stranger, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			req.TLS = &tls.ConnectionState{}
+			if tc.cert != nil {
+				req.TLS.VerifiedChains = [][]*x509.Certificate{{tc.cert}}
+			}
+			rr := httptest.NewRecorder()
+			gw.router.ServeHTTP(rr, req)
+
+			if rr.Code != tc.status {
+				t.Errorf("expected %d, got %d", tc.status, rr.Code)
+			}
+		})
+	}
+}
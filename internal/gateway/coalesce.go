@@ -0,0 +1,159 @@
+package gateway
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// flight is one backend call shared by identical concurrent requests
+type flight struct {
+	done   chan struct{}
+	shared bool
+	status int
+	header http.Header
+	body   []byte
+}
+
+type coalescer struct {
+	route   string
+	vary    []string
+	maxBody int
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// coalesceHandler lets the first of several identical requests call the
+// backend while the rest wait for its response
+func coalesceHandler(route config.RouteConfig, next http.Handler) http.Handler {
+	c := &coalescer{
+		route:   route.Name,
+		vary:    route.Coalesce.VaryHeaders,
+		maxBody: route.Coalesce.MaxBodyBytes,
+		flights: make(map[string]*flight),
+	}
+	if c.maxBody <= 0 {
+		c.maxBody = 1 << 20
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cacheable(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := c.key(r)
+		c.mu.Lock()
+		if f, ok := c.flights[key]; ok {
+			c.mu.Unlock()
+			c.wait(w, r, f, next)
+			return
+		}
+		f := &flight{done: make(chan struct{})}
+		c.flights[key] = f
+		c.mu.Unlock()
+
+		cw := &coalesceWriter{ResponseWriter: w, flight: f, maxBody: c.maxBody, share: true}
+		next.ServeHTTP(cw, r)
+
+		// A response cut short by the leader's client going away is not shared
+		f.shared = cw.share && r.Context().Err() == nil
+		if f.shared {
+			if !cw.wroteHeader {
+				f.status = http.StatusOK
+				f.header = w.Header().Clone()
+			}
+			f.body = cw.body.Bytes()
+		}
+
+		c.mu.Lock()
+		delete(c.flights, key)
+		c.mu.Unlock()
+		close(f.done)
+	})
+}
+
+func (c *coalescer) key(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method + " " + r.Host + r.URL.RequestURI() + "\n" + r.Header.Get("Accept-Encoding"))
+	for _, name := range c.vary {
+		b.WriteString("\n" + r.Header.Get(name))
+	}
+	return b.String()
+}
+
+// wait replays the leader's response, or makes its own call when the
+// response could not be shared
+func (c *coalescer) wait(w http.ResponseWriter, r *http.Request, f *flight, next http.Handler) {
+	select {
+	case <-f.done:
+	case <-r.Context().Done():
+		return
+	}
+
+	if !f.shared {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	metrics.RecordCoalescedRequest(c.route)
+	header := w.Header()
+	for name, values := range f.header {
+		header[name] = values
+	}
+	w.WriteHeader(f.status)
+	w.Write(f.body)
+}
+
+// coalesceWriter passes the leader's response through and keeps a copy
+// for the waiters while it stays shareable
+type coalesceWriter struct {
+	http.ResponseWriter
+	flight      *flight
+	maxBody     int
+	share       bool
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (cw *coalesceWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	// Per-user responses must never reach another client
+	if h.Get("Set-Cookie") != "" || strings.Contains(strings.ToLower(h.Get("Cache-Control")), "private") {
+		cw.share = false
+	}
+	cw.flight.status = status
+	cw.flight.header = h.Clone()
+
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *coalesceWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.share {
+		if cw.body.Len()+len(b) > cw.maxBody {
+			cw.share = false
+			cw.body.Reset()
+		} else {
+			cw.body.Write(b)
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets the proxy flush through to the client
+func (cw *coalesceWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,111 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestCoalesce(t *testing.T) {
+	var calls int32
+	entered := make(chan struct{}, 10)
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		entered <- struct{}{}
+		<-release
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("report " + r.Header.Get("Accept-Language")))
+	})
+	handler := coalesceHandler(config.RouteConfig{Name: "reports", Coalesce: &config.CoalesceConfig{VaryHeaders: []string{"Accept-Language"}}}, next)
+
+	get := func(lang string, auth bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/reports/daily", nil)
+		req.Header.Set("Accept-Language", lang)
+		if auth {
+			req.Header.Set("Authorization", "Bearer t")
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	results := make([]*httptest.ResponseRecorder, 5)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0] = get("en", false)
+	}()
+	<-entered
+
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = get("en", false)
+		}(i)
+	}
+	// A different language and a credentialed request get their own calls
+	wg.Add(2)
+	var other, private *httptest.ResponseRecorder
+	go func() { defer wg.Done(); other = get("de", false) }()
+	go func() { defer wg.Done(); private = get("en", true) }()
+	<-entered
+	<-entered
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 3 {
+		t.Errorf("Expected 3 backend calls, got %d", calls)
+	}
+	for i, rr := range results {
+		if rr.Code != http.StatusOK || rr.Body.String() != "report en" || rr.Header().Get("Content-Type") != "text/plain" {
+			t.Errorf("Request %d: expected the shared response, got %d %q", i, rr.Code, rr.Body.String())
+		}
+	}
+	if other.Body.String() != "report de" || private.Body.String() != "report en" {
+		t.Errorf("Expected separate responses, got %q and %q", other.Body.String(), private.Body.String())
+	}
+}
+
+func TestCoalesceNotShared(t *testing.T) {
+	var calls int32
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			entered <- struct{}{}
+			<-release
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		w.Write([]byte("welcome"))
+	})
+	handler := coalesceHandler(config.RouteConfig{Coalesce: &config.CoalesceConfig{}}, next)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}()
+		if i == 0 {
+			<-entered
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 2 {
+		t.Errorf("Expected a response setting a cookie not to be shared, got %d backend calls", calls)
+	}
+}
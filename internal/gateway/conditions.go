@@ -0,0 +1,38 @@
+package gateway
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/mux"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// conditionsMatcher matches requests that have the route's headers and
+// query parameters, with the configured values or, for "*", any value
+func conditionsMatcher(route config.RouteConfig) mux.MatcherFunc {
+	return func(r *http.Request, _ *mux.RouteMatch) bool {
+		for name, want := range route.Headers {
+			if !matchesValue(r.Header.Values(name), want) {
+				return false
+			}
+		}
+		if len(route.Query) > 0 {
+			query := r.URL.Query()
+			for name, want := range route.Query {
+				if !matchesValue(query[name], want) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+}
+
+func matchesValue(values []string, want string) bool {
+	if want == "*" {
+		return len(values) > 0
+	}
+	return slices.Contains(values, want)
+}
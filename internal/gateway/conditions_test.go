@@ -0,0 +1,49 @@
+package gateway
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestHeaderAndQueryRoutes(t *testing.T) {
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "test", URL: "http://localhost:3000", Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Routes: []config.RouteConfig{
+			{Name: "api", PathPrefix: "/api", Redirect: &config.RedirectConfig{To: "/v1", DropQuery: true}},
+			{Name: "api-v2", PathPrefix: "/api", Headers: map[string]string{"X-API-Version": "2"}, Redirect: &config.RedirectConfig{To: "/v2", DropQuery: true}},
+			{Name: "api-debug", PathPrefix: "/api", Methods: []string{"GET"}, Query: map[string]string{"debug": "*"}, Redirect: &config.RedirectConfig{To: "/debug", DropQuery: true}},
+		},
+	}
+	handler := New(cfg).Handler()
+
+	testCases := []struct {
+		name     string
+		method   string
+		target   string
+		header   string
+		location string
+	}{
+		{"no conditions", "GET", "/api/users", "", "/v1"},
+		{"header value", "GET", "/api/users", "2", "/v2"},
+		{"other header value", "GET", "/api/users", "3", "/v1"},
+		{"query present", "GET", "/api/users?debug", "", "/debug"},
+		{"query with value", "GET", "/api/users?debug=1", "", "/debug"},
+		{"wrong method", "POST", "/api/users?debug=1", "", "/v1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, nil)
+			if tc.header != "" {
+				req.Header.Set("X-API-Version", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if got := rr.Header().Get("Location"); got != tc.location {
+				t.Errorf("Expected route to %s, got %d to %q", tc.location, rr.Code, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// allowedMethods is sent with the 405 for CONNECT when tunnelling is off
+const allowedMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+
+// connectProxy tunnels CONNECT requests to allowlisted targets
+type connectProxy struct {
+	cfg         config.ConnectConfig
+	users       map[string]string
+	dialTimeout time.Duration
+	idleTimeout time.Duration
+}
+
+func newConnectProxy(cfg config.ConnectConfig) *connectProxy {
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = 10
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 300
+	}
+
+	p := &connectProxy{
+		cfg:         cfg,
+		users:       make(map[string]string, len(cfg.Users)),
+		dialTimeout: time.Duration(cfg.DialTimeout) * time.Second,
+		idleTimeout: time.Duration(cfg.IdleTimeout) * time.Second,
+	}
+	for _, user := range cfg.Users {
+		p.users[user.Username] = user.Password
+	}
+
+	if len(cfg.AllowedTargets) == 0 {
+		logger.Warn("CONNECT is enabled without allowedTargets; every tunnel will be refused")
+	} else {
+		logger.Info("CONNECT tunnels allowed to %s", strings.Join(cfg.AllowedTargets, ", "))
+	}
+	return p
+}
+
+// withConnect takes CONNECT requests before the router, which cannot match
+// their authority-form target and would answer with a redirect. Without a
+// tunnel configured they are refused outright.
+func (gw *Gateway) withConnect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if gw.connect == nil {
+			tracing.RecordDecision(r.Context(), "connect", tracing.Denied, "CONNECT disabled")
+			w.Header().Set("Allow", allowedMethods)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		gw.connect.ServeHTTP(w, r)
+	})
+}
+
+func (p *connectProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Hijacking is HTTP/1 only; HTTP/2 CONNECT is deliberately unsupported
+	if r.ProtoMajor != 1 {
+		http.Error(w, "CONNECT requires HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		return
+	}
+
+	if !p.authorized(r) {
+		tracing.RecordDecision(r.Context(), "connect", tracing.Denied, "missing or invalid proxy credentials")
+		w.Header().Set("Proxy-Authenticate", `Basic realm="gatekeeper"`)
+		http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
+		return
+	}
+
+	if !targetAllowed(p.cfg.AllowedTargets, host, port) {
+		tracing.RecordDecision(r.Context(), "connect", tracing.Denied, "target not allowed")
+		logger.Warn("CONNECT to %s refused: target not in allowedTargets", r.Host)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", r.Host, p.dialTimeout)
+	if err != nil {
+		logger.Warn("CONNECT to %s failed: %v", r.Host, err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
+	client, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		upstream.Close()
+		logger.Error("CONNECT to %s failed, connection cannot be hijacked: %v", r.Host, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	tracing.RecordDecision(r.Context(), "connect", tracing.Allowed, "target allowed")
+	logger.Debug("CONNECT tunnel opened to %s", r.Host)
+
+	// The server's read and write timeouts no longer apply once hijacked
+	client.SetDeadline(time.Time{})
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+	if n := buffered.Reader.Buffered(); n > 0 {
+		data, _ := buffered.Reader.Peek(n)
+		upstream.Write(data)
+	}
+
+	p.pipe(client, upstream)
+	logger.Debug("CONNECT tunnel to %s closed", r.Host)
+}
+
+func (p *connectProxy) authorized(r *http.Request) bool {
+	if len(p.users) == 0 {
+		return true
+	}
+
+	// Reuse BasicAuth parsing for the Proxy-Authorization header
+	auth := &http.Request{Header: http.Header{"Authorization": {r.Header.Get("Proxy-Authorization")}}}
+	username, password, ok := auth.BasicAuth()
+	if !ok {
+		return false
+	}
+	expected, found := p.users[username]
+	return found && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+}
+
+// pipe copies both ways until either side closes or the tunnel has been
+// idle in both directions for the idle timeout
+func (p *connectProxy) pipe(client, upstream net.Conn) {
+	touch := func() {
+		deadline := time.Now().Add(p.idleTimeout)
+		client.SetDeadline(deadline)
+		upstream.SetDeadline(deadline)
+	}
+	touch()
+
+	done := make(chan struct{}, 2)
+	copyConn := func(dst, src net.Conn) {
+		defer func() { done <- struct{}{} }()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				touch()
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	go copyConn(upstream, client)
+	go copyConn(client, upstream)
+
+	// One side finishing ends the tunnel; closing both unblocks the other
+	<-done
+	client.Close()
+	upstream.Close()
+	<-done
+}
+
+// targetAllowed matches host and port against "host:port" patterns. A
+// "*." host prefix matches any subdomain and a "*" port matches any port.
+func targetAllowed(patterns []string, host, port string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range patterns {
+		patternHost, patternPort, err := net.SplitHostPort(pattern)
+		if err != nil {
+			continue
+		}
+		if patternPort != "*" && patternPort != port {
+			continue
+		}
+
+		patternHost = strings.ToLower(patternHost)
+		if strings.HasPrefix(patternHost, "*.") {
+			if strings.HasSuffix(host, patternHost[1:]) {
+				return true
+			}
+		} else if patternHost == host {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// sendConnect opens a raw connection to the gateway and issues a CONNECT
+func sendConnect(t *testing.T, gatewayAddr, target, proxyAuth string) (net.Conn, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", gatewayAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	request := "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n"
+	if proxyAuth != "" {
+		request += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(proxyAuth)) + "\r\n"
+	}
+	if _, err := conn.Write([]byte(request + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, resp
+}
+
+func connectGateway(t *testing.T, connect config.ConnectConfig) *httptest.Server {
+	t.Helper()
+	gw := New(&config.Config{
+		Backends:  []config.Backend{{Name: "api", URL: "http://127.0.0.1:1", Weight: 1, Health: "/health"}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100},
+		Connect:   connect,
+	})
+	srv := httptest.NewServer(gw.Handler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestConnectRejectedByDefault(t *testing.T) {
+	srv := connectGateway(t, config.ConnectConfig{})
+
+	conn, resp := sendConnect(t, srv.Listener.Addr().String(), "example.com:443", "")
+	defer conn.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Allow") == "" {
+		t.Error("expected an Allow header")
+	}
+}
+
+func TestConnectTunnel(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	srv := connectGateway(t, config.ConnectConfig{
+		Enabled:        true,
+		AllowedTargets: []string{"127.0.0.1:*"},
+		Users:          []config.ConnectUser{{Username: "ci", Password: "s3cret"}},
+	})
+	addr := srv.Listener.Addr().String()
+	target := echo.Addr().String()
+
+	t.Run("missing credentials", func(t *testing.T) {
+		conn, resp := sendConnect(t, addr, target, "")
+		defer conn.Close()
+		if resp.StatusCode != http.StatusProxyAuthRequired {
+			t.Errorf("expected 407, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("target not allowed", func(t *testing.T) {
+		conn, resp := sendConnect(t, addr, "localhost:22", "ci:s3cret")
+		defer conn.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("expected 403, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("tunnel established", func(t *testing.T) {
+		conn, resp := sendConnect(t, addr, target, "ci:s3cret")
+		defer conn.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, 4)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			t.Fatal(err)
+		}
+		if string(reply) != "ping" {
+			t.Errorf("expected echoed ping, got %q", reply)
+		}
+	})
+}
+
+func TestTargetAllowed(t *testing.T) {
+	patterns := []string{"api.example.com:443", "*.internal:*"}
+
+	testCases := []struct {
+		host, port string
+		allowed    bool
+	}{
+		{"api.example.com", "443", true},
+		{"API.example.com", "443", true},
+		{"api.example.com", "80", false},
+		{"db.internal", "5432", true},
+		{"internal", "5432", false},
+		{"evil.com", "443", false},
+	}
+
+	for _, tc := range testCases {
+		if got := targetAllowed(patterns, tc.host, tc.port); got != tc.allowed {
+			t.Errorf("%s:%s: expected %v, got %v", tc.host, tc.port, tc.allowed, got)
+		}
+	}
+}
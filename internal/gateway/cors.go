@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/middleware"
+)
+
+// corsPolicies applies the CORS policy of the route a request will match,
+// or the global one. It runs ahead of authentication because browsers send
+// preflight requests without credentials.
+type corsPolicies struct {
+	router *mux.Router
+	global *middleware.CORSMiddleware
+	routes map[*mux.Route]*middleware.CORSMiddleware
+}
+
+func newCORSPolicies(router *mux.Router, cfg config.CORSConfig) *corsPolicies {
+	c := &corsPolicies{router: router, routes: make(map[*mux.Route]*middleware.CORSMiddleware)}
+	if cfg.Enabled {
+		c.global = middleware.NewCORSFromConfig(cfg)
+	}
+	return c
+}
+
+func routesUseCORS(routes []config.RouteConfig) bool {
+	for _, route := range routes {
+		if route.CORS != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// add gives a registered route its own policy
+func (c *corsPolicies) add(route *mux.Route, cfg config.CORSConfig) {
+	c.routes[route] = middleware.NewCORSFromConfig(cfg)
+}
+
+func (c *corsPolicies) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if policy := c.policyFor(r); policy != nil {
+			policy.Handle(w, r, next)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *corsPolicies) policyFor(r *http.Request) *middleware.CORSMiddleware {
+	if len(c.routes) == 0 {
+		return c.global
+	}
+
+	// A preflight is matched as the request it announces, so routes limited
+	// to other methods still get their own policy
+	probe := r
+	if method := r.Header.Get("Access-Control-Request-Method"); r.Method == http.MethodOptions && method != "" {
+		probe = r.Clone(r.Context())
+		probe.Method = method
+	}
+
+	var match mux.RouteMatch
+	if c.router.Match(probe, &match) {
+		if policy, ok := c.routes[match.Route]; ok {
+			return policy
+		}
+	}
+	return c.global
+}
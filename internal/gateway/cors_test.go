@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestCORSPolicies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "test", URL: backend.URL, Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		CORS:      config.CORSConfig{Enabled: true, AllowedOrigins: []string{"*"}},
+		HMAC: config.HMACConfig{
+			Enabled:   true,
+			Consumers: []config.HMACConsumer{{ID: "billing", Secret: "secret"}},
+		},
+		Routes: []config.RouteConfig{{
+			Name:       "account",
+			PathPrefix: "/account",
+			Methods:    []string{"POST"},
+			CORS: &config.CORSConfig{
+				AllowedOrigins:   []string{"https://app.example.com"},
+				AllowedMethods:   []string{"POST"},
+				AllowCredentials: true,
+			},
+		}},
+	}
+	handler := New(cfg).Handler()
+
+	preflight := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Preflights are answered even though HMAC would reject them
+	rr := preflight("/account/settings")
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("Expected the route's policy, got %d %v", rr.Code, rr.Header())
+	}
+	if rr.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Error("Expected the route to allow credentials")
+	}
+
+	rr = preflight("/other")
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("Expected the global policy, got %d %v", rr.Code, rr.Header())
+	}
+	if rr.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("Expected the global policy not to allow credentials")
+	}
+}
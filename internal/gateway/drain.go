@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/admin"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// drainState is what the admin API reports about draining
+type drainState struct {
+	Draining bool  `json:"draining"`
+	InFlight int64 `json:"inFlight"`
+}
+
+// Drain makes the gateway refuse new requests with a 503 and
+// "Connection: close", and /health report "draining", so load balancers
+// move traffic elsewhere while requests in flight finish. Requests for
+// /health and the edge health endpoint are still answered.
+func (gw *Gateway) Drain() {
+	if gw.draining.CompareAndSwap(false, true) {
+		metrics.SetDraining(true)
+		logger.Info("Draining: refusing new requests, %d in flight", gw.inFlight.Load())
+	}
+}
+
+// Resume undoes Drain
+func (gw *Gateway) Resume() {
+	if gw.draining.CompareAndSwap(true, false) {
+		metrics.SetDraining(false)
+		logger.Info("Draining stopped, accepting requests again")
+	}
+}
+
+// Draining reports whether Drain has been called
+func (gw *Gateway) Draining() bool {
+	return gw.draining.Load()
+}
+
+// InFlight returns the number of requests the gateway is handling
+func (gw *Gateway) InFlight() int64 {
+	return gw.inFlight.Load()
+}
+
+// WaitIdle waits until no request is in flight. It returns ctx's error if
+// ctx ends first.
+func (gw *Gateway) WaitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for gw.inFlight.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%d requests still in flight: %w", gw.inFlight.Load(), ctx.Err())
+		}
+	}
+	return nil
+}
+
+// withDrain counts requests in flight and refuses new ones while draining
+func (gw *Gateway) withDrain(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if gw.draining.Load() && !gw.isHealthPath(r.URL.Path) {
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		gw.inFlight.Add(1)
+		metrics.AddInFlightRequests(1)
+		defer func() {
+			gw.inFlight.Add(-1)
+			metrics.AddInFlightRequests(-1)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (gw *Gateway) isHealthPath(path string) bool {
+	if path == "/health" {
+		return true
+	}
+	return gw.config.EdgeHealth.Enabled && path == edgeHealthPath(gw.config.EdgeHealth.Path)
+}
+
+// registerDrainAdmin exposes draining:
+//
+//	GET    /admin/drain  current state
+//	POST   /admin/drain  start draining
+//	DELETE /admin/drain  stop draining
+func (gw *Gateway) registerDrainAdmin() {
+	if gw.admin == nil {
+		return
+	}
+
+	state := func(w http.ResponseWriter) {
+		admin.WriteJSON(w, http.StatusOK, drainState{Draining: gw.Draining(), InFlight: gw.InFlight()})
+	}
+
+	gw.admin.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		state(w)
+	}, "GET")
+
+	gw.admin.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		logger.Info("Draining started via admin API")
+		gw.Drain()
+		state(w)
+	}, "POST")
+
+	gw.admin.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		logger.Info("Draining stopped via admin API")
+		gw.Resume()
+		state(w)
+	}, "DELETE")
+}
@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestDrain(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	}))
+	defer backend.Close()
+
+	gw := New(&config.Config{
+		Backends:   []config.Backend{{Name: "api", URL: backend.URL, Weight: 1, Health: "/health"}},
+		RateLimit:  config.RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100},
+		EdgeHealth: config.EdgeHealthConfig{Enabled: true},
+		Drain:      config.DrainConfig{Timeout: 5},
+	})
+	handler := gw.Handler()
+
+	slow := httptest.NewRecorder()
+	finished := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(slow, httptest.NewRequest("GET", "/slow", nil))
+		close(finished)
+	}()
+	<-started
+	if n := gw.InFlight(); n != 1 {
+		t.Fatalf("Expected 1 request in flight, got %d", n)
+	}
+
+	gw.Drain()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/other", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Connection") != "close" {
+		t.Errorf("Expected 503 with Connection: close while draining, got %d %q", rr.Code, rr.Header().Get("Connection"))
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), `"status":"draining"`) {
+		t.Errorf("Expected health to report draining, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/edge-health", nil))
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), `"weight":0`) {
+		t.Errorf("Expected edge health to fail while draining, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := gw.WaitIdle(ctx); err == nil {
+		t.Error("Expected WaitIdle to time out with a request in flight")
+	}
+
+	close(release)
+	<-finished
+	if slow.Code != http.StatusOK || slow.Body.String() != "done" {
+		t.Errorf("Expected the request in flight to complete, got %d %s", slow.Code, slow.Body.String())
+	}
+	if err := gw.WaitIdle(context.Background()); err != nil {
+		t.Errorf("Expected WaitIdle to return once idle, got %v", err)
+	}
+
+	gw.Resume()
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected health to recover after Resume, got %d", rr.Code)
+	}
+}
+
+func TestShutdownWaitsForRequests(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer backend.Close()
+
+	gw := New(&config.Config{
+		Backends:  []config.Backend{{Name: "api", URL: backend.URL, Weight: 1, Health: "/health"}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100},
+	})
+	handler := gw.Handler()
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	<-started
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+	}()
+	var inFlight int64 = -1
+	gw.Shutdown(context.Background(), func(ctx context.Context) error {
+		inFlight = gw.InFlight()
+		return nil
+	})
+	if inFlight != 0 {
+		t.Errorf("Expected listeners to close after requests finished, %d were in flight", inFlight)
+	}
+}
+
+func TestDrainAdminAPI(t *testing.T) {
+	gw := New(&config.Config{
+		Backends:  []config.Backend{{Name: "api", URL: "http://localhost:3000", Weight: 1}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 60, BurstSize: 10},
+		Admin:     config.AdminConfig{Enabled: true, Token: "t"},
+	})
+	handler := gw.AdminHandler()
+
+	for _, step := range []struct {
+		method   string
+		draining bool
+	}{
+		{"GET", false},
+		{"POST", true},
+		{"GET", true},
+		{"DELETE", false},
+	} {
+		req := httptest.NewRequest(step.method, "/admin/drain", nil)
+		req.Header.Set("Authorization", "Bearer t")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		want := `"draining":false`
+		if step.draining {
+			want = `"draining":true`
+		}
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("%s /admin/drain: expected %s, got %d %s", step.method, want, rr.Code, rr.Body.String())
+		}
+		if gw.Draining() != step.draining {
+			t.Errorf("%s /admin/drain: expected draining %v, got %v", step.method, step.draining, gw.Draining())
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/middleware"
+	"github.com/barisgenc/gatekeeper/internal/redact"
+)
+
+// echoKey marks requests sent to the echo endpoint
+type echoKey struct{}
+
+// echoEndpoint answers requests under its prefix with the request the
+// gateway would have proxied, for debugging header forwarding and routing
+type echoEndpoint struct {
+	prefix   string
+	redactor *redact.Redactor
+}
+
+// debugEcho is the request as the gateway saw it after the middlewares
+type debugEcho struct {
+	Method   string              `json:"method"`
+	Path     string              `json:"path"`
+	Query    string              `json:"query,omitempty"`
+	Host     string              `json:"host"`
+	ClientIP string              `json:"client_ip"`
+	Consumer string              `json:"consumer,omitempty"`
+	Route    string              `json:"route"`
+	Backend  string              `json:"backend,omitempty"`
+	Upstream string              `json:"upstream,omitempty"`
+	Headers  map[string][]string `json:"headers"`
+}
+
+func newEchoEndpoint(cfg config.DebugEchoConfig, redaction config.RedactionConfig) *echoEndpoint {
+	prefix := cfg.Path
+	if prefix == "" {
+		prefix = "/debug/echo"
+	}
+	logger.Warn("Debug echo endpoint enabled at %s; it shows clients the requests the gateway proxies", prefix)
+	return &echoEndpoint{prefix: prefix, redactor: redact.New(redaction)}
+}
+
+// withEcho turns a request for the echo prefix into one for the rest of
+// its path, marked so it goes through the middlewares and routes like any
+// other and is answered where it would have been proxied
+func (gw *Gateway) withEcho(next http.Handler) http.Handler {
+	if gw.echo == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, gw.echo.prefix)
+		if !ok || (rest != "" && rest[0] != '/') {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if rest == "" {
+			rest = "/"
+		}
+
+		r = r.Clone(context.WithValue(r.Context(), echoKey{}, true))
+		r.URL.Path, r.URL.RawPath = rest, ""
+		r.RequestURI = r.URL.RequestURI()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// echoing reports whether r should be echoed instead of proxied
+func echoing(r *http.Request) bool {
+	echo, _ := r.Context().Value(echoKey{}).(bool)
+	return echo
+}
+
+// writeEcho answers r with what would have been sent to backend, or to
+// none when the route does not proxy to a single one
+func (gw *Gateway) writeEcho(w http.ResponseWriter, r *http.Request, backend *config.Backend) {
+	echo := debugEcho{
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Query:    gw.echo.redactor.Query(r.URL.RawQuery),
+		Host:     r.Host,
+		ClientIP: middleware.ConnectingIP(r, gw.config.RateLimit.UseForwardedFor),
+		Route:    routeName(r),
+		Headers:  make(map[string][]string, len(r.Header)),
+	}
+	echo.Consumer, _ = middleware.IdentityFromContext(r.Context())
+	if backend != nil {
+		echo.Backend = backend.Name
+		echo.Upstream = strings.TrimSuffix(backend.URL, "/") + r.URL.EscapedPath()
+		if echo.Query != "" {
+			echo.Upstream += "?" + echo.Query
+		}
+	}
+	for name, values := range r.Header {
+		for _, value := range values {
+			echo.Headers[name] = append(echo.Headers[name], gw.echo.redactor.Header(name, value))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(echo)
+}
+
+// echoHandler echoes requests for routes that call their backends
+// themselves, such as aggregates, rather than proxying them
+func (gw *Gateway) echoHandler(next http.Handler) http.Handler {
+	if gw.echo == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if echoing(r) {
+			gw.writeEcho(w, r, nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
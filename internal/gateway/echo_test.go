@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestDebugEcho(t *testing.T) {
+	var proxied atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Add(1)
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "users", URL: backend.URL, Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		DebugEcho: config.DebugEchoConfig{Enabled: true},
+		Routes: []config.RouteConfig{
+			{Name: "users", PathPrefix: "/api/users", SetHeaders: map[string]string{"X-Tier": `"gold"`}},
+			{Name: "old", Path: "/old", Redirect: &config.RedirectConfig{To: "/api/users"}},
+		},
+	}
+	handler := New(cfg).Handler()
+
+	req := httptest.NewRequest("GET", "/debug/echo/api/users/42?token=abc&page=2", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	req.Header.Set("X-Request-Source", "test")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var echo debugEcho
+	if err := json.Unmarshal(rec.Body.Bytes(), &echo); err != nil {
+		t.Fatalf("Expected a JSON echo, got %q", rec.Body.String())
+	}
+	if echo.Path != "/api/users/42" || echo.Route != "users" || echo.Backend != "users" || echo.ClientIP != "192.0.2.1" {
+		t.Errorf("Expected /api/users/42 on route users to backend users from 192.0.2.1, got %+v", echo)
+	}
+	if want := backend.URL + "/api/users/42?token=[REDACTED]&page=2"; echo.Upstream != want {
+		t.Errorf("Expected upstream %s, got %s", want, echo.Upstream)
+	}
+	if got := echo.Headers["X-Tier"]; len(got) != 1 || got[0] != "gold" {
+		t.Errorf("Expected the route's X-Tier header, got %v", got)
+	}
+	if got := echo.Headers["Authorization"]; len(got) != 1 || got[0] != "[REDACTED]" {
+		t.Errorf("Expected Authorization to be masked, got %v", got)
+	}
+	if proxied.Load() != 0 {
+		t.Errorf("Expected the echoed request not to reach the backend, got %d requests", proxied.Load())
+	}
+
+	// Routes that answer at the gateway still do
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/echo/old", nil))
+	if rec.Code != http.StatusFound {
+		t.Errorf("Expected the redirect route to answer 302, got %d", rec.Code)
+	}
+
+	// Paths that only start like the prefix are proxied as usual
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/echoes", nil))
+	if proxied.Load() != 1 {
+		t.Errorf("Expected /debug/echoes to be proxied, got %d requests", proxied.Load())
+	}
+}
+
+func TestDebugEchoForwardedFor(t *testing.T) {
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "users", URL: "http://127.0.0.1:1", Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100, UseForwardedFor: true},
+		DebugEcho: config.DebugEchoConfig{Enabled: true},
+	}
+	handler := New(cfg).Handler()
+
+	// Behind a load balancer the client is the hop it appended, as the
+	// rate limits see it
+	req := httptest.NewRequest("GET", "/debug/echo/api", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var echo debugEcho
+	if err := json.Unmarshal(rec.Body.Bytes(), &echo); err != nil {
+		t.Fatalf("Expected a JSON echo, got %q", rec.Body.String())
+	}
+	if echo.ClientIP != "198.51.100.7" {
+		t.Errorf("Expected client IP 198.51.100.7, got %q", echo.ClientIP)
+	}
+}
@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// edgeHealth is what external traffic managers are told about this region
+type edgeHealth struct {
+	Status          string  `json:"status"`
+	Region          string  `json:"region,omitempty"`
+	Weight          int     `json:"weight"`
+	Capacity        float64 `json:"capacity"`
+	HealthyBackends int     `json:"healthy_backends"`
+	TotalBackends   int     `json:"total_backends"`
+	Incident        string  `json:"incident,omitempty"`
+	Shedding        bool    `json:"shedding"`
+}
+
+// edgeHealthPath returns where edge health is served
+func edgeHealthPath(path string) string {
+	if path == "" {
+		return "/edge-health"
+	}
+	return path
+}
+
+// edgeHealthHandler answers GSLB and DNS health checks. Unlike /health,
+// which only fails once no backend is left or while draining, it reports the share of
+// capacity that is healthy so traffic can move away from a failing region
+// early.
+func (gw *Gateway) edgeHealthHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := gw.config.EdgeHealth
+	if cfg.DegradedBelow == 0 {
+		cfg.DegradedBelow = 0.75
+	}
+	if cfg.FailBelow == 0 {
+		cfg.FailBelow = 0.5
+	}
+	if cfg.DegradedStatus == 0 {
+		cfg.DegradedStatus = http.StatusOK
+	}
+
+	gw.mu.RLock()
+	healthy := gw.loadBalancer.GetHealthyBackends()
+	gw.mu.RUnlock()
+
+	health := edgeHealth{
+		Region:          cfg.Region,
+		HealthyBackends: len(healthy),
+		TotalBackends:   len(config.Instances(gw.config.Backends)),
+		Capacity:        math.Round(gw.loadBalancer.Capacity()*1000) / 1000,
+	}
+
+	if gw.incident != nil {
+		if inc, open := gw.incident.Current(); open {
+			health.Incident = inc.ID
+			health.Shedding = gw.config.Incident.ShedBelowPriority > 0
+		}
+	}
+
+	status := http.StatusOK
+	health.Status = "ok"
+	health.Weight = int(math.Round(health.Capacity * 100))
+	switch {
+	case health.Capacity < cfg.FailBelow || gw.Draining() || (health.Incident != "" && cfg.FailDuringIncident):
+		status = http.StatusServiceUnavailable
+		health.Status = "fail"
+		health.Weight = 0
+	case health.Capacity < cfg.DegradedBelow || health.Incident != "":
+		status = cfg.DegradedStatus
+		health.Status = "degraded"
+		if health.Incident != "" {
+			health.Weight /= 2
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Edge-Weight", strconv.Itoa(health.Weight))
+	writeJSON(w, status, health)
+}
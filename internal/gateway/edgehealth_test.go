@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestEdgeHealth(t *testing.T) {
+	cfg := &config.Config{
+		Backends: []config.Backend{
+			{Name: "a", URL: "http://a.internal", Weight: 50},
+			{Name: "b", URL: "http://b.internal", Weight: 30},
+			{Name: "c", URL: "http://c.internal", Weight: 20},
+		},
+		RateLimit:  config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		EdgeHealth: config.EdgeHealthConfig{Enabled: true, Region: "eu-west", DegradedStatus: http.StatusTooManyRequests},
+	}
+	gw := New(cfg)
+	handler := gw.Handler()
+
+	testCases := []struct {
+		name           string
+		down           []string
+		expectedStatus int
+		expectedHealth string
+		expectedWeight int
+	}{
+		{"all healthy", nil, http.StatusOK, "ok", 100},
+		{"small backend down", []string{"c"}, http.StatusOK, "ok", 80},
+		{"degraded", []string{"b"}, http.StatusTooManyRequests, "degraded", 70},
+		{"failed", []string{"a", "c"}, http.StatusServiceUnavailable, "fail", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, backend := range cfg.Backends {
+				gw.loadBalancer.SetBackendHealth(backend.Name, true)
+			}
+			for _, name := range tc.down {
+				gw.loadBalancer.SetBackendHealth(name, false)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/edge-health", nil))
+
+			var health edgeHealth
+			if err := json.Unmarshal(rr.Body.Bytes(), &health); err != nil {
+				t.Fatal(err)
+			}
+			if rr.Code != tc.expectedStatus || health.Status != tc.expectedHealth || health.Weight != tc.expectedWeight {
+				t.Errorf("Expected %d %s weight %d, got %d %s weight %d",
+					tc.expectedStatus, tc.expectedHealth, tc.expectedWeight, rr.Code, health.Status, health.Weight)
+			}
+			if health.Region != "eu-west" || health.TotalBackends != 3 {
+				t.Errorf("Unexpected report %+v", health)
+			}
+			if rr.Header().Get("X-Edge-Weight") == "" {
+				t.Error("Expected X-Edge-Weight header")
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// newEgressTransports gives each backend with a forward proxy its own copy
+// of the shared transport that dials through the proxy, and HTTPS backends
+// with protocol "http1" a copy that never negotiates HTTP/2. Other backends
+// use the shared transport directly.
+func newEgressTransports(shared *http.Transport, backends []config.Backend) map[string]*http.Transport {
+	transports := make(map[string]*http.Transport)
+	for _, backend := range backends {
+		if backend.Proxy == nil {
+			// TLS would offer h2 through ALPN
+			if backend.Protocol == "http1" && strings.HasPrefix(backend.URL, "https://") {
+				transport := shared.Clone()
+				transport.ForceAttemptHTTP2 = false
+				transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+				transports[backend.Name] = transport
+			}
+			continue
+		}
+
+		proxyURL, err := backend.Proxy.ParseURL()
+		if err != nil {
+			// validate rejects these; never fall back to a direct connection
+			logger.Error("Backend %s proxy misconfigured, requests will fail: %v", backend.Name, err)
+			transports[backend.Name] = &http.Transport{Proxy: func(*http.Request) (*url.URL, error) {
+				return nil, err
+			}}
+			continue
+		}
+
+		transport := shared.Clone()
+		transport.Proxy = http.ProxyURL(proxyURL)
+		transports[backend.Name] = transport
+		logger.Info("Backend %s reached through %s proxy %s", backend.Name, proxyURL.Scheme, proxyURL.Host)
+	}
+	return transports
+}
+
+// backendTransport is the transport for requests to the named backend
+func (gw *Gateway) backendTransport(name string) *http.Transport {
+	if transport, ok := gw.egress[name]; ok {
+		return transport
+	}
+	return gw.transport
+}
+
+// roundTripper is the transport for requests to the named backend
+// instance, including token relay and signing when they are enabled.
+// Tokens, signatures and the upstream stand-in name the backend the
+// instance belongs to.
+func (gw *Gateway) roundTripper(name string) http.RoundTripper {
+	group := name
+	if g, ok := gw.groups[name]; ok {
+		group = g
+	}
+	next := http.RoundTripper(gw.backendTransport(name))
+	if gw.upstream != nil {
+		next = gw.upstream(group)
+	} else {
+		next = gw.protocols.transport(name, next)
+	}
+	next = countProtocol(name, next)
+	if gw.signer != nil {
+		next = gw.signer.transport(next, group)
+	}
+	if gw.relay != nil {
+		next = gw.relay.transport(next, group)
+	}
+	return next
+}
+
+// countProtocol records the HTTP version each response from backend came
+// over
+func countProtocol(backend string, next http.RoundTripper) http.RoundTripper {
+	return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(r)
+		if err == nil {
+			metrics.RecordBackendProtocol(backend, resp.Proto)
+		}
+		return resp, err
+	})
+}
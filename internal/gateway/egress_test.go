@@ -0,0 +1,56 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestBackendThroughConnectProxy(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("via proxy"))
+	}))
+	defer backend.Close()
+	backendAddr := backend.Listener.Addr().String()
+
+	// Another gateway in CONNECT mode stands in for the corporate proxy
+	proxy := connectGateway(t, config.ConnectConfig{
+		Enabled:        true,
+		AllowedTargets: []string{backendAddr},
+		Users:          []config.ConnectUser{{Username: "svc", Password: "secret"}},
+	})
+
+	gw := New(&config.Config{
+		Backends: []config.Backend{{
+			Name:   "saas",
+			URL:    backend.URL,
+			Weight: 1,
+			Health: "/health",
+			Proxy:  &config.BackendProxyConfig{URL: proxy.URL, Username: "svc", Password: "secret"},
+		}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100},
+		Routes:    []config.RouteConfig{{PathPrefix: "/"}},
+	})
+	gw.egress["saas"].TLSClientConfig.RootCAs = backend.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	rr := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "via proxy" {
+		t.Fatalf("Expected the backend response through the proxy, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	// Wrong credentials must not fall back to a direct connection
+	wrong, _ := (&config.BackendProxyConfig{URL: proxy.URL, Username: "svc", Password: "wrong"}).ParseURL()
+	gw.egress["saas"].Proxy = http.ProxyURL(wrong)
+	gw.egress["saas"].CloseIdleConnections()
+
+	rr = httptest.NewRecorder()
+	gw.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusBadGateway {
+		body, _ := io.ReadAll(rr.Body)
+		t.Errorf("Expected 502 when the proxy refuses the tunnel, got %d %s", rr.Code, body)
+	}
+}
@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/expr"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/middleware"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// exprInput is what a route's expressions see of r
+func exprInput(r *http.Request) expr.Input {
+	in := expr.Input{Request: r, ClientIP: r.RemoteAddr}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		in.ClientIP = ip
+	}
+	in.Consumer, _ = middleware.IdentityFromContext(r.Context())
+	return in
+}
+
+// whenMatcher matches the requests for which the route's when condition
+// holds. A condition that fails to evaluate, such as on a missing header,
+// does not match.
+func whenMatcher(label, when string) mux.MatcherFunc {
+	condition, err := expr.Compile(when)
+	if err != nil {
+		logger.Error("Route %s condition is invalid and never matches: %v", label, err)
+		return func(*http.Request, *mux.RouteMatch) bool { return false }
+	}
+	return func(r *http.Request, _ *mux.RouteMatch) bool {
+		ok, err := condition.Bool(exprInput(r))
+		if err != nil {
+			logger.Debug("Route %s condition did not match %s %s: %v", label, r.Method, r.URL.Path, err)
+		}
+		return ok
+	}
+}
+
+// expressionHandler rejects requests the route's allow expression does not
+// hold for, then sets its expression headers on the upstream request. An
+// allow expression that fails to evaluate rejects the request.
+func expressionHandler(label string, route config.RouteConfig, next http.Handler) http.Handler {
+	var allow *expr.Program
+	var err error
+	if route.Allow != "" {
+		allow, err = expr.Compile(route.Allow)
+	}
+	headers := make(map[string]*expr.Program, len(route.SetHeaders))
+	for name, value := range route.SetHeaders {
+		if err != nil {
+			break
+		}
+		headers[http.CanonicalHeaderKey(name)], err = expr.CompileString(value)
+	}
+	if err != nil {
+		logger.Error("Route %s expressions misconfigured, rejecting all requests: %v", label, err)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in := exprInput(r)
+		if allow != nil {
+			ok, err := allow.Bool(in)
+			if err != nil || !ok {
+				reason := "allow expression is false"
+				if err != nil {
+					reason = err.Error()
+				}
+				tracing.RecordDecision(r.Context(), "expression", tracing.Denied, reason)
+				logger.Warn("Route %s denied %s %s from %s: %s", label, r.Method, r.URL.Path, in.ClientIP, reason)
+				metrics.RecordExpressionDenied(label)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			tracing.RecordDecision(r.Context(), "expression", tracing.Allowed, "")
+		}
+
+		// Every value is computed from the request as it came in
+		values := make(map[string]string, len(headers))
+		for name, value := range headers {
+			v, err := value.Value(in)
+			if err != nil {
+				logger.Warn("Route %s header %s not set: %v", label, name, err)
+				continue
+			}
+			values[name] = v
+		}
+		for name, v := range values {
+			if v == "" {
+				r.Header.Del(name)
+			} else {
+				r.Header.Set(name, v)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
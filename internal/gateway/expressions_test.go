@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestExpressionRoutes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Route") + " " + r.Header.Get("X-Tier") + " " + r.Header.Get("X-Debug")))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "test", URL: backend.URL, Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Routes: []config.RouteConfig{
+			{
+				Name:       "acme",
+				PathPrefix: "/api",
+				When:       `"X-Tenant" in request.header && request.header["X-Tenant"] == "acme" && request.path.startsWith("/api/v2")`,
+				SetHeaders: map[string]string{
+					"X-Route": `"acme"`,
+					"X-Tier":  `request.query["plan"] == "gold" ? "gold" : "basic"`,
+					"X-Debug": `""`,
+				},
+			},
+			{
+				Name:       "api",
+				PathPrefix: "/api",
+				Allow:      `request.method != "DELETE"`,
+				SetHeaders: map[string]string{"X-Route": `"api"`},
+			},
+		},
+	}
+	handler := New(cfg).Handler()
+
+	testCases := []struct {
+		name, method, path, tenant string
+		status                     int
+		body                       string
+	}{
+		{"condition holds", "GET", "/api/v2/items?plan=gold", "acme", http.StatusOK, "acme gold "},
+		{"query parameter missing", "GET", "/api/v2/items", "acme", http.StatusOK, "acme  "},
+		{"other tenant", "GET", "/api/v2/items", "globex", http.StatusOK, "api  on"},
+		{"no tenant", "GET", "/api/v1/items", "", http.StatusOK, "api  on"},
+		{"denied", "DELETE", "/api/v1/items", "", http.StatusForbidden, "Forbidden\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.Header.Set("X-Debug", "on")
+			if tc.tenant != "" {
+				req.Header.Set("X-Tenant", tc.tenant)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			body, _ := io.ReadAll(rr.Body)
+			if rr.Code != tc.status || string(body) != tc.body {
+				t.Errorf("Expected %d %q, got %d %q", tc.status, tc.body, rr.Code, body)
+			}
+		})
+	}
+}
@@ -3,19 +3,30 @@ package gateway
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
 
+	"github.com/barisgenc/gatekeeper/internal/accesslog"
+	"github.com/barisgenc/gatekeeper/internal/admin"
+	"github.com/barisgenc/gatekeeper/internal/bulkhead"
 	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/events"
+	"github.com/barisgenc/gatekeeper/internal/kv"
 	"github.com/barisgenc/gatekeeper/internal/loadbalancer"
 	"github.com/barisgenc/gatekeeper/internal/logger"
 	"github.com/barisgenc/gatekeeper/internal/metrics"
 	"github.com/barisgenc/gatekeeper/internal/middleware"
+	"github.com/barisgenc/gatekeeper/internal/redact"
+	"github.com/barisgenc/gatekeeper/internal/slo"
+	"github.com/barisgenc/gatekeeper/internal/traffic"
+	"github.com/barisgenc/gatekeeper/internal/usage"
 )
 
 type Gateway struct {
@@ -23,32 +34,176 @@ type Gateway struct {
 	loadBalancer *loadbalancer.LoadBalancer
 	router       *mux.Router
 	middlewares  []middleware.Middleware
+	admin        *admin.Server
+	signer       *upstreamSigner
+	relay        *tokenRelay
+	connect      *connectProxy
+	echo         *echoEndpoint
+	versions     *apiVersions
+	bulkheads    map[string]*bulkhead.Limiter
+	transport    *http.Transport
+	egress       map[string]*http.Transport
+	groups       map[string]string
+	connLimits   map[string]*bulkhead.Limiter
+	backendBytes *traffic.Stats
+	routeBytes   *traffic.Stats
+	pipelines    *pipelines
+	cors         *corsPolicies
+	costs        *routeCosts
+	storage      kv.Store
+	autoBan      *middleware.AutoBanMiddleware
+	tarpit       *middleware.Tarpit
+	upstream     func(backend string) http.RoundTripper
+	incident     *middleware.IncidentMiddleware
+	protocols    *protocolCache
+	alerts       *alerts
+	slos         *slo.Tracker
+	accessLog    *accesslog.Logger
+	events       *events.Exporter
+	usage        *usage.Meter
+	hooks        lifecycle
+	checks       context.Context
+	stopChecks   context.CancelFunc
+	draining     atomic.Bool
+	inFlight     atomic.Int64
 	mu           sync.RWMutex
 }
 
+// runHealthChecks is turned off by tests whose backends must only see the
+// requests the test sends
+var runHealthChecks = true
+
 func New(cfg *config.Config) *Gateway {
+	return NewWithUpstream(cfg, nil)
+}
+
+// NewWithUpstream is New with upstream standing in for the network: the
+// requests the gateway proxies to a backend go to upstream(backend)
+// instead. Backends are not health checked, since the checks would reach
+// the real ones; they stay healthy unless reported otherwise.
+func NewWithUpstream(cfg *config.Config, upstream func(backend string) http.RoundTripper) *Gateway {
 	gw := &Gateway{
 		config:       cfg,
 		loadBalancer: loadbalancer.New(cfg.Backends),
 		router:       mux.NewRouter(),
+		transport:    newUpstreamTransport(cfg.UpstreamTLS),
+		backendBytes: traffic.NewStats(),
+		routeBytes:   traffic.NewStats(),
+		slos:         slo.New(cfg.SLOs),
+		pipelines:    &pipelines{routes: make(map[string][]*routePipeline)},
+		upstream:     upstream,
+	}
+	gw.checks, gw.stopChecks = context.WithCancel(context.Background())
+
+	if cfg.Admin.Enabled {
+		if cfg.Admin.Token == "" {
+			logger.Warn("Admin API has no token; make sure %s is not reachable by clients", cfg.Admin.Address)
+		}
+		gw.admin = admin.New(cfg.Admin.Token)
+	}
+
+	if cfg.UpstreamSign.Enabled {
+		gw.signer = newUpstreamSigner(cfg.UpstreamSign)
+	}
+
+	if cfg.TokenRelay.Enabled {
+		gw.relay = newTokenRelay(cfg.TokenRelay)
+	}
+
+	if cfg.Connect.Enabled {
+		gw.connect = newConnectProxy(cfg.Connect)
+	}
+
+	if cfg.DebugEcho.Enabled {
+		gw.echo = newEchoEndpoint(cfg.DebugEcho, cfg.Redaction)
+	}
+
+	if cfg.Versioning.Enabled {
+		gw.versions = newAPIVersions(cfg.Versioning)
+	}
+
+	instances := config.Instances(cfg.Backends)
+	gw.egress = newEgressTransports(gw.transport, instances)
+	gw.protocols = newProtocolCache(cfg.Protocols, instances)
+	gw.connLimits = newConnectionLimits(instances)
+	gw.groups = make(map[string]string, len(instances))
+	for _, instance := range instances {
+		gw.groups[instance.Name] = instance.Group
+	}
+
+	// Features on the "shared" store fail closed if it cannot be opened
+	storage, err := kv.Open(cfg.Storage)
+	if err != nil {
+		logger.Error("Shared storage unavailable: %v", err)
+	} else {
+		gw.storage = storage
+	}
+
+	if cfg.Bulkhead.Enabled {
+		gw.bulkheads = newBackendBulkheads(cfg.Bulkhead)
 	}
 
+	gw.setupAlerts(cfg.Notifications)
+	gw.applyLoadBalancing(cfg.LoadBalancing)
 	gw.setupMiddleware()
 	gw.setupRoutes()
-	gw.startHealthChecks()
+	if err := gw.ReloadPipelines(cfg); err != nil {
+		logger.Error("Route middleware pipelines were not built: %v", err)
+	}
+	gw.registerStatsAdmin()
+	gw.registerSLOAdmin()
+	gw.registerDrainAdmin()
+	if upstream == nil && runHealthChecks {
+		gw.startHealthChecks()
+	}
 
 	return gw
 }
 
 func (gw *Gateway) setupMiddleware() {
+	if gw.config.Tarpit.Enabled {
+		gw.tarpit = middleware.NewTarpit(gw.config.Tarpit)
+	}
+
 	// Rate limiting middleware
-	rateLimiter := middleware.NewRateLimiter(
-		gw.config.RateLimit.RequestsPerMinute,
-		gw.config.RateLimit.BurstSize,
-	)
+	var rateLimiter *middleware.RateLimitMiddleware
+	if gw.config.RateLimit.SpikeArrest() {
+		rateLimiter = middleware.NewSpikeArrest(gw.config.RateLimit.RequestsPerMinute)
+	} else {
+		rateLimiter = middleware.NewRateLimiter(
+			gw.config.RateLimit.RequestsPerMinute,
+			gw.config.RateLimit.BurstSize,
+		)
+	}
 
-	// Logging middleware
-	loggingMiddleware := middleware.NewLogging()
+	// Access log, kept apart from the application log
+	redactor := redact.New(gw.config.Redaction)
+	accessLog, err := accesslog.New(gw.config.AccessLog, redactor)
+	if err != nil {
+		logger.Error("%v; writing the access log to stdout", err)
+		cfg := gw.config.AccessLog
+		cfg.Output = "stdout"
+		accessLog, _ = accesslog.New(cfg, redactor)
+	}
+	gw.accessLog = accessLog
+	if gw.config.Events.Enabled {
+		gw.events = events.New(gw.config.Events)
+		accessLog.Export(gw.events.Export)
+	}
+	if gw.config.Usage.Enabled {
+		var store kv.Store = kv.NewMemoryStore()
+		if gw.config.Usage.Store == "shared" {
+			if gw.storage != nil {
+				store = gw.storage
+			} else {
+				logger.Error("Usage shared store unavailable; metering in memory")
+			}
+		}
+		gw.usage = usage.New(gw.config.Usage, store)
+		accessLog.Export(gw.usage.Record)
+		gw.registerUsageAdmin()
+	}
+	loggingMiddleware := middleware.NewAccessLog(accessLog)
 
 	// Metrics middleware
 	metricsMiddleware := middleware.NewMetrics()
@@ -57,8 +212,193 @@ func (gw *Gateway) setupMiddleware() {
 	gw.middlewares = []middleware.Middleware{
 		loggingMiddleware,
 		metricsMiddleware,
-		rateLimiter,
 	}
+
+	// CORS answers preflights before authentication, which they cannot pass
+	gw.cors = newCORSPolicies(gw.router, gw.config.CORS)
+	if gw.config.CORS.Enabled || routesUseCORS(gw.config.Routes) {
+		gw.middlewares = append(gw.middlewares, gw.cors)
+	}
+
+	// Maintenance answers before anything spends a rate limit token or
+	// asks for credentials
+	if gw.config.Maintenance.Enabled {
+		maintenance := middleware.NewMaintenance(gw.config.Maintenance, gw.matchedRoute)
+		gw.middlewares = append(gw.middlewares, maintenance)
+		gw.registerMaintenanceAdmin(maintenance)
+	}
+
+	// Auto-ban sees the final status of every request, rate limits included
+	if gw.config.AutoBan.Enabled {
+		gw.autoBan = middleware.NewAutoBanWithStorage(gw.config.AutoBan, gw.storage)
+		gw.autoBan.OnHoneypot(gw.alertHoneypot)
+		gw.middlewares = append(gw.middlewares, gw.autoBan)
+		gw.registerAutoBanAdmin(gw.autoBan)
+	}
+
+	// Bots are turned away before they spend rate limit tokens, and
+	// auto-ban counts the 403s of clients that keep trying
+	if gw.config.BotDetection.Enabled {
+		gw.middlewares = append(gw.middlewares, middleware.NewBotDetection(gw.config.BotDetection, gw.matchedRoute))
+	}
+
+	// Routes that cost more than one token say so before the rate limits
+	gw.costs = newRouteCosts(gw.router)
+	if routesHaveCost(gw.config.Routes) {
+		gw.middlewares = append(gw.middlewares, gw.costs)
+	}
+
+	gw.middlewares = append(gw.middlewares, rateLimiter)
+
+	if gw.config.Banner.Enabled {
+		banner := middleware.NewBanner(gw.config.Banner)
+		gw.middlewares = append(gw.middlewares, banner)
+		gw.registerBannerAdmin(banner)
+	}
+
+	if gw.config.OIDC.Enabled {
+		gw.middlewares = append(gw.middlewares, middleware.NewOIDCWithSessions(gw.config.OIDC, gw.config.Sessions, gw.storage))
+	}
+
+	if gw.config.Sessions.Enabled {
+		gw.registerSessionsAdmin()
+	}
+
+	if gw.config.SAML.Enabled {
+		gw.middlewares = append(gw.middlewares, middleware.NewSAML(gw.config.SAML))
+	}
+
+	if gw.config.SPNEGO.Enabled {
+		gw.middlewares = append(gw.middlewares, middleware.NewSPNEGO(gw.config.SPNEGO))
+	}
+
+	if gw.config.LDAP.Enabled {
+		gw.middlewares = append(gw.middlewares, middleware.NewLDAP(gw.config.LDAP))
+	}
+
+	if gw.config.Introspection.Enabled {
+		gw.middlewares = append(gw.middlewares, middleware.NewIntrospection(gw.config.Introspection))
+	}
+
+	if gw.config.HMAC.Enabled {
+		gw.middlewares = append(gw.middlewares, middleware.NewHMAC(gw.config.HMAC))
+	}
+
+	if gw.config.ExtAuthz.Enabled {
+		gw.middlewares = append(gw.middlewares, middleware.NewExtAuthz(gw.config.ExtAuthz))
+	}
+
+	if gw.config.Server.TLS.ForwardClientCert {
+		gw.middlewares = append(gw.middlewares, middleware.NewClientCert())
+	}
+
+	// Per-client tiers need to know who authenticated, so they follow auth
+	if rl := gw.config.RateLimit; rl.Anonymous != nil || rl.Authenticated != nil || len(rl.Plans) > 0 {
+		gw.middlewares = append(gw.middlewares, middleware.NewTieredRateLimitWithStorage(gw.config.RateLimit, gw.storage))
+	}
+
+	if gw.config.RateLimitSvc.Enabled {
+		gw.middlewares = append(gw.middlewares, middleware.NewRateLimitService(gw.config.RateLimitSvc))
+	}
+
+	// Quotas count only what the rate limits let through
+	if gw.config.Quota.Enabled {
+		quota := middleware.NewQuotaWithStorage(gw.config.Quota, gw.storage)
+		gw.middlewares = append(gw.middlewares, quota)
+		gw.registerQuotaAdmin(quota)
+	}
+
+	// Idempotency keys are scoped per identity, and replays should still
+	// count against the client's rate limit
+	if gw.config.Idempotency.Enabled {
+		gw.middlewares = append(gw.middlewares, middleware.NewIdempotencyWithStorage(gw.config.Idempotency, gw.storage))
+	}
+
+	// The external processor sees the request as it will be proxied, and
+	// the response before anything above it does
+	if gw.config.ExtProc.Enabled {
+		gw.middlewares = append(gw.middlewares, middleware.NewExtProc(gw.config.ExtProc))
+	}
+
+	// The global bulkhead goes last so rejected requests never hold a slot
+	if gw.config.Bulkhead.Enabled && gw.config.Bulkhead.MaxConcurrent > 0 {
+		gw.middlewares = append(gw.middlewares, middleware.NewBulkhead(gw.config.Bulkhead))
+	}
+
+	// Incident mode wraps everything but classification, so it sees final
+	// statuses, tags the access log and can shed by priority
+	if gw.config.Incident.Enabled {
+		gw.incident = middleware.NewIncident(gw.config.Incident)
+		gw.middlewares = append([]middleware.Middleware{gw.incident}, gw.middlewares...)
+	}
+
+	// Classification runs first so every later middleware sees the class
+	if gw.config.Classification.Enabled {
+		classification := middleware.NewClassification(gw.config.Classification)
+		gw.middlewares = append([]middleware.Middleware{classification}, gw.middlewares...)
+	}
+
+	// Paths are cleaned before anything matches on them
+	if gw.config.PathNormalize.Enabled {
+		var prefixes []string
+		for _, route := range gw.config.Routes {
+			if route.PathPrefix != "" {
+				prefixes = append(prefixes, route.PathPrefix)
+			}
+		}
+		normalize := middleware.NewPathNormalize(gw.config.PathNormalize, prefixes)
+		gw.middlewares = append([]middleware.Middleware{normalize}, gw.middlewares...)
+	}
+
+	// Tracing wraps everything so policy decisions land on the request span
+	if gw.config.Tracing.Enabled {
+		gw.middlewares = append([]middleware.Middleware{middleware.NewTracing()}, gw.middlewares...)
+	}
+
+	// Arrival is stamped before anything else so queue time covers every
+	// middleware
+	if gw.config.RequestAge.Enabled {
+		gw.middlewares = append([]middleware.Middleware{middleware.NewRequestAge(gw.config.RequestAge)}, gw.middlewares...)
+	}
+
+	// HSTS goes outermost so rejections and login redirects carry it too
+	if gw.config.Server.TLS.Enabled() && gw.config.Server.HSTS.MaxAge > 0 {
+		gw.middlewares = append([]middleware.Middleware{middleware.NewHSTS(gw.config.Server.HSTS)}, gw.middlewares...)
+	}
+
+	for _, m := range gw.middlewares {
+		gw.useTarpit(m)
+	}
+}
+
+// useTarpit makes m, if it rejects abusive clients, hold them in the tarpit
+func (gw *Gateway) useTarpit(m middleware.Middleware) {
+	if t, ok := m.(interface{ SetTarpit(*middleware.Tarpit) }); ok && gw.tarpit != nil {
+		t.SetTarpit(gw.tarpit)
+	}
+}
+
+// Use appends a middleware to the chain. It runs after the built-in
+// middlewares, closest to the proxy, and must be called before Handler.
+func (gw *Gateway) Use(m middleware.Middleware) {
+	gw.middlewares = append(gw.middlewares, m)
+}
+
+// AdminHandler returns the admin API, or nil when it is disabled
+func (gw *Gateway) AdminHandler() http.Handler {
+	if gw.admin == nil {
+		return nil
+	}
+	return gw.admin.Handler()
+}
+
+// matchedRoute names the configured route r will match, ahead of routing
+func (gw *Gateway) matchedRoute(r *http.Request) string {
+	var match mux.RouteMatch
+	if gw.router.Match(r, &match) && match.Route != nil && match.Route.GetName() != "" {
+		return match.Route.GetName()
+	}
+	return defaultRoute
 }
 
 func (gw *Gateway) setupRoutes() {
@@ -68,19 +408,134 @@ func (gw *Gateway) setupRoutes() {
 	// Metrics endpoint
 	gw.router.Handle("/metrics", metrics.Handler()).Methods("GET")
 
+	if gw.config.EdgeHealth.Enabled {
+		gw.router.HandleFunc(edgeHealthPath(gw.config.EdgeHealth.Path), gw.edgeHealthHandler).Methods("GET", "HEAD")
+	}
+
+	for _, route := range gw.config.OrderedRoutes() {
+		gw.addRoute(route)
+	}
+
 	// All other requests go through the proxy
-	gw.router.PathPrefix("/").HandlerFunc(gw.proxyHandler)
+	gw.router.PathPrefix("/").Handler(gw.routeMetricsHandler(defaultRoute, http.HandlerFunc(gw.proxyHandler)))
+}
+
+// addRoute registers a configured route ahead of the catch-all proxy
+func (gw *Gateway) addRoute(route config.RouteConfig) {
+	pattern, err := route.Pattern()
+	if err != nil {
+		logger.Error("Route %s is invalid and was not registered: %v", route.Name, err)
+		return
+	}
+
+	label := routeLabel(route, pattern)
+	var handler http.Handler = http.HandlerFunc(gw.proxyHandler)
+	if route.LoadBalancing != nil {
+		handler = gw.balancerHandler(route)
+	}
+	if route.Aggregate != nil {
+		handler = gw.echoHandler(gw.aggregateHandler(label, route))
+	}
+	if route.GRPCTranscode != nil {
+		handler = gw.echoHandler(gw.grpcTranscodeHandler(label, route))
+	}
+	if route.Static != nil {
+		handler = staticHandler(route)
+	}
+	if route.HeaderLimits != nil {
+		handler = headerLimitHandler(label, route, handler)
+	}
+	if route.LargeBodies != nil {
+		handler = largeBodiesHandler(route, handler)
+	}
+	if route.Coalesce != nil {
+		handler = coalesceHandler(route, handler)
+	}
+	if route.NegativeCache != nil {
+		handler = negativeCacheHandler(route, gw.staleDuringIncident(), handler)
+	}
+	if route.ResponseTransform != nil {
+		handler = responseTransformHandler(label, route, handler)
+	}
+	if route.Redirect != nil {
+		handler = redirectHandler(route, handler)
+	}
+	if route.ClientCert != nil {
+		handler = clientCertHandler(route, handler)
+	}
+	if route.BodySchema != nil {
+		handler = bodySchemaHandler(label, route, handler)
+	}
+	if route.Operation != nil && gw.config.OpenAPI.Validate {
+		handler = openAPIHandler(label, route, gw.config.OpenAPI.MaxBodyBytes, handler)
+	}
+	if route.Allow != "" || len(route.SetHeaders) > 0 {
+		handler = expressionHandler(label, route, handler)
+	}
+	if route.Deprecation != nil {
+		handler = deprecationHandler(route, handler)
+	}
+	handler = gw.withPipeline(label, handler)
+	handler = gw.routeTrafficHandler(label, handler)
+	handler = gw.routeMetricsHandler(label, handler)
+	if route.AccessLog != nil {
+		gw.accessLog.Route(label, *route.AccessLog)
+	}
+
+	r := gw.router.NewRoute().Handler(handler)
+	if route.Name != "" {
+		r.Name(route.Name)
+	}
+	if route.CORS != nil {
+		gw.cors.add(r, *route.CORS)
+	}
+	if route.RateLimitCost > 1 {
+		gw.costs.add(r, route.RateLimitCost)
+	}
+	if route.Host != "" {
+		r.Host(route.Host)
+	}
+	if route.Path != "" {
+		r.Path(route.Path)
+	}
+	if route.PathPrefix != "" {
+		r.PathPrefix(route.PathPrefix)
+	}
+	if route.Glob != "" {
+		r.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+			return pattern.Match(req.URL.Path)
+		})
+	}
+	if len(route.Methods) > 0 {
+		r.Methods(route.Methods...)
+	}
+	if len(route.Headers) > 0 || len(route.Query) > 0 {
+		r.MatcherFunc(conditionsMatcher(route))
+	}
+	if route.When != "" {
+		r.MatcherFunc(whenMatcher(label, route.When))
+	}
+
+	if err := r.GetError(); err != nil {
+		logger.Error("Route %s is invalid and was not registered: %v", route.Name, err)
+	}
 }
 
 func (gw *Gateway) Handler() http.Handler {
-	handler := http.Handler(gw.router)
+	handler := gw.withConnect(gw.router)
 
 	// Apply middlewares in reverse order (last middleware wraps first)
 	for i := len(gw.middlewares) - 1; i >= 0; i-- {
 		handler = gw.middlewares[i].Wrap(handler)
 	}
-
-	return handler
+	handler = gw.withVersions(handler)
+	handler = gw.withEcho(handler)
+
+	next := gw.withDrain(handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordClientProtocol(r.Proto)
+		next.ServeHTTP(w, r)
+	})
 }
 
 func (gw *Gateway) healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -89,26 +544,68 @@ func (gw *Gateway) healthHandler(w http.ResponseWriter, r *http.Request) {
 	gw.mu.RUnlock()
 
 	status := "healthy"
-	if len(backends) == 0 {
+	code := http.StatusOK
+	switch {
+	case gw.Draining():
+		status = "draining"
+		code = http.StatusServiceUnavailable
+	case len(backends) == 0:
 		status = "unhealthy"
-		w.WriteHeader(http.StatusServiceUnavailable)
+		code = http.StatusServiceUnavailable
 	}
 
 	response := fmt.Sprintf(`{"status":"%s","healthy_backends":%d}`, status, len(backends))
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 	w.Write([]byte(response))
 }
 
 func (gw *Gateway) proxyHandler(w http.ResponseWriter, r *http.Request) {
+	gw.proxy(w, r, nil)
+}
+
+// proxy sends r to a backend chosen as balancer says, or by the global
+// load balancing settings when it is nil
+func (gw *Gateway) proxy(w http.ResponseWriter, r *http.Request, balancer *config.BalancerConfig) {
 	start := time.Now()
 
-	backend := gw.loadBalancer.NextBackend()
+	choice := gw.choice(r, balancer)
+	choice.Backends = versionBackends(r)
+	backend := gw.loadBalancer.NextBackendWith(choice)
 	if backend == nil {
 		logger.Error("No healthy backends available")
 		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
 		metrics.RecordRequest(r.Method, "503", "none", time.Since(start))
 		return
 	}
+	if echoing(r) {
+		gw.writeEcho(w, r, backend)
+		return
+	}
+
+	chosen, releaseConnection, ok := gw.acquireConnection(w, r, backend)
+	if !ok {
+		metrics.RecordRequest(r.Method, "503", backend.Name, time.Since(start))
+		return
+	}
+	defer releaseConnection()
+	backend = chosen
+
+	if limiter := gw.bulkheads[backend.Group]; limiter != nil {
+		release, ok := middleware.AcquireSlot(w, r, limiter)
+		if !ok {
+			metrics.RecordRequest(r.Method, "503", backend.Name, time.Since(start))
+			return
+		}
+		defer release()
+	}
+
+	// Past the last queue: a request that waited too long is not worth proxying
+	maxWait := time.Duration(gw.config.RequestAge.MaxQueueTimeMs) * time.Millisecond
+	if middleware.RejectQueuedTooLong(w, r, maxWait) {
+		metrics.RecordRequest(r.Method, "503", backend.Name, time.Since(start))
+		return
+	}
 
 	// Parse backend URL
 	target, err := url.Parse(backend.URL)
@@ -121,6 +618,17 @@ func (gw *Gateway) proxyHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Create reverse proxy
 	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = gw.roundTripper(backend.Name)
+	if pool := copyBuffers(r); pool != nil {
+		proxy.BufferPool = pool
+	}
+	if gw.signer != nil || gw.relay != nil {
+		proxy.ErrorHandler = gw.proxyError
+	}
+	hedge := gw.config.Hedging.Enabled && hedgeable(r)
+	if hedge {
+		proxy.Transport = gw.hedgedTransport(backend, target)
+	}
 
 	// Modify the request
 	r.URL.Host = target.Host
@@ -128,30 +636,68 @@ func (gw *Gateway) proxyHandler(w http.ResponseWriter, r *http.Request) {
 	r.Header.Set("X-Forwarded-Host", r.Header.Get("Host"))
 	r.Host = target.Host
 
+	// Count body bytes in both directions
+	body := traffic.NewCountingReader(r.Body)
+	r.Body = body
+
 	// Create response writer to capture status
 	rw := metrics.NewResponseWriter(w)
 
+	// Upstream time ends when the backend's response headers arrive, so it
+	// does not include streaming the body to a slow client
+	upstreamStart := time.Now()
+	var upstream time.Duration
+	proxy.ModifyResponse = func(*http.Response) error {
+		upstream = time.Since(upstreamStart)
+		return nil
+	}
+
 	// Serve the request
-	proxy.ServeHTTP(rw, r)
+	if hedge {
+		// Each attempt is traced under its own backend
+		proxy.ServeHTTP(rw, r)
+	} else {
+		proxy.ServeHTTP(rw, withUpstreamTrace(r, backend.Name))
+	}
 
 	// Record metrics
 	duration := time.Since(start)
+	if upstream > 0 {
+		metrics.RecordUpstreamDuration(backend.Name, routeName(r), upstream)
+	}
 	metrics.RecordRequest(r.Method, rw.StatusCode(), backend.Name, duration)
 	metrics.RecordBackendRequest(backend.Name, rw.StatusCode())
+	gw.loadBalancer.RecordResponse(backend.Name, duration, rw.Status() >= http.StatusInternalServerError)
+	metrics.RecordBackendBytes(backend.Name, body.Count(), rw.BytesWritten())
+	gw.backendBytes.Add(backend.Name, body.Count(), rw.BytesWritten())
 
 	logger.Debug("Proxied %s %s to %s (status: %s, duration: %v)",
 		r.Method, r.URL.Path, backend.Name, rw.StatusCode(), duration)
 }
 
+// startHealthChecks checks every backend right away, then every interval
+// moved by up to the jitter either way, until Shutdown
 func (gw *Gateway) startHealthChecks() {
-	go func() {
-		ticker := time.NewTicker(30 * time.Second)
-		defer ticker.Stop()
+	cfg := gw.config.HealthCheck
+	if cfg.Interval <= 0 {
+		cfg.Interval = 30
+	}
+	if cfg.Jitter == 0 {
+		cfg.Jitter = 0.1
+	}
+	interval := time.Duration(cfg.Interval) * time.Second
 
+	go func() {
 		for {
+			gw.performHealthChecks()
+
+			wait := interval + time.Duration((rand.Float64()*2-1)*cfg.Jitter*float64(interval))
+			timer := time.NewTimer(wait)
 			select {
-			case <-ticker.C:
-				gw.performHealthChecks()
+			case <-timer.C:
+			case <-gw.checks.Done():
+				timer.Stop()
+				return
 			}
 		}
 	}()
@@ -161,42 +707,95 @@ func (gw *Gateway) performHealthChecks() {
 	gw.mu.Lock()
 	defer gw.mu.Unlock()
 
-	for _, backend := range gw.config.Backends {
+	for _, backend := range config.Instances(gw.config.Backends) {
 		go gw.checkBackendHealth(backend)
 	}
 }
 
+// applyLoadBalancing configures the load balancer
+func (gw *Gateway) applyLoadBalancing(cfg config.LoadBalancingConfig) {
+	failover := cfg.Failover
+	if failover.Window <= 0 {
+		failover.Window = 30
+	}
+	if failover.HoldTime <= 0 {
+		failover.HoldTime = 30
+	}
+	gw.loadBalancer.SetFailover(loadbalancer.Failover{
+		ErrorRate: failover.ErrorRate,
+		Window:    time.Duration(failover.Window) * time.Second,
+		HoldTime:  time.Duration(failover.HoldTime) * time.Second,
+	})
+
+	algorithm := cfg.Algorithm
+	if algorithm == "" {
+		algorithm = "round_robin"
+	}
+	gw.loadBalancer.SetAlgorithm(algorithm)
+	gw.loadBalancer.SetSlowStart(time.Duration(cfg.SlowStart) * time.Second)
+}
+
+// instance returns a healthy instance of the named backend, or its first
+// one when none is healthy, for features that name a backend directly
+func (gw *Gateway) instance(name string) config.Backend {
+	if backend := gw.loadBalancer.NextInGroup(name); backend != nil {
+		return *backend
+	}
+	for _, backend := range gw.config.Backends {
+		if backend.Name == name {
+			return backend.Instances()[0]
+		}
+	}
+	return config.Backend{Name: name, Group: name}
+}
+
 func (gw *Gateway) checkBackendHealth(backend config.Backend) {
 	healthURL := backend.URL + backend.Health
-	
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	timeout := time.Duration(gw.config.HealthCheck.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(gw.checks, timeout)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
 	if err != nil {
 		logger.Error("Failed to create health check request for %s: %v", backend.Name, err)
-		gw.loadBalancer.SetBackendHealth(backend.Name, false)
-		metrics.SetBackendStatus(backend.Name, false)
+		gw.reportHealth(backend.Name, loadbalancer.HealthReport{Healthy: false, Reason: "invalid health check request: " + err.Error()})
 		return
 	}
 
-	client := &http.Client{Timeout: 5 * time.Second}
+	client := &http.Client{Transport: gw.protocols.transport(backend.Name, gw.backendTransport(backend.Name))}
+	start := time.Now()
 	resp, err := client.Do(req)
+	latency := time.Since(start)
 	if err != nil {
+		if gw.checks.Err() != nil {
+			// Shutting down, not a failure of the backend
+			return
+		}
 		logger.Warn("Health check failed for backend %s: %v", backend.Name, err)
-		gw.loadBalancer.SetBackendHealth(backend.Name, false)
-		metrics.SetBackendStatus(backend.Name, false)
+		gw.reportHealth(backend.Name, loadbalancer.HealthReport{Healthy: false, Reason: err.Error(), Latency: latency})
 		return
 	}
 	defer resp.Body.Close()
 
 	isHealthy := resp.StatusCode >= 200 && resp.StatusCode < 300
-	gw.loadBalancer.SetBackendHealth(backend.Name, isHealthy)
-	metrics.SetBackendStatus(backend.Name, isHealthy)
+	gw.reportHealth(backend.Name, loadbalancer.HealthReport{Healthy: isHealthy, Reason: "status " + resp.Status, Latency: latency})
 
 	if isHealthy {
 		logger.Debug("Health check passed for backend %s", backend.Name)
 	} else {
 		logger.Warn("Health check failed for backend %s (status: %d)", backend.Name, resp.StatusCode)
 	}
-}
\ No newline at end of file
+}
+
+func (gw *Gateway) reportHealth(backend string, report loadbalancer.HealthReport) {
+	report.Source = "health_check"
+	changed := gw.loadBalancer.ReportHealth(backend, report)
+	metrics.SetBackendStatus(backend, report.Healthy)
+	if changed {
+		gw.backendChanged(BackendChange{Backend: backend, Healthy: report.Healthy, Reason: report.Reason, Source: report.Source})
+	}
+}
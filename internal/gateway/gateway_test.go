@@ -3,11 +3,23 @@ package gateway
 import (
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/barisgenc/gatekeeper/internal/config"
 )
 
+func TestMain(m *testing.M) {
+	// Tests count and inspect what their backends receive, so health
+	// checks only run where a test turns them on
+	runHealthChecks = false
+	os.Exit(m.Run())
+}
+
 func TestNew(t *testing.T) {
 	cfg := &config.Config{
 		Backends: []config.Backend{
@@ -104,6 +116,167 @@ func TestGatewayHandler(t *testing.T) {
 	}
 }
 
+func TestBackendGroup(t *testing.T) {
+	var hits [2]atomic.Int64
+	servers := make([]config.BackendServer, 2)
+	for i := range servers {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" {
+				if i == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+				return
+			}
+			hits[i].Add(1)
+		}))
+		defer srv.Close()
+		servers[i] = config.BackendServer{URL: srv.URL}
+	}
+
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "api", Health: "/health", Servers: servers}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+	}
+	gw := New(cfg)
+	handler := gw.Handler()
+
+	for i := 0; i < 4; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders", nil))
+	}
+	if hits[0].Load() != 2 || hits[1].Load() != 2 {
+		t.Errorf("Expected both servers to get 2 requests, got %d and %d", hits[0].Load(), hits[1].Load())
+	}
+
+	// Health is checked per server
+	for _, instance := range cfg.Backends[0].Instances() {
+		gw.checkBackendHealth(instance)
+	}
+	for i := 0; i < 4; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders", nil))
+	}
+	if hits[0].Load() != 6 || hits[1].Load() != 2 {
+		t.Errorf("Expected the healthy server to get the next 4 requests, got %d and %d", hits[0].Load(), hits[1].Load())
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+	if expected := `{"status":"healthy","healthy_backends":1}`; rr.Body.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, rr.Body.String())
+	}
+}
+
+func TestLeastResponseTime(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	upstream := func(backend string) http.RoundTripper {
+		return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			mu.Lock()
+			calls[backend]++
+			mu.Unlock()
+			if backend == "slow" {
+				time.Sleep(20 * time.Millisecond)
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: http.Header{}, Request: r}, nil
+		})
+	}
+	cfg := &config.Config{
+		Backends: []config.Backend{
+			{Name: "slow", URL: "http://slow.internal"},
+			{Name: "fast", URL: "http://fast.internal"},
+		},
+		LoadBalancing: config.LoadBalancingConfig{Algorithm: "least_response_time"},
+		RateLimit:     config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+	}
+	handler := NewWithUpstream(cfg, upstream).Handler()
+
+	// Both are measured once, then the faster one gets the traffic
+	for i := 0; i < 10; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders", nil))
+	}
+	if calls["slow"] != 1 || calls["fast"] != 9 {
+		t.Errorf("Expected slow 1 and fast 9 requests, got %v", calls)
+	}
+}
+
+func TestRouteLoadBalancing(t *testing.T) {
+	var mu sync.Mutex
+	var served []string
+	upstream := func(backend string) http.RoundTripper {
+		return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			mu.Lock()
+			served = append(served, backend)
+			mu.Unlock()
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: http.Header{}, Request: r}, nil
+		})
+	}
+	cfg := &config.Config{
+		Backends: []config.Backend{
+			{Name: "one", URL: "http://one.internal"},
+			{Name: "two", URL: "http://two.internal"},
+		},
+		Routes: []config.RouteConfig{
+			{Name: "carts", PathPrefix: "/carts", LoadBalancing: &config.BalancerConfig{Algorithm: "hash", HashKey: "header:X-User"}},
+		},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+	}
+	handler := NewWithUpstream(cfg, upstream).Handler()
+
+	serve := func(path, user string) string {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-User", user)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return served[len(served)-1]
+	}
+
+	// The carts route hashes on the user, other paths are round robin
+	for _, user := range []string{"alice", "bob", "carol"} {
+		first := serve("/carts", user)
+		for i := 0; i < 3; i++ {
+			if got := serve("/carts", user); got != first {
+				t.Errorf("Expected %s to stay on %s, got %s", user, first, got)
+			}
+		}
+	}
+	if serve("/orders", "alice") == serve("/orders", "alice") {
+		t.Error("Expected /orders to alternate between backends")
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	upstream := func(backend string) http.RoundTripper {
+		return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: http.Header{}, Request: r}, nil
+		})
+	}
+	path := filepath.Join(t.TempDir(), "access.log")
+	cfg := &config.Config{
+		Backends: []config.Backend{{Name: "api", URL: "http://api.internal"}},
+		Routes: []config.RouteConfig{
+			{Name: "orders", PathPrefix: "/orders"},
+			{Name: "ping", Path: "/ping", AccessLog: &config.RouteAccessLogConfig{Disabled: true}},
+		},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		AccessLog: config.AccessLogConfig{Fields: []string{"route", "path", "status"}, Output: path},
+	}
+	gw := NewWithUpstream(cfg, upstream)
+	handler := gw.Handler()
+	for _, p := range []string{"/orders/1", "/ping", "/other"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+	gw.accessLog.Close()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"path":"/orders/1","route":"orders","status":200}` + "\n" +
+		`{"path":"/other","route":"default","status":200}` + "\n"
+	if string(got) != want {
+		t.Errorf("Expected lines for orders and the catch-all but not ping, got:\n%s", got)
+	}
+}
+
 func TestRateLimiting(t *testing.T) {
 	cfg := &config.Config{
 		Backends: []config.Backend{
@@ -130,6 +303,38 @@ func TestRateLimiting(t *testing.T) {
 	}
 }
 
+func TestRateLimitCost(t *testing.T) {
+	cfg := &config.Config{
+		Backends: []config.Backend{
+			{Name: "test", URL: "http://localhost:3000", Weight: 100, Health: "/health"},
+		},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1, BurstSize: 10},
+		Routes:    []config.RouteConfig{{Name: "search", PathPrefix: "/search", RateLimitCost: 4}},
+	}
+
+	gw := New(cfg)
+	handler := gw.Handler()
+	serve := func(path string) int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr.Code
+	}
+
+	// Two searches take 8 of the 10 tokens; a third does not fit, while
+	// cheap requests still do
+	for i := 0; i < 2; i++ {
+		if code := serve("/search?q=x"); code == http.StatusTooManyRequests {
+			t.Fatalf("Expected search %d to pass, got %d", i+1, code)
+		}
+	}
+	if code := serve("/search?q=x"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the third search to be limited, got %d", code)
+	}
+	if code := serve("/items"); code == http.StatusTooManyRequests {
+		t.Errorf("Expected a one-token request to pass, got %d", code)
+	}
+}
+
 // Benchmark tests
 func BenchmarkGatewayHandler(b *testing.B) {
 	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -175,4 +380,28 @@ func BenchmarkHealthCheck(b *testing.B) {
 		rr := httptest.NewRecorder()
 		handler.ServeHTTP(rr, req)
 	}
-}
\ No newline at end of file
+}
+func TestGlobRoutesBySpecificity(t *testing.T) {
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "test", URL: "http://localhost:3000", Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Routes: []config.RouteConfig{
+			{Name: "files", Glob: "/files/**", Redirect: &config.RedirectConfig{To: "/all-files", Status: 302}},
+			{Name: "raw", Glob: "/files/*/raw", Redirect: &config.RedirectConfig{To: "/raw-file", Status: 302}},
+		},
+	}
+	gw := New(cfg)
+
+	testCases := map[string]string{
+		"/files/report/raw": "/raw-file",
+		"/files/report":     "/all-files",
+		"/files/a/b/raw":    "/all-files",
+	}
+	for path, location := range testCases {
+		rr := httptest.NewRecorder()
+		gw.router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if got := rr.Header().Get("Location"); got != location {
+			t.Errorf("%s: expected redirect to %s, got %q", path, location, got)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/grpcclient"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/transcode"
+)
+
+// grpcBinding is a compiled config.GRPCBinding
+type grpcBinding struct {
+	httpMethod string
+	template   *transcode.Template
+	method     *transcode.Method
+}
+
+// grpcTranscodeHandler answers the route by calling a unary gRPC method on
+// the route's backend with the JSON request, and answers with the JSON of
+// the response message
+func (gw *Gateway) grpcTranscodeHandler(label string, route config.RouteConfig) http.Handler {
+	cfg := *route.GRPCTranscode
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+
+	set, bindings, err := compileGRPCTranscode(cfg)
+	if err != nil {
+		logger.Error("Route %s gRPC transcoding misconfigured, rejecting all requests: %v", label, err)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		})
+	}
+
+	clients := make(map[string]*grpcclient.Client)
+	for _, backend := range gw.config.Backends {
+		if backend.Name != cfg.Backend {
+			continue
+		}
+		for _, instance := range backend.Instances() {
+			clients[instance.Name] = grpcclient.NewWithTransport(instance.URL, gw.roundTripper(instance.Name), time.Duration(cfg.Timeout)*time.Second)
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, params, status := resolveGRPCMethod(set, bindings, r)
+		if method == nil {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		for name, values := range r.URL.Query() {
+			for _, value := range values {
+				if _, bound := params[name]; !bound {
+					params[name] = value
+				}
+			}
+		}
+
+		var body []byte
+		if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+			body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, cfg.MaxBodyBytes))
+			if err != nil {
+				http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+				return
+			}
+		}
+		message, err := method.EncodeRequest(body, params)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"code": 3, "message": err.Error()})
+			return
+		}
+
+		instance := gw.instance(cfg.Backend).Name
+		resp, err := clients[instance].InvokeWithMetadata(withUpstreamTrace(r, instance).Context(), method.Path(), message, grpcMetadata(r))
+		if err != nil {
+			code := 14 // UNAVAILABLE
+			var status *grpcclient.Status
+			switch {
+			case errors.As(err, &status):
+				code = status.Code
+			case errors.Is(err, context.DeadlineExceeded):
+				code = 4
+			default:
+				logger.Warn("gRPC call %s on route %s failed: %v", method.Name(), label, err)
+			}
+			metrics.RecordGRPCTranscode(label, method.Name(), strconv.Itoa(code))
+			text := err.Error()
+			if status != nil {
+				text = status.Message
+			}
+			writeJSON(w, transcode.HTTPStatus(code), map[string]interface{}{"code": code, "message": text})
+			return
+		}
+		metrics.RecordGRPCTranscode(label, method.Name(), "0")
+
+		out, err := method.DecodeResponse(resp)
+		if err != nil {
+			logger.Warn("gRPC call %s on route %s returned an invalid message: %v", method.Name(), label, err)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+	})
+}
+
+func compileGRPCTranscode(cfg config.GRPCTranscodeConfig) (*transcode.Set, []grpcBinding, error) {
+	set, err := transcode.Load(cfg.DescriptorSet)
+	if err != nil {
+		return nil, nil, err
+	}
+	bindings := make([]grpcBinding, 0, len(cfg.Bindings))
+	for _, b := range cfg.Bindings {
+		template, err := transcode.ParseTemplate(b.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		method, err := set.Method(b.RPC)
+		if err != nil {
+			return nil, nil, err
+		}
+		httpMethod := b.Method
+		if httpMethod == "" {
+			httpMethod = http.MethodPost
+		}
+		bindings = append(bindings, grpcBinding{httpMethod: httpMethod, template: template, method: method})
+	}
+	return set, bindings, nil
+}
+
+// resolveGRPCMethod finds the method a request is for and the fields its
+// path binds, or the status to answer with when there is none
+func resolveGRPCMethod(set *transcode.Set, bindings []grpcBinding, r *http.Request) (*transcode.Method, map[string]string, int) {
+	if len(bindings) == 0 {
+		if r.Method != http.MethodPost {
+			return nil, nil, http.StatusMethodNotAllowed
+		}
+		segments := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+		if len(segments) < 2 {
+			return nil, nil, http.StatusNotFound
+		}
+		method, err := set.Method(strings.Join(segments[len(segments)-2:], "/"))
+		if err != nil {
+			return nil, nil, http.StatusNotFound
+		}
+		return method, make(map[string]string), 0
+	}
+
+	status := http.StatusNotFound
+	for _, b := range bindings {
+		params, ok := b.template.Match(r.URL.Path)
+		if !ok {
+			continue
+		}
+		if b.httpMethod != r.Method {
+			status = http.StatusMethodNotAllowed
+			continue
+		}
+		return b.method, params, 0
+	}
+	return nil, nil, status
+}
+
+// grpcMetadata picks the request headers passed to the gRPC backend:
+// Authorization, X-Request-ID and any Grpc-Metadata-<name> header, which
+// is sent as <name>
+func grpcMetadata(r *http.Request) http.Header {
+	md := make(http.Header)
+	for _, name := range []string{"Authorization", "X-Request-Id"} {
+		if values := r.Header.Values(name); len(values) > 0 {
+			md[name] = values
+		}
+	}
+	for name, values := range r.Header {
+		if key := strings.TrimPrefix(name, "Grpc-Metadata-"); key != name && key != "" {
+			md[key] = values
+		}
+	}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		md.Set("X-Forwarded-For", ip)
+	}
+	return md
+}
@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+const bookDescriptor = `
+file {
+  name: "library.proto"
+  package: "library"
+  syntax: "proto3"
+  message_type {
+    name: "Book"
+    field { name: "id" number: 1 type: TYPE_INT64 label: LABEL_OPTIONAL json_name: "id" }
+    field { name: "title" number: 2 type: TYPE_STRING label: LABEL_OPTIONAL json_name: "title" }
+  }
+  service {
+    name: "Library"
+    method { name: "GetBook" input_type: ".library.Book" output_type: ".library.Book" }
+  }
+}`
+
+func TestGRPCTranscodeRoutes(t *testing.T) {
+	var fds descriptorpb.FileDescriptorSet
+	if err := prototext.Unmarshal([]byte(bookDescriptor), &fds); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := proto.Marshal(&fds)
+	descriptorSet := filepath.Join(t.TempDir(), "library.pb")
+	if err := os.WriteFile(descriptorSet, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := protodesc.NewFiles(&fds)
+	bookType, _ := files.FindDescriptorByName("library.Book")
+
+	// Answers GetBook with the title "Book <id>", or NOT_FOUND for id 404
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.URL.Path != "/library.Library/GetBook" {
+			t.Errorf("Expected an HTTP/2 call to GetBook, got %s %s", r.Proto, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer t" || r.Header.Get("Tenant") != "acme" {
+			t.Errorf("Expected metadata to be passed on, got %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		book := dynamicpb.NewMessage(bookType.(protoreflect.MessageDescriptor))
+		proto.Unmarshal(body[5:], book)
+		id := book.Get(book.Descriptor().Fields().ByName("id")).Int()
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		if id == 404 {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "no such book")
+			return
+		}
+		book.Set(book.Descriptor().Fields().ByName("title"), protoreflect.ValueOfString("Book "+strconv.FormatInt(id, 10)))
+		message, _ := proto.Marshal(book)
+		frame := make([]byte, 5+len(message))
+		binary.BigEndian.PutUint32(frame[1:5], uint32(len(message)))
+		copy(frame[5:], message)
+		w.Write(frame)
+		w.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "library", URL: backend.URL, Protocol: "grpc"}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Routes: []config.RouteConfig{
+			{Name: "rpc", PathPrefix: "/rpc/", GRPCTranscode: &config.GRPCTranscodeConfig{Backend: "library", DescriptorSet: descriptorSet}},
+			{Name: "books", PathPrefix: "/v1/books/", GRPCTranscode: &config.GRPCTranscodeConfig{
+				Backend:       "library",
+				DescriptorSet: descriptorSet,
+				Bindings:      []config.GRPCBinding{{Method: "GET", Path: "/v1/books/{id}", RPC: "library.Library/GetBook"}},
+			}},
+		},
+	}
+	handler := New(cfg).Handler()
+
+	testCases := []struct {
+		name           string
+		method         string
+		url            string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"path binding", "GET", "/v1/books/7", "", http.StatusOK, `{"id":"7","title":"Book 7"}`},
+		{"query sets fields", "GET", "/v1/books/8?title=ignored", "", http.StatusOK, `{"id":"8","title":"Book 8"}`},
+		{"gRPC error", "GET", "/v1/books/404", "", http.StatusNotFound, `{"code":5,"message":"no such book"}`},
+		{"invalid field", "GET", "/v1/books/seven", "", http.StatusBadRequest, ""},
+		{"wrong method", "POST", "/v1/books/7", "", http.StatusMethodNotAllowed, ""},
+		{"unbound path", "GET", "/v1/books/7/pages", "", http.StatusNotFound, ""},
+		{"default binding", "POST", "/rpc/library.Library/GetBook", `{"id": 9}`, http.StatusOK, `{"id":"9","title":"Book 9"}`},
+		{"unknown method", "POST", "/rpc/library.Library/DeleteBook", `{}`, http.StatusNotFound, ""},
+		{"invalid JSON", "POST", "/rpc/library.Library/GetBook", `{"id":`, http.StatusBadRequest, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			req.Header.Set("Authorization", "Bearer t")
+			req.Header.Set("Grpc-Metadata-Tenant", "acme")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tc.expectedBody == "" {
+				return
+			}
+			var got, expected interface{}
+			json.Unmarshal(rr.Body.Bytes(), &got)
+			json.Unmarshal([]byte(tc.expectedBody), &expected)
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("Expected body %s, got %s", tc.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
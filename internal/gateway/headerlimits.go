@@ -0,0 +1,162 @@
+package gateway
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// essentialHeaders are never dropped to fit a header limit
+var essentialHeaders = []string{
+	"Authorization", "Cookie", "Set-Cookie", "Location", "Www-Authenticate",
+	"Content-Type", "Content-Length", "Content-Encoding", "Content-Range",
+	"Transfer-Encoding", "Trailer",
+}
+
+// headerLimit trims a header map to a size
+type headerLimit struct {
+	max      int
+	critical map[string]bool
+}
+
+// trim drops the largest headers that are not critical until h fits, and
+// returns their names. It reports false if the critical headers alone do
+// not fit; h then keeps only those.
+func (l headerLimit) trim(h http.Header) ([]string, bool) {
+	sizes := make(map[string]int, len(h))
+	total := 0
+	for name, values := range h {
+		for _, value := range values {
+			sizes[name] += len(name) + len(value) + 4
+		}
+		total += sizes[name]
+	}
+	if total <= l.max {
+		return nil, true
+	}
+
+	droppable := make([]string, 0, len(h))
+	for name := range h {
+		if !l.critical[name] {
+			droppable = append(droppable, name)
+		}
+	}
+	sort.Slice(droppable, func(i, j int) bool {
+		if sizes[droppable[i]] != sizes[droppable[j]] {
+			return sizes[droppable[i]] > sizes[droppable[j]]
+		}
+		return droppable[i] < droppable[j]
+	})
+
+	var dropped []string
+	for _, name := range droppable {
+		if total <= l.max {
+			break
+		}
+		delete(h, name)
+		total -= sizes[name]
+		dropped = append(dropped, name)
+	}
+	return dropped, total <= l.max
+}
+
+// headerLimitHandler keeps the headers the route forwards in both
+// directions under its caps
+func headerLimitHandler(label string, route config.RouteConfig, next http.Handler) http.Handler {
+	cfg := *route.HeaderLimits
+	critical := make(map[string]bool)
+	for _, name := range append(essentialHeaders, cfg.Critical...) {
+		critical[http.CanonicalHeaderKey(name)] = true
+	}
+	request := headerLimit{max: cfg.MaxRequestBytes, critical: critical}
+	response := headerLimit{max: cfg.MaxResponseBytes, critical: critical}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if request.max > 0 {
+			dropped, ok := request.trim(r.Header)
+			recordDroppedHeaders(label, "request", dropped)
+			if !ok {
+				logger.Warn("Request %s %s on route %s has more critical headers than maxRequestBytes allows", r.Method, r.URL.Path, label)
+				http.Error(w, "Request Header Fields Too Large", http.StatusRequestHeaderFieldsTooLarge)
+				return
+			}
+		}
+		if response.max > 0 {
+			w = &headerLimitWriter{ResponseWriter: w, label: label, r: r, limit: response}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func recordDroppedHeaders(label, direction string, dropped []string) {
+	if len(dropped) == 0 {
+		return
+	}
+	metrics.RecordHeadersDropped(label, direction, len(dropped))
+	logger.Debug("Route %s dropped oversized %s headers: %s", label, direction, strings.Join(dropped, ", "))
+}
+
+// headerLimitWriter trims response headers as they are sent
+type headerLimitWriter struct {
+	http.ResponseWriter
+	label       string
+	r           *http.Request
+	limit       headerLimit
+	wroteHeader bool
+	failed      bool
+}
+
+func (hw *headerLimitWriter) WriteHeader(status int) {
+	if hw.wroteHeader {
+		return
+	}
+	// Informational responses such as 103 Early Hints pass as they are
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		hw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	hw.wroteHeader = true
+
+	header := hw.Header()
+	dropped, ok := hw.limit.trim(header)
+	recordDroppedHeaders(hw.label, "response", dropped)
+	if !ok {
+		logger.Warn("Response for %s %s on route %s has more critical headers than maxResponseBytes allows", hw.r.Method, hw.r.URL.Path, hw.label)
+		hw.failed = true
+		for name := range header {
+			delete(header, name)
+		}
+		http.Error(hw.ResponseWriter, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	hw.ResponseWriter.WriteHeader(status)
+}
+
+func (hw *headerLimitWriter) Write(b []byte) (int, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	// The backend's body is discarded after a 502
+	if hw.failed {
+		return len(b), nil
+	}
+	return hw.ResponseWriter.Write(b)
+}
+
+func (hw *headerLimitWriter) Flush() {
+	if !hw.failed {
+		if !hw.wroteHeader {
+			hw.WriteHeader(http.StatusOK)
+		}
+		http.NewResponseController(hw.ResponseWriter).Flush()
+	}
+}
+
+// Unwrap lets upgraded connections reach the underlying connection
+func (hw *headerLimitWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestHeaderLimits(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Debug", strconv.FormatBool(r.Header.Get("X-Debug-Context") != ""))
+		w.Header().Set("X-Seen-Auth", strconv.FormatBool(r.Header.Get("Authorization") != ""))
+		w.Header().Set("X-Seen-Small", strconv.FormatBool(r.Header.Get("X-Small") != ""))
+		if r.URL.Query().Has("debug") {
+			w.Header().Set("X-Debug-Trace", strings.Repeat("d", 4000))
+		}
+		if r.URL.Query().Has("cookie") {
+			w.Header().Set("Set-Cookie", "session="+strings.Repeat("c", 4000))
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	limits := &config.HeaderLimitsConfig{MaxRequestBytes: 1024, MaxResponseBytes: 1024, Critical: []string{"x-small"}}
+	handler := New(&config.Config{
+		Backends:  []config.Backend{{Name: "backend", URL: backend.URL, Weight: 1, Health: "/health"}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Routes:    []config.RouteConfig{{Name: "limited", PathPrefix: "/", HeaderLimits: limits}},
+	}).Handler()
+
+	testCases := []struct {
+		name           string
+		url            string
+		headers        map[string]string
+		expectedStatus int
+		expected       map[string]string
+	}{
+		{
+			name:           "headers under the cap pass",
+			url:            "/",
+			headers:        map[string]string{"Authorization": "Bearer t", "X-Debug-Context": "short", "X-Small": "1"},
+			expectedStatus: http.StatusOK,
+			expected:       map[string]string{"X-Seen-Debug": "true", "X-Seen-Auth": "true", "X-Seen-Small": "true"},
+		},
+		{
+			name:           "large request header is dropped",
+			url:            "/",
+			headers:        map[string]string{"Authorization": "Bearer t", "X-Debug-Context": strings.Repeat("x", 2000), "X-Small": "1"},
+			expectedStatus: http.StatusOK,
+			expected:       map[string]string{"X-Seen-Debug": "false", "X-Seen-Auth": "true", "X-Seen-Small": "true"},
+		},
+		{
+			name:           "critical request headers over the cap",
+			url:            "/",
+			headers:        map[string]string{"Authorization": "Bearer " + strings.Repeat("t", 2000)},
+			expectedStatus: http.StatusRequestHeaderFieldsTooLarge,
+		},
+		{
+			name:           "large response header is dropped",
+			url:            "/?debug",
+			expectedStatus: http.StatusOK,
+			expected:       map[string]string{"X-Debug-Trace": "", "X-Seen-Auth": "false"},
+		},
+		{
+			name:           "critical response headers over the cap",
+			url:            "/?cookie",
+			expectedStatus: http.StatusBadGateway,
+			expected:       map[string]string{"Set-Cookie": "", "X-Seen-Auth": ""},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil)
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, rr.Code)
+			}
+			for name, value := range tc.expected {
+				if got := rr.Header().Get(name); got != value {
+					t.Errorf("Expected %s %q, got %q", name, value, got)
+				}
+			}
+		})
+	}
+}
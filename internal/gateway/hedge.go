@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// hedgeable reports whether a request is safe to send twice: an idempotent
+// method with no body to replay
+func hedgeable(r *http.Request) bool {
+	return (r.Method == http.MethodGet || r.Method == http.MethodHead) && r.ContentLength == 0
+}
+
+type hedgeAttempt struct {
+	resp  *http.Response
+	err   error
+	hedge bool
+}
+
+// hedgedTransport sends the request to primary and, if no response has
+// arrived after the hedging delay, a copy to another healthy backend. The
+// first response wins; the other attempt is cancelled.
+func (gw *Gateway) hedgedTransport(primary *config.Backend, primaryTarget *url.URL) http.RoundTripper {
+	delay := time.Duration(gw.config.Hedging.DelayMs) * time.Millisecond
+	if delay <= 0 {
+		delay = 100 * time.Millisecond
+	}
+
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		attempts := make(chan hedgeAttempt, 2)
+		primaryCtx, cancelPrimary := context.WithCancel(req.Context())
+		hedgeCtx, cancelHedge := context.WithCancel(req.Context())
+
+		send := func(req *http.Request, backend string, hedge bool) {
+			resp, err := gw.roundTripper(backend).RoundTrip(withUpstreamTrace(req, backend))
+			attempts <- hedgeAttempt{resp: resp, err: err, hedge: hedge}
+		}
+
+		go send(req.WithContext(primaryCtx), primary.Name, false)
+		pending, hedged := 1, false
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		var first hedgeAttempt
+		select {
+		case first = <-attempts:
+		case <-timer.C:
+			if secondary := gw.loadBalancer.NextBackendExcept(primary.Name, versionBackends(req)...); secondary != nil {
+				if clone, err := retarget(req.WithContext(hedgeCtx), primaryTarget, secondary.URL); err == nil {
+					logger.Debug("Hedging %s %s to %s after %v", req.Method, req.URL.Path, secondary.Name, delay)
+					go send(clone, secondary.Name, true)
+					pending, hedged = 2, true
+				}
+			}
+			first = <-attempts
+		}
+		pending--
+
+		// A failed attempt gives way to the other one if there is one
+		if first.err != nil && pending > 0 {
+			first = <-attempts
+			pending--
+		}
+
+		winnerCancel, loserCancel := cancelPrimary, cancelHedge
+		if first.hedge {
+			winnerCancel, loserCancel = cancelHedge, cancelPrimary
+		}
+		loserCancel()
+		if pending > 0 {
+			go discard(attempts)
+		}
+
+		if hedged {
+			winner := "primary"
+			if first.hedge {
+				winner = "hedge"
+			}
+			metrics.RecordHedgedRequest(winner)
+		}
+
+		if first.err != nil {
+			winnerCancel()
+			return nil, first.err
+		}
+		first.resp.Body = &cancelOnClose{ReadCloser: first.resp.Body, cancel: winnerCancel}
+		return first.resp, nil
+	})
+}
+
+// discard releases the losing attempt's connection once it gives up
+func discard(attempts <-chan hedgeAttempt) {
+	loser := <-attempts
+	if loser.resp != nil {
+		loser.resp.Body.Close()
+	}
+}
+
+// retarget copies an outgoing request from the primary backend onto another
+// backend's URL
+func retarget(req *http.Request, primaryTarget *url.URL, backendURL string) (*http.Request, error) {
+	target, err := url.Parse(backendURL)
+	if err != nil {
+		return nil, err
+	}
+
+	out := req.Clone(req.Context())
+	requestPath := strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(primaryTarget.Path, "/"))
+	out.URL.Scheme = target.Scheme
+	out.URL.Host = target.Host
+	out.URL.Path = strings.TrimSuffix(target.Path, "/") + requestPath
+	out.URL.RawPath = ""
+	out.Host = target.Host
+	return out, nil
+}
+
+// cancelOnClose keeps the winning attempt's context alive until the proxy
+// has finished reading its body
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
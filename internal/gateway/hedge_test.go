@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestHedgedTransport(t *testing.T) {
+	cancelled := make(chan struct{}, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+			w.Write([]byte("slow"))
+		case <-r.Context().Done():
+			cancelled <- struct{}{}
+		}
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast " + r.URL.Path))
+	}))
+	defer fast.Close()
+
+	gw := New(&config.Config{
+		Backends: []config.Backend{
+			{Name: "slow", URL: slow.URL, Weight: 1},
+			{Name: "fast", URL: fast.URL, Weight: 1},
+		},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100},
+		Hedging:   config.HedgingConfig{Enabled: true, DelayMs: 20},
+	})
+
+	target, _ := url.Parse(slow.URL)
+	transport := gw.hedgedTransport(&gw.config.Backends[0], target)
+
+	start := time.Now()
+	req, _ := http.NewRequest("GET", slow.URL+"/items/1", nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "fast /items/1" {
+		t.Fatalf("Expected the hedged backend to answer, got %q", body)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Expected the hedge to beat the slow backend")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("Expected the losing request to be cancelled")
+	}
+}
+
+func TestHedgeable(t *testing.T) {
+	if !hedgeable(httptest.NewRequest("GET", "/items", nil)) {
+		t.Error("Expected a GET without a body to be hedged")
+	}
+	if hedgeable(httptest.NewRequest("POST", "/items", strings.NewReader("{}"))) {
+		t.Error("Expected a POST not to be hedged")
+	}
+}
@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// acmeToken matches the base64url tokens ACME servers issue
+var acmeToken = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// NewHTTPSRedirect returns the handler for the plain HTTP listener: ACME
+// challenge files are served as-is and everything else is redirected to the
+// same host, path and query over HTTPS.
+func NewHTTPSRedirect(cfg config.HTTPRedirectConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.ACMEChallengeDir != "" && strings.HasPrefix(r.URL.Path, acmeChallengePrefix) {
+			token := strings.TrimPrefix(r.URL.Path, acmeChallengePrefix)
+			if !acmeToken.MatchString(token) {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			http.ServeFile(w, r, filepath.Join(cfg.ACMEChallengeDir, token))
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(host, ":") {
+			// IPv6 literal
+			host = "[" + host + "]"
+		}
+		if cfg.HTTPSPort != 0 && cfg.HTTPSPort != 443 {
+			host += ":" + strconv.Itoa(cfg.HTTPSPort)
+		}
+
+		status := http.StatusMovedPermanently
+		if r.Method != "GET" && r.Method != "HEAD" {
+			// 308 keeps the method and body
+			status = http.StatusPermanentRedirect
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tok-123_abc"), []byte("tok-123_abc.keyauth"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHTTPSRedirect(config.HTTPRedirectConfig{ACMEChallengeDir: dir, HTTPSPort: 8443})
+
+	testCases := []struct {
+		name             string
+		method           string
+		url              string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"get", "GET", "http://example.com/a/b?c=d", http.StatusMovedPermanently, "https://example.com:8443/a/b?c=d"},
+		{"host with port", "GET", "http://example.com:8080/", http.StatusMovedPermanently, "https://example.com:8443/"},
+		{"post keeps method", "POST", "http://example.com/form", http.StatusPermanentRedirect, "https://example.com:8443/form"},
+		{"acme challenge", "GET", "http://example.com/.well-known/acme-challenge/tok-123_abc", http.StatusOK, ""},
+		{"acme traversal", "GET", "http://example.com/.well-known/acme-challenge/..%2fsecret", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.url, nil))
+
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status %v, got %v", tc.expectedStatus, rr.Code)
+			}
+			if got := rr.Header().Get("Location"); got != tc.expectedLocation {
+				t.Errorf("Expected Location %q, got %q", tc.expectedLocation, got)
+			}
+		})
+	}
+}
+
+func TestHTTPSRedirectDefaultPort(t *testing.T) {
+	handler := NewHTTPSRedirect(config.HTTPRedirectConfig{})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com/x", nil))
+	if got := rr.Header().Get("Location"); got != "https://example.com/x" {
+		t.Errorf("Expected redirect without port, got %q", got)
+	}
+}
+
+func TestHSTSOnRejections(t *testing.T) {
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			TLS:  config.TLSConfig{CertFile: "server.pem", KeyFile: "server-key.pem"},
+			HSTS: config.HSTSConfig{MaxAge: 31536000},
+		},
+		Backends:  []config.Backend{{Name: "test", URL: "http://localhost:3000", Weight: 100, Health: "/health"}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1, BurstSize: 1},
+	}
+	handler := New(cfg).Handler()
+
+	// The rate limiter's 429 is sent over TLS with HSTS like any response
+	var rr *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "https://example.com/test", nil)
+		req.TLS = &tls.ConnectionState{}
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+	}
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the second request to be rate limited, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Strict-Transport-Security"); got != "max-age=31536000" {
+		t.Errorf("Expected HSTS on the 429, got %q", got)
+	}
+}
@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// Splicing or sendfile would need the backend socket itself as the source,
+// but proxied bodies arrive through net/http's HTTP/1.1, HTTP/2 and TLS
+// readers. The experimental path instead copies with large pooled buffers:
+// with an empty bufio buffer, net/http writes them straight to the client
+// connection, so one read and one write move each buffer.
+
+// copyBufferKey carries a route's buffer pool to the proxy
+type copyBufferKey struct{}
+
+// bufferPool implements httputil.BufferPool
+type bufferPool struct {
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	return &bufferPool{pool: sync.Pool{New: func() interface{} {
+		b := make([]byte, size)
+		return &b
+	}}}
+}
+
+func (p *bufferPool) Get() []byte {
+	return *p.pool.Get().(*[]byte)
+}
+
+func (p *bufferPool) Put(b []byte) {
+	p.pool.Put(&b)
+}
+
+// largeBodiesHandler has the proxy copy the route's bodies with large
+// buffers
+func largeBodiesHandler(route config.RouteConfig, next http.Handler) http.Handler {
+	size := route.LargeBodies.BufferSize
+	if size <= 0 {
+		size = 256 << 10
+	}
+	pool := newBufferPool(size)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), copyBufferKey{}, pool)))
+	})
+}
+
+// copyBuffers returns the buffer pool for the request's route, or nil
+func copyBuffers(r *http.Request) *bufferPool {
+	pool, _ := r.Context().Value(copyBufferKey{}).(*bufferPool)
+	return pool
+}
@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func largeBodyGateway(tb testing.TB, body []byte, largeBodies *config.LargeBodiesConfig) (*httptest.Server, func()) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write(body)
+	}))
+	gw := New(&config.Config{
+		Backends:  []config.Backend{{Name: "media", URL: backend.URL, Weight: 1, Health: "/health", Protocol: "http1"}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1 << 20, BurstSize: 1 << 20},
+		Routes:    []config.RouteConfig{{Name: "media", PathPrefix: "/media/", LargeBodies: largeBodies}},
+	})
+	server := httptest.NewServer(gw.Handler())
+	return server, func() {
+		server.Close()
+		backend.Close()
+	}
+}
+
+func TestLargeBodies(t *testing.T) {
+	body := make([]byte, 3<<20+17)
+	rand.Read(body)
+
+	for _, largeBodies := range []*config.LargeBodiesConfig{nil, {}, {BufferSize: 4 << 10}} {
+		server, stop := largeBodyGateway(t, body, largeBodies)
+
+		resp, err := http.Get(server.URL + "/media/clip.mp4")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !bytes.Equal(got, body) {
+			t.Errorf("Expected the %d byte body intact with largeBodies %+v, got %d bytes", len(body), largeBodies, len(got))
+		}
+		stop()
+	}
+}
+
+func TestLargeBodiesBufferPool(t *testing.T) {
+	var pool *bufferPool
+	handler := largeBodiesHandler(config.RouteConfig{LargeBodies: &config.LargeBodiesConfig{BufferSize: 64 << 10}},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pool = copyBuffers(r)
+		}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/media/clip.mp4", nil))
+
+	if pool == nil {
+		t.Fatal("Expected the route's buffer pool to reach the proxy")
+	}
+	if buf := pool.Get(); len(buf) != 64<<10 {
+		t.Errorf("Expected 64KB buffers, got %d bytes", len(buf))
+	}
+	if copyBuffers(httptest.NewRequest("GET", "/", nil)) != nil {
+		t.Error("Expected no buffer pool outside largeBodies routes")
+	}
+}
+
+// BenchmarkLargeBodies compares the default copy path with largeBodies on
+// a 64MB download over loopback; run with -benchmem and compare MB/s
+func BenchmarkLargeBodies(b *testing.B) {
+	body := make([]byte, 64<<20)
+	for _, bc := range []struct {
+		name        string
+		largeBodies *config.LargeBodiesConfig
+	}{
+		{"default", nil},
+		{"256KB", &config.LargeBodiesConfig{}},
+		{"1MB", &config.LargeBodiesConfig{BufferSize: 1 << 20}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			server, stop := largeBodyGateway(b, body, bc.largeBodies)
+			defer stop()
+
+			b.SetBytes(int64(len(body)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := http.Get(server.URL + "/media/clip.mp4")
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+)
+
+// BackendChange is passed to OnBackendChange hooks when a backend's health
+// flips
+type BackendChange struct {
+	Backend string
+	Healthy bool
+	Reason  string
+	Source  string
+}
+
+// lifecycle holds the hooks embedding applications register. Hooks run in
+// registration order on the goroutine that caused the event, so they
+// should not block for long.
+type lifecycle struct {
+	mu               sync.Mutex
+	configLoaded     []func(*config.Config) error
+	reload           []func(*config.Config)
+	backendChange    []func(BackendChange)
+	shutdownStart    []func(context.Context)
+	shutdownComplete []func(error)
+}
+
+// OnConfigLoaded registers fn to check every config passed to Reload before
+// any of it is applied. An error rejects the reload. The config the gateway
+// was created with is not passed to it.
+func (gw *Gateway) OnConfigLoaded(fn func(*config.Config) error) {
+	gw.hooks.mu.Lock()
+	defer gw.hooks.mu.Unlock()
+	gw.hooks.configLoaded = append(gw.hooks.configLoaded, fn)
+}
+
+// OnReload registers fn to run after Reload has applied a config
+func (gw *Gateway) OnReload(fn func(*config.Config)) {
+	gw.hooks.mu.Lock()
+	defer gw.hooks.mu.Unlock()
+	gw.hooks.reload = append(gw.hooks.reload, fn)
+}
+
+// OnBackendChange registers fn to run when a backend becomes healthy or
+// unhealthy
+func (gw *Gateway) OnBackendChange(fn func(BackendChange)) {
+	gw.hooks.mu.Lock()
+	defer gw.hooks.mu.Unlock()
+	gw.hooks.backendChange = append(gw.hooks.backendChange, fn)
+}
+
+// OnShutdownStart registers fn to run when Shutdown begins, before
+// listeners stop. ctx carries the shutdown deadline.
+func (gw *Gateway) OnShutdownStart(fn func(ctx context.Context)) {
+	gw.hooks.mu.Lock()
+	defer gw.hooks.mu.Unlock()
+	gw.hooks.shutdownStart = append(gw.hooks.shutdownStart, fn)
+}
+
+// OnShutdownComplete registers fn to run once Shutdown has drained
+// requests and released the gateway's resources. err is what draining
+// returned.
+func (gw *Gateway) OnShutdownComplete(fn func(err error)) {
+	gw.hooks.mu.Lock()
+	defer gw.hooks.mu.Unlock()
+	gw.hooks.shutdownComplete = append(gw.hooks.shutdownComplete, fn)
+}
+
+// Reload applies cfg to a running gateway: OnConfigLoaded hooks may reject
+// it, then route middleware pipelines are rebuilt, load balancing settings
+// applied and OnReload hooks run.
+func (gw *Gateway) Reload(cfg *config.Config) error {
+	gw.hooks.mu.Lock()
+	check := slices.Clone(gw.hooks.configLoaded)
+	gw.hooks.mu.Unlock()
+	for _, fn := range check {
+		if err := fn(cfg); err != nil {
+			return fmt.Errorf("config rejected: %w", err)
+		}
+	}
+
+	if err := gw.ReloadPipelines(cfg); err != nil {
+		return err
+	}
+	gw.applyLoadBalancing(cfg.LoadBalancing)
+
+	gw.hooks.mu.Lock()
+	reloaded := slices.Clone(gw.hooks.reload)
+	gw.hooks.mu.Unlock()
+	for _, fn := range reloaded {
+		fn(cfg)
+	}
+	return nil
+}
+
+// ReopenLogs reopens the access log file, after logrotate or the like
+// moved it away
+func (gw *Gateway) ReopenLogs() error {
+	return gw.accessLog.Reopen()
+}
+
+// Shutdown runs OnShutdownStart hooks, then starts draining and waits up
+// to the drain timeout for requests in flight. It then calls drain, which
+// should stop the listeners serving the gateway, stops health checks,
+// closes the shared storage, waits for alerts being sent and runs
+// OnShutdownComplete hooks. It returns
+// drain's error. A gateway cannot be used after Shutdown.
+func (gw *Gateway) Shutdown(ctx context.Context, drain func(context.Context) error) error {
+	gw.hooks.mu.Lock()
+	start := slices.Clone(gw.hooks.shutdownStart)
+	gw.hooks.mu.Unlock()
+	for _, fn := range start {
+		fn(ctx)
+	}
+
+	timeout := gw.config.Drain.Timeout
+	if timeout <= 0 {
+		timeout = 30
+	}
+	gw.Drain()
+	idleCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	if err := gw.WaitIdle(idleCtx); err != nil {
+		logger.Warn("Draining did not finish, closing listeners anyway: %v", err)
+	}
+	cancel()
+
+	var err error
+	if drain != nil {
+		err = drain(ctx)
+	}
+
+	gw.stopChecks()
+	if gw.autoBan != nil {
+		if aerr := gw.autoBan.Close(); aerr != nil {
+			logger.Warn("Failed to close the auto-ban store: %v", aerr)
+		}
+	}
+	// Usage counts are stored before the shared storage closes
+	if gw.usage != nil {
+		if uerr := gw.usage.Close(); uerr != nil {
+			logger.Warn("Failed to store usage counts: %v", uerr)
+		}
+	}
+	if closer, ok := gw.storage.(io.Closer); ok {
+		if cerr := closer.Close(); cerr != nil {
+			logger.Warn("Failed to close shared storage: %v", cerr)
+		}
+	}
+	gw.alerts.notifier.Wait()
+	if err := gw.accessLog.Close(); err != nil {
+		logger.Warn("Failed to close the access log: %v", err)
+	}
+	if gw.events != nil {
+		gw.events.Close()
+	}
+
+	gw.hooks.mu.Lock()
+	complete := slices.Clone(gw.hooks.shutdownComplete)
+	gw.hooks.mu.Unlock()
+	for _, fn := range complete {
+		fn(err)
+	}
+	return err
+}
+
+// backendChanged runs OnBackendChange hooks
+func (gw *Gateway) backendChanged(change BackendChange) {
+	gw.hooks.mu.Lock()
+	hooks := slices.Clone(gw.hooks.backendChange)
+	gw.hooks.mu.Unlock()
+	for _, fn := range hooks {
+		fn(change)
+	}
+}
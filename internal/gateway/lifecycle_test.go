@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/loadbalancer"
+)
+
+func lifecycleGateway() *Gateway {
+	return New(&config.Config{
+		Backends:  []config.Backend{{Name: "api", URL: "http://127.0.0.1:1", Weight: 1, Health: "/health"}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100},
+		Routes:    []config.RouteConfig{{PathPrefix: "/"}},
+	})
+}
+
+func TestReloadHooks(t *testing.T) {
+	gw := lifecycleGateway()
+
+	var events []string
+	reject := false
+	gw.OnConfigLoaded(func(cfg *config.Config) error {
+		events = append(events, "loaded")
+		if reject {
+			return errors.New("plugin disagrees")
+		}
+		return nil
+	})
+	gw.OnReload(func(cfg *config.Config) {
+		events = append(events, "reloaded")
+	})
+
+	if err := gw.Reload(gw.config); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	reject = true
+	if err := gw.Reload(gw.config); err == nil {
+		t.Error("Expected the rejected config to fail the reload")
+	}
+
+	expected := []string{"loaded", "reloaded", "loaded"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+}
+
+func TestBackendChangeHook(t *testing.T) {
+	gw := lifecycleGateway()
+
+	var changes []BackendChange
+	gw.OnBackendChange(func(change BackendChange) {
+		changes = append(changes, change)
+	})
+
+	// Backends start healthy, so only the second and third reports change
+	gw.reportHealth("api", loadbalancer.HealthReport{Healthy: true})
+	gw.reportHealth("api", loadbalancer.HealthReport{Healthy: false, Reason: "connection refused"})
+	gw.reportHealth("api", loadbalancer.HealthReport{Healthy: false, Reason: "connection refused"})
+	gw.reportHealth("api", loadbalancer.HealthReport{Healthy: true, Reason: "status 200 OK"})
+
+	expected := []BackendChange{
+		{Backend: "api", Healthy: false, Reason: "connection refused", Source: "health_check"},
+		{Backend: "api", Healthy: true, Reason: "status 200 OK", Source: "health_check"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes %+v, got %+v", expected, changes)
+	}
+}
+
+func TestShutdownHooks(t *testing.T) {
+	gw := lifecycleGateway()
+
+	var events []string
+	gw.OnShutdownStart(func(ctx context.Context) {
+		events = append(events, "start")
+	})
+	gw.OnShutdownComplete(func(err error) {
+		events = append(events, "complete: "+err.Error())
+	})
+
+	drainErr := errors.New("deadline exceeded")
+	err := gw.Shutdown(context.Background(), func(ctx context.Context) error {
+		events = append(events, "drain")
+		return drainErr
+	})
+	if err != drainErr {
+		t.Errorf("Expected the drain error, got %v", err)
+	}
+
+	expected := []string{"start", "drain", "complete: deadline exceeded"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	runHealthChecks = true
+	defer func() { runHealthChecks = false }()
+
+	checks := make(chan struct{}, 10)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks <- struct{}{}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	gw := New(&config.Config{
+		Backends:    []config.Backend{{Name: "api", URL: backend.URL, Health: "/health"}},
+		HealthCheck: config.HealthCheckConfig{Interval: 1, Jitter: 0.01},
+		RateLimit:   config.RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100},
+	})
+
+	// The first check runs at startup rather than after an interval
+	waitFor(t, "the startup health check", func() bool {
+		return len(gw.loadBalancer.GetHealthyBackends()) == 0
+	})
+
+	gw.Shutdown(context.Background(), nil)
+	for len(checks) > 0 {
+		<-checks
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if len(checks) != 0 {
+		t.Errorf("Expected no health checks after Shutdown, got %d", len(checks))
+	}
+}
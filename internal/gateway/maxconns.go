@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/bulkhead"
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// newConnectionLimits builds a limiter for each backend instance with
+// maxConnections
+func newConnectionLimits(instances []config.Backend) map[string]*bulkhead.Limiter {
+	limits := make(map[string]*bulkhead.Limiter)
+	for _, instance := range instances {
+		if instance.MaxConnections <= 0 {
+			continue
+		}
+		queueDepth := instance.QueueDepth
+		if queueDepth == 0 {
+			queueDepth = instance.MaxConnections
+		}
+		timeout := instance.QueueTimeoutMs
+		if timeout == 0 {
+			timeout = 100
+		}
+		limits[instance.Name] = bulkhead.New(instance.Name, instance.MaxConnections, queueDepth,
+			time.Duration(timeout)*time.Millisecond)
+	}
+	return limits
+}
+
+// acquireConnection takes a connection to backend for the request. When
+// backend is full and spills over, the request goes to another healthy
+// backend with room instead, which is returned. Call release once the
+// request is done. On false a 503 has been written.
+func (gw *Gateway) acquireConnection(w http.ResponseWriter, r *http.Request, backend *config.Backend) (*config.Backend, func(), bool) {
+	limiter := gw.connLimits[backend.Name]
+	if limiter == nil {
+		return backend, func() {}, true
+	}
+	if release, ok := limiter.TryAcquire(); ok {
+		return backend, gw.connectionTaken(limiter, release), true
+	}
+
+	if backend.Overflow == "spill" {
+		tried := map[string]bool{backend.Name: true}
+		for {
+			other := gw.loadBalancer.NextBackendExcept(backend.Name, versionBackends(r)...)
+			if other == nil || tried[other.Name] {
+				break
+			}
+			tried[other.Name] = true
+
+			otherLimiter := gw.connLimits[other.Name]
+			if otherLimiter == nil {
+				metrics.RecordBackendSpillover(backend.Name)
+				return other, func() {}, true
+			}
+			if release, ok := otherLimiter.TryAcquire(); ok {
+				metrics.RecordBackendSpillover(backend.Name)
+				return other, gw.connectionTaken(otherLimiter, release), true
+			}
+		}
+	}
+
+	release, err := limiter.Acquire(r.Context())
+	metrics.RecordBackendConnections(limiter.Name, limiter.InFlight(), limiter.Waiting())
+	if err != nil {
+		reason := "queue_timeout"
+		switch {
+		case errors.Is(err, bulkhead.ErrQueueFull):
+			reason = "queue_full"
+		case r.Context().Err() != nil:
+			reason = "client_gone"
+		}
+		logger.Warn("Backend %s is at maxConnections, shed %s %s: %v", backend.Name, r.Method, r.URL.Path, err)
+		metrics.RecordBackendQueueRejected(backend.Name, reason)
+
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return nil, nil, false
+	}
+	return backend, gw.connectionTaken(limiter, release), true
+}
+
+// connectionTaken records a connection taken from limiter and returns its
+// release function, which records it being given back
+func (gw *Gateway) connectionTaken(limiter *bulkhead.Limiter, release func()) func() {
+	metrics.RecordBackendConnections(limiter.Name, limiter.InFlight(), limiter.Waiting())
+	return func() {
+		release()
+		metrics.RecordBackendConnections(limiter.Name, limiter.InFlight(), limiter.Waiting())
+	}
+}
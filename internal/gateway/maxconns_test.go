@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// holdingUpstream answers requests to "small" only once release is closed
+// and counts the requests each backend got
+type holdingUpstream struct {
+	release chan struct{}
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func newHoldingUpstream() *holdingUpstream {
+	return &holdingUpstream{release: make(chan struct{}), calls: make(map[string]int)}
+}
+
+func (u *holdingUpstream) transport(backend string) http.RoundTripper {
+	return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		u.mu.Lock()
+		u.calls[backend]++
+		u.mu.Unlock()
+		if backend == "small" {
+			<-u.release
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("ok")), Header: http.Header{}, Request: r}, nil
+	})
+}
+
+func (u *holdingUpstream) count(backend string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.calls[backend]
+}
+
+// waitFor polls cond for up to a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestMaxConnectionsQueue(t *testing.T) {
+	upstream := newHoldingUpstream()
+	cfg := &config.Config{
+		Backends: []config.Backend{
+			{Name: "small", URL: "http://small.internal", MaxConnections: 1, QueueTimeoutMs: 2000},
+		},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+	}
+	gw := NewWithUpstream(cfg, upstream.transport)
+	handler := gw.Handler()
+	limiter := gw.connLimits["small"]
+
+	codes := make(chan int, 2)
+	serve := func() {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/orders", nil))
+		codes <- rr.Code
+	}
+
+	go serve()
+	waitFor(t, "the first request to reach the backend", func() bool { return upstream.count("small") == 1 })
+	go serve()
+	waitFor(t, "the second request to queue", func() bool { return limiter.Waiting() == 1 })
+
+	// The queue holds one request
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/orders", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with the queue full, got %d", rr.Code)
+	}
+
+	close(upstream.release)
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("Expected 200 once a connection freed up, got %d", code)
+		}
+	}
+	if limiter.InFlight() != 0 {
+		t.Errorf("Expected every connection to be released, got %d in flight", limiter.InFlight())
+	}
+}
+
+func TestMaxConnectionsSpill(t *testing.T) {
+	upstream := newHoldingUpstream()
+	defer close(upstream.release)
+	cfg := &config.Config{
+		Backends: []config.Backend{
+			{Name: "small", URL: "http://small.internal", MaxConnections: 1, Overflow: "spill"},
+			{Name: "big", URL: "http://big.internal"},
+		},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+	}
+	handler := NewWithUpstream(cfg, upstream.transport).Handler()
+
+	// Round robin sends the first and third requests to small
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders", nil))
+	waitFor(t, "the first request to reach small", func() bool { return upstream.count("small") == 1 })
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/orders", nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d", rr.Code)
+		}
+	}
+	if upstream.count("small") != 1 || upstream.count("big") != 2 {
+		t.Errorf("Expected the request for full small to spill to big, got small %d and big %d",
+			upstream.count("small"), upstream.count("big"))
+	}
+}
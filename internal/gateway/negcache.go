@@ -0,0 +1,226 @@
+package gateway
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/incident"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// negativeCache holds recent error responses for one route
+type negativeCache struct {
+	route      string
+	statuses   map[int]bool
+	ttl        time.Duration
+	maxEntries int
+	maxBody    int
+	stale      time.Duration
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+}
+
+type cachedResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func newNegativeCache(route config.RouteConfig, stale time.Duration) *negativeCache {
+	cfg := *route.NegativeCache
+	if len(cfg.Statuses) == 0 {
+		cfg.Statuses = []int{http.StatusNotFound}
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 5
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = 10000
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 64 << 10
+	}
+
+	c := &negativeCache{
+		route:      route.Name,
+		statuses:   make(map[int]bool, len(cfg.Statuses)),
+		ttl:        time.Duration(cfg.TTL) * time.Second,
+		maxEntries: cfg.MaxEntries,
+		maxBody:    cfg.MaxBodyBytes,
+		stale:      stale,
+		now:        time.Now,
+		entries:    make(map[string]*cachedResponse),
+	}
+	for _, status := range cfg.Statuses {
+		c.statuses[status] = true
+	}
+	return c
+}
+
+// negativeCacheHandler serves cached error responses for the route and
+// records new ones from next. During an incident, expired entries are
+// still served for up to stale past their expiry.
+func negativeCacheHandler(route config.RouteConfig, stale time.Duration, next http.Handler) http.Handler {
+	c := newNegativeCache(route, stale)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cacheable(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.Method + " " + r.Host + r.URL.RequestURI() + " " + r.Header.Get("Accept-Encoding")
+		_, inIncident := incident.FromContext(r.Context())
+		if cached, fresh := c.get(key, inIncident); cached != nil {
+			metrics.RecordNegativeCacheHit(c.route, strconv.Itoa(cached.status))
+			header := w.Header()
+			for name, values := range cached.header {
+				header[name] = values
+			}
+			if fresh {
+				header.Set("X-Cache", "HIT")
+			} else {
+				header.Set("X-Cache", "STALE")
+			}
+			w.WriteHeader(cached.status)
+			w.Write(cached.body)
+			return
+		}
+
+		cw := &negativeCacheWriter{ResponseWriter: w, cache: c}
+		next.ServeHTTP(cw, r)
+		if cw.capture {
+			c.put(key, &cachedResponse{
+				status:  cw.status,
+				header:  cw.header,
+				body:    cw.body.Bytes(),
+				expires: c.now().Add(c.ttl),
+			})
+		}
+	})
+}
+
+// cacheable reports whether a request may share a cached response. Anything
+// carrying credentials could see a per-user answer, so it always goes through.
+func cacheable(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return r.Header.Get("Authorization") == "" && r.Header.Get("Cookie") == ""
+}
+
+// get returns the entry for key and whether it is fresh. With allowStale,
+// entries up to c.stale past expiry are returned too.
+func (c *negativeCache) get(key string, allowStale bool) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	now := c.now()
+	if now.Before(entry.expires) {
+		return entry, true
+	}
+	if allowStale && now.Before(entry.expires.Add(c.stale)) {
+		return entry, false
+	}
+	// Kept for stale serving until the next put needs the room
+	if !now.Before(entry.expires.Add(c.stale)) {
+		delete(c.entries, key)
+	}
+	return nil, false
+}
+
+func (c *negativeCache) put(key string, entry *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= c.maxEntries {
+		now := c.now()
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		// Still full of live entries: skip rather than evict what is working
+		if len(c.entries) >= c.maxEntries {
+			return
+		}
+	}
+	c.entries[key] = entry
+}
+
+// negativeCacheWriter passes the response through and keeps a copy when it
+// is a small error response the route caches
+type negativeCacheWriter struct {
+	http.ResponseWriter
+	cache       *negativeCache
+	wroteHeader bool
+	capture     bool
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+}
+
+func (cw *negativeCacheWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+
+	h := cw.Header()
+	cacheControl := strings.ToLower(h.Get("Cache-Control"))
+	vary := strings.ToLower(strings.Join(h.Values("Vary"), ","))
+	cw.capture = cw.cache.statuses[status] &&
+		!strings.Contains(cacheControl, "no-store") && !strings.Contains(cacheControl, "private") &&
+		h.Get("Set-Cookie") == "" &&
+		(vary == "" || strings.TrimSpace(vary) == "accept-encoding")
+	if cw.capture {
+		cw.header = h.Clone()
+	}
+
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *negativeCacheWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.capture {
+		if cw.body.Len()+len(b) > cw.cache.maxBody {
+			cw.capture = false
+			cw.body.Reset()
+		} else {
+			cw.body.Write(b)
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets the proxy flush through to the client
+func (cw *negativeCacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// staleDuringIncident is how long past expiry negative caches may serve
+// entries while an incident is open
+func (gw *Gateway) staleDuringIncident() time.Duration {
+	if !gw.config.Incident.Enabled {
+		return 0
+	}
+	if gw.config.Incident.StaleTTL <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(gw.config.Incident.StaleTTL) * time.Second
+}
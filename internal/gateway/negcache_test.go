@@ -0,0 +1,113 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestNegativeCache(t *testing.T) {
+	var calls int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		switch r.URL.Path {
+		case "/items/missing":
+			http.Error(w, "no such item", http.StatusNotFound)
+		case "/items/secret":
+			w.Header().Set("Cache-Control", "no-store")
+			http.Error(w, "no such item", http.StatusNotFound)
+		default:
+			w.Write([]byte("item"))
+		}
+	})
+	handler := negativeCacheHandler(config.RouteConfig{Name: "items", NegativeCache: &config.NegativeCacheConfig{TTL: 5}}, 0, next)
+
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	testCases := []struct {
+		name        string
+		path        string
+		header      []string
+		wantStatus  int
+		wantHit     bool
+		wantBackend int32
+	}{
+		{"first miss goes to backend", "/items/missing", nil, http.StatusNotFound, false, 1},
+		{"repeat is served from cache", "/items/missing", nil, http.StatusNotFound, true, 0},
+		{"credentials bypass the cache", "/items/missing", []string{"Authorization", "Bearer x"}, http.StatusNotFound, false, 1},
+		{"success is never cached", "/items/1", nil, http.StatusOK, false, 1},
+		{"success repeat", "/items/1", nil, http.StatusOK, false, 1},
+		{"no-store is honoured", "/items/secret", nil, http.StatusNotFound, false, 1},
+		{"no-store repeat", "/items/secret", nil, http.StatusNotFound, false, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			before := atomic.LoadInt32(&calls)
+			rr := get(tc.path, tc.header...)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("expected %d, got %d", tc.wantStatus, rr.Code)
+			}
+			if hit := rr.Header().Get("X-Cache") == "HIT"; hit != tc.wantHit {
+				t.Errorf("expected cache hit %v, got %v", tc.wantHit, hit)
+			}
+			if got := atomic.LoadInt32(&calls) - before; got != tc.wantBackend {
+				t.Errorf("expected %d backend calls, got %d", tc.wantBackend, got)
+			}
+		})
+	}
+}
+
+func TestNegativeCacheExpiry(t *testing.T) {
+	c := newNegativeCache(config.RouteConfig{NegativeCache: &config.NegativeCacheConfig{TTL: 5, MaxEntries: 1}}, 0)
+	now := time.Unix(1700000000, 0)
+	c.now = func() time.Time { return now }
+
+	c.put("a", &cachedResponse{status: 404, expires: now.Add(c.ttl)})
+	c.put("b", &cachedResponse{status: 404, expires: now.Add(c.ttl)})
+	a, _ := c.get("a", false)
+	b, _ := c.get("b", false)
+	if a == nil || b != nil {
+		t.Fatal("a full cache should keep live entries and skip new ones")
+	}
+
+	now = now.Add(5 * time.Second)
+	if a, _ := c.get("a", false); a != nil {
+		t.Error("entry should expire after the TTL")
+	}
+}
+
+func TestNegativeCacheStaleDuringIncident(t *testing.T) {
+	c := newNegativeCache(config.RouteConfig{NegativeCache: &config.NegativeCacheConfig{TTL: 5}}, time.Minute)
+	now := time.Unix(1700000000, 0)
+	c.now = func() time.Time { return now }
+
+	c.put("a", &cachedResponse{status: 404, expires: now.Add(c.ttl)})
+	now = now.Add(30 * time.Second)
+
+	if entry, _ := c.get("a", false); entry != nil {
+		t.Error("expired entry should not be served outside an incident")
+	}
+	entry, fresh := c.get("a", true)
+	if entry == nil || fresh {
+		t.Fatalf("expected a stale entry during an incident, got %v fresh=%v", entry, fresh)
+	}
+
+	now = now.Add(time.Minute)
+	if entry, _ := c.get("a", true); entry != nil {
+		t.Error("entry should not be served past the stale window")
+	}
+}
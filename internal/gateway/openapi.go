@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// openAPIHandler rejects requests that do not match the route's OpenAPI
+// operation before they reach a backend
+func openAPIHandler(label string, route config.RouteConfig, maxBody int64, next http.Handler) http.Handler {
+	if maxBody <= 0 {
+		maxBody = 1 << 20
+	}
+	op := route.Operation
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if op.RequestBody != nil && r.Body != nil {
+			var err error
+			body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+			if err != nil {
+				http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		if problems := op.Validate(r, mux.Vars(r), body); len(problems) > 0 {
+			metrics.RecordOpenAPIInvalidRequest(label)
+			writeInvalidRequest(w, "request does not match the API specification", problems)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeInvalidRequest answers 400 with the problems found in a request
+func writeInvalidRequest(w http.ResponseWriter, message string, problems []string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   message,
+		"details": problems,
+	})
+}
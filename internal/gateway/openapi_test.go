@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+const ordersSpec = `
+openapi: 3.0.3
+servers:
+  - url: /api
+paths:
+  /orders/{id}:
+    put:
+      operationId: updateOrder
+      parameters:
+        - {name: id, in: path, required: true, schema: {type: integer}}
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [quantity]
+              properties:
+                quantity: {type: integer, minimum: 1}
+`
+
+func TestOpenAPIRoutes(t *testing.T) {
+	var received string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	specFile := filepath.Join(t.TempDir(), "openapi.yaml")
+	if err := os.WriteFile(specFile, []byte(ordersSpec), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "test", URL: backend.URL, Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		OpenAPI:   config.OpenAPIConfig{Spec: specFile, Validate: true},
+	}
+	if err := cfg.AddOpenAPIRoutes(); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Routes) != 1 || cfg.Routes[0].Name != "updateOrder" || cfg.Routes[0].Path != "/api/orders/{id}" {
+		t.Fatalf("Unexpected generated routes %+v", cfg.Routes)
+	}
+	handler := New(cfg).Handler()
+
+	testCases := []struct {
+		name           string
+		url            string
+		body           string
+		expectedStatus int
+		expectedDetail string
+	}{
+		{"valid", "/api/orders/7", `{"quantity":2}`, http.StatusOK, ""},
+		{"bad path parameter", "/api/orders/seven", `{"quantity":2}`, http.StatusBadRequest, "path parameter id: must be a number"},
+		{"bad body", "/api/orders/7", `{"quantity":0}`, http.StatusBadRequest, "body.quantity: must be at least 1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			received = ""
+			req := httptest.NewRequest("PUT", tc.url, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tc.expectedStatus, rr.Code, rr.Body)
+			}
+			if tc.expectedDetail == "" {
+				if received != tc.body {
+					t.Errorf("Expected backend to receive %q, got %q", tc.body, received)
+				}
+				return
+			}
+
+			var resp struct {
+				Details []string `json:"details"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Details) != 1 || resp.Details[0] != tc.expectedDetail {
+				t.Errorf("Expected detail %q, got %q", tc.expectedDetail, resp.Details)
+			}
+			if received != "" {
+				t.Error("Invalid request reached the backend")
+			}
+		})
+	}
+}
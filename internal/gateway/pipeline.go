@@ -0,0 +1,180 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/barisgenc/gatekeeper/internal/accesslog"
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/kv"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/middleware"
+)
+
+// routePipeline runs a route's configured middleware chain in front of the
+// route's handler. The chain is swapped on reload without re-registering
+// the route.
+type routePipeline struct {
+	next  http.Handler
+	chain atomic.Pointer[http.Handler]
+}
+
+func newRoutePipeline(next http.Handler) *routePipeline {
+	p := &routePipeline{next: next}
+	p.chain.Store(&next)
+	return p
+}
+
+func (p *routePipeline) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*p.chain.Load()).ServeHTTP(w, r)
+}
+
+func (p *routePipeline) set(middlewares []middleware.Middleware) {
+	handler := p.next
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i].Wrap(handler)
+	}
+	p.chain.Store(&handler)
+}
+
+// pipelines holds the named middleware instances routes are built from
+type pipelines struct {
+	mu        sync.Mutex
+	routes    map[string][]*routePipeline
+	defs      config.MiddlewareConfigs
+	instances map[string]middleware.Middleware
+}
+
+// ReloadPipelines rebuilds every route's middleware chain from cfg.
+// Instances whose definition is unchanged are kept, along with their state
+// such as rate limit buckets. Routes themselves are not re-registered, so
+// added or removed routes need a restart.
+func (gw *Gateway) ReloadPipelines(cfg *config.Config) error {
+	p := gw.pipelines
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	instances := make(map[string]middleware.Middleware, len(cfg.Middlewares))
+	for name, def := range cfg.Middlewares {
+		if existing, ok := p.instances[name]; ok && reflect.DeepEqual(p.defs[name], def) {
+			instances[name] = existing
+			continue
+		}
+		if err := def.Validate(); err != nil {
+			return fmt.Errorf("middleware %s: %w", name, err)
+		}
+		m, err := newMiddleware(def, gw.storage, gw.accessLog)
+		if err != nil {
+			return fmt.Errorf("middleware %s: %w", name, err)
+		}
+		gw.useTarpit(m)
+		instances[name] = m
+	}
+
+	// Routes sharing a label are matched up in registration order
+	chains := make(map[*routePipeline][]middleware.Middleware)
+	seen := make(map[string]int)
+	for _, route := range cfg.OrderedRoutes() {
+		pattern, err := route.Pattern()
+		if err != nil {
+			continue
+		}
+		label := routeLabel(route, pattern)
+		registered := p.routes[label]
+		if seen[label] >= len(registered) {
+			logger.Warn("Route %s is not registered; restart to add it", label)
+			continue
+		}
+		pipeline := registered[seen[label]]
+		seen[label]++
+		chain := make([]middleware.Middleware, 0, len(route.Middlewares))
+		for _, name := range route.Middlewares {
+			m, ok := instances[name]
+			if !ok {
+				return fmt.Errorf("route %s: middleware %s is not defined", label, name)
+			}
+			chain = append(chain, m)
+		}
+		chains[pipeline] = chain
+	}
+
+	// Nothing is swapped until the whole config has been checked
+	for pipeline, chain := range chains {
+		pipeline.set(chain)
+	}
+	p.defs = cfg.Middlewares
+	p.instances = instances
+	if len(instances) > 0 {
+		logger.Info("Built middleware pipelines for %d routes from %d instances", len(chains), len(instances))
+	}
+	return nil
+}
+
+// withPipeline wraps a route's handler in its middleware pipeline
+func (gw *Gateway) withPipeline(label string, next http.Handler) http.Handler {
+	pipeline := newRoutePipeline(next)
+	gw.pipelines.mu.Lock()
+	gw.pipelines.routes[label] = append(gw.pipelines.routes[label], pipeline)
+	gw.pipelines.mu.Unlock()
+	return pipeline
+}
+
+// NewMiddleware validates def and builds it outside any gateway, for
+// programs embedding single middlewares. Shared stores are kept in memory
+// and logging instances write the default access log to stdout.
+func NewMiddleware(def config.MiddlewareConfig) (middleware.Middleware, error) {
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+	if def.Type == "logging" {
+		return middleware.NewLogging(), nil
+	}
+	return newMiddleware(def, kv.NewMemoryStore(), nil)
+}
+
+// newMiddleware builds one middleware instance from a validated definition.
+// storage backs definitions whose store is "shared", and logging instances
+// write to accessLog.
+func newMiddleware(def config.MiddlewareConfig, storage kv.Store, accessLog *accesslog.Logger) (middleware.Middleware, error) {
+	switch def.Type {
+	case "logging":
+		return middleware.NewAccessLog(accessLog), nil
+	case "metrics":
+		return middleware.NewMetrics(), nil
+	case "rateLimit":
+		if rl := def.RateLimit; rl.Anonymous != nil || rl.Authenticated != nil || len(rl.Plans) > 0 {
+			return middleware.NewTieredRateLimitWithStorage(*def.RateLimit, storage), nil
+		}
+		if def.RateLimit.SpikeArrest() {
+			return middleware.NewSpikeArrest(def.RateLimit.RequestsPerMinute), nil
+		}
+		return middleware.NewRateLimiter(def.RateLimit.RequestsPerMinute, def.RateLimit.BurstSize), nil
+	case "oidc":
+		return middleware.NewOIDC(*def.OIDC), nil
+	case "saml":
+		return middleware.NewSAML(*def.SAML), nil
+	case "spnego":
+		return middleware.NewSPNEGO(*def.SPNEGO), nil
+	case "ldap":
+		return middleware.NewLDAP(*def.LDAP), nil
+	case "introspection":
+		return middleware.NewIntrospection(*def.Introspection), nil
+	case "hmac":
+		return middleware.NewHMAC(*def.HMAC), nil
+	case "extAuthz":
+		return middleware.NewExtAuthz(*def.ExtAuthz), nil
+	case "bulkhead":
+		return middleware.NewBulkhead(*def.Bulkhead), nil
+	case "idempotency":
+		return middleware.NewIdempotencyWithStorage(*def.Idempotency, storage), nil
+	case "rateLimitService":
+		return middleware.NewRateLimitService(*def.RateLimitSvc), nil
+	case "extProc":
+		return middleware.NewExtProc(*def.ExtProc), nil
+	default:
+		return nil, fmt.Errorf("unknown middleware type %q", def.Type)
+	}
+}
@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestRoutePipelines(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "test", URL: backend.URL, Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Middlewares: config.MiddlewareConfigs{
+			"api-limit": {Type: "rateLimit", RateLimit: &config.RateLimitConfig{RequestsPerMinute: 1, BurstSize: 1}},
+		},
+		Routes: []config.RouteConfig{
+			{Name: "api", PathPrefix: "/api", Middlewares: []string{"api-limit"}},
+			{Name: "public", PathPrefix: "/public"},
+		},
+	}
+	gw := New(cfg)
+	handler := gw.Handler()
+
+	get := func(path string) int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr.Code
+	}
+
+	if code := get("/api/users"); code != http.StatusOK {
+		t.Fatalf("Expected first API request to pass, got %d", code)
+	}
+	if code := get("/api/users"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the API route's limit to apply, got %d", code)
+	}
+	for i := 0; i < 3; i++ {
+		if code := get("/public/logo.png"); code != http.StatusOK {
+			t.Errorf("Expected public route to have no limit, got %d", code)
+		}
+	}
+
+	// Moving the limit to the public route takes effect without a restart
+	reloaded := *cfg
+	reloaded.Routes = []config.RouteConfig{
+		{Name: "api", PathPrefix: "/api"},
+		{Name: "public", PathPrefix: "/public", Middlewares: []string{"api-limit"}},
+	}
+	if err := gw.ReloadPipelines(&reloaded); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if code := get("/api/users"); code != http.StatusOK {
+		t.Errorf("Expected API limit to be removed, got %d", code)
+	}
+	// The unchanged instance keeps its exhausted bucket
+	if code := get("/public/logo.png"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the reused limiter on the public route, got %d", code)
+	}
+
+	reloaded.Middlewares = config.MiddlewareConfigs{"api-limit": {Type: "rateLimit"}}
+	if err := gw.ReloadPipelines(&reloaded); err == nil {
+		t.Error("Expected reload with an invalid middleware to fail")
+	}
+	if code := get("/public/logo.png"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected a failed reload to keep the old pipelines, got %d", code)
+	}
+}
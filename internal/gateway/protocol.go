@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+)
+
+// protocolCache decides which cleartext backends get HTTP/2 with prior
+// knowledge (h2c). TLS backends need no help: ALPN picks h2 when both ends
+// support it. Backends with protocol "auto" are probed on first use, and
+// the answer is re-checked in the background once it is older than
+// revalidate.
+type protocolCache struct {
+	h2c        *http2.Transport
+	revalidate time.Duration
+	timeout    time.Duration
+	now        func() time.Time
+	backends   map[string]config.Backend
+
+	mu      sync.Mutex
+	entries map[string]*protocolEntry
+}
+
+type protocolEntry struct {
+	ready   chan struct{} // closed once the first probe is done
+	http2   bool
+	checked time.Time
+	probing bool
+}
+
+func newProtocolCache(cfg config.ProtocolConfig, backends []config.Backend) *protocolCache {
+	if cfg.Revalidate <= 0 {
+		cfg.Revalidate = 600
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2
+	}
+
+	c := &protocolCache{
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+		revalidate: time.Duration(cfg.Revalidate) * time.Second,
+		timeout:    time.Duration(cfg.Timeout) * time.Second,
+		now:        time.Now,
+		backends:   make(map[string]config.Backend),
+		entries:    make(map[string]*protocolEntry),
+	}
+	for _, backend := range backends {
+		u, err := url.Parse(backend.URL)
+		// Forward proxies only tunnel HTTP/1.1
+		if err != nil || u.Scheme != "http" || backend.Proxy != nil || backend.Protocol == "http1" {
+			continue
+		}
+		c.backends[backend.Name] = backend
+	}
+	return c
+}
+
+// transport wraps next so requests to the named backend use h2c when it
+// speaks it
+func (c *protocolCache) transport(name string, next http.RoundTripper) http.RoundTripper {
+	if _, ok := c.backends[name]; !ok {
+		return next
+	}
+	return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		// Upgrades such as WebSocket only exist in HTTP/1.1, and gRPC only
+		// in HTTP/2
+		grpc := strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+		if r.Header.Get("Upgrade") == "" && (grpc || c.http2(name)) {
+			return c.h2c.RoundTrip(r)
+		}
+		return next.RoundTrip(r)
+	})
+}
+
+// http2 reports whether the named backend speaks h2c
+func (c *protocolCache) http2(name string) bool {
+	backend := c.backends[name]
+	switch backend.Protocol {
+	case "http2", "grpc":
+		return true
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	if !ok {
+		e = &protocolEntry{ready: make(chan struct{})}
+		c.entries[name] = e
+		c.mu.Unlock()
+
+		supported := c.probe(backend)
+		c.mu.Lock()
+		e.http2, e.checked = supported, c.now()
+		close(e.ready)
+		c.mu.Unlock()
+		logProtocol(name, supported)
+		return supported
+	}
+	c.mu.Unlock()
+	<-e.ready
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !e.probing && c.now().Sub(e.checked) >= c.revalidate {
+		e.probing = true
+		go func() {
+			supported := c.probe(backend)
+			c.mu.Lock()
+			changed := supported != e.http2
+			e.http2, e.checked, e.probing = supported, c.now(), false
+			c.mu.Unlock()
+			if changed {
+				logProtocol(name, supported)
+			}
+		}()
+	}
+	return e.http2
+}
+
+// probe asks the backend "OPTIONS * HTTP/1.1", which servers answer
+// themselves without running a handler. HTTP/1.1 servers answer it, even
+// those that also accept h2c. An HTTP/2-only server, such as a gRPC
+// server, sends a SETTINGS frame or hangs up instead. A backend that cannot
+// be reached or does not answer in time is treated as HTTP/1.1, and the
+// request reports the error.
+func (c *protocolCache) probe(backend config.Backend) bool {
+	u, err := url.Parse(backend.URL)
+	if err != nil {
+		return false
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "80")
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, c.timeout)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	if _, err := fmt.Fprintf(conn, "OPTIONS * HTTP/1.1\r\nHost: %s\r\n\r\n", u.Host); err != nil {
+		return false
+	}
+	// Hanging up on the unread request can also surface as a reset
+	head := make([]byte, 5)
+	n, err := io.ReadFull(conn, head)
+	if n == 0 {
+		return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)
+	}
+	return !bytes.HasPrefix([]byte("HTTP/"), head[:n])
+}
+
+func logProtocol(name string, http2 bool) {
+	if http2 {
+		logger.Info("Backend %s speaks HTTP/2 without TLS; using h2c", name)
+	} else {
+		logger.Info("Backend %s speaks HTTP/1.1", name)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
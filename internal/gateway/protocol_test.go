@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// h2OnlyServer speaks nothing but HTTP/2 with prior knowledge, like a gRPC
+// server
+func h2OnlyServer(t *testing.T, handler http.Handler) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	server := &http2.Server{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeConn(conn, &http2.ServeConnOpts{Handler: handler})
+		}
+	}()
+	return "http://" + ln.Addr().String()
+}
+
+func TestBackendProtocols(t *testing.T) {
+	proto := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	h2Only := h2OnlyServer(t, proto)
+	h1 := httptest.NewServer(proto)
+	defer h1.Close()
+	// Accepts both HTTP/1.1 and h2c
+	dual := httptest.NewServer(h2c.NewHandler(proto, &http2.Server{}))
+	defer dual.Close()
+
+	testCases := []struct {
+		name     string
+		url      string
+		protocol string
+		upgrade  bool
+		expected string
+	}{
+		{"HTTP/2-only backend is detected", h2Only, "", false, "HTTP/2.0"},
+		{"HTTP/1.1 backend is detected", h1.URL, "auto", false, "HTTP/1.1"},
+		{"backend speaking both keeps HTTP/1.1", dual.URL, "", false, "HTTP/1.1"},
+		{"declared http2", dual.URL, "http2", false, "HTTP/2.0"},
+		{"declared http1", dual.URL, "http1", false, "HTTP/1.1"},
+		{"upgrades stay on HTTP/1.1", dual.URL, "http2", true, "HTTP/1.1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gw := New(&config.Config{
+				Backends:  []config.Backend{{Name: "backend", URL: tc.url, Weight: 1, Health: "/health", Protocol: tc.protocol}},
+				RateLimit: config.RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100},
+				Routes:    []config.RouteConfig{{PathPrefix: "/"}},
+			})
+
+			// Asked twice so the second request uses the cached answer
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest("GET", "/", nil)
+				if tc.upgrade {
+					req.Header.Set("Connection", "Upgrade")
+					req.Header.Set("Upgrade", "websocket")
+				}
+				rr := httptest.NewRecorder()
+				gw.Handler().ServeHTTP(rr, req)
+
+				if rr.Code != http.StatusOK {
+					t.Fatalf("Expected status 200, got %d", rr.Code)
+				}
+				if body, _ := io.ReadAll(rr.Body); string(body) != tc.expected {
+					t.Errorf("Expected backend to see %s, got %s", tc.expected, body)
+				}
+			}
+		})
+	}
+}
+
+func TestGRPCUsesHTTP2(t *testing.T) {
+	backend := h2OnlyServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+
+	// Even with HTTP/1.1 cached, gRPC cannot be sent over it
+	cache := newProtocolCache(config.ProtocolConfig{}, []config.Backend{{Name: "grpc", URL: backend}})
+	ready := make(chan struct{})
+	close(ready)
+	cache.entries["grpc"] = &protocolEntry{ready: ready, checked: time.Now()}
+	client := &http.Client{Transport: cache.transport("grpc", http.DefaultTransport)}
+
+	req, _ := http.NewRequest("POST", backend+"/pkg.Service/Method", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "HTTP/2.0" {
+		t.Errorf("Expected HTTP/2.0, got %s", body)
+	}
+}
+
+func TestTLSBackendProtocols(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+
+	for protocol, expected := range map[string]string{"": "HTTP/2.0", "http1": "HTTP/1.1"} {
+		gw := New(&config.Config{
+			Backends:  []config.Backend{{Name: "backend", URL: backend.URL, Weight: 1, Health: "/health", Protocol: protocol}},
+			RateLimit: config.RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100},
+			Routes:    []config.RouteConfig{{PathPrefix: "/"}},
+		})
+		gw.backendTransport("backend").TLSClientConfig.RootCAs = backend.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+		rr := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		if body := rr.Body.String(); body != expected {
+			t.Errorf("Expected protocol %q to reach the backend over %s, got %s", protocol, expected, body)
+		}
+	}
+}
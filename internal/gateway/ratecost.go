@@ -0,0 +1,48 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/middleware"
+)
+
+// routeCosts marks each request with the rate limit cost of the route it
+// will match. It runs ahead of the rate limits, which spend the cost
+// before routing.
+type routeCosts struct {
+	router *mux.Router
+	costs  map[*mux.Route]int
+}
+
+func newRouteCosts(router *mux.Router) *routeCosts {
+	return &routeCosts{router: router, costs: make(map[*mux.Route]int)}
+}
+
+func routesHaveCost(routes []config.RouteConfig) bool {
+	for _, route := range routes {
+		if route.RateLimitCost > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// add gives a registered route its cost
+func (c *routeCosts) add(route *mux.Route, cost int) {
+	c.costs[route] = cost
+}
+
+func (c *routeCosts) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var match mux.RouteMatch
+		if c.router.Match(r, &match) {
+			if cost, ok := c.costs[match.Route]; ok {
+				r = middleware.WithCost(r, cost)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
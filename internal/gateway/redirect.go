@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// redirectHandler answers with the configured redirect. Requests that a
+// scheme or trailing-slash policy leaves unchanged are passed to next.
+func redirectHandler(route config.RouteConfig, next http.Handler) http.Handler {
+	cfg := *route.Redirect
+	if cfg.Status == 0 {
+		cfg.Status = http.StatusFound
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme := requestScheme(r)
+		path := r.URL.Path
+		vars := map[string]string{
+			"scheme": scheme,
+			"host":   r.Host,
+			"path":   strings.TrimPrefix(path, "/"),
+			"rest":   strings.TrimPrefix(strings.TrimPrefix(path, route.PathPrefix), "/"),
+			"query":  r.URL.RawQuery,
+		}
+		for name, value := range mux.Vars(r) {
+			vars[name] = value
+		}
+
+		var target string
+		if cfg.To != "" {
+			target = expandTemplate(cfg.To, vars)
+		} else {
+			newScheme := scheme
+			if cfg.Scheme != "" {
+				newScheme = cfg.Scheme
+			}
+			newHost := r.Host
+			if cfg.Host != "" {
+				newHost = expandTemplate(cfg.Host, vars)
+			}
+			newPath := applyTrailingSlash(path, cfg.TrailingSlash)
+
+			if newScheme == scheme && strings.EqualFold(newHost, r.Host) && newPath == path {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if newScheme == scheme && newHost == r.Host {
+				// Stay relative so the redirect works behind other proxies,
+				// but never emit "//host" which browsers treat as absolute
+				target = "/" + strings.TrimLeft(newPath, "/")
+			} else {
+				target = newScheme + "://" + newHost + newPath
+			}
+		}
+
+		if !cfg.DropQuery && r.URL.RawQuery != "" && !strings.Contains(target, "?") {
+			target += "?" + r.URL.RawQuery
+		}
+
+		w.Header().Set("Location", target)
+		w.WriteHeader(cfg.Status)
+	})
+}
+
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "https" || proto == "http" {
+		return proto
+	}
+	return "http"
+}
+
+func applyTrailingSlash(path, policy string) string {
+	switch policy {
+	case "add":
+		if !strings.HasSuffix(path, "/") {
+			return path + "/"
+		}
+	case "remove":
+		if trimmed := strings.TrimRight(path, "/"); trimmed != "" {
+			return trimmed
+		}
+	}
+	return path
+}
+
+// expandTemplate replaces {name} placeholders; unknown names are left as is
+func expandTemplate(template string, vars map[string]string) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+
+		b.WriteString(template[:start])
+		if value, ok := vars[template[start+1:end]]; ok {
+			b.WriteString(value)
+		} else {
+			b.WriteString(template[start : end+1])
+		}
+		template = template[end+1:]
+	}
+	b.WriteString(template)
+	return b.String()
+}
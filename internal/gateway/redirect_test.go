@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestRedirectRoutes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "test", URL: backend.URL, Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Routes: []config.RouteConfig{
+			{Name: "apex", Host: "www.example.com", PathPrefix: "/", Redirect: &config.RedirectConfig{Host: "example.com", Status: 301}},
+			{Name: "users", Path: "/u/{id}", Redirect: &config.RedirectConfig{To: "/users/{id}", Status: 301}},
+			{Name: "docs", PathPrefix: "/docs/", Redirect: &config.RedirectConfig{To: "https://docs.example.com/{rest}", Status: 308}},
+			{Name: "secure", PathPrefix: "/account", Redirect: &config.RedirectConfig{Scheme: "https"}},
+			{Name: "slash", PathPrefix: "/shop", Redirect: &config.RedirectConfig{TrailingSlash: "add", Status: 307}},
+		},
+	}
+	handler := New(cfg).Handler()
+
+	testCases := []struct {
+		name             string
+		url              string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"path variable", "/u/42?ref=mail", http.StatusMovedPermanently, "/users/42?ref=mail"},
+		{"prefix rest", "/docs/guide/intro", http.StatusPermanentRedirect, "https://docs.example.com/guide/intro"},
+		{"scheme upgrade", "http://gw.example.com/account/settings", http.StatusFound, "https://gw.example.com/account/settings"},
+		{"trailing slash added", "/shop/cart", http.StatusTemporaryRedirect, "/shop/cart/"},
+		{"trailing slash already present", "/shop/cart/", http.StatusOK, ""},
+		{"unmatched", "/other", http.StatusOK, ""},
+		{"host move", "http://www.example.com/about?ref=mail", http.StatusMovedPermanently, "http://example.com/about?ref=mail"},
+		{"host move keeps the scheme", "https://www.example.com/", http.StatusMovedPermanently, "https://example.com/"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", tc.url, nil))
+
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status %v, got %v", tc.expectedStatus, rr.Code)
+			}
+			if got := rr.Header().Get("Location"); got != tc.expectedLocation {
+				t.Errorf("Expected Location %q, got %q", tc.expectedLocation, got)
+			}
+		})
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	got := expandTemplate("https://{host}/v2/{path}?{query}&x={unknown}", map[string]string{
+		"host":  "api.example.com",
+		"path":  "items",
+		"query": "a=1",
+	})
+	if got != "https://api.example.com/v2/items?a=1&x={unknown}" {
+		t.Errorf("Unexpected expansion: %v", got)
+	}
+}
+
+func TestApplyTrailingSlash(t *testing.T) {
+	testCases := []struct {
+		path, policy, expected string
+	}{
+		{"/a", "add", "/a/"},
+		{"/a/", "add", "/a/"},
+		{"/a/", "remove", "/a"},
+		{"/", "remove", "/"},
+		{"/a", "", "/a"},
+	}
+
+	for _, tc := range testCases {
+		if got := applyTrailingSlash(tc.path, tc.policy); got != tc.expected {
+			t.Errorf("applyTrailingSlash(%q, %q) = %q, expected %q", tc.path, tc.policy, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/jwt"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/signature"
+	"github.com/barisgenc/gatekeeper/internal/tlsutil"
+)
+
+// Headers carrying the HMAC upstream signature alongside cfg.Header
+const (
+	gatewayTimestampHeader = "X-Gateway-Timestamp"
+	gatewayKeyIDHeader     = "X-Gateway-Key-ID"
+)
+
+var errBodyTooLarge = errors.New("request body too large to sign")
+
+// upstreamSigner proves to backends that a request came through the
+// gateway. It signs the outgoing request as a RoundTripper, after the proxy
+// has rewritten the URL and host, so the signature covers exactly what the
+// backend receives.
+type upstreamSigner struct {
+	cfg config.UpstreamSignConfig
+	key interface{}
+	now func() time.Time
+	err error
+}
+
+func newUpstreamSigner(cfg config.UpstreamSignConfig) *upstreamSigner {
+	if cfg.Type == "" {
+		cfg.Type = "hmac"
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 60
+	}
+	if cfg.Issuer == "" {
+		cfg.Issuer = "gatekeeper"
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 10 << 20
+	}
+
+	s := &upstreamSigner{now: time.Now}
+
+	switch cfg.Type {
+	case "hmac":
+		if cfg.Algorithm == "" {
+			cfg.Algorithm = "sha256"
+		}
+		if cfg.Header == "" {
+			cfg.Header = "X-Gateway-Signature"
+		}
+		s.key, s.err = hmacKey("upstreamSigning", cfg.Secret)
+		if s.err == nil {
+			// Rejects an unknown algorithm before the first request
+			_, s.err = signature.Simple(cfg.Algorithm, s.key.([]byte), "GET", "/", "0", nil)
+		}
+	case "jwt":
+		if cfg.Algorithm == "" {
+			cfg.Algorithm = "HS256"
+		}
+		if cfg.Header == "" {
+			cfg.Header = "X-Gateway-Assertion"
+		}
+		s.key, s.err = jwtKey("upstreamSigning", cfg.Algorithm, cfg.KeyID, cfg.Secret, cfg.KeyFile)
+	default:
+		s.err = fmt.Errorf("upstreamSigning type %q must be hmac or jwt", cfg.Type)
+	}
+
+	s.cfg = cfg
+	if s.err != nil {
+		logger.Error("Upstream signing misconfigured, rejecting all requests: %v", s.err)
+		return s
+	}
+
+	logger.Info("Signing proxied requests with %s (%s) in %s", cfg.Type, cfg.Algorithm, cfg.Header)
+	return s
+}
+
+// hmacKey returns the key for secret; section names the settings in errors
+func hmacKey(section, secret string) (interface{}, error) {
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("%s secret must be at least 16 characters", section)
+	}
+	return []byte(secret), nil
+}
+
+// jwtKey returns the key for signing JWTs with algorithm: secret for HMAC
+// algorithms, else the private key in keyFile. It signs a token with it,
+// so algorithm and key type mismatches show before the first request.
+func jwtKey(section, algorithm, keyID, secret, keyFile string) (interface{}, error) {
+	var key interface{}
+	if strings.HasPrefix(algorithm, "HS") {
+		var err error
+		if key, err = hmacKey(section, secret); err != nil {
+			return nil, err
+		}
+	} else {
+		if keyFile == "" {
+			return nil, fmt.Errorf("%s algorithm %s requires a keyFile", section, algorithm)
+		}
+		data, err := tlsutil.ReadPEM(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if key, err = jwt.ParsePrivateKey(data); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := jwt.Sign(jwt.Claims{}, algorithm, keyID, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// transport signs requests to the named backend before handing them to next
+func (s *upstreamSigner) transport(next http.RoundTripper, backend string) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		signed, err := s.sign(req, backend)
+		if err != nil {
+			return nil, err
+		}
+		return next.RoundTrip(signed)
+	})
+}
+
+func (s *upstreamSigner) sign(req *http.Request, backend string) (*http.Request, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	req = req.Clone(req.Context())
+	now := s.now()
+
+	if s.cfg.Type == "jwt" {
+		claims := jwt.Claims{
+			"iss": s.cfg.Issuer,
+			"aud": backend,
+			"iat": now.Unix(),
+			"exp": now.Add(time.Duration(s.cfg.TTL) * time.Second).Unix(),
+			"jti": nonce(),
+			"htm": req.Method,
+			"htu": req.URL.RequestURI(),
+		}
+		token, err := jwt.Sign(claims, s.cfg.Algorithm, s.cfg.KeyID, s.key)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(s.cfg.Header, token)
+		return req, nil
+	}
+
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(req.Body, s.cfg.MaxBodyBytes+1))
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(body)) > s.cfg.MaxBodyBytes {
+			return nil, errBodyTooLarge
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	sig, err := signature.Simple(s.cfg.Algorithm, s.key.([]byte), req.Method, req.URL.RequestURI(), timestamp, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(s.cfg.Header, sig)
+	req.Header.Set(gatewayTimestampHeader, timestamp)
+	if s.cfg.KeyID != "" {
+		req.Header.Set(gatewayKeyIDHeader, s.cfg.KeyID)
+	} else {
+		req.Header.Del(gatewayKeyIDHeader)
+	}
+	return req, nil
+}
+
+// proxyError reports signing failures with a meaningful status and
+// everything else as the proxy's usual 502
+func (s *upstreamSigner) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+	case s.err != nil && errors.Is(err, s.err):
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		logger.Warn("Proxy error for %s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// nonce returns 128 random bits, hex encoded
+func nonce() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/jwt"
+	"github.com/barisgenc/gatekeeper/internal/signature"
+)
+
+const testSigningSecret = "0123456789abcdef0123"
+
+func signingGateway(t *testing.T, sign config.UpstreamSignConfig, backend http.HandlerFunc) *Gateway {
+	t.Helper()
+	srv := httptest.NewServer(backend)
+	t.Cleanup(srv.Close)
+
+	sign.Enabled = true
+	return New(&config.Config{
+		Backends:     []config.Backend{{Name: "api", URL: srv.URL, Weight: 1, Health: "/health"}},
+		RateLimit:    config.RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100},
+		UpstreamSign: sign,
+	})
+}
+
+func TestUpstreamHMACSignature(t *testing.T) {
+	var verified bool
+	gw := signingGateway(t, config.UpstreamSignConfig{Secret: testSigningSecret, KeyID: "gw1"},
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			want, _ := signature.Simple("sha256", []byte(testSigningSecret), r.Method, r.URL.RequestURI(),
+				r.Header.Get("X-Gateway-Timestamp"), body)
+			verified = signature.Equal(r.Header.Get("X-Gateway-Signature"), want) &&
+				r.Header.Get("X-Gateway-Key-ID") == "gw1" && string(body) == `{"a":1}`
+		})
+
+	req := httptest.NewRequest("POST", "/orders?id=7", strings.NewReader(`{"a":1}`))
+	req.Header.Set("X-Gateway-Signature", "forged")
+	rr := httptest.NewRecorder()
+	gw.proxyHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if !verified {
+		t.Error("backend could not verify the gateway signature")
+	}
+}
+
+func TestUpstreamSignatureBodyLimit(t *testing.T) {
+	gw := signingGateway(t, config.UpstreamSignConfig{Secret: testSigningSecret, MaxBodyBytes: 4},
+		func(w http.ResponseWriter, r *http.Request) {
+			t.Error("oversized request should not reach the backend")
+		})
+
+	rr := httptest.NewRecorder()
+	gw.proxyHandler(rr, httptest.NewRequest("POST", "/", strings.NewReader("too long")))
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d", rr.Code)
+	}
+}
+
+func TestUpstreamJWTAssertion(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var claims jwt.Claims
+	gw := signingGateway(t, config.UpstreamSignConfig{Type: "jwt", Algorithm: "ES256", KeyFile: keyFile, KeyID: "k1"},
+		func(w http.ResponseWriter, r *http.Request) {
+			claims, err = jwt.Parse(r.Header.Get("X-Gateway-Assertion"), func(h jwt.Header) (interface{}, error) {
+				return &key.PublicKey, nil
+			})
+		})
+
+	rr := httptest.NewRecorder()
+	gw.proxyHandler(rr, httptest.NewRequest("DELETE", "/orders/7", nil))
+
+	if err != nil {
+		t.Fatalf("backend rejected the assertion: %v", err)
+	}
+	if claims.String("iss") != "gatekeeper" || !claims.HasAudience("api") {
+		t.Errorf("unexpected issuer or audience: %v", claims)
+	}
+	if claims.String("htm") != "DELETE" || claims.String("htu") != "/orders/7" {
+		t.Errorf("assertion not bound to the request: %v", claims)
+	}
+}
+
+func TestUpstreamSignerMisconfigured(t *testing.T) {
+	gw := signingGateway(t, config.UpstreamSignConfig{Secret: "short"},
+		func(w http.ResponseWriter, r *http.Request) {
+			t.Error("request should not be proxied unsigned")
+		})
+
+	rr := httptest.NewRecorder()
+	gw.proxyHandler(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// staticHandler answers the route with files from its directory
+func staticHandler(route config.RouteConfig) http.Handler {
+	cfg := *route.Static
+	if cfg.Index == "" {
+		cfg.Index = "index.html"
+	}
+	root := http.Dir(cfg.Dir)
+	cacheControl := "no-cache"
+	if cfg.MaxAge > 0 {
+		cacheControl = "public, max-age=" + strconv.Itoa(cfg.MaxAge)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, route.PathPrefix))
+		if hidden(name) {
+			http.NotFound(w, r)
+			return
+		}
+
+		file, info, err := openStatic(root, name)
+		if err == nil && info.IsDir() {
+			if !strings.HasSuffix(r.URL.Path, "/") {
+				// Relative links in the index need the trailing slash
+				target := r.URL.Path + "/"
+				if r.URL.RawQuery != "" {
+					target += "?" + r.URL.RawQuery
+				}
+				file.Close()
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+				return
+			}
+			dir := file
+			file, info, err = openStatic(root, path.Join(name, cfg.Index))
+			if errors.Is(err, fs.ErrNotExist) && cfg.Listing {
+				defer dir.Close()
+				listDirectory(w, dir)
+				return
+			}
+			dir.Close()
+			w.Header().Set("Cache-Control", "no-cache")
+		} else if errors.Is(err, fs.ErrNotExist) && cfg.SPA && path.Ext(name) == "" {
+			file, info, err = openStatic(root, "/"+cfg.Index)
+			w.Header().Set("Cache-Control", "no-cache")
+		} else {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+
+		switch {
+		case errors.Is(err, fs.ErrNotExist), err == nil && info.IsDir():
+			w.Header().Del("Cache-Control")
+			http.NotFound(w, r)
+			return
+		case errors.Is(err, fs.ErrPermission):
+			w.Header().Del("Cache-Control")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		case err != nil:
+			w.Header().Del("Cache-Control")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+
+		w.Header().Set("ETag", fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+		http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+	})
+}
+
+func openStatic(root http.FileSystem, name string) (http.File, fs.FileInfo, error) {
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// hidden reports whether name has a dotfile or dot directory in it, such
+// as .git or .env, which are never served
+func hidden(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// listDirectory writes an HTML list of dir's visible entries
+func listDirectory(w http.ResponseWriter, dir http.File) {
+	entries, err := dir.Readdir(-1)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintln(w, "<!doctype html>\n<pre>")
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			name += "/"
+		}
+		link := url.URL{Path: name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", html.EscapeString(link.String()), html.EscapeString(name))
+	}
+	fmt.Fprintln(w, "</pre>")
+}
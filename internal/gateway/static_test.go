@@ -0,0 +1,103 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestStaticRoutes(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":          "<app>",
+		"assets/app.js":       "console.log(1)",
+		"docs/guide.txt":      "guide",
+		".env":                "SECRET=1",
+		"assets/.git/HEAD":    "ref",
+		"downloads/a.tar.gz":  "a",
+		"downloads/b & c.zip": "b",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "test", URL: "http://localhost:3000", Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Routes: []config.RouteConfig{
+			{Name: "files", PathPrefix: "/files", Static: &config.StaticConfig{Dir: dir, Listing: true}},
+			{Name: "app", PathPrefix: "/app", Static: &config.StaticConfig{Dir: dir, SPA: true, MaxAge: 3600}},
+		},
+	}
+	handler := New(cfg).Handler()
+
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		status       int
+		body         string
+		cacheControl string
+	}{
+		{"file", "GET", "/app/assets/app.js", 200, "console.log(1)", "public, max-age=3600"},
+		{"index", "GET", "/app/", 200, "<app>", "no-cache"},
+		{"spa fallback", "GET", "/app/orders/42", 200, "<app>", "no-cache"},
+		{"missing asset", "GET", "/app/assets/missing.js", 404, "", ""},
+		{"dotfile", "GET", "/app/.env", 404, "", ""},
+		{"dot directory", "GET", "/app/assets/.git/HEAD", 404, "", ""},
+		{"no listing", "GET", "/app/docs/", 404, "", ""},
+		{"no fallback", "GET", "/files/orders/42", 404, "", ""},
+		{"listing", "GET", "/files/downloads/", 200, `<a href="b%20&amp;%20c.zip">b &amp; c.zip</a>`, "no-cache"},
+		{"method", "POST", "/app/assets/app.js", 405, "", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+			if rr.Code != tc.status {
+				t.Fatalf("Expected %d, got %d: %s", tc.status, rr.Code, rr.Body.String())
+			}
+			if tc.body != "" && !strings.Contains(rr.Body.String(), tc.body) {
+				t.Errorf("Expected body with %q, got %q", tc.body, rr.Body.String())
+			}
+			if got := rr.Header().Get("Cache-Control"); got != tc.cacheControl {
+				t.Errorf("Expected Cache-Control %q, got %q", tc.cacheControl, got)
+			}
+		})
+	}
+
+	// Directories redirect to their trailing slash so relative links work
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/app", nil))
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/app/" {
+		t.Errorf("Expected a redirect to /app/, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	// Paths cannot climb out of the directory, even without the router
+	// cleaning them first
+	rr = httptest.NewRecorder()
+	staticHandler(cfg.Routes[0]).ServeHTTP(rr, httptest.NewRequest("GET", "/files/../../etc/passwd", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 outside the directory, got %d", rr.Code)
+	}
+
+	// Revalidation with the ETag gets a 304
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/app/assets/app.js", nil))
+	req := httptest.NewRequest("GET", "/app/assets/app.js", nil)
+	req.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching ETag, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/jwt"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/middleware"
+	"github.com/barisgenc/gatekeeper/internal/oauthtoken"
+)
+
+// errTokenRelay marks requests no backend token could be had for
+var errTokenRelay = errors.New("token relay")
+
+// tokenRelay replaces the client's credential with the one the backend
+// should see. Like the upstream signer it works on the outgoing request, so
+// it sees the backend the request is going to.
+type tokenRelay struct {
+	cfg    config.TokenRelayConfig
+	key    interface{}
+	tokens *oauthtoken.Client
+	now    func() time.Time
+	err    error
+}
+
+func newTokenRelay(cfg config.TokenRelayConfig) *tokenRelay {
+	if cfg.Header == "" {
+		cfg.Header = "Authorization"
+	}
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = "HS256"
+	}
+	if cfg.Issuer == "" {
+		cfg.Issuer = "gatekeeper"
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 60
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5
+	}
+
+	t := &tokenRelay{cfg: cfg, now: time.Now}
+
+	switch cfg.Mode {
+	case "passThrough", "strip":
+	case "mint":
+		t.key, t.err = jwtKey("tokenRelay", cfg.Algorithm, cfg.KeyID, cfg.Secret, cfg.KeyFile)
+	case "clientCredentials", "exchange":
+		t.tokens = oauthtoken.NewClient(cfg.TokenURL, cfg.ClientID, cfg.ClientSecret, cfg.Scopes,
+			time.Duration(cfg.Timeout)*time.Second)
+	default:
+		t.err = fmt.Errorf("tokenRelay mode %q must be passThrough, strip, mint, clientCredentials or exchange", cfg.Mode)
+	}
+
+	if t.err != nil {
+		logger.Error("Token relay misconfigured, rejecting all requests: %v", t.err)
+		return t
+	}
+	logger.Info("Relaying credentials to backends in %s mode", cfg.Mode)
+	return t
+}
+
+// transport relays credentials on requests to the named backend before
+// handing them to next
+func (t *tokenRelay) transport(next http.RoundTripper, backend string) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		relayed, err := t.relay(req, backend)
+		if err != nil {
+			return nil, err
+		}
+		return next.RoundTrip(relayed)
+	})
+}
+
+func (t *tokenRelay) relay(req *http.Request, backend string) (*http.Request, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	if t.cfg.Mode == "passThrough" {
+		return req, nil
+	}
+
+	inbound := bearer(req.Header.Get("Authorization"))
+	req = req.Clone(req.Context())
+	req.Header.Del("Authorization")
+	req.Header.Del(t.cfg.Header)
+
+	var token string
+	var err error
+	switch t.cfg.Mode {
+	case "mint":
+		principal, ok := middleware.IdentityFromContext(req.Context())
+		if !ok {
+			return req, nil
+		}
+		token, err = t.mint(principal, backend)
+	case "clientCredentials":
+		token, err = t.tokens.ClientCredentials(req.Context(), t.audience(backend))
+	case "exchange":
+		if inbound == "" {
+			return req, nil
+		}
+		token, err = t.tokens.Exchange(req.Context(), inbound, t.audience(backend))
+	default:
+		return req, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w for %s: %v", errTokenRelay, backend, err)
+	}
+
+	if strings.EqualFold(t.cfg.Header, "Authorization") {
+		token = "Bearer " + token
+	}
+	req.Header.Set(t.cfg.Header, token)
+	return req, nil
+}
+
+// mint signs a short-lived token for principal, meant for backend
+func (t *tokenRelay) mint(principal, backend string) (string, error) {
+	now := t.now()
+	return jwt.Sign(jwt.Claims{
+		"iss": t.cfg.Issuer,
+		"sub": principal,
+		"aud": t.audience(backend),
+		"iat": now.Unix(),
+		"exp": now.Add(time.Duration(t.cfg.TTL) * time.Second).Unix(),
+		"jti": nonce(),
+	}, t.cfg.Algorithm, t.cfg.KeyID, t.key)
+}
+
+func (t *tokenRelay) audience(backend string) string {
+	if audience, ok := t.cfg.Audiences[backend]; ok {
+		return audience
+	}
+	return backend
+}
+
+// bearer returns the token in an Authorization header value, or "" when it
+// holds no bearer token
+func bearer(auth string) string {
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// proxyError answers token relay failures and leaves everything else to
+// the upstream signer, or to the proxy's usual 502
+func (gw *Gateway) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case gw.relay != nil && gw.relay.err != nil && errors.Is(err, gw.relay.err):
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	case errors.Is(err, errTokenRelay):
+		logger.Warn("No backend token for %s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	case gw.signer != nil:
+		gw.signer.proxyError(w, r, err)
+	default:
+		logger.Warn("Proxy error for %s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/jwt"
+	"github.com/barisgenc/gatekeeper/internal/signature"
+)
+
+func relayGateway(t *testing.T, cfg *config.Config, relay config.TokenRelayConfig, backend http.HandlerFunc) *Gateway {
+	t.Helper()
+	srv := httptest.NewServer(backend)
+	t.Cleanup(srv.Close)
+
+	relay.Enabled = true
+	cfg.Backends = []config.Backend{{Name: "orders", URL: srv.URL, Weight: 1, Health: "/health"}}
+	cfg.RateLimit = config.RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100}
+	cfg.TokenRelay = relay
+	return New(cfg)
+}
+
+func TestTokenRelayMint(t *testing.T) {
+	var claims jwt.Claims
+	var parseErr error
+	gw := relayGateway(t, &config.Config{
+		HMAC: config.HMACConfig{
+			Enabled:   true,
+			Consumers: []config.HMACConsumer{{ID: "billing", Secret: testSigningSecret}},
+		},
+	}, config.TokenRelayConfig{
+		Mode:      "mint",
+		Secret:    testSigningSecret,
+		Audiences: map[string]string{"orders": "https://orders.internal"},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		claims, parseErr = jwt.Parse(token, func(jwt.Header) (interface{}, error) {
+			return []byte(testSigningSecret), nil
+		})
+	})
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	sig, _ := signature.Simple("sha256", []byte(testSigningSecret), "GET", "/orders/7", timestamp, nil)
+	req := httptest.NewRequest("GET", "/orders/7", nil)
+	req.Header.Set("X-Key-ID", "billing")
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("X-Signature", sig)
+	req.Header.Set("Authorization", "Bearer from-the-client")
+	rr := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if parseErr != nil {
+		t.Fatalf("Expected the backend to receive a minted token, got %v", parseErr)
+	}
+	if claims.String("sub") != "hmac:billing" || !claims.HasAudience("https://orders.internal") || claims.String("iss") != "gatekeeper" {
+		t.Errorf("Expected a token for hmac:billing meant for the orders backend, got %v", claims)
+	}
+}
+
+func TestTokenRelayModes(t *testing.T) {
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.PostFormValue("grant_type") {
+		case "client_credentials":
+			fmt.Fprintf(w, `{"access_token":"gateway-for-%s","expires_in":3600}`, r.PostFormValue("audience"))
+		default:
+			if r.PostFormValue("subject_token") == "revoked" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"access_token":"%s-for-%s","expires_in":3600}`, r.PostFormValue("subject_token"), r.PostFormValue("audience"))
+		}
+	}))
+	defer tokens.Close()
+
+	testCases := []struct {
+		name     string
+		relay    config.TokenRelayConfig
+		inbound  string
+		wantCode int
+		want     string
+	}{
+		{"pass through", config.TokenRelayConfig{Mode: "passThrough"}, "Bearer alice", http.StatusOK, "Bearer alice"},
+		{"strip", config.TokenRelayConfig{Mode: "strip"}, "Bearer alice", http.StatusOK, ""},
+		{"client credentials", config.TokenRelayConfig{Mode: "clientCredentials", TokenURL: tokens.URL}, "Bearer alice", http.StatusOK, "Bearer gateway-for-orders"},
+		{"exchange", config.TokenRelayConfig{Mode: "exchange", TokenURL: tokens.URL}, "Bearer alice", http.StatusOK, "Bearer alice-for-orders"},
+		{"exchange without a token", config.TokenRelayConfig{Mode: "exchange", TokenURL: tokens.URL}, "Basic YWxpY2U6cHc=", http.StatusOK, ""},
+		{"exchange refused", config.TokenRelayConfig{Mode: "exchange", TokenURL: tokens.URL}, "Bearer revoked", http.StatusServiceUnavailable, ""},
+		{"mint without a key", config.TokenRelayConfig{Mode: "mint"}, "Bearer alice", http.StatusInternalServerError, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			var proxied bool
+			gw := relayGateway(t, &config.Config{}, tc.relay, func(w http.ResponseWriter, r *http.Request) {
+				proxied = true
+				got = r.Header.Get("Authorization")
+			})
+
+			req := httptest.NewRequest("GET", "/orders/7", nil)
+			req.Header.Set("Authorization", tc.inbound)
+			rr := httptest.NewRecorder()
+			gw.proxyHandler(rr, req)
+
+			if rr.Code != tc.wantCode {
+				t.Fatalf("Expected %d, got %d", tc.wantCode, rr.Code)
+			}
+			if tc.wantCode == http.StatusOK && got != tc.want {
+				t.Errorf("Expected the backend to get Authorization %q, got %q", tc.want, got)
+			}
+			if tc.wantCode != http.StatusOK && proxied {
+				t.Error("Expected the request not to reach the backend")
+			}
+		})
+	}
+}
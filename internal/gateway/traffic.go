@@ -0,0 +1,77 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/accesslog"
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/routematch"
+	"github.com/barisgenc/gatekeeper/internal/traffic"
+)
+
+// routeTrafficHandler counts body bytes for everything the route serves,
+// including redirects and cached responses that never reach a backend
+func (gw *Gateway) routeTrafficHandler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := traffic.NewCountingReader(r.Body)
+		r.Body = body
+		rw := metrics.NewResponseWriter(w)
+
+		next.ServeHTTP(rw, r)
+
+		metrics.RecordRouteBytes(name, body.Count(), rw.BytesWritten())
+		gw.routeBytes.Add(name, body.Count(), rw.BytesWritten())
+	})
+}
+
+// defaultRoute labels requests no configured route matched
+const defaultRoute = "default"
+
+// routeKey carries the route label to the proxy
+type routeKey struct{}
+
+// routeMetricsHandler records the route's requests, their total duration
+// and body sizes, and how many are in flight, and counts them against the
+// route's SLOs. The access log learns the route too.
+func (gw *Gateway) routeMetricsHandler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		accesslog.SetRoute(r.Context(), name)
+		metrics.AddRouteInFlight(name, 1)
+		defer metrics.AddRouteInFlight(name, -1)
+
+		body := traffic.NewCountingReader(r.Body)
+		r.Body = body
+		rw := metrics.NewResponseWriter(w)
+
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), routeKey{}, name)))
+
+		duration := time.Since(start)
+		metrics.RecordRouteRequest(name, r.Method, rw.StatusCode(), duration, body.Count(), rw.BytesWritten())
+		gw.slos.Record(name, rw.Status(), duration)
+	})
+}
+
+// routeName returns the label of the route serving r
+func routeName(r *http.Request) string {
+	if name, ok := r.Context().Value(routeKey{}).(string); ok {
+		return name
+	}
+	return defaultRoute
+}
+
+// routeLabel names a route in metrics and stats, falling back to its
+// pattern when it has no name
+func routeLabel(route config.RouteConfig, pattern routematch.Pattern) string {
+	if route.Name != "" {
+		return route.Name
+	}
+	label := pattern.String()
+	if route.Host != "" {
+		label = route.Host + label
+	}
+	return label
+}
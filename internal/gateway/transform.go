@@ -0,0 +1,139 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/transform"
+)
+
+// responseTransformHandler rewrites the route's JSON responses. They are
+// held back until complete, up to the size cap; other responses stream
+// through untouched.
+func responseTransformHandler(label string, route config.RouteConfig, next http.Handler) http.Handler {
+	cfg := *route.ResponseTransform
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+
+	t, err := transform.New(cfg)
+	if err != nil {
+		logger.Error("Route %s response transform misconfigured, rejecting all requests: %v", label, err)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The transport then decompresses the backend's response for us
+		r.Header.Del("Accept-Encoding")
+
+		tw := &transformWriter{ResponseWriter: w, head: r.Method == http.MethodHead, max: cfg.MaxBodyBytes}
+		next.ServeHTTP(tw, r)
+		if !tw.buffering {
+			return
+		}
+
+		body, reason, err := tw.rewrite(t)
+		if err != nil {
+			metrics.RecordResponseTransformFailure(label, reason)
+			logger.Warn("Response for %s %s on route %s could not be transformed: %v", r.Method, r.URL.Path, label, err)
+			header := w.Header()
+			for name := range header {
+				delete(header, name)
+			}
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(tw.status)
+		w.Write(body)
+	})
+}
+
+// transformWriter collects a JSON response for rewriting and passes any
+// other response straight through
+type transformWriter struct {
+	http.ResponseWriter
+	head        bool
+	max         int64
+	wroteHeader bool
+	buffering   bool
+	status      int
+	body        bytes.Buffer
+	tooLarge    bool
+}
+
+func (tw *transformWriter) WriteHeader(status int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	tw.status = status
+
+	bodyless := tw.head || status == http.StatusNoContent || status == http.StatusNotModified
+	if !bodyless && isJSON(tw.Header().Get("Content-Type")) {
+		tw.buffering = true
+		return
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *transformWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if !tw.buffering {
+		return tw.ResponseWriter.Write(b)
+	}
+	// Keep reading so the backend connection can be reused, but the
+	// response is lost
+	if tw.tooLarge || int64(tw.body.Len()+len(b)) > tw.max {
+		tw.tooLarge = true
+		tw.body.Reset()
+		return len(b), nil
+	}
+	return tw.body.Write(b)
+}
+
+// Flush lets streamed responses that are not rewritten keep streaming
+func (tw *transformWriter) Flush() {
+	if tw.wroteHeader && !tw.buffering {
+		http.NewResponseController(tw.ResponseWriter).Flush()
+	}
+}
+
+// rewrite applies t to the collected body, or says why it cannot
+func (tw *transformWriter) rewrite(t *transform.Transform) ([]byte, string, error) {
+	if tw.tooLarge {
+		return nil, "too_large", errors.New("body is larger than maxBodyBytes")
+	}
+	if encoding := tw.Header().Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return nil, "encoded", fmt.Errorf("body has content encoding %s", encoding)
+	}
+	if tw.body.Len() == 0 {
+		return nil, "", nil
+	}
+	body, err := t.Apply(tw.body.Bytes())
+	if err != nil {
+		return nil, "invalid_json", err
+	}
+	return body, "", nil
+}
+
+// isJSON reports whether contentType is application/json or a +json type
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,89 @@
+package gateway
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestResponseTransformRoutes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/1":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Write([]byte(`{"name":"Ada","ssn":"123-45-6789","password":"hunter2"}`))
+		case "/users/big":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"ssn":"` + strings.Repeat("1", 100) + `"}`))
+		case "/users/broken":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"ssn":"123`))
+		case "/users/gzip":
+			// The client's Accept-Encoding is dropped and the transport
+			// decompresses what it asked for itself
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte(`{"ssn":"123"}`))
+			gz.Close()
+		case "/users/brotli":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte{0x1b, 0x0b})
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("ssn: 123-45-6789"))
+		}
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "test", URL: backend.URL, Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Routes: []config.RouteConfig{{
+			Name:       "users",
+			PathPrefix: "/users",
+			ResponseTransform: &config.ResponseTransformConfig{
+				Drop:         []string{"password"},
+				Mask:         []string{"ssn"},
+				Rename:       map[string]string{"name": "fullName"},
+				MaxBodyBytes: 64,
+			},
+		}},
+	}
+	handler := New(cfg).Handler()
+
+	testCases := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"rewritten", "/users/1", http.StatusOK, `{"fullName":"Ada","ssn":"***"}`},
+		{"decompressed", "/users/gzip", http.StatusOK, `{"ssn":"***"}`},
+		{"too large", "/users/big", http.StatusBadGateway, "Bad Gateway\n"},
+		{"invalid json", "/users/broken", http.StatusBadGateway, "Bad Gateway\n"},
+		{"unknown encoding", "/users/brotli", http.StatusBadGateway, "Bad Gateway\n"},
+		{"not json", "/users/1.txt", http.StatusOK, "ssn: 123-45-6789"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status %v, got %v", tc.expectedStatus, rr.Code)
+			}
+			if rr.Body.String() != tc.expectedBody {
+				t.Errorf("Expected body %q, got %q", tc.expectedBody, rr.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// newUpstreamTransport is the transport shared by every backend request.
+// Sharing it keeps idle connections pooled, and its TLS session cache lets
+// new connections to a backend resume instead of doing a full handshake.
+func newUpstreamTransport(cfg config.UpstreamTLSConfig) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{}
+
+	if !cfg.DisableSessionResumption {
+		size := cfg.SessionCacheSize
+		if size <= 0 {
+			size = 256
+		}
+		transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(size)
+	} else {
+		transport.TLSClientConfig.SessionTicketsDisabled = true
+	}
+
+	return transport
+}
+
+// withUpstreamTrace records connection reuse and TLS handshakes for the
+// outgoing request to backend
+func withUpstreamTrace(r *http.Request, backend string) *http.Request {
+	var handshakeStart time.Time
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			metrics.RecordUpstreamConnection(backend, info.Reused)
+		},
+		TLSHandshakeStart: func() {
+			handshakeStart = time.Now()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err == nil {
+				metrics.RecordUpstreamTLSHandshake(backend, state.DidResume, time.Since(handshakeStart))
+			}
+		},
+	}
+	return r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+}
@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestUpstreamTransportResumesSessions(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer backend.Close()
+	// Force a new connection per request so every request handshakes
+	backend.Config.SetKeepAlivesEnabled(false)
+
+	resumed := func(transport *http.Transport) []bool {
+		transport.TLSClientConfig.RootCAs = backend.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+		var results []bool
+		trace := &httptrace.ClientTrace{
+			TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+				results = append(results, state.DidResume)
+			},
+		}
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest("GET", backend.URL, nil)
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+		return results
+	}
+
+	results := resumed(newUpstreamTransport(config.UpstreamTLSConfig{}))
+	if len(results) != 2 || results[0] || !results[1] {
+		t.Errorf("Expected a full handshake then a resumed one, got %v", results)
+	}
+
+	results = resumed(newUpstreamTransport(config.UpstreamTLSConfig{DisableSessionResumption: true}))
+	if len(results) != 2 || results[0] || results[1] {
+		t.Errorf("Expected no resumption when disabled, got %v", results)
+	}
+}
@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// versionKey carries the backends of the request's API version to the proxy
+type versionKey struct{}
+
+// apiVersions resolves the API version of requests
+type apiVersions struct {
+	header   string
+	fallback string
+	strip    bool
+	versions map[string]apiVersion
+}
+
+type apiVersion struct {
+	backends    []string
+	deprecation http.Header
+}
+
+func newAPIVersions(cfg config.VersioningConfig) *apiVersions {
+	v := &apiVersions{
+		header:   cfg.Header,
+		fallback: cfg.Default,
+		strip:    cfg.StripPrefix,
+		versions: make(map[string]apiVersion, len(cfg.Versions)),
+	}
+	if v.header == "" {
+		v.header = "Accept-Version"
+	}
+	for _, version := range cfg.Versions {
+		entry := apiVersion{backends: version.Backends}
+		if version.Deprecation != nil {
+			entry.deprecation = deprecationHeaders(*version.Deprecation)
+		}
+		v.versions[version.Name] = entry
+	}
+	return v
+}
+
+// withVersions sends each request with an API version to that version's
+// backends, stripping the version from the path first when configured
+func (gw *Gateway) withVersions(next http.Handler) http.Handler {
+	if gw.versions == nil {
+		return next
+	}
+	v := gw.versions
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name, rest, inPath := v.fromPath(r.URL.Path)
+		if !inPath {
+			name = r.Header.Get(v.header)
+		}
+		if name == "" {
+			name = v.fallback
+		}
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		version, ok := v.versions[name]
+		if !ok {
+			http.Error(w, "Unsupported API version", http.StatusBadRequest)
+			return
+		}
+
+		r = r.Clone(context.WithValue(r.Context(), versionKey{}, version.backends))
+		if inPath && v.strip {
+			r.URL.Path, r.URL.RawPath = rest, ""
+			r.RequestURI = r.URL.RequestURI()
+		}
+		r.Header.Set(v.header, name)
+		for header, values := range version.deprecation {
+			w.Header()[header] = values
+		}
+		metrics.RecordAPIVersion(name, version.deprecation != nil)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fromPath returns the version named by path's first segment and the path
+// without it
+func (v *apiVersions) fromPath(path string) (name, rest string, ok bool) {
+	segment, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if _, ok := v.versions[segment]; !ok {
+		return "", path, false
+	}
+	return segment, "/" + rest, true
+}
+
+// versionBackends returns the backends r's API version is limited to, nil
+// for any
+func versionBackends(r *http.Request) []string {
+	backends, _ := r.Context().Value(versionKey{}).([]string)
+	return backends
+}
+
+// deprecationHandler announces that the route is deprecated on every
+// response
+func deprecationHandler(route config.RouteConfig, next http.Handler) http.Handler {
+	headers := deprecationHeaders(*route.Deprecation)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for header, values := range headers {
+			w.Header()[header] = values
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deprecationHeaders builds the Deprecation, Sunset and Link headers of d,
+// which has been validated
+func deprecationHeaders(d config.DeprecationConfig) http.Header {
+	since, sunset, _ := d.Dates()
+	headers := http.Header{"Deprecation": {"true"}}
+	if !since.IsZero() {
+		headers.Set("Deprecation", "@"+strconv.FormatInt(since.Unix(), 10))
+	}
+	if !sunset.IsZero() {
+		headers.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.Link != "" {
+		headers.Set("Link", "<"+d.Link+`>; rel="deprecation"; type="text/html"`)
+	}
+	return headers
+}
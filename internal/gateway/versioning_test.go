@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestVersionRouting(t *testing.T) {
+	serve := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name+" "+r.URL.Path+" "+r.Header.Get("Accept-Version"))
+		}))
+	}
+	v1, v2 := serve("v1"), serve("v2")
+	defer v1.Close()
+	defer v2.Close()
+
+	cfg := &config.Config{
+		Backends: []config.Backend{
+			{Name: "users-v1", URL: v1.URL, Weight: 100},
+			{Name: "users-v2", URL: v2.URL, Weight: 100},
+		},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Versioning: config.VersioningConfig{
+			Enabled:     true,
+			Default:     "v2",
+			StripPrefix: true,
+			Versions: []config.APIVersion{
+				{Name: "v1", Backends: []string{"users-v1"}, Deprecation: &config.DeprecationConfig{
+					Since: "2024-01-01", Sunset: "2024-12-31", Link: "https://docs.example.com/v2",
+				}},
+				{Name: "v2", Backends: []string{"users-v2"}},
+			},
+		},
+	}
+	handler := New(cfg).Handler()
+
+	testCases := []struct {
+		name   string
+		path   string
+		header string
+		status int
+		body   string
+		sunset string
+	}{
+		{"path", "/v1/users", "", 200, "v1 /users v1", "Tue, 31 Dec 2024 00:00:00 GMT"},
+		{"path wins over header", "/v2/users", "v1", 200, "v2 /users v2", ""},
+		{"header", "/users", "v1", 200, "v1 /users v1", "Tue, 31 Dec 2024 00:00:00 GMT"},
+		{"default", "/users", "", 200, "v2 /users v2", ""},
+		{"unknown", "/users", "v9", 400, "", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			if tc.header != "" {
+				req.Header.Set("Accept-Version", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tc.status || (tc.body != "" && rr.Body.String() != tc.body) {
+				t.Fatalf("Expected %d %q, got %d %q", tc.status, tc.body, rr.Code, rr.Body.String())
+			}
+			if got := rr.Header().Get("Sunset"); got != tc.sunset {
+				t.Errorf("Expected Sunset %q, got %q", tc.sunset, got)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/users", nil))
+	if got := rr.Header().Get("Deprecation"); got != "@1704067200" {
+		t.Errorf("Expected Deprecation @1704067200, got %q", got)
+	}
+	if got := rr.Header().Get("Link"); got != `<https://docs.example.com/v2>; rel="deprecation"; type="text/html"` {
+		t.Errorf("Expected a deprecation Link, got %q", got)
+	}
+}
+
+func TestDeprecatedRoute(t *testing.T) {
+	cfg := &config.Config{
+		Backends:  []config.Backend{{Name: "test", URL: "http://localhost:3000", Weight: 100}},
+		RateLimit: config.RateLimitConfig{RequestsPerMinute: 1000, BurstSize: 100},
+		Routes: []config.RouteConfig{
+			{Name: "legacy", PathPrefix: "/legacy", Deprecation: &config.DeprecationConfig{}, Redirect: &config.RedirectConfig{To: "/new"}},
+		},
+	}
+	rr := httptest.NewRecorder()
+	New(cfg).Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/legacy/report", nil))
+	if got := rr.Header().Get("Deprecation"); got != "true" || rr.Header().Get("Sunset") != "" {
+		t.Errorf("Expected Deprecation: true without Sunset, got %v", rr.Header())
+	}
+}
@@ -0,0 +1,87 @@
+package grpcclient
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newTestServer serves a cleartext HTTP/2 gRPC endpoint that echoes the
+// request message back, or fails with the status in the message text.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		message := body[5:]
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		if strings.HasPrefix(string(message), "fail") {
+			w.Header().Set("Grpc-Status", "7")
+			w.Header().Set("Grpc-Message", "permission%20denied")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		frame := make([]byte, 5+len(message))
+		binary.BigEndian.PutUint32(frame[1:5], uint32(len(message)))
+		copy(frame[5:], message)
+		w.Write(frame)
+		w.Header().Set("Grpc-Status", "0")
+	})
+
+	return httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+}
+
+func TestInvoke(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	// Cleartext HTTP/2, as the gateway's transports speak it to h2c backends
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	client := NewWithTransport(server.URL, transport, time.Second)
+
+	resp, err := client.Invoke(context.Background(), "/test.Echo/Echo", []byte("hello"))
+	if err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	if string(resp) != "hello" {
+		t.Errorf("Expected echoed message, got %q", resp)
+	}
+
+	_, err = client.Invoke(context.Background(), "/test.Echo/Echo", []byte("fail"))
+	var status *Status
+	if !errors.As(err, &status) || status.Code != 7 || status.Message != "permission denied" {
+		t.Errorf("Expected PERMISSION_DENIED status, got %v", err)
+	}
+}
+
+func TestDialRejectsUnknownScheme(t *testing.T) {
+	if _, err := Dial("http://localhost:9000"); err == nil {
+		t.Error("Expected http:// target to be rejected")
+	}
+	if _, err := Dial("grpc://"); err == nil {
+		t.Error("Expected a target without a host to be rejected")
+	}
+}
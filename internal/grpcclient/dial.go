@@ -0,0 +1,35 @@
+package grpcclient
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Dial returns a connection to target, "grpc://host:port" for cleartext
+// HTTP/2 or "grpcs://host:port" for TLS. It connects on the first call and
+// reconnects as needed, so it only fails on a malformed target.
+func Dial(target string) (*grpc.ClientConn, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("grpc: target %q has no host", target)
+	}
+
+	var creds credentials.TransportCredentials
+	switch u.Scheme {
+	case "grpc":
+		creds = insecure.NewCredentials()
+	case "grpcs":
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	default:
+		return nil, fmt.Errorf("grpc: unsupported scheme %q", u.Scheme)
+	}
+	return grpc.NewClient(u.Host, grpc.WithTransportCredentials(creds))
+}
@@ -0,0 +1,91 @@
+// Package idempotency remembers responses by Idempotency-Key so retried
+// requests get the original answer instead of running twice. The memory
+// store suits a single gateway; the Redis store shares keys across
+// replicas.
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Record is what is stored under a key: the request fingerprint and, once
+// the first request has finished, its response
+type Record struct {
+	Fingerprint string      `json:"fingerprint"`
+	Done        bool        `json:"done"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// Store keeps idempotency records
+type Store interface {
+	// Claim reserves key for a request with fingerprint for up to lockTTL.
+	// When the key is already taken it returns the existing record and
+	// false.
+	Claim(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (Record, bool, error)
+	// Complete stores the finished response under key for ttl
+	Complete(ctx context.Context, key string, record Record, ttl time.Duration) error
+	// Release drops a claim so the request can be retried
+	Release(ctx context.Context, key string) error
+}
+
+// MemoryStore keeps records in process
+type MemoryStore struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	records   map[string]*entry
+	lastSweep time.Time
+}
+
+type entry struct {
+	record  Record
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{now: time.Now, records: make(map[string]*entry)}
+}
+
+func (s *MemoryStore) Claim(_ context.Context, key, fingerprint string, lockTTL time.Duration) (Record, bool, error) {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.records {
+			if !now.Before(e.expires) {
+				delete(s.records, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if e, ok := s.records[key]; ok && now.Before(e.expires) {
+		return e.record, false, nil
+	}
+	s.records[key] = &entry{record: Record{Fingerprint: fingerprint}, expires: now.Add(lockTTL)}
+	return Record{}, true, nil
+}
+
+func (s *MemoryStore) Complete(_ context.Context, key string, record Record, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record.Done = true
+	s.records[key] = &entry{record: record, expires: s.now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
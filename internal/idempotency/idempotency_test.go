@@ -0,0 +1,75 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/barisgenc/gatekeeper/internal/kv"
+)
+
+func testStore(t *testing.T, store Store, advance func(time.Duration)) {
+	ctx := context.Background()
+
+	if _, claimed, err := store.Claim(ctx, "k1", "fp", time.Minute); err != nil || !claimed {
+		t.Fatalf("expected to claim a new key, got %v %v", claimed, err)
+	}
+
+	record, claimed, err := store.Claim(ctx, "k1", "fp", time.Minute)
+	if err != nil || claimed || record.Done || record.Fingerprint != "fp" {
+		t.Fatalf("expected the pending claim back, got %+v %v %v", record, claimed, err)
+	}
+
+	response := Record{Fingerprint: "fp", Status: 201, Header: http.Header{"Location": {"/charges/1"}}, Body: []byte(`{"id":1}`)}
+	if err := store.Complete(ctx, "k1", response, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	record, claimed, _ = store.Claim(ctx, "k1", "fp", time.Minute)
+	if claimed || !record.Done || record.Status != 201 || string(record.Body) != `{"id":1}` || record.Header.Get("Location") != "/charges/1" {
+		t.Fatalf("expected the stored response, got %+v", record)
+	}
+
+	// Released claims can be retried, and abandoned ones expire
+	store.Claim(ctx, "k2", "fp", time.Minute)
+	store.Release(ctx, "k2")
+	if _, claimed, _ := store.Claim(ctx, "k2", "fp", time.Minute); !claimed {
+		t.Error("expected a released key to be claimable")
+	}
+	advance(time.Minute)
+	if _, claimed, _ := store.Claim(ctx, "k2", "fp", time.Minute); !claimed {
+		t.Error("expected an abandoned claim to expire")
+	}
+
+	advance(time.Hour)
+	if _, claimed, _ := store.Claim(ctx, "k1", "fp", time.Minute); !claimed {
+		t.Error("expected the stored response to expire after its TTL")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+
+	testStore(t, store, func(d time.Duration) { now = now.Add(d) })
+}
+
+func TestRedisStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	testStore(t, NewRedisStore(client, ""), server.FastForward)
+}
+
+func TestKVStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	testStore(t, NewKVStore(kv.NewRedisStore(client, "")), server.FastForward)
+}
@@ -0,0 +1,65 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/kv"
+)
+
+// KVStore keeps records as JSON in the gateway's shared storage under
+// "idempotency:<key>"
+type KVStore struct {
+	store kv.Store
+}
+
+func NewKVStore(store kv.Store) *KVStore {
+	return &KVStore{store: store}
+}
+
+const kvPrefix = "idempotency:"
+
+func (s *KVStore) Claim(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (Record, bool, error) {
+	pending, err := json.Marshal(Record{Fingerprint: fingerprint})
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	for {
+		claimed, err := s.store.Add(ctx, kvPrefix+key, pending, lockTTL)
+		if err != nil {
+			return Record{}, false, err
+		}
+		if claimed {
+			return Record{}, true, nil
+		}
+
+		value, ok, err := s.store.Get(ctx, kvPrefix+key)
+		if err != nil {
+			return Record{}, false, err
+		}
+		if !ok {
+			continue // expired or released since Add; try again
+		}
+		var record Record
+		if err := json.Unmarshal(value, &record); err != nil {
+			return Record{}, false, err
+		}
+		return record, false, nil
+	}
+}
+
+func (s *KVStore) Complete(ctx context.Context, key string, record Record, ttl time.Duration) error {
+	record.Done = true
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.store.Set(ctx, kvPrefix+key, value, ttl)
+}
+
+func (s *KVStore) Release(ctx context.Context, key string) error {
+	_, err := s.store.Delete(ctx, kvPrefix+key)
+	return err
+}
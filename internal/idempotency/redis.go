@@ -0,0 +1,66 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore shares records between gateway replicas. Records are stored
+// as JSON under "<prefix><key>" and expire on their own.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = "gatekeeper:idempotency:"
+	}
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Claim(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (Record, bool, error) {
+	pending, err := json.Marshal(Record{Fingerprint: fingerprint})
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	for {
+		claimed, err := s.client.SetNX(ctx, s.prefix+key, pending, lockTTL).Result()
+		if err != nil {
+			return Record{}, false, err
+		}
+		if claimed {
+			return Record{}, true, nil
+		}
+
+		value, err := s.client.Get(ctx, s.prefix+key).Bytes()
+		if err == redis.Nil {
+			continue // expired or released since SETNX; try again
+		}
+		if err != nil {
+			return Record{}, false, err
+		}
+		var record Record
+		if err := json.Unmarshal(value, &record); err != nil {
+			return Record{}, false, err
+		}
+		return record, false, nil
+	}
+}
+
+func (s *RedisStore) Complete(ctx context.Context, key string, record Record, ttl time.Duration) error {
+	record.Done = true
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.prefix+key, value, ttl).Err()
+}
+
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.prefix+key).Err()
+}
@@ -0,0 +1,122 @@
+// Package incident detects backend 5xx storms. While an incident is open
+// the gateway tags responses and logs with its ID, serves stale cached
+// errors and sheds low-priority traffic.
+package incident
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// Incident is an open incident
+type Incident struct {
+	ID    string    `json:"id"`
+	Since time.Time `json:"since"`
+}
+
+type incidentKey struct{}
+
+// WithIncident marks a request as served during an incident
+func WithIncident(ctx context.Context, inc Incident) context.Context {
+	return context.WithValue(ctx, incidentKey{}, inc)
+}
+
+// FromContext returns the incident open when the request arrived
+func FromContext(ctx context.Context) (Incident, bool) {
+	inc, ok := ctx.Value(incidentKey{}).(Incident)
+	return inc, ok
+}
+
+type bucket struct {
+	second        int64
+	total, errors int
+}
+
+// Detector opens an incident when at least threshold of the requests in
+// the window failed with a 5xx, and closes it once the ratio drops below
+// half the threshold and the incident has lasted minDuration
+type Detector struct {
+	threshold   float64
+	minRequests int
+	minDuration time.Duration
+	now         func() time.Time
+
+	mu       sync.Mutex
+	buckets  []bucket
+	lastEval int64
+	current  *Incident
+}
+
+func NewDetector(threshold float64, window, minRequests int, minDuration time.Duration) *Detector {
+	return &Detector{
+		threshold:   threshold,
+		minRequests: minRequests,
+		minDuration: minDuration,
+		now:         time.Now,
+		buckets:     make([]bucket, window),
+	}
+}
+
+// Record counts a response status
+func (d *Detector) Record(status int) {
+	second := d.now().Unix()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	b := &d.buckets[second%int64(len(d.buckets))]
+	if b.second != second {
+		*b = bucket{second: second}
+	}
+	b.total++
+	if status >= 500 {
+		b.errors++
+	}
+}
+
+// Current returns the open incident, re-evaluating at most once a second
+func (d *Detector) Current() (Incident, bool) {
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if second := now.Unix(); second != d.lastEval {
+		d.lastEval = second
+		d.evaluate(now)
+	}
+	if d.current == nil {
+		return Incident{}, false
+	}
+	return *d.current, true
+}
+
+func (d *Detector) evaluate(now time.Time) {
+	var total, errors int
+	for _, b := range d.buckets {
+		if now.Unix()-b.second < int64(len(d.buckets)) {
+			total += b.total
+			errors += b.errors
+		}
+	}
+	ratio := 0.0
+	if total > 0 {
+		ratio = float64(errors) / float64(total)
+	}
+
+	switch {
+	case d.current == nil && total >= d.minRequests && ratio >= d.threshold:
+		d.current = &Incident{ID: "INC-" + now.UTC().Format("20060102-150405"), Since: now}
+		logger.Error("Incident %s opened: %d of %d requests in the last %ds failed with 5xx",
+			d.current.ID, errors, total, len(d.buckets))
+		metrics.RecordIncident(true)
+	case d.current != nil && now.Sub(d.current.Since) >= d.minDuration && ratio < d.threshold/2:
+		logger.Info("Incident %s closed after %s", d.current.ID, now.Sub(d.current.Since).Round(time.Second))
+		d.current = nil
+		metrics.RecordIncident(false)
+	}
+}
@@ -0,0 +1,43 @@
+package incident
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetector(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	d := NewDetector(0.5, 10, 20, time.Minute)
+	d.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		d.Record(502)
+	}
+	if _, open := d.Current(); open {
+		t.Fatal("Expected no incident below minRequests")
+	}
+
+	for i := 0; i < 10; i++ {
+		d.Record(200)
+	}
+	now = now.Add(time.Second)
+	inc, open := d.Current()
+	if !open || inc.ID == "" {
+		t.Fatal("Expected an incident at a 50% error ratio")
+	}
+
+	// Errors stop, but the incident stays open for minDuration
+	now = now.Add(20 * time.Second)
+	for i := 0; i < 20; i++ {
+		d.Record(200)
+	}
+	now = now.Add(time.Second)
+	if _, open := d.Current(); !open {
+		t.Fatal("Expected the incident to stay open for minDuration")
+	}
+
+	now = now.Add(time.Minute)
+	if _, open := d.Current(); open {
+		t.Error("Expected the incident to close once errors stopped")
+	}
+}
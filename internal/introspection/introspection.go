@@ -0,0 +1,172 @@
+package introspection
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCacheEntries bounds memory use when many distinct tokens are seen
+const maxCacheEntries = 10000
+
+// Result is the subset of an RFC 7662 introspection response the gateway
+// uses.
+type Result struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope"`
+	Subject   string `json:"sub"`
+	ClientID  string `json:"client_id"`
+	Username  string `json:"username"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Scopes splits the space-delimited scope string
+func (r *Result) Scopes() []string {
+	return strings.Fields(r.Scope)
+}
+
+// HasScopes reports whether every required scope was granted
+func (r *Result) HasScopes(required []string) bool {
+	granted := r.Scopes()
+	for _, scope := range required {
+		found := false
+		for _, g := range granted {
+			if g == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+type cacheEntry struct {
+	result  *Result
+	expires time.Time
+}
+
+// Client calls an introspection endpoint and caches the answers. Cache keys
+// are token hashes so raw tokens are not kept in memory longer than needed.
+type Client struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	cacheTTL     time.Duration
+	http         *http.Client
+	now          func() time.Time
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]cacheEntry
+}
+
+func NewClient(endpoint, clientID, clientSecret string, cacheTTL, timeout time.Duration) *Client {
+	return &Client{
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		cacheTTL:     cacheTTL,
+		http:         &http.Client{Timeout: timeout},
+		now:          time.Now,
+		cache:        make(map[[sha256.Size]byte]cacheEntry),
+	}
+}
+
+// Introspect returns the token's state, from cache when possible. Active
+// results are never cached past the token's own expiry.
+func (c *Client) Introspect(ctx context.Context, token string) (*Result, error) {
+	key := sha256.Sum256([]byte(token))
+	now := c.now()
+
+	c.mu.Lock()
+	entry, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.result, nil
+	}
+
+	result, err := c.fetch(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Active && result.ExpiresAt > 0 && !now.Before(time.Unix(result.ExpiresAt, 0)) {
+		result.Active = false
+	}
+
+	if c.cacheTTL > 0 {
+		expires := now.Add(c.cacheTTL)
+		if result.Active && result.ExpiresAt > 0 {
+			if exp := time.Unix(result.ExpiresAt, 0); exp.Before(expires) {
+				expires = exp
+			}
+		}
+		c.store(key, cacheEntry{result: result, expires: expires}, now)
+	}
+
+	return result, nil
+}
+
+func (c *Client) fetch(ctx context.Context, token string) (*Result, error) {
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("introspection: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection: status %d", resp.StatusCode)
+	}
+
+	var result Result
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("introspection: %w", err)
+	}
+	return &result, nil
+}
+
+func (c *Client) store(key [sha256.Size]byte, entry cacheEntry, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.cache) >= maxCacheEntries {
+		for k, e := range c.cache {
+			if !now.Before(e.expires) {
+				delete(c.cache, k)
+			}
+		}
+		// Still full: start over rather than grow without bound
+		if len(c.cache) >= maxCacheEntries {
+			c.cache = make(map[[sha256.Size]byte]cacheEntry)
+		}
+	}
+	c.cache[key] = entry
+}
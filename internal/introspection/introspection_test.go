@@ -0,0 +1,95 @@
+package introspection
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testServer(t *testing.T, calls *int32) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		if user, pass, _ := r.BasicAuth(); user != "gateway" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.PostFormValue("token") {
+		case "good":
+			fmt.Fprintf(w, `{"active":true,"scope":"read write","sub":"alice","exp":%d}`, time.Now().Add(time.Hour).Unix())
+		case "stale":
+			fmt.Fprintf(w, `{"active":true,"sub":"bob","exp":%d}`, time.Now().Add(-time.Minute).Unix())
+		default:
+			fmt.Fprint(w, `{"active":false}`)
+		}
+	}))
+}
+
+func TestIntrospectCachesResults(t *testing.T) {
+	var calls int32
+	server := testServer(t, &calls)
+	defer server.Close()
+
+	client := NewClient(server.URL, "gateway", "secret", time.Minute, time.Second)
+
+	for i := 0; i < 3; i++ {
+		result, err := client.Introspect(context.Background(), "good")
+		if err != nil {
+			t.Fatalf("Introspect failed: %v", err)
+		}
+		if !result.Active || result.Subject != "alice" {
+			t.Errorf("Unexpected result: %+v", result)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 introspection call, got %d", calls)
+	}
+
+	if result, _ := client.Introspect(context.Background(), "revoked"); result.Active {
+		t.Error("Expected unknown token to be inactive")
+	}
+	if calls != 2 {
+		t.Errorf("Expected distinct tokens to be cached separately, got %d calls", calls)
+	}
+}
+
+func TestIntrospectTreatsExpiredTokensAsInactive(t *testing.T) {
+	var calls int32
+	server := testServer(t, &calls)
+	defer server.Close()
+
+	client := NewClient(server.URL, "gateway", "secret", time.Minute, time.Second)
+	result, err := client.Introspect(context.Background(), "stale")
+	if err != nil {
+		t.Fatalf("Introspect failed: %v", err)
+	}
+	if result.Active {
+		t.Error("Expected token past exp to be inactive")
+	}
+}
+
+func TestIntrospectEndpointErrors(t *testing.T) {
+	var calls int32
+	server := testServer(t, &calls)
+	defer server.Close()
+
+	client := NewClient(server.URL, "gateway", "wrong", time.Minute, time.Second)
+	if _, err := client.Introspect(context.Background(), "good"); err == nil {
+		t.Error("Expected error when the endpoint rejects our credentials")
+	}
+}
+
+func TestHasScopes(t *testing.T) {
+	result := &Result{Scope: "read write"}
+	if !result.HasScopes([]string{"read"}) || !result.HasScopes(nil) {
+		t.Error("Expected granted scopes to match")
+	}
+	if result.HasScopes([]string{"read", "admin"}) {
+		t.Error("Expected missing scope to fail")
+	}
+}
@@ -0,0 +1,307 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	EntryStart  = "start"
+	EntryFinish = "finish"
+)
+
+// Entry is a single journal record. Start records carry the request
+// details; finish records only need the ID and the final status. ID is
+// assigned by the gateway; RequestID is the X-Request-ID the request was
+// proxied with, which clients may choose and reuse.
+type Entry struct {
+	Type           string    `json:"type"`
+	ID             string    `json:"id"`
+	RequestID      string    `json:"request_id,omitempty"`
+	Time           time.Time `json:"time"`
+	Method         string    `json:"method,omitempty"`
+	Path           string    `json:"path,omitempty"`
+	ClientIP       string    `json:"client_ip,omitempty"`
+	IdempotencyKey string    `json:"idempotency_key,omitempty"`
+	Status         int       `json:"status,omitempty"`
+}
+
+// Journal is an append-only log of request start/finish events. After a
+// crash, start records without a matching finish are the requests that were
+// in flight and may have been applied by a backend without the client
+// seeing the result.
+//
+// Records are buffered under mu and made durable by commit, which flushes
+// and fsyncs everything buffered so far: requests that arrive while one
+// fsync is running share the next one rather than queueing for their own.
+type Journal struct {
+	// commitMu serializes flushing, fsync and compaction. It is taken
+	// before mu, never after.
+	commitMu sync.Mutex
+	synced   uint64
+
+	mu       sync.Mutex
+	written  uint64
+	path     string
+	file     *os.File
+	writer   *bufio.Writer
+	sync     bool
+	maxBytes int64
+	size     int64
+	inflight map[string]Entry
+}
+
+// Open recovers any requests left in flight by a previous run, saves them
+// next to the journal for reconciliation, and starts a fresh journal.
+func Open(path string, syncWrites bool, maxBytes int64) (*Journal, []Entry, error) {
+	recovered, err := Recover(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(recovered) > 0 {
+		if err := writeRecovered(path, recovered); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	j := &Journal{
+		path:     path,
+		sync:     syncWrites,
+		maxBytes: maxBytes,
+		inflight: make(map[string]Entry),
+	}
+
+	if err := j.reopen(); err != nil {
+		return nil, nil, err
+	}
+
+	return j, recovered, nil
+}
+
+// Recover reads a journal and returns the start entries without a finish,
+// oldest first. A missing journal is not an error.
+func Recover(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	inflight := make(map[string]Entry)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		// A torn final line from the crash is expected; skip it
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		switch entry.Type {
+		case EntryStart:
+			inflight[entry.ID] = entry
+		case EntryFinish:
+			delete(inflight, entry.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return sortedEntries(inflight), nil
+}
+
+// Start records that a request has been handed to the proxy
+func (j *Journal) Start(entry Entry) error {
+	entry.Type = EntryStart
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	j.mu.Lock()
+	j.inflight[entry.ID] = entry
+	seq, err := j.appendLocked(entry)
+	j.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return j.commit(seq)
+}
+
+// Finish records that a request completed with the given status
+func (j *Journal) Finish(id string, status int) error {
+	j.mu.Lock()
+	delete(j.inflight, id)
+	seq, err := j.appendLocked(Entry{Type: EntryFinish, ID: id, Time: time.Now(), Status: status})
+	j.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := j.commit(seq); err != nil {
+		return err
+	}
+
+	j.commitMu.Lock()
+	defer j.commitMu.Unlock()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.maxBytes > 0 && j.size >= j.maxBytes {
+		return j.compactLocked()
+	}
+	return nil
+}
+
+// commit makes every record up to seq durable. Whoever gets commitMu
+// flushes all records buffered by then, so waiters whose records were
+// included return without writing.
+func (j *Journal) commit(seq uint64) error {
+	j.commitMu.Lock()
+	defer j.commitMu.Unlock()
+	if j.synced >= seq {
+		return nil
+	}
+
+	j.mu.Lock()
+	upTo := j.written
+	err := j.writer.Flush()
+	file := j.file
+	j.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if j.sync {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	j.synced = upTo
+	return nil
+}
+
+// Inflight returns the requests currently in flight, oldest first
+func (j *Journal) Inflight() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return sortedEntries(j.inflight)
+}
+
+// Close flushes and closes the journal file
+func (j *Journal) Close() error {
+	j.commitMu.Lock()
+	defer j.commitMu.Unlock()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.writer.Flush(); err != nil {
+		return err
+	}
+	return j.file.Close()
+}
+
+// appendLocked buffers entry and returns its sequence number for commit
+func (j *Journal) appendLocked(entry Entry) (uint64, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	data = append(data, '\n')
+
+	n, err := j.writer.Write(data)
+	j.size += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	j.written++
+	return j.written, nil
+}
+
+// compactLocked rewrites the journal so it only holds in-flight requests.
+// The new file is fully written before it replaces the old one. Both
+// commitMu and mu must be held.
+func (j *Journal) compactLocked() error {
+	tmpPath := j.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	var size int64
+	for _, entry := range sortedEntries(j.inflight) {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		n, _ := writer.Write(append(data, '\n'))
+		size += int64(n)
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		return err
+	}
+
+	j.file.Close()
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	j.file = file
+	j.writer = bufio.NewWriter(file)
+	j.size = size
+	// The synced file holds everything buffered so far
+	j.synced = j.written
+	return nil
+}
+
+func (j *Journal) reopen() error {
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening journal %s: %w", j.path, err)
+	}
+	j.file = file
+	j.writer = bufio.NewWriter(file)
+	j.size = 0
+	return nil
+}
+
+func writeRecovered(path string, entries []Entry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	reportPath := fmt.Sprintf("%s.recovered-%d.json", path, time.Now().Unix())
+	return os.WriteFile(reportPath, data, 0o600)
+}
+
+func sortedEntries(m map[string]Entry) []Entry {
+	entries := make([]Entry, 0, len(m))
+	for _, entry := range m {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, k int) bool {
+		return entries[i].Time.Before(entries[k].Time)
+	})
+	return entries
+}
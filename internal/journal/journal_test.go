@@ -0,0 +1,136 @@
+package journal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestJournalRecoversInflightRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.journal")
+
+	j, recovered, err := Open(path, true, 0)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	if len(recovered) != 0 {
+		t.Errorf("Expected nothing to recover from a new journal, got %d", len(recovered))
+	}
+
+	j.Start(Entry{ID: "a", Method: "POST", Path: "/payments"})
+	j.Start(Entry{ID: "b", Method: "POST", Path: "/payments"})
+	j.Finish("a", 201)
+
+	// Simulate a crash: no Close, just read what is on disk
+	inflight, err := Recover(path)
+	if err != nil {
+		t.Fatalf("Failed to recover journal: %v", err)
+	}
+	if len(inflight) != 1 || inflight[0].ID != "b" {
+		t.Fatalf("Expected request b to be in flight, got %+v", inflight)
+	}
+
+	// Reopening reports the leftovers and starts a clean journal
+	j2, recovered, err := Open(path, true, 0)
+	if err != nil {
+		t.Fatalf("Failed to reopen journal: %v", err)
+	}
+	defer j2.Close()
+
+	if len(recovered) != 1 || recovered[0].Path != "/payments" {
+		t.Errorf("Expected recovered request b, got %+v", recovered)
+	}
+
+	reports, _ := filepath.Glob(path + ".recovered-*.json")
+	if len(reports) != 1 {
+		t.Errorf("Expected one recovery report, got %d", len(reports))
+	}
+
+	if inflight, _ := Recover(path); len(inflight) != 0 {
+		t.Errorf("Expected fresh journal after reopen, got %d entries", len(inflight))
+	}
+}
+
+func TestJournalIgnoresTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.journal")
+	content := `{"type":"start","id":"x","time":"2024-01-01T00:00:00Z"}
+{"type":"finish","id":"x","ti`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	inflight, err := Recover(path)
+	if err != nil {
+		t.Fatalf("Expected torn line to be ignored, got error: %v", err)
+	}
+	if len(inflight) != 1 {
+		t.Errorf("Expected 1 in-flight request, got %d", len(inflight))
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.journal")
+
+	j, _, err := Open(path, false, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	j.Start(Entry{ID: "long-running", Method: "POST", Path: "/export"})
+	for i := 0; i < 20; i++ {
+		id := string(rune('a' + i))
+		j.Start(Entry{ID: id, Method: "GET", Path: "/"})
+		j.Finish(id, 200)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= 512 {
+		t.Errorf("Expected journal to be compacted below 512 bytes, got %d", info.Size())
+	}
+
+	inflight, _ := Recover(path)
+	if len(inflight) != 1 || inflight[0].ID != "long-running" {
+		t.Errorf("Expected compaction to keep in-flight request, got %+v", inflight)
+	}
+}
+
+func TestJournalConcurrentCommits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.journal")
+
+	j, _, err := Open(path, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("r%d", i)
+			if err := j.Start(Entry{ID: id, Method: "POST", Path: "/payments"}); err != nil {
+				t.Error(err)
+			}
+			if i%2 == 0 {
+				j.Finish(id, 200)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Every record is on disk once its call returns
+	inflight, err := Recover(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inflight) != 25 {
+		t.Errorf("Expected 25 requests in flight on disk, got %d", len(inflight))
+	}
+}
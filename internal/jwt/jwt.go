@@ -0,0 +1,258 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Leeway is the clock skew tolerated when checking exp and nbf
+const Leeway = 60 * time.Second
+
+var (
+	ErrMalformed       = errors.New("jwt: malformed token")
+	ErrSignature       = errors.New("jwt: invalid signature")
+	ErrExpired         = errors.New("jwt: token expired")
+	ErrNotYetValid     = errors.New("jwt: token not yet valid")
+	ErrUnsupportedAlg  = errors.New("jwt: unsupported algorithm")
+	ErrKeyTypeMismatch = errors.New("jwt: key type does not match algorithm")
+)
+
+type Header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Claims is the decoded token payload
+type Claims map[string]interface{}
+
+// String returns a string claim or "" if missing
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Time returns a NumericDate claim
+func (c Claims) Time(name string) (time.Time, bool) {
+	switch v := c[name].(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case json.Number:
+		n, err := v.Int64()
+		return time.Unix(n, 0), err == nil
+	}
+	return time.Time{}, false
+}
+
+// Audience returns the aud claim, which may be a string or a list
+func (c Claims) Audience() []string {
+	switch v := c["aud"].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		aud := make([]string, 0, len(v))
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+		return aud
+	}
+	return nil
+}
+
+// HasAudience reports whether aud contains the given value
+func (c Claims) HasAudience(audience string) bool {
+	for _, aud := range c.Audience() {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyFunc returns the verification key for a token header. HMAC keys are
+// []byte, asymmetric keys are *rsa.PublicKey or *ecdsa.PublicKey.
+type KeyFunc func(Header) (interface{}, error)
+
+// Parse verifies the token signature and time claims and returns its claims
+func Parse(token string, keyFunc KeyFunc) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var header Header
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrMalformed
+	}
+
+	key, err := keyFunc(header)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	if err := verify(header.Alg, parts[0]+"."+parts[1], signature, key); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrMalformed
+	}
+
+	now := time.Now()
+	if exp, ok := claims.Time("exp"); ok && now.After(exp.Add(Leeway)) {
+		return nil, ErrExpired
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(Leeway).Before(nbf) {
+		return nil, ErrNotYetValid
+	}
+
+	return claims, nil
+}
+
+// Sign encodes and signs claims. Supported algorithms are HS256, HS512,
+// RS256 and ES256.
+func Sign(claims Claims, alg, kid string, key interface{}) (string, error) {
+	header, err := json.Marshal(Header{Alg: alg, Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch alg {
+	case "HS256", "HS512":
+		secret, ok := key.([]byte)
+		if !ok {
+			return "", ErrKeyTypeMismatch
+		}
+		mac := hmac.New(hashFor(alg), secret)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case "RS256":
+		private, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return "", ErrKeyTypeMismatch
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, private, crypto.SHA256, digest[:])
+		if err != nil {
+			return "", err
+		}
+	case "ES256":
+		private, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return "", ErrKeyTypeMismatch
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		r, s, err := ecdsa.Sign(rand.Reader, private, digest[:])
+		if err != nil {
+			return "", err
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	default:
+		return "", ErrUnsupportedAlg
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func verify(alg, signingInput string, signature []byte, key interface{}) error {
+	switch alg {
+	case "HS256", "HS384", "HS512":
+		secret, ok := key.([]byte)
+		if !ok {
+			return ErrKeyTypeMismatch
+		}
+		mac := hmac.New(hashFor(alg), secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrSignature
+		}
+		return nil
+	case "RS256", "RS384", "RS512":
+		public, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrKeyTypeMismatch
+		}
+		h := hashFor(alg)()
+		h.Write([]byte(signingInput))
+		if err := rsa.VerifyPKCS1v15(public, cryptoHash(alg), h.Sum(nil), signature); err != nil {
+			return ErrSignature
+		}
+		return nil
+	case "ES256", "ES384", "ES512":
+		public, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrKeyTypeMismatch
+		}
+		size := (public.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ErrSignature
+		}
+		h := hashFor(alg)()
+		h.Write([]byte(signingInput))
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(public, h.Sum(nil), r, s) {
+			return ErrSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrUnsupportedAlg, alg)
+}
+
+func hashFor(alg string) func() hash.Hash {
+	switch alg[2:] {
+	case "384":
+		return sha512.New384
+	case "512":
+		return sha512.New
+	}
+	return sha256.New
+}
+
+func cryptoHash(alg string) crypto.Hash {
+	switch alg[2:] {
+	case "384":
+		return crypto.SHA384
+	case "512":
+		return crypto.SHA512
+	}
+	return crypto.SHA256
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
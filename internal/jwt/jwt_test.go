@@ -0,0 +1,98 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndParse(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	testCases := []struct {
+		alg     string
+		signKey interface{}
+		keyFunc KeyFunc
+	}{
+		{"HS256", []byte("secret"), func(Header) (interface{}, error) { return []byte("secret"), nil }},
+		{"RS256", rsaKey, func(Header) (interface{}, error) { return &rsaKey.PublicKey, nil }},
+		{"ES256", ecKey, func(Header) (interface{}, error) { return &ecKey.PublicKey, nil }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.alg, func(t *testing.T) {
+			token, err := Sign(Claims{"sub": "alice", "exp": time.Now().Add(time.Minute).Unix()}, tc.alg, "k1", tc.signKey)
+			if err != nil {
+				t.Fatalf("Failed to sign: %v", err)
+			}
+
+			claims, err := Parse(token, tc.keyFunc)
+			if err != nil {
+				t.Fatalf("Failed to parse: %v", err)
+			}
+			if claims.String("sub") != "alice" {
+				t.Errorf("Expected sub alice, got %v", claims.String("sub"))
+			}
+
+			// Flip a byte in the payload
+			parts := strings.Split(token, ".")
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			payload[len(payload)-2] ^= 1
+			tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+			if _, err := Parse(tampered, tc.keyFunc); !errors.Is(err, ErrSignature) {
+				t.Errorf("Expected signature error for tampered token, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseExpired(t *testing.T) {
+	token, _ := Sign(Claims{"exp": time.Now().Add(-time.Hour).Unix()}, "HS256", "", []byte("secret"))
+
+	_, err := Parse(token, func(Header) (interface{}, error) { return []byte("secret"), nil })
+	if !errors.Is(err, ErrExpired) {
+		t.Errorf("Expected expired error, got %v", err)
+	}
+}
+
+func TestParseRejectsKeyTypeConfusion(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	token, _ := Sign(Claims{"sub": "mallory"}, "HS256", "", []byte("public-key-bytes"))
+
+	_, err := Parse(token, func(Header) (interface{}, error) { return &rsaKey.PublicKey, nil })
+	if !errors.Is(err, ErrKeyTypeMismatch) {
+		t.Errorf("Expected key type mismatch, got %v", err)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"EC","kid":"ec1","crv":"P-256","x":"%s","y":"%s"},
+		{"kty":"RSA","kid":"enc","use":"enc","n":"AQAB","e":"AQAB"},
+		{"kty":"oct","kid":"sym"}
+	]}`,
+		base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+		base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()))
+
+	keys, err := ParseJWKS([]byte(jwks))
+	if err != nil {
+		t.Fatalf("Failed to parse JWKS: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("Expected only the signing EC key, got %d keys", len(keys))
+	}
+
+	token, _ := Sign(Claims{"sub": "bob"}, "ES256", "ec1", ecKey)
+	if _, err := Parse(token, func(h Header) (interface{}, error) { return keys[h.Kid], nil }); err != nil {
+		t.Errorf("Expected token to verify with JWKS key, got %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucket = []byte("gatekeeper")
+
+// BoltStore keeps values in a local BoltDB file so they survive restarts.
+// Only one process can open the file. Each value is stored behind its
+// expiry time; expired keys are dropped when read and swept now and then.
+type BoltStore struct {
+	db  *bolt.DB
+	now func() time.Time
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// OpenBolt opens or creates the store file at path
+func OpenBolt(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db, now: time.Now}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	var value []byte
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value, found = s.read(tx.Bucket(bucket), key)
+		return nil
+	})
+	return value, found, err
+}
+
+func (s *BoltStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.update(func(b *bolt.Bucket) error {
+		return s.write(b, key, value, ttl)
+	})
+}
+
+func (s *BoltStore) Add(_ context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	var added bool
+	err := s.update(func(b *bolt.Bucket) error {
+		if _, ok := s.read(b, key); ok {
+			return nil
+		}
+		added = true
+		return s.write(b, key, value, ttl)
+	})
+	return added, err
+}
+
+func (s *BoltStore) Delete(_ context.Context, key string) (bool, error) {
+	var existed bool
+	err := s.update(func(b *bolt.Bucket) error {
+		_, existed = s.read(b, key)
+		return b.Delete([]byte(key))
+	})
+	return existed, err
+}
+
+func (s *BoltStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return s.IncrBy(ctx, key, 1, ttl)
+}
+
+func (s *BoltStore) IncrBy(_ context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	var count int64
+	err := s.update(func(b *bolt.Bucket) error {
+		raw := b.Get([]byte(key))
+		expires, value := decode(raw)
+		if raw == nil || !s.live(expires) {
+			expires, value = s.expiry(ttl), []byte("0")
+		}
+		current, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return errNotCounter(key)
+		}
+		count = current + n
+		return b.Put([]byte(key), encode(expires, []byte(strconv.FormatInt(count, 10))))
+	})
+	return count, err
+}
+
+func (s *BoltStore) Scan(_ context.Context, prefix string) (map[string][]byte, error) {
+	found := make(map[string][]byte)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, raw := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, raw = c.Next() {
+			if expires, value := decode(raw); s.live(expires) {
+				found[string(k)] = append([]byte(nil), value...)
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+// update runs fn in a write transaction, first dropping expired keys if
+// the last sweep was over a minute ago
+func (s *BoltStore) update(fn func(b *bolt.Bucket) error) error {
+	now := s.now()
+	s.mu.Lock()
+	sweep := now.Sub(s.lastSweep) > time.Minute
+	if sweep {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if sweep {
+			var expired [][]byte
+			b.ForEach(func(k, raw []byte) error {
+				if expires, _ := decode(raw); !s.live(expires) {
+					expired = append(expired, append([]byte(nil), k...))
+				}
+				return nil
+			})
+			for _, k := range expired {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return fn(b)
+	})
+}
+
+func (s *BoltStore) read(b *bolt.Bucket, key string) ([]byte, bool) {
+	raw := b.Get([]byte(key))
+	if raw == nil {
+		return nil, false
+	}
+	expires, value := decode(raw)
+	if !s.live(expires) {
+		return nil, false
+	}
+	// Bolt's memory is only valid during the transaction
+	return append([]byte(nil), value...), true
+}
+
+func (s *BoltStore) write(b *bolt.Bucket, key string, value []byte, ttl time.Duration) error {
+	return b.Put([]byte(key), encode(s.expiry(ttl), value))
+}
+
+func (s *BoltStore) expiry(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return s.now().Add(ttl).UnixNano()
+}
+
+func (s *BoltStore) live(expires int64) bool {
+	return expires == 0 || s.now().UnixNano() < expires
+}
+
+// encode prefixes value with its expiry in Unix nanoseconds, 0 for never
+func encode(expires int64, value []byte) []byte {
+	raw := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(raw, uint64(expires))
+	copy(raw[8:], value)
+	return raw
+}
+
+func decode(raw []byte) (int64, []byte) {
+	if len(raw) < 8 {
+		return 0, nil
+	}
+	return int64(binary.BigEndian.Uint64(raw)), raw[8:]
+}
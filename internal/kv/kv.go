@@ -0,0 +1,166 @@
+// Package kv is the key-value storage shared by stateful features such as
+// auto-ban, idempotency keys and per-client rate limits, so operators pick
+// one persistence story for all of them. The memory store suits a single
+// gateway, the Redis store shares state across replicas and the Bolt store
+// keeps it in a local file across restarts.
+package kv
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store holds byte values under string keys. A ttl of zero means the key
+// never expires.
+type Store interface {
+	// Get returns the value under key and whether it exists
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key, replacing any previous value
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Add stores value only if key does not exist and reports whether it did
+	Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// Delete removes key and reports whether it existed
+	Delete(ctx context.Context, key string) (bool, error)
+	// Incr adds one to the counter under key and returns the new count. A
+	// new counter expires after ttl; incrementing does not extend it.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// IncrBy is Incr adding n instead of one
+	IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+	// Scan returns every key starting with prefix and its value
+	Scan(ctx context.Context, prefix string) (map[string][]byte, error)
+}
+
+// MemoryStore keeps values in process
+type MemoryStore struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+type entry struct {
+	value   []byte
+	expires time.Time
+}
+
+func (e *entry) live(now time.Time) bool {
+	return e.expires.IsZero() || now.Before(e.expires)
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{now: time.Now, entries: make(map[string]*entry)}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.lookup(key)
+	if !ok {
+		return nil, false, nil
+	}
+	return append([]byte(nil), e.value...), true, nil
+}
+
+func (s *MemoryStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = s.newEntry(value, ttl)
+	return nil
+}
+
+func (s *MemoryStore) Add(_ context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookup(key); ok {
+		return false, nil
+	}
+	s.entries[key] = s.newEntry(value, ttl)
+	return true, nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.lookup(key)
+	delete(s.entries, key)
+	return ok, nil
+}
+
+func (s *MemoryStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return s.IncrBy(ctx, key, 1, ttl)
+}
+
+func (s *MemoryStore) IncrBy(_ context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.lookup(key)
+	if !ok {
+		e = s.newEntry([]byte("0"), ttl)
+		s.entries[key] = e
+	}
+	count, err := strconv.ParseInt(string(e.value), 10, 64)
+	if err != nil {
+		return 0, errNotCounter(key)
+	}
+	count += n
+	e.value = []byte(strconv.FormatInt(count, 10))
+	return count, nil
+}
+
+func (s *MemoryStore) Scan(_ context.Context, prefix string) (map[string][]byte, error) {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := make(map[string][]byte)
+	for key, e := range s.entries {
+		if strings.HasPrefix(key, prefix) && e.live(now) {
+			found[key] = append([]byte(nil), e.value...)
+		}
+	}
+	return found, nil
+}
+
+// lookup returns the live entry under key, dropping expired entries now
+// and then. s.mu must be held.
+func (s *MemoryStore) lookup(key string) (*entry, bool) {
+	now := s.now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.entries {
+			if !e.live(now) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	e, ok := s.entries[key]
+	if !ok || !e.live(now) {
+		return nil, false
+	}
+	return e, true
+}
+
+func (s *MemoryStore) newEntry(value []byte, ttl time.Duration) *entry {
+	e := &entry{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		e.expires = s.now().Add(ttl)
+	}
+	return e
+}
+
+type errNotCounter string
+
+func (e errNotCounter) Error() string {
+	return "kv: value under " + string(e) + " is not a counter"
+}
@@ -0,0 +1,119 @@
+package kv
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func testStore(t *testing.T, store Store, advance func(time.Duration)) {
+	ctx := context.Background()
+
+	if _, ok, err := store.Get(ctx, "a"); err != nil || ok {
+		t.Fatalf("expected a missing key, got %v %v", ok, err)
+	}
+	if err := store.Set(ctx, "a", []byte("1"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, _ := store.Get(ctx, "a"); !ok || string(value) != "1" {
+		t.Fatalf("expected a=1, got %q %v", value, ok)
+	}
+
+	if added, _ := store.Add(ctx, "a", []byte("2"), time.Minute); added {
+		t.Error("Add should not replace a live key")
+	}
+	if added, _ := store.Add(ctx, "b", []byte("two"), 0); !added {
+		t.Error("Add should store a new key")
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		if count, err := store.Incr(ctx, "c", time.Minute); err != nil || count != i {
+			t.Fatalf("expected count %d, got %d %v", i, count, err)
+		}
+	}
+	if count, err := store.IncrBy(ctx, "c", 10, time.Minute); err != nil || count != 13 {
+		t.Fatalf("expected count 13, got %d %v", count, err)
+	}
+	if _, err := store.Incr(ctx, "b", time.Minute); err == nil {
+		t.Error("expected an error incrementing a value that is not a counter")
+	}
+
+	store.Set(ctx, "other", []byte("x"), 0)
+	entries, err := store.Scan(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("expected 4 keys, got %v", entries)
+	}
+	if entries, _ := store.Scan(ctx, "o"); len(entries) != 1 || string(entries["other"]) != "x" {
+		t.Errorf("expected only the prefixed key, got %v", entries)
+	}
+
+	if existed, _ := store.Delete(ctx, "other"); !existed {
+		t.Error("expected Delete to report the key existed")
+	}
+	if existed, _ := store.Delete(ctx, "other"); existed {
+		t.Error("deleting twice should report nothing removed")
+	}
+
+	advance(time.Minute)
+	if _, ok, _ := store.Get(ctx, "a"); ok {
+		t.Error("a should expire")
+	}
+	if _, ok, _ := store.Get(ctx, "b"); !ok {
+		t.Error("b has no ttl and should not expire")
+	}
+	if count, _ := store.Incr(ctx, "c", time.Minute); count != 1 {
+		t.Errorf("expected the counter to restart after expiring, got %d", count)
+	}
+	if added, _ := store.Add(ctx, "a", []byte("3"), time.Minute); !added {
+		t.Error("Add should replace an expired key")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+
+	testStore(t, store, func(d time.Duration) { now = now.Add(d) })
+}
+
+func TestRedisStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	testStore(t, NewRedisStore(client, ""), server.FastForward)
+	if !server.Exists("gatekeeper:b") {
+		t.Error("expected keys under the default prefix")
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, err := OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+
+	testStore(t, store, func(d time.Duration) { now = now.Add(d) })
+
+	// Values survive reopening the file
+	store.Close()
+	store, err = OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if value, ok, _ := store.Get(context.Background(), "b"); !ok || string(value) != "two" {
+		t.Errorf("expected b to survive a restart, got %q %v", value, ok)
+	}
+}
@@ -0,0 +1,35 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// Open creates the store described by cfg
+func Open(cfg config.StorageConfig) (Store, error) {
+	switch cfg.Type {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "redis":
+		if cfg.Redis.Address == "" {
+			return nil, errors.New("redis storage requires an address")
+		}
+		client := redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Address,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		})
+		return NewRedisStore(client, cfg.Redis.KeyPrefix), nil
+	case "bolt":
+		if cfg.Bolt.Path == "" {
+			return nil, errors.New("bolt storage requires a path")
+		}
+		return OpenBolt(cfg.Bolt.Path)
+	default:
+		return nil, fmt.Errorf("storage type %q must be memory, redis or bolt", cfg.Type)
+	}
+}
@@ -0,0 +1,90 @@
+package kv
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore shares values between gateway replicas. Keys are stored under
+// "<prefix><key>" and expire on their own.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = "gatekeeper:"
+	}
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+// incr starts the expiry with the counter so a crash between the two
+// commands cannot leave a counter that never expires
+var incr = redis.NewScript(`
+local count = redis.call("INCRBY", KEYS[1], ARGV[2])
+if tonumber(ARGV[1]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, s.prefix+key, value, ttl).Err()
+}
+
+func (s *RedisStore) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.prefix+key, value, ttl).Result()
+}
+
+func (s *RedisStore) Delete(ctx context.Context, key string) (bool, error) {
+	n, err := s.client.Del(ctx, s.prefix+key).Result()
+	return n > 0, err
+}
+
+func (s *RedisStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return s.IncrBy(ctx, key, 1, ttl)
+}
+
+func (s *RedisStore) IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	return incr.Run(ctx, s.client, []string{s.prefix + key}, ttl.Milliseconds(), n).Int64()
+}
+
+func (s *RedisStore) Scan(ctx context.Context, prefix string) (map[string][]byte, error) {
+	found := make(map[string][]byte)
+	iter := s.client.Scan(ctx, 0, escapeGlob(s.prefix+prefix)+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		value, err := s.client.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			continue // expired since the scan
+		}
+		if err != nil {
+			return nil, err
+		}
+		found[strings.TrimPrefix(key, s.prefix)] = value
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// escapeGlob quotes the characters SCAN MATCH treats as patterns
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`).Replace(s)
+}
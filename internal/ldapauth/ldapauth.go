@@ -0,0 +1,277 @@
+package ldapauth
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// maxCacheEntries bounds memory use when many distinct users log in
+const maxCacheEntries = 10000
+
+// ErrInvalidCredentials is returned for unknown users and wrong passwords
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Options configure a Directory. At most PoolSize connections are open at
+// once.
+type Options struct {
+	URL               string
+	StartTLS          bool
+	BindDN            string
+	BindPassword      string
+	BaseDN            string
+	UserFilter        string
+	UsernameAttribute string
+	GroupAttribute    string
+	PoolSize          int
+	Timeout           time.Duration
+	CacheTTL          time.Duration
+}
+
+// User is an authenticated directory entry. Name is its UsernameAttribute
+// as the directory stores it, whatever case the client typed, or its DN
+// when it has none.
+type User struct {
+	DN     string
+	Name   string
+	Groups []string
+}
+
+type cacheEntry struct {
+	user    *User
+	expires time.Time
+}
+
+// Directory checks passwords against an LDAP server such as Active
+// Directory. Users are looked up by the service account, then bound as to
+// check their password. Connections are kept bound as the service account
+// between requests; answers are cached by a hash of the credentials.
+type Directory struct {
+	opts  Options
+	pool  chan *ldap.Conn
+	slots chan struct{}
+	now   func() time.Time
+
+	// StartTLS verifies the certificate against serverName, the URL's host
+	serverName string
+	rootCAs    *x509.CertPool
+	connect    func() (*ldap.Conn, error)
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]cacheEntry
+}
+
+func New(opts Options) *Directory {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 1
+	}
+	d := &Directory{
+		opts:  opts,
+		pool:  make(chan *ldap.Conn, opts.PoolSize),
+		slots: make(chan struct{}, opts.PoolSize),
+		now:   time.Now,
+		cache: make(map[[sha256.Size]byte]cacheEntry),
+	}
+	if u, err := url.Parse(opts.URL); err == nil {
+		d.serverName = u.Hostname()
+	}
+	d.connect = func() (*ldap.Conn, error) {
+		return ldap.DialURL(opts.URL, ldap.DialWithDialer(&net.Dialer{Timeout: opts.Timeout}))
+	}
+	return d
+}
+
+// Authenticate checks username's password and returns the user's entry.
+// It fails with ErrInvalidCredentials when either is wrong.
+func (d *Directory) Authenticate(username, password string) (*User, error) {
+	// An empty password would make an unauthenticated bind, which servers
+	// accept for any DN
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	key := sha256.Sum256([]byte(username + "\x00" + password))
+	now := d.now()
+	d.mu.Lock()
+	entry, ok := d.cache[key]
+	d.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.user, nil
+	}
+
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.release()
+	conn, err := d.get()
+	if err != nil {
+		return nil, err
+	}
+	user, err := d.authenticate(conn, username, password)
+	if err != nil && !errors.Is(err, ErrInvalidCredentials) {
+		conn.Close()
+		return nil, err
+	}
+	d.put(conn)
+
+	if err == nil && d.opts.CacheTTL > 0 {
+		d.store(key, cacheEntry{user: user, expires: now.Add(d.opts.CacheTTL)}, now)
+	}
+	return user, err
+}
+
+func (d *Directory) authenticate(conn *ldap.Conn, username, password string) (*User, error) {
+	filter := strings.ReplaceAll(d.opts.UserFilter, "%s", ldap.EscapeFilter(username))
+	attributes := []string{d.opts.GroupAttribute}
+	if d.opts.UsernameAttribute != "" {
+		attributes = append(attributes, d.opts.UsernameAttribute)
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(d.opts.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(d.opts.Timeout/time.Second), false, filter, attributes, nil))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		// More than one entry matches: the filter cannot tell who this is
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap search: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	bindErr := conn.Bind(entry.DN, password)
+	// Back to the service account before the connection is reused
+	if err := d.bindService(conn); err != nil {
+		return nil, err
+	}
+	if ldap.IsErrorWithCode(bindErr, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	}
+	if bindErr != nil {
+		return nil, fmt.Errorf("ldap bind: %w", bindErr)
+	}
+	user := &User{DN: entry.DN, Groups: entry.GetAttributeValues(d.opts.GroupAttribute)}
+	if d.opts.UsernameAttribute != "" {
+		user.Name = entry.GetAttributeValue(d.opts.UsernameAttribute)
+	}
+	if user.Name == "" {
+		user.Name = entry.DN
+	}
+	return user, nil
+}
+
+// acquire takes one of the PoolSize connection slots, waiting up to the
+// timeout for one to free up
+func (d *Directory) acquire() error {
+	select {
+	case d.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	timer := time.NewTimer(d.opts.Timeout)
+	defer timer.Stop()
+	select {
+	case d.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errors.New("ldap: every connection is busy")
+	}
+}
+
+func (d *Directory) release() {
+	<-d.slots
+}
+
+// get returns a pooled connection, or a new one when none is idle
+func (d *Directory) get() (*ldap.Conn, error) {
+	for {
+		select {
+		case conn := <-d.pool:
+			if !conn.IsClosing() {
+				return conn, nil
+			}
+		default:
+			return d.dial()
+		}
+	}
+}
+
+// put returns conn to the pool, closing it when the pool is full
+func (d *Directory) put(conn *ldap.Conn) {
+	select {
+	case d.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+func (d *Directory) dial() (*ldap.Conn, error) {
+	conn, err := d.connect()
+	if err != nil {
+		return nil, fmt.Errorf("ldap: %w", err)
+	}
+	conn.SetTimeout(d.opts.Timeout)
+	if d.opts.StartTLS {
+		if err := conn.StartTLS(&tls.Config{ServerName: d.serverName, RootCAs: d.rootCAs}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+	if err := d.bindService(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (d *Directory) bindService(conn *ldap.Conn) error {
+	var err error
+	if d.opts.BindDN == "" {
+		err = conn.UnauthenticatedBind("")
+	} else {
+		err = conn.Bind(d.opts.BindDN, d.opts.BindPassword)
+	}
+	if err != nil {
+		return fmt.Errorf("ldap service bind: %w", err)
+	}
+	return nil
+}
+
+func (d *Directory) store(key [sha256.Size]byte, entry cacheEntry, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.cache) >= maxCacheEntries {
+		for k, e := range d.cache {
+			if !now.Before(e.expires) {
+				delete(d.cache, k)
+			}
+		}
+		// Still full: start over rather than grow without bound
+		if len(d.cache) >= maxCacheEntries {
+			d.cache = make(map[[sha256.Size]byte]cacheEntry)
+		}
+	}
+	d.cache[key] = entry
+}
+
+// CommonName returns the value of dn's first RDN, such as "admins" for
+// "cn=admins,ou=groups,dc=example,dc=com", or dn itself when it does not
+// parse
+func CommonName(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
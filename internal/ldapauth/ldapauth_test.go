@@ -0,0 +1,315 @@
+package ldapauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeDirectory is an LDAP server that knows the service account, alice
+// and bob. It answers simple binds and equality searches on uid, which
+// like a real directory ignore case and surrounding spaces, and StartTLS
+// when it has a tls config.
+type fakeDirectory struct {
+	listener net.Listener
+	tls      *tls.Config
+	dials    atomic.Int32
+	searches atomic.Int32
+}
+
+var fakeEntries = map[string]struct {
+	dn       string
+	password string
+	groups   []string
+}{
+	"alice": {"uid=alice,ou=people,dc=example,dc=com", "wonderland", []string{"cn=admins,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"}},
+	"bob":   {"uid=bob,ou=people,dc=example,dc=com", "builder", nil},
+}
+
+func newFakeDirectory(t *testing.T) *fakeDirectory {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDirectory{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			d.dials.Add(1)
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *fakeDirectory) URL() string {
+	return "ldap://" + d.listener.Addr().String()
+}
+
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			name := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if name == "cn=gateway,dc=example,dc=com" && password == "service" {
+				code = ldap.LDAPResultSuccess
+			}
+			for _, entry := range fakeEntries {
+				if name == entry.dn && password == entry.password {
+					code = ldap.LDAPResultSuccess
+				}
+			}
+			d.reply(conn, id, result(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			d.searches.Add(1)
+			filter := op.Children[6]
+			if filter.Tag == ldap.FilterEqualityMatch && filter.Children[0].Value.(string) == "uid" {
+				uid := strings.ToLower(strings.TrimSpace(filter.Children[1].Value.(string)))
+				if entry, ok := fakeEntries[uid]; ok {
+					found := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+					found.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, ""))
+					attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+					attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+					attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "memberOf", ""))
+					values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+					for _, group := range entry.groups {
+						values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, group, ""))
+					}
+					attribute.AppendChild(values)
+					attributes.AppendChild(attribute)
+					name := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+					name.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "uid", ""))
+					names := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+					names.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, uid, ""))
+					name.AppendChild(names)
+					attributes.AppendChild(name)
+					found.AppendChild(attributes)
+					d.reply(conn, id, found)
+				}
+			}
+			d.reply(conn, id, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationExtendedRequest:
+			if d.tls == nil {
+				d.reply(conn, id, result(ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError))
+				continue
+			}
+			d.reply(conn, id, result(ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess))
+			conn = tls.Server(conn, d.tls)
+		default:
+			return
+		}
+	}
+}
+
+func (d *fakeDirectory) reply(conn net.Conn, id int64, op *ber.Packet) {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	envelope.AppendChild(op)
+	conn.Write(envelope.Bytes())
+}
+
+func result(tag ber.Tag, code uint16) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return packet
+}
+
+func testDirectory(server *fakeDirectory, cacheTTL time.Duration) *Directory {
+	return New(Options{
+		URL:            server.URL(),
+		BindDN:         "cn=gateway,dc=example,dc=com",
+		BindPassword:   "service",
+		BaseDN:         "dc=example,dc=com",
+		UserFilter:        "(uid=%s)",
+		UsernameAttribute: "uid",
+		GroupAttribute:    "memberOf",
+		PoolSize:          2,
+		Timeout:           time.Second,
+		CacheTTL:          cacheTTL,
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	server := newFakeDirectory(t)
+	directory := testDirectory(server, 0)
+
+	user, err := directory.Authenticate("alice", "wonderland")
+	if err != nil {
+		t.Fatalf("Expected alice to authenticate, got %v", err)
+	}
+	if user.DN != fakeEntries["alice"].dn || user.Name != "alice" || len(user.Groups) != 2 {
+		t.Errorf("Expected alice's entry with her two groups, got %+v", user)
+	}
+
+	// However the client spells it, the name is the directory's
+	if user, err := directory.Authenticate(" Alice ", "wonderland"); err != nil || user.Name != "alice" {
+		t.Errorf("Expected \" Alice \" to authenticate as alice, got %+v, %v", user, err)
+	}
+
+	testCases := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"wrong password", "alice", "looking-glass"},
+		{"unknown user", "mallory", "wonderland"},
+		{"empty password", "alice", ""},
+		{"filter injection", "*", "wonderland"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := directory.Authenticate(tc.username, tc.password); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Expected invalid credentials, got %v", err)
+			}
+		})
+	}
+
+	// Failed logins leave the connection usable, so it is still the only one
+	if _, err := directory.Authenticate("bob", "builder"); err != nil {
+		t.Errorf("Expected bob to authenticate, got %v", err)
+	}
+	if dials := server.dials.Load(); dials != 1 {
+		t.Errorf("Expected one pooled connection, got %d dials", dials)
+	}
+}
+
+func TestAuthenticateCache(t *testing.T) {
+	server := newFakeDirectory(t)
+	directory := testDirectory(server, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if _, err := directory.Authenticate("alice", "wonderland"); err != nil {
+			t.Fatalf("Expected alice to authenticate, got %v", err)
+		}
+	}
+	if searches := server.searches.Load(); searches != 1 {
+		t.Errorf("Expected the answer to be cached, got %d searches", searches)
+	}
+
+	// Other passwords are not answered from the cache
+	if _, err := directory.Authenticate("alice", "looking-glass"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected invalid credentials, got %v", err)
+	}
+}
+
+func TestAuthenticatePoolSize(t *testing.T) {
+	server := newFakeDirectory(t)
+	directory := testDirectory(server, 0)
+
+	// A burst of logins shares PoolSize connections
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := directory.Authenticate("bob", "builder"); err != nil {
+				t.Errorf("Expected bob to authenticate, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if dials := server.dials.Load(); dials > 2 {
+		t.Errorf("Expected at most 2 connections, got %d dials", dials)
+	}
+}
+
+func TestAuthenticateUnreachable(t *testing.T) {
+	directory := New(Options{URL: "ldap://127.0.0.1:1", UserFilter: "(uid=%s)", Timeout: time.Second})
+	if _, err := directory.Authenticate("alice", "wonderland"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected a connection error, got %v", err)
+	}
+}
+
+func TestAuthenticateStartTLSWithoutPort(t *testing.T) {
+	server := newFakeDirectory(t)
+	cert, roots := selfSigned(t, "dc.example.com")
+	server.tls = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	// The URL names no port; the certificate is still checked against its
+	// host
+	directory := New(Options{
+		URL:            "ldap://dc.example.com",
+		StartTLS:       true,
+		BindDN:         "cn=gateway,dc=example,dc=com",
+		BindPassword:   "service",
+		BaseDN:         "dc=example,dc=com",
+		UserFilter:     "(uid=%s)",
+		GroupAttribute: "memberOf",
+		PoolSize:       1,
+		Timeout:        time.Second,
+	})
+	directory.rootCAs = roots
+	directory.connect = func() (*ldap.Conn, error) {
+		return ldap.DialURL(server.URL())
+	}
+
+	if _, err := directory.Authenticate("alice", "wonderland"); err != nil {
+		t.Fatalf("Expected alice to authenticate over StartTLS, got %v", err)
+	}
+}
+
+// selfSigned issues a certificate for host and returns the pool that
+// trusts it
+func selfSigned(t *testing.T, host string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _ := x509.ParseCertificate(der)
+	roots := x509.NewCertPool()
+	roots.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, roots
+}
+
+func TestCommonName(t *testing.T) {
+	if cn := CommonName("cn=admins,ou=groups,dc=example,dc=com"); cn != "admins" {
+		t.Errorf("Expected admins, got %q", cn)
+	}
+	if cn := CommonName("admins"); cn != "admins" {
+		t.Errorf("Expected an unparsable DN back, got %q", cn)
+	}
+}
@@ -0,0 +1,143 @@
+// Package lint checks a gateway configuration against an organization's
+// policy, such as "every route needs authentication", before it is served.
+package lint
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// Policy is a set of rules read from a policy file
+type Policy struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule enables one built-in check. Severity is "error" (default), which
+// blocks the config, or "warning". A rule with Environments only applies
+// in those environments. Exempt lists route or backend names, depending on
+// the rule, that the rule skips.
+type Rule struct {
+	Name         string   `yaml:"name"`
+	Severity     string   `yaml:"severity"`
+	Environments []string `yaml:"environments"`
+	Exempt       []string `yaml:"exempt"`
+}
+
+// Violation is one place where a config breaks a rule
+type Violation struct {
+	Rule     string
+	Severity string
+	Subject  string
+	Message  string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s [%s]: %s", v.Severity, v.Subject, v.Rule, v.Message)
+}
+
+type check func(cfg *config.Config, exempt map[string]bool) []Violation
+
+var checks = map[string]check{
+	"requireAuth":      requireAuth,
+	"requireRateLimit": requireRateLimit,
+	"requireTLS":       requireTLS,
+	"requireAdminAuth": requireAdminAuth,
+}
+
+// LoadPolicy reads and checks a policy file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("policy %s: %w", path, err)
+	}
+	for i, rule := range policy.Rules {
+		if _, ok := checks[rule.Name]; !ok {
+			return nil, fmt.Errorf("policy %s: rule #%d: unknown rule %q", path, i, rule.Name)
+		}
+		switch rule.Severity {
+		case "", "error", "warning":
+		default:
+			return nil, fmt.Errorf("policy %s: rule %s: severity must be error or warning", path, rule.Name)
+		}
+	}
+	return &policy, nil
+}
+
+// Check evaluates every rule that applies in environment against cfg
+func (p *Policy) Check(cfg *config.Config, environment string) []Violation {
+	var violations []Violation
+	for _, rule := range p.Rules {
+		if len(rule.Environments) > 0 && !contains(rule.Environments, environment) {
+			continue
+		}
+
+		exempt := make(map[string]bool, len(rule.Exempt))
+		for _, name := range rule.Exempt {
+			exempt[name] = true
+		}
+
+		severity := rule.Severity
+		if severity == "" {
+			severity = "error"
+		}
+		for _, v := range checks[rule.Name](cfg, exempt) {
+			v.Rule, v.Severity = rule.Name, severity
+			violations = append(violations, v)
+		}
+	}
+	return violations
+}
+
+// HasErrors reports whether any violation blocks the config
+func HasErrors(violations []Violation) bool {
+	for _, v := range violations {
+		if v.Severity == "error" {
+			return true
+		}
+	}
+	return false
+}
+
+// Enforce checks cfg against its own lint settings, if any, returning an
+// error when a rule with error severity is broken. Warnings are returned
+// for the caller to log.
+func Enforce(cfg *config.Config) ([]Violation, error) {
+	if cfg.Lint.PolicyFile == "" {
+		return nil, nil
+	}
+	policy, err := LoadPolicy(cfg.Lint.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	violations := policy.Check(cfg, cfg.Lint.Environment)
+	if HasErrors(violations) {
+		var errs []string
+		for _, v := range violations {
+			if v.Severity == "error" {
+				errs = append(errs, v.String())
+			}
+		}
+		return violations, fmt.Errorf("config violates policy:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return violations, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
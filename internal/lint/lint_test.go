@@ -0,0 +1,83 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestCheck(t *testing.T) {
+	cfg := &config.Config{
+		Backends: []config.Backend{{Name: "legacy", URL: "http://legacy:8080"}, {Name: "api", URL: "https://api"}},
+		Introspection: config.IntrospectionConfig{
+			Enabled: true, Endpoint: "https://idp/introspect", SkipPaths: []string{"/public"},
+		},
+		Middlewares: config.MiddlewareConfigs{
+			"limit": {Type: "rateLimit", RateLimit: &config.RateLimitConfig{RequestsPerMinute: 60}},
+		},
+		Routes: []config.RouteConfig{
+			{Name: "api", PathPrefix: "/api", Middlewares: []string{"limit"}},
+			{Name: "assets", PathPrefix: "/public/assets"},
+			{Name: "old", Path: "/old", Redirect: &config.RedirectConfig{To: "/new"}},
+		},
+		Admin: config.AdminConfig{Enabled: true},
+	}
+
+	policy := &Policy{Rules: []Rule{
+		{Name: "requireAuth"},
+		{Name: "requireRateLimit", Severity: "warning", Exempt: []string{"default"}},
+		{Name: "requireTLS", Environments: []string{"prod"}, Exempt: []string{"listener"}},
+		{Name: "requireAdminAuth"},
+	}}
+
+	got := describe(policy.Check(cfg, "staging"))
+	want := []string{
+		"error: route assets [requireAuth]: no authentication applies to /public/assets",
+		"warning: route assets [requireRateLimit]: no per-client rate limit",
+		"error: admin [requireAdminAuth]: admin API has no token",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected violations:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	prod := describe(policy.Check(cfg, "prod"))
+	if !contains(prod, "error: legacy [requireTLS]: backend is reached over plain HTTP") || len(prod) != 4 {
+		t.Errorf("Expected requireTLS to apply in prod only, got %v", prod)
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.yaml")
+	if err := os.WriteFile(path, []byte("rules:\n  - name: requireAdminAuth\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{Admin: config.AdminConfig{Enabled: true}, Lint: config.LintConfig{PolicyFile: path}}
+	if _, err := Enforce(cfg); err == nil {
+		t.Error("Expected an admin API without a token to be refused")
+	}
+
+	cfg.Admin.Token = "secret"
+	if _, err := Enforce(cfg); err != nil {
+		t.Errorf("Expected config to pass, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("rules:\n  - name: requireMagic\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Enforce(cfg); err == nil {
+		t.Error("Expected an unknown rule to fail closed")
+	}
+}
+
+func describe(violations []Violation) []string {
+	out := make([]string, len(violations))
+	for i, v := range violations {
+		out[i] = v.String()
+	}
+	return out
+}
@@ -0,0 +1,187 @@
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// defaultRoute names requests that match no route and go to the default
+// proxy; rules can exempt it by this name
+const defaultRoute = "default"
+
+var authTypes = map[string]bool{
+	"oidc": true, "saml": true, "spnego": true, "ldap": true, "introspection": true, "hmac": true, "extAuthz": true,
+}
+
+// requireAuth wants every proxied route to sit behind an authentication
+// mechanism, either global (and not skipped for the route) or in the
+// route's pipeline. Redirect routes never reach a backend and are ignored.
+func requireAuth(cfg *config.Config, exempt map[string]bool) []Violation {
+	if cfg.Server.TLS.ClientAuth == "require_and_verify" {
+		return nil
+	}
+
+	var global [][]string
+	for _, skip := range [][]string{
+		enabledSkipPaths(cfg.OIDC.Enabled, cfg.OIDC.SkipPaths),
+		enabledSkipPaths(cfg.SAML.Enabled, cfg.SAML.SkipPaths),
+		enabledSkipPaths(cfg.SPNEGO.Enabled, cfg.SPNEGO.SkipPaths),
+		enabledSkipPaths(cfg.LDAP.Enabled, cfg.LDAP.SkipPaths),
+		enabledSkipPaths(cfg.Introspection.Enabled, cfg.Introspection.SkipPaths),
+		enabledSkipPaths(cfg.HMAC.Enabled, cfg.HMAC.SkipPaths),
+		enabledSkipPaths(cfg.ExtAuthz.Enabled, cfg.ExtAuthz.SkipPaths),
+	} {
+		if skip != nil {
+			global = append(global, skip)
+		}
+	}
+
+	var violations []Violation
+	for i, route := range cfg.Routes {
+		name := routeName(route, i)
+		if route.Redirect != nil || exempt[name] || route.ClientCert != nil || usesType(cfg, route, authTypes) {
+			continue
+		}
+		prefix := literalPrefix(route)
+		covered := false
+		for _, skip := range global {
+			if !skipped(prefix, skip) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			violations = append(violations, Violation{Subject: "route " + name, Message: "no authentication applies to " + prefix})
+		}
+	}
+
+	if len(global) == 0 && !exempt[defaultRoute] {
+		violations = append(violations, Violation{Subject: "default proxy", Message: "requests matching no route are not authenticated"})
+	}
+	return violations
+}
+
+// requireRateLimit wants every proxied route limited per client, by the
+// global anonymous and authenticated tiers or a rateLimit middleware. The
+// gateway-wide limit does not count: one client can use all of it.
+func requireRateLimit(cfg *config.Config, exempt map[string]bool) []Violation {
+	if cfg.RateLimit.Anonymous != nil && cfg.RateLimit.Authenticated != nil {
+		return nil
+	}
+
+	var violations []Violation
+	for i, route := range cfg.Routes {
+		name := routeName(route, i)
+		if route.Redirect != nil || exempt[name] || usesType(cfg, route, map[string]bool{"rateLimit": true}) {
+			continue
+		}
+		violations = append(violations, Violation{Subject: "route " + name, Message: "no per-client rate limit"})
+	}
+	if !exempt[defaultRoute] {
+		violations = append(violations, Violation{Subject: "default proxy", Message: "no per-client rate limit for requests matching no route"})
+	}
+	return violations
+}
+
+// requireTLS wants every connection the gateway accepts or makes to be
+// encrypted
+func requireTLS(cfg *config.Config, exempt map[string]bool) []Violation {
+	var violations []Violation
+	flag := func(subject, message string) {
+		if !exempt[subject] {
+			violations = append(violations, Violation{Subject: subject, Message: message})
+		}
+	}
+
+	if !cfg.Server.TLS.Enabled() {
+		flag("listener", "clients connect over plain HTTP")
+	}
+	for _, l := range cfg.Server.Listeners {
+		if !l.TLS.Enabled() {
+			flag("listener "+l.Address, "clients connect over plain HTTP")
+		}
+	}
+	for _, backend := range config.Instances(cfg.Backends) {
+		if strings.HasPrefix(backend.URL, "http://") {
+			flag(backend.Name, "backend is reached over plain HTTP")
+		}
+	}
+	if cfg.OIDC.Enabled && strings.HasPrefix(cfg.OIDC.IssuerURL, "http://") {
+		flag("oidc", "issuer is reached over plain HTTP")
+	}
+	if cfg.LDAP.Enabled && strings.HasPrefix(cfg.LDAP.URL, "ldap://") && !cfg.LDAP.StartTLS {
+		flag("ldap", "passwords are checked over plain LDAP")
+	}
+	if cfg.Introspection.Enabled && strings.HasPrefix(cfg.Introspection.Endpoint, "http://") {
+		flag("introspection", "endpoint is reached over plain HTTP")
+	}
+	if cfg.ExtAuthz.Enabled && (strings.HasPrefix(cfg.ExtAuthz.URL, "http://") || strings.HasPrefix(cfg.ExtAuthz.URL, "grpc://")) {
+		flag("extAuthz", "authorization service is reached without TLS")
+	}
+	if cfg.Tracing.Enabled && cfg.Tracing.Insecure {
+		flag("tracing", "traces are exported without TLS")
+	}
+	return violations
+}
+
+// requireAdminAuth wants the admin API to demand a token
+func requireAdminAuth(cfg *config.Config, exempt map[string]bool) []Violation {
+	if cfg.Admin.Enabled && cfg.Admin.Token == "" && !exempt["admin"] {
+		return []Violation{{Subject: "admin", Message: "admin API has no token"}}
+	}
+	return nil
+}
+
+func enabledSkipPaths(enabled bool, skipPaths []string) []string {
+	if !enabled {
+		return nil
+	}
+	// Non-nil marks the mechanism as enabled even without skipped paths
+	return append([]string{}, skipPaths...)
+}
+
+func usesType(cfg *config.Config, route config.RouteConfig, types map[string]bool) bool {
+	for _, name := range route.Middlewares {
+		if types[cfg.Middlewares[name].Type] {
+			return true
+		}
+	}
+	return false
+}
+
+// literalPrefix is the fixed part of every path the route matches
+func literalPrefix(route config.RouteConfig) string {
+	var prefix string
+	switch {
+	case route.Glob != "":
+		prefix, _, _ = strings.Cut(route.Glob, "*")
+	case route.Path != "":
+		prefix, _, _ = strings.Cut(route.Path, "{")
+	default:
+		prefix = route.PathPrefix
+	}
+	if prefix == "" {
+		return "/"
+	}
+	return prefix
+}
+
+// skipped reports whether every path under prefix bypasses a mechanism
+// with these skip paths
+func skipped(prefix string, skipPaths []string) bool {
+	for _, skip := range skipPaths {
+		if skip != "" && strings.HasPrefix(prefix, skip) {
+			return true
+		}
+	}
+	return false
+}
+
+func routeName(route config.RouteConfig, i int) string {
+	if route.Name != "" {
+		return route.Name
+	}
+	return fmt.Sprintf("#%d", i)
+}
@@ -0,0 +1,77 @@
+package listener
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/upgrade"
+)
+
+// http3Listener serves the handler over QUIC. Like switchListener, it
+// reads the current TLS config for every new connection, so certificates
+// rotate on reload without rebinding.
+type http3Listener struct {
+	address string
+	conn    net.PacketConn
+	srv     *http3.Server
+	tls     atomic.Pointer[tls.Config]
+	altSvc  string
+}
+
+func newHTTP3Listener(cfg config.HTTP3Config, address string, handler http.Handler) (*http3Listener, error) {
+	conn, err := upgrade.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	maxAge := cfg.AltSvcMaxAge
+	if maxAge == 0 {
+		maxAge = 86400
+	}
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+
+	l := &http3Listener{
+		address: address,
+		conn:    conn,
+		altSvc:  fmt.Sprintf(`h3=":%s"; ma=%d`, port, maxAge),
+	}
+	l.srv = &http3.Server{
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return l.tls.Load(), nil
+			},
+		},
+	}
+	return l, nil
+}
+
+func (l *http3Listener) serve() {
+	logger.Info("Listening on %s (http3)", l.address)
+	if err := l.srv.Serve(l.conn); err != nil && err != http.ErrServerClosed {
+		logger.Error("HTTP/3 listener %s stopped: %v", l.address, err)
+	}
+}
+
+func (l *http3Listener) close() {
+	logger.Info("Closing HTTP/3 listener %s", l.address)
+	l.srv.Close()
+	l.conn.Close()
+}
+
+// advertise adds Alt-Svc to responses over TLS so clients learn about the
+// HTTP/3 listener
+func (m *Manager) advertise(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h3 := m.h3.Load(); h3 != nil && r.TLS != nil && r.ProtoMajor < 3 {
+			w.Header().Set("Alt-Svc", h3.altSvc)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
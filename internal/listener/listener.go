@@ -0,0 +1,275 @@
+// Package listener runs the gateway's client-facing listeners. On reload it
+// binds new sockets before closing old ones, so ports and TLS settings can
+// change without a restart or a window where connections are refused.
+package listener
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/connlimit"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/tlsutil"
+	"github.com/barisgenc/gatekeeper/internal/upgrade"
+)
+
+// drainTimeout bounds how long a removed listener waits for its requests
+const drainTimeout = 30 * time.Second
+
+// Manager serves one handler on every configured listener
+type Manager struct {
+	handler http.Handler
+
+	mu      sync.Mutex
+	running map[string]*server
+	h3      atomic.Pointer[http3Listener]
+}
+
+type server struct {
+	srv      *http.Server
+	listener *switchListener
+}
+
+// NewManager creates a manager with no listeners; Apply starts them
+func NewManager(handler http.Handler) *Manager {
+	return &Manager{handler: handler, running: make(map[string]*server)}
+}
+
+// Apply makes the running listeners match cfg. Listeners on new addresses
+// are bound and serving before listeners on removed addresses start to
+// drain. TLS settings and certificates of kept listeners are swapped in
+// place for new connections. Nothing changes if any listener fails to
+// bind or its TLS settings are invalid.
+func (m *Manager) Apply(cfg config.ServerConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wanted := listeners(cfg)
+
+	tlsConfigs := make(map[string]*tls.Config, len(wanted))
+	for _, l := range wanted {
+		if !l.TLS.Enabled() {
+			continue
+		}
+		tlsCfg, err := serverTLS(l)
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", l.Address, err)
+		}
+		tlsConfigs[l.Address] = tlsCfg
+	}
+
+	// Bind every new socket first so a failure leaves the old set untouched
+	added := make(map[string]*server)
+	var h3 *http3Listener
+	abort := func(err error) error {
+		for _, s := range added {
+			s.listener.Close()
+		}
+		if h3 != nil {
+			h3.conn.Close()
+		}
+		return err
+	}
+
+	current := m.h3.Load()
+	if cfg.HTTP3.Enabled {
+		address := cfg.HTTP3.Address
+		if address == "" {
+			address = cfg.Address
+		}
+		if current == nil || current.address != address {
+			var err error
+			if h3, err = newHTTP3Listener(cfg.HTTP3, address, m.handler); err != nil {
+				return fmt.Errorf("http3 listener %s: %w", address, err)
+			}
+		}
+	}
+
+	for _, l := range wanted {
+		if _, ok := m.running[l.Address]; ok {
+			continue
+		}
+		ln, err := upgrade.Listen("tcp", l.Address)
+		if err != nil {
+			return abort(fmt.Errorf("listener %s: %w", l.Address, err))
+		}
+		if limit := cfg.ConnLimit; limit.PerIPPerSecond > 0 {
+			ln = connlimit.NewListener(ln, limit.PerIPPerSecond, limit.Burst)
+		}
+		srv := &http.Server{
+			Handler:      m.advertise(m.handler),
+			ReadTimeout:  time.Duration(cfg.ReadTimeout) * time.Second,
+			WriteTimeout: time.Duration(cfg.WriteTimeout) * time.Second,
+			IdleTimeout:  time.Duration(cfg.IdleTimeout) * time.Second,
+		}
+		if err := configureHTTP2(srv, l.HTTP2); err != nil {
+			ln.Close()
+			return abort(fmt.Errorf("listener %s: %w", l.Address, err))
+		}
+		added[l.Address] = &server{listener: &switchListener{Listener: ln}, srv: srv}
+	}
+
+	for _, l := range wanted {
+		s, ok := added[l.Address]
+		if !ok {
+			s = m.running[l.Address]
+		}
+		s.listener.tls.Store(tlsConfigs[l.Address])
+	}
+
+	for address, s := range added {
+		m.running[address] = s
+		go serve(address, s)
+	}
+
+	for address, s := range m.running {
+		if !contains(wanted, address) {
+			delete(m.running, address)
+			go drain(address, s)
+		}
+	}
+
+	switch {
+	case h3 != nil:
+		h3.tls.Store(tlsConfigs[cfg.Address])
+		m.h3.Store(h3)
+		go h3.serve()
+		if current != nil {
+			current.close()
+		}
+	case cfg.HTTP3.Enabled:
+		current.tls.Store(tlsConfigs[cfg.Address])
+	case current != nil:
+		m.h3.Store(nil)
+		current.close()
+	}
+	return nil
+}
+
+// Shutdown gracefully stops every listener
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if h3 := m.h3.Swap(nil); h3 != nil {
+		h3.close()
+	}
+
+	var firstErr error
+	for address, s := range m.running {
+		if err := s.srv.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(m.running, address)
+	}
+	return firstErr
+}
+
+// Addresses lists the addresses being served, as bound
+func (m *Manager) Addresses() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	addresses := make([]string, 0, len(m.running))
+	for _, s := range m.running {
+		addresses = append(addresses, s.listener.Addr().String())
+	}
+	return addresses
+}
+
+func serve(address string, s *server) {
+	logger.Info("Listening on %s (tls: %v)", address, s.listener.tls.Load() != nil)
+	if err := s.srv.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+		logger.Error("Listener %s stopped: %v", address, err)
+	}
+}
+
+func drain(address string, s *server) {
+	logger.Info("Closing listener %s", address)
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := s.srv.Shutdown(ctx); err != nil {
+		logger.Warn("Listener %s did not drain in time: %v", address, err)
+	}
+}
+
+// listeners is the primary listener followed by any additional ones
+func listeners(cfg config.ServerConfig) []config.ListenerConfig {
+	return append([]config.ListenerConfig{{Address: cfg.Address, TLS: cfg.TLS, HTTP2: cfg.HTTP2}}, cfg.Listeners...)
+}
+
+// configureHTTP2 sets up HTTP/2 over TLS on srv, and cleartext h2c when
+// asked for, or turns HTTP/2 off
+func configureHTTP2(srv *http.Server, cfg config.HTTP2Config) error {
+	if cfg.Disabled {
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		return nil
+	}
+	streams := cfg.MaxConcurrentStreams
+	if streams == 0 {
+		streams = 250
+	}
+	h2s := &http2.Server{MaxConcurrentStreams: streams, IdleTimeout: srv.IdleTimeout}
+	if err := http2.ConfigureServer(srv, h2s); err != nil {
+		return err
+	}
+	if cfg.H2C {
+		srv.Handler = h2c.NewHandler(srv.Handler, h2s)
+	}
+	return nil
+}
+
+func serverTLS(l config.ListenerConfig) (*tls.Config, error) {
+	cfg := l.TLS
+	tlsCfg, err := tlsutil.ServerConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tlsutil.LoadKeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg.Certificates = []tls.Certificate{cert}
+	tlsCfg.NextProtos = []string{"h2", "http/1.1"}
+	if l.HTTP2.Disabled {
+		tlsCfg.NextProtos = []string{"http/1.1"}
+	}
+	return tlsCfg, nil
+}
+
+func contains(listeners []config.ListenerConfig, address string) bool {
+	for _, l := range listeners {
+		if l.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// switchListener starts TLS on accepted connections with whatever config
+// is current, so TLS can be turned on, off or rotated without rebinding
+type switchListener struct {
+	net.Listener
+	tls atomic.Pointer[tls.Config]
+}
+
+func (l *switchListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tlsCfg := l.tls.Load(); tlsCfg != nil {
+		return tls.Server(conn, tlsCfg), nil
+	}
+	return conn, nil
+}
@@ -0,0 +1,265 @@
+package listener
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func writeCert(t *testing.T) config.TLSConfig {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	dir := t.TempDir()
+	cfg := config.TLSConfig{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	os.WriteFile(cfg.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(cfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return cfg
+}
+
+func get(url string) error {
+	client := &http.Client{
+		Timeout:   time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, DisableKeepAlives: true},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	m := NewManager(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(func() { m.Shutdown(context.Background()) })
+	return m
+}
+
+func TestApplyMovesListener(t *testing.T) {
+	m := newTestManager(t)
+	oldAddress, newAddress := freeAddress(t), freeAddress(t)
+
+	if err := m.Apply(config.ServerConfig{Address: oldAddress}); err != nil {
+		t.Fatal(err)
+	}
+	if err := get("http://" + oldAddress); err != nil {
+		t.Fatalf("old listener: %v", err)
+	}
+
+	if err := m.Apply(config.ServerConfig{Address: newAddress}); err != nil {
+		t.Fatal(err)
+	}
+	if err := get("http://" + newAddress); err != nil {
+		t.Fatalf("new listener: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for get("http://"+oldAddress) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("old listener still serving after reload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestApplyKeepsListenersWhenBindFails(t *testing.T) {
+	m := newTestManager(t)
+	address := freeAddress(t)
+
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	if err := m.Apply(config.ServerConfig{Address: address}); err != nil {
+		t.Fatal(err)
+	}
+	err = m.Apply(config.ServerConfig{
+		Address:   freeAddress(t),
+		Listeners: []config.ListenerConfig{{Address: taken.Addr().String()}},
+	})
+	if err == nil {
+		t.Fatal("expected error binding a taken address")
+	}
+
+	if got := m.Addresses(); len(got) != 1 || got[0] != address {
+		t.Errorf("listeners after failed apply = %v, want [%s]", got, address)
+	}
+	if err := get("http://" + address); err != nil {
+		t.Errorf("listener stopped after failed apply: %v", err)
+	}
+}
+
+func TestApplyTogglesTLSInPlace(t *testing.T) {
+	m := newTestManager(t)
+	address := freeAddress(t)
+
+	if err := m.Apply(config.ServerConfig{Address: address}); err != nil {
+		t.Fatal(err)
+	}
+	if err := get("http://" + address); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Apply(config.ServerConfig{Address: address, TLS: writeCert(t)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := get("https://" + address); err != nil {
+		t.Errorf("https after enabling TLS: %v", err)
+	}
+	// The TLS server answers a plain request with 400 and closes
+	resp, err := http.Get("http://" + address)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("plain HTTP after enabling TLS: status %d, want 400", resp.StatusCode)
+		}
+	}
+}
+
+func TestApplyRejectsBadCertificate(t *testing.T) {
+	m := newTestManager(t)
+	address := freeAddress(t)
+
+	err := m.Apply(config.ServerConfig{
+		Address: address,
+		TLS:     config.TLSConfig{CertFile: "/missing/cert.pem", KeyFile: "/missing/key.pem"},
+	})
+	if err == nil {
+		t.Fatal("expected error for missing certificate")
+	}
+	if got := m.Addresses(); len(got) != 0 {
+		t.Errorf("listeners after failed apply = %v, want none", got)
+	}
+}
+
+// protoOf returns the HTTP version a request to url was served over
+func protoOf(t *testing.T, transport http.RoundTripper, url string) string {
+	t.Helper()
+	client := &http.Client{Timeout: time.Second, Transport: transport}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("get %s: %v", url, err)
+	}
+	resp.Body.Close()
+	return resp.Proto
+}
+
+func TestApplyHTTP2(t *testing.T) {
+	m := newTestManager(t)
+	tlsAddress, disabledAddress, h2cAddress := freeAddress(t), freeAddress(t), freeAddress(t)
+
+	err := m.Apply(config.ServerConfig{
+		Address: tlsAddress,
+		TLS:     writeCert(t),
+		Listeners: []config.ListenerConfig{
+			{Address: disabledAddress, TLS: writeCert(t), HTTP2: config.HTTP2Config{Disabled: true}},
+			{Address: h2cAddress, HTTP2: config.HTTP2Config{H2C: true}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h2 := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, ForceAttemptHTTP2: true}
+	if got := protoOf(t, h2, "https://"+tlsAddress); got != "HTTP/2.0" {
+		t.Errorf("TLS listener served %s, want HTTP/2.0", got)
+	}
+	if got := protoOf(t, h2, "https://"+disabledAddress); got != "HTTP/1.1" {
+		t.Errorf("listener with HTTP/2 disabled served %s, want HTTP/1.1", got)
+	}
+
+	h2c := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	if got := protoOf(t, h2c, "http://"+h2cAddress); got != "HTTP/2.0" {
+		t.Errorf("h2c listener served %s, want HTTP/2.0", got)
+	}
+	if got := protoOf(t, http.DefaultTransport, "http://"+h2cAddress); got != "HTTP/1.1" {
+		t.Errorf("h2c listener served %s to an HTTP/1.1 client, want HTTP/1.1", got)
+	}
+}
+
+func TestApplyHTTP3(t *testing.T) {
+	m := newTestManager(t)
+	address := freeAddress(t)
+	cfg := config.ServerConfig{Address: address, TLS: writeCert(t), HTTP3: config.HTTP3Config{Enabled: true}}
+	if err := m.Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	h3 := &http3.RoundTripper{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer h3.Close()
+	if got := protoOf(t, h3, "https://"+address); got != "HTTP/3.0" {
+		t.Errorf("HTTP/3 listener served %s, want HTTP/3.0", got)
+	}
+
+	h1 := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, DisableKeepAlives: true}
+	altSvc := func() string {
+		client := &http.Client{Timeout: time.Second, Transport: h1}
+		resp, err := client.Get("https://" + address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("Alt-Svc")
+	}
+	_, port, _ := net.SplitHostPort(address)
+	if got, want := altSvc(), `h3=":`+port+`"; ma=86400`; got != want {
+		t.Errorf("Alt-Svc = %q, want %q", got, want)
+	}
+
+	cfg.HTTP3.Enabled = false
+	if err := m.Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := altSvc(); got != "" {
+		t.Errorf("Alt-Svc after disabling HTTP/3 = %q, want none", got)
+	}
+}
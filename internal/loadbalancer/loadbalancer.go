@@ -1,7 +1,11 @@
 package loadbalancer
 
 import (
+	"fmt"
+	"hash/fnv"
+	"math"
 	"math/rand"
+	"slices"
 	"sync"
 	"time"
 
@@ -9,116 +13,565 @@ import (
 	"github.com/barisgenc/gatekeeper/internal/logger"
 )
 
+const (
+	// healthHistorySize bounds the transitions kept per backend
+	healthHistorySize = 100
+
+	// slowStartFloor is the share of its traffic a backend gets as soon as
+	// it becomes healthy during slow start
+	slowStartFloor = 0.1
+
+	// latencyDecay is the weight of each new sample in a backend's latency
+	// average
+	latencyDecay = 0.2
+
+	// failoverMinRequests is how many responses the error rate of the
+	// primaries needs before it can cause a failover
+	failoverMinRequests = 10
+)
+
 type BackendStatus struct {
 	Backend config.Backend
 	Healthy bool
 	Weight  int
+
+	// recovered is when the backend last became healthy, zero if it has
+	// been healthy since startup
+	recovered time.Time
+
+	// latency is the moving average of response times in seconds, 0 until
+	// the first response
+	latency float64
+
+	// history holds the latest health transitions, oldest first
+	history []HealthTransition
+}
+
+// HealthReport is one observation of a backend's health. Source names what
+// made it, e.g. "health_check".
+type HealthReport struct {
+	Healthy bool
+	Reason  string
+	Latency time.Duration
+	Source  string
+}
+
+// HealthTransition records a change in a backend's health
+type HealthTransition struct {
+	Time      time.Time `json:"time"`
+	Healthy   bool      `json:"healthy"`
+	Reason    string    `json:"reason"`
+	LatencyMs float64   `json:"latencyMs"`
+	Source    string    `json:"source"`
 }
 
 type LoadBalancer struct {
 	backends      []*BackendStatus
+	groups        []*group
 	mu            sync.RWMutex
 	currentIndex  int
 	randomSource  *rand.Rand
 	algorithm     string
+	slowStart     time.Duration
+	now           func() time.Time
+
+	// Backup tier state, see SetFailover
+	failover   Failover
+	errors     *errorWindow
+	onBackup   bool
+	primaryOK  time.Time
+	onFailover func(backup bool)
 }
 
+// Failover decides when traffic moves from the primary backends to the
+// backups (config.Backend.Backup): when no primary is healthy, or when
+// more than ErrorRate of the primaries' responses over Window failed.
+// Traffic moves back once the primaries have been fine for HoldTime.
+type Failover struct {
+	ErrorRate float64
+	Window    time.Duration
+	HoldTime  time.Duration
+}
+
+// group is the servers of one configured backend. Requests are balanced
+// between groups first, by the backend's weight, and then between the
+// group's servers, by theirs.
+type group struct {
+	name      string
+	weight    int
+	backup    bool
+	algorithm string
+	servers   []*BackendStatus
+	next      int
+}
+
+// New creates a LoadBalancer over backends, with one BackendStatus per
+// instance (see config.Backend.Instances)
 func New(backends []config.Backend) *LoadBalancer {
 	lb := &LoadBalancer{
-		backends:     make([]*BackendStatus, len(backends)),
 		randomSource: rand.New(rand.NewSource(time.Now().UnixNano())),
 		algorithm:    "round_robin", // Default algorithm
+		now:          time.Now,
+		errors:       newErrorWindow(time.Second),
 	}
 
-	for i, backend := range backends {
-		lb.backends[i] = &BackendStatus{
-			Backend: backend,
-			Healthy: true, // Assume healthy initially
-			Weight:  backend.Weight,
+	for _, backend := range backends {
+		g := &group{name: backend.Name, weight: backend.TotalWeight(), backup: backend.Backup, algorithm: backend.Algorithm}
+		for _, instance := range backend.Instances() {
+			status := &BackendStatus{
+				Backend: instance,
+				Healthy: true, // Assume healthy initially
+				Weight:  instance.Weight,
+				history: []HealthTransition{{
+					Time:    time.Now(),
+					Healthy: true,
+					Reason:  "assumed healthy until first check",
+					Source:  "startup",
+				}},
+			}
+			g.servers = append(g.servers, status)
+			lb.backends = append(lb.backends, status)
 		}
+		lb.groups = append(lb.groups, g)
 	}
 
 	logger.Info("LoadBalancer initialized with %d backends", len(backends))
 	return lb
 }
 
-// NextBackend returns the next backend using round-robin algorithm
+// Choice overrides how one request is balanced. Algorithm replaces the
+// load balancer's when set, Key is what "hash" hashes, and Backends limits
+// the pick to the named backends when set.
+type Choice struct {
+	Algorithm string
+	Key       string
+	Backends  []string
+}
+
+// NextBackend returns a healthy instance of the next backend, chosen by
+// the algorithm
 func (lb *LoadBalancer) NextBackend() *config.Backend {
+	return lb.NextBackendWith(Choice{})
+}
+
+// NextBackendWith is NextBackend for a request balanced as choice says.
+// Backends with an algorithm of their own keep using it to pick between
+// their servers.
+func (lb *LoadBalancer) NextBackendWith(choice Choice) *config.Backend {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
 
-	healthyBackends := lb.getHealthyBackendsLocked()
-	if len(healthyBackends) == 0 {
+	// The tier not in use only serves when the active one has nothing
+	now := lb.now()
+	lb.updateTierLocked(now)
+	var groups, standby []*group
+	for _, g := range lb.groups {
+		switch {
+		case len(choice.Backends) > 0 && !slices.Contains(choice.Backends, g.name):
+		case len(g.healthy()) == 0:
+		case g.backup == lb.onBackup:
+			groups = append(groups, g)
+		default:
+			standby = append(standby, g)
+		}
+	}
+	if len(groups) == 0 {
+		groups = standby
+	}
+	if len(groups) == 0 {
 		logger.Warn("No healthy backends available")
 		return nil
 	}
 
-	switch lb.algorithm {
+	// A group ramps up as fast as its warmest server, and is as fast as
+	// its measured servers are on average
+	candidates := make([]candidate, len(groups))
+	for i, g := range groups {
+		candidates[i].name = g.name
+		candidates[i].weight = g.weight
+		measured := 0
+		for _, server := range g.healthy() {
+			candidates[i].warmth = math.Max(candidates[i].warmth, lb.warmth(server, now))
+			if server.latency > 0 {
+				candidates[i].latency += server.latency
+				measured++
+			}
+		}
+		if measured > 0 {
+			candidates[i].latency /= float64(measured)
+		}
+	}
+	return lb.pickLocked(groups[lb.choose(choice, candidates, &lb.currentIndex)], choice)
+}
+
+// NextInGroup returns a healthy instance of the named backend, or nil when
+// it has none
+func (lb *LoadBalancer) NextInGroup(name string) *config.Backend {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	for _, g := range lb.groups {
+		if g.name == name && len(g.healthy()) > 0 {
+			return lb.pickLocked(g, Choice{})
+		}
+	}
+	return nil
+}
+
+// NextBackendExcept returns a healthy backend other than the named one,
+// one of the backends named in within when given, or nil when there is
+// none
+func (lb *LoadBalancer) NextBackendExcept(name string, within ...string) *config.Backend {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	var others []*BackendStatus
+	for _, backend := range lb.getHealthyBackendsLocked() {
+		if len(within) > 0 && !slices.Contains(within, backend.Backend.Group) {
+			continue
+		}
+		if backend.Backend.Name != name {
+			others = append(others, backend)
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	return &others[lb.roundRobin(len(others), &lb.currentIndex)].Backend
+}
+
+// pickLocked returns one of g's healthy servers, which it must have
+func (lb *LoadBalancer) pickLocked(g *group, choice Choice) *config.Backend {
+	servers := g.healthy()
+	if len(servers) == 1 {
+		return &servers[0].Backend
+	}
+	if g.algorithm != "" {
+		choice.Algorithm = g.algorithm
+	}
+	now := lb.now()
+	candidates := make([]candidate, len(servers))
+	for i, server := range servers {
+		candidates[i] = candidate{name: server.Backend.Name, weight: server.Weight, warmth: lb.warmth(server, now), latency: server.latency}
+	}
+	return &servers[lb.choose(choice, candidates, &g.next)].Backend
+}
+
+func (g *group) healthy() []*BackendStatus {
+	var healthy []*BackendStatus
+	for _, server := range g.servers {
+		if server.Healthy {
+			healthy = append(healthy, server)
+		}
+	}
+	return healthy
+}
+
+// candidate is a group or server to choose from
+type candidate struct {
+	name    string
+	weight  int
+	warmth  float64
+	latency float64
+}
+
+// choose returns the index of one of candidates, using the algorithm of
+// choice or else the load balancer's. Round robin advances counter. While
+// candidates are warming up at different rates, they are picked at random
+// in proportion to their warmth instead, except by hash, which keeps keys
+// where they are.
+func (lb *LoadBalancer) choose(choice Choice, candidates []candidate, counter *int) int {
+	algorithm := choice.Algorithm
+	if algorithm == "" {
+		algorithm = lb.algorithm
+	}
+	if algorithm == "hash" && choice.Key != "" {
+		return rendezvous(choice.Key, candidates)
+	}
+	for _, c := range candidates {
+		if c.warmth != candidates[0].warmth {
+			return lb.chooseWarming(algorithm, candidates)
+		}
+	}
+
+	switch algorithm {
 	case "weighted_round_robin":
-		return lb.weightedRoundRobin(healthyBackends)
+		if i := lb.weightedRoundRobin(candidates); i >= 0 {
+			return i
+		}
 	case "random":
-		return lb.randomBackend(healthyBackends)
+		return lb.randomSource.Intn(len(candidates))
+	case "p2c":
+		return lb.powerOfTwoChoices(candidates)
+	case "least_response_time":
+		return lb.leastResponseTime(candidates, counter)
 	case "least_connections":
 		// For now, fall back to round robin
 		// In a production system, you'd track active connections
-		return lb.roundRobin(healthyBackends)
-	default:
-		return lb.roundRobin(healthyBackends)
 	}
+	return lb.roundRobin(len(candidates), counter)
 }
 
-func (lb *LoadBalancer) roundRobin(healthyBackends []*BackendStatus) *config.Backend {
-	if len(healthyBackends) == 0 {
-		return nil
+// chooseWarming picks a candidate at random in proportion to its weight,
+// under the weighted algorithm, scaled by its warmth
+func (lb *LoadBalancer) chooseWarming(algorithm string, candidates []candidate) int {
+	weighted := false
+	if algorithm == "weighted_round_robin" {
+		for _, c := range candidates {
+			weighted = weighted || c.weight > 0
+		}
 	}
 
-	backend := healthyBackends[lb.currentIndex%len(healthyBackends)]
-	lb.currentIndex++
-	
-	// Prevent overflow
-	if lb.currentIndex >= 1000000 {
-		lb.currentIndex = 0
+	shares := make([]float64, len(candidates))
+	var total float64
+	for i, c := range candidates {
+		shares[i] = c.warmth
+		if weighted {
+			shares[i] *= float64(c.weight)
+		}
+		total += shares[i]
 	}
-	
-	return &backend.Backend
+
+	r := lb.randomSource.Float64() * total
+	for i, share := range shares {
+		if r < share {
+			return i
+		}
+		r -= share
+	}
+	return len(shares) - 1
 }
 
-func (lb *LoadBalancer) weightedRoundRobin(healthyBackends []*BackendStatus) *config.Backend {
-	if len(healthyBackends) == 0 {
-		return nil
+// rendezvous returns the candidate that scores highest for key. A key
+// keeps going to the same candidate, and when one goes away only its keys
+// move. With weights, a candidate's share of keys follows its weight.
+func rendezvous(key string, candidates []candidate) int {
+	weighted := false
+	for _, c := range candidates {
+		weighted = weighted || c.weight > 0
+	}
+
+	best, bestScore := 0, math.Inf(-1)
+	for i, c := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(c.name))
+		sum := mix(h.Sum64())
+
+		score := float64(sum)
+		if weighted {
+			// -w/ln(u) for u uniform in (0, 1) gives each candidate its
+			// weighted share of the highest scores
+			u := (float64(sum>>11) + 0.5) / (1 << 53)
+			score = -float64(c.weight) / math.Log(u)
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// mix spreads the bits of an FNV hash, whose high bits barely change
+// between names that differ in their last byte (MurmurHash3's finalizer)
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// powerOfTwoChoices picks two candidates at random and returns the faster.
+// Unlike always taking the fastest, it spreads load without herding onto
+// one backend between measurements.
+func (lb *LoadBalancer) powerOfTwoChoices(candidates []candidate) int {
+	if len(candidates) == 1 {
+		return 0
+	}
+	a := lb.randomSource.Intn(len(candidates))
+	b := lb.randomSource.Intn(len(candidates) - 1)
+	if b >= a {
+		b++
 	}
+	if candidates[b].latency < candidates[a].latency {
+		return b
+	}
+	return a
+}
+
+// leastResponseTime returns the candidate with the lowest average latency.
+// Ties, such as backends not measured yet, are taken in turn.
+func (lb *LoadBalancer) leastResponseTime(candidates []candidate, counter *int) int {
+	start := lb.roundRobin(len(candidates), counter)
+	best := start
+	for k := 1; k < len(candidates); k++ {
+		i := (start + k) % len(candidates)
+		if candidates[i].latency < candidates[best].latency {
+			best = i
+		}
+	}
+	return best
+}
 
+// warmth is the share of its traffic a server gets during slow start,
+// from slowStartFloor to 1
+func (lb *LoadBalancer) warmth(server *BackendStatus, now time.Time) float64 {
+	if lb.slowStart <= 0 || server.recovered.IsZero() {
+		return 1
+	}
+	elapsed := now.Sub(server.recovered)
+	if elapsed >= lb.slowStart {
+		return 1
+	}
+	return math.Max(slowStartFloor, float64(elapsed)/float64(lb.slowStart))
+}
+
+// RecordResponse records a response from the named backend. Failed
+// responses count towards the primaries' error rate; the latency of the
+// others goes into the backend's moving average, which latency-aware
+// algorithms prefer low.
+func (lb *LoadBalancer) RecordResponse(backendName string, latency time.Duration, failed bool) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	for _, backend := range lb.backends {
+		if backend.Backend.Name == backendName {
+			if !backend.Backend.Backup {
+				lb.errors.record(lb.now(), failed)
+			}
+			if failed {
+				return
+			}
+			sample := latency.Seconds()
+			if backend.latency == 0 {
+				backend.latency = sample
+			} else {
+				backend.latency += latencyDecay * (sample - backend.latency)
+			}
+			return
+		}
+	}
+}
+
+// SetFailover sets when traffic moves to the backup backends
+func (lb *LoadBalancer) SetFailover(failover Failover) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if failover.Window != lb.failover.Window {
+		lb.errors = newErrorWindow(failover.Window)
+	}
+	lb.failover = failover
+}
+
+// OnFailover registers fn to be called with the tier traffic moves to,
+// true for the backups. When there are backups, it is called right away
+// with the current tier.
+func (lb *LoadBalancer) OnFailover(fn func(backup bool)) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.onFailover = fn
+	for _, g := range lb.groups {
+		if g.backup {
+			fn(lb.onBackup)
+			return
+		}
+	}
+}
+
+// updateTierLocked moves traffic to the backups when the primaries are
+// down or failing, and back once they have been fine for the hold time
+func (lb *LoadBalancer) updateTierLocked(now time.Time) {
+	primaryUp, haveBackup := false, false
+	for _, g := range lb.groups {
+		if g.backup {
+			haveBackup = true
+		} else if len(g.healthy()) > 0 {
+			primaryUp = true
+		}
+	}
+	if !haveBackup {
+		return
+	}
+
+	reason := "no healthy primary backend"
+	if primaryUp && lb.failover.ErrorRate > 0 {
+		if rate, n := lb.errors.rate(now); n >= failoverMinRequests && rate > lb.failover.ErrorRate {
+			primaryUp = false
+			reason = fmt.Sprintf("primary error rate %.0f%%", rate*100)
+		}
+	}
+
+	switch {
+	case !primaryUp && !lb.onBackup:
+		logger.Warn("Failing over to backup backends: %s", reason)
+		lb.setTierLocked(true)
+	case !primaryUp:
+		lb.primaryOK = time.Time{}
+	case !lb.onBackup:
+	case lb.primaryOK.IsZero():
+		lb.primaryOK = now
+	case now.Sub(lb.primaryOK) >= lb.failover.HoldTime:
+		logger.Info("Primary backends recovered, moving traffic back from backups")
+		lb.setTierLocked(false)
+	}
+}
+
+func (lb *LoadBalancer) setTierLocked(backup bool) {
+	lb.onBackup = backup
+	lb.primaryOK = time.Time{}
+	if lb.onFailover != nil {
+		lb.onFailover(backup)
+	}
+}
+
+// SetSlowStart makes backends that become healthy again ramp up to their
+// share of traffic over window; 0 turns slow start off
+func (lb *LoadBalancer) SetSlowStart(window time.Duration) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.slowStart = window
+}
+
+func (lb *LoadBalancer) roundRobin(n int, counter *int) int {
+	i := *counter % n
+	*counter++
+
+	// Prevent overflow
+	if *counter >= 1000000 {
+		*counter = 0
+	}
+
+	return i
+}
+
+// weightedRoundRobin picks a candidate at random in proportion to its
+// weight, or returns -1 when they all weigh 0
+func (lb *LoadBalancer) weightedRoundRobin(candidates []candidate) int {
 	totalWeight := 0
-	for _, backend := range healthyBackends {
-		totalWeight += backend.Weight
+	for _, c := range candidates {
+		totalWeight += c.weight
 	}
 
 	if totalWeight == 0 {
-		return lb.roundRobin(healthyBackends)
+		return -1
 	}
 
 	// Generate random number between 0 and totalWeight
 	randomWeight := lb.randomSource.Intn(totalWeight)
-	
+
 	currentWeight := 0
-	for _, backend := range healthyBackends {
-		currentWeight += backend.Weight
+	for i, c := range candidates {
+		currentWeight += c.weight
 		if randomWeight < currentWeight {
-			return &backend.Backend
+			return i
 		}
 	}
 
 	// Fallback to first backend
-	return &healthyBackends[0].Backend
-}
-
-func (lb *LoadBalancer) randomBackend(healthyBackends []*BackendStatus) *config.Backend {
-	if len(healthyBackends) == 0 {
-		return nil
-	}
-
-	index := lb.randomSource.Intn(len(healthyBackends))
-	return &healthyBackends[index].Backend
+	return 0
 }
 
 func (lb *LoadBalancer) getHealthyBackendsLocked() []*BackendStatus {
@@ -140,20 +593,99 @@ func (lb *LoadBalancer) GetHealthyBackends() []*BackendStatus {
 
 // SetBackendHealth updates the health status of a backend
 func (lb *LoadBalancer) SetBackendHealth(backendName string, healthy bool) {
+	lb.ReportHealth(backendName, HealthReport{Healthy: healthy})
+}
+
+// ReportHealth updates the health status of a backend, adding the report
+// to its history when the status changes. It reports whether it changed.
+func (lb *LoadBalancer) ReportHealth(backendName string, report HealthReport) bool {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
 
 	for _, backend := range lb.backends {
 		if backend.Backend.Name == backendName {
-			if backend.Healthy != healthy {
-				logger.Info("Backend %s health changed: %v -> %v", backendName, backend.Healthy, healthy)
-				backend.Healthy = healthy
+			if backend.Healthy != report.Healthy {
+				logger.Info("Backend %s health changed: %v -> %v (%s)", backendName, backend.Healthy, report.Healthy, report.Reason)
+				backend.Healthy = report.Healthy
+				if report.Healthy {
+					backend.recovered = lb.now()
+				}
+				backend.record(report)
+				return true
 			}
-			return
+			return false
 		}
 	}
 
 	logger.Warn("Backend %s not found when updating health status", backendName)
+	return false
+}
+
+// HealthHistory returns the backend's recent health transitions, oldest
+// first, and false if there is no such backend
+func (lb *LoadBalancer) HealthHistory(backendName string) ([]HealthTransition, bool) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	for _, backend := range lb.backends {
+		if backend.Backend.Name == backendName {
+			return append([]HealthTransition(nil), backend.history...), true
+		}
+	}
+	return nil, false
+}
+
+func (b *BackendStatus) record(report HealthReport) {
+	if len(b.history) == healthHistorySize {
+		copy(b.history, b.history[1:])
+		b.history = b.history[:healthHistorySize-1]
+	}
+	b.history = append(b.history, HealthTransition{
+		Time:      time.Now(),
+		Healthy:   report.Healthy,
+		Reason:    report.Reason,
+		LatencyMs: float64(report.Latency.Microseconds()) / 1000,
+		Source:    report.Source,
+	})
+}
+
+// Capacity is the share of traffic the healthy instances can take, from 0
+// to 1. It is weighted like traffic is: each backend by its weight, then
+// each server of a group by its. Without weights every backend, and every
+// server within a group, counts the same.
+func (lb *LoadBalancer) Capacity() float64 {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	var weighted, unweighted, totalWeight float64
+	for _, g := range lb.groups {
+		totalWeight += float64(g.weight)
+		weighted += float64(g.weight) * g.healthyShare()
+		unweighted += g.healthyShare()
+	}
+	switch {
+	case totalWeight > 0:
+		return weighted / totalWeight
+	case len(lb.groups) > 0:
+		return unweighted / float64(len(lb.groups))
+	}
+	return 0
+}
+
+// healthyShare is the share of g's traffic its healthy servers can take
+func (g *group) healthyShare() float64 {
+	var healthy, total int
+	for _, server := range g.servers {
+		total += server.Weight
+		if server.Healthy {
+			healthy += server.Weight
+		}
+	}
+	if total == 0 {
+		total = len(g.servers)
+		healthy = len(g.healthy())
+	}
+	return float64(healthy) / float64(total)
 }
 
 // SetAlgorithm sets the load balancing algorithm
@@ -166,6 +698,9 @@ func (lb *LoadBalancer) SetAlgorithm(algorithm string) {
 		"weighted_round_robin": true,
 		"random":               true,
 		"least_connections":    true,
+		"p2c":                  true,
+		"least_response_time":  true,
+		"hash":                 true,
 	}
 
 	if !validAlgorithms[algorithm] {
@@ -191,18 +726,72 @@ func (lb *LoadBalancer) GetStats() map[string]interface{} {
 	stats["healthy_backends"] = healthyBackends
 	stats["unhealthy_backends"] = totalBackends - healthyBackends
 	stats["algorithm"] = lb.algorithm
+	stats["active_tier"] = "primary"
+	if lb.onBackup {
+		stats["active_tier"] = "backup"
+	}
 
 	backendStats := make([]map[string]interface{}, 0, len(lb.backends))
 	for _, backend := range lb.backends {
 		backendStat := map[string]interface{}{
-			"name":    backend.Backend.Name,
-			"url":     backend.Backend.URL,
-			"healthy": backend.Healthy,
-			"weight":  backend.Weight,
+			"name":       backend.Backend.Name,
+			"group":      backend.Backend.Group,
+			"url":        backend.Backend.URL,
+			"healthy":    backend.Healthy,
+			"weight":     backend.Weight,
+			"warmth":     lb.warmth(backend, lb.now()),
+			"latency_ms": backend.latency * 1000,
 		}
 		backendStats = append(backendStats, backendStat)
 	}
 	stats["backends"] = backendStats
 
 	return stats
-}
\ No newline at end of file
+}
+// errorWindow counts responses and failures in one-second buckets over a
+// window
+type errorWindow struct {
+	buckets []errorBucket
+}
+
+type errorBucket struct {
+	second        int64
+	total, failed int
+}
+
+func newErrorWindow(window time.Duration) *errorWindow {
+	n := int(window / time.Second)
+	if n < 1 {
+		n = 1
+	}
+	return &errorWindow{buckets: make([]errorBucket, n)}
+}
+
+func (w *errorWindow) record(now time.Time, failed bool) {
+	second := now.Unix()
+	b := &w.buckets[second%int64(len(w.buckets))]
+	if b.second != second {
+		*b = errorBucket{second: second}
+	}
+	b.total++
+	if failed {
+		b.failed++
+	}
+}
+
+// rate returns the share of failed responses in the window and how many
+// responses there were
+func (w *errorWindow) rate(now time.Time) (float64, int) {
+	oldest := now.Unix() - int64(len(w.buckets))
+	var total, failed int
+	for _, b := range w.buckets {
+		if b.second > oldest {
+			total += b.total
+			failed += b.failed
+		}
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	return float64(failed) / float64(total), total
+}
@@ -1,7 +1,9 @@
 package loadbalancer
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/barisgenc/gatekeeper/internal/config"
 )
@@ -87,6 +89,94 @@ func TestSetBackendHealth(t *testing.T) {
 	}
 }
 
+func TestHealthHistory(t *testing.T) {
+	lb := New([]config.Backend{{Name: "backend1", URL: "http://localhost:3001"}})
+
+	lb.ReportHealth("backend1", HealthReport{Healthy: false, Reason: "connection refused", Latency: 2 * time.Millisecond, Source: "health_check"})
+	lb.ReportHealth("backend1", HealthReport{Healthy: false, Reason: "connection refused", Source: "health_check"})
+	lb.ReportHealth("backend1", HealthReport{Healthy: true, Reason: "status 200 OK", Source: "health_check"})
+
+	history, ok := lb.HealthHistory("backend1")
+	if !ok {
+		t.Fatal("Expected history for backend1")
+	}
+	if len(history) != 3 {
+		t.Fatalf("Expected startup and two transitions, got %+v", history)
+	}
+	if history[0].Source != "startup" || !history[0].Healthy {
+		t.Errorf("Expected startup entry first, got %+v", history[0])
+	}
+	if history[1].Healthy || history[1].Reason != "connection refused" || history[1].LatencyMs != 2 {
+		t.Errorf("Unexpected down transition %+v", history[1])
+	}
+	if !history[2].Healthy {
+		t.Errorf("Expected recovery last, got %+v", history[2])
+	}
+
+	if _, ok := lb.HealthHistory("missing"); ok {
+		t.Error("Expected no history for unknown backend")
+	}
+}
+
+func TestHealthHistoryIsBounded(t *testing.T) {
+	lb := New([]config.Backend{{Name: "backend1", URL: "http://localhost:3001"}})
+
+	for i := 0; i < healthHistorySize+10; i++ {
+		lb.ReportHealth("backend1", HealthReport{Healthy: i%2 == 1})
+	}
+
+	history, _ := lb.HealthHistory("backend1")
+	if len(history) != healthHistorySize {
+		t.Fatalf("Expected %d transitions, got %d", healthHistorySize, len(history))
+	}
+	if !history[len(history)-1].Healthy {
+		t.Error("Expected the latest transition to be kept")
+	}
+}
+
+func TestNextBackendExcept(t *testing.T) {
+	backends := []config.Backend{
+		{Name: "backend1", URL: "http://localhost:3001", Weight: 50},
+		{Name: "backend2", URL: "http://localhost:3002", Weight: 50},
+		{Name: "backend3", URL: "http://localhost:3003", Weight: 50},
+	}
+
+	lb := New(backends)
+	lb.SetBackendHealth("backend3", false)
+
+	for i := 0; i < 10; i++ {
+		if backend := lb.NextBackendExcept("backend1"); backend == nil || backend.Name != "backend2" {
+			t.Fatalf("Expected backend2, got %v", backend)
+		}
+	}
+
+	lb.SetBackendHealth("backend2", false)
+	if backend := lb.NextBackendExcept("backend1"); backend != nil {
+		t.Errorf("Expected no other healthy backend, got %s", backend.Name)
+	}
+}
+
+func TestNextBackendWithin(t *testing.T) {
+	lb := New([]config.Backend{
+		{Name: "v1", URL: "http://localhost:3001", Weight: 50},
+		{Name: "v2", URL: "http://localhost:3002", Weight: 50, Servers: []config.BackendServer{{URL: "http://localhost:3003"}, {URL: "http://localhost:3004"}}},
+	})
+
+	for i := 0; i < 10; i++ {
+		if backend := lb.NextBackendWith(Choice{Backends: []string{"v2"}}); backend == nil || backend.Group != "v2" {
+			t.Fatalf("Expected an instance of v2, got %v", backend)
+		}
+	}
+	if backend := lb.NextBackendExcept("v2-1", "v2"); backend == nil || backend.Name != "v2-2" {
+		t.Errorf("Expected the other v2 instance, got %v", backend)
+	}
+
+	lb.SetBackendHealth("v1", false)
+	if backend := lb.NextBackendWith(Choice{Backends: []string{"v1"}}); backend != nil {
+		t.Errorf("Expected no healthy v1 backend, got %s", backend.Name)
+	}
+}
+
 func TestWeightedRoundRobin(t *testing.T) {
 	backends := []config.Backend{
 		{Name: "backend1", URL: "http://localhost:3001", Weight: 75},
@@ -246,4 +336,291 @@ func BenchmarkNextBackendWeighted(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		lb.NextBackend()
 	}
-}
\ No newline at end of file
+}
+func TestBackendGroups(t *testing.T) {
+	lb := New([]config.Backend{
+		{Name: "api", Servers: []config.BackendServer{
+			{URL: "http://10.0.0.1:3000"},
+			{URL: "http://10.0.0.2:3000"},
+		}},
+		{Name: "web", URL: "http://web:3000"},
+	})
+
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		backend := lb.NextBackend()
+		if backend == nil {
+			t.Fatal("Expected a backend, got nil")
+		}
+		counts[backend.Name]++
+	}
+	// Round robin alternates between groups, then between servers
+	if counts["web"] != 4 || counts["api-1"] != 2 || counts["api-2"] != 2 {
+		t.Errorf("Expected web 4, api-1 2 and api-2 2, got %v", counts)
+	}
+
+	lb.SetBackendHealth("api-1", false)
+	for i := 0; i < 4; i++ {
+		if backend := lb.NextInGroup("api"); backend == nil || backend.Name != "api-2" {
+			t.Errorf("Expected api-2 while api-1 is down, got %v", backend)
+		}
+	}
+	if got := lb.Capacity(); got != 0.75 {
+		t.Errorf("Expected capacity 0.75, got %v", got)
+	}
+
+	lb.SetBackendHealth("api-2", false)
+	if backend := lb.NextInGroup("api"); backend != nil {
+		t.Errorf("Expected no instance of api, got %s", backend.Name)
+	}
+	for i := 0; i < 3; i++ {
+		if backend := lb.NextBackend(); backend == nil || backend.Name != "web" {
+			t.Errorf("Expected web while api is down, got %v", backend)
+		}
+	}
+}
+
+func TestWeightedBackendGroups(t *testing.T) {
+	lb := New([]config.Backend{
+		{Name: "api", Weight: 1, Servers: []config.BackendServer{
+			{URL: "http://10.0.0.1:3000", Weight: 3},
+			{URL: "http://10.0.0.2:3000", Weight: 1},
+		}},
+		{Name: "web", URL: "http://web:3000", Weight: 1},
+	})
+	lb.SetAlgorithm("weighted_round_robin")
+
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		counts[lb.NextBackend().Name]++
+	}
+	// The group's weight applies to the group, its servers' within it
+	if counts["web"] < 1800 || counts["web"] > 2200 {
+		t.Errorf("Expected web to get about half the traffic, got %v", counts)
+	}
+	if counts["api-1"] < 2*counts["api-2"] {
+		t.Errorf("Expected api-1 to get about three times api-2's traffic, got %v", counts)
+	}
+
+	lb.SetBackendHealth("api-1", false)
+	if got := lb.Capacity(); got != 0.625 {
+		t.Errorf("Expected capacity 0.625, got %v", got)
+	}
+}
+
+func TestSlowStart(t *testing.T) {
+	lb := New([]config.Backend{
+		{Name: "backend1", URL: "http://localhost:3001"},
+		{Name: "backend2", URL: "http://localhost:3002"},
+	})
+	now := time.Now()
+	lb.now = func() time.Time { return now }
+	lb.SetSlowStart(100 * time.Second)
+
+	share := func() float64 {
+		n := 0
+		for i := 0; i < 10000; i++ {
+			if lb.NextBackend().Name == "backend1" {
+				n++
+			}
+		}
+		return float64(n) / 10000
+	}
+
+	lb.SetBackendHealth("backend1", false)
+	lb.SetBackendHealth("backend1", true)
+
+	// Warmth 0.1 against 1, then 0.5 against 1
+	if got := share(); got < 0.06 || got > 0.12 {
+		t.Errorf("Expected backend1 to get about 9%% of traffic right after recovering, got %.3f", got)
+	}
+	now = now.Add(50 * time.Second)
+	if got := share(); got < 0.29 || got > 0.38 {
+		t.Errorf("Expected backend1 to get about 33%% of traffic halfway through, got %.3f", got)
+	}
+
+	// Fully warm, round robin alternates again
+	now = now.Add(50 * time.Second)
+	first, second := lb.NextBackend(), lb.NextBackend()
+	if first.Name == second.Name {
+		t.Errorf("Expected round robin after slow start, got %s twice", first.Name)
+	}
+}
+
+func TestLatencyAwareAlgorithms(t *testing.T) {
+	backends := []config.Backend{
+		{Name: "fast", URL: "http://localhost:3001"},
+		{Name: "medium", URL: "http://localhost:3002"},
+		{Name: "slow", URL: "http://localhost:3003"},
+	}
+
+	lb := New(backends)
+	lb.SetAlgorithm("least_response_time")
+	// Unmeasured backends are tried first
+	lb.RecordResponse("fast", 10*time.Millisecond, false)
+	lb.RecordResponse("slow", 100*time.Millisecond, false)
+	if got := lb.NextBackend().Name; got != "medium" {
+		t.Errorf("Expected the unmeasured backend first, got %s", got)
+	}
+	lb.RecordResponse("medium", 50*time.Millisecond, false)
+	for i := 0; i < 5; i++ {
+		if got := lb.NextBackend().Name; got != "fast" {
+			t.Errorf("Expected fast, got %s", got)
+		}
+	}
+
+	// The average moves a fifth of the way to each sample
+	lb.RecordResponse("fast", 260*time.Millisecond, false)
+	if got := lb.NextBackend().Name; got != "medium" {
+		t.Errorf("Expected medium once fast averages 60ms, got %s", got)
+	}
+
+	lb = New(backends)
+	lb.SetAlgorithm("p2c")
+	lb.RecordResponse("fast", 10*time.Millisecond, false)
+	lb.RecordResponse("medium", 50*time.Millisecond, false)
+	lb.RecordResponse("slow", 100*time.Millisecond, false)
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		counts[lb.NextBackend().Name]++
+	}
+	// fast wins whenever it is one of the two choices, slow never does
+	if counts["slow"] != 0 || counts["fast"] < 1800 || counts["fast"] > 2200 {
+		t.Errorf("Expected fast about 2/3 of the time and slow never, got %v", counts)
+	}
+}
+
+func TestHashAlgorithm(t *testing.T) {
+	backends := []config.Backend{
+		{Name: "backend1", URL: "http://localhost:3001"},
+		{Name: "backend2", URL: "http://localhost:3002"},
+		{Name: "backend3", URL: "http://localhost:3003"},
+	}
+	lb := New(backends)
+	lb.SetAlgorithm("hash")
+
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		owners[key] = lb.NextBackendWith(Choice{Key: key}).Name
+		counts[owners[key]]++
+	}
+	for _, backend := range backends {
+		if counts[backend.Name] < 800 || counts[backend.Name] > 1200 {
+			t.Errorf("Expected about a third of the keys on each backend, got %v", counts)
+			break
+		}
+	}
+	for key, owner := range owners {
+		if got := lb.NextBackendWith(Choice{Key: key}).Name; got != owner {
+			t.Fatalf("Expected %s to stay on %s, got %s", key, owner, got)
+		}
+	}
+
+	// Only the keys of a backend that goes away move
+	lb.SetBackendHealth("backend2", false)
+	for key, owner := range owners {
+		got := lb.NextBackendWith(Choice{Key: key}).Name
+		if owner != "backend2" && got != owner {
+			t.Fatalf("Expected %s to stay on %s, got %s", key, owner, got)
+		}
+	}
+
+	// Without a key, hash is round robin
+	first, second := lb.NextBackend().Name, lb.NextBackend().Name
+	if first == second {
+		t.Errorf("Expected requests without a key to alternate, got %s twice", first)
+	}
+}
+
+func TestBalancerOverrides(t *testing.T) {
+	lb := New([]config.Backend{
+		{Name: "api", Algorithm: "hash", Servers: []config.BackendServer{
+			{URL: "http://10.0.0.1:3000"},
+			{URL: "http://10.0.0.2:3000"},
+		}},
+	})
+
+	// The group hashes between its servers under the global round robin
+	first := lb.NextBackendWith(Choice{Key: "tenant-a"}).Name
+	for i := 0; i < 5; i++ {
+		if got := lb.NextBackendWith(Choice{Key: "tenant-a"}).Name; got != first {
+			t.Errorf("Expected tenant-a to stay on %s, got %s", first, got)
+		}
+	}
+
+	// A choice's algorithm replaces the load balancer's
+	lb = New([]config.Backend{
+		{Name: "backend1", URL: "http://localhost:3001"},
+		{Name: "backend2", URL: "http://localhost:3002"},
+	})
+	first = lb.NextBackendWith(Choice{Algorithm: "hash", Key: "tenant-a"}).Name
+	for i := 0; i < 5; i++ {
+		if got := lb.NextBackendWith(Choice{Algorithm: "hash", Key: "tenant-a"}).Name; got != first {
+			t.Errorf("Expected tenant-a to stay on %s, got %s", first, got)
+		}
+	}
+	if lb.NextBackend().Name == lb.NextBackend().Name {
+		t.Error("Expected round robin without a choice")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	lb := New([]config.Backend{
+		{Name: "primary", URL: "http://localhost:3001"},
+		{Name: "backup", URL: "http://localhost:3002", Backup: true},
+	})
+	now := time.Now()
+	lb.now = func() time.Time { return now }
+	lb.SetFailover(Failover{ErrorRate: 0.5, Window: 10 * time.Second, HoldTime: 30 * time.Second})
+	var tiers []bool
+	lb.OnFailover(func(backup bool) { tiers = append(tiers, backup) })
+
+	expect := func(want string) {
+		t.Helper()
+		for i := 0; i < 3; i++ {
+			if got := lb.NextBackend(); got == nil || got.Name != want {
+				t.Fatalf("Expected %s, got %v", want, got)
+			}
+		}
+	}
+
+	expect("primary")
+	lb.SetBackendHealth("primary", false)
+	expect("backup")
+
+	// Hysteresis: the primary has to stay healthy for the hold time
+	lb.SetBackendHealth("primary", true)
+	expect("backup")
+	now = now.Add(29 * time.Second)
+	expect("backup")
+	now = now.Add(time.Second)
+	expect("primary")
+
+	// Failing responses from the primary fail over too
+	for i := 0; i < 9; i++ {
+		lb.RecordResponse("primary", time.Millisecond, true)
+	}
+	expect("primary") // not enough responses yet
+	lb.RecordResponse("primary", time.Millisecond, true)
+	expect("backup")
+
+	// The failures age out of the window before the hold time starts
+	now = now.Add(11 * time.Second)
+	expect("backup")
+	now = now.Add(30 * time.Second)
+	expect("primary")
+
+	want := []bool{false, true, false, true, false}
+	if fmt.Sprint(tiers) != fmt.Sprint(want) {
+		t.Errorf("Expected tier changes %v, got %v", want, tiers)
+	}
+
+	// Without a healthy backup, the primary keeps serving
+	lb.SetBackendHealth("backup", false)
+	for i := 0; i < 10; i++ {
+		lb.RecordResponse("primary", time.Millisecond, true)
+	}
+	expect("primary")
+}
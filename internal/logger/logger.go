@@ -10,6 +10,15 @@ import (
 
 var log *logrus.Logger
 
+// filter rewrites every message before it is logged
+var filter func(string) string
+
+// SetFilter passes every message through f before it is logged, e.g. to
+// mask credentials. Call it at startup, before logging concurrently.
+func SetFilter(f func(string) string) {
+	filter = f
+}
+
 func Init(level string) {
 	log = logrus.New()
 	log.SetFormatter(&logrus.JSONFormatter{
@@ -31,6 +40,9 @@ func Init(level string) {
 }
 
 func Debug(format string, args ...interface{}) {
+	if filter != nil {
+		format, args = "%s", []interface{}{filter(fmt.Sprintf(format, args...))}
+	}
 	if log == nil {
 		fmt.Printf(format+"\n", args...)
 		return
@@ -39,6 +51,9 @@ func Debug(format string, args ...interface{}) {
 }
 
 func Info(format string, args ...interface{}) {
+	if filter != nil {
+		format, args = "%s", []interface{}{filter(fmt.Sprintf(format, args...))}
+	}
 	if log == nil {
 		fmt.Printf(format+"\n", args...)
 		return
@@ -47,6 +62,9 @@ func Info(format string, args ...interface{}) {
 }
 
 func Warn(format string, args ...interface{}) {
+	if filter != nil {
+		format, args = "%s", []interface{}{filter(fmt.Sprintf(format, args...))}
+	}
 	if log == nil {
 		fmt.Printf(format+"\n", args...)
 		return
@@ -55,6 +73,9 @@ func Warn(format string, args ...interface{}) {
 }
 
 func Error(format string, args ...interface{}) {
+	if filter != nil {
+		format, args = "%s", []interface{}{filter(fmt.Sprintf(format, args...))}
+	}
 	if log == nil {
 		fmt.Printf(format+"\n", args...)
 		return
@@ -63,6 +84,9 @@ func Error(format string, args ...interface{}) {
 }
 
 func Fatal(format string, args ...interface{}) {
+	if filter != nil {
+		format, args = "%s", []interface{}{filter(fmt.Sprintf(format, args...))}
+	}
 	if log == nil {
 		fmt.Printf(format+"\n", args...)
 		os.Exit(1)
@@ -3,10 +3,12 @@ package metrics
 import (
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
 var (
@@ -19,13 +21,28 @@ var (
 		[]string{"method", "status", "backend"},
 	)
 
-	requestDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "gatekeeper_request_duration_seconds",
-			Help:    "HTTP request duration in seconds",
-			Buckets: prometheus.DefBuckets,
+	requestDuration = newRequestDuration(prometheus.DefBuckets)
+
+	// Route metrics
+	routeRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_route_requests_total",
+			Help: "Total number of HTTP requests by route",
 		},
-		[]string{"method", "backend"},
+		[]string{"route", "method", "status"},
+	)
+
+	routeDuration   = newRouteDuration(prometheus.DefBuckets)
+	upstreamLatency = newUpstreamDuration(prometheus.DefBuckets)
+	requestSize     = newSizeHistogram("gatekeeper_request_size_bytes", "Request body size in bytes by route", defaultSizeBuckets)
+	responseSize    = newSizeHistogram("gatekeeper_response_size_bytes", "Response body size in bytes by route", defaultSizeBuckets)
+
+	routeInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_route_in_flight_requests",
+			Help: "Requests each route is currently handling",
+		},
+		[]string{"route"},
 	)
 
 	// Backend metrics
@@ -37,6 +54,22 @@ var (
 		[]string{"backend", "status"},
 	)
 
+	clientProtocolRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_client_protocol_requests_total",
+			Help: "Total number of client requests by HTTP version",
+		},
+		[]string{"protocol"},
+	)
+
+	backendProtocolRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_backend_protocol_requests_total",
+			Help: "Total number of backend responses by HTTP version",
+		},
+		[]string{"backend", "protocol"},
+	)
+
 	backendUp = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "gatekeeper_backend_up",
@@ -53,6 +86,476 @@ var (
 		},
 	)
 
+	tierRateLimitedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_tier_rate_limited_requests_total",
+			Help: "Total number of requests rejected by the anonymous, authenticated or plan per-client limit",
+		},
+		[]string{"tier"},
+	)
+
+	expressionDenied = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_expression_denied_requests_total",
+			Help: "Requests rejected by their route's allow expression",
+		},
+		[]string{"route"},
+	)
+
+	apiVersionRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_api_version_requests_total",
+			Help: "Requests per API version, and whether the version is deprecated",
+		},
+		[]string{"version", "deprecated"},
+	)
+
+	maintenanceRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_maintenance_rejected_requests_total",
+			Help: "Requests answered with a 503 by maintenance mode",
+		},
+		[]string{"route"},
+	)
+
+	botDetections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_bot_detections_total",
+			Help: "Requests scored as bots, by what was done with them",
+		},
+		[]string{"route", "action"},
+	)
+
+	honeypotHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_honeypot_hits_total",
+			Help: "Requests for an auto-ban honeypot path",
+		},
+		[]string{"honeypot"},
+	)
+
+	tarpittedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_tarpitted_requests_total",
+			Help: "Rejected requests held before being answered, or answered at once because the tarpit was full",
+		},
+		[]string{"result"},
+	)
+
+	rateLimitServiceDecisions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_ratelimit_service_requests_total",
+			Help: "Requests checked with the external rate limit service, by result",
+		},
+		[]string{"result"},
+	)
+
+	extProcExchanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_ext_proc_requests_total",
+			Help: "Requests streamed through the external processor, by result",
+		},
+		[]string{"result"},
+	)
+
+	quotaExceededRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_quota_exceeded_requests_total",
+			Help: "Requests rejected because the consumer used up its daily or monthly quota",
+		},
+		[]string{"period"},
+	)
+
+	connectionsRejected = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_connections_rejected_total",
+			Help: "Total number of connections closed for exceeding the per-IP connection rate",
+		},
+	)
+
+	autoBansTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_autoban_bans_total",
+			Help: "Total number of client IPs banned automatically",
+		},
+	)
+
+	autoBanActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_autoban_active_bans",
+			Help: "Number of client IPs currently banned",
+		},
+	)
+
+	negativeCacheHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_negative_cache_hits_total",
+			Help: "Total number of error responses served from a route's negative cache",
+		},
+		[]string{"route", "status"},
+	)
+
+	// Incident metrics
+	incidentActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_incident_active",
+			Help: "Whether incident mode is active (1 = active, 0 = normal)",
+		},
+	)
+
+	incidentsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_incidents_total",
+			Help: "Total number of incidents opened by the 5xx detector",
+		},
+	)
+
+	incidentShed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_incident_shed_requests_total",
+			Help: "Total number of low-priority requests shed during incidents",
+		},
+	)
+
+	// Idempotency metrics
+	idempotentReplays = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_idempotency_requests_total",
+			Help: "Requests carrying an idempotency key, by outcome (stored, replayed, conflict, mismatch, released)",
+		},
+		[]string{"outcome"},
+	)
+
+	// Coalescing metrics
+	coalescedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_coalesced_requests_total",
+			Help: "Total number of requests answered with another request's backend response",
+		},
+		[]string{"route"},
+	)
+
+	// Request validation metrics
+	openAPIInvalidRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_openapi_invalid_requests_total",
+			Help: "Total number of requests rejected for not matching the OpenAPI spec",
+		},
+		[]string{"route"},
+	)
+
+	bodySchemaFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_body_schema_failures_total",
+			Help: "Total number of request bodies that did not match their route's JSON Schema",
+		},
+		[]string{"route", "mode"},
+	)
+
+	responseTransformFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_response_transform_failures_total",
+			Help: "Total number of JSON responses replaced by a 502 because they could not be transformed",
+		},
+		[]string{"route", "reason"},
+	)
+
+	aggregatePartFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_aggregate_part_failures_total",
+			Help: "Total number of aggregate route parts that failed",
+		},
+		[]string{"route", "part"},
+	)
+
+	headersDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_headers_dropped_total",
+			Help: "Total number of headers dropped to fit a route's header limits",
+		},
+		[]string{"route", "direction"},
+	)
+
+	grpcTranscodeRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_grpc_transcode_requests_total",
+			Help: "Total number of gRPC calls made for transcoded routes",
+		},
+		[]string{"route", "method", "code"},
+	)
+
+	// Hedging metrics
+	hedgedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_hedged_requests_total",
+			Help: "Total number of requests hedged to a second backend, by which one answered first",
+		},
+		[]string{"winner"},
+	)
+
+	// Request age metrics
+	requestQueueTime = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "gatekeeper_request_queue_seconds",
+			Help:    "Time requests spent in the gateway before being sent to a backend",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+
+	queueTimeRejected = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_queue_time_rejected_total",
+			Help: "Total number of requests rejected for waiting too long in the gateway",
+		},
+	)
+
+	// Traffic metrics
+	backendBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_backend_bytes_total",
+			Help: "Body bytes exchanged with each backend (in = request, out = response)",
+		},
+		[]string{"backend", "direction"},
+	)
+
+	routeBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_route_bytes_total",
+			Help: "Body bytes exchanged on each route (in = request, out = response)",
+		},
+		[]string{"route", "direction"},
+	)
+
+	// Upstream connection metrics
+	upstreamConnections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_upstream_connections_total",
+			Help: "Connections used for backend requests, by whether they were reused from the pool",
+		},
+		[]string{"backend", "reused"},
+	)
+
+	upstreamTLSHandshakes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_upstream_tls_handshakes_total",
+			Help: "TLS handshakes with backends, by whether the session was resumed",
+		},
+		[]string{"backend", "resumed"},
+	)
+
+	upstreamTLSHandshakeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gatekeeper_upstream_tls_handshake_duration_seconds",
+			Help:    "TLS handshake duration with backends",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"backend", "resumed"},
+	)
+
+	// Drain metrics
+	inFlightRequests = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_in_flight_requests",
+			Help: "Requests the gateway is currently handling",
+		},
+	)
+
+	draining = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_draining",
+			Help: "Whether the gateway is draining (1 = draining, 0 = serving)",
+		},
+	)
+
+	// TCP proxy metrics
+	tcpConnections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_tcp_connections_total",
+			Help: "Connections accepted by TCP proxies, by backend and result",
+		},
+		[]string{"proxy", "backend", "result"},
+	)
+
+	tcpActiveConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_tcp_active_connections",
+			Help: "Connections currently open through each TCP proxy",
+		},
+		[]string{"proxy"},
+	)
+
+	tcpBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_tcp_bytes_total",
+			Help: "Bytes forwarded by each TCP proxy (in = client to backend, out = backend to client)",
+		},
+		[]string{"proxy", "direction"},
+	)
+
+	// Bulkhead metrics
+	bulkheadInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_bulkhead_in_flight",
+			Help: "Requests holding a bulkhead slot",
+		},
+		[]string{"bulkhead"},
+	)
+
+	bulkheadQueued = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_bulkhead_queued",
+			Help: "Requests waiting for a bulkhead slot",
+		},
+		[]string{"bulkhead"},
+	)
+
+	bulkheadRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_bulkhead_rejected_total",
+			Help: "Total number of requests shed by a bulkhead",
+		},
+		[]string{"bulkhead", "reason"},
+	)
+
+	// Backend connection limit metrics
+	backendConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_backend_connections",
+			Help: "Requests in flight to each backend server with maxConnections",
+		},
+		[]string{"backend"},
+	)
+
+	backendQueued = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_backend_queued",
+			Help: "Requests waiting for a connection to each backend server",
+		},
+		[]string{"backend"},
+	)
+
+	backendSpillovers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_backend_spillovers_total",
+			Help: "Total number of requests sent elsewhere because a backend server was at maxConnections",
+		},
+		[]string{"backend"},
+	)
+
+	backendQueueRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_backend_queue_rejected_total",
+			Help: "Total number of requests shed waiting for a connection to a backend server",
+		},
+		[]string{"backend", "reason"},
+	)
+
+	backendTierActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_backend_tier_active",
+			Help: "Which backend tier gets traffic (1 = active): primary, or backup after a failover",
+		},
+		[]string{"tier"},
+	)
+
+	notificationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_notifications_total",
+			Help: "Total number of alerts for webhooks by result: sent, failed or throttled",
+		},
+		[]string{"webhook", "result"},
+	)
+
+	// Synthetic probe metrics
+	syntheticProbeUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_synthetic_probe_success",
+			Help: "Whether the last run of a synthetic probe passed (1 = passed, 0 = failed)",
+		},
+		[]string{"probe"},
+	)
+
+	syntheticProbeFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_synthetic_probe_failures_total",
+			Help: "Total number of failed synthetic probe runs",
+		},
+		[]string{"probe"},
+	)
+
+	syntheticProbeDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_synthetic_probe_duration_seconds",
+			Help: "Duration of the last run of a synthetic probe",
+		},
+		[]string{"probe"},
+	)
+
+	// Classification metrics
+	classifiedRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_classified_requests_total",
+			Help: "Total number of requests by traffic class",
+		},
+		[]string{"class", "status"},
+	)
+
+	// SLO metrics
+	sloRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_slo_requests_total",
+			Help: "Requests counted against an SLO, by whether they were good or bad",
+		},
+		[]string{"slo", "result"},
+	)
+
+	sloBurnRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_slo_burn_rate",
+			Help: "How many times faster than allowed an SLO's error budget is being spent, per window",
+		},
+		[]string{"slo", "window"},
+	)
+
+	sloErrorBudgetRemaining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gatekeeper_slo_error_budget_remaining",
+			Help: "Share of an SLO's error budget left for the period, negative once overspent",
+		},
+		[]string{"slo"},
+	)
+
+	eventsPublishedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_events_published_total",
+			Help: "Request events published to the event broker",
+		},
+	)
+
+	eventsDroppedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_events_dropped_total",
+			Help: "Request events dropped, because the queue was full or the broker failed",
+		},
+		[]string{"reason"},
+	)
+
+	consumerRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_consumer_requests_total",
+			Help: "Requests per consumer, by status class",
+		},
+		[]string{"consumer", "status_class"},
+	)
+
+	consumerResponseBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gatekeeper_consumer_response_bytes_total",
+			Help: "Response bytes sent to each consumer",
+		},
+		[]string{"consumer"},
+	)
+
 	// Gateway metrics
 	gatewayInfo = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -63,14 +566,152 @@ var (
 	)
 )
 
-func Init() {
+// defaultSizeBuckets go from 100 bytes to 100 MB by factors of 10
+var defaultSizeBuckets = prometheus.ExponentialBuckets(100, 10, 7)
+
+func newRequestDuration(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gatekeeper_request_duration_seconds",
+			Help:    "HTTP request duration in seconds",
+			Buckets: buckets,
+		},
+		[]string{"method", "backend"},
+	)
+}
+
+func newRouteDuration(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gatekeeper_route_request_duration_seconds",
+			Help:    "Time from a route receiving a request to the response being written, in seconds",
+			Buckets: buckets,
+		},
+		[]string{"route", "method"},
+	)
+}
+
+func newUpstreamDuration(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gatekeeper_upstream_duration_seconds",
+			Help:    "Time a backend took to answer a proxied request, in seconds",
+			Buckets: buckets,
+		},
+		[]string{"backend", "route"},
+	)
+}
+
+func newSizeHistogram(name, help string, buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets},
+		[]string{"route"},
+	)
+}
+
+// Buckets replaces the default histogram buckets. Duration buckets, in
+// seconds, apply to request, route and upstream durations; size buckets,
+// in bytes, to request and response sizes.
+type Buckets struct {
+	Duration []float64
+	Size     []float64
+}
+
+var initOnce sync.Once
+
+// Init registers all metrics with the default Prometheus registry, with the
+// histograms using buckets where set. Calls after the first do nothing.
+func Init(buckets Buckets) {
+	initOnce.Do(func() {
+		register(prometheus.DefaultRegisterer, buckets)
+	})
+}
+
+// register registers all metrics with reg
+func register(reg prometheus.Registerer, buckets Buckets) {
+	if len(buckets.Duration) > 0 {
+		requestDuration = newRequestDuration(buckets.Duration)
+		routeDuration = newRouteDuration(buckets.Duration)
+		upstreamLatency = newUpstreamDuration(buckets.Duration)
+	}
+	if len(buckets.Size) > 0 {
+		requestSize = newSizeHistogram("gatekeeper_request_size_bytes", "Request body size in bytes by route", buckets.Size)
+		responseSize = newSizeHistogram("gatekeeper_response_size_bytes", "Response body size in bytes by route", buckets.Size)
+	}
+
 	// Register all metrics
-	prometheus.MustRegister(
+	reg.MustRegister(
 		requestsTotal,
 		requestDuration,
+		routeRequestsTotal,
+		routeDuration,
+		upstreamLatency,
+		requestSize,
+		responseSize,
+		routeInFlight,
 		backendRequestsTotal,
+		clientProtocolRequests,
+		backendProtocolRequests,
 		backendUp,
 		rateLimitedRequests,
+		tierRateLimitedRequests,
+		rateLimitServiceDecisions,
+		extProcExchanges,
+		expressionDenied,
+		maintenanceRejected,
+		botDetections,
+		tarpittedRequests,
+		honeypotHits,
+		apiVersionRequests,
+		quotaExceededRequests,
+		connectionsRejected,
+		autoBansTotal,
+		autoBanActive,
+		negativeCacheHits,
+		incidentActive,
+		incidentsTotal,
+		incidentShed,
+		idempotentReplays,
+		coalescedRequests,
+		openAPIInvalidRequests,
+		bodySchemaFailures,
+		responseTransformFailures,
+		aggregatePartFailures,
+		grpcTranscodeRequests,
+		headersDropped,
+		hedgedRequests,
+		requestQueueTime,
+		queueTimeRejected,
+		backendBytes,
+		routeBytes,
+		upstreamConnections,
+		upstreamTLSHandshakes,
+		upstreamTLSHandshakeDuration,
+		inFlightRequests,
+		draining,
+		tcpConnections,
+		tcpActiveConnections,
+		tcpBytes,
+		bulkheadInFlight,
+		bulkheadQueued,
+		bulkheadRejected,
+		backendConnections,
+		backendQueued,
+		backendSpillovers,
+		backendQueueRejected,
+		backendTierActive,
+		notificationsTotal,
+		syntheticProbeUp,
+		syntheticProbeFailures,
+		syntheticProbeDuration,
+		classifiedRequestsTotal,
+		sloRequestsTotal,
+		sloBurnRate,
+		sloErrorBudgetRemaining,
+		eventsPublishedTotal,
+		eventsDroppedTotal,
+		consumerRequestsTotal,
+		consumerResponseBytes,
 		gatewayInfo,
 	)
 
@@ -82,11 +723,53 @@ func Init() {
 func RecordRequest(method, status, backend string, duration time.Duration) {
 	requestsTotal.WithLabelValues(method, status, backend).Inc()
 	requestDuration.WithLabelValues(method, backend).Observe(duration.Seconds())
+	sendCount("requests", 1, "method", method, "status", status, "backend", backend)
+	sendTiming("request.duration", duration, "method", method, "backend", backend)
+}
+
+// RecordRouteRequest records a request a route served, with its total
+// duration and body sizes
+func RecordRouteRequest(route, method, status string, duration time.Duration, in, out int64) {
+	routeRequestsTotal.WithLabelValues(route, method, status).Inc()
+	routeDuration.WithLabelValues(route, method).Observe(duration.Seconds())
+	requestSize.WithLabelValues(route).Observe(float64(in))
+	responseSize.WithLabelValues(route).Observe(float64(out))
+	sendCount("route.requests", 1, "route", route, "method", method, "status", status)
+	sendTiming("route.request.duration", duration, "route", route, "method", method)
+	sendHistogram("route.request.size", float64(in), "route", route)
+	sendHistogram("route.response.size", float64(out), "route", route)
+}
+
+// AddRouteInFlight adjusts the number of requests a route is handling
+func AddRouteInFlight(route string, delta int) {
+	routeInFlight.WithLabelValues(route).Add(float64(delta))
+	sendGauge("route.in_flight", gaugeValue(routeInFlight.WithLabelValues(route)), "route", route)
+}
+
+// RecordUpstreamDuration records how long backend took to answer a
+// request on route
+func RecordUpstreamDuration(backend, route string, duration time.Duration) {
+	upstreamLatency.WithLabelValues(backend, route).Observe(duration.Seconds())
+	sendTiming("upstream.duration", duration, "backend", backend, "route", route)
 }
 
 // RecordBackendRequest records metrics for backend requests
 func RecordBackendRequest(backend, status string) {
 	backendRequestsTotal.WithLabelValues(backend, status).Inc()
+	sendCount("backend.requests", 1, "backend", backend, "status", status)
+}
+
+// RecordClientProtocol records a client request's HTTP version, e.g.
+// "HTTP/2.0"
+func RecordClientProtocol(protocol string) {
+	clientProtocolRequests.WithLabelValues(protocol).Inc()
+	sendCount("client.protocol.requests", 1, "protocol", protocol)
+}
+
+// RecordBackendProtocol records the HTTP version a backend answered with
+func RecordBackendProtocol(backend, protocol string) {
+	backendProtocolRequests.WithLabelValues(backend, protocol).Inc()
+	sendCount("backend.protocol.requests", 1, "backend", backend, "protocol", protocol)
 }
 
 // SetBackendStatus sets the health status of a backend
@@ -96,11 +779,391 @@ func SetBackendStatus(backend string, up bool) {
 		value = 1.0
 	}
 	backendUp.WithLabelValues(backend).Set(value)
+	sendGauge("backend.up", value, "backend", backend)
 }
 
 // RecordRateLimit records a rate limited request
 func RecordRateLimit() {
 	rateLimitedRequests.Inc()
+	sendCount("rate_limited", 1)
+}
+
+// RecordConnectionRejected records a connection dropped by the connection limiter
+func RecordConnectionRejected() {
+	connectionsRejected.Inc()
+	sendCount("connections.rejected", 1)
+}
+
+// RecordAutoBan records a client being banned
+func RecordAutoBan() {
+	autoBansTotal.Inc()
+	sendCount("autoban.bans", 1)
+}
+
+// SetActiveBans sets the number of currently banned clients
+func SetActiveBans(n int) {
+	autoBanActive.Set(float64(n))
+	sendGauge("autoban.active", float64(n))
+}
+
+// RecordNegativeCacheHit records an error response served from cache
+func RecordNegativeCacheHit(route, status string) {
+	negativeCacheHits.WithLabelValues(route, status).Inc()
+	sendCount("negative_cache.hits", 1, "route", route, "status", status)
+}
+
+// RecordIncident records an incident opening or closing
+func RecordIncident(open bool) {
+	if open {
+		incidentsTotal.Inc()
+		incidentActive.Set(1)
+		sendCount("incidents", 1)
+		sendGauge("incident.active", 1)
+	} else {
+		incidentActive.Set(0)
+		sendGauge("incident.active", 0)
+	}
+}
+
+// RecordIncidentShed records a request shed during an incident
+func RecordIncidentShed() {
+	incidentShed.Inc()
+	sendCount("incident.shed", 1)
+}
+
+// RecordTierRateLimit records a request rejected by a per-client tier or
+// plan limit. The tier of a plan is its name.
+func RecordTierRateLimit(tier string) {
+	tierRateLimitedRequests.WithLabelValues(tier).Inc()
+	sendCount("tier.rate_limited", 1, "tier", tier)
+}
+
+// RecordRateLimitService records the external rate limit service's answer
+// for a request: "ok", "over_limit" or "error"
+func RecordRateLimitService(result string) {
+	rateLimitServiceDecisions.WithLabelValues(result).Inc()
+	sendCount("ratelimit_service.requests", 1, "result", result)
+}
+
+// RecordExtProc records how a request's exchange with the external
+// processor ended: "ok", "immediate" when the processor answered it, or
+// "error"
+func RecordExtProc(result string) {
+	extProcExchanges.WithLabelValues(result).Inc()
+	sendCount("ext_proc.requests", 1, "result", result)
+}
+
+// RecordBotDetection records a request scored as a bot and whether it was
+// blocked or tarpitted
+func RecordBotDetection(route, action string) {
+	botDetections.WithLabelValues(route, action).Inc()
+	sendCount("bot.detections", 1, "route", route, "action", action)
+}
+
+// RecordHoneypotHit records a request for honeypot, as configured
+func RecordHoneypotHit(honeypot string) {
+	honeypotHits.WithLabelValues(honeypot).Inc()
+	sendCount("honeypot.hits", 1, "honeypot", honeypot)
+}
+
+// RecordTarpit records a rejected request that was held ("held") or
+// answered at once because the tarpit was full ("full")
+func RecordTarpit(result string) {
+	tarpittedRequests.WithLabelValues(result).Inc()
+	sendCount("tarpit.requests", 1, "result", result)
+}
+
+// RecordQuotaExceeded records a request rejected by the daily or monthly
+// quota
+func RecordQuotaExceeded(period string) {
+	quotaExceededRequests.WithLabelValues(period).Inc()
+	sendCount("quota.exceeded", 1, "period", period)
+}
+
+// RecordIdempotentRequest records how a request with an idempotency key
+// was handled
+func RecordIdempotentRequest(outcome string) {
+	idempotentReplays.WithLabelValues(outcome).Inc()
+	sendCount("idempotent.requests", 1, "outcome", outcome)
+}
+
+// RecordCoalescedRequest records a request served from a shared response
+func RecordCoalescedRequest(route string) {
+	coalescedRequests.WithLabelValues(route).Inc()
+	sendCount("coalesced.requests", 1, "route", route)
+}
+
+// RecordOpenAPIInvalidRequest records a request rejected by OpenAPI
+// validation
+func RecordOpenAPIInvalidRequest(route string) {
+	openAPIInvalidRequests.WithLabelValues(route).Inc()
+	sendCount("openapi.invalid_requests", 1, "route", route)
+}
+
+// RecordExpressionDenied records a request a route's allow expression
+// rejected
+func RecordExpressionDenied(route string) {
+	expressionDenied.WithLabelValues(route).Inc()
+	sendCount("expression.denied", 1, "route", route)
+}
+
+// RecordAPIVersion records a request for an API version
+func RecordAPIVersion(version string, deprecated bool) {
+	label := strconv.FormatBool(deprecated)
+	apiVersionRequests.WithLabelValues(version, label).Inc()
+	sendCount("api_version.requests", 1, "version", version, "deprecated", label)
+}
+
+// RecordMaintenanceRejected records a request maintenance mode turned away
+func RecordMaintenanceRejected(route string) {
+	maintenanceRejected.WithLabelValues(route).Inc()
+	sendCount("maintenance.rejected", 1, "route", route)
+}
+
+// RecordBodySchemaFailure records a request body that failed its route's
+// JSON Schema; mode is "block" or "log"
+func RecordBodySchemaFailure(route, mode string) {
+	bodySchemaFailures.WithLabelValues(route, mode).Inc()
+	sendCount("body_schema.failures", 1, "route", route, "mode", mode)
+}
+
+// RecordResponseTransformFailure records a response that could not be
+// transformed; reason is "too_large", "encoded" or "invalid_json"
+func RecordResponseTransformFailure(route, reason string) {
+	responseTransformFailures.WithLabelValues(route, reason).Inc()
+	sendCount("response_transform.failures", 1, "route", route, "reason", reason)
+}
+
+// RecordAggregatePartFailure records a part of an aggregate route whose
+// backend failed or did not answer with JSON
+func RecordAggregatePartFailure(route, part string) {
+	aggregatePartFailures.WithLabelValues(route, part).Inc()
+	sendCount("aggregate.part_failures", 1, "route", route, "part", part)
+}
+
+// RecordGRPCTranscode records a gRPC call made for a transcoded route;
+// code is the gRPC status code
+func RecordGRPCTranscode(route, method, code string) {
+	grpcTranscodeRequests.WithLabelValues(route, method, code).Inc()
+	sendCount("grpc_transcode.requests", 1, "route", route, "method", method, "code", code)
+}
+
+// RecordHeadersDropped records headers dropped to fit a route's header
+// limits; direction is "request" or "response"
+func RecordHeadersDropped(route, direction string, count int) {
+	headersDropped.WithLabelValues(route, direction).Add(float64(count))
+	sendCount("headers.dropped", float64(count), "route", route, "direction", direction)
+}
+
+// RecordHedgedRequest records a hedged request; winner is "primary" or "hedge"
+func RecordHedgedRequest(winner string) {
+	hedgedRequests.WithLabelValues(winner).Inc()
+	sendCount("hedged.requests", 1, "winner", winner)
+}
+
+// RecordQueueTime records how long a request waited before being proxied
+func RecordQueueTime(wait time.Duration) {
+	requestQueueTime.Observe(wait.Seconds())
+	sendTiming("request.queue_time", wait)
+}
+
+// RecordQueueTimeRejected records a request rejected for its queue time
+func RecordQueueTimeRejected() {
+	queueTimeRejected.Inc()
+	sendCount("request.queue_time.rejected", 1)
+}
+
+// RecordBackendBytes records request and response body bytes for a backend
+func RecordBackendBytes(backend string, in, out int64) {
+	backendBytes.WithLabelValues(backend, "in").Add(float64(in))
+	backendBytes.WithLabelValues(backend, "out").Add(float64(out))
+	sendCount("backend.bytes", float64(in), "backend", backend, "direction", "in")
+	sendCount("backend.bytes", float64(out), "backend", backend, "direction", "out")
+}
+
+// RecordRouteBytes records request and response body bytes for a route
+func RecordRouteBytes(route string, in, out int64) {
+	routeBytes.WithLabelValues(route, "in").Add(float64(in))
+	routeBytes.WithLabelValues(route, "out").Add(float64(out))
+	sendCount("route.bytes", float64(in), "route", route, "direction", "in")
+	sendCount("route.bytes", float64(out), "route", route, "direction", "out")
+}
+
+// RecordUpstreamConnection records a connection taken for a backend request
+func RecordUpstreamConnection(backend string, reused bool) {
+	upstreamConnections.WithLabelValues(backend, strconv.FormatBool(reused)).Inc()
+	sendCount("upstream.connections", 1, "backend", backend, "reused", strconv.FormatBool(reused))
+}
+
+// RecordUpstreamTLSHandshake records a completed TLS handshake with a backend
+func RecordUpstreamTLSHandshake(backend string, resumed bool, duration time.Duration) {
+	label := strconv.FormatBool(resumed)
+	upstreamTLSHandshakes.WithLabelValues(backend, label).Inc()
+	upstreamTLSHandshakeDuration.WithLabelValues(backend, label).Observe(duration.Seconds())
+	sendCount("upstream.tls.handshakes", 1, "backend", backend, "resumed", label)
+	sendTiming("upstream.tls.handshake.duration", duration, "backend", backend, "resumed", label)
+}
+
+// AddInFlightRequests adjusts the number of requests being handled
+func AddInFlightRequests(delta int) {
+	inFlightRequests.Add(float64(delta))
+	sendGauge("in_flight", gaugeValue(inFlightRequests))
+}
+
+// SetDraining records whether the gateway is draining
+func SetDraining(on bool) {
+	value := 0.0
+	if on {
+		value = 1.0
+	}
+	draining.Set(value)
+	sendGauge("draining", value)
+}
+
+// RecordTCPConnection records a connection accepted by a TCP proxy.
+// Result is "ok", "dial_error", "no_backend" or "no_route"; backend is
+// empty when none was picked.
+func RecordTCPConnection(proxy, backend, result string) {
+	tcpConnections.WithLabelValues(proxy, backend, result).Inc()
+	sendCount("tcp.connections", 1, "proxy", proxy, "backend", backend, "result", result)
+}
+
+// AddTCPActiveConnections adjusts the open connection count of a TCP proxy
+func AddTCPActiveConnections(proxy string, delta int) {
+	tcpActiveConnections.WithLabelValues(proxy).Add(float64(delta))
+	sendGauge("tcp.active_connections", gaugeValue(tcpActiveConnections.WithLabelValues(proxy)), "proxy", proxy)
+}
+
+// RecordTCPBytes records bytes forwarded by a TCP proxy in each direction
+func RecordTCPBytes(proxy string, in, out int64) {
+	tcpBytes.WithLabelValues(proxy, "in").Add(float64(in))
+	tcpBytes.WithLabelValues(proxy, "out").Add(float64(out))
+	sendCount("tcp.bytes", float64(in), "proxy", proxy, "direction", "in")
+	sendCount("tcp.bytes", float64(out), "proxy", proxy, "direction", "out")
+}
+
+// RecordBulkhead records the occupancy of a bulkhead
+func RecordBulkhead(name string, inFlight, queued int) {
+	bulkheadInFlight.WithLabelValues(name).Set(float64(inFlight))
+	bulkheadQueued.WithLabelValues(name).Set(float64(queued))
+	sendGauge("bulkhead.in_flight", float64(inFlight), "bulkhead", name)
+	sendGauge("bulkhead.queued", float64(queued), "bulkhead", name)
+}
+
+// RecordBulkheadRejected records a request shed by a bulkhead
+func RecordBulkheadRejected(name, reason string) {
+	bulkheadRejected.WithLabelValues(name, reason).Inc()
+	sendCount("bulkhead.rejected", 1, "bulkhead", name, "reason", reason)
+}
+
+// RecordBackendConnections records the connections in use and waited for
+// on a backend server with maxConnections
+func RecordBackendConnections(backend string, inFlight, queued int) {
+	backendConnections.WithLabelValues(backend).Set(float64(inFlight))
+	backendQueued.WithLabelValues(backend).Set(float64(queued))
+	sendGauge("backend.connections", float64(inFlight), "backend", backend)
+	sendGauge("backend.queued", float64(queued), "backend", backend)
+}
+
+// RecordBackendSpillover records a request sent elsewhere because backend
+// was full
+func RecordBackendSpillover(backend string) {
+	backendSpillovers.WithLabelValues(backend).Inc()
+	sendCount("backend.spillovers", 1, "backend", backend)
+}
+
+// RecordBackendQueueRejected records a request shed waiting for backend
+func RecordBackendQueueRejected(backend, reason string) {
+	backendQueueRejected.WithLabelValues(backend, reason).Inc()
+	sendCount("backend.queue_rejected", 1, "backend", backend, "reason", reason)
+}
+
+// SetBackupActive records which backend tier gets traffic
+func SetBackupActive(backup bool) {
+	value := 0.0
+	if backup {
+		value = 1.0
+	}
+	backendTierActive.WithLabelValues("backup").Set(value)
+	backendTierActive.WithLabelValues("primary").Set(1 - value)
+	sendGauge("backend.tier_active", value, "tier", "backup")
+	sendGauge("backend.tier_active", 1-value, "tier", "primary")
+}
+
+// RecordNotification records the result of an alert for webhook
+func RecordNotification(webhook, result string) {
+	notificationsTotal.WithLabelValues(webhook, result).Inc()
+	sendCount("notifications", 1, "webhook", webhook, "result", result)
+}
+
+// RecordSyntheticProbe records the outcome of a synthetic probe run
+func RecordSyntheticProbe(probe string, passed bool, duration time.Duration) {
+	value := 0.0
+	if passed {
+		value = 1.0
+	} else {
+		syntheticProbeFailures.WithLabelValues(probe).Inc()
+	}
+	syntheticProbeUp.WithLabelValues(probe).Set(value)
+	syntheticProbeDuration.WithLabelValues(probe).Set(duration.Seconds())
+	sendGauge("synthetic.up", value, "probe", probe)
+	sendTiming("synthetic.duration", duration, "probe", probe)
+}
+
+// RecordClassifiedRequest records a request against its traffic class
+func RecordClassifiedRequest(class, status string) {
+	classifiedRequestsTotal.WithLabelValues(class, status).Inc()
+	sendCount("classified.requests", 1, "class", class, "status", status)
+}
+
+// RecordSLORequest records a request counted against slo
+func RecordSLORequest(slo string, good bool) {
+	result := "bad"
+	if good {
+		result = "good"
+	}
+	sloRequestsTotal.WithLabelValues(slo, result).Inc()
+	sendCount("slo.requests", 1, "slo", slo, "result", result)
+}
+
+// SetSLOStatus records the burn rate of slo per window and the share of
+// its error budget left
+func SetSLOStatus(slo string, burnRates map[string]float64, budgetRemaining float64) {
+	for window, rate := range burnRates {
+		sloBurnRate.WithLabelValues(slo, window).Set(rate)
+		sendGauge("slo.burn_rate", rate, "slo", slo, "window", window)
+	}
+	sloErrorBudgetRemaining.WithLabelValues(slo).Set(budgetRemaining)
+	sendGauge("slo.error_budget_remaining", budgetRemaining, "slo", slo)
+}
+
+// RecordEventsPublished counts request events the broker accepted
+func RecordEventsPublished(count int) {
+	eventsPublishedTotal.Add(float64(count))
+	sendCount("events.published", float64(count))
+}
+
+// RecordEventsDropped counts request events given up on, with reason
+// "queue_full" or "publish_failed"
+func RecordEventsDropped(reason string, count int) {
+	eventsDroppedTotal.WithLabelValues(reason).Add(float64(count))
+	sendCount("events.dropped", float64(count), "reason", reason)
+}
+
+// RecordConsumerUsage counts a request of consumer and the bytes sent back
+func RecordConsumerUsage(consumer string, status int, bytes int64) {
+	class := strconv.Itoa(status/100) + "xx"
+	consumerRequestsTotal.WithLabelValues(consumer, class).Inc()
+	consumerResponseBytes.WithLabelValues(consumer).Add(float64(bytes))
+	sendCount("consumer.requests", 1, "consumer", consumer, "status_class", class)
+	sendCount("consumer.response_bytes", float64(bytes), "consumer", consumer)
+}
+
+// gaugeValue reads g back, for sinks that only take absolute gauges
+func gaugeValue(g prometheus.Gauge) float64 {
+	var m dto.Metric
+	g.Write(&m)
+	return m.GetGauge().GetValue()
 }
 
 // Handler returns the Prometheus metrics handler
@@ -112,10 +1175,22 @@ func Handler() http.Handler {
 type ResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	written    int64
 }
 
 func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
-	return &ResponseWriter{w, http.StatusOK}
+	return &ResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+}
+
+func (rw *ResponseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.written += int64(n)
+	return n, err
+}
+
+// BytesWritten returns the number of body bytes written
+func (rw *ResponseWriter) BytesWritten() int64 {
+	return rw.written
 }
 
 func (rw *ResponseWriter) WriteHeader(code int) {
@@ -123,6 +1198,17 @@ func (rw *ResponseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// hijack the connection
+func (rw *ResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func (rw *ResponseWriter) StatusCode() string {
 	return strconv.Itoa(rw.statusCode)
+}
+
+// Status returns the captured status code as an int
+func (rw *ResponseWriter) Status() int {
+	return rw.statusCode
 }
\ No newline at end of file
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRouteMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	register(registry, Buckets{Duration: []float64{0.1, 0.3, 1}, Size: []float64{1024, 65536}})
+	// The histograms are new, but counters and gauges outlive a test run
+	routeRequestsTotal.Reset()
+	routeInFlight.Reset()
+
+	AddRouteInFlight("orders", 1)
+	RecordRouteRequest("orders", "POST", "201", 200*time.Millisecond, 512, 100000)
+	RecordUpstreamDuration("api", "orders", 150*time.Millisecond)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]*dto.Metric)
+	for _, family := range families {
+		for _, m := range family.Metric {
+			for _, label := range m.Label {
+				if label.GetName() == "route" && label.GetValue() == "orders" {
+					found[family.GetName()] = m
+				}
+			}
+		}
+	}
+
+	if m := found["gatekeeper_route_request_duration_seconds"]; m == nil || len(m.Histogram.Bucket) != 3 ||
+		m.Histogram.Bucket[0].GetCumulativeCount() != 0 || m.Histogram.Bucket[1].GetCumulativeCount() != 1 {
+		t.Errorf("Expected 200ms in the 0.3 bucket of the configured ones, got %v", m)
+	}
+	if m := found["gatekeeper_upstream_duration_seconds"]; m == nil || m.Histogram.GetSampleCount() != 1 {
+		t.Errorf("Expected one upstream duration for the route, got %v", m)
+	}
+	if m := found["gatekeeper_request_size_bytes"]; m == nil || m.Histogram.Bucket[0].GetCumulativeCount() != 1 {
+		t.Errorf("Expected a 512 byte request in the first size bucket, got %v", m)
+	}
+	if m := found["gatekeeper_response_size_bytes"]; m == nil || m.Histogram.Bucket[1].GetCumulativeCount() != 0 {
+		t.Errorf("Expected a 100000 byte response above every size bucket, got %v", m)
+	}
+	if m := found["gatekeeper_route_in_flight_requests"]; m == nil || m.Gauge.GetValue() != 1 {
+		t.Errorf("Expected 1 request in flight, got %v", m)
+	}
+	if m := found["gatekeeper_route_requests_total"]; m == nil || m.Counter.GetValue() != 1 {
+		t.Errorf("Expected 1 request counted, got %v", m)
+	}
+}
@@ -0,0 +1,268 @@
+package metrics
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink receives every metric as it is recorded, for exporters that push
+// metrics instead of being scraped. Prometheus metrics are kept either way.
+type Sink interface {
+	Count(name string, value float64, tags []Tag)
+	Gauge(name string, value float64, tags []Tag)
+	Timing(name string, d time.Duration, tags []Tag)
+	Histogram(name string, value float64, tags []Tag)
+	Close() error
+}
+
+// Tag is a metric label
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// sink is set once at startup, before any metric is recorded
+var sink Sink
+
+// SetSink sends metrics to s as well as Prometheus; nil stops sending
+func SetSink(s Sink) {
+	sink = s
+}
+
+// tags pairs up keys and values: tags("route", route, "method", method)
+func tags(kv ...string) []Tag {
+	t := make([]Tag, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		t = append(t, Tag{Key: kv[i], Value: kv[i+1]})
+	}
+	return t
+}
+
+func sendCount(name string, value float64, kv ...string) {
+	if sink != nil {
+		sink.Count(name, value, tags(kv...))
+	}
+}
+
+func sendGauge(name string, value float64, kv ...string) {
+	if sink != nil {
+		sink.Gauge(name, value, tags(kv...))
+	}
+}
+
+func sendTiming(name string, d time.Duration, kv ...string) {
+	if sink != nil {
+		sink.Timing(name, d, tags(kv...))
+	}
+}
+
+func sendHistogram(name string, value float64, kv ...string) {
+	if sink != nil {
+		sink.Histogram(name, value, tags(kv...))
+	}
+}
+
+// StatsDOptions configures a StatsD exporter. Flavor "dogstatsd" sends
+// tags the DogStatsD way; plain "statsd" has no tags, so tag values are
+// appended to the metric name instead. SampleRate, between 0 and 1,
+// samples counters, timings and histograms, and SampleRates overrides it
+// per metric name. Tags, as "key:value", go on every metric.
+type StatsDOptions struct {
+	Address     string
+	Prefix      string
+	Flavor      string
+	SampleRate  float64
+	SampleRates map[string]float64
+	Tags        []string
+}
+
+// maxPacket keeps a batch of metrics within one UDP packet on a typical
+// network MTU
+const maxPacket = 1432
+
+// statsdFlushInterval bounds how long a metric waits in the batch
+const statsdFlushInterval = 100 * time.Millisecond
+
+// StatsD sends metrics to a StatsD or DogStatsD agent over UDP. Metrics
+// are batched into packets and flushed at least every 100ms.
+type StatsD struct {
+	opts StatsDOptions
+	conn net.Conn
+	tags string
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	mu     sync.Mutex
+	buf    []byte
+	random *rand.Rand
+}
+
+// NewStatsD connects to the agent at opts.Address
+func NewStatsD(opts StatsDOptions) (*StatsD, error) {
+	if opts.Address == "" {
+		opts.Address = "127.0.0.1:8125"
+	}
+	if opts.Flavor == "" {
+		opts.Flavor = "dogstatsd"
+	}
+	if opts.SampleRate == 0 {
+		opts.SampleRate = 1
+	}
+	conn, err := net.Dial("udp", opts.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to StatsD agent %s: %w", opts.Address, err)
+	}
+
+	s := &StatsD{
+		opts:   opts,
+		conn:   conn,
+		tags:   strings.Join(opts.Tags, ","),
+		done:   make(chan struct{}),
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	s.wg.Add(1)
+	go s.flushLoop()
+	return s, nil
+}
+
+// Address is the agent metrics are sent to
+func (s *StatsD) Address() string {
+	return s.opts.Address
+}
+
+// Count sends a counter increment
+func (s *StatsD) Count(name string, value float64, tags []Tag) {
+	s.send(name, strconv.FormatFloat(value, 'f', -1, 64), "c", s.sampleRate(name), tags)
+}
+
+// Gauge sends the current value of a gauge. Gauges are never sampled.
+func (s *StatsD) Gauge(name string, value float64, tags []Tag) {
+	s.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", 1, tags)
+}
+
+// Timing sends a duration in milliseconds
+func (s *StatsD) Timing(name string, d time.Duration, tags []Tag) {
+	ms := float64(d) / float64(time.Millisecond)
+	s.send(name, strconv.FormatFloat(ms, 'f', -1, 64), "ms", s.sampleRate(name), tags)
+}
+
+// Histogram sends a value for the agent to aggregate into a distribution.
+// Plain StatsD has no histograms, so it gets a timing.
+func (s *StatsD) Histogram(name string, value float64, tags []Tag) {
+	kind := "h"
+	if s.opts.Flavor == "statsd" {
+		kind = "ms"
+	}
+	s.send(name, strconv.FormatFloat(value, 'f', -1, 64), kind, s.sampleRate(name), tags)
+}
+
+// Close flushes what is batched and disconnects
+func (s *StatsD) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	s.mu.Lock()
+	s.flushLocked()
+	s.mu.Unlock()
+	return s.conn.Close()
+}
+
+func (s *StatsD) sampleRate(name string) float64 {
+	if rate, ok := s.opts.SampleRates[name]; ok {
+		return rate
+	}
+	return s.opts.SampleRate
+}
+
+// send formats one metric line and adds it to the batch, unless sampling
+// skips it
+func (s *StatsD) send(name, value, kind string, rate float64, tags []Tag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rate < 1 && s.random.Float64() >= rate {
+		return
+	}
+
+	var line strings.Builder
+	line.WriteString(s.opts.Prefix)
+	line.WriteString(name)
+	if s.opts.Flavor == "statsd" {
+		for _, tag := range tags {
+			line.WriteByte('.')
+			line.WriteString(namePart.Replace(sanitize(tag.Value)))
+		}
+	}
+	line.WriteByte(':')
+	line.WriteString(value)
+	line.WriteByte('|')
+	line.WriteString(kind)
+	if rate < 1 {
+		line.WriteString("|@")
+		line.WriteString(strconv.FormatFloat(rate, 'f', -1, 64))
+	}
+	if s.opts.Flavor == "dogstatsd" && (len(tags) > 0 || s.tags != "") {
+		line.WriteString("|#")
+		line.WriteString(s.tags)
+		for i, tag := range tags {
+			if i > 0 || s.tags != "" {
+				line.WriteByte(',')
+			}
+			line.WriteString(tag.Key)
+			line.WriteByte(':')
+			line.WriteString(sanitize(tag.Value))
+		}
+	}
+
+	if len(s.buf) > 0 && len(s.buf)+1+line.Len() > maxPacket {
+		s.flushLocked()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line.String()...)
+}
+
+func (s *StatsD) flushLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(statsdFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.flushLocked()
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// flushLocked sends the batch. A lost packet is lost: StatsD is best
+// effort, and blocking requests on the agent is worse.
+func (s *StatsD) flushLocked() {
+	if len(s.buf) == 0 {
+		return
+	}
+	s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+}
+
+// namePart keeps a tag value folded into a plain StatsD name from adding
+// levels to it
+var namePart = strings.NewReplacer(".", "_", "/", "_")
+
+// sanitize replaces the characters StatsD uses as separators
+func sanitize(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', ',', '#', '@', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, value)
+}
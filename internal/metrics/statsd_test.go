@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen returns a UDP agent and a function reading the lines it got
+func listen(t *testing.T) (string, func() []string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().String(), func() []string {
+		var lines []string
+		buf := make([]byte, 65536)
+		for {
+			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return lines
+			}
+			lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+		}
+	}
+}
+
+func TestStatsDFlavors(t *testing.T) {
+	testCases := []struct {
+		flavor string
+		want   []string
+	}{
+		{"dogstatsd", []string{
+			"gk.route.requests:1|c|#env:prod,route:/orders,method:GET,status:200",
+			"gk.route.request.duration:250|ms|#env:prod,route:/orders,method:GET",
+			"gk.route.response.size:2048|h|#env:prod,route:/orders",
+			"gk.backend.up:1|g|#env:prod,backend:api",
+		}},
+		{"statsd", []string{
+			"gk.route.requests._orders.GET.200:1|c",
+			"gk.route.request.duration._orders.GET:250|ms",
+			"gk.route.response.size._orders:2048|ms",
+			"gk.backend.up.api:1|g",
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.flavor, func(t *testing.T) {
+			address, received := listen(t)
+			exporter, err := NewStatsD(StatsDOptions{Address: address, Prefix: "gk.", Flavor: tc.flavor, Tags: []string{"env:prod"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetSink(exporter)
+			defer SetSink(nil)
+
+			RecordRouteRequest("/orders", "GET", "200", 250*time.Millisecond, 0, 2048)
+			SetBackendStatus("api", true)
+			exporter.Close()
+
+			lines := strings.Join(received(), "\n")
+			for _, want := range tc.want {
+				if !strings.Contains(lines, want+"\n") && !strings.HasSuffix(lines, want) {
+					t.Errorf("Expected %q, got:\n%s", want, lines)
+				}
+			}
+		})
+	}
+}
+
+func TestStatsDSampling(t *testing.T) {
+	address, received := listen(t)
+	exporter, err := NewStatsD(StatsDOptions{
+		Address:     address,
+		SampleRate:  0.5,
+		SampleRates: map[string]float64{"rate_limited": 0.01},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		exporter.Count("requests", 1, nil)
+		exporter.Count("rate_limited", 1, nil)
+		exporter.Gauge("in_flight", 3, nil)
+	}
+	exporter.Close()
+
+	counts := map[string]int{}
+	for _, line := range received() {
+		counts[line]++
+	}
+	if n := counts["requests:1|c|@0.5"]; n < 400 || n > 600 {
+		t.Errorf("Expected about half the counters at rate 0.5, got %d", n)
+	}
+	if n := counts["rate_limited:1|c|@0.01"]; n > 50 {
+		t.Errorf("Expected about 1%% of rate_limited at its own rate, got %d", n)
+	}
+	if n := counts["in_flight:3|g"]; n != 1000 {
+		t.Errorf("Expected gauges not to be sampled, got %d of 1000", n)
+	}
+}
@@ -0,0 +1,323 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/barisgenc/gatekeeper/internal/autoban"
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/kv"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// Auto-ban middleware
+type AutoBanMiddleware struct {
+	cfg       config.AutoBanConfig
+	store     autoban.Store
+	statuses  map[int]bool
+	allowlist []*net.IPNet
+	window    time.Duration
+	duration  time.Duration
+	tarpit    *Tarpit
+	trapped   func(ip, honeypot string)
+	err       error
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewAutoBan(cfg config.AutoBanConfig) *AutoBanMiddleware {
+	return NewAutoBanWithStorage(cfg, nil)
+}
+
+// NewAutoBanWithStorage is NewAutoBan with the gateway's shared storage, used when
+// cfg.Store is "shared"
+func NewAutoBanWithStorage(cfg config.AutoBanConfig, storage kv.Store) *AutoBanMiddleware {
+	if len(cfg.Statuses) == 0 {
+		cfg.Statuses = []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests}
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 20
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 60
+	}
+	if cfg.BanDuration <= 0 {
+		cfg.BanDuration = 600
+	}
+	if cfg.Store == "" {
+		cfg.Store = "memory"
+	}
+
+	m := &AutoBanMiddleware{
+		cfg:      cfg,
+		statuses: make(map[int]bool, len(cfg.Statuses)),
+		window:   time.Duration(cfg.Window) * time.Second,
+		duration: time.Duration(cfg.BanDuration) * time.Second,
+		stop:     make(chan struct{}),
+	}
+	for _, status := range cfg.Statuses {
+		m.statuses[status] = true
+	}
+
+	m.allowlist, m.err = parseCIDRs(cfg.Allowlist)
+	if m.err == nil {
+		m.store, m.err = newBanStore(cfg, storage)
+	}
+	if m.err != nil {
+		logger.Error("Auto-ban misconfigured, rejecting all requests: %v", m.err)
+		return m
+	}
+
+	go m.reportActiveBans()
+
+	logger.Info("Auto-ban enabled: %d responses with status %v in %ds bans for %ds (%s store)",
+		cfg.Threshold, cfg.Statuses, cfg.Window, cfg.BanDuration, cfg.Store)
+	return m
+}
+
+// Close stops keeping the active-bans gauge current and closes the
+// connection of a redis store
+func (m *AutoBanMiddleware) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.stop)
+		if closer, ok := m.store.(io.Closer); ok {
+			err = closer.Close()
+		}
+	})
+	return err
+}
+
+// SetTarpit holds banned clients' requests in t before answering them
+func (m *AutoBanMiddleware) SetTarpit(t *Tarpit) {
+	m.tarpit = t
+}
+
+// OnHoneypot calls fn with the client and honeypot path each time a
+// client is banned for requesting a honeypot
+func (m *AutoBanMiddleware) OnHoneypot(fn func(ip, honeypot string)) {
+	m.trapped = fn
+}
+
+func newBanStore(cfg config.AutoBanConfig, storage kv.Store) (autoban.Store, error) {
+	switch cfg.Store {
+	case "memory":
+		return autoban.NewMemoryStore(), nil
+	case "redis":
+		if cfg.Redis.Address == "" {
+			return nil, errors.New("autoBan redis store requires an address")
+		}
+		client := redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Address,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		})
+		return autoban.NewRedisStore(client, cfg.Redis.KeyPrefix), nil
+	case "shared":
+		if storage == nil {
+			return nil, errors.New("autoBan shared store requires storage")
+		}
+		return autoban.NewKVStore(storage), nil
+	default:
+		return nil, fmt.Errorf("autoBan store %q must be memory, redis or shared", cfg.Store)
+	}
+}
+
+// parseCIDRs accepts CIDRs and bare IP addresses
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowlist entry %q", value)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// containsIP reports whether ip is in one of nets
+func containsIP(nets []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *AutoBanMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		ip := m.clientIP(r)
+		if m.allowlisted(ip) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ban, banned, err := m.store.Banned(r.Context(), ip)
+		if err != nil {
+			// A store outage must not take the gateway down with it
+			logger.Warn("Auto-ban store unavailable, allowing request: %v", err)
+		} else if banned {
+			tracing.RecordDecision(r.Context(), "autoban", tracing.Denied, "client banned")
+			if !m.tarpit.Hold(r) {
+				return
+			}
+			retryAfter := int(time.Until(ban.Until).Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if honeypot, ok := m.honeypot(r.URL.Path); ok {
+			tracing.RecordDecision(r.Context(), "autoban", tracing.Denied, "honeypot "+honeypot)
+			m.trap(r.Context(), ip, honeypot)
+			http.NotFound(w, r)
+			return
+		}
+
+		rw := metrics.NewResponseWriter(w)
+		next.ServeHTTP(rw, r)
+
+		if m.statuses[rw.Status()] {
+			m.recordOffence(r.Context(), ip, rw.Status())
+		}
+	})
+}
+
+func (m *AutoBanMiddleware) recordOffence(ctx context.Context, ip string, status int) {
+	count, err := m.store.Hit(ctx, ip, m.window)
+	if err != nil {
+		logger.Warn("Auto-ban store unavailable, offence from %s not counted: %v", ip, err)
+		return
+	}
+	if count < int64(m.cfg.Threshold) {
+		return
+	}
+
+	if _, err := m.store.Ban(ctx, ip, m.duration); err != nil {
+		logger.Warn("Auto-ban store unavailable, could not ban %s: %v", ip, err)
+		return
+	}
+	metrics.RecordAutoBan()
+	logger.Warn("Banned %s for %s after %d responses like %d within %s", ip, m.duration, count, status, m.window)
+	m.refreshActiveBans(ctx)
+}
+
+// honeypot returns the honeypot path matches
+func (m *AutoBanMiddleware) honeypot(path string) (string, bool) {
+	for _, honeypot := range m.cfg.Honeypots {
+		if path == honeypot || (strings.HasSuffix(honeypot, "/") && strings.HasPrefix(path, honeypot)) {
+			return honeypot, true
+		}
+	}
+	return "", false
+}
+
+// trap bans ip for requesting honeypot
+func (m *AutoBanMiddleware) trap(ctx context.Context, ip, honeypot string) {
+	metrics.RecordHoneypotHit(honeypot)
+	if _, err := m.store.Ban(ctx, ip, m.duration); err != nil {
+		logger.Warn("Auto-ban store unavailable, could not ban %s: %v", ip, err)
+		return
+	}
+	metrics.RecordAutoBan()
+	logger.Warn("Banned %s for %s after requesting honeypot %s", ip, m.duration, honeypot)
+	m.refreshActiveBans(ctx)
+	if m.trapped != nil {
+		m.trapped(ip, honeypot)
+	}
+}
+
+// clientIP identifies the client by its connection, or by the hop the load
+// balancer appended to X-Forwarded-For when that is trusted
+func (m *AutoBanMiddleware) clientIP(r *http.Request) string {
+	return ConnectingIP(r, m.cfg.UseForwardedFor)
+}
+
+func (m *AutoBanMiddleware) allowlisted(ip string) bool {
+	return containsIP(m.allowlist, ip)
+}
+
+// Bans lists active bans
+func (m *AutoBanMiddleware) Bans(ctx context.Context) ([]autoban.Ban, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return m.store.List(ctx)
+}
+
+// Unban lifts a ban, reporting whether there was one
+func (m *AutoBanMiddleware) Unban(ctx context.Context, ip string) (bool, error) {
+	if m.err != nil {
+		return false, m.err
+	}
+	lifted, err := m.store.Unban(ctx, ip)
+	if err == nil && lifted {
+		m.refreshActiveBans(ctx)
+	}
+	return lifted, err
+}
+
+// reportActiveBans keeps the active-bans gauge current as bans expire
+func (m *AutoBanMiddleware) reportActiveBans() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.stop:
+			return
+		}
+		if memory, ok := m.store.(*autoban.MemoryStore); ok {
+			memory.Sweep()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		m.refreshActiveBans(ctx)
+		cancel()
+	}
+}
+
+func (m *AutoBanMiddleware) refreshActiveBans(ctx context.Context) {
+	bans, err := m.store.List(ctx)
+	if err != nil {
+		logger.Warn("Auto-ban store unavailable, active ban count not updated: %v", err)
+		return
+	}
+	metrics.SetActiveBans(len(bans))
+}
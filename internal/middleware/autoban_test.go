@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestAutoBanMiddleware(t *testing.T) {
+	middleware := NewAutoBan(config.AutoBanConfig{
+		Enabled:   true,
+		Threshold: 3,
+		Allowlist: []string{"192.168.1.0/24"},
+	})
+
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("OK"))
+	}))
+
+	request := func(remoteAddr, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 3; i++ {
+		if rr := request("10.0.0.1:5000", "/login"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected failed login to reach the backend, got %d", rr.Code)
+		}
+	}
+
+	rr := request("10.0.0.1:5001", "/")
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected banned client to get 403, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After on a ban")
+	}
+
+	if rr := request("10.0.0.2:5000", "/"); rr.Code != http.StatusOK {
+		t.Errorf("Expected other clients to be unaffected, got %d", rr.Code)
+	}
+
+	for i := 0; i < 5; i++ {
+		request("192.168.1.7:5000", "/login")
+	}
+	if rr := request("192.168.1.7:5000", "/"); rr.Code != http.StatusOK {
+		t.Errorf("Expected allowlisted client never to be banned, got %d", rr.Code)
+	}
+
+	bans, err := middleware.Bans(context.Background())
+	if err != nil || len(bans) != 1 || bans[0].IP != "10.0.0.1" {
+		t.Fatalf("Expected one ban on 10.0.0.1, got %v (%v)", bans, err)
+	}
+
+	if lifted, _ := middleware.Unban(context.Background(), "10.0.0.1"); !lifted {
+		t.Fatal("Expected ban to be lifted")
+	}
+	if rr := request("10.0.0.1:5002", "/"); rr.Code != http.StatusOK {
+		t.Errorf("Expected unbanned client to pass, got %d", rr.Code)
+	}
+}
+
+func TestAutoBanForwardedFor(t *testing.T) {
+	middleware := NewAutoBan(config.AutoBanConfig{Enabled: true, UseForwardedFor: true})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.254:443"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 203.0.113.9")
+
+	if ip := middleware.clientIP(req); ip != "203.0.113.9" {
+		t.Errorf("Expected the hop added by the load balancer, got %s", ip)
+	}
+}
+
+func TestAutoBanMisconfigured(t *testing.T) {
+	middleware := NewAutoBan(config.AutoBanConfig{Enabled: true, Store: "redis"})
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", rr.Code)
+	}
+}
+
+func TestAutoBanClose(t *testing.T) {
+	middleware := NewAutoBan(config.AutoBanConfig{Enabled: true, Store: "redis", Redis: config.RedisConfig{Address: "127.0.0.1:1"}})
+	if err := middleware.Close(); err != nil {
+		t.Fatalf("Expected Close to close the redis client, got %v", err)
+	}
+	select {
+	case <-middleware.stop:
+	default:
+		t.Error("Expected Close to stop the active-bans reporter")
+	}
+	if err := middleware.Close(); err != nil {
+		t.Errorf("Expected a second Close to do nothing, got %v", err)
+	}
+}
+
+func TestAutoBanHoneypots(t *testing.T) {
+	middleware := NewAutoBan(config.AutoBanConfig{
+		Enabled:   true,
+		Honeypots: []string{"/.env", "/wp-admin/"},
+		Allowlist: []string{"192.168.1.0/24"},
+	})
+	var trapped []string
+	middleware.OnHoneypot(func(ip, honeypot string) { trapped = append(trapped, ip+" "+honeypot) })
+	var proxied bool
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = true
+	}))
+
+	request := func(remoteAddr, path string) int {
+		proxied = false
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := request("10.0.0.1:5000", "/wp-admin/install.php"); code != http.StatusNotFound || proxied {
+		t.Fatalf("Expected a honeypot to answer 404 itself, got %d (proxied %v)", code, proxied)
+	}
+	if code := request("10.0.0.1:5001", "/"); code != http.StatusForbidden {
+		t.Errorf("Expected the client to be banned at once, got %d", code)
+	}
+	if len(trapped) != 1 || trapped[0] != "10.0.0.1 /wp-admin/" {
+		t.Errorf("Expected the hit to be reported, got %v", trapped)
+	}
+
+	// Honeypots match whole paths, and allowlisted clients are never trapped
+	if code := request("10.0.0.2:5000", "/.envelope"); code != http.StatusOK {
+		t.Errorf("Expected a path merely starting like a honeypot through, got %d", code)
+	}
+	if code := request("192.168.1.7:5000", "/.env"); code != http.StatusOK || !proxied {
+		t.Errorf("Expected allowlisted clients through, got %d", code)
+	}
+}
@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// maxBannerBuffer is the largest HTML page buffered for injection; bigger
+// pages are passed through untouched.
+const maxBannerBuffer = 4 << 20
+
+const defaultBannerHTML = `<div style="background:#b71c1c;color:#fff;padding:8px;text-align:center;font-family:sans-serif">` +
+	`We are experiencing an incident. Some features may be unavailable.</div>`
+
+// BannerState is the live outage banner, toggled through the admin API
+type BannerState struct {
+	Active bool   `json:"active"`
+	HTML   string `json:"html"`
+}
+
+// Outage banner injection middleware
+type BannerMiddleware struct {
+	mu    sync.RWMutex
+	state BannerState
+}
+
+func NewBanner(cfg config.BannerConfig) *BannerMiddleware {
+	html := cfg.HTML
+	if html == "" {
+		html = defaultBannerHTML
+	}
+	return &BannerMiddleware{state: BannerState{Active: cfg.Active, HTML: html}}
+}
+
+// State returns the current banner
+func (m *BannerMiddleware) State() BannerState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// Set activates or clears the banner. An empty html keeps the current text.
+func (m *BannerMiddleware) Set(active bool, html string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Active = active
+	if html != "" {
+		m.state.HTML = html
+	}
+}
+
+func (m *BannerMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := m.State()
+		if !state.Active || r.Method == "HEAD" || !acceptsHTML(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Compressed bodies can't be edited, so ask the backend for identity
+		r.Header.Del("Accept-Encoding")
+
+		bw := &bannerWriter{ResponseWriter: w, banner: []byte(state.HTML)}
+		next.ServeHTTP(bw, r)
+		bw.finish()
+	})
+}
+
+func acceptsHTML(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return accept == "" || strings.Contains(accept, "text/html") || strings.Contains(accept, "*/*")
+}
+
+// bannerWriter buffers HTML responses so the banner can be inserted after
+// the opening <body> tag. Anything else streams straight through.
+type bannerWriter struct {
+	http.ResponseWriter
+	banner      []byte
+	status      int
+	wroteHeader bool
+	buffering   bool
+	buf         bytes.Buffer
+}
+
+func (bw *bannerWriter) WriteHeader(status int) {
+	if bw.wroteHeader {
+		return
+	}
+	bw.wroteHeader = true
+	bw.status = status
+
+	h := bw.Header()
+	bw.buffering = status == http.StatusOK &&
+		strings.HasPrefix(h.Get("Content-Type"), "text/html") &&
+		h.Get("Content-Encoding") == ""
+	if !bw.buffering {
+		bw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (bw *bannerWriter) Write(b []byte) (int, error) {
+	if !bw.wroteHeader {
+		if bw.Header().Get("Content-Type") == "" {
+			bw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		bw.WriteHeader(http.StatusOK)
+	}
+	if !bw.buffering {
+		return bw.ResponseWriter.Write(b)
+	}
+
+	if bw.buf.Len()+len(b) > maxBannerBuffer {
+		// Too large to rewrite; send what we have unmodified
+		bw.buffering = false
+		bw.ResponseWriter.WriteHeader(bw.status)
+		if _, err := bw.ResponseWriter.Write(bw.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		bw.buf.Reset()
+		return bw.ResponseWriter.Write(b)
+	}
+	return bw.buf.Write(b)
+}
+
+func (bw *bannerWriter) finish() {
+	if !bw.buffering {
+		return
+	}
+
+	body := injectBanner(bw.buf.Bytes(), bw.banner)
+	bw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	bw.ResponseWriter.WriteHeader(bw.status)
+	bw.ResponseWriter.Write(body)
+}
+
+// Flush sends what has been written so far, unless the response is being
+// buffered for the banner, so streamed responses keep streaming
+func (bw *bannerWriter) Flush() {
+	if !bw.wroteHeader {
+		bw.WriteHeader(http.StatusOK)
+	}
+	if bw.buffering {
+		return
+	}
+	http.NewResponseController(bw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (bw *bannerWriter) Unwrap() http.ResponseWriter {
+	return bw.ResponseWriter
+}
+
+func (bw *bannerWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := bw.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("hijacking not supported")
+}
+
+// injectBanner inserts banner right after the <body> tag, or at the start
+// of the document when there is none.
+func injectBanner(page, banner []byte) []byte {
+	out := make([]byte, 0, len(page)+len(banner))
+
+	if i := bodyTag(page); i >= 0 {
+		if end := bytes.IndexByte(page[i:], '>'); end >= 0 {
+			pos := i + end + 1
+			out = append(out, page[:pos]...)
+			out = append(out, banner...)
+			return append(out, page[pos:]...)
+		}
+	}
+
+	out = append(out, banner...)
+	return append(out, page...)
+}
+
+// bodyTag is the offset of "<body" in page in any ASCII case, or -1. It
+// compares page's own bytes: lowercasing a copy first can change the byte
+// length of non-ASCII characters and shift the offset.
+func bodyTag(page []byte) int {
+	const name = "body"
+	for i := 0; i+1+len(name) <= len(page); i++ {
+		if page[i] != '<' {
+			continue
+		}
+		j := 0
+		// Setting bit 0x20 lowercases an ASCII letter
+		for j < len(name) && page[i+1+j]|0x20 == name[j] {
+			j++
+		}
+		if j == len(name) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestBannerMiddleware(t *testing.T) {
+	banner := NewBanner(config.BannerConfig{HTML: "<p>incident</p>"})
+	handler := banner.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><BODY class="x"><h1>Hi</h1></BODY>`))
+	}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	if body := serve("/").Body.String(); strings.Contains(body, "incident") {
+		t.Errorf("Expected no banner while inactive, got %q", body)
+	}
+
+	banner.Set(true, "")
+
+	rr := serve("/")
+	expected := `<html><BODY class="x"><p>incident</p><h1>Hi</h1></BODY>`
+	if rr.Body.String() != expected {
+		t.Errorf("Expected banner after body tag, got %q", rr.Body.String())
+	}
+	if rr.Header().Get("Content-Length") != strconv.Itoa(len(expected)) {
+		t.Errorf("Expected Content-Length to be updated, got %v", rr.Header().Get("Content-Length"))
+	}
+
+	if body := serve("/api").Body.String(); body != `{"ok":true}` {
+		t.Errorf("Expected JSON to pass through untouched, got %q", body)
+	}
+
+	banner.Set(false, "")
+	if body := serve("/").Body.String(); strings.Contains(body, "incident") {
+		t.Errorf("Expected banner to be cleared, got %q", body)
+	}
+}
+
+func TestInjectBannerWithoutBody(t *testing.T) {
+	got := string(injectBanner([]byte("<h1>fragment</h1>"), []byte("<p>x</p>")))
+	if got != "<p>x</p><h1>fragment</h1>" {
+		t.Errorf("Expected banner to be prepended, got %q", got)
+	}
+}
+
+func TestInjectBannerAfterMultibyteText(t *testing.T) {
+	// Lowercased, İ grows a byte and the Kelvin sign shrinks two
+	testCases := []struct {
+		name string
+		page string
+		want string
+	}{
+		{"dotted capital I", "<title>İİ</title><BODY class=x>hi", "<title>İİ</title><BODY class=x><p>x</p>hi"},
+		{"kelvin sign", "<title>\u212a\u212a</title><Body>hi", "<title>\u212a\u212a</title><Body><p>x</p>hi"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(injectBanner([]byte(tc.page), []byte("<p>x</p>"))); got != tc.want {
+				t.Errorf("Expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestBannerFlush(t *testing.T) {
+	banner := NewBanner(config.BannerConfig{Active: true, HTML: "<p>incident</p>"})
+	rr := httptest.NewRecorder()
+	var streamed, unwrapped bool
+	handler := banner.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "text/html")
+		}
+		w.Write([]byte("data: 1\n\n"))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Expected flushing to be supported, got %v", err)
+		}
+		streamed = rr.Flushed && rr.Body.Len() > 0
+		unwrapped = w.(interface{ Unwrap() http.ResponseWriter }).Unwrap() == rr
+	}))
+
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/events", nil))
+	if !streamed || !unwrapped {
+		t.Errorf("Expected an event stream to be flushed through, got flushed=%v unwrapped=%v", streamed, unwrapped)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if streamed || !strings.Contains(rr.Body.String(), "<p>incident</p>") {
+		t.Errorf("Expected an HTML page to stay buffered for the banner, got flushed=%v %q", streamed, rr.Body.String())
+	}
+}
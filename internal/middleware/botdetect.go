@@ -0,0 +1,271 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// automationAgents are User-Agent fragments of HTTP libraries, headless
+// browsers and crawlers
+var automationAgents = []string{
+	"curl", "wget", "python-requests", "python-urllib", "aiohttp", "go-http-client",
+	"java/", "okhttp", "libwww-perl", "scrapy", "headlesschrome", "phantomjs",
+	"selenium", "puppeteer", "playwright", "bot", "crawler", "spider",
+}
+
+var challengeVerifyURLs = map[string]string{
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+}
+
+// maxChallengePasses bounds the clients remembered as having passed a
+// challenge
+const maxChallengePasses = 10000
+
+// Bot detection middleware
+type BotDetectionMiddleware struct {
+	cfg         config.BotDetectionConfig
+	route       func(*http.Request) string
+	allowAgents []string
+	blockAgents []string
+	client      *http.Client
+	err         error
+	now         func() time.Time
+
+	mu     sync.Mutex
+	passed map[string]time.Time
+}
+
+// NewBotDetection creates the bot detection middleware. route names the
+// route a request will match.
+func NewBotDetection(cfg config.BotDetectionConfig, route func(*http.Request) string) *BotDetectionMiddleware {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 60
+	}
+	if cfg.Action == "" {
+		cfg.Action = "block"
+	}
+	if cfg.TarpitDelay <= 0 {
+		cfg.TarpitDelay = 10
+	}
+	c := &cfg.Challenge
+	if c.TokenHeader == "" {
+		c.TokenHeader = "X-Challenge-Token"
+	}
+	if c.VerifyURL == "" {
+		c.VerifyURL = challengeVerifyURLs[c.Provider]
+	}
+	if c.PassTTL <= 0 {
+		c.PassTTL = 1800
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5
+	}
+
+	m := &BotDetectionMiddleware{
+		cfg:         cfg,
+		route:       route,
+		allowAgents: lowerAll(cfg.AllowAgents),
+		blockAgents: append(lowerAll(cfg.BlockAgents), automationAgents...),
+		client:      &http.Client{Timeout: time.Duration(c.Timeout) * time.Second},
+		now:         time.Now,
+		passed:      make(map[string]time.Time),
+	}
+
+	if cfg.Action != "block" && cfg.Action != "tarpit" {
+		m.err = fmt.Errorf("botDetection action %q must be block or tarpit", cfg.Action)
+	} else if c.Provider != "" && (c.Secret == "" || c.VerifyURL == "") {
+		m.err = errors.New("botDetection challenge requires a known provider and a secret")
+	}
+	if m.err != nil {
+		logger.Error("Bot detection misconfigured, rejecting all requests: %v", m.err)
+		return m
+	}
+
+	logger.Info("Bot detection enabled: scores from %d are handled by %s", cfg.Threshold, cfg.Action)
+	return m
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i, value := range values {
+		lowered[i] = strings.ToLower(value)
+	}
+	return lowered
+}
+
+// Score rates r from 0 (a browser) to 100 (certainly automated)
+func (m *BotDetectionMiddleware) Score(r *http.Request) int {
+	agent := strings.ToLower(r.UserAgent())
+	if agent != "" && containsAny(agent, m.allowAgents) {
+		return 0
+	}
+
+	score := 0
+	switch {
+	case agent == "":
+		score += 50
+	case containsAny(agent, m.blockAgents):
+		score += 60
+	case strings.HasPrefix(agent, "mozilla/") && r.Header.Get("Accept-Language") == "":
+		// Browsers always send their user's languages
+		score += 40
+	}
+	if r.Header.Get("Accept") == "" {
+		score += 20
+	}
+	if r.Header.Get("Accept-Encoding") == "" {
+		score += 20
+	}
+	return min(score, 100)
+}
+
+func containsAny(s string, fragments []string) bool {
+	for _, fragment := range fragments {
+		if strings.Contains(s, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *BotDetectionMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.cfg.ScoreHeader != "" {
+			r.Header.Del(m.cfg.ScoreHeader)
+		}
+
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" || hasAnyPrefix(r.URL.Path, m.cfg.SkipPaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		route := m.route(r)
+		if len(m.cfg.Routes) > 0 && !slices.Contains(m.cfg.Routes, route) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		score := m.Score(r)
+		if m.cfg.ScoreHeader != "" {
+			r.Header.Set(m.cfg.ScoreHeader, strconv.Itoa(score))
+		}
+		if score < m.cfg.Threshold || m.challengePassed(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		metrics.RecordBotDetection(route, m.cfg.Action)
+		if m.cfg.Action == "tarpit" {
+			tracing.RecordDecision(r.Context(), "botDetection", tracing.Allowed, fmt.Sprintf("tarpitted with score %d", score))
+			select {
+			case <-time.After(time.Duration(m.cfg.TarpitDelay) * time.Second):
+			case <-r.Context().Done():
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tracing.RecordDecision(r.Context(), "botDetection", tracing.Denied, fmt.Sprintf("score %d", score))
+		if m.cfg.Challenge.Provider != "" {
+			// Tells the frontend to show the challenge widget
+			w.Header().Set("X-Bot-Challenge", m.cfg.Challenge.Provider)
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
+
+// challengePassed reports whether r's client passed a challenge, checking
+// the token r carries if it has not yet
+func (m *BotDetectionMiddleware) challengePassed(r *http.Request) bool {
+	c := m.cfg.Challenge
+	if c.Provider == "" {
+		return false
+	}
+	client := ConnectingIP(r, m.cfg.UseForwardedFor)
+	now := m.now()
+
+	m.mu.Lock()
+	until, ok := m.passed[client]
+	m.mu.Unlock()
+	if ok && now.Before(until) {
+		return true
+	}
+
+	token := r.Header.Get(c.TokenHeader)
+	if token == "" {
+		return false
+	}
+	// The token is for the gateway, not the backend
+	r.Header.Del(c.TokenHeader)
+	if err := m.verify(r, token, client); err != nil {
+		logger.Warn("Bot challenge not passed: %v", err)
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.passed) >= maxChallengePasses {
+		for k, until := range m.passed {
+			if !now.Before(until) {
+				delete(m.passed, k)
+			}
+		}
+		// Still full: start over rather than grow without bound
+		if len(m.passed) >= maxChallengePasses {
+			m.passed = make(map[string]time.Time)
+		}
+	}
+	m.passed[client] = now.Add(time.Duration(c.PassTTL) * time.Second)
+	return true
+}
+
+// verify checks token with the provider's siteverify endpoint
+func (m *BotDetectionMiddleware) verify(r *http.Request, token, client string) error {
+	form := url.Values{"secret": {m.cfg.Challenge.Secret}, "response": {token}, "remoteip": {client}}
+	req, err := http.NewRequestWithContext(r.Context(), "POST", m.cfg.Challenge.VerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("siteverify answered %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("siteverify: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("token rejected: %v", result.ErrorCodes)
+	}
+	return nil
+}
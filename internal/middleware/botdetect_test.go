@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+var browserHeaders = map[string]string{
+	"User-Agent":      "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 Chrome/126.0 Safari/537.36",
+	"Accept":          "text/html,*/*",
+	"Accept-Language": "en-US,en;q=0.9",
+	"Accept-Encoding": "gzip, br",
+}
+
+func botRequest(path string, headers map[string]string) *http.Request {
+	req := httptest.NewRequest("GET", path, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return req
+}
+
+func TestBotDetectionScore(t *testing.T) {
+	m := NewBotDetection(config.BotDetectionConfig{
+		Enabled:     true,
+		AllowAgents: []string{"UptimeChecker"},
+		BlockAgents: []string{"EvilScraper"},
+	}, func(*http.Request) string { return "default" })
+
+	testCases := []struct {
+		name    string
+		headers map[string]string
+		bot     bool
+	}{
+		{"browser", browserHeaders, false},
+		{"no headers", map[string]string{}, true},
+		{"curl", map[string]string{"User-Agent": "curl/8.5.0", "Accept": "*/*"}, true},
+		{"headless chrome", map[string]string{"User-Agent": "Mozilla/5.0 HeadlessChrome/126.0", "Accept": "*/*", "Accept-Language": "en", "Accept-Encoding": "gzip"}, true},
+		{"configured agent", map[string]string{"User-Agent": "evilscraper/2", "Accept": "*/*", "Accept-Encoding": "gzip"}, true},
+		{"browser without languages or encodings", map[string]string{"User-Agent": "Mozilla/5.0", "Accept": "*/*"}, true},
+		{"allowed agent", map[string]string{"User-Agent": "UptimeChecker/1.0 bot"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			score := m.Score(botRequest("/", tc.headers))
+			if (score >= 60) != tc.bot {
+				t.Errorf("Expected bot=%v, got score %d", tc.bot, score)
+			}
+		})
+	}
+}
+
+func TestBotDetectionBlock(t *testing.T) {
+	m := NewBotDetection(config.BotDetectionConfig{
+		Enabled:     true,
+		Routes:      []string{"search"},
+		ScoreHeader: "X-Bot-Score",
+	}, func(r *http.Request) string {
+		if strings.HasPrefix(r.URL.Path, "/search") {
+			return "search"
+		}
+		return "default"
+	})
+	var score string
+	handler := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		score = r.Header.Get("X-Bot-Score")
+	}))
+
+	serve := func(req *http.Request) int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := serve(botRequest("/search?q=shoes", map[string]string{"User-Agent": "python-requests/2.31"})); code != http.StatusForbidden {
+		t.Errorf("Expected bots to be blocked on the search route, got %d", code)
+	}
+	if code := serve(botRequest("/products", map[string]string{"User-Agent": "python-requests/2.31"})); code != http.StatusOK {
+		t.Errorf("Expected other routes through, got %d", code)
+	}
+
+	spoofed := botRequest("/search?q=shoes", browserHeaders)
+	spoofed.Header.Set("X-Bot-Score", "100")
+	if code := serve(spoofed); code != http.StatusOK || score != "0" {
+		t.Errorf("Expected browsers through with the gateway's score, got %d with score %q", code, score)
+	}
+}
+
+func TestBotDetectionTarpit(t *testing.T) {
+	m := NewBotDetection(config.BotDetectionConfig{Enabled: true, Action: "tarpit", TarpitDelay: 30},
+		func(*http.Request) string { return "default" })
+	var proxied atomic.Bool
+	handler := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Store(true)
+	}))
+
+	// The bot gives up before the delay is over, and is never proxied
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), botRequest("/", nil).WithContext(ctx))
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the request to be held, returned after %v", elapsed)
+	}
+	if proxied.Load() {
+		t.Error("Expected a tarpitted request not to be proxied before the delay")
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), botRequest("/", browserHeaders))
+	if !proxied.Load() {
+		t.Error("Expected browsers not to be held")
+	}
+}
+
+func TestBotDetectionChallenge(t *testing.T) {
+	var verifications atomic.Int32
+	siteverify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verifications.Add(1)
+		ok := r.PostFormValue("secret") == "site-secret" && r.PostFormValue("response") == "human"
+		fmt.Fprintf(w, `{"success":%v}`, ok)
+	}))
+	defer siteverify.Close()
+
+	m := NewBotDetection(config.BotDetectionConfig{
+		Enabled: true,
+		Challenge: config.BotChallengeConfig{
+			Provider:  "turnstile",
+			Secret:    "site-secret",
+			VerifyURL: siteverify.URL,
+		},
+	}, func(*http.Request) string { return "default" })
+	var token string
+	handler := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("X-Challenge-Token")
+	}))
+
+	serve := func(challengeToken string) *httptest.ResponseRecorder {
+		req := botRequest("/", map[string]string{"User-Agent": "curl/8.5.0"})
+		req.RemoteAddr = "203.0.113.7:1234"
+		if challengeToken != "" {
+			req.Header.Set("X-Challenge-Token", challengeToken)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("")
+	if rr.Code != http.StatusForbidden || rr.Header().Get("X-Bot-Challenge") != "turnstile" {
+		t.Fatalf("Expected a 403 asking for a turnstile challenge, got %d %v", rr.Code, rr.Header())
+	}
+	if rr := serve("robot"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected a rejected token not to pass, got %d", rr.Code)
+	}
+	if rr := serve("human"); rr.Code != http.StatusOK || token != "" {
+		t.Fatalf("Expected a verified client through without its token, got %d %q", rr.Code, token)
+	}
+
+	// The pass is remembered
+	if rr := serve(""); rr.Code != http.StatusOK || verifications.Load() != 2 {
+		t.Errorf("Expected the client to pass without verifying again, got %d after %d verifications", rr.Code, verifications.Load())
+	}
+}
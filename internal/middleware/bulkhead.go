@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/bulkhead"
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// BulkheadMiddleware caps requests in flight across the whole gateway
+type BulkheadMiddleware struct {
+	limiter *bulkhead.Limiter
+}
+
+func NewBulkhead(cfg config.BulkheadConfig) *BulkheadMiddleware {
+	cfg = BulkheadDefaults(cfg)
+	logger.Info("Bulkhead initialized: %d concurrent, queue %d for %dms",
+		cfg.MaxConcurrent, cfg.QueueDepth, cfg.QueueTimeoutMs)
+
+	return &BulkheadMiddleware{
+		limiter: bulkhead.New("global", cfg.MaxConcurrent, cfg.QueueDepth,
+			time.Duration(cfg.QueueTimeoutMs)*time.Millisecond),
+	}
+}
+
+// BulkheadDefaults fills in the queue settings shared by the global and
+// group limits
+func BulkheadDefaults(cfg config.BulkheadConfig) config.BulkheadConfig {
+	if cfg.QueueTimeoutMs <= 0 {
+		cfg.QueueTimeoutMs = 1000
+	}
+	if cfg.QueueDepth < 0 {
+		cfg.QueueDepth = 0
+	}
+	return cfg
+}
+
+func (m *BulkheadMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		release, ok := AcquireSlot(w, r, m.limiter)
+		if !ok {
+			return
+		}
+		defer release()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AcquireSlot takes a slot from limiter for the request, writing a 503 and
+// returning false if the request had to be shed
+func AcquireSlot(w http.ResponseWriter, r *http.Request, limiter *bulkhead.Limiter) (func(), bool) {
+	release, err := limiter.Acquire(r.Context())
+	metrics.RecordBulkhead(limiter.Name, limiter.InFlight(), limiter.Waiting())
+	if err != nil {
+		reason := "queue_timeout"
+		switch {
+		case errors.Is(err, bulkhead.ErrQueueFull):
+			reason = "queue_full"
+		case r.Context().Err() != nil:
+			reason = "client_gone"
+		}
+		tracing.RecordDecision(r.Context(), "bulkhead", tracing.Denied, reason)
+		logger.Warn("Bulkhead %s shed %s %s from %s: %v",
+			limiter.Name, r.Method, r.URL.Path, getClientIP(r), err)
+		metrics.RecordBulkheadRejected(limiter.Name, reason)
+
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return nil, false
+	}
+
+	return func() {
+		release()
+		metrics.RecordBulkhead(limiter.Name, limiter.InFlight(), limiter.Waiting())
+	}, true
+}
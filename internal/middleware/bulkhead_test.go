@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestBulkheadMiddleware(t *testing.T) {
+	middleware := NewBulkhead(config.BulkheadConfig{Enabled: true, MaxConcurrent: 1, QueueTimeoutMs: 10})
+
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(entered)
+			<-unblock
+		}
+		w.Write([]byte("OK"))
+	}))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	}()
+	<-entered
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while the only slot is taken, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected health checks to bypass the bulkhead, got %d", rr.Code)
+	}
+
+	close(unblock)
+	wg.Wait()
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 once the slot is free, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+type classKey struct{}
+
+// TrafficClass is the business category assigned to a request. Priority is
+// carried along so load shedding can drop low-value traffic first.
+type TrafficClass struct {
+	Name     string
+	Priority int
+}
+
+// ClassFromContext returns the traffic class stored by ClassificationMiddleware.
+func ClassFromContext(ctx context.Context) (TrafficClass, bool) {
+	class, ok := ctx.Value(classKey{}).(TrafficClass)
+	return class, ok
+}
+
+// Classification middleware
+type ClassificationMiddleware struct {
+	header       string
+	defaultClass string
+	rules        []config.ClassificationRule
+}
+
+func NewClassification(cfg config.ClassificationConfig) *ClassificationMiddleware {
+	header := cfg.Header
+	if header == "" {
+		header = "X-Traffic-Class"
+	}
+
+	defaultClass := cfg.Default
+	if defaultClass == "" {
+		defaultClass = "other"
+	}
+
+	return &ClassificationMiddleware{
+		header:       header,
+		defaultClass: defaultClass,
+		rules:        cfg.Rules,
+	}
+}
+
+func (m *ClassificationMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := m.Classify(r)
+
+		// Always overwrite so clients cannot pick their own category
+		r.Header.Set(m.header, class.Name)
+		r = r.WithContext(context.WithValue(r.Context(), classKey{}, class))
+		trace.SpanFromContext(r.Context()).SetAttributes(
+			attribute.String("traffic.class", class.Name),
+			attribute.Int("traffic.priority", class.Priority),
+		)
+
+		rw := metrics.NewResponseWriter(w)
+		next.ServeHTTP(rw, r)
+
+		metrics.RecordClassifiedRequest(class.Name, rw.StatusCode())
+	})
+}
+
+// Classify returns the class of the first matching rule, or the default class
+func (m *ClassificationMiddleware) Classify(r *http.Request) TrafficClass {
+	for _, rule := range m.rules {
+		if ruleMatches(rule, r) {
+			return TrafficClass{Name: rule.Category, Priority: rule.Priority}
+		}
+	}
+	return TrafficClass{Name: m.defaultClass}
+}
+
+func ruleMatches(rule config.ClassificationRule, r *http.Request) bool {
+	if rule.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+		return false
+	}
+
+	if len(rule.Methods) > 0 && !containsFold(rule.Methods, r.Method) {
+		return false
+	}
+
+	for name, value := range rule.Headers {
+		if r.Header.Get(name) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsFold(slice []string, item string) bool {
+	for _, s := range slice {
+		if strings.EqualFold(s, item) {
+			return true
+		}
+	}
+	return false
+}
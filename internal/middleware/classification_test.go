@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestClassificationMiddleware(t *testing.T) {
+	middleware := NewClassification(config.ClassificationConfig{
+		Enabled: true,
+		Rules: []config.ClassificationRule{
+			{Category: "checkout", PathPrefix: "/cart/checkout", Methods: []string{"POST"}, Priority: 10},
+			{Category: "search", PathPrefix: "/search"},
+			{Category: "auth", Headers: map[string]string{"X-Login": "1"}},
+		},
+	})
+
+	testCases := []struct {
+		name     string
+		method   string
+		path     string
+		headers  map[string]string
+		expected string
+	}{
+		{"checkout POST", "POST", "/cart/checkout/123", nil, "checkout"},
+		{"checkout GET falls through", "GET", "/cart/checkout", nil, "other"},
+		{"search", "GET", "/search?q=x", nil, "search"},
+		{"header rule", "GET", "/session", map[string]string{"X-Login": "1"}, "auth"},
+		{"client header is overwritten", "GET", "/", map[string]string{"X-Traffic-Class": "checkout"}, "other"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var seenHeader string
+			var seenClass TrafficClass
+			handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seenHeader = r.Header.Get("X-Traffic-Class")
+				seenClass, _ = ClassFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if seenHeader != tc.expected {
+				t.Errorf("Expected forwarded class %v, got %v", tc.expected, seenHeader)
+			}
+			if seenClass.Name != tc.expected {
+				t.Errorf("Expected context class %v, got %v", tc.expected, seenClass.Name)
+			}
+		})
+	}
+}
+
+func TestClassificationPriority(t *testing.T) {
+	middleware := NewClassification(config.ClassificationConfig{
+		Default: "bulk",
+		Rules: []config.ClassificationRule{
+			{Category: "checkout", PathPrefix: "/checkout", Priority: 10},
+		},
+	})
+
+	class := middleware.Classify(httptest.NewRequest("GET", "/checkout", nil))
+	if class.Priority != 10 {
+		t.Errorf("Expected priority 10, got %d", class.Priority)
+	}
+
+	class = middleware.Classify(httptest.NewRequest("GET", "/reports", nil))
+	if class.Name != "bulk" || class.Priority != 0 {
+		t.Errorf("Expected default class bulk with priority 0, got %+v", class)
+	}
+}
+
+func TestClassificationTagsSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	classification := NewClassification(config.ClassificationConfig{
+		Rules: []config.ClassificationRule{{Category: "checkout", PathPrefix: "/checkout", Priority: 10}},
+	})
+	handler := NewTracing().Wrap(classification.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/checkout/pay", nil))
+
+	attrs := map[string]string{}
+	for _, kv := range recorder.Ended()[0].Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["traffic.class"] != "checkout" || attrs["traffic.priority"] != "10" {
+		t.Errorf("Expected the request span tagged with class checkout and priority 10, got %v", attrs)
+	}
+}
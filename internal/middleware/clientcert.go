@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/barisgenc/gatekeeper/internal/tlsutil"
+)
+
+const clientCertHeader = "X-Forwarded-Client-Cert"
+
+// Client certificate forwarding middleware
+type ClientCertMiddleware struct{}
+
+func NewClientCert() *ClientCertMiddleware {
+	return &ClientCertMiddleware{}
+}
+
+func (m *ClientCertMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Never trust a client-supplied header, only what the handshake proved
+		r.Header.Del(clientCertHeader)
+
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			cert := r.TLS.VerifiedChains[0][0]
+			r.Header.Set(clientCertHeader, tlsutil.ClientCertHeader(cert))
+			r = withIdentity(r, "cert:"+cert.Subject.String())
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientCertMiddlewareStripsSpoofedHeader(t *testing.T) {
+	middleware := NewClientCert()
+
+	var forwarded string
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get("X-Forwarded-Client-Cert")
+	}))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-Forwarded-Client-Cert", `Subject="CN=admin"`)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if forwarded != "" {
+		t.Errorf("Expected client-supplied header to be removed, got %v", forwarded)
+	}
+}
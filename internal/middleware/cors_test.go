@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestCORSFromConfig(t *testing.T) {
+	middleware := NewCORSFromConfig(config.CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	})
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	testCases := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"https://eu.shop.example.org", true},
+		{"https://example.org", false},
+		{"http://eu.example.org", false},
+		{"https://evilexample.org", false},
+		{"https://example.org.evil.com", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.origin, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Origin", tc.origin)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			got := rr.Header().Get("Access-Control-Allow-Origin")
+			if tc.allowed && (got != tc.origin || rr.Header().Get("Access-Control-Allow-Credentials") != "true") {
+				t.Errorf("Expected origin to be allowed with credentials, got %q", got)
+			}
+			if !tc.allowed && got != "" {
+				t.Errorf("Expected origin to be refused, got %q", got)
+			}
+		})
+	}
+
+	req := httptest.NewRequest("OPTIONS", "/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Max-Age") != "600" || rr.Header().Get("Vary") != "Origin" {
+		t.Errorf("Expected preflight to carry Max-Age and Vary, got %v", rr.Header())
+	}
+}
+
+func TestCORSSpecHeaders(t *testing.T) {
+	var called []string
+	middleware := NewCORSFromConfig(config.CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		ExposedHeaders:   []string{"X-Request-ID", "ETag"},
+		AllowCredentials: true,
+	})
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = append(called, r.Method)
+	}))
+
+	serve := func(method string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("OPTIONS", map[string]string{
+		"Origin":                         "https://app.example.com",
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "content-type, x-trace",
+	})
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected preflight to return 204, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Headers"); got != "content-type, x-trace" {
+		t.Errorf("Expected requested headers to be echoed, got %q", got)
+	}
+	if got := rr.Header().Values("Vary"); len(got) != 3 {
+		t.Errorf("Expected preflight to vary on origin and request headers, got %v", got)
+	}
+	if rr.Header().Get("Access-Control-Expose-Headers") != "" {
+		t.Error("Expected no Expose-Headers on a preflight")
+	}
+
+	rr = serve("GET", map[string]string{"Origin": "https://app.example.com"})
+	if got := rr.Header().Get("Access-Control-Expose-Headers"); got != "X-Request-ID, ETag" {
+		t.Errorf("Expected exposed headers, got %q", got)
+	}
+	if rr.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Error("Expected credentials to be allowed")
+	}
+
+	// Neither a plain OPTIONS nor a same-origin request is a CORS request
+	serve("OPTIONS", map[string]string{"Origin": "https://app.example.com"})
+	if rr = serve("GET", nil); rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("Expected no CORS headers without an Origin")
+	}
+	if len(called) != 3 || called[1] != "OPTIONS" {
+		t.Errorf("Expected non-preflight requests to reach the handler, got %v", called)
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type costKey struct{}
+
+// WithCost sets how many tokens r takes from the rate limits, for routes
+// that are more expensive to serve than others
+func WithCost(r *http.Request, cost int) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), costKey{}, cost))
+}
+
+// requestCost returns the tokens r takes from the rate limits, one unless
+// its route costs more
+func requestCost(r *http.Request) int {
+	if cost, ok := r.Context().Value(costKey{}).(int); ok && cost > 0 {
+		return cost
+	}
+	return 1
+}
+
+// tokenBucket is a token bucket that takes a request's cost rather than
+// one token. A cost above the burst size is capped at it, so an expensive
+// request still passes once the bucket is full.
+type tokenBucket struct {
+	*rate.Limiter
+}
+
+func newTokenBucket(requestsPerMinute float64, burst int) tokenBucket {
+	return tokenBucket{rate.NewLimiter(rate.Limit(requestsPerMinute/60.0), burst)}
+}
+
+// take removes cost tokens if there are enough, and returns what is left
+func (b tokenBucket) take(cost int) (bool, float64) {
+	now := time.Now()
+	ok := b.AllowN(now, min(cost, b.Burst()))
+	return ok, b.TokensAt(now)
+}
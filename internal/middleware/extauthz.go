@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/extauthz"
+	"github.com/barisgenc/gatekeeper/internal/grpcclient"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// External authorization middleware
+type ExtAuthzMiddleware struct {
+	cfg     config.ExtAuthzConfig
+	checker extauthz.Checker
+	timeout time.Duration
+	err     error
+}
+
+func NewExtAuthz(cfg config.ExtAuthzConfig) *ExtAuthzMiddleware {
+	if cfg.TimeoutMs <= 0 {
+		cfg.TimeoutMs = 500
+	}
+
+	m := &ExtAuthzMiddleware{
+		cfg:     cfg,
+		timeout: time.Duration(cfg.TimeoutMs) * time.Millisecond,
+	}
+
+	m.checker, m.err = newChecker(cfg.URL, m.timeout, cfg.UpstreamHeaders)
+	if m.err != nil {
+		logger.Error("External authorization misconfigured, rejecting all requests: %v", m.err)
+		return m
+	}
+
+	logger.Info("External authorization enabled via %s (fail open: %v)", cfg.URL, cfg.FailOpen)
+	return m
+}
+
+func newChecker(url string, timeout time.Duration, upstreamHeaders []string) (extauthz.Checker, error) {
+	switch {
+	case url == "":
+		return nil, errors.New("extAuthz requires a url")
+	case strings.HasPrefix(url, "grpc://"), strings.HasPrefix(url, "grpcs://"):
+		conn, err := grpcclient.Dial(url)
+		if err != nil {
+			return nil, err
+		}
+		return extauthz.NewGRPCChecker(conn, timeout), nil
+	case strings.HasPrefix(url, "http://"), strings.HasPrefix(url, "https://"):
+		client := &http.Client{
+			Timeout: timeout,
+			// Redirects are part of the decision, not something to follow
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+		return extauthz.NewHTTPChecker(url, upstreamHeaders, client), nil
+	default:
+		return nil, fmt.Errorf("extAuthz url %q must be http(s):// or grpc(s)://", url)
+	}
+}
+
+func (m *ExtAuthzMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" || hasAnyPrefix(r.URL.Path, m.cfg.SkipPaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		decision, err := m.checker.Check(r.Context(), extauthz.FromHTTP(r, r.RemoteAddr, m.cfg.AllowedHeaders))
+		if err != nil {
+			if m.cfg.FailOpen {
+				tracing.RecordDecision(r.Context(), "ext_authz", tracing.Allowed, "service unavailable, failing open")
+				logger.Warn("External authorization unavailable, allowing request: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			logger.Warn("External authorization unavailable, denying request: %v", err)
+			tracing.RecordDecision(r.Context(), "ext_authz", tracing.Denied, "service unavailable, failing closed")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if !decision.Allowed {
+			tracing.RecordDecision(r.Context(), "ext_authz", tracing.Denied, "denied by service",
+				attribute.Int("ext_authz.status", decision.Status))
+			for name, values := range decision.Headers {
+				w.Header()[name] = values
+			}
+			if decision.Body == "" {
+				http.Error(w, http.StatusText(decision.Status), decision.Status)
+				return
+			}
+			w.WriteHeader(decision.Status)
+			w.Write([]byte(decision.Body))
+			return
+		}
+
+		for name, values := range decision.Headers {
+			r.Header[http.CanonicalHeaderKey(name)] = values
+		}
+
+		tracing.RecordDecision(r.Context(), "ext_authz", tracing.Allowed, "allowed by service")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestExtAuthzMiddleware(t *testing.T) {
+	authz := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "valid" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("X-User-ID", "42")
+	}))
+	defer authz.Close()
+
+	var backendUser string
+	handler := NewExtAuthz(config.ExtAuthzConfig{
+		URL:             authz.URL,
+		UpstreamHeaders: []string{"X-User-ID"},
+	}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendUser = r.Header.Get("X-User-ID")
+	}))
+
+	req := httptest.NewRequest("GET", "/api", nil)
+	req.Header.Set("X-Api-Key", "valid")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || backendUser != "42" {
+		t.Errorf("Expected allowed request with user header, got %v %q", rr.Code, backendUser)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api", nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected denial to be relayed, got %v", rr.Code)
+	}
+}
+
+func TestExtAuthzFailureModes(t *testing.T) {
+	authz := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	authz.Close()
+
+	testCases := []struct {
+		name           string
+		failOpen       bool
+		expectedStatus int
+	}{
+		{"fail closed", false, http.StatusForbidden},
+		{"fail open", true, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := NewExtAuthz(config.ExtAuthzConfig{URL: authz.URL, FailOpen: tc.failOpen}).
+				Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api", nil))
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status %v, got %v", tc.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestExtAuthzRejectsUnknownScheme(t *testing.T) {
+	handler := NewExtAuthz(config.ExtAuthzConfig{URL: "tcp://authz:9000"}).
+		Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 for misconfigured url, got %v", rr.Code)
+	}
+}
@@ -0,0 +1,365 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/extproc"
+	"github.com/barisgenc/gatekeeper/internal/grpcclient"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// ExtProcMiddleware streams each request's headers, and optionally its
+// bodies, through an external processor that can change them or answer
+// the request itself
+type ExtProcMiddleware struct {
+	cfg    config.ExtProcConfig
+	client *extproc.Client
+	err    error
+}
+
+func NewExtProc(cfg config.ExtProcConfig) *ExtProcMiddleware {
+	if cfg.TimeoutMs <= 0 {
+		cfg.TimeoutMs = 200
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+
+	m := &ExtProcMiddleware{cfg: cfg}
+	timeout := time.Duration(cfg.TimeoutMs) * time.Millisecond
+	conn, err := grpcclient.Dial(cfg.URL)
+	if err != nil {
+		m.err = err
+		logger.Error("External processor misconfigured, rejecting all requests: %v", err)
+		return m
+	}
+	m.client = extproc.New(conn, timeout)
+
+	logger.Info("External processing enabled via %s (request body: %v, response body: %v, fail open: %v)",
+		cfg.URL, cfg.RequestBody, cfg.ResponseBody, cfg.FailOpen)
+	return m
+}
+
+func (m *ExtProcMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" || hasAnyPrefix(r.URL.Path, m.cfg.SkipPaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		var body []byte
+		if m.cfg.RequestBody && r.Body != nil {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, m.cfg.MaxBodyBytes+1))
+			if err != nil {
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > m.cfg.MaxBodyBytes {
+				http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		exchange, err := m.client.Open(r.Context())
+		if err != nil {
+			m.unavailable(w, r, next, err)
+			return
+		}
+		defer exchange.Close()
+
+		immediate, err := m.processRequest(exchange, r, body)
+		if err != nil {
+			if body != nil {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			m.unavailable(w, r, next, err)
+			return
+		}
+		if immediate != nil {
+			metrics.RecordExtProc("immediate")
+			tracing.RecordDecision(r.Context(), "ext_proc", tracing.Denied, "answered by processor",
+				attribute.Int("ext_proc.status", immediate.Status))
+			writeImmediate(w, immediate)
+			return
+		}
+		tracing.RecordDecision(r.Context(), "ext_proc", tracing.Allowed, "processed")
+
+		pw := &extProcWriter{ResponseWriter: w, m: m, r: r, exchange: exchange, buffering: m.cfg.ResponseBody, result: "ok"}
+		next.ServeHTTP(pw, r)
+		pw.finish()
+		metrics.RecordExtProc(pw.result)
+	})
+}
+
+// processRequest sends the request headers and body, and applies what the
+// processor changes. It returns the processor's own response if it sent one.
+func (m *ExtProcMiddleware) processRequest(exchange *extproc.Exchange, r *http.Request, body []byte) (*extproc.Immediate, error) {
+	resp, err := exchange.Headers(extproc.RequestHeaders, requestHeaders(r), len(body) == 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Immediate != nil {
+		return resp.Immediate, nil
+	}
+	if err := applyRequestMutation(r, &resp.Mutation); err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	resp, err = exchange.Body(extproc.RequestBody, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Immediate != nil {
+		return resp.Immediate, nil
+	}
+	if err := applyRequestMutation(r, &resp.Mutation); err != nil {
+		return nil, err
+	}
+	if resp.Mutation.ReplaceBody {
+		body = resp.Mutation.Body
+		r.ContentLength = int64(len(body))
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return nil, nil
+}
+
+// unavailable handles a failed exchange before the request was passed on:
+// it goes on unprocessed when failing open and is rejected otherwise
+func (m *ExtProcMiddleware) unavailable(w http.ResponseWriter, r *http.Request, next http.Handler, err error) {
+	metrics.RecordExtProc("error")
+	if m.cfg.FailOpen {
+		tracing.RecordDecision(r.Context(), "ext_proc", tracing.Allowed, "processor unavailable, failing open")
+		logger.Warn("External processor unavailable, passing request through unprocessed: %v", err)
+		next.ServeHTTP(w, r)
+		return
+	}
+	tracing.RecordDecision(r.Context(), "ext_proc", tracing.Denied, "processor unavailable, failing closed")
+	logger.Warn("External processor unavailable, rejecting request: %v", err)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
+
+// requestHeaders lists r's headers as the processor sees them, with the
+// HTTP/2 pseudo-headers first and names in lower case
+func requestHeaders(r *http.Request) []extproc.Header {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	headers := []extproc.Header{
+		{Key: ":method", Value: r.Method},
+		{Key: ":path", Value: r.URL.RequestURI()},
+		{Key: ":authority", Value: r.Host},
+		{Key: ":scheme", Value: scheme},
+	}
+	return appendHeaders(headers, r.Header)
+}
+
+func appendHeaders(headers []extproc.Header, h http.Header) []extproc.Header {
+	for name, values := range h {
+		for _, value := range values {
+			headers = append(headers, extproc.Header{Key: strings.ToLower(name), Value: value})
+		}
+	}
+	return headers
+}
+
+// applyRequestMutation changes r's headers, and its path and host when the
+// processor sets :path or :authority
+func applyRequestMutation(r *http.Request, mutation *extproc.Mutation) error {
+	mutation.Apply(r.Header)
+	if path, ok := mutation.Pseudo(":path"); ok {
+		u, err := url.ParseRequestURI(path)
+		if err != nil {
+			return fmt.Errorf("processor set an invalid :path %q", path)
+		}
+		r.URL.Path, r.URL.RawPath, r.URL.RawQuery = u.Path, u.RawPath, u.RawQuery
+	}
+	if authority, ok := mutation.Pseudo(":authority"); ok {
+		r.Host = authority
+	}
+	return nil
+}
+
+// writeImmediate sends the processor's own response in place of anything
+// the request would have produced
+func writeImmediate(w http.ResponseWriter, immediate *extproc.Immediate) {
+	for name := range w.Header() {
+		delete(w.Header(), name)
+	}
+	immediate.Headers.Apply(w.Header())
+	w.Header().Set("Content-Length", strconv.Itoa(len(immediate.Body)))
+	w.WriteHeader(immediate.Status)
+	w.Write(immediate.Body)
+}
+
+// extProcWriter sends the response headers to the processor before they
+// reach the client. With response bodies on, the whole response is held
+// back until the handler is done so the body can be sent too.
+type extProcWriter struct {
+	http.ResponseWriter
+	m        *ExtProcMiddleware
+	r        *http.Request
+	exchange *extproc.Exchange
+	result   string
+
+	status      int
+	wroteHeader bool
+	buffering   bool
+	// discard drops what the handler writes after the processor answered
+	// the request itself or the exchange failed closed
+	discard bool
+	buf     bytes.Buffer
+}
+
+func (pw *extProcWriter) WriteHeader(status int) {
+	if pw.wroteHeader {
+		return
+	}
+	pw.wroteHeader = true
+	pw.status = status
+	if !pw.buffering {
+		pw.process(nil)
+	}
+}
+
+func (pw *extProcWriter) Write(b []byte) (int, error) {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case pw.discard:
+		return len(b), nil
+	case !pw.buffering:
+		return pw.ResponseWriter.Write(b)
+	}
+
+	if int64(pw.buf.Len()+len(b)) > pw.m.cfg.MaxBodyBytes {
+		pw.buffering = false
+		if !pw.failed(fmt.Errorf("response body is larger than %d bytes", pw.m.cfg.MaxBodyBytes)) {
+			return len(b), nil
+		}
+		pw.ResponseWriter.WriteHeader(pw.status)
+		if _, err := pw.ResponseWriter.Write(pw.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		pw.buf.Reset()
+		return pw.ResponseWriter.Write(b)
+	}
+	return pw.buf.Write(b)
+}
+
+// finish processes a buffered response once the handler is done
+func (pw *extProcWriter) finish() {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	if pw.buffering {
+		pw.buffering = false
+		pw.process(pw.buf.Bytes())
+	}
+}
+
+// process runs the response through the processor and writes the headers,
+// and body when buffered, to the client
+func (pw *extProcWriter) process(body []byte) {
+	status, header := pw.status, pw.Header()
+	headers := appendHeaders([]extproc.Header{{Key: ":status", Value: strconv.Itoa(status)}}, header)
+	resp, err := pw.exchange.Headers(extproc.ResponseHeaders, headers, pw.m.cfg.ResponseBody && len(body) == 0)
+	if err == nil && resp.Immediate == nil {
+		status = applyResponseMutation(status, header, &resp.Mutation)
+		if len(body) > 0 {
+			resp, err = pw.exchange.Body(extproc.ResponseBody, body)
+		}
+		if err == nil && resp.Immediate == nil && len(body) > 0 {
+			status = applyResponseMutation(status, header, &resp.Mutation)
+			if resp.Mutation.ReplaceBody {
+				body = resp.Mutation.Body
+			}
+		}
+	}
+
+	switch {
+	case err != nil:
+		if !pw.failed(err) {
+			return
+		}
+	case resp.Immediate != nil:
+		pw.result = "immediate"
+		pw.discard = true
+		logger.Info("External processor answered %s %s with %d", pw.r.Method, pw.r.URL.Path, resp.Immediate.Status)
+		writeImmediate(pw.ResponseWriter, resp.Immediate)
+		return
+	}
+
+	if pw.m.cfg.ResponseBody {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	pw.ResponseWriter.WriteHeader(status)
+	pw.ResponseWriter.Write(body)
+}
+
+// failed handles a failed exchange once the response has started. It
+// reports whether the response may go on unprocessed; otherwise it has
+// been replaced with a 500.
+func (pw *extProcWriter) failed(err error) bool {
+	pw.result = "error"
+	if pw.m.cfg.FailOpen {
+		logger.Warn("External processor unavailable, passing response through unprocessed: %v", err)
+		return true
+	}
+	logger.Warn("External processor unavailable, rejecting response: %v", err)
+	pw.discard = true
+	for name := range pw.Header() {
+		delete(pw.Header(), name)
+	}
+	http.Error(pw.ResponseWriter, "Internal Server Error", http.StatusInternalServerError)
+	return false
+}
+
+// applyResponseMutation changes the response headers, and returns the
+// status the processor set with :status
+func applyResponseMutation(status int, header http.Header, mutation *extproc.Mutation) int {
+	mutation.Apply(header)
+	if value, ok := mutation.Pseudo(":status"); ok {
+		if code, err := strconv.Atoi(value); err == nil && code >= 100 && code <= 599 {
+			return code
+		}
+	}
+	return status
+}
+
+// Flush passes flushes through once the headers have been processed, and
+// holds them back while a buffered response is still being written
+func (pw *extProcWriter) Flush() {
+	if pw.wroteHeader && !pw.buffering {
+		http.NewResponseController(pw.ResponseWriter).Flush()
+	}
+}
+
+// Unwrap lets the proxy reach the client's connection
+func (pw *extProcWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/grpc"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// extProcessor tags request and response headers, rewrites the path,
+// drops X-Secret, upper-cases request bodies and wraps response bodies.
+// Requests with X-Block are answered with a 403.
+type extProcessor struct {
+	extprocv3.UnimplementedExternalProcessorServer
+}
+
+func (extProcessor) Process(stream extprocv3.ExternalProcessor_ProcessServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var reply *extprocv3.ProcessingResponse
+		switch r := req.GetRequest().(type) {
+		case *extprocv3.ProcessingRequest_RequestHeaders:
+			if _, blocked := extProcHeaders(r.RequestHeaders)["x-block"]; blocked {
+				reply = &extprocv3.ProcessingResponse{Response: &extprocv3.ProcessingResponse_ImmediateResponse{
+					ImmediateResponse: &extprocv3.ImmediateResponse{Status: &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden}, Body: []byte("blocked")},
+				}}
+				break
+			}
+			common := extProcSet("x-processed", "request", ":path", "/rewritten")
+			common.HeaderMutation.RemoveHeaders = []string{"x-secret"}
+			reply = &extprocv3.ProcessingResponse{Response: &extprocv3.ProcessingResponse_RequestHeaders{
+				RequestHeaders: &extprocv3.HeadersResponse{Response: common},
+			}}
+		case *extprocv3.ProcessingRequest_ResponseHeaders:
+			common := extProcSet("x-processed", "response, was "+extProcHeaders(r.ResponseHeaders)[":status"])
+			reply = &extprocv3.ProcessingResponse{Response: &extprocv3.ProcessingResponse_ResponseHeaders{
+				ResponseHeaders: &extprocv3.HeadersResponse{Response: common},
+			}}
+		case *extprocv3.ProcessingRequest_RequestBody:
+			body := strings.ToUpper(string(r.RequestBody.GetBody()))
+			reply = &extprocv3.ProcessingResponse{Response: &extprocv3.ProcessingResponse_RequestBody{
+				RequestBody: &extprocv3.BodyResponse{Response: extProcBody(body)},
+			}}
+		case *extprocv3.ProcessingRequest_ResponseBody:
+			body := "wrapped(" + string(r.ResponseBody.GetBody()) + ")"
+			reply = &extprocv3.ProcessingResponse{Response: &extprocv3.ProcessingResponse_ResponseBody{
+				ResponseBody: &extprocv3.BodyResponse{Response: extProcBody(body)},
+			}}
+		}
+		if err := stream.Send(reply); err != nil {
+			return err
+		}
+	}
+}
+
+// newExtProcessor serves Process on a local port
+func newExtProcessor(t *testing.T) (string, func()) {
+	return serveGRPC(t, func(server *grpc.Server) {
+		extprocv3.RegisterExternalProcessorServer(server, extProcessor{})
+	})
+}
+
+// extProcHeaders reads the raw header values the gateway sends
+func extProcHeaders(h *extprocv3.HttpHeaders) map[string]string {
+	headers := make(map[string]string)
+	for _, header := range h.GetHeaders().GetHeaders() {
+		headers[header.GetKey()] = string(header.GetRawValue())
+	}
+	return headers
+}
+
+// extProcSet sets the headers in pairs of key and value
+func extProcSet(pairs ...string) *extprocv3.CommonResponse {
+	mutation := &extprocv3.HeaderMutation{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		mutation.SetHeaders = append(mutation.SetHeaders, &corev3.HeaderValueOption{
+			Header: &corev3.HeaderValue{Key: pairs[i], RawValue: []byte(pairs[i+1])},
+		})
+	}
+	return &extprocv3.CommonResponse{HeaderMutation: mutation}
+}
+
+// extProcBody replaces the body
+func extProcBody(body string) *extprocv3.CommonResponse {
+	return &extprocv3.CommonResponse{BodyMutation: &extprocv3.BodyMutation{
+		Mutation: &extprocv3.BodyMutation_Body{Body: []byte(body)},
+	}}
+}
+
+func TestExtProc(t *testing.T) {
+	url, stop := newExtProcessor(t)
+	defer stop()
+
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Upstream-Path", r.URL.Path)
+		w.Header().Set("X-Upstream-Processed", r.Header.Get("X-Processed"))
+		w.Header().Set("X-Upstream-Secret", r.Header.Get("X-Secret"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("got " + string(body)))
+	})
+
+	tests := []struct {
+		name       string
+		cfg        config.ExtProcConfig
+		headers    map[string]string
+		wantStatus int
+		wantBody   string
+		wantHeader map[string]string
+	}{
+		{
+			name:       "headers are processed both ways",
+			cfg:        config.ExtProcConfig{URL: url},
+			headers:    map[string]string{"X-Secret": "s3cret"},
+			wantStatus: http.StatusCreated,
+			wantBody:   "got hello",
+			wantHeader: map[string]string{
+				"X-Upstream-Path":      "/rewritten",
+				"X-Upstream-Processed": "request",
+				"X-Upstream-Secret":    "",
+				"X-Processed":          "response, was 201",
+			},
+		},
+		{
+			name:       "bodies are processed when enabled",
+			cfg:        config.ExtProcConfig{URL: url, RequestBody: true, ResponseBody: true},
+			wantStatus: http.StatusCreated,
+			wantBody:   "wrapped(got HELLO)",
+			wantHeader: map[string]string{"Content-Length": "18"},
+		},
+		{
+			name:       "processor answers the request itself",
+			cfg:        config.ExtProcConfig{URL: url},
+			headers:    map[string]string{"X-Block": "1"},
+			wantStatus: http.StatusForbidden,
+			wantBody:   "blocked",
+			wantHeader: map[string]string{"X-Upstream-Path": ""},
+		},
+		{
+			name:       "unreachable processor fails closed",
+			cfg:        config.ExtProcConfig{URL: "grpc://127.0.0.1:1"},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "unreachable processor fails open",
+			cfg:        config.ExtProcConfig{URL: "grpc://127.0.0.1:1", FailOpen: true, RequestBody: true},
+			wantStatus: http.StatusCreated,
+			wantBody:   "got hello",
+			wantHeader: map[string]string{"X-Upstream-Path": "/api/orders"},
+		},
+		{
+			name:       "oversized request body is rejected",
+			cfg:        config.ExtProcConfig{URL: url, RequestBody: true, MaxBodyBytes: 3},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewExtProc(tt.cfg).Wrap(backend)
+			req := httptest.NewRequest("POST", "/api/orders", strings.NewReader("hello"))
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d (%s)", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("Expected body %q, got %q", tt.wantBody, rec.Body.String())
+			}
+			for name, want := range tt.wantHeader {
+				if got := rec.Header().Get(name); got != want {
+					t.Errorf("Expected %s %q, got %q", name, want, got)
+				}
+			}
+		})
+	}
+}
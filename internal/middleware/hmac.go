@@ -0,0 +1,289 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/signature"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// HMAC request signature middleware
+type HMACMiddleware struct {
+	cfg     config.HMACConfig
+	secrets map[string][]byte
+	plans   map[string]string
+	maxSkew time.Duration
+	now     func() time.Time
+	err     error
+	seen    *replayCache
+}
+
+func NewHMAC(cfg config.HMACConfig) *HMACMiddleware {
+	if cfg.Scheme == "" {
+		cfg.Scheme = "simple"
+	}
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = "sha256"
+	}
+	if cfg.SignatureHeader == "" {
+		cfg.SignatureHeader = "X-Signature"
+	}
+	if cfg.KeyIDHeader == "" {
+		cfg.KeyIDHeader = "X-Key-ID"
+	}
+	if cfg.TimestampHeader == "" {
+		cfg.TimestampHeader = "X-Timestamp"
+	}
+	if len(cfg.RequiredHeaders) == 0 {
+		cfg.RequiredHeaders = []string{"host", cfg.TimestampHeader}
+	}
+	if cfg.MaxSkew <= 0 {
+		cfg.MaxSkew = 300
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 10 << 20
+	}
+	if cfg.ConsumerHeader == "" {
+		cfg.ConsumerHeader = "X-Consumer-ID"
+	}
+
+	m := &HMACMiddleware{
+		cfg:     cfg,
+		secrets: make(map[string][]byte, len(cfg.Consumers)),
+		plans:   make(map[string]string),
+		maxSkew: time.Duration(cfg.MaxSkew) * time.Second,
+		now:     time.Now,
+		seen:    newReplayCache(maxReplayEntries),
+	}
+
+	for _, consumer := range cfg.Consumers {
+		if consumer.ID == "" || len(consumer.Secret) < 16 {
+			m.err = fmt.Errorf("hmac consumer %q needs an id and a secret of at least 16 characters", consumer.ID)
+		}
+		m.secrets[consumer.ID] = []byte(consumer.Secret)
+		if consumer.Plan != "" {
+			m.plans[consumer.ID] = consumer.Plan
+		}
+	}
+	switch {
+	case m.err != nil:
+	case cfg.Scheme != "simple" && cfg.Scheme != "authorization":
+		m.err = fmt.Errorf("hmac scheme %q must be simple or authorization", cfg.Scheme)
+	case len(m.secrets) == 0:
+		m.err = errors.New("hmac requires at least one consumer")
+	}
+
+	if m.err != nil {
+		logger.Error("HMAC middleware misconfigured, rejecting all requests: %v", m.err)
+		return m
+	}
+
+	logger.Info("HMAC signatures required (%s scheme, %d consumers)", cfg.Scheme, len(m.secrets))
+	return m
+}
+
+func (m *HMACMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The consumer header is only ever set by the gateway
+		r.Header.Del(m.cfg.ConsumerHeader)
+
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" || hasAnyPrefix(r.URL.Path, m.cfg.SkipPaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, m.cfg.MaxBodyBytes+1))
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) > m.cfg.MaxBodyBytes {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		consumer, err := m.verify(r, body)
+		if err != nil {
+			logger.Warn("HMAC signature rejected for %s %s from %s: %v", r.Method, r.URL.Path, getClientIP(r), err)
+			tracing.RecordDecision(r.Context(), "hmac", tracing.Denied, err.Error())
+			if errors.Is(err, errReplayCacheFull) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(replayBucket.Seconds())))
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		r.Header.Set(m.cfg.ConsumerHeader, consumer)
+		tracing.RecordDecision(r.Context(), "hmac", tracing.Allowed, "valid signature",
+			attribute.String("hmac.consumer", consumer))
+		r = withIdentity(r, "hmac:"+consumer)
+		if plan, ok := m.plans[consumer]; ok {
+			r = withPlan(r, plan)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verify checks the signature and returns the consumer ID
+func (m *HMACMiddleware) verify(r *http.Request, body []byte) (string, error) {
+	timestamp := r.Header.Get(m.cfg.TimestampHeader)
+	signedAt, err := parseTimestamp(timestamp)
+	if err != nil {
+		return "", err
+	}
+	now := m.now()
+	if signedAt.Before(now.Add(-m.maxSkew)) || signedAt.After(now.Add(m.maxSkew)) {
+		return "", errors.New("timestamp outside allowed skew")
+	}
+
+	var keyID, provided, expected string
+	if m.cfg.Scheme == "authorization" {
+		auth, err := signature.ParseAuthorization(r.Header.Get("Authorization"))
+		if err != nil {
+			return "", err
+		}
+		if auth.Algorithm != m.cfg.Algorithm {
+			return "", fmt.Errorf("algorithm %q not accepted", auth.Algorithm)
+		}
+		for _, required := range m.cfg.RequiredHeaders {
+			if !containsFold(auth.SignedHeaders, required) {
+				return "", fmt.Errorf("header %q must be signed", required)
+			}
+		}
+
+		keyID, provided = auth.KeyID, auth.Signature
+		secret, ok := m.secrets[keyID]
+		if !ok {
+			return "", fmt.Errorf("unknown key %q", keyID)
+		}
+		expected, err = signature.Canonical(m.cfg.Algorithm, secret, r, auth.SignedHeaders, timestamp, body)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		keyID, provided = r.Header.Get(m.cfg.KeyIDHeader), r.Header.Get(m.cfg.SignatureHeader)
+		if provided == "" {
+			return "", signature.ErrMissing
+		}
+		secret, ok := m.secrets[keyID]
+		if !ok {
+			return "", fmt.Errorf("unknown key %q", keyID)
+		}
+		expected, err = signature.Simple(m.cfg.Algorithm, secret, r.Method, r.URL.RequestURI(), timestamp, body)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if !signature.Equal(provided, expected) {
+		return "", errors.New("signature mismatch")
+	}
+
+	fresh, err := m.seen.add(keyID+":"+strings.ToLower(provided), signedAt.Add(m.maxSkew), now)
+	if err != nil {
+		return "", err
+	}
+	if !fresh {
+		return "", errors.New("signature replayed")
+	}
+	return keyID, nil
+}
+
+// maxReplayEntries caps the signatures remembered against replays. Once
+// it is reached, new signatures are refused until old ones expire rather
+// than memory growing with traffic.
+const maxReplayEntries = 100000
+
+// replayBucket is the span of expiry times kept in one set, so expired
+// signatures are dropped a set at a time
+const replayBucket = 10 * time.Second
+
+var errReplayCacheFull = errors.New("replay cache full")
+
+// replayCache remembers signatures until they fall out of the skew window,
+// in sets by expiry time
+type replayCache struct {
+	limit int
+
+	mu        sync.Mutex
+	seen      map[string]struct{}
+	buckets   map[int64][]string
+	lastPrune int64
+}
+
+func newReplayCache(limit int) *replayCache {
+	return &replayCache{
+		limit:   limit,
+		seen:    make(map[string]struct{}),
+		buckets: make(map[int64][]string),
+	}
+}
+
+// add records key until expires and reports whether it was new
+func (c *replayCache) add(key string, expires, now time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// At most once a second, and over a handful of sets
+	if second := now.Unix(); second != c.lastPrune {
+		c.lastPrune = second
+		for bucket, keys := range c.buckets {
+			if bucket < second {
+				for _, k := range keys {
+					delete(c.seen, k)
+				}
+				delete(c.buckets, bucket)
+			}
+		}
+	}
+
+	if _, ok := c.seen[key]; ok {
+		return false, nil
+	}
+	if len(c.seen) >= c.limit {
+		return false, errReplayCacheFull
+	}
+	// A set is kept until the last expiry it can hold
+	bucket := expires.Add(replayBucket - time.Nanosecond).Truncate(replayBucket).Unix()
+	c.seen[key] = struct{}{}
+	c.buckets[bucket] = append(c.buckets[bucket], key)
+	return true, nil
+}
+
+// parseTimestamp accepts unix seconds, RFC 3339 or the compact ISO 8601
+// form used by SigV4 (20060102T150405Z)
+func parseTimestamp(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("missing timestamp")
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+}
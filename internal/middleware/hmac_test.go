@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/signature"
+)
+
+const testHMACSecret = "0123456789abcdef0123"
+
+func TestHMACSimpleScheme(t *testing.T) {
+	var consumer, principal, plan, body string
+	handler := NewHMAC(config.HMACConfig{
+		Consumers: []config.HMACConsumer{{ID: "billing", Secret: testHMACSecret, Plan: "pro"}},
+	}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		consumer = r.Header.Get("X-Consumer-ID")
+		principal, _ = IdentityFromContext(r.Context())
+		plan, _ = PlanFromContext(r.Context())
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	}))
+
+	signed := func(ts time.Time, payload string) *http.Request {
+		timestamp := strconv.FormatInt(ts.Unix(), 10)
+		sig, _ := signature.Simple("sha256", []byte(testHMACSecret), "POST", "/charges?x=1", timestamp, []byte(payload))
+		req := httptest.NewRequest("POST", "/charges?x=1", strings.NewReader(payload))
+		req.Header.Set("X-Key-ID", "billing")
+		req.Header.Set("X-Timestamp", timestamp)
+		req.Header.Set("X-Signature", sig)
+		return req
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, signed(time.Now(), `{"amount":10}`))
+	if rr.Code != http.StatusOK || consumer != "billing" || body != `{"amount":10}` {
+		t.Fatalf("Expected valid signature to pass with body intact, got %v %q %q", rr.Code, consumer, body)
+	}
+	if principal != "hmac:billing" {
+		t.Errorf("Expected the consumer to be recorded as the identity, got %q", principal)
+	}
+	if plan != "pro" {
+		t.Errorf("Expected the key's rate limit plan, got %q", plan)
+	}
+
+	tampered := signed(time.Now().Add(time.Second), `{"amount":10}`)
+	tampered.Body = http.NoBody
+	testCases := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"stale timestamp", signed(time.Now().Add(-time.Hour), `{}`)},
+		{"tampered body", tampered},
+		{"unsigned", httptest.NewRequest("POST", "/charges", nil)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, tc.req)
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("Expected 401, got %v", rr.Code)
+			}
+		})
+	}
+}
+
+func TestHMACRejectsReplay(t *testing.T) {
+	handler := NewHMAC(config.HMACConfig{
+		Consumers: []config.HMACConsumer{{ID: "billing", Secret: testHMACSecret}},
+	}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	sig, _ := signature.Simple("sha256", []byte(testHMACSecret), "GET", "/x", timestamp, nil)
+
+	for i, expected := range []int{http.StatusOK, http.StatusUnauthorized} {
+		req := httptest.NewRequest("GET", "/x", nil)
+		req.Header.Set("X-Key-ID", "billing")
+		req.Header.Set("X-Timestamp", timestamp)
+		req.Header.Set("X-Signature", sig)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != expected {
+			t.Errorf("Attempt %d: expected %v, got %v", i+1, expected, rr.Code)
+		}
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	c := newReplayCache(2)
+	now := time.Now()
+
+	for i, key := range []string{"a", "b"} {
+		if fresh, err := c.add(key, now.Add(time.Duration(i+1)*time.Minute), now); !fresh || err != nil {
+			t.Fatalf("Expected %s to be new, got %v %v", key, fresh, err)
+		}
+	}
+	if fresh, err := c.add("a", now.Add(time.Minute), now); fresh || err != nil {
+		t.Errorf("Expected a replay to be caught, got %v %v", fresh, err)
+	}
+	if _, err := c.add("c", now.Add(time.Minute), now); err != errReplayCacheFull {
+		t.Errorf("Expected a full cache to refuse new signatures, got %v", err)
+	}
+
+	// Once a's set expires there is room again, and b is still remembered
+	later := now.Add(time.Minute + 2*replayBucket)
+	if fresh, err := c.add("c", later.Add(time.Minute), later); !fresh || err != nil {
+		t.Errorf("Expected room after expiry, got %v %v", fresh, err)
+	}
+	if fresh, _ := c.add("b", later.Add(time.Minute), later); fresh {
+		t.Error("Expected an unexpired signature to be kept")
+	}
+}
+
+func TestHMACAuthorizationScheme(t *testing.T) {
+	handler := NewHMAC(config.HMACConfig{
+		Scheme:    "authorization",
+		Consumers: []config.HMACConsumer{{ID: "partner", Secret: testHMACSecret}},
+	}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	sign := func(signedHeaders []string) *http.Request {
+		req := httptest.NewRequest("GET", "http://api.example.com/items?b=2&a=1", nil)
+		timestamp := time.Now().UTC().Format("20060102T150405Z")
+		req.Header.Set("X-Timestamp", timestamp)
+		sig, _ := signature.Canonical("sha256", []byte(testHMACSecret), req, signedHeaders, timestamp, nil)
+		req.Header.Set("Authorization", signature.Authorization{
+			Algorithm: "sha256", KeyID: "partner", SignedHeaders: signedHeaders, Signature: sig,
+		}.String())
+		return req
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, sign([]string{"host", "x-timestamp"}))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected valid authorization signature, got %v", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, sign([]string{"host"}))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected signature without timestamp header to be rejected, got %v", rr.Code)
+	}
+}
+
+func TestHMACFailsClosedWithWeakSecret(t *testing.T) {
+	handler := NewHMAC(config.HMACConfig{
+		Consumers: []config.HMACConsumer{{ID: "x", Secret: "short"}},
+	}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 for weak secret, got %v", rr.Code)
+	}
+}
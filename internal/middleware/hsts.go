@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// HSTS middleware
+type HSTSMiddleware struct {
+	value string
+}
+
+func NewHSTS(cfg config.HSTSConfig) *HSTSMiddleware {
+	value := "max-age=" + strconv.Itoa(cfg.MaxAge)
+	if cfg.IncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if cfg.Preload {
+		value += "; preload"
+	}
+	return &HSTSMiddleware{value: value}
+}
+
+func (m *HSTSMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Browsers ignore the header over plain HTTP, so only send it on TLS
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", m.value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
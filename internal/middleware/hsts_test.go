@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestHSTSMiddleware(t *testing.T) {
+	handler := NewHSTS(config.HSTSConfig{MaxAge: 31536000, IncludeSubdomains: true, Preload: true}).
+		Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "https://example.com/", nil)
+	req.TLS = &tls.ConnectionState{}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	expected := "max-age=31536000; includeSubDomains; preload"
+	if got := rr.Header().Get("Strict-Transport-Security"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com/", nil))
+	if got := rr.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("Expected no HSTS header over plain HTTP, got %q", got)
+	}
+}
@@ -0,0 +1,271 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/idempotency"
+	"github.com/barisgenc/gatekeeper/internal/kv"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// IdempotencyMiddleware replays stored responses for repeated
+// Idempotency-Keys, Stripe style: the same key with a different request is
+// rejected with 422 and a key whose first request is still running with 409.
+type IdempotencyMiddleware struct {
+	cfg     config.IdempotencyConfig
+	store   idempotency.Store
+	methods map[string]bool
+	ttl     time.Duration
+	lock    time.Duration
+	err     error
+}
+
+func NewIdempotency(cfg config.IdempotencyConfig) *IdempotencyMiddleware {
+	return NewIdempotencyWithStorage(cfg, nil)
+}
+
+// NewIdempotencyWithStorage is NewIdempotency with the gateway's shared storage, used when
+// cfg.Store is "shared"
+func NewIdempotencyWithStorage(cfg config.IdempotencyConfig, storage kv.Store) *IdempotencyMiddleware {
+	if cfg.Header == "" {
+		cfg.Header = "Idempotency-Key"
+	}
+	if len(cfg.Methods) == 0 {
+		cfg.Methods = []string{http.MethodPost}
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 86400
+	}
+	if cfg.LockTimeout <= 0 {
+		cfg.LockTimeout = 60
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	if cfg.Store == "" {
+		cfg.Store = "memory"
+	}
+
+	m := &IdempotencyMiddleware{
+		cfg:     cfg,
+		methods: make(map[string]bool, len(cfg.Methods)),
+		ttl:     time.Duration(cfg.TTL) * time.Second,
+		lock:    time.Duration(cfg.LockTimeout) * time.Second,
+	}
+	for _, method := range cfg.Methods {
+		m.methods[strings.ToUpper(method)] = true
+	}
+
+	m.store, m.err = newIdempotencyStore(cfg, storage)
+	if m.err != nil {
+		logger.Error("Idempotency misconfigured, rejecting all requests: %v", m.err)
+		return m
+	}
+
+	logger.Info("Idempotency keys enabled on %s for %v (%s store)", cfg.Header, cfg.Methods, cfg.Store)
+	return m
+}
+
+func newIdempotencyStore(cfg config.IdempotencyConfig, storage kv.Store) (idempotency.Store, error) {
+	switch cfg.Store {
+	case "memory":
+		return idempotency.NewMemoryStore(), nil
+	case "redis":
+		if cfg.Redis.Address == "" {
+			return nil, errors.New("idempotency redis store requires an address")
+		}
+		client := redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Address,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		})
+		return idempotency.NewRedisStore(client, cfg.Redis.KeyPrefix), nil
+	case "shared":
+		if storage == nil {
+			return nil, errors.New("idempotency shared store requires storage")
+		}
+		return idempotency.NewKVStore(storage), nil
+	default:
+		return nil, fmt.Errorf("idempotency store %q must be memory, redis or shared", cfg.Store)
+	}
+}
+
+func (m *IdempotencyMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(m.cfg.Header)
+		if key == "" || !m.methods[r.Method] || hasAnyPrefix(r.URL.Path, m.cfg.SkipPaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if len(key) > 255 {
+			http.Error(w, m.cfg.Header+" must be at most 255 characters", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, m.cfg.MaxBodyBytes))
+		if err != nil {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		storeKey := m.scopedKey(r, key)
+		fingerprint := hashParts(r.Method, r.URL.RequestURI(), string(body))
+
+		record, claimed, err := m.store.Claim(r.Context(), storeKey, fingerprint, m.lock)
+		if err != nil {
+			// Proxying without the key could run the request twice
+			logger.Error("Idempotency store unavailable: %v", err)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		if !claimed {
+			m.answerDuplicate(w, r, record, fingerprint)
+			return
+		}
+
+		cw := &idempotencyWriter{ResponseWriter: w, maxBody: int(m.cfg.MaxBodyBytes), keep: true}
+		next.ServeHTTP(cw, r)
+
+		if !cw.wroteHeader {
+			cw.status = http.StatusOK
+			cw.header = w.Header().Clone()
+		}
+		m.finish(r, storeKey, fingerprint, cw)
+	})
+}
+
+func (m *IdempotencyMiddleware) answerDuplicate(w http.ResponseWriter, r *http.Request, record idempotency.Record, fingerprint string) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		metrics.RecordIdempotentRequest("mismatch")
+		tracing.RecordDecision(r.Context(), "idempotency", tracing.Denied, "key reused with a different request")
+		http.Error(w, m.cfg.Header+" was already used for a different request", http.StatusUnprocessableEntity)
+	case !record.Done:
+		metrics.RecordIdempotentRequest("conflict")
+		tracing.RecordDecision(r.Context(), "idempotency", tracing.Denied, "original request still in flight")
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "A request with this "+m.cfg.Header+" is still being processed", http.StatusConflict)
+	default:
+		metrics.RecordIdempotentRequest("replayed")
+		header := w.Header()
+		for name, values := range record.Header {
+			header[name] = values
+		}
+		header.Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.Status)
+		w.Write(record.Body)
+	}
+}
+
+// finish stores the response, or releases the key when the request was
+// shed or never reached a backend so a retry can go through
+func (m *IdempotencyMiddleware) finish(r *http.Request, key, fingerprint string, cw *idempotencyWriter) {
+	retryable := cw.status == http.StatusTooManyRequests ||
+		cw.status == http.StatusBadGateway || cw.status == http.StatusServiceUnavailable
+
+	// The client is waiting on this request, not on the store
+	ctx := context.WithoutCancel(r.Context())
+
+	if retryable || !cw.keep {
+		metrics.RecordIdempotentRequest("released")
+		if err := m.store.Release(ctx, key); err != nil {
+			logger.Warn("Releasing idempotency key failed: %v", err)
+		}
+		return
+	}
+
+	err := m.store.Complete(ctx, key, idempotency.Record{
+		Fingerprint: fingerprint,
+		Status:      cw.status,
+		Header:      cw.header,
+		Body:        cw.body.Bytes(),
+	}, m.ttl)
+	if err != nil {
+		logger.Warn("Storing idempotent response failed: %v", err)
+		return
+	}
+	metrics.RecordIdempotentRequest("stored")
+}
+
+// scopedKey keeps clients from seeing each other's responses: keys belong
+// to the authenticated identity, or to the client address otherwise
+func (m *IdempotencyMiddleware) scopedKey(r *http.Request, key string) string {
+	scope, ok := IdentityFromContext(r.Context())
+	if !ok {
+		scope = "ip:" + ConnectingIP(r, false)
+	}
+	return hashParts(scope, key)
+}
+
+func hashParts(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyWriter passes the response through and keeps a copy unless it
+// grows past maxBody
+type idempotencyWriter struct {
+	http.ResponseWriter
+	maxBody     int
+	keep        bool
+	wroteHeader bool
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+}
+
+func (cw *idempotencyWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+	cw.header = cw.Header().Clone()
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *idempotencyWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.keep {
+		if cw.body.Len()+len(b) > cw.maxBody {
+			cw.keep = false
+			cw.body.Reset()
+		} else {
+			cw.body.Write(b)
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets the proxy flush through to the client
+func (cw *idempotencyWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestIdempotencyReplaysDuplicatePost(t *testing.T) {
+	var calls atomic.Int32
+	middleware := NewIdempotency(config.IdempotencyConfig{Enabled: true})
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Charge", string(body))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte{byte('0' + n)})
+	}))
+
+	serve := func(key, body, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/charges", strings.NewReader(body))
+		req.RemoteAddr = remote
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := serve("abc", "amount=10", "198.51.100.7:4000")
+	if first.Code != http.StatusCreated || first.Body.String() != "1" {
+		t.Fatalf("Expected first request to be proxied, got %d %q", first.Code, first.Body.String())
+	}
+
+	replay := serve("abc", "amount=10", "198.51.100.7:4001")
+	if replay.Code != http.StatusCreated || replay.Body.String() != "1" {
+		t.Errorf("Expected stored response to be replayed, got %d %q", replay.Code, replay.Body.String())
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" || replay.Header().Get("X-Charge") != "amount=10" {
+		t.Errorf("Expected replayed headers, got %v", replay.Header())
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected backend to be called once, got %d", calls.Load())
+	}
+
+	if rr := serve("abc", "amount=99", "198.51.100.7:4000"); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected reused key with a different body to be rejected, got %d", rr.Code)
+	}
+
+	// Keys belong to the client that sent them
+	if rr := serve("abc", "amount=10", "203.0.113.9:4000"); rr.Body.String() != "2" {
+		t.Errorf("Expected another client's key to be independent, got %q", rr.Body.String())
+	}
+
+	if rr := serve("", "amount=10", "198.51.100.7:4000"); rr.Body.String() != "3" {
+		t.Errorf("Expected request without a key to be proxied, got %q", rr.Body.String())
+	}
+}
+
+func TestIdempotencyReleasesKeyOnRetryableStatus(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	middleware := NewIdempotency(config.IdempotencyConfig{Enabled: true})
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+
+	serve := func() int {
+		req := httptest.NewRequest("POST", "/charges", strings.NewReader("x"))
+		req.Header.Set("Idempotency-Key", "retry-me")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d", code)
+	}
+	status = http.StatusOK
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("Expected retry after 503 to reach the backend, got %d", code)
+	}
+}
+
+func TestIdempotencyRejectsConcurrentDuplicate(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	middleware := NewIdempotency(config.IdempotencyConfig{Enabled: true})
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("POST", "/charges", strings.NewReader("x"))
+		req.Header.Set("Idempotency-Key", "slow")
+		return req
+	}
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest())
+		close(done)
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequest())
+	close(release)
+	<-done
+
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 while the first request is in flight, got %d", rr.Code)
+	}
+}
+
+func TestIdempotencyFailsClosedOnBadStore(t *testing.T) {
+	middleware := NewIdempotency(config.IdempotencyConfig{Enabled: true, Store: "redis"})
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not be called")
+	}))
+
+	req := httptest.NewRequest("POST", "/charges", nil)
+	req.Header.Set("Idempotency-Key", "abc")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 for misconfigured store, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/barisgenc/gatekeeper/internal/accesslog"
+)
+
+type identityKey struct{}
+
+// withIdentity records the principal an authentication middleware
+// verified, so later middlewares can tell authenticated requests apart
+func withIdentity(r *http.Request, principal string) *http.Request {
+	accesslog.SetConsumer(r.Context(), principal)
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, principal))
+}
+
+// IdentityFromContext returns the principal verified for the request, if any
+func IdentityFromContext(ctx context.Context) (string, bool) {
+	principal, ok := ctx.Value(identityKey{}).(string)
+	return principal, ok
+}
+
+type planKey struct{}
+
+// withPlan records the rate limit plan the credential of the request names
+func withPlan(r *http.Request, plan string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), planKey{}, plan))
+}
+
+// PlanFromContext returns the rate limit plan the request's credential
+// names, if any
+func PlanFromContext(ctx context.Context) (string, bool) {
+	plan, ok := ctx.Value(planKey{}).(string)
+	return plan, ok
+}
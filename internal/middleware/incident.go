@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/incident"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// IncidentMiddleware watches response statuses for 5xx storms and applies
+// incident mode while one is open
+type IncidentMiddleware struct {
+	detector          *incident.Detector
+	shedBelowPriority int
+}
+
+func NewIncident(cfg config.IncidentConfig) *IncidentMiddleware {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 0.25
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 30
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 50
+	}
+	if cfg.MinDuration <= 0 {
+		cfg.MinDuration = 120
+	}
+
+	logger.Info("Incident mode trips at %.0f%% 5xx over %ds", cfg.Threshold*100, cfg.Window)
+
+	return &IncidentMiddleware{
+		detector: incident.NewDetector(cfg.Threshold, cfg.Window, cfg.MinRequests,
+			time.Duration(cfg.MinDuration)*time.Second),
+		shedBelowPriority: cfg.ShedBelowPriority,
+	}
+}
+
+// Current returns the open incident, if any
+func (m *IncidentMiddleware) Current() (incident.Incident, bool) {
+	return m.detector.Current()
+}
+
+func (m *IncidentMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if inc, open := m.detector.Current(); open {
+			r = r.WithContext(incident.WithIncident(r.Context(), inc))
+			w.Header().Set("X-Incident-ID", inc.ID)
+
+			if class, ok := ClassFromContext(r.Context()); ok && class.Priority < m.shedBelowPriority {
+				tracing.RecordDecision(r.Context(), "incident", tracing.Denied, "low priority during incident")
+				metrics.RecordIncidentShed()
+				w.Header().Set("Retry-After", "30")
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		rw := metrics.NewResponseWriter(w)
+		next.ServeHTTP(rw, r)
+		m.detector.Record(rw.Status())
+	})
+}
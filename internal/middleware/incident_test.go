@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestIncidentMiddleware(t *testing.T) {
+	incident := NewIncident(config.IncidentConfig{
+		Enabled:           true,
+		Threshold:         0.5,
+		MinRequests:       4,
+		ShedBelowPriority: 5,
+	})
+	classification := NewClassification(config.ClassificationConfig{
+		Enabled: true,
+		Rules:   []config.ClassificationRule{{Category: "checkout", PathPrefix: "/checkout", Priority: 10}},
+	})
+
+	failing := true
+	handler := classification.Wrap(incident.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("OK"))
+	})))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	for i := 0; i < 4; i++ {
+		serve("/checkout")
+	}
+	failing = false
+
+	// The detector evaluates at most once a second
+	inc, open := incident.Current()
+	for i := 0; !open && i < 30; i++ {
+		time.Sleep(100 * time.Millisecond)
+		inc, open = incident.Current()
+	}
+	if !open {
+		t.Fatal("Expected an incident after a burst of 502s")
+	}
+
+	rr := serve("/checkout")
+	if rr.Code != http.StatusOK || rr.Header().Get("X-Incident-ID") != inc.ID {
+		t.Errorf("Expected high-priority traffic to pass tagged with %s, got %d %q", inc.ID, rr.Code, rr.Header().Get("X-Incident-ID"))
+	}
+
+	if rr := serve("/browse"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected low-priority traffic to be shed, got %d", rr.Code)
+	}
+
+	if rr := serve("/health"); rr.Code != http.StatusOK || rr.Header().Get("X-Incident-ID") != "" {
+		t.Errorf("Expected health checks to be left alone, got %d", rr.Code)
+	}
+}
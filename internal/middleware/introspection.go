@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/introspection"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// OAuth2 token introspection middleware
+type IntrospectionMiddleware struct {
+	cfg    config.IntrospectionConfig
+	client *introspection.Client
+	err    error
+}
+
+func NewIntrospection(cfg config.IntrospectionConfig) *IntrospectionMiddleware {
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = 60
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5
+	}
+	if cfg.ActiveHeader == "" {
+		cfg.ActiveHeader = "X-Token-Active"
+	}
+	if cfg.ScopeHeader == "" {
+		cfg.ScopeHeader = "X-Token-Scope"
+	}
+	if cfg.SubjectHeader == "" {
+		cfg.SubjectHeader = "X-Auth-Subject"
+	}
+
+	m := &IntrospectionMiddleware{cfg: cfg}
+
+	if cfg.Endpoint == "" {
+		m.err = errors.New("introspection requires an endpoint")
+		logger.Error("Introspection middleware misconfigured, rejecting all requests: %v", m.err)
+		return m
+	}
+
+	m.client = introspection.NewClient(cfg.Endpoint, cfg.ClientID, cfg.ClientSecret,
+		time.Duration(cfg.CacheTTL)*time.Second, time.Duration(cfg.Timeout)*time.Second)
+	logger.Info("Bearer tokens introspected at %s", cfg.Endpoint)
+	return m
+}
+
+func (m *IntrospectionMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Token headers are only ever set by the gateway
+		r.Header.Del(m.cfg.ActiveHeader)
+		r.Header.Del(m.cfg.ScopeHeader)
+		r.Header.Del(m.cfg.SubjectHeader)
+
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" || hasAnyPrefix(r.URL.Path, m.cfg.SkipPaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		token := bearerToken(r)
+		if token == "" {
+			tracing.RecordDecision(r.Context(), "introspection", tracing.Denied, "no bearer token")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gatekeeper"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		result, err := m.client.Introspect(r.Context(), token)
+		if err != nil {
+			logger.Warn("Token introspection failed: %v", err)
+			tracing.RecordDecision(r.Context(), "introspection", tracing.Denied, "introspection endpoint unavailable")
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		if !result.Active {
+			tracing.RecordDecision(r.Context(), "introspection", tracing.Denied, "token inactive")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gatekeeper", error="invalid_token"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !result.HasScopes(m.cfg.RequiredScopes) {
+			tracing.RecordDecision(r.Context(), "introspection", tracing.Denied, "insufficient scope",
+				attribute.String("token.scope", result.Scope))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gatekeeper", error="insufficient_scope", scope="`+
+				strings.Join(m.cfg.RequiredScopes, " ")+`"`)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		r.Header.Set(m.cfg.ActiveHeader, strconv.FormatBool(result.Active))
+		if result.Scope != "" {
+			r.Header.Set(m.cfg.ScopeHeader, result.Scope)
+		}
+		if result.Subject != "" {
+			r.Header.Set(m.cfg.SubjectHeader, result.Subject)
+		}
+
+		tracing.RecordDecision(r.Context(), "introspection", tracing.Allowed, "token active")
+		principal := result.Subject
+		if principal == "" {
+			principal = result.ClientID
+		}
+		next.ServeHTTP(w, withIdentity(r, "token:"+principal))
+	})
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
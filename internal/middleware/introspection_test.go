@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestIntrospectionMiddleware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("token") == "good" {
+			fmt.Fprint(w, `{"active":true,"scope":"orders:read","sub":"alice"}`)
+			return
+		}
+		fmt.Fprint(w, `{"active":false}`)
+	}))
+	defer server.Close()
+
+	var backendHeaders http.Header
+	handler := NewIntrospection(config.IntrospectionConfig{
+		Endpoint:       server.URL,
+		RequiredScopes: []string{"orders:read"},
+	}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendHeaders = r.Header.Clone()
+	}))
+
+	testCases := []struct {
+		name           string
+		authorization  string
+		expectedStatus int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"inactive token", "Bearer revoked", http.StatusUnauthorized},
+		{"active token", "Bearer good", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/orders", nil)
+			req.Header.Set("X-Auth-Subject", "spoofed")
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status %v, got %v", tc.expectedStatus, rr.Code)
+			}
+		})
+	}
+
+	if backendHeaders.Get("X-Auth-Subject") != "alice" {
+		t.Errorf("Expected subject header alice, got %q", backendHeaders.Get("X-Auth-Subject"))
+	}
+	if backendHeaders.Get("X-Token-Active") != "true" || backendHeaders.Get("X-Token-Scope") != "orders:read" {
+		t.Errorf("Expected active and scope headers, got %v", backendHeaders)
+	}
+}
+
+func TestIntrospectionMiddlewareRequiresScopes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"active":true,"scope":"orders:read","sub":"alice"}`)
+	}))
+	defer server.Close()
+
+	handler := NewIntrospection(config.IntrospectionConfig{
+		Endpoint:       server.URL,
+		RequiredScopes: []string{"orders:write"},
+	}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("POST", "/orders", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for missing scope, got %v", rr.Code)
+	}
+}
+
+func TestIntrospectionMiddlewareFailsClosedWhenEndpointDown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	handler := NewIntrospection(config.IntrospectionConfig{Endpoint: server.URL}).
+		Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 when introspection endpoint is down, got %v", rr.Code)
+	}
+}
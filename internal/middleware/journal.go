@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/barisgenc/gatekeeper/internal/journal"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// Journal middleware
+type JournalMiddleware struct {
+	journal *journal.Journal
+}
+
+func NewJournal(j *journal.Journal) *JournalMiddleware {
+	return &JournalMiddleware{journal: j}
+}
+
+func (m *JournalMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Clients pick their own X-Request-IDs and may reuse them, so the
+		// journal keys entries by an ID of its own
+		id := randomToken()
+		requestID := ensureRequestID(r)
+
+		err := m.journal.Start(journal.Entry{
+			ID:             id,
+			RequestID:      requestID,
+			Method:         r.Method,
+			Path:           r.URL.RequestURI(),
+			ClientIP:       getClientIP(r),
+			IdempotencyKey: r.Header.Get("Idempotency-Key"),
+		})
+		if err != nil {
+			// Refuse rather than proxy a request we could not account for
+			logger.Error("Failed to journal request %s: %v", requestID, err)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		rw := metrics.NewResponseWriter(w)
+		defer func() {
+			if err := m.journal.Finish(id, rw.Status()); err != nil {
+				logger.Error("Failed to journal completion of request %s: %v", requestID, err)
+			}
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/journal"
+)
+
+func TestJournalMiddleware(t *testing.T) {
+	j, _, err := journal.Open(filepath.Join(t.TempDir(), "requests.journal"), false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	middleware := NewJournal(j)
+
+	var inflight []journal.Entry
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inflight = j.Inflight()
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	req := httptest.NewRequest("POST", "/payments", nil)
+	req.Header.Set("Idempotency-Key", "pay-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(inflight) != 1 || inflight[0].IdempotencyKey != "pay-1" {
+		t.Fatalf("Expected request to be journaled while in flight, got %+v", inflight)
+	}
+
+	if req.Header.Get("X-Request-ID") != inflight[0].RequestID {
+		t.Errorf("Expected X-Request-ID to match journaled request ID %v, got %v", inflight[0].RequestID, req.Header.Get("X-Request-ID"))
+	}
+
+	if remaining := j.Inflight(); len(remaining) != 0 {
+		t.Errorf("Expected no in-flight requests after completion, got %d", len(remaining))
+	}
+}
+
+func TestJournalMiddlewareReusedRequestID(t *testing.T) {
+	j, _, err := journal.Open(filepath.Join(t.TempDir(), "requests.journal"), false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	// A second request reusing the first one's X-Request-ID while it is in
+	// flight must not hide it
+	middleware := NewJournal(j)
+	var inflight []journal.Entry
+	inner := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inflight = j.Inflight()
+	}))
+	outer := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := httptest.NewRequest("POST", "/payments", nil)
+		req.Header.Set("X-Request-ID", "client-id")
+		inner.ServeHTTP(httptest.NewRecorder(), req)
+	}))
+
+	req := httptest.NewRequest("POST", "/payments", nil)
+	req.Header.Set("X-Request-ID", "client-id")
+	outer.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(inflight) != 2 || inflight[0].ID == inflight[1].ID || inflight[1].RequestID != "client-id" {
+		t.Errorf("Expected both requests journaled under their own IDs, got %+v", inflight)
+	}
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/ldapauth"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// LDAP Basic authentication middleware
+type LDAPMiddleware struct {
+	cfg       config.LDAPConfig
+	directory *ldapauth.Directory
+	roles     map[string]string
+	err       error
+}
+
+func NewLDAP(cfg config.LDAPConfig) *LDAPMiddleware {
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(uid=%s)"
+	}
+	if cfg.UsernameAttribute == "" {
+		cfg.UsernameAttribute = filterAttribute(cfg.UserFilter)
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "memberOf"
+	}
+	if cfg.Realm == "" {
+		cfg.Realm = "gatekeeper"
+	}
+	if cfg.IdentityHeader == "" {
+		cfg.IdentityHeader = "X-Auth-Subject"
+	}
+	if cfg.RolesHeader == "" {
+		cfg.RolesHeader = "X-Auth-Roles"
+	}
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = 4
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = 60
+	}
+
+	m := &LDAPMiddleware{cfg: cfg, roles: make(map[string]string, len(cfg.Roles))}
+
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		m.err = errors.New("ldap requires a url and a baseDN")
+		logger.Error("LDAP middleware misconfigured, rejecting all requests: %v", m.err)
+		return m
+	}
+
+	// Groups are matched by DN or common name, in any case
+	for group, role := range cfg.Roles {
+		m.roles[strings.ToLower(group)] = role
+	}
+	m.directory = ldapauth.New(ldapauth.Options{
+		URL:               cfg.URL,
+		StartTLS:          cfg.StartTLS,
+		BindDN:            cfg.BindDN,
+		BindPassword:      cfg.BindPassword,
+		BaseDN:            cfg.BaseDN,
+		UserFilter:        cfg.UserFilter,
+		UsernameAttribute: cfg.UsernameAttribute,
+		GroupAttribute:    cfg.GroupAttribute,
+		PoolSize:          cfg.PoolSize,
+		Timeout:           time.Duration(cfg.Timeout) * time.Second,
+		CacheTTL:          time.Duration(cfg.CacheTTL) * time.Second,
+	})
+	logger.Info("LDAP authentication against %s under %s", cfg.URL, cfg.BaseDN)
+	return m
+}
+
+func (m *LDAPMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Identity headers are only ever set by the gateway
+		r.Header.Del(m.cfg.IdentityHeader)
+		r.Header.Del(m.cfg.RolesHeader)
+
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" || hasAnyPrefix(r.URL.Path, m.cfg.SkipPaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			tracing.RecordDecision(r.Context(), "ldap", tracing.Denied, "no basic credentials")
+			m.challenge(w)
+			return
+		}
+
+		user, err := m.directory.Authenticate(username, password)
+		if errors.Is(err, ldapauth.ErrInvalidCredentials) {
+			tracing.RecordDecision(r.Context(), "ldap", tracing.Denied, "invalid credentials")
+			m.challenge(w)
+			return
+		}
+		if err != nil {
+			logger.Warn("LDAP authentication failed: %v", err)
+			tracing.RecordDecision(r.Context(), "ldap", tracing.Denied, "directory unavailable")
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		// Backends must not see the user's password
+		r.Header.Del("Authorization")
+		// The directory's spelling: "Alice" and "alice " bind as the same
+		// entry and must reach backends as the same identity
+		r.Header.Set(m.cfg.IdentityHeader, user.Name)
+		if roles := m.rolesOf(user.Groups); len(roles) > 0 {
+			r.Header.Set(m.cfg.RolesHeader, strings.Join(roles, ","))
+		}
+
+		tracing.RecordDecision(r.Context(), "ldap", tracing.Allowed, "directory bind succeeded")
+		next.ServeHTTP(w, withIdentity(r, "ldap:"+user.Name))
+	})
+}
+
+// filterAttribute is the attribute a filter such as "(uid=%s)" matches the
+// username on, or "" when it is not that simple
+func filterAttribute(filter string) string {
+	inner, ok := strings.CutPrefix(filter, "(")
+	if !ok {
+		return ""
+	}
+	attribute, ok := strings.CutSuffix(inner, "=%s)")
+	if !ok || attribute == "" || strings.ContainsAny(attribute, "()=&|!*") {
+		return ""
+	}
+	return attribute
+}
+
+func (m *LDAPMiddleware) challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+m.cfg.Realm+`", charset="UTF-8"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// rolesOf maps groups to their roles, sorted and without duplicates
+func (m *LDAPMiddleware) rolesOf(groups []string) []string {
+	seen := make(map[string]bool)
+	var roles []string
+	for _, group := range groups {
+		role, ok := m.roles[strings.ToLower(group)]
+		if !ok {
+			role, ok = m.roles[strings.ToLower(ldapauth.CommonName(group))]
+		}
+		if ok && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
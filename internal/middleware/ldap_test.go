@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestLDAPMiddleware(t *testing.T) {
+	var reached bool
+	handler := NewLDAP(config.LDAPConfig{
+		URL:       "ldap://127.0.0.1:1",
+		BaseDN:    "dc=example,dc=com",
+		SkipPaths: []string{"/public"},
+	}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	testCases := []struct {
+		name     string
+		path     string
+		user     string
+		wantCode int
+	}{
+		{"no credentials", "/api", "", http.StatusUnauthorized},
+		{"directory unreachable", "/api", "alice", http.StatusServiceUnavailable},
+		{"skipped path", "/public/logo.png", "", http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reached = false
+			req := httptest.NewRequest("GET", tc.path, nil)
+			req.Header.Set("X-Auth-Roles", "admin")
+			if tc.user != "" {
+				req.SetBasicAuth(tc.user, "wonderland")
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantCode {
+				t.Fatalf("Expected %d, got %d", tc.wantCode, rr.Code)
+			}
+			if reached != (tc.wantCode == http.StatusOK) {
+				t.Errorf("Expected the backend to be reached only on success, reached=%v", reached)
+			}
+			if tc.wantCode == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected a Basic challenge")
+			}
+			if req.Header.Get("X-Auth-Roles") != "" {
+				t.Error("Expected client-supplied roles to be dropped")
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	NewLDAP(config.LDAPConfig{}).Wrap(http.NotFoundHandler()).ServeHTTP(rr, httptest.NewRequest("GET", "/api", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 without a directory, got %d", rr.Code)
+	}
+}
+
+func TestLDAPRoles(t *testing.T) {
+	m := NewLDAP(config.LDAPConfig{
+		URL:    "ldap://127.0.0.1:1",
+		BaseDN: "dc=example,dc=com",
+		Roles: map[string]string{
+			"CN=API Admins,OU=Groups,DC=example,DC=com": "admin",
+			"developers": "developer",
+			"staff":      "developer",
+		},
+	})
+
+	roles := m.rolesOf([]string{
+		"cn=api admins,ou=groups,dc=example,dc=com",
+		"CN=Developers,OU=Groups,DC=example,DC=com",
+		"cn=staff,ou=groups,dc=example,dc=com",
+		"cn=unmapped,ou=groups,dc=example,dc=com",
+	})
+	if want := []string{"admin", "developer"}; !reflect.DeepEqual(roles, want) {
+		t.Errorf("Expected %v, got %v", want, roles)
+	}
+}
+
+func TestFilterAttribute(t *testing.T) {
+	testCases := map[string]string{
+		"(uid=%s)":                        "uid",
+		"(sAMAccountName=%s)":             "sAMAccountName",
+		"(&(objectClass=person)(uid=%s))": "",
+		"(|(mail=%s)(uid=%s))":            "",
+		"uid=%s":                          "",
+	}
+	for filter, want := range testCases {
+		if got := filterAttribute(filter); got != want {
+			t.Errorf("filterAttribute(%q) = %q, want %q", filter, got, want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"encoding/json"
+	"html"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+const defaultMaintenanceMessage = "We are down for maintenance and will be back shortly."
+
+// MaintenanceState is the live maintenance mode, switched through the admin
+// API. Without Routes it covers every route.
+type MaintenanceState struct {
+	Active  bool     `json:"active"`
+	Routes  []string `json:"routes"`
+	Message string   `json:"message"`
+}
+
+// Maintenance mode middleware
+type MaintenanceMiddleware struct {
+	cfg       config.MaintenanceConfig
+	route     func(*http.Request) string
+	allowlist []*net.IPNet
+	err       error
+
+	mu    sync.RWMutex
+	state MaintenanceState
+}
+
+// NewMaintenance creates the maintenance middleware. route names the route
+// a request will match.
+func NewMaintenance(cfg config.MaintenanceConfig, route func(*http.Request) string) *MaintenanceMiddleware {
+	if cfg.Message == "" {
+		cfg.Message = defaultMaintenanceMessage
+	}
+	m := &MaintenanceMiddleware{
+		cfg:   cfg,
+		route: route,
+		state: MaintenanceState{Active: cfg.Active, Routes: cfg.Routes, Message: cfg.Message},
+	}
+	m.allowlist, m.err = parseCIDRs(cfg.AllowIPs)
+	if m.err != nil {
+		logger.Error("Maintenance mode misconfigured, rejecting all requests: %v", m.err)
+	}
+	return m
+}
+
+// State returns the current maintenance mode
+func (m *MaintenanceMiddleware) State() MaintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// Set switches maintenance mode. An empty message keeps the current one.
+func (m *MaintenanceMiddleware) Set(state MaintenanceState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if state.Message == "" {
+		state.Message = m.state.Message
+	}
+	m.state = state
+}
+
+func (m *MaintenanceMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		state := m.State()
+		if !state.Active {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := m.route(r)
+		if len(state.Routes) > 0 && !slices.Contains(state.Routes, route) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if containsIP(m.allowlist, ConnectingIP(r, m.cfg.UseForwardedFor)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tracing.RecordDecision(r.Context(), "maintenance", tracing.Denied, "maintenance mode")
+		metrics.RecordMaintenanceRejected(route)
+		m.reject(w, r, state.Message)
+	})
+}
+
+// reject answers browsers with the maintenance page and other clients with
+// JSON
+func (m *MaintenanceMiddleware) reject(w http.ResponseWriter, r *http.Request, message string) {
+	w.Header().Set("Cache-Control", "no-store")
+	if m.cfg.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(m.cfg.RetryAfter))
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		page := m.cfg.HTML
+		if page == "" {
+			page = "<!doctype html>\n<title>Maintenance</title>\n<p>" + html.EscapeString(message) + "</p>\n"
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(page))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]string{"error": "maintenance", "message": message})
+}
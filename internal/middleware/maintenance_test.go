@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestMaintenance(t *testing.T) {
+	routes := func(r *http.Request) string {
+		if strings.HasPrefix(r.URL.Path, "/checkout") {
+			return "checkout"
+		}
+		return "default"
+	}
+	m := NewMaintenance(config.MaintenanceConfig{
+		Enabled:    true,
+		Active:     true,
+		Routes:     []string{"checkout"},
+		AllowIPs:   []string{"10.0.0.0/8"},
+		Message:    "Back at <14:00>",
+		RetryAfter: 600,
+	}, routes)
+	handler := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path, remoteAddr, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("/checkout/cart", "203.0.113.7:1234", "application/json")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "600" {
+		t.Fatalf("Expected 503 with Retry-After, got %d %v", rr.Code, rr.Header())
+	}
+	if !strings.Contains(rr.Body.String(), `"error":"maintenance"`) || !strings.Contains(rr.Header().Get("Content-Type"), "json") {
+		t.Errorf("Expected a JSON body, got %q", rr.Body.String())
+	}
+
+	rr = serve("/checkout/cart", "203.0.113.7:1234", "text/html,*/*")
+	if !strings.Contains(rr.Body.String(), "Back at &lt;14:00&gt;") {
+		t.Errorf("Expected an HTML page with the escaped message, got %q", rr.Body.String())
+	}
+
+	if rr := serve("/checkout/cart", "10.1.2.3:1234", ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected allowlisted clients through, got %d", rr.Code)
+	}
+	if rr := serve("/products", "203.0.113.7:1234", ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected other routes through, got %d", rr.Code)
+	}
+	if rr := serve("/health", "203.0.113.7:1234", ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected /health through, got %d", rr.Code)
+	}
+
+	// Without routes, maintenance covers everything
+	m.Set(MaintenanceState{Active: true})
+	if rr := serve("/products", "203.0.113.7:1234", ""); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected every route in maintenance, got %d", rr.Code)
+	}
+	if state := m.State(); state.Message != "Back at <14:00>" {
+		t.Errorf("Expected the message to be kept, got %q", state.Message)
+	}
+
+	m.Set(MaintenanceState{})
+	if rr := serve("/checkout/cart", "203.0.113.7:1234", ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected requests through once maintenance ends, got %d", rr.Code)
+	}
+}
@@ -1,12 +1,22 @@
 package middleware
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
-	"golang.org/x/time/rate"
+	"go.opentelemetry.io/otel/attribute"
 
+	"github.com/barisgenc/gatekeeper/internal/accesslog"
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/incident"
 	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
 	"github.com/barisgenc/gatekeeper/internal/metrics"
 )
 
@@ -14,33 +24,52 @@ type Middleware interface {
 	Wrap(http.Handler) http.Handler
 }
 
-// Logging middleware
-type LoggingMiddleware struct{}
+// Logging middleware writes the access log
+type LoggingMiddleware struct {
+	log *accesslog.Logger
+}
 
+// NewLogging logs JSON lines to stdout
 func NewLogging() *LoggingMiddleware {
-	return &LoggingMiddleware{}
+	return NewAccessLog(accesslog.NewWriter(config.AccessLogConfig{}, nil, os.Stdout))
+}
+
+// NewAccessLog writes requests to log
+func NewAccessLog(log *accesslog.Logger) *LoggingMiddleware {
+	return &LoggingMiddleware{log: log}
 }
 
 func (m *LoggingMiddleware) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+		entry := &accesslog.Entry{Time: time.Now()}
 		
 		// Create response writer to capture status
 		rw := metrics.NewResponseWriter(w)
 		
 		// Call next handler
-		next.ServeHTTP(rw, r)
+		next.ServeHTTP(rw, r.WithContext(accesslog.NewContext(r.Context(), entry)))
 		
-		duration := time.Since(start)
-		
-		logger.WithFields(map[string]interface{}{
-			"method":     r.Method,
-			"path":       r.URL.Path,
-			"status":     rw.StatusCode(),
-			"duration":   duration.String(),
-			"remote_ip":  getClientIP(r),
-			"user_agent": r.UserAgent(),
-		}).Info("HTTP Request")
+		entry.Duration = time.Since(entry.Time)
+		entry.Method = r.Method
+		entry.Path = r.URL.Path
+		entry.Query = r.URL.RawQuery
+		entry.Protocol = r.Proto
+		entry.Host = r.Host
+		entry.Status = rw.Status()
+		entry.Bytes = rw.BytesWritten()
+		entry.RemoteIP = getClientIP(r)
+		entry.UserAgent = r.UserAgent()
+		entry.Referer = r.Referer()
+		entry.RequestID = r.Header.Get("X-Request-ID")
+		entry.Header = r.Header
+		if class, ok := ClassFromContext(r.Context()); ok {
+			entry.Class = class.Name
+		}
+		if inc, ok := incident.FromContext(r.Context()); ok {
+			entry.Incident = inc.ID
+		}
+
+		m.log.Log(entry)
 	})
 }
 
@@ -69,20 +98,31 @@ func (m *MetricsMiddleware) Wrap(next http.Handler) http.Handler {
 	})
 }
 
-// Rate limiting middleware
+// Rate limiting middleware. Each request takes its cost in tokens from
+// one bucket shared by all clients.
 type RateLimitMiddleware struct {
-	limiter *rate.Limiter
+	limiter    tokenBucket
+	reason     string
+	retryAfter string
+	tarpit     *Tarpit
+}
+
+// SetTarpit holds rejected requests in t before answering them
+func (m *RateLimitMiddleware) SetTarpit(t *Tarpit) {
+	m.tarpit = t
 }
 
 func NewRateLimiter(requestsPerMinute, burstSize int) *RateLimitMiddleware {
 	// Convert requests per minute to requests per second
 	rps := float64(requestsPerMinute) / 60.0
-	limiter := rate.NewLimiter(rate.Limit(rps), burstSize)
+	limiter := newTokenBucket(float64(requestsPerMinute), burstSize)
 	
 	logger.Info("Rate limiter initialized: %.2f req/sec, burst: %d", rps, burstSize)
 	
 	return &RateLimitMiddleware{
-		limiter: limiter,
+		limiter:    limiter,
+		reason:     "global limit exceeded",
+		retryAfter: "60",
 	}
 }
 
@@ -94,63 +134,157 @@ func (m *RateLimitMiddleware) Wrap(next http.Handler) http.Handler {
 			return
 		}
 
-		if !m.limiter.Allow() {
+		cost := requestCost(r)
+		allowed, remaining := m.limiter.take(cost)
+		if !allowed {
+			tracing.RecordDecision(r.Context(), "rate_limit", tracing.Denied, m.reason,
+				attribute.Float64("ratelimit.remaining", remaining),
+				attribute.Int("ratelimit.cost", cost))
 			logger.Warn("Rate limit exceeded for %s %s from %s", 
 				r.Method, r.URL.Path, getClientIP(r))
 			
 			metrics.RecordRateLimit()
+			if !m.tarpit.Hold(r) {
+				return
+			}
 			
-			w.Header().Set("Retry-After", "60")
+			w.Header().Set("Retry-After", m.retryAfter)
 			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 			return
 		}
-		
+
+		tracing.RecordDecision(r.Context(), "rate_limit", tracing.Allowed, "",
+			attribute.Float64("ratelimit.remaining", remaining),
+			attribute.Int("ratelimit.cost", cost))
 		next.ServeHTTP(w, r)
 	})
 }
 
 // CORS middleware
 type CORSMiddleware struct {
-	allowedOrigins []string
-	allowedMethods []string
-	allowedHeaders []string
+	allowedOrigins   []string
+	allowedMethods   []string
+	allowedHeaders   []string
+	exposedHeaders   []string
+	allowCredentials bool
+	maxAge           int
 }
 
 func NewCORS(origins, methods, headers []string) *CORSMiddleware {
+	return NewCORSFromConfig(config.CORSConfig{
+		AllowedOrigins: origins,
+		AllowedMethods: methods,
+		AllowedHeaders: headers,
+	})
+}
+
+// NewCORSFromConfig builds a CORS policy from a global or route cors block
+func NewCORSFromConfig(cfg config.CORSConfig) *CORSMiddleware {
 	return &CORSMiddleware{
-		allowedOrigins: origins,
-		allowedMethods: methods,
-		allowedHeaders: headers,
+		allowedOrigins:   cfg.AllowedOrigins,
+		allowedMethods:   cfg.AllowedMethods,
+		allowedHeaders:   cfg.AllowedHeaders,
+		exposedHeaders:   cfg.ExposedHeaders,
+		allowCredentials: cfg.AllowCredentials,
+		maxAge:           cfg.MaxAge,
 	}
 }
 
 func (m *CORSMiddleware) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-		
-		// Set CORS headers
-		if len(m.allowedOrigins) > 0 && contains(m.allowedOrigins, origin) {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-		} else if len(m.allowedOrigins) > 0 && contains(m.allowedOrigins, "*") {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
+		m.Handle(w, r, next)
+	})
+}
+
+// Handle applies the policy to one request, answering preflight requests
+// itself and passing the rest to next. Requests without an Origin are not
+// cross-origin and pass untouched.
+func (m *CORSMiddleware) Handle(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	h := w.Header()
+	preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+
+	// Responses differ by origin unless every origin gets the same answer
+	if !(len(m.allowedOrigins) == 1 && m.allowedOrigins[0] == "*" && !m.allowCredentials) {
+		h.Add("Vary", "Origin")
+	}
+	if preflight {
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	// Set CORS headers
+	if allowed := m.allowOrigin(origin); allowed != "" {
+		h.Set("Access-Control-Allow-Origin", allowed)
+		if m.allowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
 		}
-		
-		if len(m.allowedMethods) > 0 {
-			w.Header().Set("Access-Control-Allow-Methods", joinStrings(m.allowedMethods, ", "))
+	}
+
+	if len(m.allowedMethods) > 0 {
+		h.Set("Access-Control-Allow-Methods", joinStrings(m.allowedMethods, ", "))
+	}
+
+	// Without a fixed list, or with "*", whatever the browser asks for is
+	// allowed; echoing it also works for credentialed requests, which
+	// browsers refuse a literal "*" for
+	if len(m.allowedHeaders) > 0 && !contains(m.allowedHeaders, "*") {
+		h.Set("Access-Control-Allow-Headers", joinStrings(m.allowedHeaders, ", "))
+	} else if requested := r.Header.Get("Access-Control-Request-Headers"); preflight && requested != "" {
+		h.Set("Access-Control-Allow-Headers", requested)
+	}
+
+	// Handle preflight request
+	if preflight {
+		if m.maxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(m.maxAge))
 		}
-		
-		if len(m.allowedHeaders) > 0 {
-			w.Header().Set("Access-Control-Allow-Headers", joinStrings(m.allowedHeaders, ", "))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if len(m.exposedHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", joinStrings(m.exposedHeaders, ", "))
+	}
+
+	next.ServeHTTP(w, r)
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" if it is not allowed. Credentialed responses always name the origin.
+func (m *CORSMiddleware) allowOrigin(origin string) string {
+	if len(m.allowedOrigins) == 0 {
+		return ""
+	}
+	if origin != "" && (contains(m.allowedOrigins, origin) || matchesWildcardOrigin(m.allowedOrigins, origin)) {
+		return origin
+	}
+	if contains(m.allowedOrigins, "*") {
+		return "*"
+	}
+	return ""
+}
+
+// matchesWildcardOrigin reports whether origin is a subdomain allowed by a
+// pattern such as https://*.example.com. The apex domain itself must be
+// listed separately.
+func matchesWildcardOrigin(patterns []string, origin string) bool {
+	for _, pattern := range patterns {
+		scheme, suffix, ok := strings.Cut(pattern, "://*.")
+		if !ok || !strings.HasPrefix(origin, scheme+"://") {
+			continue
 		}
-		
-		// Handle preflight request
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+		host := strings.TrimPrefix(origin, scheme+"://")
+		if strings.HasSuffix(host, "."+suffix) && len(host) > len(suffix)+1 {
+			return true
 		}
-		
-		next.ServeHTTP(w, r)
-	})
+	}
+	return false
 }
 
 // Helper functions
@@ -169,6 +303,42 @@ func getClientIP(r *http.Request) string {
 	return r.RemoteAddr
 }
 
+// ConnectingIP is the address of the connecting client, or with
+// useForwardedFor the last X-Forwarded-For hop, which the load balancer in
+// front of the gateway appended and the client cannot forge
+func ConnectingIP(r *http.Request, useForwardedFor bool) string {
+	if useForwardedFor {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			hops := strings.Split(xff, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ensureRequestID returns the request's X-Request-ID, generating one if the
+// client did not send it so backends and the gateway share the same ID
+func ensureRequestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+
+	id := randomToken()
+	r.Header.Set("X-Request-ID", id)
+	return id
+}
+
+// randomToken returns 128 random bits, hex encoded
+func randomToken() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
 		if s == item {
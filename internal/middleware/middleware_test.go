@@ -163,12 +163,14 @@ func TestCORSPreflightRequest(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
 
 	rr := httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("Preflight request should return OK: got %v want %v", status, http.StatusOK)
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("Preflight request should return No Content: got %v want %v", status, http.StatusNoContent)
 	}
 
 	if origin := rr.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+var (
+	errPathTraversal = errors.New("path climbs above the root")
+	errEncodedSlash  = errors.New("path contains an encoded slash")
+)
+
+// encodedSlashes turns %2F and %5C into separators, as backends that
+// decode them before resolving dot segments do
+var encodedSlashes = strings.NewReplacer("%2F", "/", "%2f", "/", "%5C", "/", "%5c", "/")
+
+// Path normalization middleware
+type PathNormalizeMiddleware struct {
+	cfg      config.PathNormalizeConfig
+	prefixes []string
+}
+
+// NewPathNormalize normalizes paths and rejects requests whose ".." would
+// leave any of the given route prefixes.
+func NewPathNormalize(cfg config.PathNormalizeConfig, prefixes []string) *PathNormalizeMiddleware {
+	return &PathNormalizeMiddleware{cfg: cfg, prefixes: prefixes}
+}
+
+func (m *PathNormalizeMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		escaped := r.URL.EscapedPath()
+		// Authority-form (CONNECT) and asterisk-form (OPTIONS *) targets have no path to clean
+		if !strings.HasPrefix(escaped, "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		collapsed, normalized, err := normalizePath(escaped, m.cfg.RejectEncodedSlash)
+		if err == nil {
+			err = m.checkPrefixes(collapsed, normalized)
+		}
+		if err == nil && !m.cfg.RejectEncodedSlash {
+			// Encoded slashes stay encoded, but "/public/..%2Fadmin" must
+			// not reach a backend that reads it as "/public/../admin"
+			collapsed, split, splitErr := normalizePath(encodedSlashes.Replace(escaped), false)
+			if err = splitErr; err == nil {
+				err = m.checkPrefixes(collapsed, split)
+			}
+		}
+		if err != nil {
+			logger.Warn("Rejected path %q from %s: %v", escaped, getClientIP(r), err)
+			tracing.RecordDecision(r.Context(), "path_normalization", tracing.Denied, err.Error())
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		if normalized != escaped {
+			path, _ := url.PathUnescape(normalized)
+			r.URL.Path = path
+			r.URL.RawPath = ""
+			if r.URL.EscapedPath() != normalized {
+				r.URL.RawPath = normalized
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkPrefixes rejects paths that name a route prefix but resolve outside
+// it, e.g. "/public/../admin" against "/public/"
+func (m *PathNormalizeMiddleware) checkPrefixes(collapsed, normalized string) error {
+	before, _ := url.PathUnescape(collapsed)
+	after, _ := url.PathUnescape(normalized)
+	for _, prefix := range m.prefixes {
+		if strings.HasPrefix(before, prefix) && !strings.HasPrefix(after, prefix) {
+			return errors.New("path escapes route prefix " + prefix)
+		}
+	}
+	return nil
+}
+
+// normalizePath returns the escaped path with unreserved characters decoded
+// and empty segments removed (collapsed), and the same with dot segments
+// resolved (normalized). A trailing slash is kept.
+func normalizePath(escaped string, rejectEncodedSlash bool) (string, string, error) {
+	var decoded strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		c := escaped[i]
+		if c != '%' || i+2 >= len(escaped) {
+			decoded.WriteByte(c)
+			continue
+		}
+		b, ok := unhex(escaped[i+1], escaped[i+2])
+		if !ok {
+			decoded.WriteByte(c)
+			continue
+		}
+		if rejectEncodedSlash && (b == '/' || b == '\\') {
+			return "", "", errEncodedSlash
+		}
+		if isUnreserved(b) {
+			decoded.WriteByte(b)
+		} else {
+			decoded.WriteString(strings.ToUpper(escaped[i : i+3]))
+		}
+		i += 2
+	}
+
+	path := decoded.String()
+	trailing := strings.HasSuffix(path, "/")
+
+	var segments, resolved []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			continue
+		}
+		segments = append(segments, segment)
+
+		switch segment {
+		case ".":
+		case "..":
+			if len(resolved) == 0 {
+				return "", "", errPathTraversal
+			}
+			resolved = resolved[:len(resolved)-1]
+		default:
+			resolved = append(resolved, segment)
+		}
+	}
+
+	// "/a/b/.." names the directory /a/, like "/a/"
+	resolvedTrailing := trailing
+	if n := len(segments); n > 0 && (segments[n-1] == "." || segments[n-1] == "..") {
+		resolvedTrailing = true
+	}
+
+	return joinSegments(segments, trailing), joinSegments(resolved, resolvedTrailing), nil
+}
+
+func joinSegments(segments []string, trailing bool) string {
+	if len(segments) == 0 {
+		return "/"
+	}
+	joined := "/" + strings.Join(segments, "/")
+	if trailing {
+		joined += "/"
+	}
+	return joined
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func unhex(hi, lo byte) (byte, bool) {
+	h, ok1 := hexValue(hi)
+	l, ok2 := hexValue(lo)
+	return h<<4 | l, ok1 && ok2
+}
+
+func hexValue(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
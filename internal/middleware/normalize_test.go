@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestPathNormalizeMiddleware(t *testing.T) {
+	middleware := NewPathNormalize(config.PathNormalizeConfig{Enabled: true}, []string{"/public/"})
+
+	var gotPath, gotRaw string
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotRaw = r.URL.Path, r.URL.EscapedPath()
+	}))
+
+	testCases := []struct {
+		name         string
+		target       string
+		expectedCode int
+		expectedPath string
+		expectedRaw  string
+	}{
+		{"clean path untouched", "/api/users", http.StatusOK, "/api/users", "/api/users"},
+		{"double slashes collapsed", "//api///users/", http.StatusOK, "/api/users/", "/api/users/"},
+		{"dot segments resolved", "/api/./v1/../users", http.StatusOK, "/api/users", "/api/users"},
+		{"encoded dots resolved", "/api/%2e%2e/admin", http.StatusOK, "/admin", "/admin"},
+		{"unreserved decoded", "/%61pi/%7Euser", http.StatusOK, "/api/~user", "/api/~user"},
+		{"reserved kept encoded", "/files/a%2fb", http.StatusOK, "/files/a/b", "/files/a%2Fb"},
+		{"climbing above root", "/../etc/passwd", http.StatusBadRequest, "", ""},
+		{"encoded climb above root", "/%2e%2e/etc/passwd", http.StatusBadRequest, "", ""},
+		{"escaping a route prefix", "/public/../admin", http.StatusBadRequest, "", ""},
+		{"encoded slash escaping a route prefix", "/public/..%2Fadmin", http.StatusBadRequest, "", ""},
+		{"encoded backslash escaping a route prefix", "/public/..%5cadmin", http.StatusBadRequest, "", ""},
+		{"encoded slash climbing above root", "/..%2Fetc/passwd", http.StatusBadRequest, "", ""},
+		{"encoded slash inside a route prefix", "/public/css%2F..%2Fjs/app.js", http.StatusOK, "/public/css/../js/app.js", "/public/css%2F..%2Fjs/app.js"},
+		{"staying inside a route prefix", "/public/css/../js/app.js", http.StatusOK, "/public/js/app.js", "/public/js/app.js"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotPath, gotRaw = "", ""
+			req := httptest.NewRequest("GET", "http://gw.example.com"+tc.target, nil)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedCode {
+				t.Fatalf("Expected status %d, got %d", tc.expectedCode, rr.Code)
+			}
+			if gotPath != tc.expectedPath || gotRaw != tc.expectedRaw {
+				t.Errorf("Expected path %q (%q), got %q (%q)", tc.expectedPath, tc.expectedRaw, gotPath, gotRaw)
+			}
+		})
+	}
+}
+
+func TestPathNormalizeRejectEncodedSlash(t *testing.T) {
+	middleware := NewPathNormalize(config.PathNormalizeConfig{Enabled: true, RejectEncodedSlash: true}, nil)
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/files/a%2Fb", nil))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,268 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/kv"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/oidc"
+	"github.com/barisgenc/gatekeeper/internal/session"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+var (
+	errMissingRedirectPath = errors.New("oidc redirectURL must include the callback path")
+	errNonceMismatch       = errors.New("nonce does not match the login")
+)
+
+type oidcSession struct {
+	Subject     string `json:"sub"`
+	Email       string `json:"email,omitempty"`
+	Name        string `json:"name,omitempty"`
+	AccessToken string `json:"at,omitempty"`
+}
+
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	ReturnTo string `json:"return_to"`
+}
+
+// OIDC authentication middleware
+type OIDCMiddleware struct {
+	cfg          config.OIDCConfig
+	sessions     *session.Manager
+	codec        *session.Codec
+	callbackPath string
+	err          error
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func NewOIDC(cfg config.OIDCConfig) *OIDCMiddleware {
+	return NewOIDCWithSessions(cfg, config.SessionsConfig{}, nil)
+}
+
+// NewOIDCWithSessions keeps login sessions as sessions configures, in
+// storage when they are server side. When sessions is not enabled they are
+// sealed into cookies with CookieSecret and last SessionTTL.
+func NewOIDCWithSessions(cfg config.OIDCConfig, sessions config.SessionsConfig, storage kv.Store) *OIDCMiddleware {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = "gatekeeper_session"
+	}
+	if cfg.IdentityHeader == "" {
+		cfg.IdentityHeader = "X-Auth-Subject"
+	}
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = 8 * 3600
+	}
+
+	m := &OIDCMiddleware{cfg: cfg}
+
+	redirect, err := url.Parse(cfg.RedirectURL)
+	if err != nil || redirect.Path == "" {
+		m.err = err
+		if m.err == nil {
+			m.err = errMissingRedirectPath
+		}
+	} else {
+		m.callbackPath = redirect.Path
+	}
+
+	if m.err == nil {
+		if !sessions.Enabled {
+			secure := redirect.Scheme == "https"
+			sessions = config.SessionsConfig{
+				CookieName: cfg.CookieName,
+				Secret:     cfg.CookieSecret,
+				TTL:        cfg.SessionTTL,
+				Secure:     &secure,
+			}
+			storage = kv.NewMemoryStore()
+		}
+		m.sessions, m.err = NewSessions(sessions, storage)
+	}
+	if m.err == nil {
+		m.codec = m.sessions.Codec("oidc_state")
+	}
+
+	// Fail closed: a broken auth config must not let traffic through
+	if m.err != nil {
+		logger.Error("OIDC middleware misconfigured, rejecting all requests: %v", m.err)
+	}
+
+	return m
+}
+
+func (m *OIDCMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Identity headers are only ever set by the gateway
+		r.Header.Del(m.cfg.IdentityHeader)
+		r.Header.Del("X-Auth-Email")
+
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" || hasAnyPrefix(r.URL.Path, m.cfg.SkipPaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Path == m.callbackPath {
+			m.handleCallback(w, r)
+			return
+		}
+
+		var sess oidcSession
+		found, err := m.sessions.Load(r, &sess)
+		if err != nil {
+			logger.Error("Loading OIDC session failed: %v", err)
+			tracing.RecordDecision(r.Context(), "oidc", tracing.Denied, "session store unavailable")
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if found != nil {
+			r.Header.Set(m.cfg.IdentityHeader, sess.Subject)
+			if sess.Email != "" {
+				r.Header.Set("X-Auth-Email", sess.Email)
+			}
+			if m.cfg.ForwardAccessToken && sess.AccessToken != "" {
+				r.Header.Set("Authorization", "Bearer "+sess.AccessToken)
+			}
+			tracing.RecordDecision(r.Context(), "oidc", tracing.Allowed, "valid session")
+			next.ServeHTTP(w, withIdentity(r, found.Subject))
+			return
+		}
+
+		tracing.RecordDecision(r.Context(), "oidc", tracing.Denied, "no valid session")
+
+		// API clients get a plain 401; only browsers are sent to the IdP
+		if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		m.startLogin(w, r)
+	})
+}
+
+func (m *OIDCMiddleware) startLogin(w http.ResponseWriter, r *http.Request) {
+	provider, err := m.getProvider(r.Context())
+	if err != nil {
+		logger.Error("OIDC provider discovery failed: %v", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
+	state := oidcState{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		ReturnTo: r.URL.RequestURI(),
+	}
+	encoded, err := m.codec.Encode(state, oidcStateTTL)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	m.sessions.SetStateCookie(w, m.cfg.CookieName+"_state", encoded, oidcStateTTL)
+	authURL := provider.AuthCodeURL(m.cfg.ClientID, m.cfg.RedirectURL, state.State, state.Nonce, m.cfg.Scopes)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (m *OIDCMiddleware) handleCallback(w http.ResponseWriter, r *http.Request) {
+	stateCookie, err := r.Cookie(m.cfg.CookieName + "_state")
+	if err != nil {
+		http.Error(w, "Missing login state", http.StatusBadRequest)
+		return
+	}
+	var state oidcState
+	if err := m.codec.Decode(stateCookie.Value, &state); err != nil || state.State != r.URL.Query().Get("state") {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+	m.sessions.SetStateCookie(w, m.cfg.CookieName+"_state", "", -time.Second)
+
+	if idpErr := r.URL.Query().Get("error"); idpErr != "" {
+		logger.Warn("OIDC login failed at identity provider: %s", idpErr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	provider, err := m.getProvider(r.Context())
+	if err != nil {
+		logger.Error("OIDC provider discovery failed: %v", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
+	token, err := provider.Exchange(r.Context(), m.cfg.ClientID, m.cfg.ClientSecret, m.cfg.RedirectURL, r.URL.Query().Get("code"))
+	if err != nil {
+		logger.Warn("OIDC code exchange failed: %v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := provider.VerifyIDToken(r.Context(), token.IDToken, m.cfg.ClientID)
+	if err == nil && claims.String("nonce") != state.Nonce {
+		err = errNonceMismatch
+	}
+	if err != nil {
+		logger.Warn("OIDC ID token rejected: %v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sess := oidcSession{
+		Subject: claims.String("sub"),
+		Email:   claims.String("email"),
+		Name:    claims.String("name"),
+	}
+	if m.cfg.ForwardAccessToken {
+		sess.AccessToken = token.AccessToken
+	}
+
+	if err := m.sessions.Start(r.Context(), w, "oidc:"+sess.Subject, sess); err != nil {
+		logger.Error("Starting OIDC session failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Only redirect back to a local path to avoid an open redirect
+	returnTo := state.ReturnTo
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+		returnTo = "/"
+	}
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+func (m *OIDCMiddleware) getProvider(ctx context.Context) (*oidc.Provider, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.provider != nil {
+		return m.provider, nil
+	}
+
+	provider, err := oidc.Discover(ctx, m.cfg.IssuerURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	m.provider = provider
+	return provider, nil
+}
@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/jwt"
+	"github.com/barisgenc/gatekeeper/internal/kv"
+)
+
+// newTestIdP serves discovery, JWKS and a token endpoint that issues an ID
+// token carrying the nonce from the last authorization request.
+func newTestIdP(t *testing.T) (*httptest.Server, *string) {
+	t.Helper()
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	nonce := new(string)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "gatekeeper" || pass != "s3cret" || r.FormValue("code") != "good-code" {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		idToken, _ := jwt.Sign(jwt.Claims{
+			"iss":   server.URL,
+			"aud":   "gatekeeper",
+			"sub":   "user-42",
+			"email": "user@example.com",
+			"nonce": *nonce,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}, "RS256", "k1", key)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access-123",
+			"id_token":     idToken,
+			"expires_in":   3600,
+		})
+	})
+
+	return server, nonce
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	idp, nonce := newTestIdP(t)
+
+	middleware := NewOIDC(config.OIDCConfig{
+		Enabled:            true,
+		IssuerURL:          idp.URL,
+		ClientID:           "gatekeeper",
+		ClientSecret:       "s3cret",
+		RedirectURL:        "https://gw.example.com/oauth2/callback",
+		CookieSecret:       "a-long-enough-cookie-secret",
+		ForwardAccessToken: true,
+	})
+
+	var backendHeaders http.Header
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendHeaders = r.Header.Clone()
+	}))
+
+	// 1. Unauthenticated browser request is sent to the IdP
+	req := httptest.NewRequest("GET", "/dashboard?tab=1", nil)
+	req.Header.Set("Accept", "text/html")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected redirect to IdP, got %v", rr.Code)
+	}
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	if !strings.HasPrefix(location.String(), idp.URL+"/authorize") {
+		t.Fatalf("Expected redirect to authorize endpoint, got %v", location)
+	}
+	*nonce = location.Query().Get("nonce")
+	stateCookie := rr.Result().Cookies()[0]
+
+	// 2. The IdP sends the browser back to the callback
+	req = httptest.NewRequest("GET", "/oauth2/callback?code=good-code&state="+location.Query().Get("state"), nil)
+	req.AddCookie(stateCookie)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/dashboard?tab=1" {
+		t.Fatalf("Expected redirect back to original page, got %v %v", rr.Code, rr.Header().Get("Location"))
+	}
+	var sessionCookie *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "gatekeeper_session" {
+			sessionCookie = c
+		}
+	}
+	if sessionCookie == nil || !sessionCookie.Secure || !sessionCookie.HttpOnly {
+		t.Fatalf("Expected secure HttpOnly session cookie, got %+v", sessionCookie)
+	}
+
+	// 3. Subsequent requests are proxied with identity headers
+	req = httptest.NewRequest("GET", "/dashboard", nil)
+	req.AddCookie(sessionCookie)
+	req.Header.Set("X-Auth-Subject", "spoofed")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected authenticated request to pass, got %v", rr.Code)
+	}
+	if backendHeaders.Get("X-Auth-Subject") != "user-42" {
+		t.Errorf("Expected identity header user-42, got %v", backendHeaders.Get("X-Auth-Subject"))
+	}
+	if backendHeaders.Get("Authorization") != "Bearer access-123" {
+		t.Errorf("Expected forwarded access token, got %v", backendHeaders.Get("Authorization"))
+	}
+}
+
+func TestOIDCRejectsAPIClientsAndBadState(t *testing.T) {
+	idp, _ := newTestIdP(t)
+	middleware := NewOIDC(config.OIDCConfig{
+		IssuerURL:    idp.URL,
+		ClientID:     "gatekeeper",
+		RedirectURL:  "https://gw.example.com/oauth2/callback",
+		CookieSecret: "a-long-enough-cookie-secret",
+	})
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/items", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for API client, got %v", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/oauth2/callback?code=good-code&state=forged", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for callback without state cookie, got %v", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected health endpoint to bypass auth, got %v", rr.Code)
+	}
+}
+
+func TestOIDCRejectsStateCookieAsSession(t *testing.T) {
+	idp, _ := newTestIdP(t)
+	middleware := NewOIDC(config.OIDCConfig{
+		IssuerURL:    idp.URL,
+		ClientID:     "gatekeeper",
+		RedirectURL:  "https://gw.example.com/oauth2/callback",
+		CookieSecret: "a-long-enough-cookie-secret",
+	})
+	var proxied bool
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = true
+	}))
+
+	// Starting a login hands out a sealed state cookie
+	req := httptest.NewRequest("GET", "/dashboard", nil)
+	req.Header.Set("Accept", "text/html")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	stateCookie := rr.Result().Cookies()[0]
+
+	// Presented as the session, it must not authenticate anyone
+	req = httptest.NewRequest("GET", "/api/items", nil)
+	req.AddCookie(&http.Cookie{Name: "gatekeeper_session", Value: stateCookie.Value})
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if proxied || rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a state cookie not to pass as a session, got %d (proxied %v)", rr.Code, proxied)
+	}
+}
+
+func TestOIDCStateCookieIsLax(t *testing.T) {
+	idp, _ := newTestIdP(t)
+	middleware := NewOIDCWithSessions(config.OIDCConfig{
+		IssuerURL:   idp.URL,
+		ClientID:    "gatekeeper",
+		RedirectURL: "https://gw.example.com/oauth2/callback",
+	}, config.SessionsConfig{Enabled: true, Secret: "a-long-enough-session-secret", SameSite: "strict"}, kv.NewMemoryStore())
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// A strict state cookie would not come back from the IdP's redirect
+	req := httptest.NewRequest("GET", "/dashboard", nil)
+	req.Header.Set("Accept", "text/html")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Fatalf("Expected a SameSite=Lax state cookie, got %+v", cookies)
+	}
+}
+
+func TestOIDCMisconfigurationFailsClosed(t *testing.T) {
+	middleware := NewOIDC(config.OIDCConfig{
+		RedirectURL:  "https://gw.example.com/oauth2/callback",
+		CookieSecret: "short",
+	})
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not be called when OIDC is misconfigured")
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/app", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %v", rr.Code)
+	}
+}
@@ -0,0 +1,284 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/kv"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// quotaPrefix starts every counter key: quota:<period>:<start>:<consumer>
+const quotaPrefix = "quota:"
+
+// QuotaMiddleware caps the requests of each authenticated consumer per day
+// and month. It must run after the authentication middlewares.
+type QuotaMiddleware struct {
+	cfg   config.QuotaConfig
+	store kv.Store
+	now   func() time.Time
+	err   error
+}
+
+// QuotaPeriod is a consumer's use of one quota
+type QuotaPeriod struct {
+	Period    string    `json:"period"`
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	ResetsAt  time.Time `json:"resetsAt"`
+}
+
+// QuotaStatus is a consumer's use of its quotas, as served by
+// /admin/quotas
+type QuotaStatus struct {
+	Consumer string        `json:"consumer"`
+	Quotas   []QuotaPeriod `json:"quotas"`
+}
+
+// quotaWindow is the current day or month
+type quotaWindow struct {
+	period     string
+	limit      int64
+	start, end time.Time
+}
+
+func (w quotaWindow) key(consumer string) string {
+	return quotaPrefix + w.period + ":" + strconv.FormatInt(w.start.Unix(), 10) + ":" + consumer
+}
+
+func NewQuota(cfg config.QuotaConfig) *QuotaMiddleware {
+	return NewQuotaWithStorage(cfg, nil)
+}
+
+// NewQuotaWithStorage is NewQuota with the gateway's shared storage, which
+// holds the counters when cfg.Store is "shared"
+func NewQuotaWithStorage(cfg config.QuotaConfig, storage kv.Store) *QuotaMiddleware {
+	m := &QuotaMiddleware{cfg: cfg, now: time.Now}
+	switch cfg.Store {
+	case "", "memory":
+		m.store = kv.NewMemoryStore()
+	case "shared":
+		if storage == nil {
+			m.err = errors.New("quota shared store requires storage")
+		}
+		m.store = storage
+	default:
+		m.err = fmt.Errorf("quota store %q must be memory or shared", cfg.Store)
+	}
+	if m.err != nil {
+		logger.Error("Quotas misconfigured, rejecting all requests: %v", m.err)
+		return m
+	}
+	logger.Info("Quotas: %d requests a day, %d a month per consumer, %d consumers with their own",
+		cfg.Daily, cfg.Monthly, len(cfg.Consumers))
+	return m
+}
+
+func (m *QuotaMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if m.err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		consumer, ok := IdentityFromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := m.now()
+		var counted []string
+		var tightest *QuotaPeriod
+		var exceeded *QuotaPeriod
+		for _, window := range m.windows(consumer, now) {
+			key := window.key(consumer)
+			used, err := m.store.Incr(r.Context(), key, window.end.Sub(now)+time.Hour)
+			if err != nil {
+				// A store outage must not take the gateway down with it
+				logger.Warn("Quota store unavailable, allowing request: %v", err)
+				m.uncount(r.Context(), counted)
+				next.ServeHTTP(w, r)
+				return
+			}
+			counted = append(counted, key)
+			p := quotaPeriod(window, used)
+			if tightest == nil || p.Remaining < tightest.Remaining {
+				tightest = &p
+			}
+			if used > window.limit && exceeded == nil {
+				exceeded = &p
+			}
+		}
+		if tightest == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if exceeded != nil {
+			// Rejected requests do not use up the quota
+			m.uncount(r.Context(), counted)
+			tracing.RecordDecision(r.Context(), "quota", tracing.Denied, exceeded.Period+" quota exceeded",
+				attribute.String("quota.period", exceeded.Period))
+			logger.Warn("%s quota of %d exceeded for %s on %s %s", exceeded.Period, exceeded.Limit, consumer, r.Method, r.URL.Path)
+			metrics.RecordQuotaExceeded(exceeded.Period)
+
+			setQuotaHeaders(w.Header(), *exceeded, now)
+			w.Header().Set("Retry-After", strconv.FormatInt(resetSeconds(exceeded.ResetsAt, now), 10))
+			http.Error(w, "Quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		tracing.RecordDecision(r.Context(), "quota", tracing.Allowed, "",
+			attribute.Int64("quota.remaining", tightest.Remaining))
+		setQuotaHeaders(w.Header(), *tightest, now)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setQuotaHeaders tells the consumer about the quota closest to running
+// out: its limit, what is left and the seconds until it resets
+func setQuotaHeaders(h http.Header, p QuotaPeriod, now time.Time) {
+	h.Set("X-Quota-Limit", strconv.FormatInt(p.Limit, 10))
+	h.Set("X-Quota-Remaining", strconv.FormatInt(p.Remaining, 10))
+	h.Set("X-Quota-Reset", strconv.FormatInt(resetSeconds(p.ResetsAt, now), 10))
+}
+
+func resetSeconds(at, now time.Time) int64 {
+	return int64(at.Sub(now).Round(time.Second) / time.Second)
+}
+
+// uncount takes back a request counted against keys
+func (m *QuotaMiddleware) uncount(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if _, err := m.store.IncrBy(ctx, key, -1, 0); err != nil {
+			logger.Warn("Failed to take back a rejected request from quota %s: %v", key, err)
+		}
+	}
+}
+
+// limits returns the quotas of consumer
+func (m *QuotaMiddleware) limits(consumer string) config.QuotaLimits {
+	if limits, ok := m.cfg.Consumers[consumer]; ok {
+		return limits
+	}
+	return config.QuotaLimits{Daily: m.cfg.Daily, Monthly: m.cfg.Monthly}
+}
+
+// windows returns the current day and month, for the quotas consumer has
+func (m *QuotaMiddleware) windows(consumer string, now time.Time) []quotaWindow {
+	limits := m.limits(consumer)
+	now = now.UTC()
+	var windows []quotaWindow
+	if limits.Daily > 0 {
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		windows = append(windows, quotaWindow{period: "daily", limit: limits.Daily, start: day, end: day.AddDate(0, 0, 1)})
+	}
+	if limits.Monthly > 0 {
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		windows = append(windows, quotaWindow{period: "monthly", limit: limits.Monthly, start: month, end: month.AddDate(0, 1, 0)})
+	}
+	return windows
+}
+
+func quotaPeriod(w quotaWindow, used int64) QuotaPeriod {
+	return QuotaPeriod{
+		Period:    w.period,
+		Limit:     w.limit,
+		Used:      used,
+		Remaining: max(w.limit-used, 0),
+		ResetsAt:  w.end,
+	}
+}
+
+// Status returns consumer's use of its quotas in the current day and month
+func (m *QuotaMiddleware) Status(ctx context.Context, consumer string) (QuotaStatus, error) {
+	if m.err != nil {
+		return QuotaStatus{}, m.err
+	}
+	status := QuotaStatus{Consumer: consumer, Quotas: []QuotaPeriod{}}
+	for _, window := range m.windows(consumer, m.now()) {
+		var used int64
+		value, ok, err := m.store.Get(ctx, window.key(consumer))
+		if err != nil {
+			return QuotaStatus{}, err
+		}
+		if ok {
+			used, _ = strconv.ParseInt(string(value), 10, 64)
+		}
+		status.Quotas = append(status.Quotas, quotaPeriod(window, used))
+	}
+	return status, nil
+}
+
+// Statuses returns the quota use of every consumer that made requests in
+// the current day or month, or has quotas of its own, by consumer
+func (m *QuotaMiddleware) Statuses(ctx context.Context) ([]QuotaStatus, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	entries, err := m.store.Scan(ctx, quotaPrefix)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for consumer := range m.cfg.Consumers {
+		seen[consumer] = true
+	}
+	for key := range entries {
+		// quota:<period>:<start>:<consumer>, the consumer may hold colons
+		parts := strings.SplitN(strings.TrimPrefix(key, quotaPrefix), ":", 3)
+		if len(parts) == 3 {
+			seen[parts[2]] = true
+		}
+	}
+
+	consumers := make([]string, 0, len(seen))
+	for consumer := range seen {
+		consumers = append(consumers, consumer)
+	}
+	sort.Strings(consumers)
+	statuses := make([]QuotaStatus, 0, len(consumers))
+	for _, consumer := range consumers {
+		status, err := m.Status(ctx, consumer)
+		if err != nil {
+			return nil, err
+		}
+		if len(status.Quotas) > 0 {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
+
+// Reset starts consumer's current day and month over, reporting whether
+// it had used any of them
+func (m *QuotaMiddleware) Reset(ctx context.Context, consumer string) (bool, error) {
+	if m.err != nil {
+		return false, m.err
+	}
+	var reset bool
+	for _, window := range m.windows(consumer, m.now()) {
+		existed, err := m.store.Delete(ctx, window.key(consumer))
+		if err != nil {
+			return false, err
+		}
+		reset = reset || existed
+	}
+	return reset, nil
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestQuota(t *testing.T) {
+	m := NewQuota(config.QuotaConfig{
+		Daily:     2,
+		Monthly:   3,
+		Consumers: map[string]config.QuotaLimits{"hmac:partner": {Monthly: 100}},
+	})
+	now := time.Date(2024, 3, 31, 23, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	handler := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	serve := func(principal string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		if principal != "" {
+			req = withIdentity(req, principal)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("hmac:shop")
+	if rr.Code != http.StatusOK || rr.Header().Get("X-Quota-Limit") != "2" || rr.Header().Get("X-Quota-Remaining") != "1" || rr.Header().Get("X-Quota-Reset") != "3600" {
+		t.Fatalf("Expected the daily quota in the headers, got %d %v", rr.Code, rr.Header())
+	}
+	serve("hmac:shop")
+	rr = serve("hmac:shop")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "3600" || rr.Header().Get("X-Quota-Remaining") != "0" {
+		t.Fatalf("Expected the daily quota to be used up, got %d %v", rr.Code, rr.Header())
+	}
+
+	// Other consumers and anonymous requests are not affected
+	if rr := serve("hmac:partner"); rr.Code != http.StatusOK || rr.Header().Get("X-Quota-Limit") != "100" {
+		t.Errorf("Expected the partner's own quota, got %d %v", rr.Code, rr.Header())
+	}
+	if rr := serve(""); rr.Code != http.StatusOK || rr.Header().Get("X-Quota-Limit") != "" {
+		t.Errorf("Expected anonymous requests to pass without quota, got %d %v", rr.Code, rr.Header())
+	}
+
+	// A new day; the rejected request did not count against the month
+	now = now.Add(2 * time.Hour)
+	if rr := serve("hmac:shop"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected a new day and month to start over, got %d", rr.Code)
+	}
+
+	status, err := m.Status(context.Background(), "hmac:shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Quotas) != 2 || status.Quotas[0].Used != 1 || status.Quotas[1].Period != "monthly" || status.Quotas[1].Remaining != 2 {
+		t.Errorf("Expected 1 of each quota used, got %+v", status)
+	}
+
+	statuses, _ := m.Statuses(context.Background())
+	if len(statuses) != 2 || statuses[0].Consumer != "hmac:partner" || statuses[1].Consumer != "hmac:shop" {
+		t.Errorf("Expected partner and shop, got %+v", statuses)
+	}
+
+	if reset, _ := m.Reset(context.Background(), "hmac:shop"); !reset {
+		t.Error("Expected the quota to be reset")
+	}
+	if status, _ := m.Status(context.Background(), "hmac:shop"); status.Quotas[0].Used != 0 {
+		t.Errorf("Expected nothing used after a reset, got %+v", status)
+	}
+}
+
+func TestQuotaSharedStoreRequiresStorage(t *testing.T) {
+	m := NewQuotaWithStorage(config.QuotaConfig{Store: "shared", Daily: 10}, nil)
+	rr := httptest.NewRecorder()
+	m.Wrap(http.NotFoundHandler()).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 without storage, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/grpcclient"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/ratelimit"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// RateLimitServiceMiddleware asks an external rate limit service whether a
+// request is over a limit. It runs after the authentication middlewares, so
+// descriptors can name the consumer.
+type RateLimitServiceMiddleware struct {
+	cfg    config.RateLimitSvcConfig
+	client *ratelimit.Client
+	tarpit *Tarpit
+	err    error
+}
+
+// SetTarpit holds rejected requests in t before answering them
+func (m *RateLimitServiceMiddleware) SetTarpit(t *Tarpit) {
+	m.tarpit = t
+}
+
+func NewRateLimitService(cfg config.RateLimitSvcConfig) *RateLimitServiceMiddleware {
+	if cfg.TimeoutMs <= 0 {
+		cfg.TimeoutMs = 100
+	}
+	if len(cfg.Descriptors) == 0 {
+		cfg.Descriptors = []config.RateLimitDescriptor{{
+			Entries: []config.RateLimitDescriptorEntry{{Key: "remote_address", From: "remoteAddress"}},
+		}}
+	}
+
+	m := &RateLimitServiceMiddleware{cfg: cfg}
+	conn, err := grpcclient.Dial(cfg.URL)
+	if err != nil {
+		m.err = err
+		logger.Error("Rate limit service misconfigured, rejecting all requests: %v", err)
+		return m
+	}
+	m.client = ratelimit.New(conn, time.Duration(cfg.TimeoutMs)*time.Millisecond)
+
+	logger.Info("Rate limits delegated to %s, domain %s (fail closed: %v)", cfg.URL, cfg.Domain, cfg.FailClosed)
+	return m
+}
+
+func (m *RateLimitServiceMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		descriptors := m.descriptors(r)
+		if len(descriptors) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cost := requestCost(r)
+		decision, err := m.client.ShouldRateLimit(r.Context(), &ratelimit.Request{
+			Domain:      m.cfg.Domain,
+			Descriptors: descriptors,
+			Hits:        uint32(cost),
+		})
+		if err != nil {
+			metrics.RecordRateLimitService("error")
+			if m.cfg.FailClosed {
+				tracing.RecordDecision(r.Context(), "rate_limit_service", tracing.Denied, "service unavailable, failing closed")
+				logger.Warn("Rate limit service unavailable, rejecting request: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			tracing.RecordDecision(r.Context(), "rate_limit_service", tracing.Allowed, "service unavailable, failing open")
+			logger.Warn("Rate limit service unavailable, allowing request: %v", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		for name, values := range decision.ResponseHeaders {
+			w.Header()[http.CanonicalHeaderKey(name)] = values
+		}
+		attrs := []attribute.KeyValue{attribute.Int("ratelimit.cost", cost)}
+		if decision.Limit != nil {
+			attrs = append(attrs, attribute.Int64("ratelimit.remaining", int64(decision.Limit.Remaining)))
+		}
+
+		if decision.OverLimit {
+			metrics.RecordRateLimitService("over_limit")
+			tracing.RecordDecision(r.Context(), "rate_limit_service", tracing.Denied, "over limit", attrs...)
+			logger.Warn("Rate limit service rejected %s %s from %s", r.Method, r.URL.Path, getClientIP(r))
+			if !m.tarpit.Hold(r) {
+				return
+			}
+
+			if decision.Limit != nil && decision.Limit.ResetIn > 0 && w.Header().Get("Retry-After") == "" {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.Limit.ResetIn.Seconds()))))
+			}
+			if decision.Body == "" {
+				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(decision.Body))
+			return
+		}
+
+		for name, values := range decision.RequestHeaders {
+			r.Header[http.CanonicalHeaderKey(name)] = values
+		}
+		metrics.RecordRateLimitService("ok")
+		tracing.RecordDecision(r.Context(), "rate_limit_service", tracing.Allowed, "", attrs...)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// descriptors resolves the configured descriptors for r, leaving out those
+// with an entry r has no value for
+func (m *RateLimitServiceMiddleware) descriptors(r *http.Request) []ratelimit.Descriptor {
+	var descriptors []ratelimit.Descriptor
+next:
+	for _, d := range m.cfg.Descriptors {
+		descriptor := make(ratelimit.Descriptor, 0, len(d.Entries))
+		for _, e := range d.Entries {
+			value := e.Value
+			switch {
+			case e.From == "remoteAddress":
+				value = ConnectingIP(r, m.cfg.UseForwardedFor)
+			case e.From == "consumer":
+				value, _ = IdentityFromContext(r.Context())
+			case e.From == "method":
+				value = r.Method
+			case e.From == "path":
+				value = r.URL.Path
+			case strings.HasPrefix(e.From, "header:"):
+				value = r.Header.Get(strings.TrimPrefix(e.From, "header:"))
+			}
+			if value == "" {
+				continue next
+			}
+			descriptor = append(descriptor, ratelimit.Entry{Key: e.Key, Value: value})
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+	return descriptors
+}
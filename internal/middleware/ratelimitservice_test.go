@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/grpc"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// serveGRPC serves the services register adds on a local port, and
+// returns its grpc:// URL and a function that stops it
+func serveGRPC(t *testing.T, register func(*grpc.Server)) (string, func()) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	register(server)
+	go server.Serve(listener)
+	return "grpc://" + listener.Addr().String(), server.Stop
+}
+
+// rateLimitService allows limit hits per descriptor. The descriptors it
+// saw are joined into keys like "consumer=hmac:shop".
+type rateLimitService struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+	limit uint32
+
+	mu   sync.Mutex
+	used map[string]uint32
+	seen []string
+}
+
+func (s *rateLimitService) ShouldRateLimit(_ context.Context, req *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	hits := req.GetHitsAddend()
+	if hits == 0 {
+		hits = 1
+	}
+	var keys []string
+	for _, descriptor := range req.GetDescriptors() {
+		var entries []string
+		for _, entry := range descriptor.GetEntries() {
+			entries = append(entries, entry.GetKey()+"="+entry.GetValue())
+		}
+		keys = append(keys, strings.Join(entries, ","))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen = append(s.seen, keys...)
+	code := rlsv3.RateLimitResponse_OK
+	for _, key := range keys {
+		s.used[key] += hits
+		if s.used[key] > s.limit {
+			code = rlsv3.RateLimitResponse_OVER_LIMIT
+		}
+	}
+	return &rlsv3.RateLimitResponse{
+		OverallCode:          code,
+		ResponseHeadersToAdd: []*corev3.HeaderValue{{Key: "x-ratelimit-limit", Value: "2"}},
+	}, nil
+}
+
+func (s *rateLimitService) descriptors() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seen
+}
+
+// newRateLimitService serves ShouldRateLimit, allowing limit hits per
+// descriptor
+func newRateLimitService(t *testing.T, limit uint32) (string, func(), *rateLimitService) {
+	service := &rateLimitService{limit: limit, used: make(map[string]uint32)}
+	url, stop := serveGRPC(t, func(server *grpc.Server) {
+		rlsv3.RegisterRateLimitServiceServer(server, service)
+	})
+	return url, stop, service
+}
+
+func TestRateLimitService(t *testing.T) {
+	url, stop, service := newRateLimitService(t, 2)
+	defer stop()
+
+	handler := NewRateLimitService(config.RateLimitSvcConfig{
+		URL:    url,
+		Domain: "gatekeeper",
+		Descriptors: []config.RateLimitDescriptor{
+			{Entries: []config.RateLimitDescriptorEntry{{Key: "consumer", From: "consumer"}}},
+			{Entries: []config.RateLimitDescriptorEntry{{Key: "generic_key", Value: "search"}, {Key: "plan", From: "header:X-Plan"}}},
+		},
+	}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	serve := func(cost int) *httptest.ResponseRecorder {
+		req := withIdentity(httptest.NewRequest("GET", "/search", nil), "hmac:shop")
+		if cost > 0 {
+			req = WithCost(req, cost)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve(0)
+	if rr.Code != http.StatusOK || rr.Header().Get("X-Ratelimit-Limit") != "2" {
+		t.Fatalf("Expected the first request to pass with the service's headers, got %d %v", rr.Code, rr.Header())
+	}
+	// The second descriptor was left out for lack of an X-Plan header
+	if got := service.descriptors(); len(got) != 1 || got[0] != "consumer=hmac:shop" {
+		t.Errorf("Expected only the consumer descriptor, got %v", got)
+	}
+	// A request costing two takes the consumer over its limit
+	if rr := serve(2); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the service to reject the request, got %d", rr.Code)
+	}
+}
+
+func TestRateLimitServiceUnavailable(t *testing.T) {
+	url, stop, _ := newRateLimitService(t, 2)
+	stop()
+
+	for _, failClosed := range []bool{false, true} {
+		handler := NewRateLimitService(config.RateLimitSvcConfig{URL: url, Domain: "gatekeeper", FailClosed: failClosed}).
+			Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("OK"))
+			}))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		want := http.StatusOK
+		if failClosed {
+			want = http.StatusInternalServerError
+		}
+		if rr.Code != want {
+			t.Errorf("Fail closed %v: expected %d, got %d", failClosed, want, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+type arrivalKey struct{}
+
+// RequestAgeMiddleware records when each request arrived and forwards it
+// to the backend. It should wrap everything else so the time includes
+// every gateway queue.
+type RequestAgeMiddleware struct {
+	header string
+	now    func() time.Time
+}
+
+func NewRequestAge(cfg config.RequestAgeConfig) *RequestAgeMiddleware {
+	if cfg.Header == "" {
+		cfg.Header = "X-Request-Start"
+	}
+	if cfg.MaxQueueTimeMs > 0 {
+		logger.Info("Requests queued longer than %dms will be rejected", cfg.MaxQueueTimeMs)
+	}
+	return &RequestAgeMiddleware{header: cfg.Header, now: time.Now}
+}
+
+func (m *RequestAgeMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrival := m.now()
+
+		// A client-supplied value would let callers skew the backend's
+		// queue time metrics, so it is always replaced
+		r.Header.Set(m.header, "t="+strconv.FormatInt(arrival.UnixMicro(), 10))
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), arrivalKey{}, arrival)))
+	})
+}
+
+// ArrivalFromContext returns when the request reached the gateway
+func ArrivalFromContext(ctx context.Context) (time.Time, bool) {
+	arrival, ok := ctx.Value(arrivalKey{}).(time.Time)
+	return arrival, ok
+}
+
+// RejectQueuedTooLong writes a 503 and returns true if the request has been
+// in the gateway longer than maxWait. Requests without an arrival time are
+// never rejected.
+func RejectQueuedTooLong(w http.ResponseWriter, r *http.Request, maxWait time.Duration) bool {
+	arrival, ok := ArrivalFromContext(r.Context())
+	if !ok {
+		return false
+	}
+
+	waited := time.Since(arrival)
+	metrics.RecordQueueTime(waited)
+	if maxWait <= 0 || waited <= maxWait {
+		return false
+	}
+
+	tracing.RecordDecision(r.Context(), "queue_time", tracing.Denied, "queued too long",
+		attribute.Int64("queue.wait_ms", waited.Milliseconds()))
+	logger.Warn("Rejected %s %s from %s after %v in queue", r.Method, r.URL.Path, getClientIP(r), waited)
+	metrics.RecordQueueTimeRejected()
+
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	return true
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestRequestAge(t *testing.T) {
+	arrival := time.Now().Add(-2 * time.Second)
+	middleware := NewRequestAge(config.RequestAgeConfig{Enabled: true})
+	middleware.now = func() time.Time { return arrival }
+
+	var header string
+	var rejected []bool
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("X-Request-Start")
+		rejected = append(rejected,
+			RejectQueuedTooLong(httptest.NewRecorder(), r, 5*time.Second),
+			RejectQueuedTooLong(w, r, time.Second))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-Start", "t=1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if want := "t=" + strconv.FormatInt(arrival.UnixMicro(), 10); header != want {
+		t.Errorf("Expected forwarded header %q, got %q", want, header)
+	}
+	if len(rejected) != 2 || rejected[0] || !rejected[1] {
+		t.Errorf("Expected only the 1s limit to reject a 2s old request, got %v", rejected)
+	}
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for a request queued too long, got %d", rr.Code)
+	}
+}
+
+func TestRejectQueuedTooLongWithoutArrival(t *testing.T) {
+	rr := httptest.NewRecorder()
+	if RejectQueuedTooLong(rr, httptest.NewRequest("GET", "/", nil), time.Nanosecond) {
+		t.Error("Expected requests without an arrival time to pass")
+	}
+}
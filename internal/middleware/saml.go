@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/saml"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// SAML assertion middleware
+type SAMLMiddleware struct {
+	cfg       config.SAMLConfig
+	validator *saml.Validator
+	err       error
+}
+
+func NewSAML(cfg config.SAMLConfig) *SAMLMiddleware {
+	if cfg.Header == "" {
+		cfg.Header = "X-SAML-Assertion"
+	}
+	if cfg.IdentityHeader == "" {
+		cfg.IdentityHeader = "X-Auth-Subject"
+	}
+
+	m := &SAMLMiddleware{cfg: cfg}
+
+	idp, err := loadSAMLMetadata(cfg)
+	if err != nil {
+		m.err = err
+		logger.Error("SAML middleware misconfigured, rejecting all requests: %v", err)
+		return m
+	}
+
+	m.validator = saml.NewValidator(idp, cfg.Audience)
+	logger.Info("SAML assertions accepted from %s for audience %s", idp.EntityID, cfg.Audience)
+	return m
+}
+
+func (m *SAMLMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoded := m.extractAssertion(r)
+
+		// Identity headers are only ever set by the gateway
+		r.Header.Del(m.cfg.IdentityHeader)
+		for _, header := range m.cfg.AttributeHeaders {
+			r.Header.Del(header)
+		}
+
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" || hasAnyPrefix(r.URL.Path, m.cfg.SkipPaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if encoded == "" {
+			tracing.RecordDecision(r.Context(), "saml", tracing.Denied, "no assertion")
+			w.Header().Set("WWW-Authenticate", `SAML realm="gatekeeper"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			tracing.RecordDecision(r.Context(), "saml", tracing.Denied, "assertion is not base64")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		assertion, err := m.validator.Validate(raw)
+		if err != nil {
+			logger.Warn("SAML assertion rejected from %s: %v", getClientIP(r), err)
+			tracing.RecordDecision(r.Context(), "saml", tracing.Denied, err.Error())
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		r.Header.Set(m.cfg.IdentityHeader, assertion.Subject)
+		for attribute, header := range m.cfg.AttributeHeaders {
+			if values := assertion.Attributes[attribute]; len(values) > 0 {
+				r.Header.Set(header, strings.Join(values, ","))
+			}
+		}
+
+		tracing.RecordDecision(r.Context(), "saml", tracing.Allowed, "valid assertion")
+		next.ServeHTTP(w, withIdentity(r, "saml:"+assertion.Subject))
+	})
+}
+
+// extractAssertion reads the base64 assertion from the configured header or
+// an "Authorization: SAML <assertion>" header, and removes it so it is not
+// forwarded to backends.
+func (m *SAMLMiddleware) extractAssertion(r *http.Request) string {
+	if value := r.Header.Get(m.cfg.Header); value != "" {
+		r.Header.Del(m.cfg.Header)
+		return strings.TrimSpace(value)
+	}
+
+	if auth := r.Header.Get("Authorization"); len(auth) > 5 && strings.EqualFold(auth[:5], "SAML ") {
+		r.Header.Del("Authorization")
+		return strings.TrimSpace(auth[5:])
+	}
+
+	return ""
+}
+
+func loadSAMLMetadata(cfg config.SAMLConfig) (*saml.IdentityProvider, error) {
+	var data []byte
+	var err error
+
+	switch {
+	case cfg.MetadataFile != "":
+		data, err = os.ReadFile(cfg.MetadataFile)
+	case cfg.MetadataURL != "":
+		data, err = fetchMetadata(cfg.MetadataURL)
+	default:
+		return nil, errors.New("saml requires metadataFile or metadataURL")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Audience == "" {
+		return nil, errors.New("saml requires an audience (the SP entity ID)")
+	}
+
+	return saml.ParseMetadata(data)
+}
+
+func fetchMetadata(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching SAML metadata: status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
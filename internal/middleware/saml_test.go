@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestSAMLMiddlewareFailsClosedWithoutMetadata(t *testing.T) {
+	middleware := NewSAML(config.SAMLConfig{Audience: "https://gateway.example.com"})
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when metadata is missing, got %v", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected health endpoint to bypass SAML, got %v", rr.Code)
+	}
+}
+
+func TestSAMLExtractAssertion(t *testing.T) {
+	middleware := &SAMLMiddleware{cfg: config.SAMLConfig{Header: "X-SAML-Assertion"}}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "SAML PHNhbWw+")
+	if got := middleware.extractAssertion(req); got != "PHNhbWw+" {
+		t.Errorf("Expected assertion from Authorization header, got %v", got)
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Error("Expected Authorization header to be removed before proxying")
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer abc")
+	if got := middleware.extractAssertion(req); got != "" {
+		t.Errorf("Expected bearer tokens to be ignored, got %v", got)
+	}
+}
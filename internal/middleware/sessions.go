@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/kv"
+	"github.com/barisgenc/gatekeeper/internal/session"
+)
+
+// NewSessions creates the session manager cfg describes. Server-side
+// sessions and revocations are kept in storage.
+func NewSessions(cfg config.SessionsConfig, storage kv.Store) (*session.Manager, error) {
+	if cfg.CookieName == "" {
+		cfg.CookieName = "gatekeeper_session"
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 8 * 3600
+	}
+	opts := session.Options{
+		CookieName: cfg.CookieName,
+		TTL:        time.Duration(cfg.TTL) * time.Second,
+		SameSite:   http.SameSiteLaxMode,
+		Secure:     cfg.Secure == nil || *cfg.Secure,
+		Domain:     cfg.Domain,
+	}
+
+	switch cfg.SameSite {
+	case "", "lax":
+	case "strict":
+		opts.SameSite = http.SameSiteStrictMode
+	case "none":
+		opts.SameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("sessions sameSite %q must be lax, strict or none", cfg.SameSite)
+	}
+	switch cfg.Store {
+	case "", "cookie":
+	case "shared":
+		opts.ServerSide = true
+	default:
+		return nil, fmt.Errorf("sessions store %q must be cookie or shared", cfg.Store)
+	}
+	if storage == nil {
+		return nil, errors.New("sessions require storage")
+	}
+	return session.NewManager(cfg.Secret, opts, storage)
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/logger"
+)
+
+// spikeInterval is the time between two requests when requestsPerMinute
+// are spread evenly over the minute
+func spikeInterval(requestsPerMinute int) time.Duration {
+	if requestsPerMinute <= 0 {
+		return time.Minute
+	}
+	return time.Minute / time.Duration(requestsPerMinute)
+}
+
+// NewSpikeArrest limits all clients together to requestsPerMinute spread
+// evenly over the minute, one request per interval, so a fragile backend
+// never sees the minute's allowance at once. It is a token bucket holding
+// a single token, so a request's cost counts as one.
+func NewSpikeArrest(requestsPerMinute int) *RateLimitMiddleware {
+	interval := spikeInterval(requestsPerMinute)
+	logger.Info("Spike arrest initialized: one request every %v", interval)
+
+	return &RateLimitMiddleware{
+		limiter:    newTokenBucket(float64(requestsPerMinute), 1),
+		reason:     "spike arrest",
+		retryAfter: strconv.Itoa(int(math.Ceil(interval.Seconds()))),
+	}
+}
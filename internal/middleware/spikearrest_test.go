@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/kv"
+)
+
+func TestSpikeArrest(t *testing.T) {
+	// 600 a minute is one request every 100ms, however long the burst
+	handler := NewSpikeArrest(600).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		return rr
+	}
+
+	if rr := serve(); rr.Code != http.StatusOK {
+		t.Fatalf("Expected the first request to pass, got %d", rr.Code)
+	}
+	rr := serve()
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Expected the second request to be arrested, got %d %v", rr.Code, rr.Header())
+	}
+	time.Sleep(110 * time.Millisecond)
+	if rr := serve(); rr.Code != http.StatusOK {
+		t.Errorf("Expected a request after the interval to pass, got %d", rr.Code)
+	}
+}
+
+func TestTieredSpikeArrest(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	tier := &config.RateLimitTier{RequestsPerMinute: 120, BurstSize: 50}
+
+	for _, store := range []string{"memory", "shared"} {
+		t.Run(store, func(t *testing.T) {
+			m := NewTieredRateLimitWithStorage(config.RateLimitConfig{
+				Mode:      "spikeArrest",
+				Store:     store,
+				Anonymous: tier,
+			}, kv.NewMemoryStore())
+			now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+			switch l := m.anonymous.(type) {
+			case *clientLimiters:
+				if l.burst != 1 {
+					t.Fatalf("Expected the burst size to be ignored, got %d", l.burst)
+				}
+				return
+			case *sharedLimiter:
+				l.now = func() time.Time { return now }
+			}
+			handler := m.Wrap(ok)
+			serve := func() int {
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+				return rr.Code
+			}
+
+			// 120 a minute is one request every 500ms
+			if code := serve(); code != http.StatusOK {
+				t.Fatalf("Expected the first request to pass, got %d", code)
+			}
+			if code := serve(); code != http.StatusTooManyRequests {
+				t.Errorf("Expected a second request in the same 500ms to be arrested, got %d", code)
+			}
+			now = now.Add(500 * time.Millisecond)
+			if code := serve(); code != http.StatusOK {
+				t.Errorf("Expected a request in the next 500ms to pass, got %d", code)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+)
+
+func writeTestKeytab(t *testing.T) string {
+	t.Helper()
+	kt := keytab.New()
+	if err := kt.AddEntry("HTTP/gateway.example.com", "EXAMPLE.COM", "secret", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatal(err)
+	}
+	data, err := kt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "gateway.keytab")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSPNEGOMiddlewareFailsClosedWithoutKeytab(t *testing.T) {
+	middleware := NewSPNEGO(config.SPNEGOConfig{KeytabFile: filepath.Join(t.TempDir(), "missing.keytab")})
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when keytab is missing, got %v", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected health endpoint to bypass SPNEGO, got %v", rr.Code)
+	}
+}
+
+func TestSPNEGOMiddlewareChallengesUnauthenticatedRequests(t *testing.T) {
+	middleware := NewSPNEGO(config.SPNEGOConfig{
+		KeytabFile:       writeTestKeytab(t),
+		ServicePrincipal: "HTTP/gateway.example.com",
+	})
+
+	called := false
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest("GET", "/api", nil)
+	req.Header.Set("X-Auth-Subject", "admin@EXAMPLE.COM")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a Negotiate token, got %v", rr.Code)
+	}
+	if got := rr.Header().Get("WWW-Authenticate"); got != "Negotiate" {
+		t.Errorf("Expected Negotiate challenge, got %q", got)
+	}
+	if called {
+		t.Error("Expected backend not to be called")
+	}
+
+	req = httptest.NewRequest("GET", "/api", nil)
+	req.Header.Set("Authorization", "Negotiate bm90LWEtdG9rZW4=")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized || called {
+		t.Errorf("Expected invalid Negotiate token to be rejected, got %v", rr.Code)
+	}
+}
+
+func TestPrincipalName(t *testing.T) {
+	if got := principalName("alice", "EXAMPLE.COM", false); got != "alice@EXAMPLE.COM" {
+		t.Errorf("Expected alice@EXAMPLE.COM, got %v", got)
+	}
+	if got := principalName("alice", "EXAMPLE.COM", true); got != "alice" {
+		t.Errorf("Expected realm to be stripped, got %v", got)
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// Tarpit holds rejected requests for a while before they are answered. It
+// is shared by the rate limits and auto-ban, so one cap covers every held
+// request. A nil Tarpit holds nothing.
+type Tarpit struct {
+	delay time.Duration
+	slots chan struct{}
+}
+
+func NewTarpit(cfg config.TarpitConfig) *Tarpit {
+	if cfg.Delay <= 0 {
+		cfg.Delay = 5
+	}
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = 100
+	}
+	logger.Info("Tarpit enabled: rejections held for %ds, at most %d at once", cfg.Delay, cfg.MaxConcurrent)
+	return &Tarpit{
+		delay: time.Duration(cfg.Delay) * time.Second,
+		slots: make(chan struct{}, cfg.MaxConcurrent),
+	}
+}
+
+// Hold delays the rejection of r, unless the tarpit is full. It returns
+// false when the client went away while held, so there is no one left to
+// answer.
+func (t *Tarpit) Hold(r *http.Request) bool {
+	if t == nil {
+		return true
+	}
+	select {
+	case t.slots <- struct{}{}:
+	default:
+		metrics.RecordTarpit("full")
+		return true
+	}
+	defer func() { <-t.slots }()
+	metrics.RecordTarpit("held")
+
+	timer := time.NewTimer(t.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestTarpitHold(t *testing.T) {
+	var none *Tarpit
+	if !none.Hold(httptest.NewRequest("GET", "/", nil)) {
+		t.Error("Expected a nil tarpit to answer at once")
+	}
+
+	tarpit := NewTarpit(config.TarpitConfig{Enabled: true, Delay: 30, MaxConcurrent: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	held := make(chan bool)
+	go func() {
+		held <- tarpit.Hold(httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	}()
+	for len(tarpit.slots) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The only slot is taken, so the next rejection is answered at once
+	start := time.Now()
+	if !tarpit.Hold(httptest.NewRequest("GET", "/", nil)) || time.Since(start) > time.Second {
+		t.Error("Expected a full tarpit to answer at once")
+	}
+
+	cancel()
+	if <-held {
+		t.Error("Expected Hold to report a client that went away")
+	}
+	if len(tarpit.slots) != 0 {
+		t.Error("Expected the slot to be released")
+	}
+}
+
+func TestRateLimitTarpit(t *testing.T) {
+	m := NewRateLimiter(60, 1)
+	m.SetTarpit(NewTarpit(config.TarpitConfig{Enabled: true, Delay: 30}))
+	handler := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	// Over the limit, the client gives up while held and gets no answer
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the rejection to be held, answered after %v", elapsed)
+	}
+	if rr.Body.Len() != 0 || rr.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected no answer to a client that went away, got %d %q", rr.Code, rr.Body.String())
+	}
+}
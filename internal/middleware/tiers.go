@@ -0,0 +1,287 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/time/rate"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/kv"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// TieredRateLimitMiddleware limits each client by tier: anonymous clients
+// per IP, authenticated clients per identity, by their plan when they have
+// one. It must run after the authentication middlewares.
+type TieredRateLimitMiddleware struct {
+	anonymous       tierLimiter
+	authenticated   tierLimiter
+	plans           map[string]tierLimiter
+	consumers       map[string]string
+	limits          map[string]int
+	useForwardedFor bool
+	tarpit          *Tarpit
+	err             error
+}
+
+// SetTarpit holds rejected requests in t before answering them
+func (m *TieredRateLimitMiddleware) SetTarpit(t *Tarpit) {
+	m.tarpit = t
+}
+
+// tierLimiter decides whether a client may make another request costing
+// cost tokens, and how many tokens it has left
+type tierLimiter interface {
+	allow(ctx context.Context, client string, cost int) (bool, float64, error)
+}
+
+func NewTieredRateLimit(cfg config.RateLimitConfig) *TieredRateLimitMiddleware {
+	return NewTieredRateLimitWithStorage(cfg, nil)
+}
+
+// NewTieredRateLimitWithStorage is NewTieredRateLimit with the gateway's
+// shared storage, which counts requests when cfg.Store is "shared"
+func NewTieredRateLimitWithStorage(cfg config.RateLimitConfig, storage kv.Store) *TieredRateLimitMiddleware {
+	m := &TieredRateLimitMiddleware{
+		plans:           make(map[string]tierLimiter, len(cfg.Plans)),
+		consumers:       cfg.Consumers,
+		limits:          make(map[string]int),
+		useForwardedFor: cfg.UseForwardedFor,
+	}
+
+	newLimiter := func(tier string, t config.RateLimitTier) tierLimiter {
+		if cfg.SpikeArrest() {
+			t.BurstSize = 1
+		}
+		return newClientLimiters(t)
+	}
+	switch cfg.Store {
+	case "", "memory":
+	case "shared":
+		if storage == nil {
+			m.err = errors.New("rateLimit shared store requires storage")
+			break
+		}
+		newLimiter = func(tier string, t config.RateLimitTier) tierLimiter {
+			l := &sharedLimiter{store: storage, prefix: "ratelimit:" + tier + ":", window: time.Minute, limit: int64(t.RequestsPerMinute), now: time.Now}
+			if cfg.SpikeArrest() && t.RequestsPerMinute > 0 {
+				// One request per window of the interval between two
+				l.window, l.limit = spikeInterval(t.RequestsPerMinute), 1
+			}
+			return l
+		}
+	default:
+		m.err = fmt.Errorf("rateLimit store %q must be memory or shared", cfg.Store)
+	}
+	if m.err != nil {
+		logger.Error("Rate limit misconfigured, rejecting all requests: %v", m.err)
+		return m
+	}
+
+	burst := func(t config.RateLimitTier) string {
+		if cfg.SpikeArrest() {
+			return "one request every " + spikeInterval(t.RequestsPerMinute).String()
+		}
+		return "burst: " + strconv.Itoa(t.BurstSize)
+	}
+	if cfg.Anonymous != nil {
+		m.anonymous = newLimiter("anonymous", *cfg.Anonymous)
+		logger.Info("Anonymous rate limit: %d req/min per IP, %s",
+			cfg.Anonymous.RequestsPerMinute, burst(*cfg.Anonymous))
+	}
+	if cfg.Authenticated != nil {
+		m.authenticated = newLimiter("authenticated", *cfg.Authenticated)
+		m.limits["authenticated"] = cfg.Authenticated.RequestsPerMinute
+		logger.Info("Authenticated rate limit: %d req/min per identity, %s",
+			cfg.Authenticated.RequestsPerMinute, burst(*cfg.Authenticated))
+	}
+	for name, plan := range cfg.Plans {
+		m.plans[name] = newLimiter("plan:"+name, plan)
+		m.limits[name] = plan.RequestsPerMinute
+		logger.Info("Rate limit plan %s: %d req/min per identity, %s", name, plan.RequestsPerMinute, burst(plan))
+	}
+	if cfg.Anonymous != nil {
+		m.limits["anonymous"] = cfg.Anonymous.RequestsPerMinute
+	}
+	return m
+}
+
+// plan returns the plan of the request's consumer: the one its credential
+// names, else the one configured for principal
+func (m *TieredRateLimitMiddleware) plan(r *http.Request, principal string) (string, tierLimiter) {
+	plan, ok := PlanFromContext(r.Context())
+	if !ok {
+		plan = m.consumers[principal]
+	}
+	return plan, m.plans[plan]
+}
+
+func (m *TieredRateLimitMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		tier, limiters, key := "anonymous", m.anonymous, ConnectingIP(r, m.useForwardedFor)
+		if principal, ok := IdentityFromContext(r.Context()); ok {
+			tier, limiters, key = "authenticated", m.authenticated, principal
+			if plan, planLimiters := m.plan(r, principal); planLimiters != nil {
+				tier, limiters = plan, planLimiters
+				w.Header().Set("X-RateLimit-Plan", plan)
+			}
+		}
+		if limiters == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cost := requestCost(r)
+		allowed, remaining, err := limiters.allow(r.Context(), key, cost)
+		if err != nil {
+			// A store outage must not take the gateway down with it
+			logger.Warn("Rate limit store unavailable, allowing request: %v", err)
+			allowed = true
+		}
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(m.limits[tier]))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(remaining)))
+		if !allowed {
+			tracing.RecordDecision(r.Context(), "rate_limit", tracing.Denied, tier+" limit exceeded",
+				attribute.String("ratelimit.tier", tier),
+				attribute.Int("ratelimit.cost", cost))
+			logger.Warn("Rate limit exceeded for %s client %s on %s %s", tier, key, r.Method, r.URL.Path)
+			metrics.RecordTierRateLimit(tier)
+			if !m.tarpit.Hold(r) {
+				return
+			}
+
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		tracing.RecordDecision(r.Context(), "rate_limit", tracing.Allowed, "",
+			attribute.String("ratelimit.tier", tier),
+			attribute.Float64("ratelimit.remaining", remaining),
+			attribute.Int("ratelimit.cost", cost))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientLimiters holds one token bucket per client, dropping buckets of
+// clients that have gone quiet
+type clientLimiters struct {
+	perMinute float64
+	burst     int
+	idle      time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+type clientLimiter struct {
+	tokenBucket
+	lastSeen time.Time
+}
+
+func newClientLimiters(tier config.RateLimitTier) *clientLimiters {
+	burst := tier.BurstSize
+	if burst <= 0 {
+		burst = 1
+	}
+	limit := rate.Limit(float64(tier.RequestsPerMinute) / 60.0)
+
+	// A bucket idle long enough to refill can be dropped without changing
+	// the outcome for its client
+	idle := 10 * time.Minute
+	if limit > 0 {
+		if refill := time.Duration(float64(burst) / float64(limit) * float64(time.Second)); refill > idle {
+			idle = refill
+		}
+	}
+
+	return &clientLimiters{
+		perMinute: float64(tier.RequestsPerMinute),
+		burst:     burst,
+		idle:      idle,
+		now:       time.Now,
+		clients:   make(map[string]*clientLimiter),
+	}
+}
+
+func (c *clientLimiters) allow(_ context.Context, client string, cost int) (bool, float64, error) {
+	allowed, remaining := c.get(client).take(cost)
+	return allowed, remaining, nil
+}
+
+func (c *clientLimiters) get(key string) tokenBucket {
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, client := range c.clients {
+			if now.Sub(client.lastSeen) > c.idle {
+				delete(c.clients, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	client, ok := c.clients[key]
+	if !ok {
+		client = &clientLimiter{tokenBucket: newTokenBucket(c.perMinute, c.burst)}
+		c.clients[key] = client
+	}
+	client.lastSeen = now
+	return client.tokenBucket
+}
+
+// sharedLimiter counts each client's request costs per clock window,
+// a minute unless spike arrest narrows it, in shared storage, so replicas
+// enforce one limit together. Fixed windows cannot express a burst: a
+// client may use the whole window's allowance at once.
+type sharedLimiter struct {
+	store  kv.Store
+	prefix string
+	window time.Duration
+	limit  int64
+	now    func() time.Time
+}
+
+func (l *sharedLimiter) allow(ctx context.Context, client string, cost int) (bool, float64, error) {
+	window := l.now().Truncate(l.window)
+	key := l.prefix + client + ":" + strconv.FormatInt(window.UnixMilli(), 10)
+	// A cost above the limit is capped at it, as in the token buckets
+	n := min(int64(cost), max(l.limit, 1))
+	count, err := l.store.IncrBy(ctx, key, n, l.window)
+	if err != nil {
+		return false, 0, err
+	}
+	if count > l.limit {
+		// A rejected request must not use up what cheaper ones could still
+		// spend
+		if _, err := l.store.IncrBy(ctx, key, -n, 0); err != nil {
+			logger.Warn("Failed to take back a rejected request from %s: %v", key, err)
+		}
+		return false, float64(max(l.limit-count+n, 0)), nil
+	}
+	return true, float64(l.limit - count), nil
+}
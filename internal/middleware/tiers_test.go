@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/kv"
+)
+
+func TestTieredRateLimit(t *testing.T) {
+	middleware := NewTieredRateLimit(config.RateLimitConfig{
+		Anonymous:     &config.RateLimitTier{RequestsPerMinute: 1, BurstSize: 1},
+		Authenticated: &config.RateLimitTier{RequestsPerMinute: 60, BurstSize: 2},
+	})
+	handler := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	serve := func(principal string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "198.51.100.7:4000"
+		if principal != "" {
+			req = withIdentity(req, principal)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := serve(""); code != http.StatusOK {
+		t.Fatalf("Expected first anonymous request to pass, got %d", code)
+	}
+	if code := serve(""); code != http.StatusTooManyRequests {
+		t.Errorf("Expected anonymous client to be limited, got %d", code)
+	}
+
+	// Logged-in users behind the same NAT IP have their own buckets
+	for _, principal := range []string{"oidc:alice", "oidc:alice", "oidc:bob"} {
+		if code := serve(principal); code != http.StatusOK {
+			t.Errorf("Expected %s to pass, got %d", principal, code)
+		}
+	}
+	if code := serve("oidc:alice"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected alice to hit the authenticated limit, got %d", code)
+	}
+}
+
+func TestTieredRateLimitSharedStore(t *testing.T) {
+	cfg := config.RateLimitConfig{
+		Store:     "shared",
+		Anonymous: &config.RateLimitTier{RequestsPerMinute: 2},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	// Two replicas on one store share the client's allowance
+	storage := kv.NewMemoryStore()
+	replicas := []http.Handler{
+		NewTieredRateLimitWithStorage(cfg, storage).Wrap(ok),
+		NewTieredRateLimitWithStorage(cfg, storage).Wrap(ok),
+	}
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "198.51.100.7:4000"
+		rr := httptest.NewRecorder()
+		replicas[i%2].ServeHTTP(rr, req)
+		if rr.Code != expected {
+			t.Errorf("Request %d: expected %d, got %d", i+1, expected, rr.Code)
+		}
+	}
+
+	// Without storage the limit cannot be enforced, so nothing gets through
+	rr := httptest.NewRecorder()
+	NewTieredRateLimit(cfg).Wrap(ok).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 without storage, got %d", rr.Code)
+	}
+}
+
+func TestTieredRateLimitCost(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	serve := func(handler http.Handler, cost int) int {
+		req := withIdentity(httptest.NewRequest("GET", "/search", nil), "oidc:alice")
+		if cost > 0 {
+			req = WithCost(req, cost)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for _, store := range []string{"memory", "shared"} {
+		t.Run(store, func(t *testing.T) {
+			handler := NewTieredRateLimitWithStorage(config.RateLimitConfig{
+				Store:         store,
+				Authenticated: &config.RateLimitTier{RequestsPerMinute: 10, BurstSize: 10},
+			}, kv.NewMemoryStore()).Wrap(ok)
+
+			if code := serve(handler, 6); code != http.StatusOK {
+				t.Fatalf("Expected a request costing 6 to pass, got %d", code)
+			}
+			if code := serve(handler, 6); code != http.StatusTooManyRequests {
+				t.Errorf("Expected a second one to be limited, got %d", code)
+			}
+			if code := serve(handler, 0); code != http.StatusOK {
+				t.Errorf("Expected a request of the default cost to pass, got %d", code)
+			}
+		})
+	}
+
+	// A cost above the burst size takes the whole bucket
+	handler := NewTieredRateLimit(config.RateLimitConfig{
+		Authenticated: &config.RateLimitTier{RequestsPerMinute: 1, BurstSize: 5},
+	}).Wrap(ok)
+	if code := serve(handler, 50); code != http.StatusOK {
+		t.Errorf("Expected a request costing more than the burst to pass on a full bucket, got %d", code)
+	}
+	if code := serve(handler, 1); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the bucket to be empty, got %d", code)
+	}
+}
+
+func TestTieredRateLimitPlans(t *testing.T) {
+	handler := NewTieredRateLimit(config.RateLimitConfig{
+		Authenticated: &config.RateLimitTier{RequestsPerMinute: 60, BurstSize: 1},
+		Plans: map[string]config.RateLimitTier{
+			"free": {RequestsPerMinute: 60, BurstSize: 1},
+			"pro":  {RequestsPerMinute: 6000, BurstSize: 3},
+		},
+		Consumers: map[string]string{"oidc:alice": "pro"},
+	}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	serve := func(principal, plan string) *httptest.ResponseRecorder {
+		req := withIdentity(httptest.NewRequest("GET", "/", nil), principal)
+		if plan != "" {
+			req = withPlan(req, plan)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// alice is on pro through the consumers map
+	for i := 0; i < 3; i++ {
+		if rr := serve("oidc:alice", ""); rr.Code != http.StatusOK {
+			t.Fatalf("Expected request %d on pro to pass, got %d", i+1, rr.Code)
+		}
+	}
+	rr := serve("oidc:alice", "")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected alice to hit the pro limit, got %d", rr.Code)
+	}
+	if rr.Header().Get("X-RateLimit-Plan") != "pro" || rr.Header().Get("X-RateLimit-Limit") != "6000" || rr.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("Expected pro rate limit headers, got %v", rr.Header())
+	}
+
+	// A plan carried by the credential wins over the consumers map
+	if rr := serve("hmac:shop", "free"); rr.Code != http.StatusOK || rr.Header().Get("X-RateLimit-Plan") != "free" {
+		t.Fatalf("Expected the first free request to pass on plan free, got %d %v", rr.Code, rr.Header())
+	}
+	if rr := serve("hmac:shop", "free"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected shop to hit the free limit, got %d", rr.Code)
+	}
+
+	// Consumers without a plan stay on the authenticated tier
+	rr = serve("oidc:bob", "")
+	if rr.Code != http.StatusOK || rr.Header().Get("X-RateLimit-Plan") != "" || rr.Header().Get("X-RateLimit-Limit") != "60" {
+		t.Errorf("Expected bob on the authenticated tier, got %d %v", rr.Code, rr.Header())
+	}
+}
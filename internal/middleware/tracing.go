@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+)
+
+// Tracing middleware
+type TracingMiddleware struct{}
+
+func NewTracing() *TracingMiddleware {
+	return &TracingMiddleware{}
+}
+
+func (m *TracingMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		propagator := otel.GetTextMapPropagator()
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method+" gateway",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.Path),
+				attribute.String("net.peer.addr", r.RemoteAddr),
+			))
+		defer span.End()
+
+		// Backends continue the trace from the gateway span
+		propagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+		rw := metrics.NewResponseWriter(w)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		status := rw.Status()
+		span.SetAttributes(attribute.Int("http.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, strconv.Itoa(status))
+		}
+	})
+}
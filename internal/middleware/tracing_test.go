@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingRecordsPolicyDecisions(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(previous)
+
+	var traceparent string
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+	})
+	handler := NewTracing().Wrap(NewRateLimiter(60, 1).Wrap(backend))
+
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api", nil))
+	}
+
+	if traceparent == "" {
+		t.Error("Expected trace context to be propagated to the backend")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+
+	expected := []string{"allowed", "denied"}
+	for i, span := range spans {
+		events := span.Events()
+		if len(events) != 1 || events[0].Name != "policy.decision" {
+			t.Fatalf("Expected one policy.decision event, got %v", events)
+		}
+
+		attrs := map[string]string{}
+		for _, kv := range events[0].Attributes {
+			attrs[string(kv.Key)] = kv.Value.Emit()
+		}
+		if attrs["policy.name"] != "rate_limit" || attrs["policy.decision"] != expected[i] {
+			t.Errorf("Request %d: unexpected event attributes %v", i+1, attrs)
+		}
+		if _, ok := attrs["ratelimit.remaining"]; !ok {
+			t.Errorf("Request %d: expected remaining budget attribute", i+1)
+		}
+	}
+}
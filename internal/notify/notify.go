@@ -0,0 +1,286 @@
+// Package notify sends alerts about backend health to webhooks: generic
+// JSON endpoints, Slack incoming webhooks and PagerDuty. Alerts are sent
+// in the background, so raising one never holds up the caller, and each
+// webhook gets its alerts in the order they were raised.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// pagerDutyURL is the Events API v2 endpoint
+const pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// sendTimeout bounds one delivery
+const sendTimeout = 10 * time.Second
+
+// Event is one alert. Kind is one of config.NotificationEvents; Backend is
+// set for backend_down and backend_up, and Client for honeypot_hit.
+type Event struct {
+	Kind    string
+	Backend string
+	Client  string
+	Message string
+	Time    time.Time
+}
+
+// raises tells the kinds that raise a problem from those that clear one
+var raises = map[string]bool{
+	"backend_down":        true,
+	"no_healthy_backends": true,
+	"failover":            true,
+	"honeypot_hit":        true,
+}
+
+// subject is what the event is about. Alerts about the same subject
+// dedup each other, and PagerDuty resolves the incident its raising alert
+// opened.
+func (e Event) subject() string {
+	switch {
+	case e.Backend != "":
+		return "backend/" + e.Backend
+	case e.Client != "":
+		return "client/" + e.Client
+	case e.Kind == "failover" || e.Kind == "failback":
+		return "failover"
+	default:
+		return "backends"
+	}
+}
+
+// Notifier sends events to the configured webhooks
+type Notifier struct {
+	hooks  []*webhook
+	dedup  time.Duration
+	client *http.Client
+	source string
+	now    func() time.Time
+
+	mu   sync.Mutex
+	last map[string]Event
+	wg   sync.WaitGroup
+}
+
+type webhook struct {
+	config.WebhookConfig
+	events map[string]bool
+
+	// Alerts sent in the current minute
+	limit    int
+	minute   time.Time
+	inMinute int
+
+	// Alerts waiting to be sent, oldest first, and whether a goroutine is
+	// sending them
+	queue   []Event
+	sending bool
+}
+
+// New prepares the webhooks of cfg. A Notifier without webhooks drops
+// every event.
+func New(cfg config.NotificationsConfig) *Notifier {
+	if cfg.DedupWindow == 0 {
+		cfg.DedupWindow = 300
+	}
+	if cfg.MaxPerMinute == 0 {
+		cfg.MaxPerMinute = 20
+	}
+	source, err := os.Hostname()
+	if err != nil {
+		source = "gatekeeper"
+	}
+
+	n := &Notifier{
+		dedup:  time.Duration(cfg.DedupWindow) * time.Second,
+		client: &http.Client{Timeout: sendTimeout},
+		source: source,
+		now:    time.Now,
+		last:   make(map[string]Event),
+	}
+	for _, hook := range cfg.Webhooks {
+		if hook.Type == "" {
+			hook.Type = "generic"
+		}
+		if hook.Type == "pagerduty" && hook.URL == "" {
+			hook.URL = pagerDutyURL
+		}
+		w := &webhook{WebhookConfig: hook, limit: cfg.MaxPerMinute}
+		if len(hook.Events) > 0 {
+			w.events = make(map[string]bool)
+			for _, event := range hook.Events {
+				w.events[event] = true
+			}
+		}
+		n.hooks = append(n.hooks, w)
+	}
+	return n
+}
+
+// Notify sends event to every webhook that takes it, unless it repeats
+// the last alert about the same subject within the dedup window or a
+// webhook has had its fill of alerts this minute
+func (n *Notifier) Notify(event Event) {
+	if len(n.hooks) == 0 {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = n.now()
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	subject := event.subject()
+	if last, ok := n.last[subject]; ok && last.Kind == event.Kind && event.Time.Sub(last.Time) < n.dedup {
+		logger.Debug("Dropped repeated %s alert for %s", event.Kind, subject)
+		return
+	}
+	n.last[subject] = event
+
+	for _, hook := range n.hooks {
+		if hook.events != nil && !hook.events[event.Kind] {
+			continue
+		}
+		if !hook.allow(event.Time) {
+			logger.Warn("Webhook %s is over its alerts per minute, dropped %s", hook.Name, event.Kind)
+			metrics.RecordNotification(hook.Name, "throttled")
+			continue
+		}
+		hook.queue = append(hook.queue, event)
+		if !hook.sending {
+			hook.sending = true
+			n.wg.Add(1)
+			go n.deliver(hook)
+		}
+	}
+}
+
+// deliver sends hook's queued alerts one at a time, so a resolving alert
+// never overtakes the one that raised the problem
+func (n *Notifier) deliver(hook *webhook) {
+	defer n.wg.Done()
+	for {
+		n.mu.Lock()
+		if len(hook.queue) == 0 {
+			hook.sending = false
+			n.mu.Unlock()
+			return
+		}
+		event := hook.queue[0]
+		hook.queue = hook.queue[1:]
+		n.mu.Unlock()
+		n.send(hook, event)
+	}
+}
+
+// Wait blocks until the alerts being sent are delivered or have failed
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+// allow counts an alert against the hook's limit for the minute of now
+func (w *webhook) allow(now time.Time) bool {
+	if minute := now.Truncate(time.Minute); !minute.Equal(w.minute) {
+		w.minute = minute
+		w.inMinute = 0
+	}
+	if w.inMinute >= w.limit {
+		return false
+	}
+	w.inMinute++
+	return true
+}
+
+func (n *Notifier) send(hook *webhook, event Event) {
+	body, err := json.Marshal(n.payload(hook, event))
+	if err != nil {
+		logger.Error("Failed to encode %s alert for webhook %s: %v", event.Kind, hook.Name, err)
+		metrics.RecordNotification(hook.Name, "failed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		logger.Error("Failed to create request for webhook %s: %v", hook.Name, err)
+		metrics.RecordNotification(hook.Name, "failed")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range hook.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := n.client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("status %s", resp.Status)
+		}
+	}
+	if err != nil {
+		logger.Warn("Failed to send %s alert to webhook %s: %v", event.Kind, hook.Name, err)
+		metrics.RecordNotification(hook.Name, "failed")
+		return
+	}
+	metrics.RecordNotification(hook.Name, "sent")
+}
+
+// payload is the body hook's type expects for event
+func (n *Notifier) payload(hook *webhook, event Event) interface{} {
+	switch hook.Type {
+	case "slack":
+		icon := ":white_check_mark:"
+		if raises[event.Kind] {
+			icon = ":rotating_light:"
+		}
+		return map[string]string{"text": fmt.Sprintf("%s GateKeeper on %s: %s", icon, n.source, event.Message)}
+	case "pagerduty":
+		action, severity := "resolve", "info"
+		if raises[event.Kind] {
+			action, severity = "trigger", "error"
+			switch event.Kind {
+			case "no_healthy_backends":
+				severity = "critical"
+			case "honeypot_hit":
+				severity = "warning"
+			}
+		}
+		return map[string]interface{}{
+			"routing_key":  hook.RoutingKey,
+			"event_action": action,
+			"dedup_key":    "gatekeeper/" + n.source + "/" + event.subject(),
+			"payload": map[string]interface{}{
+				"summary":   event.Message,
+				"source":    n.source,
+				"severity":  severity,
+				"timestamp": event.Time.UTC().Format(time.RFC3339),
+				"component": event.Backend + event.Client,
+				"class":     event.Kind,
+			},
+		}
+	default:
+		body := map[string]string{
+			"event":   event.Kind,
+			"backend": event.Backend,
+			"message": event.Message,
+			"source":  n.source,
+			"time":    event.Time.UTC().Format(time.RFC3339),
+		}
+		if event.Client != "" {
+			body["client"] = event.Client
+		}
+		return body
+	}
+}
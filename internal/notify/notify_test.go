@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// receiver records the JSON bodies posted to it
+type receiver struct {
+	mu     sync.Mutex
+	bodies []map[string]interface{}
+	header http.Header
+}
+
+func newReceiver(t *testing.T) (*receiver, string) {
+	rcv := &receiver{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		rcv.mu.Lock()
+		rcv.bodies = append(rcv.bodies, body)
+		rcv.header = r.Header.Clone()
+		rcv.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(srv.Close)
+	return rcv, srv.URL
+}
+
+func (rcv *receiver) received() []map[string]interface{} {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return rcv.bodies
+}
+
+func TestWebhookTypes(t *testing.T) {
+	generic, genericURL := newReceiver(t)
+	slack, slackURL := newReceiver(t)
+	pagerDuty, pagerDutyURL := newReceiver(t)
+	n := New(config.NotificationsConfig{Webhooks: []config.WebhookConfig{
+		{Name: "ops", URL: genericURL, Headers: map[string]string{"Authorization": "Bearer s3cret"}},
+		{Name: "chat", Type: "slack", URL: slackURL},
+		{Name: "oncall", Type: "pagerduty", URL: pagerDutyURL, RoutingKey: "rk", Events: []string{"backend_down", "backend_up"}},
+	}})
+
+	n.Notify(Event{Kind: "backend_down", Backend: "api-1", Message: "Backend api-1 is down: status 503"})
+	n.Notify(Event{Kind: "no_healthy_backends", Message: "No healthy backends are left"})
+	n.Notify(Event{Kind: "backend_up", Backend: "api-1", Message: "Backend api-1 is healthy again"})
+	n.Wait()
+
+	if got := generic.received(); len(got) != 3 || got[0]["event"] != "backend_down" || got[0]["backend"] != "api-1" {
+		t.Errorf("Expected 3 alerts starting with backend_down for api-1, got %v", got)
+	}
+	if got := generic.header.Get("Authorization"); got != "Bearer s3cret" {
+		t.Errorf("Expected the configured Authorization header, got %q", got)
+	}
+	if got := slack.received(); len(got) != 3 || got[0]["text"] == nil {
+		t.Errorf("Expected 3 Slack messages, got %v", got)
+	}
+
+	// PagerDuty only takes the backend events, and resolves what it raised
+	got := pagerDuty.received()
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 PagerDuty events, got %v", got)
+	}
+	for _, body := range got {
+		if body["routing_key"] != "rk" {
+			t.Errorf("Expected routing key rk, got %v", body["routing_key"])
+		}
+	}
+	if got[0]["event_action"] != "trigger" || got[1]["event_action"] != "resolve" || got[0]["dedup_key"] != got[1]["dedup_key"] {
+		t.Errorf("Expected a trigger then a resolve with the same dedup key, got %v", got)
+	}
+	if got := generic.received(); len(got) == 3 {
+		for i, want := range []string{"backend_down", "no_healthy_backends", "backend_up"} {
+			if got[i]["event"] != want {
+				t.Errorf("Expected alert %d to be %s, got %v", i+1, want, got[i]["event"])
+			}
+		}
+	}
+}
+
+func TestDedupAndThrottle(t *testing.T) {
+	rcv, url := newReceiver(t)
+	n := New(config.NotificationsConfig{
+		Webhooks:     []config.WebhookConfig{{Name: "ops", URL: url}},
+		DedupWindow:  60,
+		MaxPerMinute: 3,
+	})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+
+	// A repeat within the window is dropped
+	n.Notify(Event{Kind: "backend_down", Backend: "api"})
+	n.Notify(Event{Kind: "backend_down", Backend: "api"})
+	n.Wait()
+	if got := len(rcv.received()); got != 1 {
+		t.Fatalf("Expected the repeated alert to be dropped, got %d alerts", got)
+	}
+
+	// Flapping runs into the limit for the minute
+	n.Notify(Event{Kind: "backend_up", Backend: "api"})
+	n.Notify(Event{Kind: "backend_down", Backend: "api"})
+	n.Notify(Event{Kind: "backend_up", Backend: "api"})
+	n.Wait()
+	if got := len(rcv.received()); got != 3 {
+		t.Fatalf("Expected 3 alerts in the minute, got %d", got)
+	}
+
+	now = now.Add(time.Minute)
+	n.Notify(Event{Kind: "backend_down", Backend: "api"})
+	n.Wait()
+	if got := len(rcv.received()); got != 4 {
+		t.Errorf("Expected alerts again the next minute, got %d", got)
+	}
+}
@@ -0,0 +1,164 @@
+package oauthtoken
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCacheEntries bounds memory use when many distinct tokens are
+	// exchanged
+	maxCacheEntries = 10000
+
+	// refreshEarly keeps a token from being handed out just before it
+	// expires on its way to the backend
+	refreshEarly = 30 * time.Second
+
+	grantClientCredentials = "client_credentials"
+	grantTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+	typeAccessToken        = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// response is the subset of a token endpoint response the gateway uses
+type response struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type cacheEntry struct {
+	token   string
+	expires time.Time
+}
+
+// Client gets access tokens from an OAuth2 token endpoint and caches them
+// until shortly before they expire. Cache keys are hashes so exchanged
+// tokens are not kept in memory as keys.
+type Client struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	http         *http.Client
+	now          func() time.Time
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]cacheEntry
+}
+
+func NewClient(endpoint, clientID, clientSecret string, scopes []string, timeout time.Duration) *Client {
+	return &Client{
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+		http:         &http.Client{Timeout: timeout},
+		now:          time.Now,
+		cache:        make(map[[sha256.Size]byte]cacheEntry),
+	}
+}
+
+// ClientCredentials returns the gateway's own token for audience
+func (c *Client) ClientCredentials(ctx context.Context, audience string) (string, error) {
+	return c.token(ctx, url.Values{
+		"grant_type": {grantClientCredentials},
+		"audience":   {audience},
+	})
+}
+
+// Exchange returns a token for audience that acts for the holder of
+// subjectToken (RFC 8693)
+func (c *Client) Exchange(ctx context.Context, subjectToken, audience string) (string, error) {
+	return c.token(ctx, url.Values{
+		"grant_type":           {grantTokenExchange},
+		"subject_token":        {subjectToken},
+		"subject_token_type":   {typeAccessToken},
+		"requested_token_type": {typeAccessToken},
+		"audience":             {audience},
+	})
+}
+
+func (c *Client) token(ctx context.Context, form url.Values) (string, error) {
+	if len(c.scopes) > 0 {
+		form.Set("scope", strings.Join(c.scopes, " "))
+	}
+	key := sha256.Sum256([]byte(form.Encode()))
+	now := c.now()
+
+	c.mu.Lock()
+	entry, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.token, nil
+	}
+
+	result, err := c.fetch(ctx, form)
+	if err != nil {
+		return "", err
+	}
+	if expires := now.Add(time.Duration(result.ExpiresIn)*time.Second - refreshEarly); expires.After(now) {
+		c.store(key, cacheEntry{token: result.AccessToken, expires: expires}, now)
+	}
+	return result.AccessToken, nil
+}
+
+func (c *Client) fetch(ctx context.Context, form url.Values) (*response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint: status %d", resp.StatusCode)
+	}
+
+	var result response
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("token endpoint: %w", err)
+	}
+	if result.AccessToken == "" {
+		return nil, errors.New("token endpoint: no access_token in response")
+	}
+	return &result, nil
+}
+
+func (c *Client) store(key [sha256.Size]byte, entry cacheEntry, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.cache) >= maxCacheEntries {
+		for k, e := range c.cache {
+			if !now.Before(e.expires) {
+				delete(c.cache, k)
+			}
+		}
+		// Still full: start over rather than grow without bound
+		if len(c.cache) >= maxCacheEntries {
+			c.cache = make(map[[sha256.Size]byte]cacheEntry)
+		}
+	}
+	c.cache[key] = entry
+}
@@ -0,0 +1,65 @@
+package oauthtoken
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokens(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if user, pass, _ := r.BasicAuth(); user != "gateway" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.PostFormValue("scope") != "orders:read" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.PostFormValue("grant_type") {
+		case grantClientCredentials:
+			fmt.Fprintf(w, `{"access_token":"gateway-for-%s","expires_in":3600}`, r.PostFormValue("audience"))
+		case grantTokenExchange:
+			if r.PostFormValue("subject_token") == "revoked" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"access_token":"%s-for-%s","expires_in":10}`, r.PostFormValue("subject_token"), r.PostFormValue("audience"))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "gateway", "secret", []string{"orders:read"}, time.Second)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		token, err := client.ClientCredentials(ctx, "orders")
+		if err != nil || token != "gateway-for-orders" {
+			t.Fatalf("Expected the gateway's token for orders, got %q %v", token, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected the client credentials token to be cached, got %d calls", calls)
+	}
+
+	for i := 0; i < 2; i++ {
+		token, err := client.Exchange(ctx, "alice", "orders")
+		if err != nil || token != "alice-for-orders" {
+			t.Fatalf("Expected alice's token for orders, got %q %v", token, err)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("Expected a token about to expire not to be cached, got %d calls", calls)
+	}
+
+	if _, err := client.Exchange(ctx, "revoked", "orders"); err == nil {
+		t.Error("Expected a refused exchange to fail")
+	}
+}
@@ -0,0 +1,217 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/jwt"
+)
+
+// jwksRefreshInterval limits how often an unknown kid triggers a refetch
+const jwksRefreshInterval = time.Minute
+
+// Provider is an OpenID Connect identity provider discovered from its
+// issuer URL.
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+
+	client    *http.Client
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	lastFetch time.Time
+}
+
+// Token is the token endpoint response
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	IDToken      string `json:"id_token"`
+}
+
+// Discover fetches the provider metadata from the issuer's
+// .well-known/openid-configuration document.
+func Discover(ctx context.Context, issuer string, client *http.Client) (*Provider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	data, err := get(ctx, client, wellKnown)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+
+	p := &Provider{client: client}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+
+	if strings.TrimSuffix(p.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch, got %q", p.Issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: metadata is missing required endpoints")
+	}
+
+	return p, nil
+}
+
+// AuthCodeURL builds the authorization request the browser is redirected to
+func (p *Provider) AuthCodeURL(clientID, redirectURL, state, nonce string, scopes []string) string {
+	values := url.Values{
+		"response_type": {"code"},
+		"client_id":     {clientID},
+		"redirect_uri":  {redirectURL},
+		"scope":         {strings.Join(scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.AuthorizationEndpoint + sep + values.Encode()
+}
+
+// Exchange trades an authorization code for tokens
+func (p *Provider) Exchange(ctx context.Context, clientID, clientSecret, redirectURL, code string) (*Token, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURL},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token exchange: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc token exchange: status %d: %s", resp.StatusCode, body)
+	}
+
+	var token Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("oidc token exchange: %w", err)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("oidc token exchange: response has no id_token")
+	}
+	return &token, nil
+}
+
+// VerifyIDToken checks the ID token signature against the provider's JWKS
+// and validates issuer and audience.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, clientID string) (jwt.Claims, error) {
+	claims, err := jwt.Parse(raw, func(h jwt.Header) (interface{}, error) {
+		if strings.HasPrefix(h.Alg, "HS") || h.Alg == "none" {
+			return nil, fmt.Errorf("oidc: id token algorithm %q not allowed", h.Alg)
+		}
+		return p.key(ctx, h.Kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.String("iss") != p.Issuer {
+		return nil, fmt.Errorf("oidc: unexpected issuer %q", claims.String("iss"))
+	}
+	if !claims.HasAudience(clientID) {
+		return nil, errors.New("oidc: id token was not issued for this client")
+	}
+	if _, ok := claims.Time("exp"); !ok {
+		return nil, errors.New("oidc: id token has no expiry")
+	}
+
+	return claims, nil
+}
+
+func (p *Provider) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupLocked(kid); ok {
+		return key, nil
+	}
+
+	// Unknown kid usually means the provider rotated keys
+	if time.Since(p.lastFetch) < jwksRefreshInterval && p.keys != nil {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+
+	data, err := get(ctx, p.client, p.JWKSURI)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: fetching JWKS: %w", err)
+	}
+	keys, err := jwt.ParseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	p.lastFetch = time.Now()
+
+	if key, ok := p.lookupLocked(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+}
+
+func (p *Provider) lookupLocked(kid string) (interface{}, bool) {
+	if key, ok := p.keys[kid]; ok {
+		return key, true
+	}
+	// Providers with a single key sometimes omit kid from the token
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func get(ctx context.Context, client *http.Client, target string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: status %d", target, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
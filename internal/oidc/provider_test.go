@@ -0,0 +1,87 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/jwt"
+)
+
+func newTestIssuer(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	return server
+}
+
+func TestDiscoverAndVerify(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	issuer := newTestIssuer(t, key)
+
+	provider, err := Discover(context.Background(), issuer.URL, nil)
+	if err != nil {
+		t.Fatalf("Discovery failed: %v", err)
+	}
+
+	if got := provider.AuthCodeURL("client", "https://gw/cb", "s", "n", []string{"openid"}); got[:len(issuer.URL)+10] != issuer.URL+"/authorize" {
+		t.Errorf("Unexpected auth URL: %v", got)
+	}
+
+	exp := time.Now().Add(time.Minute).Unix()
+	good, _ := jwt.Sign(jwt.Claims{"iss": issuer.URL, "aud": "client", "sub": "alice", "exp": exp}, "RS256", "k1", key)
+	claims, err := provider.VerifyIDToken(context.Background(), good, "client")
+	if err != nil {
+		t.Fatalf("Expected valid ID token, got %v", err)
+	}
+	if claims.String("sub") != "alice" {
+		t.Errorf("Expected sub alice, got %v", claims.String("sub"))
+	}
+
+	wrongAudience, _ := jwt.Sign(jwt.Claims{"iss": issuer.URL, "aud": "other", "exp": exp}, "RS256", "k1", key)
+	if _, err := provider.VerifyIDToken(context.Background(), wrongAudience, "client"); err == nil {
+		t.Error("Expected token for another client to be rejected")
+	}
+
+	hmacToken, _ := jwt.Sign(jwt.Claims{"iss": issuer.URL, "aud": "client", "exp": exp}, "HS256", "k1", []byte("x"))
+	if _, err := provider.VerifyIDToken(context.Background(), hmacToken, "client"); err == nil {
+		t.Error("Expected HMAC-signed ID token to be rejected")
+	}
+}
+
+func TestDiscoverUnknownIssuer(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	issuer := newTestIssuer(t, key)
+
+	if _, err := Discover(context.Background(), issuer.URL+"/other", nil); err == nil {
+		t.Error("Expected discovery of an unknown issuer to fail")
+	}
+}
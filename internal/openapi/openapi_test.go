@@ -0,0 +1,256 @@
+package openapi
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const petstore = `
+openapi: 3.0.3
+servers:
+  - url: https://api.example.com/v1/
+paths:
+  /pets:
+    get:
+      operationId: listPets
+      parameters:
+        - name: limit
+          in: query
+          schema: {type: integer, minimum: 1, maximum: 100}
+        - name: tags
+          in: query
+          schema: {type: array, items: {type: string}}
+    post:
+      operationId: createPet
+      requestBody:
+        $ref: '#/components/requestBodies/Pet'
+  /pets/{petId}:
+    parameters:
+      - $ref: '#/components/parameters/PetID'
+    get:
+      operationId: showPet
+      parameters:
+        - name: X-Trace
+          in: header
+          required: true
+          schema: {type: string, pattern: '^[a-f0-9]+$'}
+components:
+  parameters:
+    PetID:
+      name: petId
+      in: path
+      required: true
+      schema: {type: integer}
+  requestBodies:
+    Pet:
+      required: true
+      content:
+        application/json:
+          schema:
+            $ref: '#/components/schemas/Pet'
+  schemas:
+    Pet:
+      type: object
+      required: [name]
+      additionalProperties: false
+      properties:
+        name: {type: string, minLength: 1}
+        status: {type: string, enum: [available, sold]}
+        age: {type: integer, nullable: true}
+        parent:
+          $ref: '#/components/schemas/Pet'
+`
+
+func findOperation(t *testing.T, spec *Spec, id string) *Operation {
+	t.Helper()
+	for _, op := range spec.Operations {
+		if op.ID == id {
+			return op
+		}
+	}
+	t.Fatalf("operation %s not found", id)
+	return nil
+}
+
+func TestParse(t *testing.T) {
+	spec, err := Parse([]byte(petstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.BasePath != "/v1" {
+		t.Errorf("Expected base path /v1, got %q", spec.BasePath)
+	}
+	if len(spec.Operations) != 3 {
+		t.Fatalf("Expected 3 operations, got %d", len(spec.Operations))
+	}
+
+	show := findOperation(t, spec, "showPet")
+	if show.Method != "GET" || show.Path != "/pets/{petId}" {
+		t.Errorf("Unexpected operation %s %s", show.Method, show.Path)
+	}
+	if len(show.Parameters) != 2 || show.Parameters[0].Name != "petId" {
+		t.Errorf("Expected path item and operation parameters, got %+v", show.Parameters)
+	}
+
+	pet := findOperation(t, spec, "createPet").RequestBody.Content["application/json"].Schema
+	if pet.Properties["parent"] != pet {
+		t.Error("Expected recursive reference to resolve to the same schema")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		spec string
+	}{
+		{"swagger 2", "swagger: '2.0'\npaths: {}"},
+		{"unresolved ref", "openapi: 3.0.0\npaths:\n  /a:\n    post:\n      requestBody:\n        content:\n          application/json:\n            schema: {$ref: '#/components/schemas/Missing'}"},
+		{"external ref", "openapi: 3.0.0\npaths:\n  /a:\n    get:\n      parameters:\n        - $ref: 'common.yaml#/Limit'"},
+		{"bad pattern", "openapi: 3.0.0\npaths:\n  /a:\n    get:\n      parameters:\n        - {name: q, in: query, schema: {type: string, pattern: '('}}"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tc.spec)); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	spec, err := Parse([]byte(petstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, show := findOperation(t, spec, "listPets"), findOperation(t, spec, "showPet")
+
+	testCases := []struct {
+		name     string
+		op       *Operation
+		target   string
+		header   string
+		path     map[string]string
+		problems []string
+	}{
+		{"valid query", list, "/v1/pets?limit=10&tags=a&tags=b", "", nil, nil},
+		{"no query", list, "/v1/pets", "", nil, nil},
+		{"not a number", list, "/v1/pets?limit=ten", "", nil, []string{"query parameter limit: must be a number"}},
+		{"not an integer", list, "/v1/pets?limit=1.5", "", nil, []string{"query parameter limit: must be integer"}},
+		{"too large", list, "/v1/pets?limit=500", "", nil, []string{"query parameter limit: must be at most 100"}},
+		{"valid path", show, "/v1/pets/7", "abc", map[string]string{"petId": "7"}, nil},
+		{"bad path", show, "/v1/pets/x", "abc", map[string]string{"petId": "x"}, []string{"path parameter petId: must be a number"}},
+		{"missing header", show, "/v1/pets/7", "", map[string]string{"petId": "7"}, []string{"header parameter X-Trace: is required"}},
+		{"bad header", show, "/v1/pets/7", "xyz", map[string]string{"petId": "7"}, []string{"header parameter X-Trace: must match ^[a-f0-9]+$"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.target, nil)
+			if tc.header != "" {
+				req.Header.Set("X-Trace", tc.header)
+			}
+			problems := tc.op.Validate(req, tc.path, nil)
+			if strings.Join(problems, "|") != strings.Join(tc.problems, "|") {
+				t.Errorf("Expected %q, got %q", tc.problems, problems)
+			}
+		})
+	}
+}
+
+func TestValidateBody(t *testing.T) {
+	spec, err := Parse([]byte(petstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := findOperation(t, spec, "createPet")
+
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		problems    []string
+	}{
+		{"valid", "application/json", `{"name":"Rex","status":"sold","age":null,"parent":{"name":"Max"}}`, nil},
+		{"missing body", "application/json", "", []string{"body: is required"}},
+		{"wrong content type", "text/plain", "Rex", []string{`body: content type "text/plain" is not accepted`}},
+		{"invalid json", "application/json", `{"name":`, []string{"body: invalid JSON: unexpected EOF"}},
+		{"missing field", "application/json", `{"status":"sold"}`, []string{"body.name: is required"}},
+		{"wrong type", "application/json", `{"name":5}`, []string{"body.name: must be string"}},
+		{"not in enum", "application/json", `{"name":"Rex","status":"lost"}`, []string{"body.status: must be one of available, sold"}},
+		{"unknown field", "application/json", `{"name":"Rex","color":"red"}`, []string{"body.color: is not allowed"}},
+		{"nested", "application/json", `{"name":"Rex","parent":{"name":""}}`, []string{"body.parent.name: must be at least 1 characters"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/pets", nil)
+			req.Header.Set("Content-Type", tc.contentType)
+			problems := create.Validate(req, nil, []byte(tc.body))
+			if strings.Join(problems, "|") != strings.Join(tc.problems, "|") {
+				t.Errorf("Expected %q, got %q", tc.problems, problems)
+			}
+		})
+	}
+}
+
+func TestValidateOpenAPI31Schemas(t *testing.T) {
+	spec, err := Parse([]byte(`
+openapi: 3.1.0
+paths:
+  /orders:
+    post:
+      requestBody:
+        content:
+          application/json:
+            schema:
+              type: object
+              properties:
+                quantity: {type: integer, exclusiveMinimum: 0}
+                note: {type: [string, "null"]}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := spec.Operations[0]
+
+	req := httptest.NewRequest("POST", "/orders", nil)
+	req.Header.Set("Content-Type", "application/json")
+	if problems := op.Validate(req, nil, []byte(`{"quantity":2,"note":null}`)); len(problems) != 0 {
+		t.Errorf("Expected valid body, got %q", problems)
+	}
+	problems := op.Validate(req, nil, []byte(`{"quantity":0}`))
+	if len(problems) != 1 || problems[0] != "body.quantity: must be greater than 0" {
+		t.Errorf("Unexpected problems %q", problems)
+	}
+}
+
+func TestParseSchema(t *testing.T) {
+	schema, err := ParseSchema([]byte(`{
+		"$defs": {"tag": {"type": "string", "maxLength": 3}},
+		"type": "object",
+		"properties": {
+			"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}},
+			"child": {"$ref": "#"}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if problems := schema.ValidateJSON([]byte(`{"tags":["a"],"child":{"tags":["b"]}}`)); len(problems) != 0 {
+		t.Errorf("Expected valid document, got %q", problems)
+	}
+	problems := schema.ValidateJSON([]byte(`{"child":{"tags":["long"]}}`))
+	if len(problems) != 1 || problems[0] != "body.child.tags[0]: must be at most 3 characters" {
+		t.Errorf("Unexpected problems %q", problems)
+	}
+	if problems := schema.ValidateJSON([]byte(`{} {}`)); len(problems) != 1 {
+		t.Errorf("Expected trailing data to be rejected, got %q", problems)
+	}
+
+	if _, err := ParseSchema([]byte(`{"$ref": "#/$defs/missing"}`)); err == nil {
+		t.Error("Expected error for unresolved reference")
+	}
+}
@@ -0,0 +1,58 @@
+package openapi
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// standalone is a JSON Schema document with its named subschemas
+type standalone struct {
+	Schema      `yaml:",inline"`
+	Defs        map[string]*Schema `yaml:"$defs"`
+	Definitions map[string]*Schema `yaml:"definitions"`
+}
+
+// LoadSchema reads a JSON Schema file (JSON or YAML). References may point
+// at the document itself ("#") or at its "$defs" or "definitions".
+func LoadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := ParseSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("schema %s: %w", path, err)
+	}
+	return schema, nil
+}
+
+// ParseSchema reads a JSON Schema document
+func ParseSchema(data []byte) (*Schema, error) {
+	var doc standalone
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	root := &doc.Schema
+	r := &resolver{done: make(map[*Schema]bool), lookup: func(ref string) (*Schema, bool) {
+		if ref == "#" {
+			return root, true
+		}
+		if name, ok := strings.CutPrefix(ref, "#/$defs/"); ok {
+			schema, ok := doc.Defs[name]
+			return schema, ok
+		}
+		if name, ok := strings.CutPrefix(ref, "#/definitions/"); ok {
+			schema, ok := doc.Definitions[name]
+			return schema, ok
+		}
+		return nil, false
+	}}
+	if err := r.schema(&root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
@@ -0,0 +1,345 @@
+// Package openapi reads OpenAPI 3 specs so the gateway can route each
+// operation and check requests against its parameters and JSON body schema.
+// Standalone JSON Schema files are checked with the same validator.
+//
+// Only the parts of the spec used for routing and validation are read.
+// References must be local ("#/components/...").
+package openapi
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is a parsed OpenAPI document
+type Spec struct {
+	// BasePath is the path of the first server URL, if any
+	BasePath   string
+	Operations []*Operation
+}
+
+// Operation is one method on one path of the spec
+type Operation struct {
+	ID          string
+	Method      string
+	Path        string
+	Parameters  []*Parameter
+	RequestBody *RequestBody
+}
+
+type Parameter struct {
+	Ref      string  `yaml:"$ref"`
+	Name     string  `yaml:"name"`
+	In       string  `yaml:"in"`
+	Required bool    `yaml:"required"`
+	Explode  *bool   `yaml:"explode"`
+	Schema   *Schema `yaml:"schema"`
+}
+
+type RequestBody struct {
+	Ref      string               `yaml:"$ref"`
+	Required bool                 `yaml:"required"`
+	Content  map[string]MediaType `yaml:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `yaml:"schema"`
+}
+
+// Schema is the subset of JSON Schema that requests are checked against.
+// Both OpenAPI 3.0 (nullable, boolean exclusive bounds) and 3.1 (type
+// lists, numeric exclusive bounds) forms are read.
+type Schema struct {
+	Ref                  string             `yaml:"$ref"`
+	Type                 Types              `yaml:"type"`
+	Nullable             bool               `yaml:"nullable"`
+	Enum                 []interface{}      `yaml:"enum"`
+	Properties           map[string]*Schema `yaml:"properties"`
+	Required             []string           `yaml:"required"`
+	AdditionalProperties *Additional        `yaml:"additionalProperties"`
+	Items                *Schema            `yaml:"items"`
+	MinItems             *int               `yaml:"minItems"`
+	MaxItems             *int               `yaml:"maxItems"`
+	MinLength            *int               `yaml:"minLength"`
+	MaxLength            *int               `yaml:"maxLength"`
+	Pattern              string             `yaml:"pattern"`
+	Minimum              *float64           `yaml:"minimum"`
+	Maximum              *float64           `yaml:"maximum"`
+	ExclusiveMinimum     Bound              `yaml:"exclusiveMinimum"`
+	ExclusiveMaximum     Bound              `yaml:"exclusiveMaximum"`
+	AllOf                []*Schema          `yaml:"allOf"`
+	AnyOf                []*Schema          `yaml:"anyOf"`
+	OneOf                []*Schema          `yaml:"oneOf"`
+
+	pattern *regexp.Regexp
+}
+
+// Types is a schema's type: one name, or a list of them
+type Types []string
+
+func (t *Types) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*t = Types{node.Value}
+		return nil
+	}
+	return node.Decode((*[]string)(t))
+}
+
+func (t Types) has(name string) bool {
+	for _, n := range t {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Bound is exclusiveMinimum or exclusiveMaximum: a flag on minimum or
+// maximum in OpenAPI 3.0, a number of its own in 3.1
+type Bound struct {
+	Exclusive bool
+	Value     *float64
+}
+
+func (b *Bound) UnmarshalYAML(node *yaml.Node) error {
+	if err := node.Decode(&b.Exclusive); err == nil {
+		return nil
+	}
+	return node.Decode(&b.Value)
+}
+
+// Additional is additionalProperties: false, true or a schema for the
+// properties not listed
+type Additional struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+func (a *Additional) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&a.Allowed)
+	}
+	a.Allowed = true
+	return node.Decode(&a.Schema)
+}
+
+type document struct {
+	OpenAPI string `yaml:"openapi"`
+	Servers []struct {
+		URL string `yaml:"url"`
+	} `yaml:"servers"`
+	Paths      map[string]pathItem `yaml:"paths"`
+	Components struct {
+		Schemas       map[string]*Schema      `yaml:"schemas"`
+		Parameters    map[string]*Parameter   `yaml:"parameters"`
+		RequestBodies map[string]*RequestBody `yaml:"requestBodies"`
+	} `yaml:"components"`
+}
+
+type operation struct {
+	OperationID string       `yaml:"operationId"`
+	Parameters  []*Parameter `yaml:"parameters"`
+	RequestBody *RequestBody `yaml:"requestBody"`
+}
+
+type pathItem struct {
+	Parameters []*Parameter `yaml:"parameters"`
+	Get        *operation   `yaml:"get"`
+	Put        *operation   `yaml:"put"`
+	Post       *operation   `yaml:"post"`
+	Delete     *operation   `yaml:"delete"`
+	Options    *operation   `yaml:"options"`
+	Head       *operation   `yaml:"head"`
+	Patch      *operation   `yaml:"patch"`
+	Trace      *operation   `yaml:"trace"`
+}
+
+func (p pathItem) operations() map[string]*operation {
+	return map[string]*operation{
+		"GET": p.Get, "PUT": p.Put, "POST": p.Post, "DELETE": p.Delete,
+		"OPTIONS": p.Options, "HEAD": p.Head, "PATCH": p.Patch, "TRACE": p.Trace,
+	}
+}
+
+// Load reads a spec in YAML or JSON
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("openapi spec %s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// Parse reads a spec in YAML or JSON
+func Parse(data []byte) (*Spec, error) {
+	var doc document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported openapi version %q, want 3.x", doc.OpenAPI)
+	}
+
+	r := &resolver{doc: &doc, done: make(map[*Schema]bool), lookup: func(ref string) (*Schema, bool) {
+		const prefix = "#/components/schemas/"
+		schema, ok := doc.Components.Schemas[strings.TrimPrefix(ref, prefix)]
+		return schema, ok && strings.HasPrefix(ref, prefix)
+	}}
+	spec := &Spec{}
+	if len(doc.Servers) > 0 {
+		u, err := url.Parse(doc.Servers[0].URL)
+		if err != nil {
+			return nil, fmt.Errorf("server url: %w", err)
+		}
+		spec.BasePath = strings.TrimSuffix(u.Path, "/")
+	}
+
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("path %q must start with /", path)
+		}
+		item := doc.Paths[path]
+		for _, method := range []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "TRACE"} {
+			o := item.operations()[method]
+			if o == nil {
+				continue
+			}
+			op := &Operation{ID: o.OperationID, Method: method, Path: path}
+			params, err := r.parameters(append(append([]*Parameter(nil), item.Parameters...), o.Parameters...))
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", method, path, err)
+			}
+			op.Parameters = params
+			if o.RequestBody != nil {
+				if op.RequestBody, err = r.requestBody(o.RequestBody); err != nil {
+					return nil, fmt.Errorf("%s %s: %w", method, path, err)
+				}
+			}
+			spec.Operations = append(spec.Operations, op)
+		}
+	}
+	return spec, nil
+}
+
+// resolver replaces references with the components they name
+type resolver struct {
+	doc    *document
+	lookup func(ref string) (*Schema, bool)
+	done   map[*Schema]bool
+}
+
+func (r *resolver) parameters(params []*Parameter) ([]*Parameter, error) {
+	// Operation parameters override path item ones with the same name and location
+	byKey := make(map[string]int)
+	var resolved []*Parameter
+	for _, p := range params {
+		if p.Ref != "" {
+			target, ok := r.doc.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+			if !ok || !strings.HasPrefix(p.Ref, "#/components/parameters/") {
+				return nil, fmt.Errorf("unresolved reference %q", p.Ref)
+			}
+			p = target
+		}
+		if p.Name == "" {
+			return nil, fmt.Errorf("parameter without a name")
+		}
+		switch p.In {
+		case "path", "query", "header", "cookie":
+		default:
+			return nil, fmt.Errorf("parameter %s: unknown location %q", p.Name, p.In)
+		}
+		if err := r.schema(&p.Schema); err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", p.Name, err)
+		}
+
+		key := p.In + ":" + p.Name
+		if i, ok := byKey[key]; ok {
+			resolved[i] = p
+			continue
+		}
+		byKey[key] = len(resolved)
+		resolved = append(resolved, p)
+	}
+	return resolved, nil
+}
+
+func (r *resolver) requestBody(body *RequestBody) (*RequestBody, error) {
+	if body.Ref != "" {
+		target, ok := r.doc.Components.RequestBodies[strings.TrimPrefix(body.Ref, "#/components/requestBodies/")]
+		if !ok || !strings.HasPrefix(body.Ref, "#/components/requestBodies/") {
+			return nil, fmt.Errorf("unresolved reference %q", body.Ref)
+		}
+		body = target
+	}
+	for contentType, media := range body.Content {
+		if err := r.schema(&media.Schema); err != nil {
+			return nil, fmt.Errorf("request body %s: %w", contentType, err)
+		}
+		body.Content[contentType] = media
+	}
+	return body, nil
+}
+
+// schema resolves *s in place, following references through any chain of
+// named schemas. Recursive schemas are fine: each schema is visited once.
+func (r *resolver) schema(s **Schema) error {
+	for depth := 0; *s != nil && (*s).Ref != ""; depth++ {
+		target, ok := r.lookup((*s).Ref)
+		if !ok || depth > 32 {
+			return fmt.Errorf("unresolved reference %q", (*s).Ref)
+		}
+		*s = target
+	}
+	if *s == nil || r.done[*s] {
+		return nil
+	}
+	r.done[*s] = true
+
+	schema := *s
+	if schema.Pattern != "" {
+		re, err := regexp.Compile(schema.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern %q: %w", schema.Pattern, err)
+		}
+		schema.pattern = re
+	}
+	for name := range schema.Properties {
+		property := schema.Properties[name]
+		if err := r.schema(&property); err != nil {
+			return err
+		}
+		schema.Properties[name] = property
+	}
+	if schema.AdditionalProperties != nil {
+		if err := r.schema(&schema.AdditionalProperties.Schema); err != nil {
+			return err
+		}
+	}
+	if err := r.schema(&schema.Items); err != nil {
+		return err
+	}
+	for _, list := range [][]*Schema{schema.AllOf, schema.AnyOf, schema.OneOf} {
+		for i := range list {
+			if err := r.schema(&list[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
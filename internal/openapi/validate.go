@@ -0,0 +1,376 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validate checks a request against the operation and returns one message
+// per problem found. pathParams holds the values matched from the path
+// template and body the request body, already read.
+func (op *Operation) Validate(r *http.Request, pathParams map[string]string, body []byte) []string {
+	var problems []string
+	query := r.URL.Query()
+
+	for _, p := range op.Parameters {
+		var values []string
+		switch p.In {
+		case "path":
+			if v, ok := pathParams[p.Name]; ok {
+				values = []string{v}
+			}
+		case "query":
+			values = query[p.Name]
+		case "header":
+			values = r.Header.Values(p.Name)
+		case "cookie":
+			if c, err := r.Cookie(p.Name); err == nil {
+				values = []string{c.Value}
+			}
+		}
+
+		subject := p.In + " parameter " + p.Name
+		if len(values) == 0 {
+			if p.Required || p.In == "path" {
+				problems = append(problems, subject+": is required")
+			}
+			continue
+		}
+		if p.Schema == nil {
+			continue
+		}
+		v, err := p.Schema.parse(values, p.In == "query" && (p.Explode == nil || *p.Explode))
+		if err != nil {
+			problems = append(problems, subject+": "+err.Error())
+			continue
+		}
+		p.Schema.check(v, subject, &problems)
+	}
+
+	if op.RequestBody != nil {
+		problems = append(problems, op.RequestBody.validate(r.Header.Get("Content-Type"), body)...)
+	}
+	return problems
+}
+
+func (b *RequestBody) validate(contentType string, body []byte) []string {
+	if len(body) == 0 {
+		if b.Required {
+			return []string{"body: is required"}
+		}
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return []string{fmt.Sprintf("body: content type %q is not accepted", contentType)}
+	}
+	media, ok := b.media(mediaType)
+	if !ok {
+		return []string{fmt.Sprintf("body: content type %q is not accepted", mediaType)}
+	}
+	if media.Schema == nil || !isJSON(mediaType) {
+		return nil
+	}
+
+	return media.Schema.ValidateJSON(body)
+}
+
+// ValidateJSON checks a JSON document against the schema and returns one
+// message per problem, with paths starting at "body"
+func (s *Schema) ValidateJSON(data []byte) []string {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return []string{"body: invalid JSON: " + err.Error()}
+	}
+	if dec.More() {
+		return []string{"body: invalid JSON: data after the top-level value"}
+	}
+	var problems []string
+	s.check(v, "body", &problems)
+	return problems
+}
+
+// media finds the entry for a media type, trying exact, "type/*" and "*/*"
+func (b *RequestBody) media(mediaType string) (MediaType, bool) {
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, key := range []string{mediaType, major + "/*", "*/*"} {
+		for name, media := range b.Content {
+			if strings.EqualFold(name, key) {
+				return media, true
+			}
+		}
+	}
+	return MediaType{}, false
+}
+
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// parse turns raw parameter values into the JSON value the schema expects
+func (s *Schema) parse(values []string, explode bool) (interface{}, error) {
+	if s.Type.has("array") && s.Items != nil {
+		if !explode && len(values) == 1 {
+			values = strings.Split(values[0], ",")
+		}
+		items := make([]interface{}, len(values))
+		for i, raw := range values {
+			v, err := s.Items.scalar(raw)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = v
+		}
+		return items, nil
+	}
+	if len(values) > 1 {
+		return nil, fmt.Errorf("must be given once")
+	}
+	return s.scalar(values[0])
+}
+
+func (s *Schema) scalar(raw string) (interface{}, error) {
+	switch {
+	case s.Type.has("integer"), s.Type.has("number"):
+		if _, err := strconv.ParseFloat(raw, 64); err != nil {
+			return nil, fmt.Errorf("must be a number")
+		}
+		return json.Number(raw), nil
+	case s.Type.has("boolean"):
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("must be true or false")
+		}
+		return b, nil
+	}
+	return raw, nil
+}
+
+// check appends a message for every way v breaks the schema
+func (s *Schema) check(v interface{}, at string, problems *[]string) {
+	fail := func(format string, args ...interface{}) {
+		*problems = append(*problems, at+": "+fmt.Sprintf(format, args...))
+	}
+
+	for _, sub := range s.AllOf {
+		sub.check(v, at, problems)
+	}
+	if len(s.AnyOf) > 0 && s.matching(s.AnyOf, v) == 0 {
+		fail("does not match any allowed schema")
+	}
+	if len(s.OneOf) > 0 {
+		if n := s.matching(s.OneOf, v); n != 1 {
+			fail("matches %d schemas, want exactly one", n)
+		}
+	}
+
+	if v == nil {
+		if len(s.Type) > 0 && !s.Nullable && !s.Type.has("null") {
+			fail("must not be null")
+		}
+		return
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		fail("must be one of %s", formatEnum(s.Enum))
+	}
+
+	if len(s.Type) > 0 && !s.allowsType(v) {
+		fail("must be %s", strings.Join(s.Type, " or "))
+		return
+	}
+
+	switch v := v.(type) {
+	case string:
+		s.checkString(v, fail)
+	case json.Number:
+		s.checkNumber(v, fail)
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			fail("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.check(item, fmt.Sprintf("%s[%d]", at, i), problems)
+			}
+		}
+	case map[string]interface{}:
+		s.checkObject(v, at, problems)
+	}
+}
+
+func (s *Schema) checkString(v string, fail func(string, ...interface{})) {
+	n := utf8.RuneCountInString(v)
+	if s.MinLength != nil && n < *s.MinLength {
+		fail("must be at least %d characters", *s.MinLength)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		fail("must be at most %d characters", *s.MaxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(v) {
+		fail("must match %s", s.Pattern)
+	}
+}
+
+func (s *Schema) checkNumber(v json.Number, fail func(string, ...interface{})) {
+	f, err := v.Float64()
+	if err != nil {
+		fail("must be a number")
+		return
+	}
+	if s.Minimum != nil {
+		if s.ExclusiveMinimum.Exclusive && f <= *s.Minimum {
+			fail("must be greater than %v", *s.Minimum)
+		} else if f < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+	}
+	if s.Maximum != nil {
+		if s.ExclusiveMaximum.Exclusive && f >= *s.Maximum {
+			fail("must be less than %v", *s.Maximum)
+		} else if f > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+	}
+	if bound := s.ExclusiveMinimum.Value; bound != nil && f <= *bound {
+		fail("must be greater than %v", *bound)
+	}
+	if bound := s.ExclusiveMaximum.Value; bound != nil && f >= *bound {
+		fail("must be less than %v", *bound)
+	}
+}
+
+func (s *Schema) checkObject(v map[string]interface{}, at string, problems *[]string) {
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			*problems = append(*problems, at+"."+name+": is required")
+		}
+	}
+
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if property, ok := s.Properties[name]; ok {
+			property.check(v[name], at+"."+name, problems)
+			continue
+		}
+		if additional := s.AdditionalProperties; additional != nil {
+			if !additional.Allowed {
+				*problems = append(*problems, at+"."+name+": is not allowed")
+			} else if additional.Schema != nil {
+				additional.Schema.check(v[name], at+"."+name, problems)
+			}
+		}
+	}
+}
+
+// matching counts the schemas v satisfies
+func (s *Schema) matching(schemas []*Schema, v interface{}) int {
+	n := 0
+	for _, sub := range schemas {
+		var problems []string
+		sub.check(v, "", &problems)
+		if len(problems) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Schema) allowsType(v interface{}) bool {
+	switch t := jsonType(v); {
+	case s.Type.has(t):
+		return true
+	case t == "integer":
+		return s.Type.has("number")
+	case t == "number":
+		return s.Type.has("integer") && isInteger(v)
+	}
+	return false
+}
+
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "null"
+}
+
+// isInteger accepts numbers such as 1.0 or 1e3 as integers
+func isInteger(v interface{}) bool {
+	n, ok := v.(json.Number)
+	if !ok {
+		return false
+	}
+	f, err := n.Float64()
+	return err == nil && f == math.Trunc(f) && !math.IsInf(f, 0)
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, allowed := range enum {
+		if sameValue(allowed, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameValue compares a value from the spec with one from the request
+func sameValue(spec, v interface{}) bool {
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		if err != nil {
+			return false
+		}
+		switch spec := spec.(type) {
+		case int:
+			return f == float64(spec)
+		case float64:
+			return f == spec
+		}
+		return false
+	}
+	switch v.(type) {
+	case string, bool:
+		return spec == v
+	}
+	return false
+}
+
+func formatEnum(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, v := range enum {
+		values[i] = fmt.Sprint(v)
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,147 @@
+// Package ratelimit asks an external rate limit service whether a request
+// is over a limit. It speaks Envoy's envoy.service.ratelimit.v3
+// RateLimitService API, so the gateway can share limits with an existing
+// Envoy and envoyproxy/ratelimit deployment.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/grpc"
+)
+
+// Entry is one key and value of a descriptor
+type Entry struct {
+	Key   string
+	Value string
+}
+
+// Descriptor is a list of entries the service matches its limits against
+type Descriptor []Entry
+
+// Request asks for a decision on Descriptors in Domain, counting Hits
+// requests against each (one when zero)
+type Request struct {
+	Domain      string
+	Descriptors []Descriptor
+	Hits        uint32
+}
+
+// Limit is the state of the limit closest to running out
+type Limit struct {
+	RequestsPerUnit uint32
+	Unit            string
+	Remaining       uint32
+	ResetIn         time.Duration
+}
+
+// Decision is the service's answer. ResponseHeaders are sent to the client
+// either way and RequestHeaders added to the upstream request when allowed.
+// Body replaces the default one when over the limit.
+type Decision struct {
+	OverLimit       bool
+	Limit           *Limit
+	ResponseHeaders http.Header
+	RequestHeaders  http.Header
+	Body            string
+}
+
+// Client calls a rate limit service
+type Client struct {
+	client  rlsv3.RateLimitServiceClient
+	timeout time.Duration
+}
+
+// New asks the service over conn, giving it timeout to answer each request
+func New(conn grpc.ClientConnInterface, timeout time.Duration) *Client {
+	return &Client{client: rlsv3.NewRateLimitServiceClient(conn), timeout: timeout}
+}
+
+// ShouldRateLimit asks the service whether req is over a limit
+func (c *Client) ShouldRateLimit(ctx context.Context, req *Request) (*Decision, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	resp, err := c.client.ShouldRateLimit(ctx, rateLimitRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	return decision(resp)
+}
+
+func rateLimitRequest(req *Request) *rlsv3.RateLimitRequest {
+	descriptors := make([]*ratelimitv3.RateLimitDescriptor, 0, len(req.Descriptors))
+	for _, descriptor := range req.Descriptors {
+		entries := make([]*ratelimitv3.RateLimitDescriptor_Entry, 0, len(descriptor))
+		for _, entry := range descriptor {
+			entries = append(entries, &ratelimitv3.RateLimitDescriptor_Entry{Key: entry.Key, Value: entry.Value})
+		}
+		descriptors = append(descriptors, &ratelimitv3.RateLimitDescriptor{Entries: entries})
+	}
+	return &rlsv3.RateLimitRequest{Domain: req.Domain, Descriptors: descriptors, HitsAddend: req.Hits}
+}
+
+func decision(resp *rlsv3.RateLimitResponse) (*Decision, error) {
+	d := &Decision{ResponseHeaders: http.Header{}, RequestHeaders: http.Header{}, Body: string(resp.GetRawBody())}
+	switch resp.GetOverallCode() {
+	case rlsv3.RateLimitResponse_OK:
+	case rlsv3.RateLimitResponse_OVER_LIMIT:
+		d.OverLimit = true
+	case rlsv3.RateLimitResponse_UNKNOWN:
+		return nil, errors.New("ratelimit: response has no code")
+	default:
+		return nil, errors.New("ratelimit: unknown response code")
+	}
+	for _, status := range resp.GetStatuses() {
+		d.Limit = tighter(d.Limit, limit(status))
+	}
+	addHeaders(d.ResponseHeaders, resp.GetResponseHeadersToAdd())
+	addHeaders(d.RequestHeaders, resp.GetRequestHeadersToAdd())
+	return d, nil
+}
+
+// limit reads a descriptor's status. A status without a limit, which
+// matched none of the service's rules, is nil.
+func limit(status *rlsv3.RateLimitResponse_DescriptorStatus) *Limit {
+	current := status.GetCurrentLimit()
+	if current == nil {
+		return nil
+	}
+	return &Limit{
+		RequestsPerUnit: current.GetRequestsPerUnit(),
+		Unit:            strings.ToLower(current.GetUnit().String()),
+		Remaining:       status.GetLimitRemaining(),
+		ResetIn:         status.GetDurationUntilReset().AsDuration(),
+	}
+}
+
+// tighter returns whichever limit has fewer requests left, the one that
+// resets later on a tie
+func tighter(a, b *Limit) *Limit {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case b.Remaining < a.Remaining, b.Remaining == a.Remaining && b.ResetIn > a.ResetIn:
+		return b
+	}
+	return a
+}
+
+func addHeaders(headers http.Header, values []*corev3.HeaderValue) {
+	for _, h := range values {
+		if h.GetKey() != "" {
+			headers.Add(h.GetKey(), h.GetValue())
+		}
+	}
+}
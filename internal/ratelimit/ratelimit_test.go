@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestRateLimitRequest(t *testing.T) {
+	msg := rateLimitRequest(&Request{
+		Domain: "gatekeeper",
+		Descriptors: []Descriptor{
+			{{Key: "remote_address", Value: "10.0.0.1"}},
+			{{Key: "consumer", Value: "hmac:shop"}, {Key: "path", Value: "/search"}},
+		},
+		Hits: 10,
+	})
+
+	if msg.GetDomain() != "gatekeeper" || msg.GetHitsAddend() != 10 || len(msg.GetDescriptors()) != 2 {
+		t.Fatalf("Unexpected request: %v", msg)
+	}
+	entries := msg.GetDescriptors()[1].GetEntries()
+	if len(entries) != 2 || entries[0].GetKey() != "consumer" || entries[0].GetValue() != "hmac:shop" || entries[1].GetKey() != "path" {
+		t.Errorf("Unexpected descriptor entries: %v", entries)
+	}
+}
+
+// overLimitResponse is a RateLimitResponse over a limit of 10 a minute
+// resetting in 30s, with one descriptor that matched no rule
+func overLimitResponse() *rlsv3.RateLimitResponse {
+	return &rlsv3.RateLimitResponse{
+		OverallCode: rlsv3.RateLimitResponse_OVER_LIMIT,
+		Statuses: []*rlsv3.RateLimitResponse_DescriptorStatus{
+			{Code: rlsv3.RateLimitResponse_OK},
+			{
+				Code:               rlsv3.RateLimitResponse_OVER_LIMIT,
+				CurrentLimit:       &rlsv3.RateLimitResponse_RateLimit{RequestsPerUnit: 10, Unit: rlsv3.RateLimitResponse_RateLimit_MINUTE},
+				DurationUntilReset: durationpb.New(30 * time.Second),
+			},
+		},
+		ResponseHeadersToAdd: []*corev3.HeaderValue{{Key: "x-ratelimit-limit", Value: "10"}},
+		RawBody:              []byte("slow down"),
+	}
+}
+
+func TestDecision(t *testing.T) {
+	d, err := decision(overLimitResponse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.OverLimit || d.Body != "slow down" || d.ResponseHeaders.Get("X-Ratelimit-Limit") != "10" {
+		t.Errorf("Unexpected decision: %+v", d)
+	}
+	if l := d.Limit; l == nil || l.RequestsPerUnit != 10 || l.Unit != "minute" || l.Remaining != 0 || l.ResetIn != 30*time.Second {
+		t.Errorf("Expected the limit of the matched descriptor, got %+v", d.Limit)
+	}
+
+	if _, err := decision(&rlsv3.RateLimitResponse{}); err == nil {
+		t.Error("Expected a response without a code to fail")
+	}
+}
+
+// limiter answers every request with overLimitResponse
+type limiter struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+	t *testing.T
+}
+
+func (l *limiter) ShouldRateLimit(_ context.Context, req *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	if req.GetDomain() != "gatekeeper" {
+		l.t.Errorf("Expected domain gatekeeper, got %q", req.GetDomain())
+	}
+	return overLimitResponse(), nil
+}
+
+func TestShouldRateLimit(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	rlsv3.RegisterRateLimitServiceServer(server, &limiter{t: t})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	decision, err := New(conn, time.Second).ShouldRateLimit(context.Background(), &Request{Domain: "gatekeeper"})
+	if err != nil {
+		t.Fatalf("ShouldRateLimit failed: %v", err)
+	}
+	if !decision.OverLimit {
+		t.Errorf("Expected over limit, got %+v", decision)
+	}
+}
@@ -0,0 +1,122 @@
+// Package redact masks credentials, such as Authorization headers,
+// cookies and API keys in query strings, before they are logged.
+package redact
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// DefaultHeaders are masked when no headers are configured
+var DefaultHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-API-Key"}
+
+// DefaultQueryParams are masked when no query parameters are configured
+var DefaultQueryParams = []string{
+	"*token", "*secret", "*password", "api_key", "apikey", "key",
+	"signature", "sig", "x-amz-signature", "x-amz-credential",
+}
+
+// DefaultMask replaces masked values
+const DefaultMask = "[REDACTED]"
+
+// credentials are schemes whose token follows them in free text
+var credentials = regexp.MustCompile(`(?i)\b(Bearer|Basic|Digest|Negotiate)\s+[A-Za-z0-9\-._~+/]+=*`)
+
+// userinfo finds the password in a URL
+var userinfo = regexp.MustCompile(`(://[^:/@\s]+:)[^@/\s]+@`)
+
+// queryValue finds name=value pairs in URLs and query strings
+var queryValue = regexp.MustCompile(`([?&;])([^=&;\s"'?#]+)=([^&;\s"'#]*)`)
+
+// Redactor masks the configured headers and query parameters
+type Redactor struct {
+	headers map[string]bool
+	params  []string
+	mask    string
+	text    *regexp.Regexp
+}
+
+// New builds a Redactor for cfg. Nil lists take the defaults; empty ones
+// mask nothing.
+func New(cfg config.RedactionConfig) *Redactor {
+	headers := cfg.Headers
+	if headers == nil {
+		headers = DefaultHeaders
+	}
+	params := cfg.QueryParams
+	if params == nil {
+		params = DefaultQueryParams
+	}
+	r := &Redactor{headers: make(map[string]bool, len(headers)), mask: cfg.Mask}
+	if r.mask == "" {
+		r.mask = DefaultMask
+	}
+
+	names := make([]string, 0, len(headers))
+	for _, header := range headers {
+		r.headers[http.CanonicalHeaderKey(header)] = true
+		names = append(names, regexp.QuoteMeta(header))
+	}
+	for _, param := range params {
+		r.params = append(r.params, strings.ToLower(param))
+	}
+	if len(names) > 0 {
+		// "Name: value" or "Name=value" up to the end of the value
+		r.text = regexp.MustCompile(`(?i)\b(` + strings.Join(names, "|") + `)("?\s*[:=]\s*"?)[^"\n,;]*`)
+	}
+	return r
+}
+
+// Header returns value, masked when the header name is sensitive
+func (r *Redactor) Header(name, value string) string {
+	if r.headers[http.CanonicalHeaderKey(name)] {
+		return r.mask
+	}
+	return value
+}
+
+// Query masks the values of sensitive parameters in a raw query string,
+// keeping everything else as it was
+func (r *Redactor) Query(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	masked := r.Text("?" + raw)
+	return masked[1:]
+}
+
+// Text masks credentials in free text such as a log message: credentials
+// after an auth scheme, values of sensitive headers and sensitive query
+// parameters, and passwords in URLs
+func (r *Redactor) Text(s string) string {
+	if r.text != nil {
+		s = r.text.ReplaceAllString(s, "${1}${2}"+r.mask)
+	}
+	s = credentials.ReplaceAllString(s, "${1} "+r.mask)
+	s = userinfo.ReplaceAllString(s, "${1}"+r.mask+"@")
+	return queryValue.ReplaceAllStringFunc(s, func(pair string) string {
+		m := queryValue.FindStringSubmatch(pair)
+		if !r.sensitiveParam(m[2]) {
+			return pair
+		}
+		return m[1] + m[2] + "=" + r.mask
+	})
+}
+
+func (r *Redactor) sensitiveParam(name string) bool {
+	if unescaped, err := url.QueryUnescape(name); err == nil {
+		name = unescaped
+	}
+	name = strings.ToLower(name)
+	for _, pattern := range r.params {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
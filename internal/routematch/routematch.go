@@ -0,0 +1,164 @@
+// Package routematch describes route paths as segment patterns so routes
+// can be ordered by specificity and checked for ambiguous overlaps.
+//
+// A segment is a literal, a single-segment wildcard ("*", or any segment
+// using path.Match syntax such as "*.json") or "**", which matches zero or
+// more segments.
+package routematch
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Pattern is a parsed route path
+type Pattern struct {
+	segments []string
+}
+
+// Glob parses a glob such as "/api/*/export" or "/files/**"
+func Glob(glob string) (Pattern, error) {
+	if !strings.HasPrefix(glob, "/") {
+		return Pattern{}, fmt.Errorf("glob %q must start with /", glob)
+	}
+	segments := split(glob)
+	for _, segment := range segments {
+		if strings.Contains(segment, "**") && segment != "**" {
+			return Pattern{}, fmt.Errorf("glob %q: ** must be a whole segment", glob)
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return Pattern{}, fmt.Errorf("glob %q: invalid segment %q", glob, segment)
+		}
+	}
+	return Pattern{segments: segments}, nil
+}
+
+// Template describes a gorilla mux path template; segments with variables
+// match any single segment
+func Template(template string) Pattern {
+	segments := split(template)
+	for i, segment := range segments {
+		if strings.Contains(segment, "{") {
+			segments[i] = "*"
+		}
+	}
+	return Pattern{segments: segments}
+}
+
+// Prefix describes a path prefix as its segments followed by "**"
+func Prefix(prefix string) Pattern {
+	return Pattern{segments: append(split(prefix), "**")}
+}
+
+func split(p string) []string {
+	var segments []string
+	for _, segment := range strings.Split(p, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+func (p Pattern) String() string {
+	return "/" + strings.Join(p.segments, "/")
+}
+
+// Match reports whether the pattern matches a request path
+func (p Pattern) Match(requestPath string) bool {
+	return match(p.segments, split(requestPath))
+}
+
+func match(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if match(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return match(pattern[1:], segments[1:])
+}
+
+// Overlaps reports whether some path could match both patterns. Two
+// wildcard segments are assumed to overlap.
+func (p Pattern) Overlaps(other Pattern) bool {
+	return overlap(p.segments, other.segments)
+}
+
+func overlap(a, b []string) bool {
+	switch {
+	case len(a) > 0 && a[0] == "**":
+		return overlap(a[1:], b) || (len(b) > 0 && overlap(a, b[1:]))
+	case len(b) > 0 && b[0] == "**":
+		return overlap(a, b[1:]) || (len(a) > 0 && overlap(a[1:], b))
+	case len(a) == 0 || len(b) == 0:
+		return len(a) == 0 && len(b) == 0
+	}
+	return segmentsOverlap(a[0], b[0]) && overlap(a[1:], b[1:])
+}
+
+func segmentsOverlap(a, b string) bool {
+	aLiteral, bLiteral := isLiteral(a), isLiteral(b)
+	switch {
+	case aLiteral && bLiteral:
+		return a == b
+	case aLiteral:
+		ok, _ := path.Match(b, a)
+		return ok
+	case bLiteral:
+		ok, _ := path.Match(a, b)
+		return ok
+	}
+	return true
+}
+
+func isLiteral(segment string) bool {
+	return !strings.ContainsAny(segment, `*?[\`)
+}
+
+// Compare orders patterns by specificity: negative when p is more specific
+// than other, zero when they rank the same. Fixed-length patterns beat those
+// with "**", then more literal segments win, then more segments.
+func (p Pattern) Compare(other Pattern) int {
+	if a, b := p.hasMulti(), other.hasMulti(); a != b {
+		if a {
+			return 1
+		}
+		return -1
+	}
+	if a, b := p.literals(), other.literals(); a != b {
+		return b - a
+	}
+	return len(other.segments) - len(p.segments)
+}
+
+func (p Pattern) hasMulti() bool {
+	for _, segment := range p.segments {
+		if segment == "**" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Pattern) literals() int {
+	n := 0
+	for _, segment := range p.segments {
+		if isLiteral(segment) {
+			n++
+		}
+	}
+	return n
+}
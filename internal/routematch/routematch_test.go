@@ -0,0 +1,81 @@
+package routematch
+
+import "testing"
+
+func mustGlob(t *testing.T, glob string) Pattern {
+	t.Helper()
+	p, err := Glob(glob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestMatch(t *testing.T) {
+	testCases := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"/api/*/export", "/api/orders/export", true},
+		{"/api/*/export", "/api/orders/items/export", false},
+		{"/files/**", "/files", true},
+		{"/files/**", "/files/a/b/c.txt", true},
+		{"/files/**", "/filesystem", false},
+		{"/**/raw", "/a/b/raw", true},
+		{"/reports/*.csv", "/reports/may.csv", true},
+		{"/reports/*.csv", "/reports/may.pdf", false},
+	}
+
+	for _, tc := range testCases {
+		if got := mustGlob(t, tc.glob).Match(tc.path); got != tc.match {
+			t.Errorf("%s against %s: expected %v, got %v", tc.glob, tc.path, tc.match, got)
+		}
+	}
+}
+
+func TestInvalidGlob(t *testing.T) {
+	for _, glob := range []string{"api/*", "/files/a**", "/bad/[x"} {
+		if _, err := Glob(glob); err == nil {
+			t.Errorf("expected %q to be rejected", glob)
+		}
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	testCases := []struct {
+		a, b    Pattern
+		overlap bool
+	}{
+		{mustGlob(t, "/api/*/export"), mustGlob(t, "/api/v1/*"), true},
+		{mustGlob(t, "/api/*/export"), mustGlob(t, "/api/v1/import"), false},
+		{mustGlob(t, "/files/**"), Prefix("/files"), true},
+		{mustGlob(t, "/files/**"), Template("/users/{id}"), false},
+		{mustGlob(t, "/**/raw"), mustGlob(t, "/files/*"), true},
+		{mustGlob(t, "/reports/*.csv"), Template("/reports/summary.pdf"), false},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.a.Overlaps(tc.b); got != tc.overlap {
+			t.Errorf("%s and %s: expected overlap %v, got %v", tc.a, tc.b, tc.overlap, got)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// Each pattern is more specific than the next
+	ordered := []Pattern{
+		Template("/api/users/export"),
+		mustGlob(t, "/api/*/export"),
+		mustGlob(t, "/api/*"),
+		mustGlob(t, "/api/users/**"),
+		Prefix("/api"),
+		mustGlob(t, "/**"),
+	}
+
+	for i := 0; i+1 < len(ordered); i++ {
+		if c := ordered[i].Compare(ordered[i+1]); c >= 0 {
+			t.Errorf("expected %s to rank before %s, got %d", ordered[i], ordered[i+1], c)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	assertionNS = "urn:oasis:names:tc:SAML:2.0:assertion"
+	protocolNS  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	bearer      = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+	// ClockSkew is tolerated when checking assertion validity windows
+	ClockSkew = 90 * time.Second
+)
+
+// IdentityProvider is the trust anchor imported from IdP metadata
+type IdentityProvider struct {
+	EntityID     string
+	Certificates []*x509.Certificate
+}
+
+// Assertion holds the validated facts from a SAML assertion
+type Assertion struct {
+	Issuer     string
+	Subject    string
+	Expires    time.Time
+	Attributes map[string][]string
+}
+
+// Validator checks bearer assertions issued by a single identity provider
+// for a single audience (our SP entity ID).
+type Validator struct {
+	idp      *IdentityProvider
+	audience string
+	now      func() time.Time
+}
+
+func NewValidator(idp *IdentityProvider, audience string) *Validator {
+	return &Validator{idp: idp, audience: audience, now: time.Now}
+}
+
+// ParseMetadata reads an EntityDescriptor and returns the IdP's entity ID
+// and signing certificates.
+func ParseMetadata(data []byte) (*IdentityProvider, error) {
+	var md struct {
+		EntityID string `xml:"entityID,attr"`
+		IDPSSO   struct {
+			KeyDescriptors []struct {
+				Use          string   `xml:"use,attr"`
+				Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+			} `xml:"KeyDescriptor"`
+		} `xml:"IDPSSODescriptor"`
+	}
+	if err := xml.Unmarshal(data, &md); err != nil {
+		return nil, fmt.Errorf("saml: invalid metadata: %w", err)
+	}
+
+	idp := &IdentityProvider{EntityID: md.EntityID}
+	for _, kd := range md.IDPSSO.KeyDescriptors {
+		if kd.Use != "" && kd.Use != "signing" {
+			continue
+		}
+		for _, encoded := range kd.Certificates {
+			der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+			if err != nil {
+				return nil, fmt.Errorf("saml: invalid certificate in metadata: %w", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("saml: invalid certificate in metadata: %w", err)
+			}
+			idp.Certificates = append(idp.Certificates, cert)
+		}
+	}
+
+	if idp.EntityID == "" {
+		return nil, errors.New("saml: metadata has no entityID")
+	}
+	if len(idp.Certificates) == 0 {
+		return nil, errors.New("saml: metadata has no signing certificates")
+	}
+	return idp, nil
+}
+
+// Validate verifies the XML signature of an Assertion (or a Response that
+// wraps one) and checks issuer, audience, bearer confirmation and validity
+// windows. Only the signed element is trusted, which defeats signature
+// wrapping attacks.
+func (v *Validator) Validate(raw []byte) (*Assertion, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return nil, fmt.Errorf("saml: invalid XML: %w", err)
+	}
+	root := doc.Root()
+	if root == nil {
+		return nil, errors.New("saml: empty document")
+	}
+
+	ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: v.idp.Certificates})
+	ctx.Clock = dsig.NewFakeClockAt(v.now())
+
+	var assertionEl *etree.Element
+	switch {
+	case root.Tag == "Assertion" && root.NamespaceURI() == assertionNS:
+		validated, err := ctx.Validate(root)
+		if err != nil {
+			return nil, fmt.Errorf("saml: signature: %w", err)
+		}
+		assertionEl = validated
+	case root.Tag == "Response" && root.NamespaceURI() == protocolNS:
+		// Prefer a signed assertion; fall back to a signed response
+		child := root.FindElement("./Assertion")
+		if child == nil {
+			return nil, errors.New("saml: response contains no assertion")
+		}
+		if validated, err := ctx.Validate(child); err == nil {
+			assertionEl = validated
+		} else {
+			validatedResponse, err := ctx.Validate(root)
+			if err != nil {
+				return nil, fmt.Errorf("saml: signature: %w", err)
+			}
+			assertionEl = validatedResponse.FindElement("./Assertion")
+			if assertionEl == nil {
+				return nil, errors.New("saml: signed response contains no assertion")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("saml: unexpected root element %q", root.Tag)
+	}
+
+	signed := etree.NewDocument()
+	signed.SetRoot(assertionEl)
+	data, err := signed.WriteToBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var a xmlAssertion
+	if err := xml.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("saml: invalid assertion: %w", err)
+	}
+
+	return v.check(&a)
+}
+
+type xmlAssertion struct {
+	Issuer  string `xml:"Issuer"`
+	Subject struct {
+		NameID       string `xml:"NameID"`
+		Confirmation []struct {
+			Method string `xml:"Method,attr"`
+			Data   struct {
+				NotOnOrAfter string `xml:"NotOnOrAfter,attr"`
+			} `xml:"SubjectConfirmationData"`
+		} `xml:"SubjectConfirmation"`
+	} `xml:"Subject"`
+	Conditions struct {
+		NotBefore    string   `xml:"NotBefore,attr"`
+		NotOnOrAfter string   `xml:"NotOnOrAfter,attr"`
+		Audiences    []string `xml:"AudienceRestriction>Audience"`
+	} `xml:"Conditions"`
+	Attributes []struct {
+		Name   string   `xml:"Name,attr"`
+		Values []string `xml:"AttributeValue"`
+	} `xml:"AttributeStatement>Attribute"`
+}
+
+func (v *Validator) check(a *xmlAssertion) (*Assertion, error) {
+	now := v.now()
+
+	if strings.TrimSpace(a.Issuer) != v.idp.EntityID {
+		return nil, fmt.Errorf("saml: unexpected issuer %q", a.Issuer)
+	}
+
+	if !containsString(a.Conditions.Audiences, v.audience) {
+		return nil, errors.New("saml: assertion is not intended for this audience")
+	}
+
+	notBefore, err := parseTime(a.Conditions.NotBefore)
+	if err != nil {
+		return nil, err
+	}
+	if !notBefore.IsZero() && now.Add(ClockSkew).Before(notBefore) {
+		return nil, errors.New("saml: assertion not yet valid")
+	}
+
+	notOnOrAfter, err := parseTime(a.Conditions.NotOnOrAfter)
+	if err != nil {
+		return nil, err
+	}
+	if notOnOrAfter.IsZero() {
+		return nil, errors.New("saml: assertion has no expiry")
+	}
+	if !now.Add(-ClockSkew).Before(notOnOrAfter) {
+		return nil, errors.New("saml: assertion expired")
+	}
+
+	confirmed := false
+	for _, sc := range a.Subject.Confirmation {
+		if sc.Method != bearer {
+			continue
+		}
+		expiry, err := parseTime(sc.Data.NotOnOrAfter)
+		if err != nil {
+			return nil, err
+		}
+		if expiry.IsZero() || now.Add(-ClockSkew).Before(expiry) {
+			confirmed = true
+			break
+		}
+	}
+	if !confirmed {
+		return nil, errors.New("saml: no valid bearer subject confirmation")
+	}
+
+	subject := strings.TrimSpace(a.Subject.NameID)
+	if subject == "" {
+		return nil, errors.New("saml: assertion has no subject")
+	}
+
+	attributes := make(map[string][]string, len(a.Attributes))
+	for _, attr := range a.Attributes {
+		for _, value := range attr.Values {
+			attributes[attr.Name] = append(attributes[attr.Name], strings.TrimSpace(value))
+		}
+	}
+
+	return &Assertion{
+		Issuer:     v.idp.EntityID,
+		Subject:    subject,
+		Expires:    notOnOrAfter,
+		Attributes: attributes,
+	}, nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("saml: invalid timestamp %q", value)
+	}
+	return t, nil
+}
+
+func containsString(slice []string, item string) bool {
+	for _, s := range slice {
+		if strings.TrimSpace(s) == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package saml
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const testEntityID = "https://idp.example.com/saml"
+
+func testAssertion(audience string, notOnOrAfter time.Time) string {
+	return fmt.Sprintf(`<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_a1" Version="2.0" IssueInstant="%[1]s">
+  <saml:Issuer>%[2]s</saml:Issuer>
+  <saml:Subject>
+    <saml:NameID>alice@example.com</saml:NameID>
+    <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+      <saml:SubjectConfirmationData NotOnOrAfter="%[3]s"/>
+    </saml:SubjectConfirmation>
+  </saml:Subject>
+  <saml:Conditions NotBefore="%[1]s" NotOnOrAfter="%[3]s">
+    <saml:AudienceRestriction><saml:Audience>%[4]s</saml:Audience></saml:AudienceRestriction>
+  </saml:Conditions>
+  <saml:AttributeStatement>
+    <saml:Attribute Name="groups">
+      <saml:AttributeValue>admins</saml:AttributeValue>
+      <saml:AttributeValue>finance</saml:AttributeValue>
+    </saml:Attribute>
+  </saml:AttributeStatement>
+</saml:Assertion>`, time.Now().Add(-time.Minute).UTC().Format(time.RFC3339), testEntityID,
+		notOnOrAfter.UTC().Format(time.RFC3339), audience)
+}
+
+func sign(t *testing.T, ks dsig.X509KeyStore, xmlText string) []byte {
+	t.Helper()
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlText); err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := dsig.NewDefaultSigningContext(ks).SignEnveloped(doc.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := etree.NewDocument()
+	out.SetRoot(signed)
+	data, err := out.WriteToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testIdP(t *testing.T, ks dsig.X509KeyStore) *IdentityProvider {
+	t.Helper()
+	_, der, _ := ks.GetKeyPair()
+	metadata := fmt.Sprintf(`<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="%s">
+  <md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:KeyDescriptor use="signing">
+      <ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#">
+        <ds:X509Data><ds:X509Certificate>%s</ds:X509Certificate></ds:X509Data>
+      </ds:KeyInfo>
+    </md:KeyDescriptor>
+  </md:IDPSSODescriptor>
+</md:EntityDescriptor>`, testEntityID, base64.StdEncoding.EncodeToString(der))
+
+	idp, err := ParseMetadata([]byte(metadata))
+	if err != nil {
+		t.Fatalf("Failed to parse metadata: %v", err)
+	}
+	return idp
+}
+
+func TestValidateSignedAssertion(t *testing.T) {
+	ks := dsig.RandomKeyStoreForTest()
+	validator := NewValidator(testIdP(t, ks), "https://gateway.example.com")
+
+	signed := sign(t, ks, testAssertion("https://gateway.example.com", time.Now().Add(5*time.Minute)))
+
+	assertion, err := validator.Validate(signed)
+	if err != nil {
+		t.Fatalf("Expected assertion to validate, got %v", err)
+	}
+	if assertion.Subject != "alice@example.com" {
+		t.Errorf("Expected subject alice@example.com, got %v", assertion.Subject)
+	}
+	if groups := assertion.Attributes["groups"]; len(groups) != 2 || groups[1] != "finance" {
+		t.Errorf("Expected groups attribute, got %v", groups)
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	ks := dsig.RandomKeyStoreForTest()
+	validator := NewValidator(testIdP(t, ks), "https://gateway.example.com")
+	valid := testAssertion("https://gateway.example.com", time.Now().Add(5*time.Minute))
+
+	testCases := []struct {
+		name string
+		doc  []byte
+	}{
+		{"unsigned", []byte(valid)},
+		{"wrong audience", sign(t, ks, testAssertion("https://other.example.com", time.Now().Add(5*time.Minute)))},
+		{"expired", sign(t, ks, testAssertion("https://gateway.example.com", time.Now().Add(-5*time.Minute)))},
+		{"signed by another key", sign(t, dsig.RandomKeyStoreForTest(), valid)},
+		{"tampered subject", []byte(strings.Replace(string(sign(t, ks, valid)), "alice@example.com", "mallory@example.com", 1))},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := validator.Validate(tc.doc); err == nil {
+				t.Error("Expected assertion to be rejected")
+			}
+		})
+	}
+}
+
+func TestParseMetadataRequiresCertificates(t *testing.T) {
+	_, err := ParseMetadata([]byte(`<EntityDescriptor entityID="x"><IDPSSODescriptor/></EntityDescriptor>`))
+	if err == nil {
+		t.Error("Expected metadata without certificates to be rejected")
+	}
+
+	ks := dsig.RandomKeyStoreForTest()
+	idp := testIdP(t, ks)
+	if len(idp.Certificates) != 1 || idp.EntityID != testEntityID {
+		t.Errorf("Unexpected identity provider: %+v", idp)
+	}
+}
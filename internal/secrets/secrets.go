@@ -0,0 +1,182 @@
+// Package secrets fills in config values written as "vault://path#key"
+// from a secrets provider, and watches them for changes, so credentials,
+// signing keys and certificates can live in Vault and be rotated there.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+)
+
+// prefix marks a config value that is a reference to a secret
+const prefix = "vault://"
+
+// Provider fetches the secret a reference names
+type Provider interface {
+	Fetch(ctx context.Context, ref string) (string, error)
+}
+
+// resolution is what the last Resolve fetched, for Watch to compare with
+type resolution struct {
+	provider Provider
+	interval time.Duration
+	values   map[string]string
+}
+
+var (
+	mu   sync.Mutex
+	last *resolution
+)
+
+// Resolve replaces every "vault://path#key" value in cfg with the secret
+// from the Vault server in cfg.Secrets, and remembers the values for Watch.
+// Certificates and keys fetched this way can be used as server.tls certFile
+// and keyFile, which accept PEM as well as paths.
+func Resolve(ctx context.Context, cfg *config.Config) error {
+	var refs []string
+	walk(reflect.ValueOf(cfg), func(ref string) (string, error) {
+		refs = append(refs, ref)
+		return prefix + ref, nil
+	})
+	if len(refs) == 0 {
+		mu.Lock()
+		last = nil
+		mu.Unlock()
+		return nil
+	}
+	if cfg.Secrets.Vault.Address == "" {
+		return fmt.Errorf("%s%s is used without secrets.vault", prefix, refs[0])
+	}
+
+	vault, err := NewVault(cfg.Secrets.Vault)
+	if err != nil {
+		return err
+	}
+	r := &resolution{provider: vault, interval: vault.refreshInterval(), values: make(map[string]string)}
+	if err := r.resolve(ctx, cfg); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	last = r
+	mu.Unlock()
+	return nil
+}
+
+func (r *resolution) resolve(ctx context.Context, cfg *config.Config) error {
+	return walk(reflect.ValueOf(cfg), func(ref string) (string, error) {
+		if value, ok := r.values[ref]; ok {
+			return value, nil
+		}
+		value, err := r.provider.Fetch(ctx, ref)
+		if err != nil {
+			return "", fmt.Errorf("%s%s: %w", prefix, ref, err)
+		}
+		r.values[ref] = value
+		return value, nil
+	})
+}
+
+// changed reports whether any secret has a new value. A secret that cannot
+// be fetched counts as unchanged, so an unreachable Vault keeps the
+// current config.
+func (r *resolution) changed(ctx context.Context) bool {
+	for ref, old := range r.values {
+		value, err := r.provider.Fetch(ctx, ref)
+		if err != nil {
+			logger.Warn("Could not refresh secret %s%s: %v", prefix, ref, err)
+			continue
+		}
+		if value != old {
+			logger.Info("Secret %s%s changed", prefix, ref)
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reads the secrets the last Resolve fetched again every refresh
+// interval, and calls reload when one of them has changed. It is meant to
+// run for the life of the process.
+func Watch(reload func()) {
+	for {
+		mu.Lock()
+		r := last
+		mu.Unlock()
+
+		interval := time.Minute
+		if r != nil {
+			interval = r.interval
+		}
+		time.Sleep(interval)
+
+		// A reload in the meantime resolved newer values
+		mu.Lock()
+		current := last
+		mu.Unlock()
+		if r == nil || r != current {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		changed := r.changed(ctx)
+		cancel()
+		if changed {
+			reload()
+		}
+	}
+}
+
+// walk calls fn with the reference of every string below v that starts
+// with prefix, and replaces the string with what fn returns
+func walk(v reflect.Value, fn func(ref string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return walk(v.Elem(), fn)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if !t.Field(i).IsExported() || t.Field(i).Tag.Get("yaml") == "-" {
+				continue
+			}
+			if err := walk(v.Field(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := walk(v.Index(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// Map values cannot be set in place, so each is copied out and
+		// stored back
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			if err := walk(elem, fn); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	case reflect.String:
+		if s := v.String(); strings.HasPrefix(s, prefix) && v.CanSet() {
+			value, err := fn(strings.TrimPrefix(s, prefix))
+			if err != nil {
+				return err
+			}
+			v.SetString(value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// fakeVault serves KV version 2 reads of secrets, keyed by path below the
+// "secret" mount
+type fakeVault struct {
+	mu      sync.Mutex
+	secrets map[string]map[string]interface{}
+	reads   int
+}
+
+func (f *fakeVault) set(path, key string, value interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.secrets[path] == nil {
+		f.secrets[path] = make(map[string]interface{})
+	}
+	f.secrets[path][key] = value
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, config.VaultConfig) {
+	f := &fakeVault{secrets: make(map[string]map[string]interface{})}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.reads++
+		data, ok := f.secrets[strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": data}})
+	}))
+	t.Cleanup(srv.Close)
+	return f, config.VaultConfig{Address: srv.URL, Token: "root"}
+}
+
+func TestResolve(t *testing.T) {
+	vault, vaultCfg := newFakeVault(t)
+	vault.set("gatekeeper/redis", "password", "s3cret")
+	vault.set("gatekeeper/signing", "key", "0123456789abcdef")
+	vault.set("gatekeeper/hmac", "partner", "partner-secret")
+	vault.set("gatekeeper/redis", "db", 3)
+
+	cfg := &config.Config{
+		Secrets:      config.SecretsConfig{Vault: vaultCfg},
+		Storage:      config.StorageConfig{Type: "redis", Redis: config.RedisConfig{Address: "redis:6379", Password: "vault://gatekeeper/redis#password"}},
+		UpstreamSign: config.UpstreamSignConfig{Secret: "vault://gatekeeper/signing#key"},
+		Middlewares: config.MiddlewareConfigs{"partners": {Type: "hmac", HMAC: &config.HMACConfig{
+			Consumers: []config.HMACConsumer{{ID: "partner", Secret: "vault://gatekeeper/hmac#partner"}},
+		}}},
+		Routes: []config.RouteConfig{{Name: "db", Host: "vault://gatekeeper/redis#db"}},
+	}
+	if err := Resolve(context.Background(), cfg); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+
+	if got := cfg.Storage.Redis.Password; got != "s3cret" {
+		t.Errorf("redis password = %q, want s3cret", got)
+	}
+	if got := cfg.UpstreamSign.Secret; got != "0123456789abcdef" {
+		t.Errorf("signing secret = %q, want 0123456789abcdef", got)
+	}
+	if got := cfg.Middlewares["partners"].HMAC.Consumers[0].Secret; got != "partner-secret" {
+		t.Errorf("consumer secret = %q, want partner-secret", got)
+	}
+	if got := cfg.Routes[0].Host; got != "3" {
+		t.Errorf("non-string value = %q, want 3", got)
+	}
+	if got := cfg.Storage.Redis.Address; got != "redis:6379" {
+		t.Errorf("plain value changed to %q", got)
+	}
+	// The two keys of gatekeeper/redis are fetched separately
+	if vault.reads != 4 {
+		t.Errorf("got %d reads, want 4", vault.reads)
+	}
+
+	mu.Lock()
+	r := last
+	mu.Unlock()
+	if r.changed(context.Background()) {
+		t.Error("changed reported a change before any")
+	}
+	vault.set("gatekeeper/redis", "password", "rotated")
+	if !r.changed(context.Background()) {
+		t.Error("changed missed a rotated secret")
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	vault, vaultCfg := newFakeVault(t)
+	vault.set("gatekeeper/redis", "password", "s3cret")
+
+	for name, tc := range map[string]struct {
+		vault config.VaultConfig
+		ref   string
+	}{
+		"no vault":      {config.VaultConfig{}, "vault://gatekeeper/redis#password"},
+		"missing key":   {vaultCfg, "vault://gatekeeper/redis#username"},
+		"missing path":  {vaultCfg, "vault://gatekeeper/other#password"},
+		"no key":        {vaultCfg, "vault://gatekeeper/redis"},
+		"wrong token":   {config.VaultConfig{Address: vaultCfg.Address, Token: "guess"}, "vault://gatekeeper/redis#password"},
+		"bad reference": {vaultCfg, "vault://#password"},
+	} {
+		cfg := &config.Config{
+			Secrets: config.SecretsConfig{Vault: tc.vault},
+			Storage: config.StorageConfig{Redis: config.RedisConfig{Password: tc.ref}},
+		}
+		if err := Resolve(context.Background(), cfg); err == nil {
+			t.Errorf("%s: Resolve succeeded, want an error", name)
+		}
+	}
+
+	// Without references, Vault is not needed
+	if err := Resolve(context.Background(), &config.Config{}); err != nil {
+		t.Errorf("Resolve without references: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/tlsutil"
+)
+
+// Vault reads secrets from a KV version 2 engine. A reference is the path
+// of the secret below the mount and the key within it, "path#key".
+type Vault struct {
+	cfg    config.VaultConfig
+	client *http.Client
+}
+
+// NewVault creates a Vault provider, applying defaults
+func NewVault(cfg config.VaultConfig) (*Vault, error) {
+	if cfg.Mount == "" {
+		cfg.Mount = "secret"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5
+	}
+	if cfg.RefreshInterval == 0 {
+		cfg.RefreshInterval = 300
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAFile != "" {
+		pool, err := tlsutil.LoadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &Vault{
+		cfg:    cfg,
+		client: &http.Client{Transport: transport, Timeout: time.Duration(cfg.Timeout) * time.Second},
+	}, nil
+}
+
+// kvResponse is the part of a KV version 2 read the provider uses
+type kvResponse struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+func (v *Vault) Fetch(ctx context.Context, ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("vault reference %q must be path#key", ref)
+	}
+
+	url := strings.TrimRight(v.cfg.Address, "/") + "/v1/" + v.cfg.Mount + "/data/" + strings.Trim(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.cfg.Token)
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("vault %s: status %d", path, resp.StatusCode)
+	}
+
+	var kv kvResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&kv); err != nil {
+		return "", fmt.Errorf("vault %s: %w", path, err)
+	}
+	value, ok := kv.Data.Data[key]
+	if !ok {
+		return "", fmt.Errorf("vault %s has no key %s", path, key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// refreshInterval is how often Watch reads the secrets again
+func (v *Vault) refreshInterval() time.Duration {
+	return time.Duration(v.cfg.RefreshInterval) * time.Second
+}
@@ -0,0 +1,61 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	codec, err := NewCodec("0123456789abcdef-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := codec.Encode(map[string]string{"sub": "alice"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	var decoded map[string]string
+	if err := codec.Decode(encoded, &decoded); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if decoded["sub"] != "alice" {
+		t.Errorf("Expected sub alice, got %v", decoded["sub"])
+	}
+
+	other, _ := NewCodec("a-completely-different-secret")
+	if err := other.Decode(encoded, &decoded); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected value from another key to be rejected, got %v", err)
+	}
+}
+
+func TestCodecExpiry(t *testing.T) {
+	codec, _ := NewCodec("0123456789abcdef-secret")
+	encoded, _ := codec.Encode("value", -time.Second)
+
+	var decoded string
+	if err := codec.Decode(encoded, &decoded); !errors.Is(err, ErrExpired) {
+		t.Errorf("Expected expired error, got %v", err)
+	}
+}
+
+func TestCodecPurpose(t *testing.T) {
+	codec, _ := NewCodec("0123456789abcdef-secret")
+	encoded, _ := codec.WithPurpose("oidc_state").Encode("value", time.Minute)
+
+	var decoded string
+	if err := codec.WithPurpose("session").Decode(encoded, &decoded); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected a value sealed for another purpose to be rejected, got %v", err)
+	}
+	if err := codec.WithPurpose("oidc_state").Decode(encoded, &decoded); err != nil || decoded != "value" {
+		t.Errorf("Expected the value to open for its own purpose, got %q %v", decoded, err)
+	}
+}
+
+func TestNewCodecRejectsShortSecret(t *testing.T) {
+	if _, err := NewCodec("short"); err == nil {
+		t.Error("Expected short secret to be rejected")
+	}
+}
@@ -0,0 +1,231 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/kv"
+)
+
+// Store keys: records of server-side sessions, revoked cookie sessions and
+// the time each revoked subject was logged out everywhere
+const (
+	recordPrefix  = "session:id:"
+	revokedPrefix = "session:revoked:"
+	subjectPrefix = "session:subject:"
+)
+
+// Session is a login session as the admin API shows it
+type Session struct {
+	ID      string    `json:"id"`
+	Subject string    `json:"subject"`
+	Issued  time.Time `json:"issued"`
+	Expires time.Time `json:"expires"`
+}
+
+// record is a session with the data its owner keeps in it
+type record struct {
+	Session
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Options configure a Manager
+type Options struct {
+	CookieName string
+	TTL        time.Duration
+	SameSite   http.SameSite
+	Secure     bool
+	Domain     string
+	// ServerSide keeps sessions in the store and only their ID in the
+	// cookie
+	ServerSide bool
+}
+
+// Manager keeps login sessions in sealed cookies or, server side, in a
+// kv.Store. Either kind can be revoked: cookie sessions through a list of
+// revoked IDs kept in the store until they would have expired.
+type Manager struct {
+	opts  Options
+	codec *Codec
+	store kv.Store
+	now   func() time.Time
+}
+
+func NewManager(secret string, opts Options, store kv.Store) (*Manager, error) {
+	codec, err := NewCodec(secret)
+	if err != nil {
+		return nil, err
+	}
+	codec = codec.WithPurpose("session")
+	if store == nil {
+		return nil, errors.New("session: a store is required")
+	}
+	return &Manager{opts: opts, codec: codec, store: store, now: time.Now}, nil
+}
+
+// Codec seals other cookies, such as login state, with the session secret
+// for purpose. What it seals never opens as a session.
+func (m *Manager) Codec(purpose string) *Codec {
+	return m.codec.WithPurpose(purpose)
+}
+
+// ServerSide reports whether sessions are kept in the store
+func (m *Manager) ServerSide() bool {
+	return m.opts.ServerSide
+}
+
+// Start begins a session for subject holding data and sets its cookie
+func (m *Manager) Start(ctx context.Context, w http.ResponseWriter, subject string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	now := m.now()
+	rec := record{
+		Session: Session{ID: newID(), Subject: subject, Issued: now, Expires: now.Add(m.opts.TTL)},
+		Data:    raw,
+	}
+
+	var sealed interface{} = rec
+	if m.opts.ServerSide {
+		encoded, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if err := m.store.Set(ctx, recordPrefix+rec.ID, encoded, m.opts.TTL); err != nil {
+			return err
+		}
+		sealed = rec.ID
+	}
+
+	value, err := m.codec.Encode(sealed, m.opts.TTL)
+	if err != nil {
+		return err
+	}
+	m.SetCookie(w, m.opts.CookieName, value, m.opts.TTL)
+	return nil
+}
+
+// Load decodes the data of r's session into v. It returns nil when r has
+// no valid session, and an error only when the store cannot be reached.
+func (m *Manager) Load(r *http.Request, v interface{}) (*Session, error) {
+	cookie, err := r.Cookie(m.opts.CookieName)
+	if err != nil {
+		return nil, nil
+	}
+
+	var rec record
+	if m.opts.ServerSide {
+		var id string
+		if err := m.codec.Decode(cookie.Value, &id); err != nil {
+			return nil, nil
+		}
+		raw, ok, err := m.store.Get(r.Context(), recordPrefix+id)
+		if err != nil || !ok {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return nil, nil
+		}
+	} else {
+		if err := m.codec.Decode(cookie.Value, &rec); err != nil {
+			return nil, nil
+		}
+		_, revoked, err := m.store.Get(r.Context(), revokedPrefix+rec.ID)
+		if err != nil || revoked {
+			return nil, err
+		}
+	}
+
+	if raw, ok, err := m.store.Get(r.Context(), subjectPrefix+rec.Subject); err != nil {
+		return nil, err
+	} else if ok {
+		var loggedOut time.Time
+		if loggedOut.UnmarshalText(raw) == nil && !rec.Issued.After(loggedOut) {
+			return nil, nil
+		}
+	}
+
+	if rec.Subject == "" {
+		return nil, nil
+	}
+	if err := json.Unmarshal(rec.Data, v); err != nil {
+		return nil, nil
+	}
+	return &rec.Session, nil
+}
+
+// List returns the live server-side sessions, oldest first
+func (m *Manager) List(ctx context.Context) ([]Session, error) {
+	if !m.opts.ServerSide {
+		return nil, errors.New("session: cookie sessions cannot be listed")
+	}
+	entries, err := m.store.Scan(ctx, recordPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(entries))
+	for _, raw := range entries {
+		var rec record
+		if json.Unmarshal(raw, &rec) == nil {
+			sessions = append(sessions, rec.Session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Issued.Before(sessions[j].Issued) })
+	return sessions, nil
+}
+
+// Revoke ends session id. For server-side sessions it reports whether the
+// session existed; a cookie session cannot be looked up, so it always
+// reports true.
+func (m *Manager) Revoke(ctx context.Context, id string) (bool, error) {
+	if m.opts.ServerSide {
+		return m.store.Delete(ctx, recordPrefix+id)
+	}
+	return true, m.store.Set(ctx, revokedPrefix+id, []byte("1"), m.opts.TTL)
+}
+
+// RevokeSubject ends every session of subject started until now
+func (m *Manager) RevokeSubject(ctx context.Context, subject string) error {
+	now, _ := m.now().MarshalText()
+	return m.store.Set(ctx, subjectPrefix+subject, now, m.opts.TTL)
+}
+
+// SetCookie sets a cookie with the manager's attributes. A negative ttl
+// deletes it.
+func (m *Manager) SetCookie(w http.ResponseWriter, name, value string, ttl time.Duration) {
+	m.setCookie(w, name, value, ttl, m.opts.SameSite)
+}
+
+// SetStateCookie sets a login state cookie like SetCookie, but always
+// SameSite=Lax: it has to come back on the identity provider's cross-site
+// redirect to the callback, which a strict cookie would not.
+func (m *Manager) SetStateCookie(w http.ResponseWriter, name, value string, ttl time.Duration) {
+	m.setCookie(w, name, value, ttl, http.SameSiteLaxMode)
+}
+
+func (m *Manager) setCookie(w http.ResponseWriter, name, value string, ttl time.Duration, sameSite http.SameSite) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   m.opts.Domain,
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   m.opts.Secure,
+		SameSite: sameSite,
+	})
+}
+
+// newID returns 128 random bits, hex encoded
+func newID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,151 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/kv"
+)
+
+const testSecret = "0123456789abcdef-secret"
+
+// start begins a session for subject and returns a request carrying its
+// cookie
+func start(t *testing.T, m *Manager, subject string) *http.Request {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	if err := m.Start(context.Background(), rr, subject, map[string]string{"token": "t-" + subject}); err != nil {
+		t.Fatalf("Failed to start session: %v", err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, c := range rr.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestManagerLoad(t *testing.T) {
+	for _, serverSide := range []bool{false, true} {
+		m, err := NewManager(testSecret, Options{CookieName: "sid", TTL: time.Hour, ServerSide: serverSide}, kv.NewMemoryStore())
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := start(t, m, "oidc:alice")
+
+		var data map[string]string
+		found, err := m.Load(req, &data)
+		if err != nil || found == nil {
+			t.Fatalf("serverSide=%v: expected the session to load, got %v %v", serverSide, found, err)
+		}
+		if found.Subject != "oidc:alice" || data["token"] != "t-oidc:alice" {
+			t.Errorf("serverSide=%v: expected alice's session and data, got %+v %v", serverSide, found, data)
+		}
+
+		if found, _ := m.Load(httptest.NewRequest("GET", "/", nil), &data); found != nil {
+			t.Errorf("serverSide=%v: expected no session without a cookie", serverSide)
+		}
+	}
+}
+
+func TestManagerRejectsOtherCookies(t *testing.T) {
+	m, _ := NewManager(testSecret, Options{CookieName: "sid", TTL: time.Hour}, kv.NewMemoryStore())
+	var data map[string]string
+
+	// Login state sealed with the same secret
+	state, _ := m.Codec("oidc_state").Encode(map[string]string{"state": "abc"}, time.Hour)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "sid", Value: state})
+	if found, _ := m.Load(req, &data); found != nil {
+		t.Errorf("Expected another cookie not to load as a session, got %+v", found)
+	}
+
+	// A session without a subject
+	empty, _ := m.codec.Encode(record{Session: Session{ID: "x"}, Data: []byte("{}")}, time.Hour)
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "sid", Value: empty})
+	if found, _ := m.Load(req, &data); found != nil {
+		t.Errorf("Expected a session without a subject to be rejected, got %+v", found)
+	}
+}
+
+func TestManagerRevoke(t *testing.T) {
+	for _, serverSide := range []bool{false, true} {
+		m, _ := NewManager(testSecret, Options{CookieName: "sid", TTL: time.Hour, ServerSide: serverSide}, kv.NewMemoryStore())
+		alice, bob := start(t, m, "alice"), start(t, m, "bob")
+
+		var data map[string]string
+		found, _ := m.Load(alice, &data)
+		if ok, err := m.Revoke(context.Background(), found.ID); !ok || err != nil {
+			t.Fatalf("serverSide=%v: expected the session to be revoked, got %v %v", serverSide, ok, err)
+		}
+		if found, _ := m.Load(alice, &data); found != nil {
+			t.Errorf("serverSide=%v: expected a revoked session not to load", serverSide)
+		}
+		if found, _ := m.Load(bob, &data); found == nil {
+			t.Errorf("serverSide=%v: expected other sessions to be kept", serverSide)
+		}
+	}
+}
+
+func TestManagerRevokeSubject(t *testing.T) {
+	m, _ := NewManager(testSecret, Options{CookieName: "sid", TTL: time.Hour}, kv.NewMemoryStore())
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	before := start(t, m, "alice")
+
+	if err := m.RevokeSubject(context.Background(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]string
+	if found, _ := m.Load(before, &data); found != nil {
+		t.Error("Expected sessions started before the logout to be revoked")
+	}
+
+	// Logging in again afterwards works
+	m.now = func() time.Time { return now.Add(time.Second) }
+	if found, _ := m.Load(start(t, m, "alice"), &data); found == nil {
+		t.Error("Expected a session started after the logout to load")
+	}
+}
+
+func TestManagerList(t *testing.T) {
+	m, _ := NewManager(testSecret, Options{CookieName: "sid", TTL: time.Hour, ServerSide: true}, kv.NewMemoryStore())
+	now := time.Now()
+	for i, subject := range []string{"alice", "bob"} {
+		m.now = func() time.Time { return now.Add(time.Duration(i) * time.Second) }
+		start(t, m, subject)
+	}
+
+	sessions, err := m.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].Subject != "alice" || sessions[1].Subject != "bob" {
+		t.Errorf("Expected alice's then bob's session, got %+v", sessions)
+	}
+
+	cookies, _ := NewManager(testSecret, Options{CookieName: "sid", TTL: time.Hour}, kv.NewMemoryStore())
+	if _, err := cookies.List(context.Background()); err == nil {
+		t.Error("Expected cookie sessions not to be listable")
+	}
+}
+
+func TestManagerCookieAttributes(t *testing.T) {
+	m, _ := NewManager(testSecret, Options{
+		CookieName: "sid",
+		TTL:        time.Hour,
+		SameSite:   http.SameSiteStrictMode,
+		Secure:     true,
+		Domain:     "example.com",
+	}, kv.NewMemoryStore())
+
+	rr := httptest.NewRecorder()
+	m.Start(context.Background(), rr, "alice", nil)
+	c := rr.Result().Cookies()[0]
+	if c.Name != "sid" || !c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteStrictMode || c.Domain != "example.com" || c.MaxAge != 3600 {
+		t.Errorf("Expected a strict secure cookie for example.com, got %+v", c)
+	}
+}
@@ -0,0 +1,58 @@
+package signature
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSimpleIsStable(t *testing.T) {
+	a, err := Simple("sha256", []byte("secret"), "POST", "/orders?x=1", "1700000000", []byte(`{"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := Simple("sha256", []byte("secret"), "POST", "/orders?x=1", "1700000000", []byte(`{"id":2}`))
+	if a == b {
+		t.Error("Expected body to be covered by the signature")
+	}
+	if len(a) != 64 {
+		t.Errorf("Expected hex sha256 signature, got %v", a)
+	}
+
+	if _, err := Simple("md5", []byte("secret"), "GET", "/", "1", nil); err == nil {
+		t.Error("Expected unsupported algorithm to be rejected")
+	}
+}
+
+func TestCanonicalQueryOrderDoesNotMatter(t *testing.T) {
+	r1 := httptest.NewRequest("GET", "http://api.example.com/items?b=2&a=1", nil)
+	r2 := httptest.NewRequest("GET", "http://api.example.com/items?a=1&b=2", nil)
+
+	s1, _ := Canonical("sha256", []byte("secret"), r1, []string{"host"}, "t", nil)
+	s2, _ := Canonical("sha256", []byte("secret"), r2, []string{"Host"}, "t", nil)
+	if s1 != s2 {
+		t.Error("Expected canonical signatures to match regardless of query order")
+	}
+
+	r2.Host = "evil.example.com"
+	if s3, _ := Canonical("sha256", []byte("secret"), r2, []string{"host"}, "t", nil); s3 == s1 {
+		t.Error("Expected signed host to be covered")
+	}
+}
+
+func TestParseAuthorization(t *testing.T) {
+	auth := Authorization{Algorithm: "sha256", KeyID: "client-1", SignedHeaders: []string{"host", "x-timestamp"}, Signature: "abcd"}
+
+	parsed, err := ParseAuthorization(auth.String())
+	if err != nil {
+		t.Fatalf("Failed to parse %q: %v", auth.String(), err)
+	}
+	if parsed.KeyID != "client-1" || parsed.Algorithm != "sha256" || len(parsed.SignedHeaders) != 2 || parsed.Signature != "abcd" {
+		t.Errorf("Unexpected parse result: %+v", parsed)
+	}
+
+	for _, value := range []string{"", "Bearer x", "HMAC-SHA256 Credential=a"} {
+		if _, err := ParseAuthorization(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
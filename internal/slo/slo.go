@@ -0,0 +1,198 @@
+// Package slo tracks service level objectives for routes: the share of
+// requests that must be good, how fast the error budget is burning over
+// several windows, and how much of the budget is left for the period.
+package slo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// Windows are the burn rate windows, paired for multiwindow alerts: a
+// fast burn shows in 5m and 1h, a slow one in 30m and 6h
+var Windows = []struct {
+	Name    string
+	Minutes int64
+}{
+	{"5m", 5},
+	{"30m", 30},
+	{"1h", 60},
+	{"6h", 360},
+}
+
+// minutes is how many minute buckets cover the longest window
+const minutes = 360
+
+// publishEvery limits how often the metrics of one objective are updated
+const publishEvery = time.Second
+
+// Status is an objective's state, as served by /admin/slo
+type Status struct {
+	Name                 string             `json:"name"`
+	Route                string             `json:"route"`
+	Objective            float64            `json:"objective"`
+	LatencyMs            int                `json:"latencyMs,omitempty"`
+	PeriodDays           int                `json:"periodDays"`
+	Requests             int64              `json:"requests"`
+	BadRequests          int64              `json:"badRequests"`
+	Compliance           float64            `json:"compliance"`
+	ErrorBudgetRemaining float64            `json:"errorBudgetRemaining"`
+	BurnRates            map[string]float64 `json:"burnRates"`
+}
+
+type bucket struct {
+	at         int64
+	total, bad int64
+}
+
+type objective struct {
+	config.SLOConfig
+	allowed float64 // share of requests that may be bad
+	latency time.Duration
+
+	mu        sync.Mutex
+	minutes   [minutes]bucket
+	hours     []bucket
+	published time.Time
+}
+
+// Tracker records route requests against the objectives set for them
+type Tracker struct {
+	routes     map[string][]*objective
+	objectives []*objective
+	now        func() time.Time
+}
+
+// New tracks the objectives of cfg. PeriodDays defaults to 30.
+func New(cfg []config.SLOConfig) *Tracker {
+	t := &Tracker{routes: make(map[string][]*objective), now: time.Now}
+	for _, c := range cfg {
+		if c.PeriodDays == 0 {
+			c.PeriodDays = 30
+		}
+		o := &objective{
+			SLOConfig: c,
+			allowed:   1 - c.Objective/100,
+			latency:   time.Duration(c.LatencyMs) * time.Millisecond,
+			hours:     make([]bucket, c.PeriodDays*24),
+		}
+		t.routes[c.Route] = append(t.routes[c.Route], o)
+		t.objectives = append(t.objectives, o)
+	}
+	return t
+}
+
+// Record counts a request route served. It is bad when it failed with a
+// 5xx or, for objectives with a latency threshold, took longer.
+func (t *Tracker) Record(route string, status int, duration time.Duration) {
+	objectives := t.routes[route]
+	if len(objectives) == 0 {
+		return
+	}
+	now := t.now()
+	for _, o := range objectives {
+		bad := status >= 500 || (o.latency > 0 && duration > o.latency)
+		metrics.RecordSLORequest(o.Name, !bad)
+
+		o.mu.Lock()
+		o.add(now, bad)
+		var publish *Status
+		if now.Sub(o.published) >= publishEvery {
+			o.published = now
+			s := o.status(now)
+			publish = &s
+		}
+		o.mu.Unlock()
+		if publish != nil {
+			metrics.SetSLOStatus(o.Name, publish.BurnRates, publish.ErrorBudgetRemaining)
+		}
+	}
+}
+
+// Statuses returns the state of every objective, in config order
+func (t *Tracker) Statuses() []Status {
+	now := t.now()
+	statuses := make([]Status, 0, len(t.objectives))
+	for _, o := range t.objectives {
+		o.mu.Lock()
+		statuses = append(statuses, o.status(now))
+		o.mu.Unlock()
+	}
+	return statuses
+}
+
+func (o *objective) add(now time.Time, bad bool) {
+	for _, b := range []*bucket{o.minuteBucket(now.Unix() / 60), o.hourBucket(now.Unix() / 3600)} {
+		b.total++
+		if bad {
+			b.bad++
+		}
+	}
+}
+
+func (o *objective) minuteBucket(minute int64) *bucket {
+	b := &o.minutes[minute%minutes]
+	if b.at != minute {
+		*b = bucket{at: minute}
+	}
+	return b
+}
+
+func (o *objective) hourBucket(hour int64) *bucket {
+	b := &o.hours[hour%int64(len(o.hours))]
+	if b.at != hour {
+		*b = bucket{at: hour}
+	}
+	return b
+}
+
+// status sums the buckets still inside each window
+func (o *objective) status(now time.Time) Status {
+	s := Status{
+		Name:       o.Name,
+		Route:      o.Route,
+		Objective:  o.Objective,
+		LatencyMs:  o.LatencyMs,
+		PeriodDays: o.PeriodDays,
+		BurnRates:  make(map[string]float64, len(Windows)),
+	}
+
+	minute := now.Unix() / 60
+	for _, window := range Windows {
+		var total, bad int64
+		for _, b := range o.minutes {
+			if b.at > minute-window.Minutes && b.at <= minute {
+				total += b.total
+				bad += b.bad
+			}
+		}
+		s.BurnRates[window.Name] = o.burnRate(total, bad)
+	}
+
+	hour := now.Unix() / 3600
+	for _, b := range o.hours {
+		if b.at > hour-int64(len(o.hours)) && b.at <= hour {
+			s.Requests += b.total
+			s.BadRequests += b.bad
+		}
+	}
+	s.Compliance = 100
+	s.ErrorBudgetRemaining = 1
+	if s.Requests > 0 {
+		s.Compliance = 100 * float64(s.Requests-s.BadRequests) / float64(s.Requests)
+		s.ErrorBudgetRemaining = 1 - o.burnRate(s.Requests, s.BadRequests)
+	}
+	return s
+}
+
+// burnRate is how many times faster than allowed the budget is spent: 1
+// uses it up exactly by the end of the period
+func (o *objective) burnRate(total, bad int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(bad) / float64(total) / o.allowed
+}
@@ -0,0 +1,77 @@
+package slo
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestBurnRates(t *testing.T) {
+	tracker := New([]config.SLOConfig{{Name: "orders", Route: "orders", Objective: 99, LatencyMs: 300}})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	// An hour ago: 100 good requests
+	now = now.Add(-time.Hour)
+	for i := 0; i < 100; i++ {
+		tracker.Record("orders", 200, 50*time.Millisecond)
+	}
+
+	// The last minute: 96 good, 2 slow and 2 failed
+	now = now.Add(time.Hour)
+	for i := 0; i < 96; i++ {
+		tracker.Record("orders", 200, 50*time.Millisecond)
+	}
+	tracker.Record("orders", 200, time.Second)
+	tracker.Record("orders", 200, time.Second)
+	tracker.Record("orders", 503, time.Millisecond)
+	tracker.Record("orders", 500, time.Millisecond)
+	tracker.Record("users", 500, time.Millisecond)
+
+	statuses := tracker.Statuses()
+	if len(statuses) != 1 {
+		t.Fatalf("Expected 1 SLO, got %+v", statuses)
+	}
+	s := statuses[0]
+	if s.Requests != 200 || s.BadRequests != 4 {
+		t.Errorf("Expected 4 bad of 200 requests, got %d of %d", s.BadRequests, s.Requests)
+	}
+
+	// 4% bad in the last 5 minutes against 1% allowed
+	if rate := s.BurnRates["5m"]; math.Abs(rate-4) > 1e-9 {
+		t.Errorf("Expected a 5m burn rate of 4, got %v", rate)
+	}
+	// The hour ago is still in the 6h window
+	if rate := s.BurnRates["6h"]; math.Abs(rate-2) > 1e-9 {
+		t.Errorf("Expected a 6h burn rate of 2, got %v", rate)
+	}
+	// 2% bad over the period: twice the budget spent
+	if math.Abs(s.ErrorBudgetRemaining+1) > 1e-9 || math.Abs(s.Compliance-98) > 1e-9 {
+		t.Errorf("Expected the budget overspent by 100%% at 98%% compliance, got %v at %v", s.ErrorBudgetRemaining, s.Compliance)
+	}
+
+	// Old minutes leave the short windows
+	now = now.Add(10 * time.Minute)
+	if rate := tracker.Statuses()[0].BurnRates["5m"]; rate != 0 {
+		t.Errorf("Expected no burn in a quiet 5m window, got %v", rate)
+	}
+}
+
+func TestErrorBudgetPeriod(t *testing.T) {
+	tracker := New([]config.SLOConfig{{Name: "api", Route: "default", Objective: 99.9, PeriodDays: 1}})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	tracker.Record("default", 500, time.Millisecond)
+	if s := tracker.Statuses()[0]; s.ErrorBudgetRemaining >= 0 || s.PeriodDays != 1 {
+		t.Errorf("Expected a lone failure to overspend the budget, got %+v", s)
+	}
+
+	// A day later the failure is out of the period
+	now = now.Add(25 * time.Hour)
+	if s := tracker.Statuses()[0]; s.Requests != 0 || s.ErrorBudgetRemaining != 1 {
+		t.Errorf("Expected a full budget once the failure aged out, got %+v", s)
+	}
+}
@@ -0,0 +1,195 @@
+// Package synthetics runs configured probe requests through the gateway's
+// full handler chain and reports whether each one passes. Backend health
+// checks only prove the backends are up; probes prove clients can still get
+// through the gateway to them.
+package synthetics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// ProbeHeader marks synthetic requests so backends can tell them apart
+const ProbeHeader = "X-GateKeeper-Synthetic"
+
+// maxBody is how much of a response is kept to check ExpectBody
+const maxBody = 1 << 20
+
+// Result is the outcome of one probe run
+type Result struct {
+	Probe    string
+	Passed   bool
+	Status   int
+	Duration time.Duration
+	Error    string
+}
+
+type Runner struct {
+	interval time.Duration
+	probes   []config.ProbeConfig
+	handler  http.Handler
+
+	mu     sync.Mutex
+	passed map[string]bool
+}
+
+// New prepares probes against handler, normally the handler the server
+// serves clients with
+func New(cfg config.SyntheticsConfig, handler http.Handler) *Runner {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 30
+	}
+
+	probes := make([]config.ProbeConfig, len(cfg.Probes))
+	for i, probe := range cfg.Probes {
+		if probe.Name == "" {
+			probe.Name = fmt.Sprintf("probe-%d", i)
+		}
+		if probe.Method == "" {
+			probe.Method = http.MethodGet
+		}
+		if probe.Host == "" {
+			probe.Host = "localhost"
+		}
+		if probe.Path == "" {
+			probe.Path = "/"
+		}
+		if probe.ExpectStatus == 0 {
+			probe.ExpectStatus = http.StatusOK
+		}
+		if probe.Timeout <= 0 {
+			probe.Timeout = 10
+		}
+		probes[i] = probe
+	}
+
+	return &Runner{
+		interval: time.Duration(cfg.Interval) * time.Second,
+		probes:   probes,
+		handler:  handler,
+		passed:   make(map[string]bool),
+	}
+}
+
+// Start runs the probes now and then every interval
+func (r *Runner) Start() {
+	logger.Info("Running %d synthetic probes every %s", len(r.probes), r.interval)
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			r.RunOnce(context.Background())
+			<-ticker.C
+		}
+	}()
+}
+
+// RunOnce runs every probe once and records the results
+func (r *Runner) RunOnce(ctx context.Context) []Result {
+	results := make([]Result, len(r.probes))
+	for i, probe := range r.probes {
+		results[i] = r.run(ctx, probe)
+		r.record(results[i])
+	}
+	return results
+}
+
+func (r *Runner) run(ctx context.Context, probe config.ProbeConfig) Result {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(probe.Timeout)*time.Second)
+	defer cancel()
+
+	result := Result{Probe: probe.Name}
+
+	req, err := http.NewRequestWithContext(ctx, probe.Method, "http://"+probe.Host+probe.Path, strings.NewReader(probe.Body))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	req.RequestURI = req.URL.RequestURI()
+	for name, value := range probe.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set(ProbeHeader, probe.Name)
+
+	rec := newRecorder()
+	start := time.Now()
+	r.handler.ServeHTTP(rec, req)
+	result.Duration = time.Since(start)
+	result.Status = rec.status
+
+	switch {
+	case ctx.Err() != nil:
+		result.Error = "timed out"
+	case rec.status != probe.ExpectStatus:
+		result.Error = fmt.Sprintf("expected status %d, got %d", probe.ExpectStatus, rec.status)
+	case probe.ExpectBody != "" && !bytes.Contains(rec.body.Bytes(), []byte(probe.ExpectBody)):
+		result.Error = fmt.Sprintf("body does not contain %q", probe.ExpectBody)
+	default:
+		result.Passed = true
+	}
+	return result
+}
+
+// record updates metrics and logs when a probe changes state
+func (r *Runner) record(result Result) {
+	metrics.RecordSyntheticProbe(result.Probe, result.Passed, result.Duration)
+
+	r.mu.Lock()
+	previous, seen := r.passed[result.Probe]
+	r.passed[result.Probe] = result.Passed
+	r.mu.Unlock()
+
+	switch {
+	case !result.Passed && (!seen || previous):
+		logger.Warn("Synthetic probe %s failing: %s", result.Probe, result.Error)
+	case result.Passed && seen && !previous:
+		logger.Info("Synthetic probe %s passing again", result.Probe)
+	}
+}
+
+// recorder captures a probe response, keeping up to maxBody bytes
+type recorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: make(http.Header), status: http.StatusOK}
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader = true
+	rec.status = status
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	if room := maxBody - rec.body.Len(); room > 0 {
+		if len(b) > room {
+			rec.body.Write(b[:room])
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return len(b), nil
+}
@@ -0,0 +1,64 @@
+package synthetics
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestRunOnce(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(ProbeHeader) == "" {
+			t.Error("Expected probe requests to be marked")
+		}
+		switch r.URL.Path {
+		case "/api/status":
+			if r.Header.Get("Authorization") != "Bearer probe" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"status":"ok"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	runner := New(config.SyntheticsConfig{Probes: []config.ProbeConfig{
+		{Name: "status", Path: "/api/status", Headers: map[string]string{"Authorization": "Bearer probe"}, ExpectBody: `"ok"`},
+		{Name: "unauthenticated", Path: "/api/status", ExpectStatus: http.StatusUnauthorized},
+		{Name: "wrong-body", Path: "/api/status", Headers: map[string]string{"Authorization": "Bearer probe"}, ExpectBody: "degraded"},
+		{Name: "missing", Path: "/nope"},
+	}}, handler)
+
+	results := runner.RunOnce(context.Background())
+
+	expected := []bool{true, true, false, false}
+	for i, result := range results {
+		if result.Passed != expected[i] {
+			t.Errorf("Probe %s: expected passed=%v, got %v (%s)", result.Probe, expected[i], result.Passed, result.Error)
+		}
+	}
+	if results[3].Status != http.StatusNotFound {
+		t.Errorf("Expected status 404 to be reported, got %d", results[3].Status)
+	}
+}
+
+func TestRunOnceTimeout(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+
+	runner := New(config.SyntheticsConfig{Probes: []config.ProbeConfig{{Name: "slow", Timeout: 1}}}, handler)
+
+	start := time.Now()
+	results := runner.RunOnce(context.Background())
+	if results[0].Passed || results[0].Error != "timed out" {
+		t.Errorf("Expected probe to time out, got %+v", results[0])
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Error("Expected probe to give up after its timeout")
+	}
+}
@@ -0,0 +1,112 @@
+package tcpproxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/loadbalancer"
+)
+
+// errHelloRead stops the handshake once the ClientHello has been parsed
+var errHelloRead = errors.New("client hello read")
+
+// sniRoute balances connections for some server names over a subset of
+// the proxy's backends
+type sniRoute struct {
+	hosts    []string
+	backends map[string]bool
+	lb       *loadbalancer.LoadBalancer
+}
+
+func newSNIRoute(cfg config.SNIRoute, backends []config.Backend, algorithm string) *sniRoute {
+	r := &sniRoute{backends: make(map[string]bool, len(cfg.Backends))}
+	for _, host := range cfg.Hosts {
+		r.hosts = append(r.hosts, strings.ToLower(host))
+	}
+	for _, name := range cfg.Backends {
+		r.backends[name] = true
+	}
+
+	var members []config.Backend
+	for _, backend := range backends {
+		if r.backends[backend.Name] {
+			members = append(members, backend)
+		}
+	}
+	r.lb = loadbalancer.New(members)
+	if algorithm != "" {
+		r.lb.SetAlgorithm(algorithm)
+	}
+	return r
+}
+
+func (r *sniRoute) matches(serverName string) bool {
+	for _, host := range r.hosts {
+		switch {
+		case host == "*":
+			return true
+		case strings.HasPrefix(host, "*."):
+			if strings.HasSuffix(serverName, host[1:]) {
+				return true
+			}
+		case host == serverName:
+			return true
+		}
+	}
+	return false
+}
+
+// route returns the load balancer for serverName, or nil when no route
+// matches
+func (p *Proxy) route(serverName string) *loadbalancer.LoadBalancer {
+	serverName = strings.ToLower(serverName)
+	for _, r := range p.routes {
+		if r.matches(serverName) {
+			return r.lb
+		}
+	}
+	return nil
+}
+
+// readServerName reads the ClientHello from conn and returns the server
+// name it asks for, along with the bytes read, which the backend still
+// needs. crypto/tls parses the hello; the handshake is abandoned before
+// anything is sent back.
+func readServerName(conn net.Conn, timeout time.Duration) (string, []byte, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var hello bytes.Buffer
+	var serverName string
+	err := tls.Server(readOnlyConn{r: io.TeeReader(conn, &hello)}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = info.ServerName
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if !errors.Is(err, errHelloRead) {
+		return "", hello.Bytes(), err
+	}
+	return serverName, hello.Bytes(), nil
+}
+
+// readOnlyConn lets crypto/tls read a ClientHello without writing to the
+// client
+type readOnlyConn struct {
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(b []byte) (int, error)         { return c.r.Read(b) }
+func (c readOnlyConn) Write(b []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
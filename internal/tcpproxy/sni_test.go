@@ -0,0 +1,100 @@
+package tcpproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// tlsBackend is a TLS server, with its own certificate, that answers with
+// its name
+func tlsBackend(t *testing.T, name string) config.Backend {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return config.Backend{Name: name, URL: "tcp://" + strings.TrimPrefix(srv.URL, "https://")}
+}
+
+// getVia requests https://serverName/ through the proxy and returns the body
+func getVia(p *Proxy, serverName string) (string, error) {
+	client := &http.Client{
+		Timeout: 2 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, p.Addr().String())
+			},
+		},
+	}
+	resp, err := client.Get("https://" + serverName + "/")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestSNIRouting(t *testing.T) {
+	p := New(config.TCPProxyConfig{
+		Name:     "sni",
+		Address:  "127.0.0.1:0",
+		Backends: []config.Backend{tlsBackend(t, "payments"), tlsBackend(t, "tenants")},
+		SNI: []config.SNIRoute{
+			{Hosts: []string{"payments.example.com"}, Backends: []string{"payments"}},
+			{Hosts: []string{"*.tenants.example.com"}, Backends: []string{"tenants"}},
+		},
+	})
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Shutdown(context.Background())
+
+	for serverName, want := range map[string]string{
+		"payments.example.com":      "payments",
+		"PAYMENTS.example.com":      "payments",
+		"acme.tenants.example.com":  "tenants",
+		"other.tenants.example.com": "tenants",
+	} {
+		if got, err := getVia(p, serverName); err != nil || got != want {
+			t.Errorf("%s: got %q, %v, want %q", serverName, got, err, want)
+		}
+	}
+
+	if got, err := getVia(p, "unknown.example.com"); err == nil {
+		t.Errorf("unknown.example.com: got %q, want the connection closed", got)
+	}
+}
+
+func TestSNIRoutingRejectsPlainConnections(t *testing.T) {
+	p := New(config.TCPProxyConfig{
+		Name:           "sni",
+		Address:        "127.0.0.1:0",
+		Backends:       []config.Backend{{Name: "echo", URL: "tcp://" + echoServer(t, "echo")}},
+		SNI:            []config.SNIRoute{{Hosts: []string{"*"}, Backends: []string{"echo"}}},
+		ConnectTimeout: 1,
+	})
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Shutdown(context.Background())
+
+	if reply, err := exchange(t, p, "ping"); err == nil {
+		t.Errorf("got reply %q, want the connection closed", reply)
+	}
+}
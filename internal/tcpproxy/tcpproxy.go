@@ -0,0 +1,329 @@
+// Package tcpproxy forwards raw TCP connections to a pool of backends. It
+// fronts protocols the HTTP routes cannot carry, such as databases or MQTT,
+// with the same load balancer, health tracking and metrics as HTTP
+// backends. Connections are passed through untouched: there is no TLS
+// termination and no protocol awareness.
+package tcpproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/loadbalancer"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/upgrade"
+)
+
+// Proxy accepts connections on one address and pipes each to a healthy
+// backend
+type Proxy struct {
+	name           string
+	address        string
+	backends       map[string]string // backend name to host:port
+	lb             *loadbalancer.LoadBalancer
+	routes         []*sniRoute
+	connectTimeout time.Duration
+	idleTimeout    time.Duration
+	interval       time.Duration
+	checkTimeout   time.Duration
+
+	listener net.Listener
+	stop     chan struct{}
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// New prepares the proxy described by cfg, which must have passed config
+// validation
+func New(cfg config.TCPProxyConfig) *Proxy {
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = 5
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 3600
+	}
+	if cfg.HealthCheck.Interval <= 0 {
+		cfg.HealthCheck.Interval = 10
+	}
+	if cfg.HealthCheck.Timeout <= 0 {
+		cfg.HealthCheck.Timeout = 2
+	}
+
+	p := &Proxy{
+		name:           cfg.Name,
+		address:        cfg.Address,
+		backends:       make(map[string]string, len(cfg.Backends)),
+		lb:             loadbalancer.New(cfg.Backends),
+		connectTimeout: time.Duration(cfg.ConnectTimeout) * time.Second,
+		idleTimeout:    time.Duration(cfg.IdleTimeout) * time.Second,
+		interval:       time.Duration(cfg.HealthCheck.Interval) * time.Second,
+		checkTimeout:   time.Duration(cfg.HealthCheck.Timeout) * time.Second,
+		stop:           make(chan struct{}),
+		conns:          make(map[net.Conn]struct{}),
+	}
+	if cfg.Algorithm != "" {
+		p.lb.SetAlgorithm(cfg.Algorithm)
+	}
+	for _, backend := range cfg.Backends {
+		u, _ := url.Parse(backend.URL)
+		p.backends[backend.Name] = u.Host
+	}
+	for _, route := range cfg.SNI {
+		p.routes = append(p.routes, newSNIRoute(route, cfg.Backends, cfg.Algorithm))
+	}
+	return p
+}
+
+// Start binds the proxy's address, then accepts connections and checks
+// backend health in the background
+func (p *Proxy) Start() error {
+	ln, err := upgrade.Listen("tcp", p.address)
+	if err != nil {
+		return err
+	}
+	p.listener = ln
+	logger.Info("TCP proxy %s listening on %s", p.name, ln.Addr())
+
+	go p.checkHealth()
+	go p.serve()
+	return nil
+}
+
+// Addr returns the bound address, which differs from the configured one
+// when that used port 0
+func (p *Proxy) Addr() net.Addr {
+	return p.listener.Addr()
+}
+
+// Shutdown stops accepting connections and waits for open ones to finish.
+// When ctx ends first the rest are closed and ctx's error is returned.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	close(p.stop)
+	p.listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		for conn := range p.conns {
+			conn.Close()
+		}
+		p.mu.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (p *Proxy) serve() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Warn("TCP proxy %s failed to accept a connection: %v", p.name, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		p.wg.Add(1)
+		go p.handle(client)
+	}
+}
+
+func (p *Proxy) handle(client net.Conn) {
+	defer p.wg.Done()
+	defer client.Close()
+
+	lb := p.lb
+	var hello []byte
+	if len(p.routes) > 0 {
+		serverName, data, err := readServerName(client, p.connectTimeout)
+		if err != nil {
+			metrics.RecordTCPConnection(p.name, "", "no_route")
+			logger.Warn("TCP proxy %s could not read a TLS ClientHello from %s: %v", p.name, client.RemoteAddr(), err)
+			return
+		}
+		if lb = p.route(serverName); lb == nil {
+			metrics.RecordTCPConnection(p.name, "", "no_route")
+			logger.Warn("TCP proxy %s has no route for server name %q, closing connection", p.name, serverName)
+			return
+		}
+		hello = data
+	}
+
+	upstream, backend := p.dial(lb)
+	if upstream == nil {
+		return
+	}
+	defer upstream.Close()
+
+	// The ClientHello read for routing goes to the backend first
+	if len(hello) > 0 {
+		if _, err := upstream.Write(hello); err != nil {
+			logger.Warn("TCP proxy %s failed to forward to backend %s: %v", p.name, backend, err)
+			return
+		}
+	}
+
+	p.track(client, true)
+	p.track(upstream, true)
+	metrics.AddTCPActiveConnections(p.name, 1)
+	defer func() {
+		p.track(client, false)
+		p.track(upstream, false)
+		metrics.AddTCPActiveConnections(p.name, -1)
+	}()
+
+	logger.Debug("TCP proxy %s: %s connected to %s", p.name, client.RemoteAddr(), backend)
+	in, out := p.pipe(client, upstream)
+	in += int64(len(hello))
+	metrics.RecordTCPBytes(p.name, in, out)
+	logger.Debug("TCP proxy %s: %s disconnected from %s (%d bytes in, %d out)", p.name, client.RemoteAddr(), backend, in, out)
+}
+
+// dial connects to a healthy backend picked by lb. A backend that refuses
+// is marked unhealthy right away and the next one is tried, so clients
+// only see errors when no backend accepts.
+func (p *Proxy) dial(lb *loadbalancer.LoadBalancer) (net.Conn, string) {
+	for range p.backends {
+		backend := lb.NextBackend()
+		if backend == nil {
+			break
+		}
+
+		start := time.Now()
+		upstream, err := net.DialTimeout("tcp", p.backends[backend.Name], p.connectTimeout)
+		if err != nil {
+			metrics.RecordTCPConnection(p.name, backend.Name, "dial_error")
+			logger.Warn("TCP proxy %s failed to connect to backend %s: %v", p.name, backend.Name, err)
+			p.report(backend.Name, loadbalancer.HealthReport{Healthy: false, Reason: err.Error(), Latency: time.Since(start), Source: "connection"})
+			continue
+		}
+		metrics.RecordTCPConnection(p.name, backend.Name, "ok")
+		return upstream, backend.Name
+	}
+
+	metrics.RecordTCPConnection(p.name, "", "no_backend")
+	logger.Warn("TCP proxy %s has no healthy backend, closing connection", p.name)
+	return nil, ""
+}
+
+// pipe copies both ways until both sides are done or the connection has
+// been idle in both directions for the idle timeout. Unlike CONNECT
+// tunnels, one side finishing only closes the other's write half, since
+// protocols may send a last reply after the client stops sending.
+func (p *Proxy) pipe(client, upstream net.Conn) (in, out int64) {
+	touch := func() {
+		deadline := time.Now().Add(p.idleTimeout)
+		client.SetDeadline(deadline)
+		upstream.SetDeadline(deadline)
+	}
+	touch()
+
+	copyConn := func(dst, src net.Conn, n *int64, done chan<- error) {
+		buf := make([]byte, 32*1024)
+		for {
+			read, err := src.Read(buf)
+			if read > 0 {
+				touch()
+				if _, werr := dst.Write(buf[:read]); werr != nil {
+					done <- werr
+					return
+				}
+				*n += int64(read)
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+					if tcp, ok := dst.(*net.TCPConn); ok {
+						tcp.CloseWrite()
+					}
+				}
+				done <- err
+				return
+			}
+		}
+	}
+
+	done := make(chan error, 2)
+	go copyConn(upstream, client, &in, done)
+	go copyConn(client, upstream, &out, done)
+
+	// A failure in either direction, including the idle deadline, ends the
+	// connection; closing both unblocks the other copy
+	if err := <-done; err != nil {
+		client.Close()
+		upstream.Close()
+	}
+	if err := <-done; err != nil {
+		client.Close()
+		upstream.Close()
+	}
+	return in, out
+}
+
+func (p *Proxy) track(conn net.Conn, open bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if open {
+		p.conns[conn] = struct{}{}
+	} else {
+		delete(p.conns, conn)
+	}
+}
+
+// checkHealth checks every backend now and then every interval
+func (p *Proxy) checkHealth() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		for name, address := range p.backends {
+			go p.checkBackend(name, address)
+		}
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// checkBackend counts a backend as healthy when it accepts a connection
+func (p *Proxy) checkBackend(name, address string) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, p.checkTimeout)
+	latency := time.Since(start)
+	if err != nil {
+		logger.Warn("Health check failed for TCP backend %s: %v", name, err)
+		p.report(name, loadbalancer.HealthReport{Healthy: false, Reason: err.Error(), Latency: latency, Source: "health_check"})
+		return
+	}
+	conn.Close()
+	p.report(name, loadbalancer.HealthReport{Healthy: true, Reason: "connection accepted", Latency: latency, Source: "health_check"})
+}
+
+func (p *Proxy) report(backend string, report loadbalancer.HealthReport) {
+	p.lb.ReportHealth(backend, report)
+	for _, route := range p.routes {
+		if route.backends[backend] {
+			route.lb.ReportHealth(backend, report)
+		}
+	}
+	metrics.SetBackendStatus(backend, report.Healthy)
+}
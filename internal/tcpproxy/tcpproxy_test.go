@@ -0,0 +1,202 @@
+package tcpproxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// echoServer answers every line with the server's name and the line
+func echoServer(t *testing.T, name string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					io.WriteString(conn, name+": "+scanner.Text()+"\n")
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// closedAddress returns an address nothing listens on
+func closedAddress(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+	return address
+}
+
+func startProxy(t *testing.T, backends ...config.Backend) *Proxy {
+	t.Helper()
+	p := New(config.TCPProxyConfig{Name: "test", Address: "127.0.0.1:0", Backends: backends, ConnectTimeout: 1})
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		p.Shutdown(ctx)
+	})
+	return p
+}
+
+// exchange sends line through the proxy and returns the reply
+func exchange(t *testing.T, p *Proxy, line string) (string, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", p.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := io.WriteString(conn, line+"\n"); err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	return strings.TrimSuffix(reply, "\n"), err
+}
+
+func TestProxyBalancesConnections(t *testing.T) {
+	p := startProxy(t,
+		config.Backend{Name: "a", URL: "tcp://" + echoServer(t, "a")},
+		config.Backend{Name: "b", URL: "tcp://" + echoServer(t, "b")},
+	)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		reply, err := exchange(t, p, "ping")
+		if err != nil {
+			t.Fatalf("exchange %d: %v", i, err)
+		}
+		seen[reply] = true
+	}
+	if !seen["a: ping"] || !seen["b: ping"] || len(seen) != 2 {
+		t.Errorf("replies = %v, want a and b", seen)
+	}
+}
+
+func TestProxySkipsUnreachableBackend(t *testing.T) {
+	p := startProxy(t,
+		config.Backend{Name: "down", URL: "tcp://" + closedAddress(t)},
+		config.Backend{Name: "up", URL: "tcp://" + echoServer(t, "up")},
+	)
+
+	for i := 0; i < 3; i++ {
+		reply, err := exchange(t, p, "ping")
+		if err != nil || reply != "up: ping" {
+			t.Errorf("exchange %d = %q, %v, want reply from up", i, reply, err)
+		}
+	}
+	for _, status := range p.lb.GetHealthyBackends() {
+		if status.Backend.Name == "down" {
+			t.Error("unreachable backend is still healthy")
+		}
+	}
+}
+
+func TestProxyClosesWithoutBackend(t *testing.T) {
+	p := startProxy(t, config.Backend{Name: "down", URL: "tcp://" + closedAddress(t)})
+
+	if reply, err := exchange(t, p, "ping"); err == nil {
+		t.Errorf("got reply %q, want the connection closed", reply)
+	}
+}
+
+func TestProxyForwardsAfterHalfClose(t *testing.T) {
+	// The backend answers only once the client has finished sending
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				body, _ := io.ReadAll(conn)
+				io.WriteString(conn, strings.ToUpper(string(body)))
+			}()
+		}
+	}()
+
+	p := startProxy(t, config.Backend{Name: "upper", URL: "tcp://" + ln.Addr().String()})
+	conn, err := net.Dial("tcp", p.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	io.WriteString(conn, "hello")
+	conn.(*net.TCPConn).CloseWrite()
+	reply, err := io.ReadAll(conn)
+	if err != nil || string(reply) != "HELLO" {
+		t.Errorf("reply = %q, %v, want HELLO", reply, err)
+	}
+}
+
+func TestShutdownClosesIdleConnections(t *testing.T) {
+	p := New(config.TCPProxyConfig{
+		Name:     "test",
+		Address:  "127.0.0.1:0",
+		Backends: []config.Backend{{Name: "a", URL: "tcp://" + echoServer(t, "a")}},
+	})
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exchange(t, p, "ping"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", p.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "ping\n")
+	bufio.NewReader(conn).ReadString('\n')
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read after shutdown = %v, want EOF", err)
+	}
+	if _, err := net.DialTimeout("tcp", p.Addr().String(), time.Second); err == nil {
+		t.Error("proxy still accepts connections after shutdown")
+	}
+}
@@ -0,0 +1,258 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+)
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// ServerConfig builds the listener TLS configuration, including the client
+// CA pool and CRL check when mutual TLS is configured.
+func ServerConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	clientAuth, ok := clientAuthTypes[strings.ToLower(cfg.ClientAuth)]
+	if !ok {
+		return nil, fmt.Errorf("invalid clientAuth %q", cfg.ClientAuth)
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: clientAuth,
+	}
+
+	if cfg.ClientCAFile != "" {
+		pool, err := LoadCertPool(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.ClientCAs = pool
+	} else if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		return nil, errors.New("clientAuth requires clientCAFile to verify certificates")
+	}
+
+	if cfg.CRLFile != "" {
+		if cfg.ClientCAFile == "" {
+			return nil, errors.New("crlFile requires clientCAFile to verify the CRL")
+		}
+		issuers, err := loadCertificates(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		crl := &crlChecker{path: cfg.CRLFile, issuers: issuers, now: time.Now}
+		if err := crl.reload(); err != nil {
+			return nil, err
+		}
+		tlsCfg.VerifyPeerCertificate = crl.verify
+	}
+
+	return tlsCfg, nil
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA file %s: %w", path, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", path)
+	}
+	return pool, nil
+}
+
+// loadCertificates reads the certificates in a PEM bundle
+func loadCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA file %s: %w", path, err)
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing CA file %s: %w", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in CA file %s", path)
+	}
+	return certs, nil
+}
+
+// crlChecker rejects client certificates listed in a CRL file. The file is
+// re-read when its modification time changes so a new CRL can be dropped
+// in place without a restart. Only CRLs signed by one of issuers, the
+// client CAs, and not past their NextUpdate are accepted.
+type crlChecker struct {
+	path    string
+	issuers []*x509.Certificate
+	now     func() time.Time
+
+	mu         sync.RWMutex
+	modTime    time.Time
+	nextUpdate time.Time
+	revoked    map[string]bool
+}
+
+func (c *crlChecker) reload() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return fmt.Errorf("reading CRL file %s: %w", c.path, err)
+	}
+
+	c.mu.RLock()
+	unchanged := info.ModTime().Equal(c.modTime)
+	c.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("reading CRL file %s: %w", c.path, err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+
+	list, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return fmt.Errorf("parsing CRL file %s: %w", c.path, err)
+	}
+	if err := c.checkSignature(list); err != nil {
+		return fmt.Errorf("CRL file %s: %w", c.path, err)
+	}
+	if !list.NextUpdate.IsZero() && c.now().After(list.NextUpdate) {
+		return fmt.Errorf("CRL file %s expired at %s", c.path, list.NextUpdate.Format(time.RFC3339))
+	}
+
+	revoked := make(map[string]bool, len(list.RevokedCertificateEntries))
+	for _, entry := range list.RevokedCertificateEntries {
+		revoked[revocationKey(list.RawIssuer, entry.SerialNumber.Bytes())] = true
+	}
+
+	c.mu.Lock()
+	c.revoked = revoked
+	c.modTime = info.ModTime()
+	c.nextUpdate = list.NextUpdate
+	c.mu.Unlock()
+
+	logger.Info("Loaded CRL %s with %d revoked certificates", c.path, len(revoked))
+	return nil
+}
+
+// checkSignature finds the client CA that issued list and checks its
+// signature
+func (c *crlChecker) checkSignature(list *x509.RevocationList) error {
+	for _, issuer := range c.issuers {
+		if !bytes.Equal(issuer.RawSubject, list.RawIssuer) {
+			continue
+		}
+		if err := list.CheckSignatureFrom(issuer); err == nil {
+			return nil
+		}
+	}
+	return errors.New("not signed by a client CA")
+}
+
+func (c *crlChecker) verify(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if err := c.reload(); err != nil {
+		// Keep using the last good CRL rather than locking everyone out
+		logger.Error("CRL reload failed: %v", err)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// Revocations since NextUpdate are unknown, so no certificate passes
+	if !c.nextUpdate.IsZero() && c.now().After(c.nextUpdate) {
+		return fmt.Errorf("CRL %s expired at %s", c.path, c.nextUpdate.Format(time.RFC3339))
+	}
+
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if c.revoked[revocationKey(cert.RawIssuer, cert.SerialNumber.Bytes())] {
+				return fmt.Errorf("certificate %s has been revoked", cert.Subject)
+			}
+		}
+	}
+	return nil
+}
+
+func revocationKey(issuer, serial []byte) string {
+	return string(issuer) + "/" + string(serial)
+}
+
+// ClientCertHeader formats a verified client certificate in the style of
+// Envoy's X-Forwarded-Client-Cert header.
+func ClientCertHeader(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	parts := []string{
+		"Hash=" + hex.EncodeToString(hash[:]),
+		fmt.Sprintf("Subject=%q", cert.Subject.String()),
+	}
+
+	for _, uri := range cert.URIs {
+		parts = append(parts, "URI="+uri.String())
+	}
+	for _, dns := range cert.DNSNames {
+		parts = append(parts, "DNS="+dns)
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// ReadPEM returns value itself if it holds PEM, as it does when fetched
+// from a secrets provider, and otherwise reads the file it names
+func ReadPEM(value string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		return []byte(value), nil
+	}
+	return os.ReadFile(value)
+}
+
+// LoadKeyPair is tls.LoadX509KeyPair for a certificate and key that are
+// each PEM or a path to it
+func LoadKeyPair(cert, key string) (tls.Certificate, error) {
+	certPEM, err := ReadPEM(cert)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := ReadPEM(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
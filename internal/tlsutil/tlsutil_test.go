@@ -0,0 +1,234 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64, cn string) tls.Certificate {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (ca *testCA) writeFiles(t *testing.T, revoked ...int64) (caFile, crlFile string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	caFile = filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600)
+
+	crlFile = filepath.Join(dir, "ca.crl")
+	os.WriteFile(crlFile, ca.crl(t, time.Now().Add(time.Hour), revoked...), 0o600)
+
+	return caFile, crlFile
+}
+
+// crl returns a PEM CRL revoking serials until nextUpdate
+func (ca *testCA) crl(t *testing.T, nextUpdate time.Time, revoked ...int64) []byte {
+	t.Helper()
+	var entries []x509.RevocationListEntry
+	for _, serial := range revoked {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                nextUpdate.Add(-2 * time.Hour),
+		NextUpdate:                nextUpdate,
+		RevokedCertificateEntries: entries,
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func TestServerConfigMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	caFile, crlFile := ca.writeFiles(t, 3)
+
+	tlsCfg, err := ServerConfig(config.TLSConfig{
+		ClientCAFile: caFile,
+		ClientAuth:   "require_and_verify",
+		CRLFile:      crlFile,
+	})
+	if err != nil {
+		t.Fatalf("Failed to build TLS config: %v", err)
+	}
+
+	var forwarded string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = ClientCertHeader(r.TLS.VerifiedChains[0][0])
+	}))
+	server.TLS = tlsCfg
+	server.StartTLS()
+	defer server.Close()
+
+	request := func(cert *tls.Certificate) error {
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		if cert != nil {
+			transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+		}
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := request(nil); err == nil {
+		t.Error("Expected request without client certificate to fail")
+	}
+
+	good := ca.issue(t, 2, "client.example.com")
+	if err := request(&good); err != nil {
+		t.Fatalf("Expected valid client certificate to be accepted: %v", err)
+	}
+	if !strings.Contains(forwarded, "DNS=client.example.com") || !strings.HasPrefix(forwarded, "Hash=") {
+		t.Errorf("Unexpected client cert header: %v", forwarded)
+	}
+
+	revoked := ca.issue(t, 3, "revoked.example.com")
+	if err := request(&revoked); err == nil {
+		t.Error("Expected revoked client certificate to be rejected")
+	}
+}
+
+func TestServerConfigRejectsUntrustedCRL(t *testing.T) {
+	ca := newTestCA(t)
+	caFile, crlFile := ca.writeFiles(t)
+	tlsCfg := config.TLSConfig{ClientCAFile: caFile, ClientAuth: "require_and_verify", CRLFile: crlFile}
+
+	// Signed by another CA with the same name
+	os.WriteFile(crlFile, newTestCA(t).crl(t, time.Now().Add(time.Hour), 2), 0o600)
+	if _, err := ServerConfig(tlsCfg); err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Errorf("Expected a CRL from another CA to be rejected, got %v", err)
+	}
+
+	os.WriteFile(crlFile, ca.crl(t, time.Now().Add(-time.Minute)), 0o600)
+	if _, err := ServerConfig(tlsCfg); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Expected an expired CRL to be rejected, got %v", err)
+	}
+
+	tlsCfg.ClientCAFile = ""
+	tlsCfg.ClientAuth = "request"
+	if _, err := ServerConfig(tlsCfg); err == nil {
+		t.Error("Expected a CRL without a client CA to check it against to be rejected")
+	}
+}
+
+func TestCRLExpiresInUse(t *testing.T) {
+	ca := newTestCA(t)
+	caFile, crlFile := ca.writeFiles(t)
+	issuers, _ := loadCertificates(caFile)
+
+	now := time.Now()
+	crl := &crlChecker{path: crlFile, issuers: issuers, now: func() time.Time { return now }}
+	if err := crl.reload(); err != nil {
+		t.Fatal(err)
+	}
+	chain := [][]*x509.Certificate{{ca.cert}}
+	if err := crl.verify(nil, chain); err != nil {
+		t.Fatalf("Expected a current CRL to pass certificates, got %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := crl.verify(nil, chain); err == nil {
+		t.Error("Expected certificates to be rejected once the CRL is past its next update")
+	}
+}
+
+func TestServerConfigValidation(t *testing.T) {
+	if _, err := ServerConfig(config.TLSConfig{ClientAuth: "sometimes"}); err == nil {
+		t.Error("Expected error for unknown clientAuth")
+	}
+
+	if _, err := ServerConfig(config.TLSConfig{ClientAuth: "require_and_verify"}); err == nil {
+		t.Error("Expected error when verifying without a client CA")
+	}
+
+	if _, err := ServerConfig(config.TLSConfig{}); err != nil {
+		t.Errorf("Expected plain TLS config to be valid, got %v", err)
+	}
+}
+
+func TestLoadKeyPair(t *testing.T) {
+	ca := newTestCA(t)
+	issued := ca.issue(t, 2, "gateway.example.com")
+	keyDER, err := x509.MarshalPKCS8PrivateKey(issued.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issued.Certificate[0]}))
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, []byte(certPEM), 0o600)
+	os.WriteFile(keyFile, []byte(keyPEM), 0o600)
+
+	for name, pair := range map[string][2]string{
+		"files": {certFile, keyFile},
+		"pem":   {certPEM, keyPEM},
+		"mixed": {certPEM, keyFile},
+	} {
+		if _, err := LoadKeyPair(pair[0], pair[1]); err != nil {
+			t.Errorf("%s: LoadKeyPair failed: %v", name, err)
+		}
+	}
+	if _, err := LoadKeyPair(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Error("LoadKeyPair succeeded with a missing certificate file")
+	}
+}
@@ -0,0 +1,91 @@
+// Package tracing sets up OpenTelemetry tracing and records policy
+// decisions as span events, so a traced request shows exactly which check
+// allowed or rejected it.
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+const instrumentationName = "github.com/barisgenc/gatekeeper"
+
+// Decision outcomes
+const (
+	Allowed = "allowed"
+	Denied  = "denied"
+)
+
+// Init installs the global tracer provider and W3C trace context
+// propagation. The returned function flushes pending spans on shutdown.
+func Init(cfg config.TracingConfig) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("tracing requires an endpoint")
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "gatekeeper"
+	}
+	ratio := cfg.SampleRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = 1
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the gateway's tracer from the global provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// RecordDecision adds a "policy.decision" event to the request's span.
+// policy names the check (rate_limit, oidc, ext_authz, ...), outcome is
+// Allowed or Denied, and reason says which rule or error decided it. It is
+// a no-op when the request is not being traced.
+func RecordDecision(ctx context.Context, policy, outcome, reason string, attrs ...attribute.KeyValue) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	eventAttrs := append([]attribute.KeyValue{
+		attribute.String("policy.name", policy),
+		attribute.String("policy.decision", outcome),
+	}, attrs...)
+	if reason != "" {
+		eventAttrs = append(eventAttrs, attribute.String("policy.reason", reason))
+	}
+
+	span.AddEvent("policy.decision", trace.WithAttributes(eventAttrs...))
+}
@@ -0,0 +1,48 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRecordDecision(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	ctx, span := tracer.Start(context.Background(), "request")
+	RecordDecision(ctx, "hmac", Denied, "signature replayed", attribute.String("hmac.key_id", "billing"))
+	RecordDecision(ctx, "cors", Allowed, "")
+	span.End()
+
+	events := recorder.Ended()[0].Events()
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %v", events)
+	}
+
+	expected := []map[string]string{
+		{"policy.name": "hmac", "policy.decision": "denied", "policy.reason": "signature replayed", "hmac.key_id": "billing"},
+		{"policy.name": "cors", "policy.decision": "allowed"},
+	}
+	for i, event := range events {
+		attrs := map[string]string{}
+		for _, kv := range event.Attributes {
+			attrs[string(kv.Key)] = kv.Value.Emit()
+		}
+		if event.Name != "policy.decision" || len(attrs) != len(expected[i]) {
+			t.Errorf("Event %d: expected policy.decision with %v, got %s with %v", i+1, expected[i], event.Name, attrs)
+			continue
+		}
+		for key, value := range expected[i] {
+			if attrs[key] != value {
+				t.Errorf("Event %d: expected %s=%q, got %q", i+1, key, value, attrs[key])
+			}
+		}
+	}
+
+	// Without a recording span it does nothing
+	RecordDecision(context.Background(), "hmac", Denied, "no span")
+}
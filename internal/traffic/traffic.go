@@ -0,0 +1,114 @@
+// Package traffic counts bytes moved per backend and per route, keeping
+// totals and a one-minute rolling rate for the admin stats endpoint.
+package traffic
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// window is how many seconds the rolling rate covers
+const window = 60
+
+// Usage is the traffic seen for one backend or route. In counts request
+// body bytes, Out counts response body bytes.
+type Usage struct {
+	Name              string  `json:"name"`
+	BytesIn           int64   `json:"bytesIn"`
+	BytesOut          int64   `json:"bytesOut"`
+	BytesInPerSecond  float64 `json:"bytesInPerSecond"`
+	BytesOutPerSecond float64 `json:"bytesOutPerSecond"`
+}
+
+type bucket struct {
+	second  int64
+	in, out int64
+}
+
+type entry struct {
+	in, out int64
+	buckets [window]bucket
+}
+
+// Stats holds usage per name
+type Stats struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	now     func() time.Time
+}
+
+func NewStats() *Stats {
+	return &Stats{entries: make(map[string]*entry), now: time.Now}
+}
+
+// Add records in and out bytes for name
+func (s *Stats) Add(name string, in, out int64) {
+	second := s.now().Unix()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[name]
+	if !ok {
+		e = &entry{}
+		s.entries[name] = e
+	}
+	e.in += in
+	e.out += out
+
+	b := &e.buckets[second%window]
+	if b.second != second {
+		*b = bucket{second: second}
+	}
+	b.in += in
+	b.out += out
+}
+
+// Snapshot returns usage for every name, sorted by name
+func (s *Stats) Snapshot() []Usage {
+	second := s.now().Unix()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := make([]Usage, 0, len(s.entries))
+	for name, e := range s.entries {
+		u := Usage{Name: name, BytesIn: e.in, BytesOut: e.out}
+		var in, out int64
+		for _, b := range e.buckets {
+			if second-b.second < window {
+				in += b.in
+				out += b.out
+			}
+		}
+		u.BytesInPerSecond = float64(in) / window
+		u.BytesOutPerSecond = float64(out) / window
+		usage = append(usage, u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Name < usage[j].Name })
+	return usage
+}
+
+// CountingReader counts bytes read through it
+type CountingReader struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func NewCountingReader(r io.ReadCloser) *CountingReader {
+	return &CountingReader{ReadCloser: r}
+}
+
+func (r *CountingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// Count is the number of bytes read so far
+func (r *CountingReader) Count() int64 {
+	return r.n.Load()
+}
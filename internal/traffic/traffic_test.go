@@ -0,0 +1,48 @@
+package traffic
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	stats := NewStats()
+	stats.now = func() time.Time { return now }
+
+	stats.Add("api", 100, 6000)
+	now = now.Add(30 * time.Second)
+	stats.Add("api", 20, 0)
+	stats.Add("reports", 0, 1200)
+
+	usage := stats.Snapshot()
+	if len(usage) != 2 || usage[0].Name != "api" || usage[1].Name != "reports" {
+		t.Fatalf("Expected api and reports, got %+v", usage)
+	}
+	if usage[0].BytesIn != 120 || usage[0].BytesOut != 6000 {
+		t.Errorf("Expected totals 120/6000, got %d/%d", usage[0].BytesIn, usage[0].BytesOut)
+	}
+	if usage[0].BytesOutPerSecond != 100 {
+		t.Errorf("Expected 6000 bytes over the window to be 100/s, got %v", usage[0].BytesOutPerSecond)
+	}
+
+	// Once the first burst leaves the window only the totals remember it
+	now = now.Add(45 * time.Second)
+	usage = stats.Snapshot()
+	if usage[0].BytesOutPerSecond != 0 || usage[0].BytesInPerSecond != 20.0/window {
+		t.Errorf("Expected only the later bytes in the rate, got %+v", usage[0])
+	}
+	if usage[0].BytesOut != 6000 {
+		t.Errorf("Expected totals to persist, got %d", usage[0].BytesOut)
+	}
+}
+
+func TestCountingReader(t *testing.T) {
+	r := NewCountingReader(io.NopCloser(strings.NewReader("hello world")))
+	io.ReadAll(r)
+	if r.Count() != 11 {
+		t.Errorf("Expected 11 bytes, got %d", r.Count())
+	}
+}
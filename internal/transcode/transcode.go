@@ -0,0 +1,263 @@
+// Package transcode converts between HTTP/JSON and unary gRPC calls using
+// a compiled descriptor set (protoc --include_imports
+// --descriptor_set_out). No generated code is needed, so REST clients can
+// reach any gRPC service the set describes.
+package transcode
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Set is a loaded descriptor set
+type Set struct {
+	files *protoregistry.Files
+}
+
+// Load reads a binary FileDescriptorSet. It must include the imports of
+// the files it describes.
+func Load(path string) (*Set, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fds descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &fds); err != nil {
+		return nil, fmt.Errorf("descriptor set %s: %w", path, err)
+	}
+	files, err := protodesc.NewFiles(&fds)
+	if err != nil {
+		return nil, fmt.Errorf("descriptor set %s: %w", path, err)
+	}
+	return &Set{files: files}, nil
+}
+
+// Method finds a unary method by its "package.Service/Method" name
+func (s *Set) Method(name string) (*Method, error) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(name, "/"), "/")
+	if !ok {
+		return nil, fmt.Errorf("method %q is not of the form package.Service/Method", name)
+	}
+	desc, err := s.files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service %s is not in the descriptor set", service)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("service %s has no method %s", service, method)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("method %s is streaming; only unary methods can be transcoded", name)
+	}
+	return &Method{desc: md}, nil
+}
+
+// Method is a unary gRPC method
+type Method struct {
+	desc protoreflect.MethodDescriptor
+}
+
+// Name is the method's "package.Service/Method" name
+func (m *Method) Name() string {
+	return string(m.desc.Parent().FullName()) + "/" + string(m.desc.Name())
+}
+
+// Path is the HTTP/2 path gRPC calls the method on
+func (m *Method) Path() string {
+	return "/" + m.Name()
+}
+
+// EncodeRequest builds the request message from a JSON body, which may be
+// empty, and params. Params are field paths such as "id" or "page.size"
+// with their text values, taken from the URL; they override the body.
+func (m *Method) EncodeRequest(body []byte, params map[string]string) ([]byte, error) {
+	msg := dynamicpb.NewMessage(m.desc.Input())
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := protojson.Unmarshal(body, msg); err != nil {
+			return nil, err
+		}
+	}
+	for path, value := range params {
+		if err := setField(msg, path, value); err != nil {
+			return nil, err
+		}
+	}
+	return proto.Marshal(msg)
+}
+
+// DecodeResponse turns the response message into JSON
+func (m *Method) DecodeResponse(message []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(m.desc.Output())
+	if err := proto.Unmarshal(message, msg); err != nil {
+		return nil, err
+	}
+	return protojson.Marshal(msg)
+}
+
+// setField sets the field at a dotted path to a value parsed from text
+func setField(msg protoreflect.Message, path, value string) error {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fd := msg.Descriptor().Fields().ByJSONName(name)
+		if fd == nil {
+			fd = msg.Descriptor().Fields().ByName(protoreflect.Name(name))
+		}
+		if fd == nil {
+			return fmt.Errorf("%s has no field %s", msg.Descriptor().FullName(), name)
+		}
+		if i < len(names)-1 {
+			if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+				return fmt.Errorf("field %s is not a message", name)
+			}
+			msg = msg.Mutable(fd).Message()
+			continue
+		}
+		if fd.IsMap() {
+			return fmt.Errorf("field %s is a map and cannot be set from the URL", name)
+		}
+
+		v, err := parseScalar(fd, value)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", path, err)
+		}
+		if fd.IsList() {
+			msg.Mutable(fd).List().Append(v)
+		} else {
+			msg.Set(fd, v)
+		}
+	}
+	return nil
+}
+
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(s)), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("%q is not a value of %s", s, fd.Enum().FullName())
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	}
+	return protoreflect.Value{}, errors.New("messages cannot be set from the URL")
+}
+
+// HTTPStatus maps a gRPC status code to the HTTP status REST clients get
+func HTTPStatus(code int) int {
+	switch code {
+	case 0:
+		return http.StatusOK
+	case 1: // CANCELLED
+		return 499
+	case 3, 9, 11: // INVALID_ARGUMENT, FAILED_PRECONDITION, OUT_OF_RANGE
+		return http.StatusBadRequest
+	case 4: // DEADLINE_EXCEEDED
+		return http.StatusGatewayTimeout
+	case 5: // NOT_FOUND
+		return http.StatusNotFound
+	case 6, 10: // ALREADY_EXISTS, ABORTED
+		return http.StatusConflict
+	case 7: // PERMISSION_DENIED
+		return http.StatusForbidden
+	case 8: // RESOURCE_EXHAUSTED
+		return http.StatusTooManyRequests
+	case 12: // UNIMPLEMENTED
+		return http.StatusNotImplemented
+	case 14: // UNAVAILABLE
+		return http.StatusServiceUnavailable
+	case 16: // UNAUTHENTICATED
+		return http.StatusUnauthorized
+	}
+	return http.StatusInternalServerError
+}
+
+// Template is an HTTP path with {field} segments, e.g.
+// "/v1/shelves/{shelf}/books/{book.id}"
+type Template struct {
+	segments []string
+}
+
+// ParseTemplate checks and compiles a path template
+func ParseTemplate(path string) (*Template, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path %q must start with /", path)
+	}
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for _, segment := range segments {
+		if strings.ContainsAny(segment, "{}") && !isVariable(segment) {
+			return nil, fmt.Errorf("path %q: segment %q must be a literal or a whole {field}", path, segment)
+		}
+	}
+	return &Template{segments: segments}, nil
+}
+
+// Match reports whether path fits the template and returns the field
+// values it binds
+func (t *Template) Match(path string) (map[string]string, bool) {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(segments) != len(t.segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, segment := range t.segments {
+		if isVariable(segment) {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[segment[1:len(segment)-1]] = segments[i]
+			continue
+		}
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func isVariable(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}' &&
+		!strings.ContainsAny(segment[1:len(segment)-1], "{}")
+}
@@ -0,0 +1,183 @@
+package transcode
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const libraryDescriptor = `
+file {
+  name: "library.proto"
+  package: "library"
+  syntax: "proto3"
+  message_type {
+    name: "Book"
+    field { name: "id" number: 1 type: TYPE_INT64 label: LABEL_OPTIONAL json_name: "id" }
+    field { name: "title" number: 2 type: TYPE_STRING label: LABEL_OPTIONAL json_name: "title" }
+    field { name: "kind" number: 3 type: TYPE_ENUM type_name: ".library.Kind" label: LABEL_OPTIONAL json_name: "kind" }
+    field { name: "page_count" number: 4 type: TYPE_INT32 label: LABEL_OPTIONAL json_name: "pageCount" }
+    field { name: "shelf" number: 5 type: TYPE_MESSAGE type_name: ".library.Shelf" label: LABEL_OPTIONAL json_name: "shelf" }
+  }
+  message_type {
+    name: "Shelf"
+    field { name: "name" number: 1 type: TYPE_STRING label: LABEL_OPTIONAL json_name: "name" }
+  }
+  enum_type {
+    name: "Kind"
+    value { name: "KIND_UNSPECIFIED" number: 0 }
+    value { name: "NOVEL" number: 1 }
+  }
+  service {
+    name: "Library"
+    method { name: "GetBook" input_type: ".library.Book" output_type: ".library.Book" }
+    method { name: "WatchBooks" input_type: ".library.Shelf" output_type: ".library.Book" server_streaming: true }
+  }
+}`
+
+func writeDescriptorSet(t *testing.T) string {
+	var fds descriptorpb.FileDescriptorSet
+	if err := prototext.Unmarshal([]byte(libraryDescriptor), &fds); err != nil {
+		t.Fatalf("Failed to parse descriptor: %v", err)
+	}
+	data, err := proto.Marshal(&fds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "library.pb")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMethod(t *testing.T) {
+	set, err := Load(writeDescriptorSet(t))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	testCases := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"library.Library/GetBook", false},
+		{"/library.Library/GetBook", false},
+		{"library.Library/WatchBooks", true},
+		{"library.Library/Missing", true},
+		{"library.Archive/GetBook", true},
+		{"library.Book/GetBook", true},
+		{"GetBook", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			method, err := set.Method(tc.name)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Expected error: %v, got %v", tc.wantErr, err)
+			}
+			if err == nil && method.Path() != "/library.Library/GetBook" {
+				t.Errorf("Expected path /library.Library/GetBook, got %s", method.Path())
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	set, err := Load(writeDescriptorSet(t))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	method, _ := set.Method("library.Library/GetBook")
+
+	// URL params win over the body and accept both JSON and proto names
+	message, err := method.EncodeRequest([]byte(`{"id": "1", "title": "Dune"}`), map[string]string{
+		"id":         "42",
+		"kind":       "NOVEL",
+		"page_count": "412",
+		"shelf.name": "sci-fi",
+	})
+	if err != nil {
+		t.Fatalf("EncodeRequest failed: %v", err)
+	}
+
+	out, err := method.DecodeResponse(message)
+	if err != nil {
+		t.Fatalf("DecodeResponse failed: %v", err)
+	}
+	expected := `{"id":"42","title":"Dune","kind":"NOVEL","pageCount":412,"shelf":{"name":"sci-fi"}}`
+	if compact(string(out)) != expected {
+		t.Errorf("Expected %s, got %s", expected, out)
+	}
+
+	for _, params := range []map[string]string{
+		{"id": "forty-two"},
+		{"unknown": "1"},
+		{"kind": "POEM"},
+		{"title.name": "x"},
+	} {
+		if _, err := method.EncodeRequest(nil, params); err == nil {
+			t.Errorf("Expected params %v to be rejected", params)
+		}
+	}
+	if _, err := method.EncodeRequest([]byte(`{"id": true}`), nil); err == nil {
+		t.Error("Expected an invalid body to be rejected")
+	}
+}
+
+// compact drops the spaces protojson adds at random to discourage
+// comparing its output byte for byte
+func compact(s string) string {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != ' ' {
+			out = append(out, s[i])
+		}
+	}
+	return string(out)
+}
+
+func TestTemplate(t *testing.T) {
+	template, err := ParseTemplate("/v1/shelves/{shelf.name}/books/{id}")
+	if err != nil {
+		t.Fatalf("ParseTemplate failed: %v", err)
+	}
+
+	params, ok := template.Match("/v1/shelves/sci-fi/books/42")
+	if !ok || params["shelf.name"] != "sci-fi" || params["id"] != "42" {
+		t.Errorf("Expected shelf.name=sci-fi and id=42, got %v (matched %v)", params, ok)
+	}
+	for _, path := range []string{"/v1/shelves/sci-fi/books", "/v1/shelves//books/42", "/v2/shelves/sci-fi/books/42"} {
+		if _, ok := template.Match(path); ok {
+			t.Errorf("Expected %s not to match", path)
+		}
+	}
+
+	for _, bad := range []string{"v1/books", "/v1/books/id-{id}", "/v1/{a{b}}"} {
+		if _, err := ParseTemplate(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	testCases := map[int]int{
+		0:  http.StatusOK,
+		3:  http.StatusBadRequest,
+		5:  http.StatusNotFound,
+		7:  http.StatusForbidden,
+		14: http.StatusServiceUnavailable,
+		16: http.StatusUnauthorized,
+		2:  http.StatusInternalServerError,
+	}
+	for code, expected := range testCases {
+		if got := HTTPStatus(code); got != expected {
+			t.Errorf("Expected code %d to map to %d, got %d", code, expected, got)
+		}
+	}
+}
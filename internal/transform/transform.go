@@ -0,0 +1,181 @@
+// Package transform rewrites JSON documents: it drops or masks fields,
+// renames keys and adds top-level metadata. The gateway uses it on response
+// bodies so internal services can be exposed without leaking fields such
+// as passwords or national ID numbers.
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+// DefaultMask replaces masked values when no MaskWith is configured
+const DefaultMask = "***"
+
+// Transform is a compiled response transformation
+type Transform struct {
+	drop     []field
+	mask     []field
+	maskWith string
+	rename   []renamed
+	inject   map[string]interface{}
+}
+
+// field is a configured field name. A single segment matches the key at
+// any depth; several match the path from the top of the document.
+type field []string
+
+type renamed struct {
+	from field
+	to   string
+}
+
+func (f field) matches(path []string) bool {
+	if len(f) == 1 {
+		return path[len(path)-1] == f[0]
+	}
+	if len(f) != len(path) {
+		return false
+	}
+	for i := range f {
+		if f[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func parseField(name string) (field, error) {
+	segments := strings.Split(name, ".")
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, fmt.Errorf("field %q has an empty segment", name)
+		}
+	}
+	return segments, nil
+}
+
+// New compiles cfg
+func New(cfg config.ResponseTransformConfig) (*Transform, error) {
+	t := &Transform{maskWith: cfg.MaskWith, inject: cfg.Inject}
+	if t.maskWith == "" {
+		t.maskWith = DefaultMask
+	}
+
+	for _, name := range cfg.Drop {
+		f, err := parseField(name)
+		if err != nil {
+			return nil, fmt.Errorf("drop: %w", err)
+		}
+		t.drop = append(t.drop, f)
+	}
+	for _, name := range cfg.Mask {
+		f, err := parseField(name)
+		if err != nil {
+			return nil, fmt.Errorf("mask: %w", err)
+		}
+		t.mask = append(t.mask, f)
+	}
+	for from, to := range cfg.Rename {
+		f, err := parseField(from)
+		if err != nil {
+			return nil, fmt.Errorf("rename: %w", err)
+		}
+		if to == "" {
+			return nil, fmt.Errorf("rename: field %q has no new name", from)
+		}
+		t.rename = append(t.rename, renamed{from: f, to: to})
+	}
+	return t, nil
+}
+
+// Apply returns doc with the transformation applied. Numbers keep their
+// original text; object keys come out sorted.
+func (t *Transform) Apply(doc []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the JSON document")
+	}
+
+	value = t.walk(value, nil)
+	if obj, ok := value.(map[string]interface{}); ok {
+		for k, v := range t.inject {
+			obj[k] = v
+		}
+	}
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n")), nil
+}
+
+// walk applies the field rules below path. Array elements share their
+// array's path, so "items.ssn" reaches every item.
+func (t *Transform) walk(value interface{}, path []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		renames := make(map[string]interface{})
+		for key, child := range v {
+			childPath := append(path[:len(path):len(path)], key)
+			switch {
+			case matchesAny(t.drop, childPath):
+				delete(v, key)
+				continue
+			case matchesAny(t.mask, childPath):
+				v[key] = t.maskWith
+				continue
+			}
+
+			child = t.walk(child, childPath)
+			if to, ok := t.renameOf(childPath); ok {
+				delete(v, key)
+				renames[to] = child
+				continue
+			}
+			v[key] = child
+		}
+		for key, child := range renames {
+			v[key] = child
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = t.walk(child, path)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func (t *Transform) renameOf(path []string) (string, bool) {
+	for _, r := range t.rename {
+		if r.from.matches(path) {
+			return r.to, true
+		}
+	}
+	return "", false
+}
+
+func matchesAny(fields []field, path []string) bool {
+	for _, f := range fields {
+		if f.matches(path) {
+			return true
+		}
+	}
+	return false
+}
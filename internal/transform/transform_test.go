@@ -0,0 +1,91 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+)
+
+func TestApply(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cfg      config.ResponseTransformConfig
+		doc      string
+		expected string
+	}{
+		{
+			"drop at any depth",
+			config.ResponseTransformConfig{Drop: []string{"password"}},
+			`{"password":"x","user":{"name":"a","password":"y"}}`,
+			`{"user":{"name":"a"}}`,
+		},
+		{
+			"mask through arrays",
+			config.ResponseTransformConfig{Mask: []string{"users.ssn"}},
+			`{"users":[{"ssn":"123-45-6789"},{"ssn":"987-65-4321"}],"ssn":"kept"}`,
+			`{"ssn":"kept","users":[{"ssn":"***"},{"ssn":"***"}]}`,
+		},
+		{
+			"custom mask",
+			config.ResponseTransformConfig{Mask: []string{"card"}, MaskWith: "[redacted]"},
+			`[{"card":{"number":"4111"}}]`,
+			`[{"card":"[redacted]"}]`,
+		},
+		{
+			"rename nested value",
+			config.ResponseTransformConfig{Rename: map[string]string{"internal_id": "id"}, Drop: []string{"secret"}},
+			`{"internal_id":{"secret":1,"v":2}}`,
+			`{"id":{"v":2}}`,
+		},
+		{
+			"inject metadata",
+			config.ResponseTransformConfig{Inject: map[string]interface{}{"api_version": "v2"}},
+			`{"amount":12345678901234567890}`,
+			`{"amount":12345678901234567890,"api_version":"v2"}`,
+		},
+		{
+			"inject skips arrays",
+			config.ResponseTransformConfig{Inject: map[string]interface{}{"api_version": "v2"}},
+			`[1,2]`,
+			`[1,2]`,
+		},
+		{
+			"html is not escaped",
+			config.ResponseTransformConfig{},
+			`{"link":"<a href='x'>&</a>"}`,
+			`{"link":"<a href='x'>&</a>"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr, err := New(tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tr.Apply([]byte(tc.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestApplyInvalid(t *testing.T) {
+	tr, err := New(config.ResponseTransformConfig{Drop: []string{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []string{`{"a":`, `{} {}`, `not json`} {
+		if _, err := tr.Apply([]byte(doc)); err == nil {
+			t.Errorf("Expected error for %q", doc)
+		}
+	}
+
+	if _, err := New(config.ResponseTransformConfig{Mask: []string{"user..ssn"}}); err == nil {
+		t.Error("Expected error for an empty path segment")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package upgrade
+
+import "os"
+
+// Signal is nil where processes cannot inherit sockets
+var Signal os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package upgrade
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signal asks a running GateKeeper to upgrade itself
+var Signal os.Signal = syscall.SIGUSR2
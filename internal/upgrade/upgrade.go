@@ -0,0 +1,272 @@
+// Package upgrade replaces a running GateKeeper with a new binary without
+// refusing connections. Sockets are opened through Listen and
+// ListenPacket, which remember them. Upgrade starts the new binary with
+// those sockets inherited and waits for it to call Ready; from then on both
+// processes accept on the same sockets, and the old one stops accepting
+// and drains.
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// envListeners lists inherited sockets as "network|address=fd" pairs,
+	// separated by commas
+	envListeners = "GATEKEEPER_INHERITED_LISTENERS"
+
+	// envReady is the descriptor the new process writes to once it serves
+	envReady = "GATEKEEPER_UPGRADE_READY_FD"
+)
+
+// filer is a socket that can be handed to another process
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Upgrader tracks the process's sockets and hands them to its successor
+type Upgrader struct {
+	mu        sync.Mutex
+	inherited map[string]*os.File
+	sockets   map[string]filer
+	ready     *os.File
+	upgrading bool
+}
+
+var std = fromEnv()
+
+// fromEnv picks up what a parent process passed, if anything
+func fromEnv() *Upgrader {
+	u, err := parse(os.Getenv(envListeners), os.Getenv(envReady))
+	if err != nil {
+		// Starting like a fresh process is the only sensible fallback
+		fmt.Fprintf(os.Stderr, "Ignoring inherited listeners: %v\n", err)
+		u, _ = parse("", "")
+	}
+	// A later upgrade passes its own
+	os.Unsetenv(envListeners)
+	os.Unsetenv(envReady)
+	return u
+}
+
+func parse(listeners, readyFD string) (*Upgrader, error) {
+	u := &Upgrader{inherited: make(map[string]*os.File), sockets: make(map[string]filer)}
+	if listeners != "" {
+		for _, entry := range strings.Split(listeners, ",") {
+			key, value, ok := strings.Cut(entry, "=")
+			fd, err := strconv.Atoi(value)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("malformed entry %q", entry)
+			}
+			u.inherited[key] = os.NewFile(uintptr(fd), key)
+		}
+	}
+	if readyFD != "" {
+		fd, err := strconv.Atoi(readyFD)
+		if err != nil {
+			return nil, fmt.Errorf("malformed ready descriptor %q", readyFD)
+		}
+		u.ready = os.NewFile(uintptr(fd), "upgrade-ready")
+	}
+	return u, nil
+}
+
+// Listen is net.Listen, except that it takes over a socket inherited from
+// the process being upgraded when there is one for the same address
+func Listen(network, address string) (net.Listener, error) {
+	return std.Listen(network, address)
+}
+
+// ListenPacket is net.ListenPacket, taking over inherited sockets like
+// Listen does
+func ListenPacket(network, address string) (net.PacketConn, error) {
+	return std.ListenPacket(network, address)
+}
+
+// Ready tells the process being upgraded, if any, that this one serves
+// now. Inherited sockets that were not taken over are closed.
+func Ready() error {
+	return std.Ready()
+}
+
+// Upgrade starts the running binary again with the current sockets and
+// waits up to timeout for it to call Ready. When it returns nil the caller
+// should stop accepting connections and exit.
+func Upgrade(timeout time.Duration) error {
+	return std.Upgrade(timeout)
+}
+
+func (u *Upgrader) Listen(network, address string) (net.Listener, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	key := network + "|" + address
+	var ln net.Listener
+	var err error
+	if f, ok := u.inherited[key]; ok {
+		delete(u.inherited, key)
+		ln, err = net.FileListener(f)
+		f.Close()
+	} else {
+		ln, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := ln.(filer); ok {
+		u.sockets[key] = s
+	}
+	return ln, nil
+}
+
+func (u *Upgrader) ListenPacket(network, address string) (net.PacketConn, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	key := network + "|" + address
+	var conn net.PacketConn
+	var err error
+	if f, ok := u.inherited[key]; ok {
+		delete(u.inherited, key)
+		conn, err = net.FilePacketConn(f)
+		f.Close()
+	} else {
+		conn, err = net.ListenPacket(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := conn.(filer); ok {
+		u.sockets[key] = s
+	}
+	return conn, nil
+}
+
+func (u *Upgrader) Ready() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for key, f := range u.inherited {
+		f.Close()
+		delete(u.inherited, key)
+	}
+	if u.ready == nil {
+		return nil
+	}
+	defer func() { u.ready = nil }()
+	_, err := u.ready.Write([]byte{1})
+	u.ready.Close()
+	return err
+}
+
+func (u *Upgrader) Upgrade(timeout time.Duration) error {
+	u.mu.Lock()
+	if u.upgrading {
+		u.mu.Unlock()
+		return errors.New("an upgrade is already in progress")
+	}
+	u.upgrading = true
+	u.mu.Unlock()
+
+	err := u.start(timeout)
+	if err != nil {
+		u.mu.Lock()
+		u.upgrading = false
+		u.mu.Unlock()
+	}
+	return err
+}
+
+func (u *Upgrader) start(timeout time.Duration) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+
+	files, listeners := u.files()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	files = append(files, readyW)
+
+	// ExtraFiles start at descriptor 3
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		envListeners+"="+strings.Join(listeners, ","),
+		envReady+"="+strconv.Itoa(2+len(files)),
+	)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// The new process outlives this one, so it is not waited for once ready
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	ready := make(chan error, 1)
+	go func() {
+		_, err := readyR.Read(make([]byte, 1))
+		ready <- err
+	}()
+	readyW.Close()
+	files = files[:len(files)-1]
+
+	select {
+	case err := <-ready:
+		if err == nil {
+			return nil
+		}
+		err = <-exited
+		return fmt.Errorf("new process exited before it was ready: %v", err)
+	case err := <-exited:
+		return fmt.Errorf("new process exited before it was ready: %v", err)
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("new process was not ready within %s", timeout)
+	}
+}
+
+// files duplicates the open sockets for the new process and describes
+// them for envListeners. Sockets closed since they were opened are
+// forgotten.
+func (u *Upgrader) files() ([]*os.File, []string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	keys := make([]string, 0, len(u.sockets))
+	for key := range u.sockets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var files []*os.File
+	var listeners []string
+	for _, key := range keys {
+		f, err := u.sockets[key].File()
+		if err != nil {
+			delete(u.sockets, key)
+			continue
+		}
+		files = append(files, f)
+		listeners = append(listeners, fmt.Sprintf("%s=%d", key, 2+len(files)))
+	}
+	return files, listeners
+}
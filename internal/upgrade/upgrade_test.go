@@ -0,0 +1,149 @@
+package upgrade
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envChild makes the test binary act as the new process of an upgrade
+const envChild = "GATEKEEPER_UPGRADE_TEST_CHILD"
+
+func TestMain(m *testing.M) {
+	if address := os.Getenv(envChild); address != "" {
+		os.Exit(runChild(address))
+	}
+	os.Exit(m.Run())
+}
+
+// runChild takes over the listener on address, reports ready and answers
+// one connection with "new"
+func runChild(address string) int {
+	ln, err := Listen("tcp", address)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := Ready(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		return 1
+	}
+	io.WriteString(conn, "new\n")
+	conn.Close()
+	return 0
+}
+
+func TestListenTakesOverInheritedSocket(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+
+	u, err := parse(fmt.Sprintf("tcp|%s=%d", address, f.Fd()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inherited, err := u.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("Listen on the inherited address: %v", err)
+	}
+	defer inherited.Close()
+
+	// The original is closed, so only the inherited listener can accept
+	ln.Close()
+	go func() {
+		if conn, err := inherited.Accept(); err == nil {
+			io.WriteString(conn, "inherited\n")
+			conn.Close()
+		}
+	}()
+	if got := readLine(t, address); got != "inherited" {
+		t.Errorf("got %q, want inherited", got)
+	}
+
+	if _, ok := u.sockets["tcp|"+address]; !ok {
+		t.Error("inherited listener is not passed on by a later upgrade")
+	}
+}
+
+func TestParseRejectsMalformedEntries(t *testing.T) {
+	for _, listeners := range []string{"tcp|:8080", "tcp|:8080=x"} {
+		if _, err := parse(listeners, ""); err == nil {
+			t.Errorf("parse(%q) succeeded, want an error", listeners)
+		}
+	}
+	if _, err := parse("", "x"); err == nil {
+		t.Error("parse accepted a malformed ready descriptor")
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	// The child looks the socket up by the address it was opened with, so
+	// that has to name the port
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := free.Addr().String()
+	free.Close()
+	// The test's own Upgrader, so the upgrade it leaves in progress does
+	// not outlive the test
+	u, _ := parse("", "")
+	ln, err := u.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(envChild, address)
+	if err := u.Upgrade(10 * time.Second); err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+	if err := u.Upgrade(10 * time.Second); err == nil || !strings.Contains(err.Error(), "in progress") {
+		t.Errorf("second Upgrade = %v, want an error", err)
+	}
+
+	// Once this process stops accepting, the new one gets the connections
+	ln.Close()
+	if got := readLine(t, address); got != "new" {
+		t.Errorf("got %q, want new", got)
+	}
+}
+
+func TestUpgradeFailsWhenChildExits(t *testing.T) {
+	u, _ := parse("", "")
+	// Without the listener it asks for, the child exits before Ready
+	t.Setenv(envChild, "not-an-address")
+	if err := u.Upgrade(10 * time.Second); err == nil {
+		t.Fatal("Upgrade succeeded, want an error")
+	}
+	if u.upgrading {
+		t.Error("failed upgrade still counts as in progress")
+	}
+}
+
+func readLine(t *testing.T, address string) string {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		t.Fatalf("dial %s: %v", address, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,284 @@
+// Package usage meters requests per consumer for quota reporting and
+// billing: requests, client and server errors and response bytes, counted
+// in hourly buckets in key-value storage.
+package usage
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/accesslog"
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/kv"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+)
+
+// Anonymous stands for requests no authentication verified
+const Anonymous = "anonymous"
+
+// keyPrefix starts every key: usage:<hour>:<field>:<consumer>. The
+// consumer comes last since it may hold colons itself.
+const keyPrefix = "usage:"
+
+// The counters kept per consumer and hour
+const (
+	fieldRequests     = "requests"
+	fieldClientErrors = "4xx"
+	fieldServerErrors = "5xx"
+	fieldBytes        = "bytes"
+)
+
+// Totals are the counts of a consumer over some time
+type Totals struct {
+	Requests     int64   `json:"requests"`
+	ClientErrors int64   `json:"clientErrors"`
+	ServerErrors int64   `json:"serverErrors"`
+	Bytes        int64   `json:"bytes"`
+	ErrorRate    float64 `json:"errorRate"`
+}
+
+func (t *Totals) add(field string, n int64) {
+	switch field {
+	case fieldRequests:
+		t.Requests += n
+	case fieldClientErrors:
+		t.ClientErrors += n
+	case fieldServerErrors:
+		t.ServerErrors += n
+	case fieldBytes:
+		t.Bytes += n
+	}
+}
+
+// rate sets ErrorRate, the share of requests that failed with a 5xx
+func (t *Totals) rate() {
+	if t.Requests > 0 {
+		t.ErrorRate = float64(t.ServerErrors) / float64(t.Requests)
+	}
+}
+
+// Consumer is a consumer's usage, as served by /admin/usage
+type Consumer struct {
+	Consumer string `json:"consumer"`
+	Totals
+}
+
+// Bucket is a consumer's usage in one hour or day
+type Bucket struct {
+	Start time.Time `json:"start"`
+	Totals
+}
+
+// Meter counts requests per consumer. Counts are kept in memory and added
+// to the store in the background, so requests never wait on it.
+type Meter struct {
+	store     kv.Store
+	retention time.Duration
+	interval  time.Duration
+	now       func() time.Time
+
+	mu      sync.Mutex
+	pending map[string]int64
+
+	// flushes are serialized so a failed one can put its counts back
+	flushing sync.Mutex
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New meters into store, which the caller picks from cfg.Store
+func New(cfg config.UsageConfig, store kv.Store) *Meter {
+	if cfg.RetentionDays == 0 {
+		cfg.RetentionDays = 31
+	}
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = 10
+	}
+	m := &Meter{
+		store:     store,
+		retention: time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+		interval:  time.Duration(cfg.FlushInterval) * time.Second,
+		now:       time.Now,
+		pending:   make(map[string]int64),
+		done:      make(chan struct{}),
+	}
+	m.wg.Add(1)
+	go m.flushLoop()
+	return m
+}
+
+// Record counts the request of e against its consumer
+func (m *Meter) Record(e *accesslog.Entry) {
+	consumer := e.Consumer
+	if consumer == "" {
+		consumer = Anonymous
+	}
+	metrics.RecordConsumerUsage(consumer, e.Status, e.Bytes)
+
+	hour := strconv.FormatInt(m.now().Unix()/3600*3600, 10)
+	key := func(field string) string {
+		return keyPrefix + hour + ":" + field + ":" + consumer
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[key(fieldRequests)]++
+	switch {
+	case e.Status >= 500:
+		m.pending[key(fieldServerErrors)]++
+	case e.Status >= 400:
+		m.pending[key(fieldClientErrors)]++
+	}
+	if e.Bytes > 0 {
+		m.pending[key(fieldBytes)] += e.Bytes
+	}
+}
+
+// Flush adds the counts recorded since the last flush to the store. Counts
+// the store did not take are kept for the next flush.
+func (m *Meter) Flush(ctx context.Context) error {
+	m.flushing.Lock()
+	defer m.flushing.Unlock()
+
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[string]int64)
+	m.mu.Unlock()
+
+	// A bucket expires once it falls out of the retention period
+	ttl := m.retention + time.Hour
+	var err error
+	for key, n := range pending {
+		if err != nil {
+			break
+		}
+		if _, err = m.store.IncrBy(ctx, key, n, ttl); err == nil {
+			delete(pending, key)
+		}
+	}
+	if err != nil {
+		m.mu.Lock()
+		for key, n := range pending {
+			m.pending[key] += n
+		}
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// Consumers returns the usage of every consumer since the given time,
+// busiest first
+func (m *Meter) Consumers(ctx context.Context, since time.Time) ([]Consumer, error) {
+	byConsumer := make(map[string]*Totals)
+	err := m.scan(ctx, since, func(_ int64, field, consumer string, n int64) {
+		t, ok := byConsumer[consumer]
+		if !ok {
+			t = &Totals{}
+			byConsumer[consumer] = t
+		}
+		t.add(field, n)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	consumers := make([]Consumer, 0, len(byConsumer))
+	for name, t := range byConsumer {
+		t.rate()
+		consumers = append(consumers, Consumer{Consumer: name, Totals: *t})
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		if consumers[i].Requests != consumers[j].Requests {
+			return consumers[i].Requests > consumers[j].Requests
+		}
+		return consumers[i].Consumer < consumers[j].Consumer
+	})
+	return consumers, nil
+}
+
+// History returns the usage of consumer since the given time, in buckets
+// of an hour or a day (UTC), oldest first. Buckets without requests are
+// left out.
+func (m *Meter) History(ctx context.Context, consumer string, since time.Time, daily bool) ([]Bucket, error) {
+	byStart := make(map[int64]*Totals)
+	err := m.scan(ctx, since, func(hour int64, field, name string, n int64) {
+		if name != consumer {
+			return
+		}
+		start := hour
+		if daily {
+			start -= start % (24 * 3600)
+		}
+		t, ok := byStart[start]
+		if !ok {
+			t = &Totals{}
+			byStart[start] = t
+		}
+		t.add(field, n)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]Bucket, 0, len(byStart))
+	for start, t := range byStart {
+		t.rate()
+		buckets = append(buckets, Bucket{Start: time.Unix(start, 0).UTC(), Totals: *t})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+	return buckets, nil
+}
+
+// scan flushes, then calls fn for every stored count in an hour that ends
+// after since
+func (m *Meter) scan(ctx context.Context, since time.Time, fn func(hour int64, field, consumer string, n int64)) error {
+	if err := m.Flush(ctx); err != nil {
+		return err
+	}
+	entries, err := m.store.Scan(ctx, keyPrefix)
+	if err != nil {
+		return err
+	}
+	from := since.Unix() / 3600 * 3600
+	for key, value := range entries {
+		hourPart, rest, _ := strings.Cut(strings.TrimPrefix(key, keyPrefix), ":")
+		field, consumer, ok := strings.Cut(rest, ":")
+		hour, err := strconv.ParseInt(hourPart, 10, 64)
+		if !ok || err != nil || hour < from {
+			continue
+		}
+		n, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		fn(hour, field, consumer, n)
+	}
+	return nil
+}
+
+func (m *Meter) flushLoop() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Flush(context.Background()); err != nil {
+				logger.Warn("Failed to store usage counts, retrying: %v", err)
+			}
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// Close stops flushing in the background and flushes one last time
+func (m *Meter) Close() error {
+	close(m.done)
+	m.wg.Wait()
+	return m.Flush(context.Background())
+}
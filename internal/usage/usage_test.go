@@ -0,0 +1,93 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/accesslog"
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/kv"
+)
+
+func TestMeter(t *testing.T) {
+	ctx := context.Background()
+	m := New(config.UsageConfig{}, kv.NewMemoryStore())
+	defer m.Close()
+	now := time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	for _, e := range []accesslog.Entry{
+		{Consumer: "hmac:shop", Status: 200, Bytes: 100},
+		{Consumer: "hmac:shop", Status: 404, Bytes: 10},
+		{Consumer: "hmac:shop", Status: 503},
+		{Status: 200, Bytes: 5},
+	} {
+		m.Record(&e)
+	}
+	now = now.Add(2 * time.Hour)
+	m.Record(&accesslog.Entry{Consumer: "hmac:shop", Status: 200, Bytes: 50})
+
+	consumers, err := m.Consumers(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(consumers) != 2 || consumers[0].Consumer != "hmac:shop" || consumers[1].Consumer != Anonymous {
+		t.Fatalf("Expected shop then anonymous, got %+v", consumers)
+	}
+	shop := consumers[0]
+	if shop.Requests != 4 || shop.ClientErrors != 1 || shop.ServerErrors != 1 || shop.Bytes != 160 || shop.ErrorRate != 0.25 {
+		t.Errorf("Expected shop's totals, got %+v", shop)
+	}
+
+	// Only the last hour
+	consumers, _ = m.Consumers(ctx, now)
+	if len(consumers) != 1 || consumers[0].Requests != 1 {
+		t.Errorf("Expected one request in the last hour, got %+v", consumers)
+	}
+
+	hourly, _ := m.History(ctx, "hmac:shop", now.Add(-24*time.Hour), false)
+	if len(hourly) != 2 || hourly[0].Requests != 3 || !hourly[0].Start.Equal(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected two hours, 3 requests from 10:00, got %+v", hourly)
+	}
+	daily, _ := m.History(ctx, "hmac:shop", now.Add(-24*time.Hour), true)
+	if len(daily) != 1 || daily[0].Requests != 4 || !daily[0].Start.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected one day with 4 requests, got %+v", daily)
+	}
+}
+
+// failingStore fails every write until told otherwise
+type failingStore struct {
+	kv.Store
+	fail bool
+}
+
+func (s *failingStore) IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	if s.fail {
+		return 0, errors.New("store down")
+	}
+	return s.Store.IncrBy(ctx, key, n, ttl)
+}
+
+func TestMeterKeepsCountsTheStoreRefused(t *testing.T) {
+	ctx := context.Background()
+	store := &failingStore{Store: kv.NewMemoryStore(), fail: true}
+	m := New(config.UsageConfig{}, store)
+	defer m.Close()
+
+	m.Record(&accesslog.Entry{Status: 200, Bytes: 10})
+	if err := m.Flush(ctx); err == nil {
+		t.Fatal("Expected the flush to fail")
+	}
+	m.Record(&accesslog.Entry{Status: 200, Bytes: 10})
+
+	store.fail = false
+	consumers, err := m.Consumers(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(consumers) != 1 || consumers[0].Requests != 2 || consumers[0].Bytes != 20 {
+		t.Errorf("Expected both requests kept, got %+v", consumers)
+	}
+}
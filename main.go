@@ -8,57 +8,334 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/barisgenc/gatekeeper/internal/accesslog"
 	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/configsource"
 	"github.com/barisgenc/gatekeeper/internal/gateway"
+	"github.com/barisgenc/gatekeeper/internal/journal"
+	"github.com/barisgenc/gatekeeper/internal/listener"
 	"github.com/barisgenc/gatekeeper/internal/logger"
 	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/middleware"
+	"github.com/barisgenc/gatekeeper/internal/redact"
+	"github.com/barisgenc/gatekeeper/internal/secrets"
+	"github.com/barisgenc/gatekeeper/internal/synthetics"
+	"github.com/barisgenc/gatekeeper/internal/tcpproxy"
+	"github.com/barisgenc/gatekeeper/internal/tracing"
+	"github.com/barisgenc/gatekeeper/internal/upgrade"
 )
 
+// upgradeTimeout bounds how long a new process may take to start serving
+// on SIGUSR2
+const upgradeTimeout = time.Minute
+
+// loadConfig loads the config, layered with the document in the config
+// source if there is one, and fetches the secrets it references
+func loadConfig() (*config.Config, error) {
+	src, err := config.ReadSource()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var remote []byte
+	if src.Type != "" {
+		if remote, err = configsource.Fetch(ctx, src); err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := config.LoadWithRemote(remote)
+	if err != nil {
+		return nil, err
+	}
+	if err := secrets.Resolve(ctx, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "docs":
+			os.Exit(runDocs(os.Args[2:]))
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "test":
+			os.Exit(runTests(os.Args[2:]))
+		}
+	}
+	parseFlags(os.Args[1:])
+
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := loadConfig()
 	if err != nil {
 		logger.Fatal("Failed to load configuration: %v", err)
 	}
 
-	// Initialize logger
+	// Initialize logger, keeping credentials out of it
 	logger.Init(cfg.LogLevel)
+	logger.SetFilter(redact.New(cfg.Redaction).Text)
+
+	// Refuse to serve a config that breaks the organization policy
+	if err := enforcePolicy(cfg); err != nil {
+		logger.Fatal("%v", err)
+	}
 
 	// Initialize metrics
-	metrics.Init()
+	metrics.Init(metrics.Buckets{Duration: cfg.Metrics.DurationBuckets, Size: cfg.Metrics.SizeBuckets})
+	if cfg.Metrics.Exporter == "statsd" {
+		statsd := cfg.Metrics.StatsD
+		prefix := "gatekeeper."
+		if statsd.Prefix != nil {
+			prefix = *statsd.Prefix
+		}
+		exporter, err := metrics.NewStatsD(metrics.StatsDOptions{
+			Address:     statsd.Address,
+			Prefix:      prefix,
+			Flavor:      statsd.Flavor,
+			SampleRate:  statsd.SampleRate,
+			SampleRates: statsd.SampleRates,
+			Tags:        statsd.Tags,
+		})
+		if err != nil {
+			logger.Fatal("Failed to initialize StatsD exporter: %v", err)
+		}
+		metrics.SetSink(exporter)
+		defer exporter.Close()
+		logger.Info("Sending metrics to StatsD at %s", exporter.Address())
+	}
+
+	// Export traces before any request is handled
+	if cfg.Tracing.Enabled {
+		shutdown, err := tracing.Init(cfg.Tracing)
+		if err != nil {
+			logger.Fatal("Failed to initialize tracing: %v", err)
+		}
+		defer shutdown(context.Background())
+		logger.Info("Exporting traces to %s", cfg.Tracing.Endpoint)
+	}
 
 	// Create gateway server
 	gw := gateway.New(cfg)
 
-	// Create HTTP server
-	srv := &http.Server{
-		Addr:         cfg.Server.Address,
-		Handler:      gw.Handler(),
-		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
-		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
-		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout) * time.Second,
+	// Open the request journal and report anything left over from a crash
+	if cfg.Journal.Enabled {
+		j, recovered, err := journal.Open(cfg.Journal.Path, cfg.Journal.Sync, int64(cfg.Journal.MaxSizeMB)*1024*1024)
+		if err != nil {
+			logger.Fatal("Failed to open request journal: %v", err)
+		}
+		defer j.Close()
+
+		for _, entry := range recovered {
+			logger.Warn("Request %s (%s %s from %s) was in flight at last shutdown, started %s",
+				entry.ID, entry.Method, entry.Path, entry.ClientIP, entry.Time.Format(time.RFC3339Nano))
+		}
+
+		gw.Use(middleware.NewJournal(j))
+	}
+
+	handler := gw.Handler()
+
+	// Synthetic probes go through the same handler as client requests
+	if cfg.Synthetics.Enabled {
+		synthetics.New(cfg.Synthetics, handler).Start()
+	}
+
+	// Listeners are bound before serving so connection floods are cut off
+	// before the TLS handshake, and rebound on reload
+	listeners := listener.NewManager(handler)
+	if err := listeners.Apply(cfg.Server); err != nil {
+		logger.Fatal("Server failed to start: %v", err)
+	}
+	if limit := cfg.Server.ConnLimit; limit.PerIPPerSecond > 0 {
+		logger.Info("Connection rate limited to %.2f/sec per client IP", limit.PerIPPerSecond)
+	}
+
+	// Raw TCP forwarding for protocols other than HTTP
+	var tcpProxies []*tcpproxy.Proxy
+	for _, proxyCfg := range cfg.TCPProxies {
+		p := tcpproxy.New(proxyCfg)
+		if err := p.Start(); err != nil {
+			logger.Fatal("TCP proxy %s failed to start: %v", proxyCfg.Name, err)
+		}
+		tcpProxies = append(tcpProxies, p)
 	}
 
-	// Start server in goroutine
+	// Plain HTTP listener that only redirects to HTTPS and answers ACME challenges
+	var redirectSrv *http.Server
+	if cfg.Server.HTTPRedirect.Enabled {
+		address := cfg.Server.HTTPRedirect.Address
+		if address == "" {
+			address = ":80"
+		}
+		redirectSrv = &http.Server{
+			Addr:         address,
+			Handler:      gateway.NewHTTPSRedirect(cfg.Server.HTTPRedirect),
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		ln, err := upgrade.Listen("tcp", address)
+		if err != nil {
+			logger.Fatal("HTTP redirect listener failed to start: %v", err)
+		}
+
+		go func() {
+			logger.Info("Redirecting HTTP on %s to HTTPS", address)
+			if err := redirectSrv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("HTTP redirect listener stopped: %v", err)
+			}
+		}()
+	}
+
+	// Admin API on its own listener
+	var adminSrv *http.Server
+	if handler := gw.AdminHandler(); handler != nil {
+		adminSrv = &http.Server{
+			Addr:         cfg.Admin.Address,
+			Handler:      handler,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		ln, err := upgrade.Listen("tcp", cfg.Admin.Address)
+		if err != nil {
+			logger.Fatal("Admin listener failed to start: %v", err)
+		}
+
+		go func() {
+			logger.Info("Admin API listening on %s", cfg.Admin.Address)
+			if err := adminSrv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Admin listener stopped: %v", err)
+			}
+		}()
+	}
+
+	// Every socket is open, so a process being upgraded from can stop
+	// accepting
+	if err := upgrade.Ready(); err != nil {
+		logger.Warn("Could not tell the previous process this one is ready: %v", err)
+	}
+	logger.Info("Started GateKeeper")
+
+	// SIGHUP re-reads the config, rebinds changed listeners and rebuilds
+	// route middleware pipelines
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	go func() {
-		logger.Info("Starting GateKeeper on %s", cfg.Server.Address)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatal("Server failed to start: %v", err)
+		for range reload {
+			newCfg, err := loadConfig()
+			if err != nil {
+				logger.Error("Reload failed, keeping current config: %v", err)
+				continue
+			}
+			if err := enforcePolicy(newCfg); err != nil {
+				logger.Error("Reload failed, keeping current config: %v", err)
+				continue
+			}
+			if err := gw.Reload(newCfg); err != nil {
+				logger.Error("Reload failed, keeping current pipelines: %v", err)
+				continue
+			}
+			if err := listeners.Apply(newCfg.Server); err != nil {
+				logger.Error("Reload failed, keeping current listeners: %v", err)
+				continue
+			}
+			logger.Info("Reloaded listeners and route middleware pipelines")
 		}
 	}()
 
+	// Changes in the config source and secrets that change in Vault are
+	// picked up by a reload
+	requestReload := func() {
+		select {
+		case reload <- syscall.SIGHUP:
+		default:
+		}
+	}
+	if cfg.Source.Type != "" {
+		go configsource.Watch(requestReload)
+	}
+	if cfg.Secrets.Vault.Address != "" {
+		go secrets.Watch(requestReload)
+	}
+
+	// SIGUSR1 reopens the access log file once something else rotated it
+	if accesslog.ReopenSignal != nil {
+		reopen := make(chan os.Signal, 1)
+		signal.Notify(reopen, accesslog.ReopenSignal)
+		go func() {
+			for range reopen {
+				if err := gw.ReopenLogs(); err != nil {
+					logger.Error("Failed to reopen the access log: %v", err)
+					continue
+				}
+				logger.Info("Reopened the access log")
+			}
+		}()
+	}
+
+	// SIGUSR2 starts the binary on disk with this process's sockets. Once
+	// it serves, this one shuts down like on SIGTERM, except that listeners
+	// stop accepting before draining, since the new process takes the
+	// connections.
+	upgraded := make(chan struct{})
+	if upgrade.Signal != nil {
+		upgradeSignal := make(chan os.Signal, 1)
+		signal.Notify(upgradeSignal, upgrade.Signal)
+		go func() {
+			for range upgradeSignal {
+				logger.Info("Upgrading: starting a new process")
+				if err := upgrade.Upgrade(upgradeTimeout); err != nil {
+					logger.Error("Upgrade failed, this process keeps serving: %v", err)
+					continue
+				}
+				close(upgraded)
+				return
+			}
+		}()
+	}
+
 	// Wait for interrupt signal to gracefully shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	handedOff := false
+	select {
+	case <-quit:
+	case <-upgraded:
+		handedOff = true
+	}
 
 	logger.Info("Shutting down server...")
 
-	// Graceful shutdown with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Graceful shutdown: requests in flight get up to drain.timeout, then
+	// listeners get 30 seconds to close
+	drainTimeout := time.Duration(cfg.Drain.Timeout) * time.Second
+	if drainTimeout <= 0 {
+		drainTimeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout+30*time.Second)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
+	if handedOff {
+		if err := listeners.Shutdown(ctx); err != nil {
+			logger.Warn("Listeners did not close in time: %v", err)
+		}
+	}
+
+	err = gw.Shutdown(ctx, func(ctx context.Context) error {
+		if redirectSrv != nil {
+			redirectSrv.Shutdown(ctx)
+		}
+		if adminSrv != nil {
+			adminSrv.Shutdown(ctx)
+		}
+		for _, p := range tcpProxies {
+			p.Shutdown(ctx)
+		}
+		return listeners.Shutdown(ctx)
+	})
+	if err != nil {
 		logger.Fatal("Server forced to shutdown: %v", err)
 	}
 
@@ -0,0 +1,230 @@
+// Package gatekeeper embeds the GateKeeper API gateway in another Go
+// program, for services that would rather route, limit and authenticate
+// their own traffic than run the gatekeeper binary in front of them.
+//
+// It is a facade: the gateway lives in internal packages, and this package
+// is the supported way to reach it. Its types are aliases of the internal
+// ones, so values pass between the two unchanged, and everything an
+// embedding program needs is exported here rather than by those packages.
+//
+// Aliasing rather than moving the gateway out of internal/ is deliberate:
+// it keeps the internal packages free to change shape without breaking
+// embedders. The cost is that every type reachable from Config has to be
+// aliased here, or a program could read a setting it cannot name; a test
+// checks that none is missing.
+//
+//	cfg, err := gatekeeper.LoadConfig("gateway.yaml")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	gw := gatekeeper.New(cfg)
+//	log.Fatal(http.ListenAndServe(":8080", gw.Handler()))
+//
+// The config is the same one the binary reads, so a gateway can move
+// between the two unchanged. To build one in code, start from the defaults
+// ParseConfig(nil) returns and check the result with Config.Validate.
+//
+// The program owns the listeners: the server settings in the config, such
+// as TLS and additional listeners, are left to it, while routing,
+// middlewares and health checks run as they do in the binary. Reload,
+// Shutdown and the On* hooks on Gateway cover the rest of its lifecycle.
+//
+// Programs that only want part of the gateway can build single middlewares
+// with NewMiddleware, from the same definitions as Config.Middlewares, and
+// spread requests over backends with NewLoadBalancer.
+package gatekeeper
+
+import (
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/gateway"
+	"github.com/barisgenc/gatekeeper/internal/loadbalancer"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+	"github.com/barisgenc/gatekeeper/internal/metrics"
+	"github.com/barisgenc/gatekeeper/internal/middleware"
+	"github.com/barisgenc/gatekeeper/internal/openapi"
+)
+
+type (
+	// Config is a whole gateway config, as read from config.yaml
+	Config = config.Config
+	// RouteConfig is one entry of Config.Routes
+	RouteConfig = config.RouteConfig
+	// Backend is one entry of Config.Backends
+	Backend = config.Backend
+	// MiddlewareConfigs is Config.Middlewares, keyed by name
+	MiddlewareConfigs = config.MiddlewareConfigs
+	// MiddlewareConfig is one named entry of Config.Middlewares
+	MiddlewareConfig = config.MiddlewareConfig
+
+	// The sections of Config and the blocks nested in them
+	AccessLogConfig          = config.AccessLogConfig
+	AccessLogRotationConfig  = config.AccessLogRotationConfig
+	AdminConfig              = config.AdminConfig
+	AggregateConfig          = config.AggregateConfig
+	AggregatePart            = config.AggregatePart
+	APIVersion               = config.APIVersion
+	AutoBanConfig            = config.AutoBanConfig
+	BackendProxyConfig       = config.BackendProxyConfig
+	BackendServer            = config.BackendServer
+	BalancerConfig           = config.BalancerConfig
+	BannerConfig             = config.BannerConfig
+	BodySchemaConfig         = config.BodySchemaConfig
+	BoltConfig               = config.BoltConfig
+	BotChallengeConfig       = config.BotChallengeConfig
+	BotDetectionConfig       = config.BotDetectionConfig
+	BulkheadGroupConfig      = config.BulkheadGroupConfig
+	ClassificationConfig     = config.ClassificationConfig
+	ClassificationRule       = config.ClassificationRule
+	CoalesceConfig           = config.CoalesceConfig
+	ConfigSourceConfig       = config.ConfigSourceConfig
+	ConnectConfig            = config.ConnectConfig
+	ConnectUser              = config.ConnectUser
+	ConnLimitConfig          = config.ConnLimitConfig
+	ContractExpect           = config.ContractExpect
+	ContractTest             = config.ContractTest
+	ContractUpstream         = config.ContractUpstream
+	CORSConfig               = config.CORSConfig
+	DebugEchoConfig          = config.DebugEchoConfig
+	DeprecationConfig        = config.DeprecationConfig
+	DrainConfig              = config.DrainConfig
+	EdgeHealthConfig         = config.EdgeHealthConfig
+	EventsConfig             = config.EventsConfig
+	FailoverConfig           = config.FailoverConfig
+	GRPCBinding              = config.GRPCBinding
+	GRPCTranscodeConfig      = config.GRPCTranscodeConfig
+	HeaderLimitsConfig       = config.HeaderLimitsConfig
+	HealthCheckConfig        = config.HealthCheckConfig
+	HedgingConfig            = config.HedgingConfig
+	HSTSConfig               = config.HSTSConfig
+	HTTP2Config              = config.HTTP2Config
+	HTTP3Config              = config.HTTP3Config
+	HTTPRedirectConfig       = config.HTTPRedirectConfig
+	IncidentConfig           = config.IncidentConfig
+	JournalConfig            = config.JournalConfig
+	LargeBodiesConfig        = config.LargeBodiesConfig
+	LintConfig               = config.LintConfig
+	ListenerConfig           = config.ListenerConfig
+	LoadBalancingConfig      = config.LoadBalancingConfig
+	MaintenanceConfig        = config.MaintenanceConfig
+	MetricsConfig            = config.MetricsConfig
+	NegativeCacheConfig      = config.NegativeCacheConfig
+	NotificationsConfig      = config.NotificationsConfig
+	OpenAPIConfig            = config.OpenAPIConfig
+	PathNormalizeConfig      = config.PathNormalizeConfig
+	ProbeConfig              = config.ProbeConfig
+	ProtocolConfig           = config.ProtocolConfig
+	QuotaConfig              = config.QuotaConfig
+	QuotaLimits              = config.QuotaLimits
+	RateLimitDescriptor      = config.RateLimitDescriptor
+	RateLimitDescriptorEntry = config.RateLimitDescriptorEntry
+	RedactionConfig          = config.RedactionConfig
+	RedirectConfig           = config.RedirectConfig
+	RedisConfig              = config.RedisConfig
+	RequestAgeConfig         = config.RequestAgeConfig
+	ResponseTransformConfig  = config.ResponseTransformConfig
+	RouteAccessLogConfig     = config.RouteAccessLogConfig
+	RouteClientCertConfig    = config.RouteClientCertConfig
+	SecretsConfig            = config.SecretsConfig
+	ServerConfig             = config.ServerConfig
+	SessionsConfig           = config.SessionsConfig
+	SLOConfig                = config.SLOConfig
+	SNIRoute                 = config.SNIRoute
+	StaticConfig             = config.StaticConfig
+	StatsDConfig             = config.StatsDConfig
+	StorageConfig            = config.StorageConfig
+	SyntheticsConfig         = config.SyntheticsConfig
+	TarpitConfig             = config.TarpitConfig
+	TCPHealthCheckConfig     = config.TCPHealthCheckConfig
+	TCPProxyConfig           = config.TCPProxyConfig
+	TLSConfig                = config.TLSConfig
+	TokenRelayConfig         = config.TokenRelayConfig
+	TracingConfig            = config.TracingConfig
+	UpstreamSignConfig       = config.UpstreamSignConfig
+	UpstreamTLSConfig        = config.UpstreamTLSConfig
+	UsageConfig              = config.UsageConfig
+	VaultConfig              = config.VaultConfig
+	VersioningConfig         = config.VersioningConfig
+	WebhookConfig            = config.WebhookConfig
+
+	// The settings blocks of MiddlewareConfig, one per middleware type
+	RateLimitConfig     = config.RateLimitConfig
+	RateLimitTier       = config.RateLimitTier
+	OIDCConfig          = config.OIDCConfig
+	SAMLConfig          = config.SAMLConfig
+	SPNEGOConfig        = config.SPNEGOConfig
+	LDAPConfig          = config.LDAPConfig
+	IntrospectionConfig = config.IntrospectionConfig
+	HMACConfig          = config.HMACConfig
+	HMACConsumer        = config.HMACConsumer
+	ExtAuthzConfig      = config.ExtAuthzConfig
+	BulkheadConfig      = config.BulkheadConfig
+	IdempotencyConfig   = config.IdempotencyConfig
+	RateLimitSvcConfig  = config.RateLimitSvcConfig
+	ExtProcConfig       = config.ExtProcConfig
+
+	// The operation RouteConfig.Operation holds on routes generated from
+	// an OpenAPI spec, and the parts of the spec it refers to
+	OpenAPIOperation   = openapi.Operation
+	OpenAPIParameter   = openapi.Parameter
+	OpenAPIRequestBody = openapi.RequestBody
+	OpenAPIMediaType   = openapi.MediaType
+	OpenAPISchema      = openapi.Schema
+	OpenAPITypes       = openapi.Types
+	OpenAPIBound       = openapi.Bound
+	OpenAPIAdditional  = openapi.Additional
+
+	// Gateway routes requests to the backends in its config
+	Gateway = gateway.Gateway
+	// BackendChange is passed to Gateway.OnBackendChange hooks
+	BackendChange = gateway.BackendChange
+	// Middleware wraps the gateway's handler. Middlewares added with
+	// Gateway.Use run after the built-in ones, closest to the proxy.
+	Middleware = middleware.Middleware
+
+	// LoadBalancer picks healthy backends by the configured algorithm
+	LoadBalancer = loadbalancer.LoadBalancer
+	// BackendStatus is a backend's health and traffic as the load
+	// balancer sees it
+	BackendStatus = loadbalancer.BackendStatus
+)
+
+// New creates a gateway for cfg and starts health checking its backends
+func New(cfg *Config) *Gateway {
+	return gateway.New(cfg)
+}
+
+// LoadConfig reads a config file. Files ending in .json are read as JSON
+// and .toml as TOML, anything else as YAML.
+func LoadConfig(path string) (*Config, error) {
+	return config.LoadFile(path)
+}
+
+// ParseConfig reads a YAML or JSON config. Settings it leaves out take
+// their defaults, or the GATEKEEPER_* environment variables where set.
+func ParseConfig(data []byte) (*Config, error) {
+	return config.Parse(data)
+}
+
+// Init sets the log level and registers the gateway's metrics, with the
+// histogram buckets in cfg, with the default Prometheus registry. Without
+// it the gateway logs at info level and its /metrics endpoint serves only
+// the Go runtime metrics. Call it before New; later calls only set the log
+// level.
+func Init(cfg *Config) {
+	logger.Init(cfg.LogLevel)
+	metrics.Init(metrics.Buckets{Duration: cfg.Metrics.DurationBuckets, Size: cfg.Metrics.SizeBuckets})
+}
+
+// NewMiddleware builds the middleware def describes, as a route would get
+// it from Config.Middlewares, and checks def first. Its state, such as rate
+// limit buckets, is its own and kept in memory.
+func NewMiddleware(def MiddlewareConfig) (Middleware, error) {
+	return gateway.NewMiddleware(def)
+}
+
+// NewLoadBalancer spreads requests over backends, round robin until
+// SetAlgorithm picks another algorithm. Every backend starts healthy;
+// report health checks with SetBackendHealth or ReportHealth.
+func NewLoadBalancer(backends []Backend) *LoadBalancer {
+	return loadbalancer.New(backends)
+}
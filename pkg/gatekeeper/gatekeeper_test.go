@@ -0,0 +1,168 @@
+package gatekeeper_test
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/barisgenc/gatekeeper/pkg/gatekeeper"
+)
+
+func TestEmbeddedGateway(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.URL.Path, r.Header.Get("X-Embedded"))
+	}))
+	defer backend.Close()
+
+	cfg, err := gatekeeper.ParseConfig([]byte(`
+backends:
+  - name: orders
+    url: ` + backend.URL + `
+    health: /health
+routes:
+  - name: orders
+    pathPrefix: /orders
+`))
+	if err != nil {
+		t.Fatalf("Expected the config to parse, got %v", err)
+	}
+
+	gw := gatekeeper.New(cfg)
+	defer gw.Shutdown(context.Background(), nil)
+	gw.Use(middlewareFunc(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("X-Embedded", "yes")
+			next.ServeHTTP(w, r)
+		})
+	}))
+
+	rec := httptest.NewRecorder()
+	gw.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/orders/42", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "/orders/42 yes" {
+		t.Errorf("Expected the backend's 200 \"/orders/42 yes\", got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestValidateBuiltConfig(t *testing.T) {
+	cfg, err := gatekeeper.ParseConfig(nil)
+	if err != nil {
+		t.Fatalf("Expected the defaults to be valid, got %v", err)
+	}
+	cfg.Backends = []gatekeeper.Backend{{Name: "orders", URL: "http://localhost:3001", Weight: 100}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected the config to be valid, got %v", err)
+	}
+
+	cfg.Routes = []gatekeeper.RouteConfig{{Name: "orders", PathPrefix: "/orders", RateLimitCost: -1}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "orders") {
+		t.Errorf("Expected an error about the orders route, got %v", err)
+	}
+}
+
+func TestNewMiddleware(t *testing.T) {
+	limit, err := gatekeeper.NewMiddleware(gatekeeper.MiddlewareConfig{
+		Type:      "rateLimit",
+		RateLimit: &gatekeeper.RateLimitConfig{RequestsPerMinute: 1, BurstSize: 1},
+	})
+	if err != nil {
+		t.Fatalf("Expected the rate limit to build, got %v", err)
+	}
+	handler := limit.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, expected := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != expected {
+			t.Errorf("Request %d: expected %d, got %d", i+1, expected, rec.Code)
+		}
+	}
+
+	_, err = gatekeeper.NewMiddleware(gatekeeper.MiddlewareConfig{Type: "hmac", HMAC: &gatekeeper.HMACConfig{Scheme: "simple"}})
+	if err == nil {
+		t.Error("Expected an hmac middleware without consumers to be refused")
+	}
+}
+
+func TestNewLoadBalancer(t *testing.T) {
+	lb := gatekeeper.NewLoadBalancer([]gatekeeper.Backend{
+		{Name: "a", URL: "http://a:8080", Weight: 1},
+		{Name: "b", URL: "http://b:8080", Weight: 1},
+	})
+	lb.SetBackendHealth("a", false)
+
+	for i := 0; i < 3; i++ {
+		if backend := lb.NextBackend(); backend == nil || backend.Name != "b" {
+			t.Fatalf("Expected only the healthy backend b, got %+v", backend)
+		}
+	}
+}
+
+func TestConfigTypesAliased(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "gatekeeper.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliased := map[string]bool{}
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			if sel, ok := spec.(*ast.TypeSpec).Type.(*ast.SelectorExpr); ok {
+				aliased[sel.X.(*ast.Ident).Name+"."+sel.Sel.Name] = true
+			}
+		}
+	}
+
+	seen := map[reflect.Type]bool{}
+	var walk func(reflect.Type)
+	walk = func(typ reflect.Type) {
+		for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Map {
+			typ = typ.Elem()
+		}
+		if seen[typ] {
+			return
+		}
+		seen[typ] = true
+		if strings.Contains(typ.PkgPath(), "/internal/") && !aliased[typ.String()] {
+			t.Errorf("Expected %s to be aliased in package gatekeeper", typ)
+		}
+		if typ.Kind() == reflect.Struct {
+			for i := 0; i < typ.NumField(); i++ {
+				if typ.Field(i).IsExported() {
+					walk(typ.Field(i).Type)
+				}
+			}
+		}
+	}
+	walk(reflect.TypeOf(gatekeeper.Config{}))
+}
+
+type middlewareFunc func(http.Handler) http.Handler
+
+func (f middlewareFunc) Wrap(next http.Handler) http.Handler {
+	return f(next)
+}
+
+func Example() {
+	cfg, err := gatekeeper.LoadConfig("gateway.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+	gatekeeper.Init(cfg)
+
+	gw := gatekeeper.New(cfg)
+	gw.OnBackendChange(func(change gatekeeper.BackendChange) {
+		log.Printf("backend %s healthy: %v", change.Backend, change.Healthy)
+	})
+	log.Fatal(http.ListenAndServe(":8080", gw.Handler()))
+}
@@ -0,0 +1,288 @@
+// Package gatekeepertest runs a gateway config inside Go tests. The gateway
+// has its full handler chain, but every backend is replaced by an
+// in-memory fake whose answers, latencies and failures the test scripts,
+// and state stores such as Redis are replaced by memory.
+//
+//	gw := gatekeepertest.New(t, gatekeepertest.Config(t, `
+//	backends:
+//	  - name: orders
+//	    url: http://orders.internal
+//	routes:
+//	  - name: orders
+//	    pathPrefix: /orders
+//	`))
+//	gw.Backend("orders").Script(gatekeepertest.Response{Status: 503})
+//	gw.AssertStatus(httptest.NewRequest("GET", "/orders/1", nil), 503)
+//	gw.AssertRoute(httptest.NewRequest("GET", "/orders/1", nil), "orders")
+package gatekeepertest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/internal/contract"
+	"github.com/barisgenc/gatekeeper/internal/gateway"
+	"github.com/barisgenc/gatekeeper/pkg/gatekeeper"
+)
+
+// Config parses a YAML or JSON config, failing the test if it is invalid
+func Config(t testing.TB, data string) *gatekeeper.Config {
+	t.Helper()
+	cfg, err := gatekeeper.ParseConfig([]byte(data))
+	if err != nil {
+		t.Fatalf("gatekeepertest: invalid config: %v", err)
+	}
+	return cfg
+}
+
+// Gateway is a gateway under test. Requests sent with Send and the Assert
+// methods go through its handler in memory.
+type Gateway struct {
+	*gatekeeper.Gateway
+	t       testing.TB
+	handler http.Handler
+
+	mu       sync.Mutex
+	backends map[string]*Backend
+	// reached lists the backends the request being sent reached
+	reached []*Request
+}
+
+// New starts a gateway for cfg with fake backends. It is shut down when the
+// test ends.
+func New(t testing.TB, cfg *gatekeeper.Config) *Gateway {
+	t.Helper()
+	g := &Gateway{t: t, backends: make(map[string]*Backend)}
+	g.Gateway = gateway.NewWithUpstream(contract.Isolate(cfg), func(backend string) http.RoundTripper {
+		return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return g.Backend(backend).roundTrip(g, r)
+		})
+	})
+	g.handler = g.Gateway.Handler()
+	t.Cleanup(func() {
+		g.Gateway.Shutdown(context.Background(), nil)
+	})
+	return g
+}
+
+// Backend returns the fake standing in for the named backend. Until it is
+// scripted it answers every request with an empty 200.
+func (g *Gateway) Backend(name string) *Backend {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.backends[name]
+	if !ok {
+		b = &Backend{name: name, fallback: Response{Status: http.StatusOK}}
+		g.backends[name] = b
+	}
+	return b
+}
+
+// Result is what the gateway answered a request with
+type Result struct {
+	Status int
+	Header http.Header
+	Body   string
+	// Upstream is the request the last backend it reached received, nil
+	// when the gateway answered it itself. Retries and hedges can reach
+	// several.
+	Upstream *Request
+}
+
+// Send sends req through the gateway. Requests are sent one at a time.
+func (g *Gateway) Send(req *http.Request) *Result {
+	g.mu.Lock()
+	g.reached = nil
+	g.mu.Unlock()
+
+	rec := httptest.NewRecorder()
+	g.handler.ServeHTTP(rec, req)
+
+	result := &Result{Status: rec.Code, Header: rec.Header(), Body: rec.Body.String()}
+	g.mu.Lock()
+	if len(g.reached) > 0 {
+		result.Upstream = g.reached[len(g.reached)-1]
+	}
+	g.mu.Unlock()
+	return result
+}
+
+// AssertStatus sends req and fails the test unless the gateway answered
+// with status
+func (g *Gateway) AssertStatus(req *http.Request, status int) *Result {
+	g.t.Helper()
+	result := g.Send(req)
+	if result.Status != status {
+		g.t.Errorf("%s %s: expected status %d, got %d", req.Method, req.URL.Path, status, result.Status)
+	}
+	return result
+}
+
+// AssertRoute sends req and fails the test unless it was proxied to the
+// named backend, or with backend "" unless the gateway answered it itself
+func (g *Gateway) AssertRoute(req *http.Request, backend string) *Result {
+	g.t.Helper()
+	result := g.Send(req)
+	switch {
+	case backend == "" && result.Upstream != nil:
+		g.t.Errorf("%s %s: expected no backend, got %s", req.Method, req.URL.Path, result.Upstream.Backend)
+	case backend != "" && result.Upstream == nil:
+		g.t.Errorf("%s %s: expected backend %s, got none (status %d)", req.Method, req.URL.Path, backend, result.Status)
+	case backend != "" && result.Upstream.Backend != backend:
+		g.t.Errorf("%s %s: expected backend %s, got %s", req.Method, req.URL.Path, backend, result.Upstream.Backend)
+	}
+	return result
+}
+
+// AssertLimit sends allowed requests made by newRequest, which must all
+// get through, then one more, which must be rejected with a 429
+func (g *Gateway) AssertLimit(allowed int, newRequest func() *http.Request) {
+	g.t.Helper()
+	for i := 0; i < allowed; i++ {
+		req := newRequest()
+		if result := g.Send(req); result.Status == http.StatusTooManyRequests {
+			g.t.Errorf("%s %s: expected request %d of %d to be allowed, got 429", req.Method, req.URL.Path, i+1, allowed)
+			return
+		}
+	}
+	req := newRequest()
+	if result := g.Send(req); result.Status != http.StatusTooManyRequests {
+		g.t.Errorf("%s %s: expected request %d to be rate limited, got %d", req.Method, req.URL.Path, allowed+1, result.Status)
+	}
+}
+
+// Response is a scripted backend answer. After Delay, or when the request
+// is cancelled first, the backend fails with Err if set, and otherwise
+// answers with Status (default 200), Header and Body.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   string
+	Delay  time.Duration
+	Err    error
+}
+
+// Request is what a fake backend received
+type Request struct {
+	Backend string
+	Method  string
+	Path    string
+	Query   string
+	Header  http.Header
+	Body    string
+}
+
+// Backend is a fake backend. It answers with the scripted responses in
+// order, then with the one set by Respond.
+type Backend struct {
+	name string
+
+	mu       sync.Mutex
+	script   []Response
+	fallback Response
+	requests []*Request
+}
+
+// Respond sets the answer to every request once the script has run out
+func (b *Backend) Respond(resp Response) *Backend {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fallback = resp
+	return b
+}
+
+// Script queues answers for the next requests, one each
+func (b *Backend) Script(responses ...Response) *Backend {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.script = append(b.script, responses...)
+	return b
+}
+
+// Requests returns what the backend has received, in order
+func (b *Backend) Requests() []*Request {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*Request(nil), b.requests...)
+}
+
+// Count returns how many requests the backend has received
+func (b *Backend) Count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.requests)
+}
+
+func (b *Backend) roundTrip(g *Gateway, r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+		r.Body.Close()
+	}
+	received := &Request{
+		Backend: b.name,
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Header:  r.Header.Clone(),
+		Body:    string(body),
+	}
+
+	b.mu.Lock()
+	b.requests = append(b.requests, received)
+	resp := b.fallback
+	if len(b.script) > 0 {
+		resp, b.script = b.script[0], b.script[1:]
+	}
+	b.mu.Unlock()
+
+	g.mu.Lock()
+	g.reached = append(g.reached, received)
+	g.mu.Unlock()
+
+	if resp.Delay > 0 {
+		timer := time.NewTimer(resp.Delay)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			return nil, r.Context().Err()
+		}
+	}
+	if resp.Err != nil {
+		return nil, fmt.Errorf("gatekeepertest: backend %s: %w", b.name, resp.Err)
+	}
+
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := resp.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(resp.Body))),
+		ContentLength: int64(len(resp.Body)),
+		Request:       r,
+	}, nil
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
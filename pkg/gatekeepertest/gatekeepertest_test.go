@@ -0,0 +1,76 @@
+package gatekeepertest_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barisgenc/gatekeeper/pkg/gatekeepertest"
+)
+
+const ordersConfig = `
+backends:
+  - name: orders
+    url: http://orders.internal
+rateLimit:
+  requestsPerMinute: 60
+  burstSize: 3
+routes:
+  - name: orders
+    pathPrefix: /orders
+  - name: legacy
+    path: /old
+    redirect:
+      to: /orders
+`
+
+func TestGateway(t *testing.T) {
+	gw := gatekeepertest.New(t, gatekeepertest.Config(t, ordersConfig))
+	orders := gw.Backend("orders")
+	orders.Script(
+		gatekeepertest.Response{Status: http.StatusServiceUnavailable},
+		gatekeepertest.Response{Err: errors.New("connection reset"), Delay: 10 * time.Millisecond},
+	)
+
+	gw.AssertStatus(httptest.NewRequest("POST", "/orders", strings.NewReader(`{"id":1}`)), http.StatusServiceUnavailable)
+	if got := orders.Requests(); len(got) != 1 || got[0].Method != "POST" || got[0].Body != `{"id":1}` {
+		t.Errorf("Expected the backend to receive the POST, got %+v", got)
+	}
+
+	result := gw.AssertStatus(httptest.NewRequest("GET", "/orders/1", nil), http.StatusBadGateway)
+	if result.Upstream == nil || result.Upstream.Path != "/orders/1" {
+		t.Errorf("Expected the failed request to have reached the backend, got %+v", result.Upstream)
+	}
+
+	gw.AssertRoute(httptest.NewRequest("GET", "/old", nil), "")
+	if orders.Count() != 2 {
+		t.Errorf("Expected the redirect not to reach the backend, got %d requests", orders.Count())
+	}
+}
+
+func TestGatewayRespond(t *testing.T) {
+	gw := gatekeepertest.New(t, gatekeepertest.Config(t, ordersConfig))
+	gw.Backend("orders").Respond(gatekeepertest.Response{
+		Status: http.StatusCreated,
+		Header: http.Header{"X-Order": {"42"}},
+		Body:   "created",
+	})
+
+	result := gw.AssertRoute(httptest.NewRequest("GET", "/orders?page=2", nil), "orders")
+	if result.Status != http.StatusCreated || result.Body != "created" || result.Header.Get("X-Order") != "42" {
+		t.Errorf("Expected the backend's answer, got %d %q %v", result.Status, result.Body, result.Header)
+	}
+	if result.Upstream.Query != "page=2" {
+		t.Errorf("Expected the query to reach the backend, got %q", result.Upstream.Query)
+	}
+}
+
+func TestGatewayRateLimit(t *testing.T) {
+	gw := gatekeepertest.New(t, gatekeepertest.Config(t, ordersConfig))
+	gw.AssertLimit(3, func() *http.Request {
+		return httptest.NewRequest("GET", "/orders", nil)
+	})
+}
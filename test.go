@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/barisgenc/gatekeeper/internal/contract"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+)
+
+// runTests implements "gatekeeper test": it loads the configuration the
+// server would run with and runs its tests section against it in memory
+func runTests(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	run := fs.String("run", "", "only run tests whose name matches this regular expression")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gatekeeper test [-run regexp]")
+		fmt.Fprintln(fs.Output(), "Reads the config from GATEKEEPER_CONFIG (default config.yaml).")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	filter, err := regexp.Compile(*run)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -run pattern: %v\n", err)
+		return 2
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 1
+	}
+
+	// Request logs would bury the results
+	logger.Init("error")
+
+	tests := cfg.Tests[:0:0]
+	for _, test := range cfg.Tests {
+		if filter.MatchString(test.Name) {
+			tests = append(tests, test)
+		}
+	}
+	if len(tests) == 0 {
+		fmt.Println("No tests to run")
+		return 0
+	}
+	cfg.Tests = tests
+
+	failed := 0
+	for _, result := range contract.Run(cfg) {
+		if result.Passed {
+			fmt.Printf("PASS %s\n", result.Test)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL %s\n", result.Test)
+		for _, failure := range result.Failures {
+			fmt.Printf("    %s\n", failure)
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d tests failed\n", failed, len(tests))
+		return 1
+	}
+	fmt.Printf("All %d tests passed\n", len(tests))
+	return 0
+}
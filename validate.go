@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/barisgenc/gatekeeper/internal/config"
+	"github.com/barisgenc/gatekeeper/internal/lint"
+	"github.com/barisgenc/gatekeeper/internal/logger"
+)
+
+// runValidate implements "gatekeeper validate": it checks the configuration
+// the server would run with, reporting every problem with its line, and
+// then checks it against the policy
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	configFile := fs.String("c", "", "config file (default GATEKEEPER_CONFIG or config.yaml)")
+	policyFile := fs.String("policy", "", "policy file (default lint.policyFile from the config)")
+	environment := fs.String("env", "", "environment to check for (default lint.environment from the config)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gatekeeper validate [-c file] [-policy file] [-env name]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	path := *configFile
+	if path == "" {
+		path = config.Path()
+	}
+	problems, err := config.Check(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read configuration: %v\n", err)
+		return 1
+	}
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, p)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 1
+	}
+
+	if *policyFile == "" {
+		*policyFile = cfg.Lint.PolicyFile
+	}
+	if *environment == "" {
+		*environment = cfg.Lint.Environment
+	}
+	if *policyFile == "" {
+		fmt.Println("Configuration is valid; no policy to check")
+		return 0
+	}
+
+	policy, err := lint.LoadPolicy(*policyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid policy: %v\n", err)
+		return 1
+	}
+
+	violations := policy.Check(cfg, *environment)
+	for _, v := range violations {
+		fmt.Println(v)
+	}
+	if lint.HasErrors(violations) {
+		return 1
+	}
+	fmt.Printf("Configuration is valid and passes %d policy rules\n", len(policy.Rules))
+	return 0
+}
+
+// enforcePolicy applies the config's own lint settings, logging warnings
+// and failing on errors
+func enforcePolicy(cfg *config.Config) error {
+	violations, err := lint.Enforce(cfg)
+	for _, v := range violations {
+		if v.Severity == "warning" {
+			logger.Warn("Policy: %s", v)
+		}
+	}
+	return err
+}